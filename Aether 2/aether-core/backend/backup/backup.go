@@ -0,0 +1,292 @@
+// Backend > Backup
+// This package backs up the database and the state of the node on a schedule, so that the operator can recover from a damaged disk. The backups are archives like the ones -export-node writes, either full, or incremental with only the entities that arrived since the backup before. Each one is checked as it is written, and its hash is kept in the index of the backups, so that a backup damaged afterwards is noticed before it is restored from.
+
+package backup
+
+import (
+	"aether-core/backend/migration"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// indexFile lists the backups in the destination, oldest first.
+const indexFile = "backups.json"
+
+// Snapshot is a single backup.
+type Snapshot struct {
+	Name     string         `json:"name"` // The file of the archive in the destination.
+	Full     bool           `json:"full"`
+	Base     string         `json:"base,omitempty"` // The full backup an incremental one builds on.
+	Since    int64          `json:"since"`
+	Until    int64          `json:"until"`
+	Size     int64          `json:"size"`
+	Sha256   string         `json:"sha256"`
+	Entities map[string]int `json:"entities"`
+}
+
+// Problem is a backup that failed the verification.
+type Problem struct {
+	Name    string `json:"name"`
+	Details string `json:"details"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Name, p.Details)
+}
+
+var lock sync.Mutex
+
+// Destination gives the folder the backups are kept in.
+func Destination() string {
+	if len(globals.BackupDestination) > 0 {
+		return globals.BackupDestination
+	}
+	return fmt.Sprint(globals.UserDirectory, "/backups")
+}
+
+func readIndex() ([]Snapshot, error) {
+	var snapshots []Snapshot
+	data, err := ioutil.ReadFile(filepath.Join(Destination(), indexFile))
+	if err != nil && os.IsNotExist(err) {
+		return snapshots, nil
+	} else if err != nil {
+		return snapshots, err
+	}
+	err2 := json.Unmarshal(data, &snapshots)
+	if err2 != nil {
+		return snapshots, errors.New(fmt.Sprintf("The index of the backups could not be read. Error: %s", err2))
+	}
+	return snapshots, nil
+}
+
+func writeIndex(snapshots []Snapshot) error {
+	data, err := json.MarshalIndent(snapshots, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(Destination(), fmt.Sprint(indexFile, ".tmp"))
+	err2 := ioutil.WriteFile(tmp, data, 0600)
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The index of the backups could not be written. Error: %s", err2))
+	}
+	return os.Rename(tmp, filepath.Join(Destination(), indexFile))
+}
+
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err2 := io.Copy(h, f)
+	if err2 != nil {
+		return "", 0, err2
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// nextIsFull decides whether the next backup is a full one: the first one is, and after that, every BackupFullEvery-th.
+func nextIsFull(snapshots []Snapshot) bool {
+	incrementals := 0
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].Full {
+			return incrementals+1 >= globals.BackupFullEvery
+		}
+		incrementals++
+	}
+	return true
+}
+
+// rotate gives the backups to keep and the ones to delete, keeping the last BackupsKept full backups and the incremental ones after them.
+func rotate(snapshots []Snapshot) ([]Snapshot, []Snapshot) {
+	fulls := 0
+	cut := 0
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if !snapshots[i].Full {
+			continue
+		}
+		fulls++
+		if fulls == globals.BackupsKept {
+			cut = i
+			break
+		}
+	}
+	return snapshots[cut:], snapshots[:cut]
+}
+
+// Run takes a backup now, full or incremental as its turn is, checks it, and deletes the backups that are no longer kept.
+func Run() (Snapshot, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	var s Snapshot
+	err := os.MkdirAll(Destination(), 0700)
+	if err != nil {
+		return s, errors.New(fmt.Sprintf("The backup destination could not be created. Destination: %s, Error: %s", Destination(), err))
+	}
+	snapshots, err2 := readIndex()
+	if err2 != nil {
+		return s, err2
+	}
+	s.Full = nextIsFull(snapshots)
+	if !s.Full {
+		last := snapshots[len(snapshots)-1]
+		s.Base = last.Base
+		if last.Full {
+			s.Base = last.Name
+		}
+		// A second of overlap, for the entities that arrived in the same second as the last backup was taken, after it read them. Importing one twice does no harm.
+		s.Since = last.Until - 1
+	}
+	kind := "incremental"
+	if s.Full {
+		kind = "full"
+	}
+	s.Name = fmt.Sprint("backup_", clock.Unix(), "_", kind, ".tar.gz")
+	for n := 2; fileExists(filepath.Join(Destination(), s.Name)); n++ {
+		s.Name = fmt.Sprint("backup_", clock.Unix(), "_", kind, "_", n, ".tar.gz")
+	}
+	// It is written under a temporary name, so that a backup cut short is never taken for a whole one.
+	path := filepath.Join(Destination(), s.Name)
+	tmp := fmt.Sprint(path, ".tmp")
+	manifest, err3 := migration.ExportSince(tmp, false, s.Since)
+	if err3 != nil {
+		os.Remove(tmp)
+		return s, errors.New(fmt.Sprintf("The backup could not be written. Error: %s", err3))
+	}
+	_, err4 := migration.CheckArchive(tmp)
+	if err4 != nil {
+		os.Remove(tmp)
+		return s, errors.New(fmt.Sprintf("The backup was written, but it doesn't read back. Error: %s", err4))
+	}
+	s.Until = manifest.Until
+	s.Entities = manifest.EntityCounts
+	s.Sha256, s.Size, err = hashFile(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return s, err
+	}
+	err5 := os.Rename(tmp, path)
+	if err5 != nil {
+		os.Remove(tmp)
+		return s, err5
+	}
+	kept, dropped := rotate(append(snapshots, s))
+	err6 := writeIndex(kept)
+	if err6 != nil {
+		return s, err6
+	}
+	for _, d := range dropped {
+		err7 := os.Remove(filepath.Join(Destination(), d.Name))
+		if err7 != nil && !os.IsNotExist(err7) {
+			logging.Log(1, fmt.Sprintf("An old backup could not be deleted. Backup: %s, Error: %s", d.Name, err7))
+		}
+	}
+	logging.Log(1, fmt.Sprintf("The node is backed up. Backup: %s, Entities: %v, Size: %d", s.Name, s.Entities, s.Size))
+	return s, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// verify checks that the backup is there, that it is the same as it was when it was written, and that it reads through.
+func verify(s Snapshot) error {
+	path := filepath.Join(Destination(), s.Name)
+	sum, size, err := hashFile(path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("The backup could not be read. Error: %s", err))
+	}
+	if size != s.Size || sum != s.Sha256 {
+		return errors.New(fmt.Sprintf("The backup has changed since it was written. Size: %d, Expected: %d", size, s.Size))
+	}
+	_, err2 := migration.CheckArchive(path)
+	return err2
+}
+
+// List gives the backups in the destination, oldest first.
+func List() ([]Snapshot, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	snapshots, err := readIndex()
+	if snapshots == nil {
+		snapshots = []Snapshot{}
+	}
+	return snapshots, err
+}
+
+// Verify checks every backup in the destination, and gives the ones that fail.
+func Verify() ([]Problem, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	var problems []Problem
+	snapshots, err := readIndex()
+	if err != nil {
+		return problems, err
+	}
+	for _, s := range snapshots {
+		if err2 := verify(s); err2 != nil {
+			problems = append(problems, Problem{Name: s.Name, Details: err2.Error()})
+		}
+	}
+	return problems, nil
+}
+
+// chain gives the backups that have to be restored, in order, to get to the named one: the full backup it builds on, and the incremental ones up to it. "latest" is the last backup.
+func chain(snapshots []Snapshot, name string) ([]Snapshot, error) {
+	target := -1
+	for i, _ := range snapshots {
+		if snapshots[i].Name == name || (name == "latest" && i == len(snapshots)-1) {
+			target = i
+		}
+	}
+	if target == -1 {
+		return nil, errors.New(fmt.Sprintf("There is no such backup. Backup: %s", name))
+	}
+	start := target
+	for start >= 0 && !snapshots[start].Full {
+		start--
+	}
+	if start == -1 {
+		return nil, errors.New(fmt.Sprintf("The full backup the backup builds on is missing. Backup: %s", snapshots[target].Name))
+	}
+	return snapshots[start : target+1], nil
+}
+
+// Restore restores the node from the named backup, or from the last one if the name is "latest". The full backup it builds on and the incremental ones up to it are all checked before anything is restored, and then imported in order. The database has to exist already.
+func Restore(name string) ([]Snapshot, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	snapshots, err := readIndex()
+	if err != nil {
+		return nil, err
+	}
+	restore, err2 := chain(snapshots, name)
+	if err2 != nil {
+		return nil, err2
+	}
+	for _, s := range restore {
+		if err3 := verify(s); err3 != nil {
+			return nil, errors.New(fmt.Sprintf("A backup needed for the restore is damaged, so nothing is restored. Backup: %s, Error: %s", s.Name, err3))
+		}
+	}
+	for _, s := range restore {
+		err4 := migration.Import(filepath.Join(Destination(), s.Name))
+		if err4 != nil {
+			return nil, errors.New(fmt.Sprintf("The backup could not be restored. Backup: %s, Error: %s", s.Name, err4))
+		}
+	}
+	return restore, nil
+}
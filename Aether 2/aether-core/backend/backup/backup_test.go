@@ -0,0 +1,125 @@
+// This test is in the package itself rather than in backup_test, since the rotation and the chains of the backups are decided by functions that are not exported. Taking a backup needs the database, so the archives here are written by hand.
+
+package backup
+
+import (
+	"aether-core/services/globals"
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func snapshots(kinds string) []Snapshot {
+	var result []Snapshot
+	for i, k := range kinds {
+		result = append(result, Snapshot{Name: string(rune('a' + i)), Full: k == 'F'})
+	}
+	return result
+}
+
+func names(snapshots []Snapshot) string {
+	var n string
+	for _, s := range snapshots {
+		n += s.Name
+	}
+	return n
+}
+
+// writeArchive writes an archive with only a manifest into the destination, and gives its entry in the index.
+func writeArchive(t *testing.T, name string, full bool) Snapshot {
+	path := filepath.Join(Destination(), name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	manifest := []byte(`{"version": 1}`)
+	tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0600, Size: int64(len(manifest))})
+	tw.Write(manifest)
+	tw.Close()
+	gz.Close()
+	f.Close()
+	sum, size, err2 := hashFile(path)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	return Snapshot{Name: name, Full: full, Sha256: sum, Size: size}
+}
+
+func TestRotation_Success(t *testing.T) {
+	globals.SetGlobals()
+	globals.BackupFullEvery = 3
+	globals.BackupsKept = 2
+	if !nextIsFull(nil) || nextIsFull(snapshots("F")) || nextIsFull(snapshots("FI")) || !nextIsFull(snapshots("FII")) {
+		t.Errorf("Every third backup should be full.")
+	}
+	kept, dropped := rotate(snapshots("FIIFIIFI"))
+	if names(kept) != "defgh" || names(dropped) != "abc" {
+		t.Errorf("The last two full backups and the ones after them should be kept. Kept: %s, Dropped: %s", names(kept), names(dropped))
+	}
+	kept, dropped = rotate(snapshots("FII"))
+	if names(kept) != "abc" || len(dropped) != 0 {
+		t.Errorf("Nothing should be dropped before there are enough full backups.")
+	}
+	c, err := chain(snapshots("FIIFII"), "e")
+	if err != nil || names(c) != "de" {
+		t.Errorf("The restore should start from the full backup before the one asked for. Chain: %s, Error: %v", names(c), err)
+	}
+	c, err = chain(snapshots("FIIFII"), "latest")
+	if err != nil || names(c) != "def" {
+		t.Errorf("The latest backup should be the last one. Chain: %s, Error: %v", names(c), err)
+	}
+}
+
+func TestRotation_Fail(t *testing.T) {
+	if _, err := chain(snapshots("FII"), "x"); err == nil {
+		t.Errorf("A backup that doesn't exist should not be restored.")
+	}
+	if _, err := chain(snapshots("II"), "b"); err == nil {
+		t.Errorf("An incremental backup without its full backup should not be restored.")
+	}
+}
+
+func TestVerify_Success(t *testing.T) {
+	globals.SetGlobals()
+	dir, _ := ioutil.TempDir("", "backup")
+	defer os.RemoveAll(dir)
+	globals.BackupDestination = dir
+	err := writeIndex([]Snapshot{writeArchive(t, "full.tar.gz", true), writeArchive(t, "incremental.tar.gz", false)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err2 := Verify()
+	if err2 != nil || len(problems) != 0 {
+		t.Errorf("Intact backups should pass. Problems: %v, Error: %v", problems, err2)
+	}
+}
+
+func TestVerify_Fail(t *testing.T) {
+	globals.SetGlobals()
+	dir, _ := ioutil.TempDir("", "backup")
+	defer os.RemoveAll(dir)
+	globals.BackupDestination = dir
+	full := writeArchive(t, "full.tar.gz", true)
+	incremental := writeArchive(t, "incremental.tar.gz", false)
+	writeIndex([]Snapshot{full, incremental})
+	// The incremental one is damaged on disk.
+	data, _ := ioutil.ReadFile(filepath.Join(dir, incremental.Name))
+	data[len(data)-5] ^= 0xff
+	ioutil.WriteFile(filepath.Join(dir, incremental.Name), data, 0600)
+	problems, err := Verify()
+	if err != nil || len(problems) != 1 || problems[0].Name != incremental.Name {
+		t.Errorf("The damaged backup should be caught. Problems: %v, Error: %v", problems, err)
+	}
+	if _, err2 := Restore("latest"); err2 == nil {
+		t.Errorf("Nothing should be restored from a chain with a damaged backup.")
+	}
+	os.Remove(filepath.Join(dir, full.Name))
+	if problems, _ := Verify(); len(problems) != 2 {
+		t.Errorf("The missing backup should be caught too. Problems: %v", problems)
+	}
+}
@@ -0,0 +1,284 @@
+// Backend > Bundle
+// This package exchanges content between nodes without any network connection. A node exports the entities of a time range into a bundle, the bundle is carried to another node (on a USB stick, for example), and that node imports it. This is store-and-forward sync for the places where the network is censored or not there at all.
+// The bundle is signed by the node that exported it, but that only protects it from damage on the way. Every entity in it is verified on import just like the entities that arrive from the remotes, so a bundle from an unknown source is as safe as a sync with an unknown node.
+
+package bundle
+
+import (
+	"aether-core/backend/ranking"
+	"aether-core/backend/replytree"
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/canonical"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"aether-core/services/membership"
+	"aether-core/services/signaturing"
+	"aether-core/services/verify"
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// bundleVersion is increased when the layout of the bundle changes in a way older versions can't read.
+const bundleVersion = 1
+
+// entityTypes are the types that travel in bundles. Addresses are left out, since the addresses of a network the bundle is carried around are not of much use.
+var entityTypes = []string{"boards", "threads", "posts", "votes", "keys", "truststates", "tombstones"}
+
+// Manifest describes the bundle. It carries the hash of every file in the bundle, so that the signature over the manifest covers the whole bundle.
+type Manifest struct {
+	Version    int               `json:"version"`
+	NodeId     string            `json:"node_id"`
+	NetworkId  string            `json:"network_id,omitempty"`
+	Signer     string            `json:"signer"` // Public key of the node that exported the bundle.
+	Created    int64             `json:"created"`
+	StartsFrom api.Timestamp     `json:"starts_from"`
+	EndsAt     api.Timestamp     `json:"ends_at"`
+	FileHashes map[string]string `json:"file_hashes"`
+}
+
+func hashOf(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func addFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: clock.Now()}
+	err := tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err2 := tw.Write(data)
+	return err2
+}
+
+// Export writes the entities that arrived at this node within the time range into a bundle. An end of 0 means now.
+func Export(bundlePath string, start api.Timestamp, end api.Timestamp) error {
+	if end == 0 {
+		end = api.Timestamp(clock.Unix())
+	}
+	if start >= end {
+		return errors.New(fmt.Sprintf("The time range of the bundle is empty. Start: %d, End: %d", start, end))
+	}
+	manifest := Manifest{
+		Version:    bundleVersion,
+		NodeId:     globals.NodeId,
+		NetworkId:  globals.NetworkId,
+		Signer:     globals.MarshaledPubKey,
+		Created:    clock.Unix(),
+		StartsFrom: start,
+		EndsAt:     end,
+		FileHashes: make(map[string]string),
+	}
+	files := make(map[string][]byte)
+	var names []string
+	for _, entityType := range entityTypes {
+		resp, err := persistence.ReadInRange(entityType, start, end)
+		if err != nil {
+			return errors.New(fmt.Sprintf("The entities could not be read. Entity type: %s, Error: %s", entityType, err))
+		}
+		data, err2 := json.Marshal(resp)
+		if err2 != nil {
+			return err2
+		}
+		name := fmt.Sprint("entities/", entityType, ".json")
+		files[name] = data
+		names = append(names, name)
+		manifest.FileHashes[name] = hashOf(data)
+	}
+	err := writeBundle(bundlePath, manifest, files, names)
+	if err != nil {
+		return err
+	}
+	logging.Log(1, fmt.Sprintf("The bundle is exported to %s. Time range: %d-%d", bundlePath, start, end))
+	return nil
+}
+
+// writeBundle signs the manifest with the key of this node, and writes it into the bundle with the files, in the order of the names.
+func writeBundle(bundlePath string, manifest Manifest, files map[string][]byte, names []string) error {
+	manifestJson, err := canonical.Encode(manifest)
+	if err != nil {
+		return err
+	}
+	sig, err2 := signaturing.Sign(string(manifestJson), globals.KeyPair)
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The bundle could not be signed. Error: %s", err2))
+	}
+	f, err3 := os.Create(bundlePath)
+	if err3 != nil {
+		return errors.New(fmt.Sprintf("The bundle could not be created. Error: %s", err3))
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	defer gz.Close()
+	tw := tar.NewWriter(gz)
+	defer tw.Close()
+	// The manifest goes first, so that the importer knows what to expect before it reads anything else.
+	err4 := addFile(tw, "manifest.json", manifestJson)
+	if err4 != nil {
+		return err4
+	}
+	err5 := addFile(tw, "manifest.sig", []byte(sig))
+	if err5 != nil {
+		return err5
+	}
+	for _, name := range names {
+		err6 := addFile(tw, name, files[name])
+		if err6 != nil {
+			return err6
+		}
+	}
+	return nil
+}
+
+// readBundle reads every file in the bundle.
+func readBundle(bundlePath string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return files, errors.New(fmt.Sprintf("The bundle could not be opened. Error: %s", err))
+	}
+	defer f.Close()
+	gz, err2 := gzip.NewReader(f)
+	if err2 != nil {
+		return files, errors.New(fmt.Sprintf("The bundle is not a valid gzip file. Error: %s", err2))
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err3 := tr.Next()
+		if err3 == io.EOF {
+			break
+		}
+		if err3 != nil {
+			return files, errors.New(fmt.Sprintf("The bundle could not be read. Error: %s", err3))
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err4 := ioutil.ReadAll(tr)
+		if err4 != nil {
+			return files, err4
+		}
+		files[hdr.Name] = data
+	}
+	return files, nil
+}
+
+// checkManifest verifies the signature over the manifest, and the files against their hashes in it.
+func checkManifest(files map[string][]byte) (Manifest, error) {
+	var manifest Manifest
+	manifestJson, ok := files["manifest.json"]
+	if !ok {
+		return manifest, errors.New("The bundle has no manifest.")
+	}
+	sig, ok2 := files["manifest.sig"]
+	if !ok2 {
+		return manifest, errors.New("The bundle is not signed.")
+	}
+	err := json.Unmarshal(manifestJson, &manifest)
+	if err != nil {
+		return manifest, errors.New(fmt.Sprintf("The manifest of the bundle could not be read. Error: %s", err))
+	}
+	if manifest.Version > bundleVersion {
+		return manifest, errors.New(fmt.Sprintf("The bundle was created by a newer version of the app. Bundle version: %d", manifest.Version))
+	}
+	if !signaturing.Verify(string(manifestJson), string(sig), manifest.Signer) {
+		return manifest, errors.New("The signature of the bundle is invalid. The bundle is damaged or it was changed after it was signed.")
+	}
+	err2 := membership.CheckNetwork(manifest.NetworkId)
+	if err2 != nil {
+		return manifest, err2
+	}
+	for name, expected := range manifest.FileHashes {
+		data, ok3 := files[name]
+		if !ok3 {
+			return manifest, errors.New(fmt.Sprintf("A file listed in the manifest of the bundle is missing. File: %s", name))
+		}
+		if hashOf(data) != expected {
+			return manifest, errors.New(fmt.Sprintf("A file in the bundle does not match its hash in the manifest. File: %s", name))
+		}
+	}
+	return manifest, nil
+}
+
+// openBundle reads the bundle, and gives its files only if they are the ones its signer exported.
+func openBundle(bundlePath string) (Manifest, map[string][]byte, error) {
+	files, err := readBundle(bundlePath)
+	if err != nil {
+		return Manifest{}, files, err
+	}
+	manifest, err2 := checkManifest(files)
+	return manifest, files, err2
+}
+
+func moveEntitiesToInterfacePack(r *api.Response) []interface{} {
+	var carrier []interface{}
+	for i, _ := range r.Boards {
+		carrier = append(carrier, r.Boards[i])
+	}
+	for i, _ := range r.Threads {
+		carrier = append(carrier, r.Threads[i])
+	}
+	for i, _ := range r.Posts {
+		carrier = append(carrier, r.Posts[i])
+	}
+	for i, _ := range r.Votes {
+		carrier = append(carrier, r.Votes[i])
+	}
+	for i, _ := range r.Keys {
+		carrier = append(carrier, r.Keys[i])
+	}
+	for i, _ := range r.Truststates {
+		carrier = append(carrier, r.Truststates[i])
+	}
+	for i, _ := range r.Tombstones {
+		carrier = append(carrier, r.Tombstones[i])
+	}
+	return carrier
+}
+
+// Import verifies the bundle and commits the entities in it that pass verification. Keys are committed first, so that the entities of the other types can find the keys they are signed with.
+func Import(bundlePath string) error {
+	manifest, files, err := openBundle(bundlePath)
+	if err != nil {
+		return err
+	}
+	imported := 0
+	for _, entityType := range []string{"keys", "boards", "threads", "posts", "votes", "truststates", "tombstones"} {
+		name := fmt.Sprint("entities/", entityType, ".json")
+		if _, listed := manifest.FileHashes[name]; !listed {
+			// Files that are not in the manifest are not covered by the signature.
+			continue
+		}
+		var resp api.Response
+		err3 := json.Unmarshal(files[name], &resp)
+		if err3 != nil {
+			return errors.New(fmt.Sprintf("The entities in the bundle could not be read. File: %s, Error: %s", name, err3))
+		}
+		resp = api.FilterByTextPolicy(api.FilterByPolicy(verify.FilterByMinPoW(resp)))
+		resp = verify.VerifyResponse(resp)
+		pack := moveEntitiesToInterfacePack(&resp)
+		if len(pack) == 0 {
+			continue
+		}
+		err4 := persistence.BatchInsertFrom(pack, persistence.Source{Node: api.Fingerprint(manifest.NodeId), Via: "bundle"})
+		if err4 != nil {
+			return errors.New(fmt.Sprintf("The entities in the bundle could not be committed. File: %s, Error: %s", name, err4))
+		}
+		ranking.Update(&resp)
+		replytree.Update(&resp)
+		imported += len(pack)
+	}
+	logging.Log(1, fmt.Sprintf("The bundle is imported from %s. Exported by: %s, Time range: %d-%d, Entities imported: %d", bundlePath, manifest.NodeId, manifest.StartsFrom, manifest.EndsAt, imported))
+	return nil
+}
@@ -0,0 +1,105 @@
+// This test is in the package itself rather than in bundle_test, since the bundles are written and checked by functions that are not exported, and the exports and imports themselves need the database.
+
+package bundle
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/signaturing"
+	"archive/tar"
+	"compress/gzip"
+	"crypto/elliptic"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+var tempDir string
+
+func TestMain(m *testing.M) {
+	globals.SetGlobals()
+	tempDir, _ = ioutil.TempDir("", "bundle")
+	exitVal := m.Run()
+	os.RemoveAll(tempDir)
+	os.Exit(exitVal)
+}
+
+// testBundle writes a bundle with a file of posts, signed by the key of this node, and gives its path.
+func testBundle(t *testing.T, manifest Manifest) (string, map[string][]byte) {
+	files := map[string][]byte{"entities/posts.json": []byte(`{"posts":[{"fingerprint":"a"}]}`)}
+	manifest.Version = bundleVersion
+	manifest.FileHashes = map[string]string{"entities/posts.json": hashOf(files["entities/posts.json"])}
+	path := filepath.Join(tempDir, t.Name()+".tar.gz")
+	err := writeBundle(path, manifest, files, []string{"entities/posts.json"})
+	if err != nil {
+		t.Fatalf("The bundle could not be written. Error: %s", err)
+	}
+	return path, files
+}
+
+// rewriteBundle writes the files back into the bundle as they are given, without signing anything again.
+func rewriteBundle(t *testing.T, path string, files map[string][]byte) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	defer gz.Close()
+	tw := tar.NewWriter(gz)
+	defer tw.Close()
+	for name, data := range files {
+		err2 := addFile(tw, name, data)
+		if err2 != nil {
+			t.Fatal(err2)
+		}
+	}
+}
+
+// Tests
+
+func TestOpenBundle_Success(t *testing.T) {
+	path, files := testBundle(t, Manifest{NodeId: "exporter", Signer: globals.MarshaledPubKey, StartsFrom: 1, EndsAt: 2})
+	manifest, opened, err := openBundle(path)
+	if err != nil {
+		t.Fatalf("The bundle should have opened as it was written. Error: %s", err)
+	}
+	if manifest.NodeId != "exporter" || manifest.StartsFrom != 1 || manifest.EndsAt != 2 {
+		t.Errorf("The manifest did not come back as it was written. Manifest: %#v", manifest)
+	}
+	if string(opened["entities/posts.json"]) != string(files["entities/posts.json"]) {
+		t.Errorf("The entities did not come back as they were written. Entities: %s", opened["entities/posts.json"])
+	}
+}
+
+func TestOpenBundle_Fail_Tampered(t *testing.T) {
+	path, _ := testBundle(t, Manifest{NodeId: "exporter", Signer: globals.MarshaledPubKey})
+	files, err := readBundle(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["entities/posts.json"] = []byte(`{"posts":[{"fingerprint":"b"}]}`)
+	rewriteBundle(t, path, files)
+	if _, _, err2 := openBundle(path); err2 == nil {
+		t.Errorf("A bundle whose entities were changed after it was signed was accepted.")
+	}
+	// Changing the hash in the manifest to match breaks the signature instead.
+	files["manifest.json"] = []byte(`{"version":1,"node_id":"exporter","signer":"` + globals.MarshaledPubKey + `","created":0,"starts_from":0,"ends_at":0,"file_hashes":{"entities/posts.json":"` + hashOf(files["entities/posts.json"]) + `"}}`)
+	rewriteBundle(t, path, files)
+	if _, _, err3 := openBundle(path); err3 == nil {
+		t.Errorf("A bundle whose manifest was changed after it was signed was accepted.")
+	}
+}
+
+func TestOpenBundle_Fail_WrongSigner(t *testing.T) {
+	otherKey, _ := signaturing.CreateKeyPair()
+	otherPubKey := hex.EncodeToString(elliptic.Marshal(elliptic.P521(), otherKey.PublicKey.X, otherKey.PublicKey.Y))
+	// The bundle is signed by the key of this node, but names another key as its signer.
+	path, _ := testBundle(t, Manifest{NodeId: "exporter", Signer: otherPubKey})
+	if _, _, err := openBundle(path); err == nil {
+		t.Errorf("A bundle that was not signed by the signer it names was accepted.")
+	}
+}
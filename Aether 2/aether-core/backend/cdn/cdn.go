@@ -0,0 +1,48 @@
+// Backend > CDN
+// This package uploads the freshly baked caches to an S3-compatible object store, so that the remotes can download the cache pages from a CDN instead of from the node itself.
+
+package cdn
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// objectKey is the key a cache file is uploaded under. It mirrors the path of the cache in the node's statics directory, so that the CDN layout is the same as the origin's.
+func objectKey(respType string, cacheName string, filename string) string {
+	return fmt.Sprint("v0/", respType, "/", cacheName, "/", filename)
+}
+
+// MirrorUrl returns the public URL of a mirrored cache.
+func MirrorUrl(respType string, cacheName string) string {
+	return fmt.Sprint(strings.TrimRight(globals.CdnPublicBaseUrl, "/"), "/v0/", respType, "/", cacheName)
+}
+
+// UploadCache uploads the entity pages of a cache in the given directory. Index pages are not uploaded, they are always served by the origin. It returns the public URL of the mirrored cache.
+func UploadCache(respType string, cacheName string, cacheDir string) (string, error) {
+	if !globals.CdnEnabled {
+		return "", errors.New("The CDN is not enabled.")
+	}
+	files, err := filepath.Glob(fmt.Sprint(cacheDir, "/*.json"))
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		data, err2 := ioutil.ReadFile(f)
+		if err2 != nil {
+			return "", err2
+		}
+		key := objectKey(respType, cacheName, filepath.Base(f))
+		err3 := putObject(key, data)
+		if err3 != nil {
+			return "", errors.New(fmt.Sprintf("The cache page could not be uploaded to the CDN. Key: %s, Error: %s", key, err3))
+		}
+	}
+	logging.Log(1, fmt.Sprintf("Uploaded %d pages of the cache %s/%s to the CDN.", len(files), respType, cacheName))
+	return MirrorUrl(respType, cacheName), nil
+}
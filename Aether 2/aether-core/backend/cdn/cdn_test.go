@@ -0,0 +1,42 @@
+package cdn_test
+
+import (
+	"aether-core/backend/cdn"
+	"aether-core/services/globals"
+	"os"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+}
+
+func teardown() {
+}
+
+// Tests
+
+func TestMirrorUrl_Success(t *testing.T) {
+	globals.CdnPublicBaseUrl = "https://cdn.example.com/"
+	u := cdn.MirrorUrl("posts", "cache_abc")
+	if u != "https://cdn.example.com/v0/posts/cache_abc" {
+		t.Errorf("Mirror URL is wrong. URL: %s", u)
+	}
+}
+
+func TestUploadCache_Disabled_Fail(t *testing.T) {
+	globals.CdnEnabled = false
+	_, err := cdn.UploadCache("posts", "cache_abc", os.TempDir())
+	if err == nil {
+		t.Errorf("Expected an error when the CDN is disabled.")
+	}
+}
@@ -0,0 +1,99 @@
+// Backend > CDN > S3
+// This file implements the minimal S3 client the uploader needs: a path-style PUT signed with AWS Signature Version 4.
+
+package cdn
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signingKey derives the SigV4 signing key for the day.
+func signingKey(secret string, date string, region string) []byte {
+	kDate := hmacSha256([]byte(fmt.Sprint("AWS4", secret)), date)
+	kRegion := hmacSha256(kDate, region)
+	kService := hmacSha256(kRegion, "s3")
+	return hmacSha256(kService, "aws4_request")
+}
+
+// signRequest adds the SigV4 headers to the request. The payload hash has to be set as the x-amz-content-sha256 header beforehand.
+func signRequest(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := now.UTC().Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	// The signed headers have to be sorted and lowercase.
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprint(
+		"content-type:", req.Header.Get("Content-Type"), "\n",
+		"host:", req.URL.Host, "\n",
+		"x-amz-content-sha256:", payloadHash, "\n",
+		"x-amz-date:", amzDate, "\n")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprint(date, "/", globals.CdnRegion, "/s3/aws4_request")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+	signature := hex.EncodeToString(hmacSha256(signingKey(globals.CdnSecretKey, date, globals.CdnRegion), stringToSign))
+	req.Header.Set("Authorization", fmt.Sprint(
+		"AWS4-HMAC-SHA256 Credential=", globals.CdnAccessKey, "/", scope,
+		", SignedHeaders=", signedHeaders,
+		", Signature=", signature))
+}
+
+// putObject uploads a single object to the configured bucket.
+func putObject(key string, data []byte) error {
+	u, err := url.Parse(fmt.Sprint(strings.TrimRight(globals.CdnEndpoint, "/"), "/", globals.CdnBucket, "/", key))
+	if err != nil {
+		return err
+	}
+	req, err2 := http.NewRequest("PUT", u.String(), bytes.NewReader(data))
+	if err2 != nil {
+		return err2
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signRequest(req, sha256Hex(data), clock.Now())
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err3 := client.Do(req)
+	if err3 != nil {
+		return err3
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("The object store responded with a non-2xx status. Status: %d, Body: %s", resp.StatusCode, string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Backend > Compaction
+// This package replaces the old votes with per-target summaries. Votes are most of what a node stores and serves, but a single old vote matters little; what matters is the tally. Compaction is optional, since the summaries can only be verified as far as the node that signs them is trusted.
+
+package compaction
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"aether-core/services/signaturing"
+	"errors"
+	"fmt"
+)
+
+// signSummary signs the summary with the key of this node.
+func signSummary(s *persistence.DbVoteSummary) error {
+	summary := SummaryFromDb(s)
+	summary.Signer = globals.MarshaledPubKey
+	sig, err := signaturing.Sign(summary.SigningInput(), globals.KeyPair)
+	if err != nil {
+		return errors.New(fmt.Sprintf("The vote summary could not be signed. Target: %s, Error: %s", s.Target, err))
+	}
+	s.Signer = summary.Signer
+	s.Signature = api.Signature(sig)
+	return nil
+}
+
+// SummaryFromDb converts a summary from its database form.
+func SummaryFromDb(s *persistence.DbVoteSummary) api.VoteSummary {
+	return api.VoteSummary{
+		Target:    s.Target,
+		Board:     s.Board,
+		Thread:    s.Thread,
+		Type:      s.Type,
+		Count:     s.Count,
+		Until:     s.Until,
+		Signer:    s.Signer,
+		Signature: s.Signature,
+	}
+}
+
+// CompactVotes runs a compaction, if compaction is enabled.
+func CompactVotes() {
+	if !globals.VoteCompactionEnabled {
+		return
+	}
+	cutoff := persistence.VoteCompactionCutoff()
+	logging.Log(1, fmt.Sprintf("Vote compaction started. Cutoff: %d", cutoff))
+	compacted, err := persistence.CompactVotes(cutoff, signSummary)
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("Vote compaction failed. Error: %s", err))
+		return
+	}
+	logging.Log(1, fmt.Sprintf("Vote compaction is complete. Votes compacted: %d", compacted))
+}
+
+// ReadSummaries reads the summaries of the given targets, or all of them if none are given.
+func ReadSummaries(targets []api.Fingerprint) ([]api.VoteSummary, error) {
+	var summaries []api.VoteSummary
+	dbSummaries, err := persistence.ReadVoteSummaries(targets)
+	if err != nil {
+		return summaries, err
+	}
+	for i, _ := range dbSummaries {
+		summaries = append(summaries, SummaryFromDb(&dbSummaries[i]))
+	}
+	return summaries, nil
+}
@@ -0,0 +1,42 @@
+// This test is in the package itself rather than in compaction_test, since the summaries are signed by a function that is not exported.
+
+package compaction
+
+import (
+	"aether-core/io/persistence"
+	"aether-core/services/globals"
+	"testing"
+)
+
+func TestSignSummary_Success(t *testing.T) {
+	globals.GenerateUserKeyPair()
+	s := persistence.DbVoteSummary{Target: "target", Board: "board", Thread: "thread", Type: 1, Count: 12, Until: 2000}
+	err := signSummary(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := SummaryFromDb(&s)
+	if summary.Signer != globals.MarshaledPubKey || !summary.VerifySignature() {
+		t.Errorf("The summary should be signed by the key of this node. Summary: %#v", summary)
+	}
+	// The signature covers the count, so a summary with a count changed afterwards doesn't verify.
+	summary.Count++
+	if summary.VerifySignature() {
+		t.Errorf("A summary changed after it was signed should not verify.")
+	}
+}
+
+func TestSummaryFromDb_Success(t *testing.T) {
+	s := persistence.DbVoteSummary{Target: "target", Board: "board", Thread: "thread", Type: 2, Count: 3, Until: 4, Signer: "signer", Signature: "sig", LocalArrival: 5}
+	summary := SummaryFromDb(&s)
+	if summary.Target != s.Target || summary.Board != s.Board || summary.Thread != s.Thread || summary.Type != s.Type || summary.Count != s.Count || summary.Until != s.Until || summary.Signer != s.Signer || summary.Signature != s.Signature {
+		t.Errorf("The summary should have the fields of its database form. Summary: %#v", summary)
+	}
+}
+
+func TestCompactVotes_Fail_Disabled(t *testing.T) {
+	defer func(v bool) { globals.VoteCompactionEnabled = v }(globals.VoteCompactionEnabled)
+	globals.VoteCompactionEnabled = false
+	// With compaction off, nothing is read or written, so this returns without a database.
+	CompactVotes()
+}
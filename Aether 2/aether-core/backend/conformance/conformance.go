@@ -0,0 +1,581 @@
+// Backend > Conformance
+// This package writes the test vectors of the protocol: a set of sample entities with their fingerprints, proofs of work and signatures, the responses this node gives to every endpoint for them, and the caches it would generate out of them. People implementing the protocol in other clients can test against these, and we can test against them too: the responses are rebuilt from the same entities on every check, so any change in what the node sends out shows up as a difference.
+// The vectors are generated under fixed conditions (a fixed time, node id, key and page sizes), so that the same entities always give the same responses byte for byte. The entities themselves can't be fixed that way, since signatures and proofs of work have randomness in them, so they are saved along with the responses, and the check builds the responses from the saved entities.
+
+package conformance
+
+import (
+	"aether-core/backend/responsegenerator"
+	"aether-core/io/api"
+	"aether-core/services/canonical"
+	"aether-core/services/clock"
+	"aether-core/services/fingerprinting"
+	"aether-core/services/globals"
+	"aether-core/services/proofofwork"
+	"aether-core/services/signaturing"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// vectorsVersion is increased when the layout of the vectors changes in a way older checks can't read. 2 added the inputs of the fingerprints, the proofs of work and the signatures.
+const vectorsVersion = 2
+
+const (
+	vectorsTime       = 1500000000 // Unix timestamp all the vectors are generated at.
+	vectorsDifficulty = 8          // Low, so that the vectors are quick to generate. This is also the minimum the vectors node advertises.
+	vectorsCacheName  = "cache_conformance"
+	vectorsSeed       = "aether conformance test vectors" // The node id and the private key of the vectors node are derived from this.
+	vectorsPostsPage  = 2                                 // Small enough that the posts come out in multiple pages.
+)
+
+// entityTypes are the types the vectors have caches and POST responses for, in the order they are written.
+var entityTypes = []string{"boards", "threads", "posts", "votes", "keys", "truststates", "tombstones", "addresses"}
+
+// Manifest describes the vectors, and lists the response files in them.
+type Manifest struct {
+	Version       int    `json:"version"`
+	Created       int64  `json:"created"`
+	NodeId        string `json:"node_id"`
+	PublicKey     string `json:"public_key"` // Public key of the vectors node. Every entity in the vectors is signed with it.
+	PoWDifficulty int64  `json:"pow_difficulty"`
+	Files         []File `json:"files"`
+}
+
+// File is one response of the vectors. The file holds the response exactly as the node sends it.
+type File struct {
+	Path        string `json:"path"`
+	Endpoint    string `json:"endpoint"`
+	Description string `json:"description"`
+}
+
+// EntityVector is what an implementation should get out of one of the entities. The fingerprint, the proof of work and the signature of an entity are not made over its canonical JSON, but over the JSON of its struct, in the order of its fields, with the fields each doesn't cover emptied; the inputs are those exact bytes. The canonical form is accepted when verifying, but nothing is made over it, since the nodes that know no other form would refuse it.
+type EntityVector struct {
+	EntityType       string          `json:"entity_type"`
+	Canonical        string          `json:"canonical"` // Canonical JSON of the entity as a whole.
+	FingerprintInput string          `json:"fingerprint_input,omitempty"`
+	Fingerprint      api.Fingerprint `json:"fingerprint,omitempty"`
+	PoWInput         string          `json:"proof_of_work_input,omitempty"`
+	ProofOfWork      api.ProofOfWork `json:"proof_of_work,omitempty"`
+	SignatureInput   string          `json:"signature_input,omitempty"`
+	Signature        api.Signature   `json:"signature,omitempty"`
+}
+
+// provable is an entity whose fingerprint, proof of work and signature have inputs that can be given.
+type provable interface {
+	FingerprintInput() string
+	PoWInput() string
+	SignatureInput() string
+}
+
+// provableVector gives the vector of an entity, with the inputs of its fingerprint, proof of work and signature.
+func provableVector(entityType string, e provable, fp api.Fingerprint, pow api.ProofOfWork, sig api.Signature) EntityVector {
+	return EntityVector{entityType, canonical.String(e), e.FingerprintInput(), fp, e.PoWInput(), pow, e.SignatureInput(), sig}
+}
+
+// Entities is the content of entities.json.
+type Entities struct {
+	Entities api.Answer     `json:"entities"`
+	Vectors  []EntityVector `json:"vectors"`
+}
+
+type response struct {
+	File
+	resp api.ApiResponse
+}
+
+// prepare sets up the globals the responses are built with, so that they come out the same on every machine.
+func prepare(created int64) {
+	clock.Set(clock.NewMockClock(time.Unix(created, 0)))
+	curve := elliptic.P521()
+	seed := sha512.Sum512([]byte(vectorsSeed))
+	d := new(big.Int).SetBytes(seed[:])
+	d.Mod(d, curve.Params().N)
+	privKey := new(ecdsa.PrivateKey)
+	privKey.PublicKey.Curve = curve
+	privKey.D = d
+	privKey.PublicKey.X, privKey.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+	globals.KeyPair = privKey
+	globals.MarshaledPubKey = hex.EncodeToString(elliptic.Marshal(curve, privKey.PublicKey.X, privKey.PublicKey.Y))
+	globals.NodeId = fingerprinting.Create(vectorsSeed)
+	globals.NetworkId = ""
+	globals.NetworkMembershipKey = ""
+	globals.AdvertisedEndpoints = []globals.AdvertisedEndpoint{}
+	globals.AddressType = 2
+	globals.AddressPort = 49999
+	globals.SetMinPoWStrengths(vectorsDifficulty)
+	globals.EntityPageSizesObj.Posts = vectorsPostsPage
+	globals.EntityPageSizesObj.PostIndexes = vectorsPostsPage
+}
+
+// createEntities creates one of each entity, and a few posts, signed by the vectors node. The order is the one of a real client: the signature first, then the proof of work that covers the signature, then the fingerprint that covers both.
+func createEntities() (api.Answer, error) {
+	var a api.Answer
+	now := api.Timestamp(clock.Unix())
+	key := globals.KeyPair
+	var k api.Key
+	k.Creation = now
+	k.Type = "ecdsa-p521"
+	k.Key = globals.MarshaledPubKey
+	k.Name = "conformance"
+	k.Info = "The key of the conformance test vectors."
+	err := k.CreateSignature(key)
+	if err != nil {
+		return a, err
+	}
+	err = k.CreatePoW(key, vectorsDifficulty)
+	if err != nil {
+		return a, err
+	}
+	k.CreateFingerprint()
+	a.Keys = append(a.Keys, k)
+	owner := k.Fingerprint
+	var b api.Board
+	b.Creation = now
+	b.Name = "conformance"
+	b.Description = "A board of the conformance test vectors."
+	b.Owner = owner
+	b.BoardOwners = []api.BoardOwner{api.BoardOwner{KeyFingerprint: owner, Expiry: now + 86400, Level: 1}}
+	err = b.CreateSignature(key)
+	if err != nil {
+		return a, err
+	}
+	err = b.CreatePoW(key, vectorsDifficulty)
+	if err != nil {
+		return a, err
+	}
+	b.CreateFingerprint()
+	a.Boards = append(a.Boards, b)
+	var t api.Thread
+	t.Creation = now
+	t.Board = b.Fingerprint
+	t.Name = "Conformance thread"
+	t.Body = "Unicode: çöğüş ☃, escapes: \"quoted\" <tag> & \\"
+	t.Link = "https://example.com/?a=1&b=2"
+	t.Owner = owner
+	err = t.CreateSignature(key)
+	if err != nil {
+		return a, err
+	}
+	err = t.CreatePoW(key, vectorsDifficulty)
+	if err != nil {
+		return a, err
+	}
+	t.CreateFingerprint()
+	a.Threads = append(a.Threads, t)
+	parent := t.Fingerprint
+	for i := 0; i < vectorsPostsPage+1; i++ {
+		var p api.Post
+		p.Creation = now + api.Timestamp(i)
+		p.Board = b.Fingerprint
+		p.Thread = t.Fingerprint
+		p.Parent = parent
+		p.Body = fmt.Sprintf("Conformance post %d", i)
+		p.Owner = owner
+		err = p.CreateSignature(key)
+		if err != nil {
+			return a, err
+		}
+		err = p.CreatePoW(key, vectorsDifficulty)
+		if err != nil {
+			return a, err
+		}
+		p.CreateFingerprint()
+		a.Posts = append(a.Posts, p)
+		parent = p.Fingerprint
+	}
+	var v api.Vote
+	v.Creation = now
+	v.Board = b.Fingerprint
+	v.Thread = t.Fingerprint
+	v.Target = a.Posts[0].Fingerprint
+	v.Owner = owner
+	v.Type = 1
+	err = v.CreateSignature(key)
+	if err != nil {
+		return a, err
+	}
+	err = v.CreatePoW(key, vectorsDifficulty)
+	if err != nil {
+		return a, err
+	}
+	v.CreateFingerprint()
+	a.Votes = append(a.Votes, v)
+	var ts api.Truststate
+	ts.Creation = now
+	ts.Target = owner
+	ts.Owner = owner
+	ts.Type = 1
+	ts.Domains = []api.Fingerprint{b.Fingerprint}
+	ts.Expiry = now + 86400
+	err = ts.CreateSignature(key)
+	if err != nil {
+		return a, err
+	}
+	err = ts.CreatePoW(key, vectorsDifficulty)
+	if err != nil {
+		return a, err
+	}
+	ts.CreateFingerprint()
+	a.Truststates = append(a.Truststates, ts)
+	var tb api.Tombstone
+	tb.Creation = now
+	tb.Target = a.Posts[len(a.Posts)-1].Fingerprint
+	tb.TargetType = "posts"
+	tb.Owner = owner
+	err = tb.CreateSignature(key)
+	if err != nil {
+		return a, err
+	}
+	err = tb.CreatePoW(key, vectorsDifficulty)
+	if err != nil {
+		return a, err
+	}
+	tb.CreateFingerprint()
+	a.Tombstones = append(a.Tombstones, tb)
+	var addr api.Address
+	addr.Location = "192.0.2.1"
+	addr.LocationType = api.LocationTypeIPv4
+	addr.Port = 49999
+	addr.Type = 2
+	addr.LastOnline = now
+	addr.Protocol.VersionMajor = uint8(globals.ProtocolVersionMajor)
+	addr.Protocol.VersionMinor = uint16(globals.ProtocolVersionMinor)
+	addr.Client.ClientName = "conformance"
+	a.Addresses = append(a.Addresses, addr)
+	var vs api.VoteSummary
+	vs.Target = v.Target
+	vs.Board = v.Board
+	vs.Thread = v.Thread
+	vs.Type = v.Type
+	vs.Count = 1
+	vs.Until = now
+	vs.Signer = globals.MarshaledPubKey
+	sig, err := signaturing.Sign(vs.SigningInput(), key)
+	if err != nil {
+		return a, err
+	}
+	vs.Signature = api.Signature(sig)
+	a.VoteSummaries = append(a.VoteSummaries, vs)
+	return a, nil
+}
+
+// vectorsOf gives the expected values of every entity.
+func vectorsOf(a api.Answer) []EntityVector {
+	var vectors []EntityVector
+	for i, _ := range a.Boards {
+		e := a.Boards[i]
+		vectors = append(vectors, provableVector("boards", &e, e.Fingerprint, e.ProofOfWork, e.Signature))
+	}
+	for i, _ := range a.Threads {
+		e := a.Threads[i]
+		vectors = append(vectors, provableVector("threads", &e, e.Fingerprint, e.ProofOfWork, e.Signature))
+	}
+	for i, _ := range a.Posts {
+		e := a.Posts[i]
+		vectors = append(vectors, provableVector("posts", &e, e.Fingerprint, e.ProofOfWork, e.Signature))
+	}
+	for i, _ := range a.Votes {
+		e := a.Votes[i]
+		vectors = append(vectors, provableVector("votes", &e, e.Fingerprint, e.ProofOfWork, e.Signature))
+	}
+	for i, _ := range a.Keys {
+		e := a.Keys[i]
+		vectors = append(vectors, provableVector("keys", &e, e.Fingerprint, e.ProofOfWork, e.Signature))
+	}
+	for i, _ := range a.Truststates {
+		e := a.Truststates[i]
+		vectors = append(vectors, provableVector("truststates", &e, e.Fingerprint, e.ProofOfWork, e.Signature))
+	}
+	for i, _ := range a.Tombstones {
+		e := a.Tombstones[i]
+		vectors = append(vectors, provableVector("tombstones", &e, e.Fingerprint, e.ProofOfWork, e.Signature))
+	}
+	for i, _ := range a.Addresses {
+		vectors = append(vectors, EntityVector{EntityType: "addresses", Canonical: canonical.String(a.Addresses[i])})
+	}
+	for i, _ := range a.VoteSummaries {
+		e := a.VoteSummaries[i]
+		vectors = append(vectors, EntityVector{EntityType: "votesummaries", Canonical: canonical.String(e), Signature: e.Signature})
+	}
+	return vectors
+}
+
+// verifyEntities checks that every entity is fingerprinted, proven and signed correctly with the given key.
+func verifyEntities(a api.Answer, pubKey string) []string {
+	var problems []string
+	report := func(entityType string, fp api.Fingerprint, what string, ok bool, err error) {
+		if !ok || err != nil {
+			problems = append(problems, fmt.Sprintf("The %s of the %s entity %s does not verify. Error: %v", what, entityType, fp, err))
+		}
+	}
+	for i, _ := range a.Boards {
+		e := &a.Boards[i]
+		report("boards", e.Fingerprint, "fingerprint", e.VerifyFingerprint(), nil)
+		ok, err := e.VerifyPoW(pubKey)
+		report("boards", e.Fingerprint, "proof of work", ok, err)
+		ok, err = e.VerifySignature(pubKey)
+		report("boards", e.Fingerprint, "signature", ok, err)
+	}
+	for i, _ := range a.Threads {
+		e := &a.Threads[i]
+		report("threads", e.Fingerprint, "fingerprint", e.VerifyFingerprint(), nil)
+		ok, err := e.VerifyPoW(pubKey)
+		report("threads", e.Fingerprint, "proof of work", ok, err)
+		ok, err = e.VerifySignature(pubKey)
+		report("threads", e.Fingerprint, "signature", ok, err)
+	}
+	for i, _ := range a.Posts {
+		e := &a.Posts[i]
+		report("posts", e.Fingerprint, "fingerprint", e.VerifyFingerprint(), nil)
+		ok, err := e.VerifyPoW(pubKey)
+		report("posts", e.Fingerprint, "proof of work", ok, err)
+		ok, err = e.VerifySignature(pubKey)
+		report("posts", e.Fingerprint, "signature", ok, err)
+	}
+	for i, _ := range a.Votes {
+		e := &a.Votes[i]
+		report("votes", e.Fingerprint, "fingerprint", e.VerifyFingerprint(), nil)
+		ok, err := e.VerifyPoW(pubKey)
+		report("votes", e.Fingerprint, "proof of work", ok, err)
+		ok, err = e.VerifySignature(pubKey)
+		report("votes", e.Fingerprint, "signature", ok, err)
+	}
+	for i, _ := range a.Keys {
+		e := &a.Keys[i]
+		report("keys", e.Fingerprint, "fingerprint", e.VerifyFingerprint(), nil)
+		ok, err := e.VerifyPoW(pubKey)
+		report("keys", e.Fingerprint, "proof of work", ok, err)
+		ok, err = e.VerifySignature(pubKey)
+		report("keys", e.Fingerprint, "signature", ok, err)
+	}
+	for i, _ := range a.Truststates {
+		e := &a.Truststates[i]
+		report("truststates", e.Fingerprint, "fingerprint", e.VerifyFingerprint(), nil)
+		ok, err := e.VerifyPoW(pubKey)
+		report("truststates", e.Fingerprint, "proof of work", ok, err)
+		ok, err = e.VerifySignature(pubKey)
+		report("truststates", e.Fingerprint, "signature", ok, err)
+	}
+	for i, _ := range a.Tombstones {
+		e := &a.Tombstones[i]
+		report("tombstones", e.Fingerprint, "fingerprint", e.VerifyFingerprint(), nil)
+		ok, err := e.VerifyPoW(pubKey)
+		report("tombstones", e.Fingerprint, "proof of work", ok, err)
+		ok, err = e.VerifySignature(pubKey)
+		report("tombstones", e.Fingerprint, "signature", ok, err)
+	}
+	for i, _ := range a.VoteSummaries {
+		e := &a.VoteSummaries[i]
+		report("votesummaries", e.Target, "signature", e.VerifySignature(), nil)
+	}
+	return problems
+}
+
+// verifyInputs checks the fingerprints, the proofs of work and the signatures of the vectors against their inputs as given, so that what is checked is what an implementation has to make them over.
+func verifyInputs(vectors []EntityVector, pubKey string) []string {
+	var problems []string
+	for i, _ := range vectors {
+		v := vectors[i]
+		if len(v.FingerprintInput) > 0 && !fingerprinting.Verify(v.FingerprintInput, string(v.Fingerprint)) {
+			problems = append(problems, fmt.Sprintf("The fingerprint of the vector %d (%s) is not that of its input.", i, v.EntityType))
+		}
+		if len(v.PoWInput) > 0 {
+			ok, _, err := proofofwork.Verify(v.PoWInput, string(v.ProofOfWork), pubKey)
+			if !ok || err != nil {
+				problems = append(problems, fmt.Sprintf("The proof of work of the vector %d (%s) is not over its input. Error: %v", i, v.EntityType, err))
+			}
+		}
+		if len(v.SignatureInput) > 0 && !signaturing.Verify(v.SignatureInput, string(v.Signature), pubKey) {
+			problems = append(problems, fmt.Sprintf("The signature of the vector %d (%s) is not over its input.", i, v.EntityType))
+		}
+	}
+	return problems
+}
+
+// responseOf puts the entities of the given type into a response.
+func responseOf(entityType string, a api.Answer) api.Response {
+	var data api.Response
+	switch entityType {
+	case "boards":
+		data.Boards = a.Boards
+	case "threads":
+		data.Threads = a.Threads
+	case "posts":
+		data.Posts = a.Posts
+	case "votes":
+		data.Votes = a.Votes
+	case "keys":
+		data.Keys = a.Keys
+	case "truststates":
+		data.Truststates = a.Truststates
+	case "tombstones":
+		data.Tombstones = a.Tombstones
+	case "addresses":
+		data.Addresses = a.Addresses
+	}
+	return data
+}
+
+// buildResponses builds every response the vectors have out of the entities, in the order they are listed in the manifest.
+func buildResponses(a api.Answer) ([]response, error) {
+	var resps []response
+	resps = append(resps, response{File{"node.json", "/v0/node", "Response to GET and POST on the node endpoint."}, responsegenerator.SampleNodeResponse()})
+	var peers api.Answer
+	peers.Addresses = a.Addresses
+	resps = append(resps, response{File{"peers.json", "/v0/peers", "Response to a POST on the peers endpoint."}, responsegenerator.SampleListResponse("peers", peers)})
+	var summaries api.Answer
+	summaries.VoteSummaries = a.VoteSummaries
+	resps = append(resps, response{File{"votesummaries.json", "/v0/votesummaries", "Response to a POST on the vote summaries endpoint."}, responsegenerator.SampleListResponse("votesummaries", summaries)})
+	for _, entityType := range entityTypes {
+		data := responseOf(entityType, a)
+		folder := fmt.Sprintf("conformance_%s", entityType)
+		postResp, pages := responsegenerator.SamplePOSTResponse(entityType, data, folder)
+		resps = append(resps, response{File{fmt.Sprintf("post/%s.json", entityType), fmt.Sprintf("/v0/%s", entityType), fmt.Sprintf("Response to a POST on the %s endpoint.", entityType)}, postResp})
+		for i, _ := range pages {
+			resps = append(resps, response{File{fmt.Sprintf("post/%s/%d.json", folder, i), fmt.Sprintf("/responses/%s/%d.json", folder, i), fmt.Sprintf("Page %d of the multipart response to a POST on the %s endpoint.", i, entityType)}, pages[i]})
+		}
+		c, err := responsegenerator.SampleCacheResponses(entityType, data, vectorsCacheName, api.Timestamp(vectorsTime-86400), api.Timestamp(vectorsTime))
+		if err != nil {
+			return resps, err
+		}
+		resps = append(resps, response{File{fmt.Sprintf("caches/%s/index.json", entityType), fmt.Sprintf("/v0/c0/%s/index.json", entityType), fmt.Sprintf("Cache index of the %s.", entityType)}, c.Index})
+		for i, _ := range c.EntityPages {
+			resps = append(resps, response{File{fmt.Sprintf("caches/%s/%s/%d.json", entityType, vectorsCacheName, i), fmt.Sprintf("/v0/c0/%s/%s/%d.json", entityType, vectorsCacheName, i), fmt.Sprintf("Page %d of a cache of the %s.", i, entityType)}, c.EntityPages[i]})
+		}
+		for i, _ := range c.IndexPages {
+			resps = append(resps, response{File{fmt.Sprintf("caches/%s/%s/index/%d.json", entityType, vectorsCacheName, i), fmt.Sprintf("/v0/c0/%s/%s/index/%d.json", entityType, vectorsCacheName, i), fmt.Sprintf("Page %d of the index of a cache of the %s.", i, entityType)}, c.IndexPages[i]})
+		}
+	}
+	return resps, nil
+}
+
+func writeFile(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Write generates the vectors into the given directory. This changes the globals and the clock of the app, so it should only be run on its own, and the app should exit afterwards.
+func Write(dir string) error {
+	prepare(vectorsTime)
+	a, err := createEntities()
+	if err != nil {
+		return errors.New(fmt.Sprintf("The entities of the test vectors could not be created. Error: %s", err))
+	}
+	resps, err2 := buildResponses(a)
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The responses of the test vectors could not be built. Error: %s", err2))
+	}
+	m := Manifest{
+		Version:       vectorsVersion,
+		Created:       vectorsTime,
+		NodeId:        globals.NodeId,
+		PublicKey:     globals.MarshaledPubKey,
+		PoWDifficulty: vectorsDifficulty,
+	}
+	for i, _ := range resps {
+		data, err3 := responsegenerator.ConvertApiResponseToJson(&resps[i].resp)
+		if err3 != nil {
+			return err3
+		}
+		err4 := writeFile(filepath.Join(dir, filepath.FromSlash(resps[i].Path)), data)
+		if err4 != nil {
+			return err4
+		}
+		m.Files = append(m.Files, resps[i].File)
+	}
+	entities, err5 := json.MarshalIndent(Entities{Entities: a, Vectors: vectorsOf(a)}, "", "  ")
+	if err5 != nil {
+		return err5
+	}
+	err6 := writeFile(filepath.Join(dir, "entities.json"), entities)
+	if err6 != nil {
+		return err6
+	}
+	manifest, err7 := json.MarshalIndent(m, "", "  ")
+	if err7 != nil {
+		return err7
+	}
+	return writeFile(filepath.Join(dir, "manifest.json"), manifest)
+}
+
+// Check verifies the vectors in the given directory, and builds the responses again out of their entities to compare with the ones saved. It returns what doesn't match. Like Write, it changes the globals and the clock of the app.
+func Check(dir string) ([]string, error) {
+	var problems []string
+	var m Manifest
+	manifest, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return problems, err
+	}
+	err2 := json.Unmarshal(manifest, &m)
+	if err2 != nil {
+		return problems, errors.New(fmt.Sprintf("The manifest of the test vectors could not be read. Error: %s", err2))
+	}
+	if m.Version > vectorsVersion {
+		return problems, errors.New(fmt.Sprintf("These test vectors are of version %d, which is newer than this app can check. Version of this app: %d", m.Version, vectorsVersion))
+	}
+	var e Entities
+	entities, err3 := ioutil.ReadFile(filepath.Join(dir, "entities.json"))
+	if err3 != nil {
+		return problems, err3
+	}
+	err4 := json.Unmarshal(entities, &e)
+	if err4 != nil {
+		return problems, errors.New(fmt.Sprintf("The entities of the test vectors could not be read. Error: %s", err4))
+	}
+	prepare(m.Created)
+	if m.NodeId != globals.NodeId || m.PublicKey != globals.MarshaledPubKey {
+		problems = append(problems, "The node id or the public key of the vectors node does not match the one derived from the seed.")
+	}
+	problems = append(problems, verifyEntities(e.Entities, m.PublicKey)...)
+	problems = append(problems, verifyInputs(e.Vectors, m.PublicKey)...)
+	expected := vectorsOf(e.Entities)
+	if len(expected) != len(e.Vectors) {
+		problems = append(problems, fmt.Sprintf("There are %d entity vectors, but %d entities.", len(e.Vectors), len(expected)))
+	} else {
+		for i, _ := range expected {
+			if expected[i] != e.Vectors[i] {
+				problems = append(problems, fmt.Sprintf("The vector %d (%s) does not match its entity. Expected: %#v, Got: %#v", i, expected[i].EntityType, expected[i], e.Vectors[i]))
+			}
+		}
+	}
+	resps, err5 := buildResponses(e.Entities)
+	if err5 != nil {
+		return problems, err5
+	}
+	built := make(map[string]bool)
+	for i, _ := range resps {
+		built[resps[i].Path] = true
+		data, err6 := responsegenerator.ConvertApiResponseToJson(&resps[i].resp)
+		if err6 != nil {
+			return problems, err6
+		}
+		onDisk, err7 := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(resps[i].Path)))
+		if err7 != nil {
+			problems = append(problems, fmt.Sprintf("The response %s could not be read. Error: %s", resps[i].Path, err7))
+			continue
+		}
+		if !bytes.Equal(data, onDisk) {
+			problems = append(problems, fmt.Sprintf("The response %s is different from the one built now.\nSaved: %s\nBuilt: %s", resps[i].Path, onDisk, data))
+		}
+	}
+	for _, f := range m.Files {
+		if !built[f.Path] {
+			problems = append(problems, fmt.Sprintf("The response %s is in the manifest, but it is not built anymore.", f.Path))
+		}
+	}
+	return problems, nil
+}
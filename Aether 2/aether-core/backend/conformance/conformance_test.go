@@ -0,0 +1,115 @@
+package conformance_test
+
+import (
+	"aether-core/backend/conformance"
+	"aether-core/services/globals"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+var dir string
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+	var err error
+	dir, err = ioutil.TempDir("", "aether-conformance")
+	if err != nil {
+		panic(err)
+	}
+	err = conformance.Write(dir)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func teardown() {
+	os.RemoveAll(dir)
+}
+
+// Tests
+
+func TestCheck_Success(t *testing.T) {
+	problems, err := conformance.Check(dir)
+	if err != nil {
+		t.Fatalf("The check failed. Error: %s", err)
+	}
+	if len(problems) > 0 {
+		t.Errorf("The vectors that were just written should have no problems. Problems: %v", problems)
+	}
+}
+
+func TestCheck_Fail_ChangedResponse(t *testing.T) {
+	path := filepath.Join(dir, "caches", "boards", "index.json")
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("The cache index could not be read. Error: %s", err)
+	}
+	defer ioutil.WriteFile(path, original, 0644)
+	ioutil.WriteFile(path, append(original, ' '), 0644)
+	problems, err2 := conformance.Check(dir)
+	if err2 != nil {
+		t.Fatalf("The check failed. Error: %s", err2)
+	}
+	if len(problems) != 1 {
+		t.Errorf("Expected 1 problem, got %d. Problems: %v", len(problems), problems)
+	}
+}
+
+func TestCheck_Fail_TamperedEntity(t *testing.T) {
+	path := filepath.Join(dir, "entities.json")
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("The entities could not be read. Error: %s", err)
+	}
+	defer ioutil.WriteFile(path, original, 0644)
+	tampered := bytes.Replace(original, []byte("Conformance post 0"), []byte("Conformance post 9"), -1)
+	ioutil.WriteFile(path, tampered, 0644)
+	problems, err2 := conformance.Check(dir)
+	if err2 != nil {
+		t.Fatalf("The check failed. Error: %s", err2)
+	}
+	if len(problems) == 0 {
+		t.Errorf("A post that was changed after it was signed should have been caught.")
+	}
+}
+
+func TestCheck_Fail_TamperedInput(t *testing.T) {
+	path := filepath.Join(dir, "entities.json")
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("The entities could not be read. Error: %s", err)
+	}
+	defer ioutil.WriteFile(path, original, 0644)
+	var e conformance.Entities
+	err2 := json.Unmarshal(original, &e)
+	if err2 != nil {
+		t.Fatalf("The entities could not be parsed. Error: %s", err2)
+	}
+	// The inputs are the JSON of the struct, not the canonical JSON.
+	if e.Vectors[0].SignatureInput == e.Vectors[0].Canonical || len(e.Vectors[0].SignatureInput) == 0 {
+		t.Errorf("The signature input should be given, and differ from the canonical JSON. Vector: %#v", e.Vectors[0])
+	}
+	e.Vectors[0].SignatureInput = e.Vectors[0].Canonical
+	tampered, _ := json.Marshal(e)
+	ioutil.WriteFile(path, tampered, 0644)
+	problems, err3 := conformance.Check(dir)
+	if err3 != nil {
+		t.Fatalf("The check failed. Error: %s", err3)
+	}
+	if len(problems) != 2 {
+		t.Errorf("The signature should not have verified over the changed input, and the vector should not have matched its entity. Problems: %v", problems)
+	}
+}
@@ -0,0 +1,189 @@
+// Backend > Content Filters
+// This package keeps what the local user muted: keywords, patterns, authors and boards. The filters are kept in the database per user key, and they are applied when the frontend endpoints put together what they show, so that the frontend never gets the muted content unless the user asked for it to be collapsed rather than hidden.
+
+package contentfilters
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Types of the filters.
+const (
+	TypeKeyword = "keyword" // Matches the text that contains the value, ignoring case.
+	TypeRegex   = "regex"   // Matches the text the value matches, as a regular expression.
+	TypeAuthor  = "author"  // Matches the content of the key with the value as its fingerprint.
+	TypeBoard   = "board"   // Matches the content in the board with the value as its fingerprint.
+)
+
+// Actions of the filters. When more than one filter matches, hiding wins over collapsing.
+const (
+	ActionHide     = "hide"     // The content is left out.
+	ActionCollapse = "collapse" // The content is given, marked as collapsed, so that the frontend can show it folded.
+)
+
+// Filter is the frontend-facing form of a content filter.
+type Filter struct {
+	Type     string        `json:"type"`
+	Value    string        `json:"value"`
+	Action   string        `json:"action"` // Hide if not given.
+	Creation api.Timestamp `json:"creation"`
+}
+
+// profile is the user the filters belong to. Filters added before the user has a key belong to the empty profile.
+func profile() api.Fingerprint {
+	return api.Fingerprint(globals.CurrentUserKeyFingerprint())
+}
+
+func validate(f Filter) error {
+	if len(f.Value) == 0 {
+		return errors.New("A content filter needs a value.")
+	}
+	if len(f.Value) > 512 {
+		return errors.New(fmt.Sprintf("The value of a content filter can be at most 512 characters. Length: %d", len(f.Value)))
+	}
+	switch f.Type {
+	case TypeKeyword, TypeAuthor, TypeBoard:
+	case TypeRegex:
+		_, err := regexp.Compile(f.Value)
+		if err != nil {
+			return errors.New(fmt.Sprintf("The pattern of the content filter is invalid. Pattern: %s, Error: %s", f.Value, err))
+		}
+	default:
+		return errors.New(fmt.Sprintf("The type of the content filter is unknown. Type: %s", f.Type))
+	}
+	if f.Action != ActionHide && f.Action != ActionCollapse {
+		return errors.New(fmt.Sprintf("The action of the content filter is unknown. Action: %s", f.Action))
+	}
+	return nil
+}
+
+// Add saves a filter for the local user. Adding a filter that already exists changes its action.
+func Add(f Filter) error {
+	if len(f.Action) == 0 {
+		f.Action = ActionHide
+	}
+	err := validate(f)
+	if err != nil {
+		return err
+	}
+	var dbF persistence.DbContentFilter
+	dbF.Profile = profile()
+	dbF.Type = f.Type
+	dbF.Value = f.Value
+	dbF.Action = f.Action
+	dbF.Creation = api.Timestamp(clock.Unix())
+	return persistence.InsertContentFilter(dbF)
+}
+
+// Remove removes a filter of the local user, and returns how many were removed.
+func Remove(filterType string, value string) (int64, error) {
+	return persistence.DeleteContentFilter(profile(), filterType, value)
+}
+
+// List returns the filters of the local user, oldest first.
+func List() ([]Filter, error) {
+	var result []Filter
+	dbFs, err := persistence.ReadContentFilters(profile())
+	if err != nil {
+		return result, err
+	}
+	for _, dbF := range dbFs {
+		result = append(result, Filter{Type: dbF.Type, Value: dbF.Value, Action: dbF.Action, Creation: dbF.Creation})
+	}
+	return result, nil
+}
+
+type pattern struct {
+	re     *regexp.Regexp
+	action string
+}
+
+// Set is the filters of the local user, ready to be matched.
+type Set struct {
+	keywords map[string]string // Lowercased keyword to action.
+	patterns []pattern
+	authors  map[api.Fingerprint]string
+	boards   map[api.Fingerprint]string
+}
+
+// NewSet puts the given filters into a set.
+func NewSet(fs []Filter) *Set {
+	s := &Set{
+		keywords: make(map[string]string),
+		authors:  make(map[api.Fingerprint]string),
+		boards:   make(map[api.Fingerprint]string),
+	}
+	for _, f := range fs {
+		switch f.Type {
+		case TypeKeyword:
+			s.keywords[strings.ToLower(f.Value)] = stronger(s.keywords[strings.ToLower(f.Value)], f.Action)
+		case TypeRegex:
+			re, err := regexp.Compile(f.Value)
+			if err != nil {
+				// Saved filters are validated when they are added, so this can only be one from an older version.
+				continue
+			}
+			s.patterns = append(s.patterns, pattern{re, f.Action})
+		case TypeAuthor:
+			s.authors[api.Fingerprint(f.Value)] = f.Action
+		case TypeBoard:
+			s.boards[api.Fingerprint(f.Value)] = f.Action
+		}
+	}
+	return s
+}
+
+// Load reads the filters of the local user into a set. Load it once for every view that is put together, so that the changes to the filters show up right away.
+func Load() (*Set, error) {
+	fs, err := List()
+	if err != nil {
+		return NewSet([]Filter{}), err
+	}
+	return NewSet(fs), nil
+}
+
+// stronger gives the action that wins of the two.
+func stronger(a string, b string) string {
+	if a == ActionHide || b == ActionHide {
+		return ActionHide
+	}
+	if a == ActionCollapse || b == ActionCollapse {
+		return ActionCollapse
+	}
+	return ""
+}
+
+// Match gives what to do with content in the given board, by the given author, with the given texts: ActionHide, ActionCollapse, or empty if no filter matches. Either of the board and the author can be empty if they are not known.
+func (s *Set) Match(board api.Fingerprint, author api.Fingerprint, texts ...string) string {
+	action := ""
+	if len(board) > 0 {
+		action = stronger(action, s.boards[board])
+	}
+	if len(author) > 0 {
+		action = stronger(action, s.authors[author])
+	}
+	for _, text := range texts {
+		if action == ActionHide {
+			return action
+		}
+		lowered := strings.ToLower(text)
+		for keyword, a := range s.keywords {
+			if strings.Contains(lowered, keyword) {
+				action = stronger(action, a)
+			}
+		}
+		for _, p := range s.patterns {
+			if p.re.MatchString(text) {
+				action = stronger(action, p.action)
+			}
+		}
+	}
+	return action
+}
@@ -0,0 +1,79 @@
+package contentfilters_test
+
+import (
+	"aether-core/backend/contentfilters"
+	"aether-core/services/globals"
+	"os"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+}
+
+func teardown() {
+}
+
+// Tests
+
+func TestMatch_Success(t *testing.T) {
+	set := contentfilters.NewSet([]contentfilters.Filter{
+		{Type: contentfilters.TypeKeyword, Value: "Spoiler", Action: contentfilters.ActionCollapse},
+		{Type: contentfilters.TypeRegex, Value: `(?i)buy\s+now`, Action: contentfilters.ActionHide},
+		{Type: contentfilters.TypeAuthor, Value: "author1", Action: contentfilters.ActionHide},
+		{Type: contentfilters.TypeBoard, Value: "board1", Action: contentfilters.ActionCollapse},
+	})
+	if a := set.Match("", "", "Contains a SPOILER of the ending"); a != contentfilters.ActionCollapse {
+		t.Errorf("The keyword should have matched regardless of case. Action: %s", a)
+	}
+	if a := set.Match("", "", "Title", "BUY   now!"); a != contentfilters.ActionHide {
+		t.Errorf("The pattern should have matched the second text. Action: %s", a)
+	}
+	if a := set.Match("board2", "author1"); a != contentfilters.ActionHide {
+		t.Errorf("The author should have matched. Action: %s", a)
+	}
+	if a := set.Match("board1", "author2", "spoiler"); a != contentfilters.ActionCollapse {
+		t.Errorf("The board should have matched. Action: %s", a)
+	}
+	if a := set.Match("board1", "", "buy now"); a != contentfilters.ActionHide {
+		t.Errorf("Hiding should win over collapsing. Action: %s", a)
+	}
+}
+
+func TestMatch_Fail_NoMatch(t *testing.T) {
+	set := contentfilters.NewSet([]contentfilters.Filter{
+		{Type: contentfilters.TypeKeyword, Value: "spoiler", Action: contentfilters.ActionHide},
+		{Type: contentfilters.TypeAuthor, Value: "author1", Action: contentfilters.ActionHide},
+		{Type: contentfilters.TypeRegex, Value: "([", Action: contentfilters.ActionHide},
+	})
+	if a := set.Match("board1", "author2", "Nothing to see here"); a != "" {
+		t.Errorf("Nothing should have matched. Action: %s", a)
+	}
+	empty := contentfilters.NewSet([]contentfilters.Filter{})
+	if a := empty.Match("board1", "author1", "spoiler"); a != "" {
+		t.Errorf("An empty set should match nothing. Action: %s", a)
+	}
+}
+
+func TestAdd_Fail_Invalid(t *testing.T) {
+	invalid := []contentfilters.Filter{
+		{Type: contentfilters.TypeKeyword, Value: ""},
+		{Type: "colour", Value: "red"},
+		{Type: contentfilters.TypeRegex, Value: "(["},
+		{Type: contentfilters.TypeKeyword, Value: "spoiler", Action: "delete"},
+	}
+	for _, f := range invalid {
+		if err := contentfilters.Add(f); err == nil {
+			t.Errorf("This filter should have been refused. Filter: %#v", f)
+		}
+	}
+}
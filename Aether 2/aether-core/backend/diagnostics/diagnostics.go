@@ -0,0 +1,232 @@
+// Backend > Diagnostics
+// This file checks the state of the node before it starts, and repairs what can be repaired without the operator.
+
+package diagnostics
+
+import (
+	"aether-core/backend/migration"
+	"aether-core/backend/responsegenerator"
+	"aether-core/io/persistence"
+	"aether-core/services/diskspace"
+	"aether-core/services/globals"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Check is the outcome of a single diagnostic.
+type Check struct {
+	Name       string `json:"name"`
+	Ok         bool   `json:"ok"`
+	Detail     string `json:"detail"`
+	Repairable bool   `json:"repairable"` // Repair can fix this without the operator.
+	repair     func() (string, error)
+}
+
+// Report is the outcome of all diagnostics.
+type Report struct {
+	Checks []Check `json:"checks"`
+}
+
+// Ok is true if every check passed.
+func (r *Report) Ok() bool {
+	for i, _ := range r.Checks {
+		if !r.Checks[i].Ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Repairable is true if any of the failed checks can be repaired.
+func (r *Report) Repairable() bool {
+	for i, _ := range r.Checks {
+		if !r.Checks[i].Ok && r.Checks[i].Repairable {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the report to be printed to the terminal.
+func (r *Report) String() string {
+	var b strings.Builder
+	b.WriteString("Diagnostics:\n")
+	for i, _ := range r.Checks {
+		c := r.Checks[i]
+		status := "OK"
+		if !c.Ok && c.Repairable {
+			status = "REPAIRABLE"
+		} else if !c.Ok {
+			status = "FAILED"
+		}
+		b.WriteString(fmt.Sprintf("  [%s] %s: %s\n", status, c.Name, c.Detail))
+	}
+	return b.String()
+}
+
+// Run runs all diagnostics. It does not change anything. It has to run before the servers start, otherwise the ports the node uses are reported as taken.
+func Run() Report {
+	var r Report
+	r.Checks = append(r.Checks, checkSchema())
+	for _, respType := range responsegenerator.CacheEntityTypes() {
+		r.Checks = append(r.Checks, checkCacheIndex(respType))
+	}
+	r.Checks = append(r.Checks, checkStaleResponses())
+	r.Checks = append(r.Checks, checkIdentity())
+	for i, _ := range globals.Listeners {
+		r.Checks = append(r.Checks, checkListener(globals.Listeners[i]))
+	}
+	r.Checks = append(r.Checks, checkDiskSpace())
+	return r
+}
+
+// Repair repairs the failed checks that can be repaired, and returns what it did for each.
+func Repair(r *Report) []string {
+	var results []string
+	for i, _ := range r.Checks {
+		c := r.Checks[i]
+		if c.Ok || !c.Repairable {
+			continue
+		}
+		result, err := c.repair()
+		if err != nil {
+			results = append(results, fmt.Sprintf("%s: The repair failed. Error: %s", c.Name, err))
+			continue
+		}
+		results = append(results, fmt.Sprintf("%s: %s", c.Name, result))
+	}
+	return results
+}
+
+func checkSchema() Check {
+	c := Check{Name: "Database schema"}
+	problems, err := persistence.CheckSchema()
+	if err != nil {
+		c.Detail = fmt.Sprintf("The schema could not be read. Error: %s", err)
+		return c
+	}
+	if len(problems) == 0 {
+		c.Ok = true
+		c.Detail = "The database matches the schema of this version."
+		return c
+	}
+	var details []string
+	for _, p := range problems {
+		details = append(details, p.String())
+	}
+	c.Detail = strings.Join(details, " ")
+	c.Repairable = true
+	c.repair = func() (string, error) {
+		err := persistence.RepairSchema(problems)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Added %d missing tables and columns.", len(problems)), nil
+	}
+	return c
+}
+
+func checkCacheIndex(respType string) Check {
+	c := Check{Name: fmt.Sprintf("Cache index of %s", respType)}
+	report, err := responsegenerator.InspectCacheIndex(respType)
+	if err != nil {
+		c.Detail = fmt.Sprintf("The cache index could not be inspected. Error: %s", err)
+		return c
+	}
+	if !report.NeedsRepair() {
+		c.Ok = true
+		c.Detail = "The index agrees with the cache folders."
+		return c
+	}
+	if report.IndexRebuilt {
+		c.Detail = "The index can't be read. "
+	}
+	c.Detail = fmt.Sprintf("%sEntries without a cache folder: %d, Cache folders not in the index: %d", c.Detail, len(report.RemovedEntries), len(report.RemovedFolders))
+	c.Repairable = true
+	c.repair = func() (string, error) {
+		report, err := responsegenerator.RepairCacheIndex(respType)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Rebuilt the index. Removed entries: %d, Removed folders: %d", len(report.RemovedEntries), len(report.RemovedFolders)), nil
+	}
+	return c
+}
+
+func checkStaleResponses() Check {
+	c := Check{Name: "POST responses"}
+	stale, err := responsegenerator.StaleResponses()
+	if err != nil {
+		c.Detail = fmt.Sprintf("The responses could not be listed. Error: %s", err)
+		return c
+	}
+	if len(stale) == 0 {
+		c.Ok = true
+		c.Detail = "There are no expired responses."
+		return c
+	}
+	c.Detail = fmt.Sprintf("There are %d expired responses.", len(stale))
+	c.Repairable = true
+	c.repair = func() (string, error) {
+		count, err := responsegenerator.DeleteStaleResponses()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Deleted %d expired responses.", count), nil
+	}
+	return c
+}
+
+func checkIdentity() Check {
+	c := Check{Name: "Key file"}
+	exists, err := migration.CheckIdentity()
+	if err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	c.Ok = true
+	if exists {
+		c.Detail = "The saved identity is intact."
+	} else {
+		c.Detail = "There is no saved identity. The node runs with the key generated at start."
+	}
+	return c
+}
+
+// checkListener checks that at least one port in the range of the listener is free.
+func checkListener(l globals.Listener) Check {
+	c := Check{Name: fmt.Sprintf("Listener %s", l.Name)}
+	for port := int(l.PortStart); port <= int(l.PortEnd); port++ {
+		ln, err := net.Listen("tcp", net.JoinHostPort(l.Interface, fmt.Sprint(port)))
+		if err != nil {
+			continue
+		}
+		ln.Close()
+		c.Ok = true
+		c.Detail = fmt.Sprintf("Port %d is available.", port)
+		return c
+	}
+	c.Detail = fmt.Sprintf("No port between %d and %d is available on %s.", l.PortStart, l.PortEnd, l.Interface)
+	return c
+}
+
+func checkDiskSpace() Check {
+	c := Check{Name: "Disk space"}
+	free, err := diskspace.Free(globals.UserDirectory)
+	if err == diskspace.ErrUnsupported {
+		c.Ok = true
+		c.Detail = err.Error()
+		return c
+	} else if err != nil {
+		c.Detail = fmt.Sprintf("The free disk space could not be read. Error: %s", err)
+		return c
+	}
+	c.Detail = fmt.Sprintf("%d MB free.", free/(1024*1024))
+	if free < globals.DiagnosticsMinFreeDiskBytes {
+		c.Detail = fmt.Sprintf("%s At least %d MB are needed.", c.Detail, globals.DiagnosticsMinFreeDiskBytes/(1024*1024))
+		return c
+	}
+	c.Ok = true
+	return c
+}
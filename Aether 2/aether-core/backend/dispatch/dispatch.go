@@ -8,6 +8,8 @@ import (
 	"aether-core/io/persistence"
 	"aether-core/services/globals"
 	"aether-core/services/logging"
+	"aether-core/services/peerrules"
+	"aether-core/services/scheduling"
 	"fmt"
 	// "strings"
 	// "errors"
@@ -57,14 +59,28 @@ func processExclusions(excl *map[*interface{}]time.Time) []api.Address {
 	return excludedAddressesToReturn
 }
 
+// LiveSyncBounds gives the bounds of the interval of the live dispatcher from the settings. With adaptive sync disabled, the interval is fixed at the base.
+func LiveSyncBounds() scheduling.AdaptiveBounds {
+	b := scheduling.AdaptiveBounds{
+		Base: globals.SyncInterval,
+		Min:  globals.SyncIntervalMin,
+		Max:  globals.SyncIntervalMax,
+		Busy: globals.SyncBusyEntities,
+	}
+	if !globals.AdaptiveSyncEnabled {
+		b.Min, b.Max = b.Base, b.Base
+	}
+	return b
+}
+
 /*
 Dispatcher is the big thing here.
 One thing to keep thinking about, this behaviour of the dispatch to get one online node that is not excluded, might actually create 'islands' that only connect to each other.
 To be able to diagnose this, I might need to build a tool that visualises the connections between the nodes.. Just to make sure that there are no islands.
 */
 
-// Dispatcher is the loop that controls the outbound connections.
-func Dispatcher(addressType uint8) {
+// Dispatcher is the loop that controls the outbound connections. It gives how many entities arrived in the sync it made, or -1 if it could not find a remote to sync with, which says nothing about how busy the network is.
+func Dispatcher(addressType uint8) int {
 	logging.Log(1, fmt.Sprintf("Dispatch for AddressType: %d has started.", addressType))
 	defer logging.Log(1, fmt.Sprintf("Dispatch for AddressType: %d is complete.", addressType))
 	/*
@@ -82,19 +98,24 @@ func Dispatcher(addressType uint8) {
 		/*
 			If there are any online addresses, connect to the first one.
 		*/
-		err2 := Sync(onlineAddresses[0])
+		arrived, err2 := Sync(onlineAddresses[0])
 		if err2 != nil {
 			logging.Log(1, fmt.Sprintf("Sync call from Dispatcher failed. Address: %#v, Error: %#v", onlineAddresses[0], err2))
+			if api.IsLimitError(err2) {
+				recordViolation(onlineAddresses[0], err2)
+			}
 		}
 		/*
 			After the sync is complete, add it to the exclusions list.
 		*/
 		addrsAsIface := interface{}(onlineAddresses[0])
 		globals.DispatcherExclusions[&addrsAsIface] = time.Now()
+		return arrived
 	} else {
 		logging.Log(1, "Dispatcher could not find any online addresses. It will a)trigger the AddressScanner so it can convert more addresses to known addresses, rendering them eligible to be used by Dispatcher in the next iteration, and b) Quit this iteration of Dispatcher without further processing after AddressScanner completes.")
 		AddressScanner()
 	}
+	return -1
 }
 
 // sameAddress checks if the addresses given are the same
@@ -131,6 +152,17 @@ func eliminateExcludedAddressesFromList(addrs *[]api.Address, excls *[]api.Addre
 	return cleanList
 }
 
+// eliminateBlockedAddressesFromList returns the addresses that the peer rules allow. The node ids of these are not known yet; that is checked again when the sync reaches the remote.
+func eliminateBlockedAddressesFromList(addrs *[]api.Address) []api.Address {
+	var cleanList []api.Address
+	for _, a := range *addrs {
+		if peerrules.Allowed(string(a.Location), "") {
+			cleanList = append(cleanList, a)
+		}
+	}
+	return cleanList
+}
+
 // TODO: We need tests for this. Kind of hard to mock as it requires actually online nodes. But it does have a few things that can end up a bit hairy.
 // GetOnlineAddresses goes through the addresses database and finds the requested amount of live nodes and provides it back. It provides a useful feature with exclusions, in that you can provide a list of addresses that you want to exclude (perhaps, addresses you connected recently, and that you don't want to connect for a while).
 func GetOnlineAddresses(noOfOnlineAddressesRequested int, exclude []api.Address, addressType uint8) ([]api.Address, error) {
@@ -157,6 +189,10 @@ func GetOnlineAddresses(noOfOnlineAddressesRequested int, exclude []api.Address,
 		persistence.InsertOrUpdateAddresses(&updatedAddresses)
 		// Check for the exclusions, so that the address we have isn't what we want to exclude.
 		cleanedUpdatedAddresses := eliminateExcludedAddressesFromList(&updatedAddresses, &exclude)
+		// And leave out the ones that sent us more than the inbound limits allow.
+		cleanedUpdatedAddresses = eliminatePenalisedAddressesFromList(&cleanedUpdatedAddresses)
+		// And the ones the operator has blocked.
+		cleanedUpdatedAddresses = eliminateBlockedAddressesFromList(&cleanedUpdatedAddresses)
 		// Add the found online addresses to the result set,
 		onlineAddresses = append(onlineAddresses, cleanedUpdatedAddresses...)
 		// Set the offset by the page size, so you get the next 'page' from the database
@@ -254,7 +290,7 @@ func Pinger(fullAddressesSlice []api.Address) []api.Address {
 func Ping(addr api.Address, processedAddresses chan<- api.Address) {
 	logging.Log(2, fmt.Sprintf("Connection attempt started: %v:%v", addr.Location, addr.Port))
 	var blankAddr api.Address
-	updatedAddr, _, _, err := Check(addr)
+	updatedAddr, _, _, _, err := CheckEndpoints(addr)
 	if err != nil {
 		updatedAddr = blankAddr
 		logging.Log(2, err)
@@ -1,5 +1,13 @@
 package dispatch_test
 
+import (
+	"aether-core/backend/dispatch"
+	"aether-core/services/globals"
+	"os"
+	"reflect"
+	"testing"
+)
+
 // This includes the tests for dispatch. The important part that needs to be checked is the online finder, since it has the most possible paths.
 
 /*
@@ -24,3 +32,42 @@ fmt.Printf("%#v\n", addresses)
 
 
 */
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+}
+
+func teardown() {
+}
+
+// Tests
+
+func TestSyncOrder_Success(t *testing.T) {
+	types := []string{"boards", "threads", "posts", "votes", "addresses", "keys", "truststates", "tombstones"}
+	expected := []string{"keys", "boards", "threads", "posts", "votes", "truststates", "tombstones", "addresses"}
+	if order := dispatch.SyncOrder(types); !reflect.DeepEqual(order, expected) {
+		t.Errorf("Unexpected sync order. Order: %v", order)
+	}
+	defaults := globals.SyncPriorities
+	globals.SyncPriorities = map[string]int{"votes": 100}
+	defer func() { globals.SyncPriorities = defaults }()
+	expected2 := []string{"votes", "addresses", "boards", "keys", "posts", "threads", "tombstones", "truststates"}
+	if order := dispatch.SyncOrder(types); !reflect.DeepEqual(order, expected2) {
+		t.Errorf("The configured priorities should apply, and the rest should come in the order of their names. Order: %v", order)
+	}
+}
+
+func TestSyncOrder_Fail_Empty(t *testing.T) {
+	if order := dispatch.SyncOrder(nil); len(order) != 0 {
+		t.Errorf("Nothing should come out of nothing. Order: %v", order)
+	}
+}
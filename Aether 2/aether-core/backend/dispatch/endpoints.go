@@ -0,0 +1,130 @@
+// Backend > Dispatch > Endpoints
+// This file decides in which order the endpoints of a multi-endpoint address are tried, and keeps track of the health of each endpoint.
+
+package dispatch
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+type endpointHealth struct {
+	consecutiveFailures int
+	lastFailure         time.Time
+	lastSuccess         time.Time
+}
+
+var endpointHealthLock sync.Mutex
+var endpointHealthMap = make(map[string]*endpointHealth)
+
+func endpointKey(a api.Address) string {
+	return fmt.Sprint(a.Location, "/", a.Sublocation, ":", a.Port)
+}
+
+func recordEndpointResult(a api.Address, success bool) {
+	endpointHealthLock.Lock()
+	defer endpointHealthLock.Unlock()
+	key := endpointKey(a)
+	h, ok := endpointHealthMap[key]
+	if !ok {
+		h = &endpointHealth{}
+		endpointHealthMap[key] = h
+	}
+	if success {
+		h.consecutiveFailures = 0
+		h.lastSuccess = time.Now()
+	} else {
+		h.consecutiveFailures++
+		h.lastFailure = time.Now()
+	}
+}
+
+// isUnhealthy checks whether the endpoint failed enough times recently that it should be tried last.
+func isUnhealthy(a api.Address) bool {
+	endpointHealthLock.Lock()
+	defer endpointHealthLock.Unlock()
+	h, ok := endpointHealthMap[endpointKey(a)]
+	if !ok {
+		return false
+	}
+	return h.consecutiveFailures >= globals.EndpointFailureThreshold && time.Since(h.lastFailure) < globals.EndpointFailureBackoff
+}
+
+// dialable checks whether this node can connect to the endpoint. There is no TLS or onion transport yet, so those endpoints are advertised but not dialed.
+func dialable(e api.AddressEndpoint) bool {
+	if e.TLS || e.LocationType == api.LocationTypeOnion {
+		return false
+	}
+	return len(e.Location) > 0 && e.Port > 0
+}
+
+// orderedEndpoints returns the ways to reach the node of the given address, best first. The address itself comes first, then its endpoints by priority. The endpoints that have been failing are moved to the end, keeping their relative order.
+func orderedEndpoints(a api.Address) []api.Address {
+	endpoints := make([]api.AddressEndpoint, len(a.Endpoints))
+	copy(endpoints, a.Endpoints)
+	sort.SliceStable(endpoints, func(i, j int) bool { return endpoints[i].Priority < endpoints[j].Priority })
+	candidates := []api.Address{a}
+	seen := map[string]bool{endpointKey(a): true}
+	for _, e := range endpoints {
+		if !dialable(e) {
+			continue
+		}
+		c := a
+		c.Location = e.Location
+		c.Sublocation = e.Sublocation
+		c.Port = e.Port
+		c.LocationType = e.LocationType
+		if seen[endpointKey(c)] {
+			continue
+		}
+		seen[endpointKey(c)] = true
+		candidates = append(candidates, c)
+	}
+	var healthy, unhealthy []api.Address
+	for _, c := range candidates {
+		if isUnhealthy(c) {
+			unhealthy = append(unhealthy, c)
+		} else {
+			healthy = append(healthy, c)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// CheckEndpoints runs Check over the endpoints of the address in order, until one of them responds. It returns the same as Check, plus the endpoint that worked. The address data that is returned is always keyed by the primary location of the address, so that a node reached through an alternative endpoint is not saved as a second node.
+func CheckEndpoints(a api.Address) (api.Address, bool, api.ApiResponse, api.Address, error) {
+	var lastErr error
+	for _, c := range orderedEndpoints(a) {
+		addr, static, apiResp, err := Check(c)
+		recordEndpointResult(c, err == nil)
+		if err != nil {
+			logging.Log(2, fmt.Sprintf("Endpoint did not respond. Endpoint: %s, Error: %s", endpointKey(c), err))
+			lastErr = err
+			continue
+		}
+		if endpointKey(c) != endpointKey(a) {
+			addr.Location = a.Location
+			addr.Sublocation = a.Sublocation
+			addr.Port = a.Port
+			if ip := net.ParseIP(string(a.Location)); ip != nil && ip.To4() == nil {
+				addr.LocationType = api.LocationTypeIPv6
+			} else {
+				addr.LocationType = api.LocationTypeIPv4
+			}
+		}
+		// The remote doesn't know whether it is on our local network. Keep what we know.
+		addr.Local = a.Local
+		return addr, static, apiResp, c, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New(fmt.Sprintf("This address has no endpoints that can be dialed. Address: %#v", a))
+	}
+	return api.Address{}, false, api.ApiResponse{}, a, lastErr
+}
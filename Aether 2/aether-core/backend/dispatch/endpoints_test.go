@@ -0,0 +1,104 @@
+// This test is in the package itself rather than in dispatch_test, since the endpoint order and health are not exported.
+
+package dispatch
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"testing"
+	"time"
+)
+
+func resetEndpointHealth() {
+	endpointHealthLock.Lock()
+	endpointHealthMap = make(map[string]*endpointHealth)
+	endpointHealthLock.Unlock()
+}
+
+func multiEndpointAddress() api.Address {
+	a := api.Address{Location: "10.0.0.1", LocationType: api.LocationTypeIPv4, Port: 8000}
+	a.Endpoints = []api.AddressEndpoint{
+		api.AddressEndpoint{Location: "10.0.0.3", LocationType: api.LocationTypeIPv4, Port: 8000, Priority: 2},
+		api.AddressEndpoint{Location: "node.example.com", LocationType: api.LocationTypeIPv4, Port: 443, TLS: true, Priority: 0},
+		api.AddressEndpoint{Location: "abc.onion", LocationType: api.LocationTypeOnion, Port: 80, Priority: 0},
+		api.AddressEndpoint{Location: "10.0.0.2", LocationType: api.LocationTypeIPv4, Port: 8000, Priority: 1},
+		api.AddressEndpoint{Location: "10.0.0.1", LocationType: api.LocationTypeIPv4, Port: 8000, Priority: 0},
+	}
+	return a
+}
+
+func locationsOf(addrs []api.Address) []string {
+	var locs []string
+	for _, a := range addrs {
+		locs = append(locs, string(a.Location))
+	}
+	return locs
+}
+
+func TestOrderedEndpoints_Success(t *testing.T) {
+	resetEndpointHealth()
+	// The address comes first, then the dialable endpoints by priority. The TLS and onion ones, and the one that is the address itself, are left out.
+	locs := locationsOf(orderedEndpoints(multiEndpointAddress()))
+	if len(locs) != 3 || locs[0] != "10.0.0.1" || locs[1] != "10.0.0.2" || locs[2] != "10.0.0.3" {
+		t.Errorf("Unexpected endpoint order. Order: %v", locs)
+	}
+}
+
+func TestOrderedEndpoints_Success_UnhealthyLast(t *testing.T) {
+	resetEndpointHealth()
+	defer resetEndpointHealth()
+	a := multiEndpointAddress()
+	for i := 0; i < globals.EndpointFailureThreshold; i++ {
+		recordEndpointResult(a, false)
+	}
+	locs := locationsOf(orderedEndpoints(a))
+	if len(locs) != 3 || locs[0] != "10.0.0.2" || locs[1] != "10.0.0.3" || locs[2] != "10.0.0.1" {
+		t.Errorf("The failing endpoint should have been moved to the end. Order: %v", locs)
+	}
+}
+
+func TestOrderedEndpoints_Success_NoEndpoints(t *testing.T) {
+	resetEndpointHealth()
+	a := api.Address{Location: "10.0.0.1", Port: 8000}
+	if locs := locationsOf(orderedEndpoints(a)); len(locs) != 1 || locs[0] != "10.0.0.1" {
+		t.Errorf("An address without endpoints should only give itself. Order: %v", locs)
+	}
+}
+
+func TestIsUnhealthy_Success(t *testing.T) {
+	resetEndpointHealth()
+	defer resetEndpointHealth()
+	a := api.Address{Location: "10.0.0.1", Port: 8000}
+	if isUnhealthy(a) {
+		t.Errorf("An endpoint that was never tried should not be unhealthy.")
+	}
+	for i := 0; i < globals.EndpointFailureThreshold-1; i++ {
+		recordEndpointResult(a, false)
+	}
+	if isUnhealthy(a) {
+		t.Errorf("An endpoint under the failure threshold should not be unhealthy.")
+	}
+	recordEndpointResult(a, false)
+	if !isUnhealthy(a) {
+		t.Errorf("An endpoint at the failure threshold should be unhealthy.")
+	}
+	recordEndpointResult(a, true)
+	if isUnhealthy(a) {
+		t.Errorf("A success should reset the failures of an endpoint.")
+	}
+}
+
+func TestIsUnhealthy_Fail_BackoffOver(t *testing.T) {
+	resetEndpointHealth()
+	defer resetEndpointHealth()
+	a := api.Address{Location: "10.0.0.1", Port: 8000}
+	for i := 0; i < globals.EndpointFailureThreshold; i++ {
+		recordEndpointResult(a, false)
+	}
+	endpointHealthLock.Lock()
+	endpointHealthMap[endpointKey(a)].lastFailure = time.Now().Add(-globals.EndpointFailureBackoff - time.Minute)
+	endpointHealthLock.Unlock()
+	if isUnhealthy(a) {
+		t.Errorf("An endpoint whose last failure is older than the backoff should be tried in its order again.")
+	}
+}
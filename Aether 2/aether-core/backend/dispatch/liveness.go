@@ -0,0 +1,30 @@
+// Backend > Dispatch > Liveness
+// This file deletes the addresses that haven't been reached for longer than the purge window, and tells how many are demoted. It runs after the address scanner, so that the addresses the scanner just reached are not counted as dead.
+
+package dispatch
+
+import (
+	"aether-core/io/persistence"
+	"aether-core/services/logging"
+	"fmt"
+)
+
+// DecayAddresses deletes the dead addresses, and logs how many are demoted.
+func DecayAddresses() {
+	if cutoff := persistence.AddressPurgeCutoff(); cutoff > 0 {
+		deleted, err := persistence.DeleteDeadAddresses(cutoff)
+		if err != nil {
+			logging.Log(1, fmt.Sprintf("The dead addresses could not be deleted. Error: %s", err))
+		} else if deleted > 0 {
+			logging.Log(1, fmt.Sprintf("The addresses that were not reached for too long were deleted. Addresses: %d", deleted))
+		}
+	}
+	if cutoff := persistence.AddressLivenessCutoff(); cutoff > 0 {
+		demoted, err := persistence.CountDemotedAddresses(cutoff)
+		if err != nil {
+			logging.Log(1, fmt.Sprintf("The demoted addresses could not be counted. Error: %s", err))
+			return
+		}
+		logging.Log(2, fmt.Sprintf("The addresses that were not reached for a while are not given to the remotes. Addresses: %d", demoted))
+	}
+}
@@ -0,0 +1,123 @@
+// Backend > Dispatch > Orphans
+// This file asks remotes for the parents that the frontend is waiting for, such as the board of a thread that arrived before it. See the orphans package.
+
+package dispatch
+
+import (
+	"aether-core/backend/events"
+	"aether-core/backend/orphans"
+	"aether-core/backend/responsegenerator"
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"aether-core/services/verify"
+	"errors"
+	"fmt"
+)
+
+// FetchMissingParents asks an online remote for the wanted parents by fingerprint, and saves the ones that arrive.
+func FetchMissingParents() {
+	want := orphans.Wanted()
+	if len(want) == 0 {
+		return
+	}
+	onlineAddresses, err := GetOnlineAddresses(1, []api.Address{}, 2)
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("Missing parents could not be asked for. Error: %s", err))
+		return
+	}
+	if len(onlineAddresses) == 0 {
+		logging.Log(2, "Missing parents could not be asked for, because there are no online addresses.")
+		return
+	}
+	_, static, _, a, err2 := CheckEndpoints(onlineAddresses[0])
+	if err2 != nil {
+		logging.Log(1, fmt.Sprintf("Missing parents could not be asked for. Address: %s:%d, Error: %s", onlineAddresses[0].Location, onlineAddresses[0].Port, err2))
+		return
+	}
+	if static {
+		// Static nodes can't respond to POST requests. The parents will be asked for again.
+		return
+	}
+	var entityTypes []string
+	for t, _ := range want {
+		entityTypes = append(entityTypes, t)
+	}
+	// Boards first, so that the threads that arrive have their boards.
+	for _, entityType := range SyncOrder(entityTypes) {
+		fps := want[entityType]
+		for start := 0; start < len(fps); start += globals.OrphanFetchBatchSize {
+			end := start + globals.OrphanFetchBatchSize
+			if end > len(fps) {
+				end = len(fps)
+			}
+			err3 := fetchParents(a, entityType, fps[start:end])
+			if err3 != nil {
+				logging.Log(1, fmt.Sprintf("Missing parents could not be fetched. Address: %s:%d, Entity type: %s, Error: %s", a.Location, a.Port, entityType, err3))
+				if api.IsLimitError(err3) {
+					recordViolation(a, err3)
+				}
+				break
+			}
+		}
+	}
+}
+
+func fetchParents(a api.Address, entityType string, fps []api.Fingerprint) error {
+	apiReq := responsegenerator.GeneratePrefilledApiResponse()
+	var values []string
+	for _, fp := range fps {
+		values = append(values, string(fp))
+	}
+	apiReq.Filters = append(apiReq.Filters, api.Filter{Type: "fingerprint", Values: values})
+	apiResp, err := api.GetPageBound(string(a.Location), string(a.Sublocation), a.Port, entityType, *apiReq)
+	if err != nil {
+		return err
+	}
+	if len(apiResp.Results) > 0 {
+		return errors.New(fmt.Sprintf("The remote responded with more than one page, which a batch of fingerprints should not need. Batch size: %d", len(fps)))
+	}
+	var resp api.Response
+	resp = api.InsertApiResponseToResponse(resp, apiResp)
+	// Only the parents that were asked for are taken. The rest arrive with the syncs.
+	resp = onlyWanted(resp, fps)
+	resp = api.FilterByTextPolicy(api.FilterByPolicy(verify.FilterByMinPoW(resp)))
+	iface := moveEntitiesToInterfacePack(&resp)
+	written, err2 := persistence.BatchInsertWritten(*iface, persistence.Source{Node: apiResp.NodeId, Via: "parents"})
+	if err2 != nil {
+		return err2
+	}
+	events.Publish(&resp, written)
+	responsegenerator.NoteIngested(&resp)
+	var arrived []api.Fingerprint
+	for i, _ := range resp.Boards {
+		arrived = append(arrived, resp.Boards[i].Fingerprint)
+	}
+	for i, _ := range resp.Threads {
+		arrived = append(arrived, resp.Threads[i].Fingerprint)
+	}
+	orphans.Arrived(arrived)
+	logging.Log(2, fmt.Sprintf("Missing parents arrived. Entity type: %s, Asked: %d, Arrived: %d", entityType, len(fps), len(arrived)))
+	return nil
+}
+
+// onlyWanted leaves only the boards and threads with the given fingerprints in the response.
+func onlyWanted(resp api.Response, fps []api.Fingerprint) api.Response {
+	set := make(map[api.Fingerprint]bool)
+	for _, fp := range fps {
+		set[fp] = true
+	}
+	var cleaned api.Response
+	for i, _ := range resp.Boards {
+		if set[resp.Boards[i].Fingerprint] {
+			cleaned.Boards = append(cleaned.Boards, resp.Boards[i])
+		}
+	}
+	for i, _ := range resp.Threads {
+		if set[resp.Threads[i].Fingerprint] {
+			cleaned.Threads = append(cleaned.Threads, resp.Threads[i])
+		}
+	}
+	return cleaned
+}
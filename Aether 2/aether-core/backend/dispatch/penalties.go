@@ -0,0 +1,78 @@
+// Backend > Dispatch > Penalties
+// This file keeps score of the remotes that sent more than the inbound limits allow. A remote that does it repeatedly is left out of dispatch for a while, so that it can't keep wasting our bandwidth.
+
+package dispatch
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type penalty struct {
+	violations    int
+	lastViolation time.Time
+}
+
+var penaltiesLock sync.Mutex
+var penaltiesMap = make(map[string]*penalty)
+
+// recordViolation scores down the remote for going over the inbound limits.
+func recordViolation(a api.Address, err error) {
+	penaltiesLock.Lock()
+	defer penaltiesLock.Unlock()
+	key := endpointKey(a)
+	p, ok := penaltiesMap[key]
+	if !ok || clock.Since(p.lastViolation) > globals.InboundViolationBackoff {
+		// Old violations are forgiven once the backoff passes.
+		p = &penalty{}
+		penaltiesMap[key] = p
+	}
+	p.violations++
+	p.lastViolation = clock.Now()
+	logging.LogSampled("dispatch", "inbound-limits", 1, fmt.Sprintf("The remote went over the inbound limits. Address: %s, Violations: %d, Error: %s", key, p.violations, err))
+}
+
+// RecordAbuse scores down a remote for abusing what we serve, such as scraping the database through the fingerprint filters. It counts the same as going over the inbound limits, so a remote that keeps doing it is not synced with either.
+func RecordAbuse(a api.Address, err error) {
+	recordViolation(a, err)
+}
+
+// isPenalised checks whether the remote went over the inbound limits enough times recently that it should not be synced with.
+func isPenalised(a api.Address) bool {
+	penaltiesLock.Lock()
+	defer penaltiesLock.Unlock()
+	p, ok := penaltiesMap[endpointKey(a)]
+	if !ok {
+		return false
+	}
+	return p.violations >= globals.InboundViolationThreshold && clock.Since(p.lastViolation) < globals.InboundViolationBackoff
+}
+
+// IsPenalisedHost checks whether any address at the host is penalised, whatever its port. The server uses it to serve the remotes that went over the limits last when it is loaded.
+func IsPenalisedHost(host string) bool {
+	penaltiesLock.Lock()
+	defer penaltiesLock.Unlock()
+	for key, p := range penaltiesMap {
+		if strings.HasPrefix(key, host+"/") && p.violations >= globals.InboundViolationThreshold && clock.Since(p.lastViolation) < globals.InboundViolationBackoff {
+			return true
+		}
+	}
+	return false
+}
+
+// eliminatePenalisedAddressesFromList returns the addresses that are not penalised.
+func eliminatePenalisedAddressesFromList(addrs *[]api.Address) []api.Address {
+	var cleanList []api.Address
+	for _, a := range *addrs {
+		if !isPenalised(a) {
+			cleanList = append(cleanList, a)
+		}
+	}
+	return cleanList
+}
@@ -0,0 +1,22 @@
+// Backend > Dispatch > Priority
+// This file gives the order in which the entity types are synced from a remote. A post is of no use until its board and the key of its author are there, so during the first sync the types the others depend on come first, and the partial data is usable much sooner.
+
+package dispatch
+
+import (
+	"aether-core/services/globals"
+	"sort"
+)
+
+// SyncOrder sorts the entity types by their sync priority, highest first. The types without a priority come last, in the order of their names.
+func SyncOrder(entityTypes []string) []string {
+	ordered := append([]string{}, entityTypes...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		pi, pj := globals.SyncPriorities[ordered[i]], globals.SyncPriorities[ordered[j]]
+		if pi != pj {
+			return pi > pj
+		}
+		return ordered[i] < ordered[j]
+	})
+	return ordered
+}
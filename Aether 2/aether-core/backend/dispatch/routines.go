@@ -4,19 +4,32 @@
 package dispatch
 
 import (
+	"aether-core/backend/events"
+	"aether-core/backend/notifications"
+	"aether-core/backend/ranking"
+	"aether-core/backend/replytree"
 	"aether-core/backend/responsegenerator"
+	"aether-core/backend/syncpolicy"
 	"aether-core/io/api"
 	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
 	"aether-core/services/logging"
+	"aether-core/services/membership"
+	"aether-core/services/peerrules"
+	"aether-core/services/syncprogress"
+	"aether-core/services/verify"
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// Sync is the core logic of a single connection. It pulls updates from a remote node and patches it to the current node.
-func Sync(a api.Address) error {
+// Sync is the core logic of a single connection. It pulls updates from a remote node and patches it to the current node. It gives how many entities arrived from the remote, which is what the adaptive sync interval goes by, with what arrived before an error counted too.
+func Sync(a api.Address) (int, error) {
+	arrived := 0
 	// --------------------
 	// Steps
 	// - Fetch /status GET to see if the node is online.
@@ -55,17 +68,25 @@ func Sync(a api.Address) error {
 	// }
 	// addr.Port = a.Port
 	// addr.LastOnline = api.Timestamp(time.Now().Unix())
-	logging.Log(1, fmt.Sprintf("SYNC STARTED with node: %s:%d", a.Location, a.Port))
-	defer logging.Log(1, fmt.Sprintf("SYNC COMPLETE with node: %s:%d", a.Location, a.Port))
-	addr, NODE_STATIC, apiResp, err := Check(a)
+	// The trace id goes into every request of this sync, so the remote logs its side of it under the same id.
+	traceId := logging.NewTraceId()
+	logging.LogTrace(traceId, 1, fmt.Sprintf("SYNC STARTED with node: %s:%d", a.Location, a.Port))
+	defer logging.LogTrace(traceId, 1, fmt.Sprintf("SYNC COMPLETE with node: %s:%d", a.Location, a.Port))
+	addr, NODE_STATIC, apiResp, reachedAt, err := CheckEndpoints(a)
 	if err != nil {
-		return err
+		return arrived, err
+	}
+	// From here on, talk to the remote through the endpoint that responded.
+	a = reachedAt
+	// Now that we know who the remote is, check it against the peer rules once more.
+	if !peerrules.Allowed(string(a.Location), string(apiResp.NodeId)) {
+		return arrived, errors.New(fmt.Sprintf("The remote is blocked by the peer rules. Node: %s, Address: %s:%d", apiResp.NodeId, a.Location, a.Port))
 	}
 	// FULLY TRUSTED ADDRESS ENTRY
 	// Anything here will be committed in and will write over existing data, since all of this data is either coming from a first-party remote, or from the client.
 	err3 := persistence.InsertOrUpdateAddress(addr)
 	if err3 != nil {
-		return err3
+		return arrived, err3
 	}
 
 	// - Check if there is a record of this node in the nodes table. If not so, create and commit.
@@ -79,11 +100,19 @@ func Sync(a api.Address) error {
 		err5 := persistence.InsertNode(n)
 		if err5 != nil {
 			// DB commit error.
-			return err5
+			return arrived, err5
 		}
 	} else if err4 != nil {
 		// We have an error in node query and it's not 'node not found'
-		return err4
+		return arrived, err4
+	}
+	// Ask the remote for a few peers we don't know yet. Static nodes can't respond to POST requests.
+	if !NODE_STATIC {
+		err6 := exchangePeers(a, traceId)
+		if err6 != nil {
+			// Peer exchange failing should not stop the sync.
+			logging.LogTrace(traceId, 1, fmt.Sprintf("Peer exchange failed. Address: %s:%d, Error: %s", a.Location, a.Port, err6))
+		}
 	}
 	// For every endpoint, hit the caches. If the node is not static, hit the POSTs too.
 	endpoints := map[string]api.Timestamp{
@@ -93,61 +122,68 @@ func Sync(a api.Address) error {
 		"votes":       n.VotesLastCheckin,
 		"addresses":   n.AddressesLastCheckin,
 		"keys":        n.KeysLastCheckin,
-		"truststates": n.TruststatesLastCheckin}
-	// endpoints := []string{"boards", "threads", "posts", "votes", "addresses", "keys", "truststates"}
-	logging.Log(1, fmt.Sprintf("SYNC:COMMIT STARTED with data from node: %s:%d", a.Location, a.Port))
-	for key, val := range endpoints {
+		"truststates": n.TruststatesLastCheckin,
+		"tombstones":  n.TombstonesLastCheckin}
+	// endpoints := []string{"boards", "threads", "posts", "votes", "addresses", "keys", "truststates", "tombstones"}
+	// The bookmarks are where the last sync with this remote ended, per entity type. They are saved as each entity type is done, while the checkins above are saved only when the whole sync is, so a bookmark can be ahead of its checkin.
+	bookmarks, errBm := persistence.ReadSyncBookmarks(apiResp.NodeId)
+	if errBm != nil {
+		logging.LogTrace(traceId, 1, fmt.Sprintf("The sync bookmarks could not be read. The sync will start from the checkins. Node: %s, Error: %s", apiResp.NodeId, errBm))
+	}
+	for key, _ := range endpoints {
+		if bm, ok := bookmarks[key]; ok && bm.Covered > endpoints[key] {
+			endpoints[key] = bm.Covered
+		}
+	}
+	logging.LogTrace(traceId, 1, fmt.Sprintf("SYNC:COMMIT STARTED with data from node: %s:%d", a.Location, a.Port))
+	peer := syncprogress.PeerKey(string(a.Location), a.Port)
+	defer syncprogress.EndAll(peer)
+	var keys []string
+	for key, _ := range endpoints {
+		keys = append(keys, key)
+	}
+	// What arrives in the POST responses goes into the digests of this sync, to be checked against the remote's at the end.
+	digests := api.NewDigestAccumulator()
+	for _, key := range SyncOrder(keys) {
+		val := endpoints[key]
+		if key == "votes" {
+			if boards, all := syncpolicy.FetchedBoards(); !all && len(boards) == 0 {
+				// The vote sync policies fetch the votes of no board. The checkin stays where it is, so that the votes are fetched from there if the policies change.
+				continue
+			}
+		}
+		syncprogress.Begin(peer, key)
 		// // GET
 		// Do an endpoint GET with the timestamp. (Mind that the timestamp is being provided into the GetEndpoint, it will only fetch stuff after that timestamp.)
-		resp, err6 := api.GetEndpoint(string(a.Location), string(a.Sublocation), a.Port, key, val)
+		bm := bookmarks[key]
+		resp, nextBm, err6 := api.GetEndpointFrom(string(a.Location), string(a.Sublocation), a.Port, key, api.EndpointBookmark{LastCache: bm.LastCache, LastCacheEnd: bm.LastCacheEnd, IndexETag: bm.IndexETag, Since: val})
 		if err6 != nil {
-			return errors.New(fmt.Sprintf("Getting GET Endpoint for this entity type failed. Endpoint type: %s, Error: %s", key, err6))
+			return arrived, errors.New(fmt.Sprintf("Getting GET Endpoint for this entity type failed. Endpoint type: %s, Error: %s", key, err6))
 		}
-		// Move the objects into an interface to prepare them to be committed.
-		iface := moveEntitiesToInterfacePack(&resp)
-		// Save the response to the database.
-		persistence.BatchInsert(*iface)
+		// Drop the entities that do not satisfy the local PoW policy, or are over the validation policy.
+		resp = syncpolicy.FilterFetched(api.FilterByTextPolicy(api.FilterByPolicy(verify.FilterByMinPoW(resp))))
+		arrived += commitFetched(&resp, persistence.Source{Node: apiResp.NodeId, Via: "cache", TraceId: traceId})
 		// Set the last checkin timestamp for each entity type to the beginning of this process. (We will update this later before committing the node checkin set based on the POST response receipts, if any)
 		endpoints[key] = apiResp.Timestamp
 		// GET portion of this sync is done. Now on to POST requests.
 
 		// // POST
-		// POST requests can have two types of responses. If the results of that POST request is few enough, the data might just be provided as a response to the post request directly. Or, if there are many pages of results, the remote saves these into a folder that is available for the next half hour or so, and sends back the link to that folder. The two cases below deal with this.
+		// Static nodes can't respond to POST requests, so what is newer than their last cache arrives in the next one.
 		if !NODE_STATIC {
-			// Generate the POST request.
-			// POST request is essentially an ApiResponse converted to JSON. This can have fields like:
-			// "filters": [
-			//  {"type":"timestamp", "values": ["0", "1483641920"]}
-			//  ]
-			// which allows us to filter. But if you create an empty request for POST to an entity endpoint, it will give you all the entities for that endpoint since the last cache generation, automatically. There are no filters required for that kind of query.
-			apiReq := responsegenerator.GeneratePrefilledApiResponse()
-			reqAsJson, jsonErr := responsegenerator.ConvertApiResponseToJson(apiReq)
-			if jsonErr != nil {
-				return jsonErr
-			}
-			postApiResp, err7 := api.GetPageRaw(string(a.Location), string(a.Sublocation), a.Port, key, "POST", reqAsJson) // Raw call instead of regular one because we need access to the inbound remote timestamp.
+			lastTs, postArrived, err7 := syncPOST(a, key, supports(a, apiResp, "cursor"), traceId, digests)
+			arrived += postArrived
 			if err7 != nil {
-				return errors.New(fmt.Sprintf("Getting POST Endpoint for this entity type failed. Endpoint type: %s, Error: %s", key, err7))
-			}
-			var postResp api.Response
-			postResp = api.InsertApiResponseToResponse(postResp, postApiResp)
-			// Now, check if this is an one-page response, or links to another location for a cache hit.
-			if len(postResp.CacheLinks) > 0 { // This response needed more than one page, so the remote split it into multiple pages, and saved it to a cache.
-				postResultResp, err8 := api.GetCache(string(a.Location), string(a.Sublocation), a.Port, postResp.CacheLinks[0].ResponseUrl) // There is only one if it's a prepared request.
-				if err8 != nil {
-					return errors.New(fmt.Sprintf("Getting Multi page POST Endpoint for this entity type failed. Endpoint type: %s, Error: %s", key, err8))
-				}
-				postresultIface := moveEntitiesToInterfacePack(&postResultResp)
-				persistence.BatchInsert(*postresultIface)
-			} else {
-				// This response is one page, so the result is embedded into the POST response itself. Simple.
-				postIface := moveEntitiesToInterfacePack(&postResp)
-				persistence.BatchInsert(*postIface)
+				return arrived, err7
 			}
-			endpoints[key] = postApiResp.Timestamp
+			endpoints[key] = lastTs
 		}
+		saveBookmark(apiResp.NodeId, key, nextBm, endpoints[key], traceId)
+		syncprogress.End(peer, key)
+	}
+	logging.LogTrace(traceId, 1, fmt.Sprintf("SYNC:COMMIT COMPLETE with data from node: %s:%d", a.Location, a.Port))
+	if !NODE_STATIC && globals.SessionDigestsEnabled && supports(a, apiResp, api.SessionDigestExtension) {
+		arrived += checkSession(a, supports(a, apiResp, "cursor"), traceId, digests)
 	}
-	logging.Log(1, fmt.Sprintf("SYNC:COMMIT COMPLETE with data from node: %s:%d", a.Location, a.Port))
 	// Both POST and GETs are committed into the database. We now need to save the Node LastCheckin timestamps into the database.
 	n.BoardsLastCheckin = endpoints["boards"]
 	n.ThreadsLastCheckin = endpoints["threads"]
@@ -156,11 +192,267 @@ func Sync(a api.Address) error {
 	n.AddressesLastCheckin = endpoints["addresses"]
 	n.KeysLastCheckin = endpoints["keys"]
 	n.TruststatesLastCheckin = endpoints["truststates"]
+	n.TombstonesLastCheckin = endpoints["tombstones"]
 	err9 := persistence.InsertNode(n)
 	if err9 != nil {
-		return err9
+		return arrived, err9
+	}
+	return arrived, nil
+}
+
+// saveBookmark saves where the sync of the entity type ended. A bookmark that can't be saved doesn't stop the sync; the next one starts from the one before.
+func saveBookmark(node api.Fingerprint, key string, bm api.EndpointBookmark, covered api.Timestamp, traceId string) {
+	err := persistence.InsertSyncBookmark(persistence.DbSyncBookmark{
+		Node:         node,
+		EntityType:   key,
+		LastCache:    bm.LastCache,
+		LastCacheEnd: bm.LastCacheEnd,
+		IndexETag:    bm.IndexETag,
+		Covered:      covered,
+		LastUpdate:   api.Timestamp(clock.Unix()),
+	})
+	if err != nil {
+		logging.LogTrace(traceId, 1, fmt.Sprintf("The sync bookmark could not be saved. Node: %s, Entity type: %s, Error: %s", node, key, err))
+	}
+}
+
+// hasExtension checks whether the remote has announced support for the given protocol extension.
+func hasExtension(apiResp api.ApiResponse, extension string) bool {
+	for _, ext := range apiResp.Address.Protocol.Extensions {
+		if ext == extension {
+			return true
+		}
+	}
+	return false
+}
+
+// supports checks whether the extension can be used with the remote. After a handshake, that is whether both sides announced it there. The remotes that don't take handshakes are gone by what they announced in their node responses.
+func supports(a api.Address, apiResp api.ApiResponse, extension string) bool {
+	if c, ok := api.ConnectionTo(string(a.Location), a.Port); ok {
+		return c.Has(extension)
+	}
+	return hasExtension(apiResp, extension)
+}
+
+// handshake exchanges handshakes with the remote, and keeps what they settled as the connection to it. The handshake of the remote has to be signed by the key of its node response, and sign back the nonce that was sent.
+func handshake(a api.Address, apiResp api.ApiResponse) error {
+	nonce := api.NewNonce()
+	local, err := responsegenerator.LocalHandshake(nonce)
+	if err != nil {
+		return errors.New(fmt.Sprintf("The handshake of this node could not be made. Error: %s", err))
+	}
+	apiReq := responsegenerator.GeneratePrefilledApiResponse()
+	apiReq.Handshake = &local
+	sentAt := clock.Now()
+	hsApiResp, err2 := api.GetPageBound(string(a.Location), string(a.Sublocation), a.Port, "handshake", *apiReq)
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The handshake with the remote failed. Address: %s:%d, Error: %s", a.Location, a.Port, err2))
+	}
+	if hsApiResp.Handshake == nil {
+		return errors.New(fmt.Sprintf("The remote announces the handshake, but did not send one. Node: %s", apiResp.NodeId))
+	}
+	remote := *hsApiResp.Handshake
+	if remote.NodeId != apiResp.NodeId {
+		return errors.New(fmt.Sprintf("The handshake is of another node than the node response. Node response: %s, Handshake: %s", apiResp.NodeId, remote.NodeId))
+	}
+	err3 := api.VerifyHandshake(remote, api.PinnedNodeKey(string(a.Location), a.Port), nonce)
+	if err3 != nil {
+		return err3
+	}
+	c := api.NewConnection(local, remote, sentAt, true)
+	err4 := c.CheckClock()
+	if err4 != nil {
+		return err4
+	}
+	api.SetConnection(string(a.Location), a.Port, c)
+	logging.Log(2, fmt.Sprintf("Handshake done with %s:%d. Extensions: %v, Clock offset: %ds", a.Location, a.Port, c.Extensions, c.ClockOffset))
+	return nil
+}
+
+// boardFilters gives the board filter for a POST request of the entity type, if the vote sync policies limit the votes fetched to some boards. The remotes that don't know the filter give the votes of every board, and the ones not wanted are dropped as they arrive.
+func boardFilters(key string) []api.Filter {
+	if key != "votes" {
+		return nil
+	}
+	boards, all := syncpolicy.FetchedBoards()
+	if all {
+		return nil
+	}
+	return []api.Filter{api.Filter{Type: "board", Values: boards}}
+}
+
+// syncPOST makes the POST requests of an entity type, and commits what arrives. It gives the timestamp of the remote the next sync should start from, and how many entities arrived. What arrives goes into the digests before anything is dropped from it, since the remote's digests are of what it sent.
+func syncPOST(a api.Address, key string, cursor bool, traceId string, digests *api.DigestAccumulator) (api.Timestamp, int, error) {
+	if cursor && key != "addresses" {
+		// The remote can give us pages one at a time with a cursor. These stay correct when the remote receives new entities mid-sync, and an interrupted sync can pick up from the last cursor.
+		lastTs, arrived, err := syncPOSTByCursor(a, key, traceId, digests)
+		if err != nil {
+			return lastTs, arrived, errors.New(fmt.Sprintf("Getting cursor POST Endpoint for this entity type failed. Endpoint type: %s, Error: %s", key, err))
+		}
+		return lastTs, arrived, nil
+	}
+	// POST requests can have two types of responses. If the results of that POST request is few enough, the data might just be provided as a response to the post request directly. Or, if there are many pages of results, the remote saves these into a folder that is available for the next half hour or so, and sends back the link to that folder. The two cases below deal with this.
+	// Generate the POST request.
+	// POST request is essentially an ApiResponse converted to JSON. This can have fields like:
+	// "filters": [
+	//  {"type":"timestamp", "values": ["0", "1483641920"]}
+	//  ]
+	// which allows us to filter. But if you create an empty request for POST to an entity endpoint, it will give you all the entities for that endpoint since the last cache generation, automatically. There are no filters required for that kind of query.
+	apiReq := responsegenerator.GeneratePrefilledApiResponse()
+	apiReq.TraceId = traceId
+	apiReq.Filters = append(apiReq.Filters, boardFilters(key)...)
+	// Results up to this size come back in the response itself, instead of as pages to download one by one. The older versions ignore this.
+	if globals.POSTInlinePreferredBytes > 0 {
+		apiReq.Filters = append(apiReq.Filters, api.Filter{Type: "max_inline", Values: []string{strconv.FormatInt(globals.POSTInlinePreferredBytes, 10)}})
+	}
+	postApiResp, err := api.GetPageBound(string(a.Location), string(a.Sublocation), a.Port, key, *apiReq) // Raw response instead of the regular one because we need access to the inbound remote timestamp.
+	if err != nil {
+		return 0, 0, errors.New(fmt.Sprintf("Getting POST Endpoint for this entity type failed. Endpoint type: %s, Error: %s", key, err))
+	}
+	var postResp api.Response
+	postResp = api.InsertApiResponseToResponse(postResp, postApiResp)
+	// Now, check if this is an one-page response, or links to another location for a cache hit.
+	if len(postResp.CacheLinks) > 0 { // This response needed more than one page, so the remote split it into multiple pages, and saved it to a cache.
+		postResultResp, err2 := api.GetPostResponseCache(string(a.Location), string(a.Sublocation), a.Port, postResp.CacheLinks) // There is a link for every page, and they all point to the same folder.
+		if err2 != nil {
+			return 0, 0, errors.New(fmt.Sprintf("Getting Multi page POST Endpoint for this entity type failed. Endpoint type: %s, Error: %s", key, err2))
+		}
+		postResp = postResultResp
+	}
+	digests.Add(postResp)
+	postResp = syncpolicy.FilterFetched(api.FilterByTextPolicy(api.FilterByPolicy(verify.FilterByMinPoW(postResp))))
+	return postApiResp.Timestamp, commitFetched(&postResp, persistence.Source{Node: postApiResp.NodeId, Via: "post", TraceId: traceId}), nil
+}
+
+// syncPOSTByCursor walks through the POST response of an entity type one page at a time, committing each page before asking for the next. It returns the timestamp of the first page, which is when the remote started serving this iteration, and how many entities arrived.
+func syncPOSTByCursor(a api.Address, key string, traceId string, digests *api.DigestAccumulator) (api.Timestamp, int, error) {
+	var firstTs api.Timestamp
+	arrived := 0
+	cursor := ""
+	for {
+		apiReq := responsegenerator.GeneratePrefilledApiResponse()
+		apiReq.TraceId = traceId
+		apiReq.Filters = append(apiReq.Filters, api.Filter{Type: "cursor", Values: []string{cursor}})
+		apiReq.Filters = append(apiReq.Filters, boardFilters(key)...)
+		postApiResp, err2 := api.GetPageBound(string(a.Location), string(a.Sublocation), a.Port, key, *apiReq)
+		if err2 != nil {
+			return firstTs, arrived, err2
+		}
+		if firstTs == 0 {
+			firstTs = postApiResp.Timestamp
+		}
+		var postResp api.Response
+		postResp = api.InsertApiResponseToResponse(postResp, postApiResp)
+		digests.Add(postResp)
+		postResp = syncpolicy.FilterFetched(api.FilterByTextPolicy(api.FilterByPolicy(verify.FilterByMinPoW(postResp))))
+		arrived += commitFetched(&postResp, persistence.Source{Node: postApiResp.NodeId, Via: "cursor", TraceId: traceId})
+		next := postApiResp.Pagination.NextCursor
+		if len(next) == 0 {
+			return firstTs, arrived, nil
+		}
+		if next == cursor {
+			return firstTs, arrived, errors.New(fmt.Sprintf("The remote returned the same cursor twice. Cursor: %s", cursor))
+		}
+		cursor = next
 	}
-	return nil // TODO: This could return something more informative, about the status of the sync that was just completed.
+}
+
+// fetchSessionDigests asks the remote for the digests of what it sent to the sync with the trace id.
+func fetchSessionDigests(a api.Address, traceId string) ([]api.SessionDigest, error) {
+	apiReq := responsegenerator.GeneratePrefilledApiResponse()
+	apiReq.TraceId = traceId
+	sessionApiResp, err := api.GetPageBound(string(a.Location), string(a.Sublocation), a.Port, "session", *apiReq)
+	if err != nil {
+		return nil, err
+	}
+	return sessionApiResp.SessionDigests, nil
+}
+
+// checkSession compares the digests of what arrived in the POST responses of the sync with the remote's digests of what it sent. The entity types that differ lost or changed something on the way, so they are fetched once more, as a sync of their own, and compared again. It gives how many entities arrived in the second fetch.
+func checkSession(a api.Address, cursor bool, traceId string, digests *api.DigestAccumulator) int {
+	remote, err := fetchSessionDigests(a, traceId)
+	if err != nil {
+		logging.LogTrace(traceId, 1, fmt.Sprintf("The session digests could not be fetched. Address: %s:%d, Error: %s", a.Location, a.Port, err))
+		return 0
+	}
+	differ := api.CompareDigests(digests.Digests(), remote)
+	if len(differ) == 0 {
+		logging.LogTrace(traceId, 2, fmt.Sprintf("The session digests match. Address: %s:%d", a.Location, a.Port))
+		return 0
+	}
+	logging.LogTrace(traceId, 1, fmt.Sprintf("The session digests do not match. The entity types that differ will be fetched again. Address: %s:%d, Entity types: %v", a.Location, a.Port, differ))
+	// The second fetch is a session of its own, so that its digests are only of what it fetched.
+	refetchId := fmt.Sprint(traceId, ".refetch")
+	refetched := api.NewDigestAccumulator()
+	arrived := 0
+	for _, key := range differ {
+		_, postArrived, err2 := syncPOST(a, key, cursor, refetchId, refetched)
+		arrived += postArrived
+		if err2 != nil {
+			logging.LogTrace(traceId, 1, fmt.Sprintf("The entity type could not be fetched again. Entity type: %s, Error: %s", key, err2))
+			return arrived
+		}
+	}
+	remote2, err3 := fetchSessionDigests(a, refetchId)
+	if err3 != nil {
+		logging.LogTrace(traceId, 1, fmt.Sprintf("The session digests of the second fetch could not be fetched. Address: %s:%d, Error: %s", a.Location, a.Port, err3))
+		return arrived
+	}
+	if differ2 := api.CompareDigests(refetched.Digests(), remote2); len(differ2) > 0 {
+		logging.LogTrace(traceId, 1, fmt.Sprintf("The session digests still do not match after the second fetch. Address: %s:%d, Entity types: %v", a.Location, a.Port, differ2))
+		return arrived
+	}
+	logging.LogTrace(traceId, 1, fmt.Sprintf("The session digests match after the second fetch. Address: %s:%d, Entity types: %v", a.Location, a.Port, differ))
+	return arrived
+}
+
+// exchangePeers asks the remote for a sample of its good addresses, listing the ones we already know so that we only get new ones, and saves the result.
+func exchangePeers(a api.Address, traceId string) error {
+	known, err := persistence.ReadPeerCandidates(0, globals.PexMaxExcludes)
+	if err != nil {
+		return err
+	}
+	var knownKeys []string
+	for i, _ := range known {
+		knownKeys = append(knownKeys, responsegenerator.PeerKey(known[i]))
+	}
+	apiReq := responsegenerator.GeneratePrefilledApiResponse()
+	apiReq.TraceId = traceId
+	apiReq.Filters = append(apiReq.Filters, api.Filter{Type: "known_peers", Values: knownKeys})
+	peersApiResp, err3 := api.GetPageBound(string(a.Location), string(a.Sublocation), a.Port, "peers", *apiReq)
+	if err3 != nil {
+		return err3
+	}
+	var peersResp api.Response
+	peersResp = api.InsertApiResponseToResponse(peersResp, peersApiResp)
+	if len(peersResp.Addresses) > globals.PexSampleSize {
+		// The remote is sending more than it should. Keep only what we would have sent.
+		peersResp.Addresses = peersResp.Addresses[:globals.PexSampleSize]
+	}
+	logging.LogTrace(traceId, 2, fmt.Sprintf("Peer exchange returned %d addresses from %s:%d", len(peersResp.Addresses), a.Location, a.Port))
+	var onlyAddresses api.Response
+	onlyAddresses.Addresses = peersResp.Addresses
+	iface := moveEntitiesToInterfacePack(&onlyAddresses)
+	return persistence.BatchInsert(*iface)
+}
+
+// commitFetched saves what arrived from a remote, with the remote as its source, and tells the parts of the backend that follow the new entities about it: the notifications of the local user, the rankings, the reply trees, the event subscribers and the live caches. It gives how many entities arrived, leaving out the addresses, which arrive whether or not there is anything new on the network, or 0 if they could not be committed.
+func commitFetched(resp *api.Response, source persistence.Source) int {
+	// Move the objects into an interface to prepare them to be committed.
+	iface := moveEntitiesToInterfacePack(resp)
+	// Save the response to the database. What couldn't be committed is not told about, since it is not there to be read.
+	written, err := persistence.BatchInsertWritten(*iface, source)
+	if err != nil {
+		logging.LogTrace(source.TraceId, 1, fmt.Sprintf("What arrived from a remote could not be committed. Source: %#v, Error: %s", source, err))
+		return 0
+	}
+	// Look for replies to and mentions of the local user in what we just committed.
+	notifications.Generate(resp)
+	ranking.Update(resp)
+	replytree.Update(resp)
+	events.Publish(resp, written)
+	responsegenerator.NoteIngested(resp)
+	return len(resp.Boards) + len(resp.Threads) + len(resp.Posts) + len(resp.Votes) + len(resp.Keys) + len(resp.Truststates) + len(resp.Tombstones)
 }
 
 func moveEntitiesToInterfacePack(r *api.Response) *[]interface{} {
@@ -179,6 +471,10 @@ func moveEntitiesToInterfacePack(r *api.Response) *[]interface{} {
 		carrier = append(carrier, resp.Votes[i])
 	}
 	for i, _ := range resp.Addresses {
+		// Addresses the operator has blocked are not taken in, so that they don't come back as sync candidates.
+		if !peerrules.Allowed(string(resp.Addresses[i].Location), "") {
+			continue
+		}
 		carrier = append(carrier, resp.Addresses[i])
 	}
 	for i, _ := range resp.Keys {
@@ -187,6 +483,9 @@ func moveEntitiesToInterfacePack(r *api.Response) *[]interface{} {
 	for i, _ := range resp.Truststates {
 		carrier = append(carrier, resp.Truststates[i])
 	}
+	for i, _ := range resp.Tombstones {
+		carrier = append(carrier, resp.Tombstones[i])
+	}
 	return &carrier
 }
 
@@ -209,25 +508,43 @@ func Check(a api.Address) (api.Address, bool, api.ApiResponse, error) {
 	/*
 		- The node is online. Ask for node data.
 	*/
+	// The key pinned in the last sync is forgotten, since the remote might have restarted with a new key since.
+	api.PinNodeKey(string(a.Location), a.Port, "")
 	apiResp, err2 := api.GetPageRaw(string(a.Location), string(a.Sublocation), a.Port, "node", "GET", []byte{})
 	if err2 != nil {
 		return api.Address{}, NODE_STATIC, apiResp, err2
 	}
+	// The pages of a remote that signs its responses have to be signed by the key of its node response for the rest of the sync.
+	if hasExtension(apiResp, api.SignedResponsesExtension) {
+		if len(apiResp.ResponseSignature) == 0 {
+			return api.Address{}, NODE_STATIC, apiResp, errors.New(fmt.Sprintf("The remote announces signed responses, but its node response is not signed. Node: %s", apiResp.NodeId))
+		}
+		api.PinNodeKey(string(a.Location), a.Port, apiResp.NodePublicKey)
+	}
+	// Do not sync with the nodes of other networks.
+	errNet := membership.Admit(apiResp.NetworkId, string(apiResp.NodeId), apiResp.MembershipProof)
+	if errNet != nil {
+		return api.Address{}, NODE_STATIC, apiResp, errNet
+	}
 	if apiResp.Address.Type == 255 {
 		NODE_STATIC = true
 	}
+	// What was settled with the remote in the last sync is forgotten, like its key, and settled again if the remote still takes handshakes.
+	api.ForgetConnection(string(a.Location), a.Port)
+	if !NODE_STATIC && globals.HandshakeEnabled && hasExtension(apiResp, api.HandshakeExtension) {
+		errHs := handshake(a, apiResp)
+		if errHs != nil {
+			return api.Address{}, NODE_STATIC, apiResp, errHs
+		}
+	}
 	/*
 		- If the node is not static, present yourself.
 	*/
 	var postApiResp api.ApiResponse
 	if !NODE_STATIC {
 		apiReq := responsegenerator.GeneratePrefilledApiResponse()
-		reqAsJson, jsonErr := responsegenerator.ConvertApiResponseToJson(apiReq)
-		if jsonErr != nil {
-			return api.Address{}, NODE_STATIC, apiResp, jsonErr
-		}
 		var err3 error
-		postApiResp, err3 = api.GetPageRaw(string(a.Location), string(a.Sublocation), a.Port, "node", "POST", reqAsJson) // Raw call instead of regular one because we need access to the inbound remote timestamp.
+		postApiResp, err3 = api.GetPageBound(string(a.Location), string(a.Sublocation), a.Port, "node", *apiReq) // Raw response instead of the regular one because we need access to the inbound remote timestamp.
 		if err3 != nil {
 			return api.Address{}, NODE_STATIC, apiResp, errors.New(fmt.Sprintf("Getting POST Endpoint for this entity type failed. Endpoint type: %s, Error: %s", "node", err3))
 		}
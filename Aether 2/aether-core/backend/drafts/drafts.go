@@ -0,0 +1,334 @@
+// Backend > Drafts
+// This package keeps the drafts of the local user, and publishes the ones scheduled for later. A draft is a thread or a post as the user wrote it, not signed; it is kept in the database per profile, which is the key of the user, so that it survives restarts and doesn't need the frontend to be open.
+// A scheduled draft is published by a job of the job queue, queued to run at the time the user chose. It is signed, given its proof of work, and committed as the entities the operator creates are, when the job runs, so its creation is the time it was published. The job reads the draft then, so the draft can be edited until it is published. A draft whose publishing failed stays, with the reason, for the user to fix and schedule again.
+
+package drafts
+
+import (
+	"aether-core/backend/responsegenerator"
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/composition"
+	"aether-core/services/create"
+	"aether-core/services/globals"
+	"aether-core/services/jobs"
+	"aether-core/services/logging"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Kinds of the drafts.
+const (
+	KindThread = "thread"
+	KindPost   = "post"
+)
+
+// States of the drafts.
+const (
+	StateDraft     = "draft"
+	StateScheduled = "scheduled" // A job is queued to publish it.
+	StatePublished = "published"
+	StateFailed    = "failed" // The job could not publish it. The reason is in the error.
+)
+
+// JobKind is the kind of the jobs that publish the scheduled drafts.
+const JobKind = "draft publishing"
+
+// Draft is the frontend-facing form of a draft.
+type Draft struct {
+	Id         string          `json:"id"` // Empty for a draft that is not saved yet.
+	Kind       string          `json:"kind"`
+	Board      api.Fingerprint `json:"board"`
+	Thread     api.Fingerprint `json:"thread"` // Posts only.
+	Parent     api.Fingerprint `json:"parent"` // Posts only. The thread, or the post replied to.
+	Name       string          `json:"name"`   // Threads only.
+	Body       string          `json:"body"`
+	Link       string          `json:"link"` // Threads only.
+	Creation   api.Timestamp   `json:"creation"`
+	LastUpdate api.Timestamp   `json:"last_update"`
+	PublishAt  api.Timestamp   `json:"publish_at"` // When a scheduled draft is published.
+	State      string          `json:"state"`
+	Job        string          `json:"job,omitempty"`       // The job that publishes a scheduled draft.
+	Published  api.Fingerprint `json:"published,omitempty"` // The entity the draft was published as.
+	Error      string          `json:"error,omitempty"`     // Why the publishing failed, or why a scheduled draft was moved later.
+}
+
+// publishArgs are the args of the job that publishes a draft. A job whose draft was scheduled again, or unscheduled, since it was queued, does nothing.
+type publishArgs struct {
+	Profile   api.Fingerprint `json:"profile"`
+	Id        string          `json:"id"`
+	PublishAt api.Timestamp   `json:"publish_at"`
+}
+
+// lock makes sure a draft is not edited while it is being published.
+var lock sync.Mutex
+
+// profile is the user the drafts belong to. Drafts saved before the user has a key belong to the empty profile.
+func profile() api.Fingerprint {
+	return api.Fingerprint(globals.CurrentUserKeyFingerprint())
+}
+
+func newId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func toDraft(d persistence.DbDraft) Draft {
+	return Draft{Id: d.Id, Kind: d.Kind, Board: d.Board, Thread: d.Thread, Parent: d.Parent, Name: d.Name, Body: d.Body, Link: d.Link, Creation: d.Creation, LastUpdate: d.LastUpdate, PublishAt: d.PublishAt, State: d.State, Job: d.Job, Published: d.Published, Error: d.Error}
+}
+
+// validate checks that the draft has what its kind needs to be published. The rest, such as the limits of the lengths, is checked when it is published.
+func validate(d Draft) error {
+	if len(d.Board) == 0 {
+		return errors.New("A draft needs the board it is to be published in.")
+	}
+	switch d.Kind {
+	case KindThread:
+		if len(d.Name) == 0 {
+			return errors.New("A draft of a thread needs a name.")
+		}
+	case KindPost:
+		if len(d.Thread) == 0 || len(d.Body) == 0 {
+			return errors.New("A draft of a post needs the thread it is in, and a body.")
+		}
+	default:
+		return errors.New(fmt.Sprintf("The kind of the draft is unknown. Kind: %s", d.Kind))
+	}
+	return nil
+}
+
+// read reads a draft of the local user. The caller holds the lock.
+func read(id string) (persistence.DbDraft, error) {
+	d, found, err := persistence.ReadDraft(profile(), id)
+	if err != nil {
+		return d, err
+	}
+	if !found {
+		return d, errors.New(fmt.Sprintf("There is no such draft. Draft: %s", id))
+	}
+	return d, nil
+}
+
+// cancelJob cancels the job that publishes the draft, if it is scheduled. The job may have ended meanwhile, which is not an error. The caller holds the lock.
+func cancelJob(d *persistence.DbDraft) {
+	if d.State == StateScheduled && len(d.Job) > 0 {
+		jobs.Cancel(d.Job)
+	}
+	d.Job = ""
+	d.PublishAt = 0
+}
+
+// Save saves a draft of the local user. A draft without an id is new. Saving a scheduled draft keeps it scheduled, and it is published as it was saved last; saving one whose publishing failed makes it a draft again.
+func Save(d Draft) (Draft, error) {
+	err := validate(d)
+	if err != nil {
+		return d, err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	now := api.Timestamp(clock.Unix())
+	var dbD persistence.DbDraft
+	if len(d.Id) == 0 {
+		count, err2 := persistence.CountDrafts(profile())
+		if err2 != nil {
+			return d, err2
+		}
+		if count >= globals.DraftsMaxPerProfile {
+			return d, errors.New(fmt.Sprintf("There are too many drafts. Delete some of them first. Drafts: %d, Limit: %d", count, globals.DraftsMaxPerProfile))
+		}
+		dbD = persistence.DbDraft{Id: newId(), Profile: profile(), Creation: now, State: StateDraft}
+	} else {
+		existing, err3 := read(d.Id)
+		if err3 != nil {
+			return d, err3
+		}
+		if existing.State == StatePublished {
+			return d, errors.New(fmt.Sprintf("The draft is already published, so it can't be changed. Draft: %s", d.Id))
+		}
+		dbD = existing
+		if dbD.State == StateFailed {
+			dbD.State = StateDraft
+			dbD.Error = ""
+		}
+	}
+	dbD.Kind, dbD.Board, dbD.Thread, dbD.Parent = d.Kind, d.Board, d.Thread, d.Parent
+	dbD.Name, dbD.Body, dbD.Link = d.Name, d.Body, d.Link
+	dbD.LastUpdate = now
+	err4 := persistence.InsertDraft(dbD)
+	if err4 != nil {
+		return d, err4
+	}
+	return toDraft(dbD), nil
+}
+
+// List gives the drafts of the local user, the ones updated most recently first.
+func List() ([]Draft, error) {
+	result := []Draft{}
+	dbDs, err := persistence.ReadDrafts(profile())
+	if err != nil {
+		return result, err
+	}
+	for _, dbD := range dbDs {
+		result = append(result, toDraft(dbD))
+	}
+	return result, nil
+}
+
+// Get gives a draft of the local user.
+func Get(id string) (Draft, error) {
+	d, err := read(id)
+	if err != nil {
+		return Draft{}, err
+	}
+	return toDraft(d), nil
+}
+
+// Delete deletes a draft of the local user. A scheduled draft is not published.
+func Delete(id string) error {
+	lock.Lock()
+	defer lock.Unlock()
+	d, err := read(id)
+	if err != nil {
+		return err
+	}
+	cancelJob(&d)
+	_, err2 := persistence.DeleteDraft(profile(), id)
+	return err2
+}
+
+// Schedule schedules a draft of the local user to be published at the given time. A time that has passed, such as 0, publishes it as soon as the job queue can. A draft that is already scheduled is scheduled again.
+func Schedule(id string, at api.Timestamp) (Draft, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	d, err := read(id)
+	if err != nil {
+		return Draft{}, err
+	}
+	if d.State == StatePublished {
+		return toDraft(d), errors.New(fmt.Sprintf("The draft is already published. Draft: %s", id))
+	}
+	err2 := validate(toDraft(d))
+	if err2 != nil {
+		return toDraft(d), err2
+	}
+	if now := api.Timestamp(clock.Unix()); at < now {
+		at = now
+	}
+	cancelJob(&d)
+	j, err3 := jobs.EnqueueAt(JobKind, publishArgs{Profile: d.Profile, Id: d.Id, PublishAt: at}, jobs.PriorityNormal, int64(at))
+	if err3 != nil {
+		return toDraft(d), err3
+	}
+	d.State, d.PublishAt, d.Job, d.Error = StateScheduled, at, j.Id, ""
+	err4 := persistence.InsertDraft(d)
+	if err4 != nil {
+		jobs.Cancel(j.Id)
+		return toDraft(d), err4
+	}
+	return toDraft(d), nil
+}
+
+// Unschedule makes a scheduled draft of the local user a draft again.
+func Unschedule(id string) (Draft, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	d, err := read(id)
+	if err != nil {
+		return Draft{}, err
+	}
+	if d.State != StateScheduled {
+		return toDraft(d), errors.New(fmt.Sprintf("The draft is not scheduled. Draft: %s, State: %s", id, d.State))
+	}
+	cancelJob(&d)
+	d.State = StateDraft
+	err2 := persistence.InsertDraft(d)
+	return toDraft(d), err2
+}
+
+// RunPublishJob is the job that publishes a scheduled draft. A job that is not the one the draft is scheduled with anymore does nothing.
+func RunPublishJob(args json.RawMessage, cancel <-chan struct{}) error {
+	var a publishArgs
+	err := json.Unmarshal(args, &a)
+	if err != nil {
+		return errors.New(fmt.Sprintf("The args of the job could not be parsed. Error: %s", err))
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	d, found, err2 := persistence.ReadDraft(a.Profile, a.Id)
+	if err2 != nil {
+		return err2
+	}
+	if !found || d.State != StateScheduled || d.PublishAt != a.PublishAt {
+		return nil
+	}
+	fp, err3 := publish(d)
+	var limitErr *composition.LimitError
+	if errors.As(err3, &limitErr) {
+		// Over the composition limit of the network. The draft stays scheduled, for when the limit allows it.
+		at := api.Timestamp(clock.Unix() + int64((limitErr.RetryAfter+time.Second-1)/time.Second))
+		j, err5 := jobs.EnqueueAt(JobKind, publishArgs{Profile: d.Profile, Id: d.Id, PublishAt: at}, jobs.PriorityNormal, int64(at))
+		if err5 == nil {
+			d.PublishAt, d.Job, d.Error = at, j.Id, err3.Error()
+			logging.Log(2, fmt.Sprintf("A scheduled draft is over the composition limit, and is published later. Draft: %s, Publish at: %d", d.Id, at))
+			return persistence.InsertDraft(d)
+		}
+		logging.Log(1, fmt.Sprintf("A scheduled draft over the composition limit could not be scheduled again. Draft: %s, Error: %s", d.Id, err5))
+	}
+	if err3 != nil {
+		d.State, d.Error = StateFailed, err3.Error()
+		logging.Log(1, fmt.Sprintf("A scheduled draft could not be published. Draft: %s, Error: %s", d.Id, err3))
+	} else {
+		d.State, d.Published, d.Error = StatePublished, fp, ""
+		logging.Log(1, fmt.Sprintf("A scheduled draft was published. Draft: %s, Fingerprint: %s", d.Id, fp))
+	}
+	d.Job = ""
+	err4 := persistence.InsertDraft(d)
+	if err4 != nil {
+		return err4
+	}
+	return err3
+}
+
+// publish signs the draft with the key of the user and commits it, and gives its fingerprint.
+func publish(d persistence.DbDraft) (api.Fingerprint, error) {
+	if d.Profile != profile() {
+		return "", errors.New(fmt.Sprintf("The draft belongs to a key other than the one of the user now, so it can't be signed. Profile: %s", d.Profile))
+	}
+	var body api.Answer
+	var fp api.Fingerprint
+	switch d.Kind {
+	case KindThread:
+		t, err := create.CreateThread(d.Board, d.Name, d.Body, d.Link, d.Profile)
+		if err != nil {
+			return "", err
+		}
+		body.Threads, fp = []api.Thread{t}, t.Fingerprint
+	case KindPost:
+		parent := d.Parent
+		if len(parent) == 0 {
+			parent = d.Thread
+		}
+		p, err := create.CreatePost(d.Board, d.Thread, parent, d.Body, d.Profile)
+		if err != nil {
+			return "", err
+		}
+		body.Posts, fp = []api.Post{p}, p.Fingerprint
+	default:
+		return "", errors.New(fmt.Sprintf("The kind of the draft is unknown. Kind: %s", d.Kind))
+	}
+	for _, s := range responsegenerator.AcceptLocal(body) {
+		if s.Fingerprint == fp && s.Status == api.EntityAccepted {
+			return fp, nil
+		} else if s.Fingerprint == fp {
+			return "", errors.New(fmt.Sprintf("The node did not accept the published draft. Reason: %s", s.Reason))
+		}
+	}
+	return "", errors.New("The node did not accept the published draft.")
+}
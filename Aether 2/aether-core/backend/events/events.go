@@ -0,0 +1,211 @@
+// Backend > Events
+// This package publishes an event for every entity the node ingests, to the local subscribers: webhooks, a Unix socket, and in-process subscribers. It is disabled by default.
+
+package events
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event is a single entity-ingested event.
+type Event struct {
+	Type        string          `json:"type"` // "boards", "threads", "posts", ...
+	Fingerprint api.Fingerprint `json:"fingerprint"`
+	Board       api.Fingerprint `json:"board,omitempty"` // Empty for entities that do not belong to a board.
+	Entity      interface{}     `json:"entity"`
+	Timestamp   api.Timestamp   `json:"timestamp"`
+}
+
+// Filter decides which events a subscriber receives. Empty fields match everything.
+type Filter struct {
+	Boards []string
+	Types  []string
+}
+
+func contains(slc []string, s string) bool {
+	for _, val := range slc {
+		if val == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches checks whether the event passes the filter.
+func (f *Filter) Matches(e Event) bool {
+	if len(f.Types) > 0 && !contains(f.Types, e.Type) {
+		return false
+	}
+	if len(f.Boards) > 0 && !contains(f.Boards, string(e.Board)) {
+		return false
+	}
+	return true
+}
+
+type subscriber struct {
+	filter Filter
+	ch     chan Event
+}
+
+var subscribersLock sync.Mutex
+var subscribers = make(map[int]subscriber)
+var lastSubscriberId int
+
+// Subscribe registers an in-process subscriber. Events are dropped for a subscriber whose channel is full, so a slow subscriber never blocks the ingest. Call Unsubscribe with the returned id when done.
+func Subscribe(f Filter, bufferSize int) (int, <-chan Event) {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	lastSubscriberId++
+	s := subscriber{filter: f, ch: make(chan Event, bufferSize)}
+	subscribers[lastSubscriberId] = s
+	return lastSubscriberId, s.ch
+}
+
+// Unsubscribe removes an in-process subscriber and closes its channel.
+func Unsubscribe(id int) {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	if s, ok := subscribers[id]; ok {
+		close(s.ch)
+		delete(subscribers, id)
+	}
+}
+
+// eventsFromResponse converts the entities of a response that were written into events.
+func eventsFromResponse(resp *api.Response, written map[api.Fingerprint]bool) []Event {
+	var evs []Event
+	now := api.Timestamp(time.Now().Unix())
+	for _, e := range resp.Boards {
+		if written[e.Fingerprint] {
+			evs = append(evs, Event{Type: "boards", Fingerprint: e.Fingerprint, Board: e.Fingerprint, Entity: e, Timestamp: now})
+		}
+	}
+	for _, e := range resp.Threads {
+		if written[e.Fingerprint] {
+			evs = append(evs, Event{Type: "threads", Fingerprint: e.Fingerprint, Board: e.Board, Entity: e, Timestamp: now})
+		}
+	}
+	for _, e := range resp.Posts {
+		if written[e.Fingerprint] {
+			evs = append(evs, Event{Type: "posts", Fingerprint: e.Fingerprint, Board: e.Board, Entity: e, Timestamp: now})
+		}
+	}
+	for _, e := range resp.Votes {
+		if written[e.Fingerprint] {
+			evs = append(evs, Event{Type: "votes", Fingerprint: e.Fingerprint, Board: e.Board, Entity: e, Timestamp: now})
+		}
+	}
+	for _, e := range resp.Keys {
+		if written[e.Fingerprint] {
+			evs = append(evs, Event{Type: "keys", Fingerprint: e.Fingerprint, Entity: e, Timestamp: now})
+		}
+	}
+	for _, e := range resp.Truststates {
+		if written[e.Fingerprint] {
+			evs = append(evs, Event{Type: "truststates", Fingerprint: e.Fingerprint, Entity: e, Timestamp: now})
+		}
+	}
+	for _, e := range resp.Tombstones {
+		if written[e.Fingerprint] {
+			evs = append(evs, Event{Type: "tombstones", Fingerprint: e.Fingerprint, Entity: e, Timestamp: now})
+		}
+	}
+	// Addresses are not published, they are the node's own bookkeeping rather than content.
+	return evs
+}
+
+// Publish sends the events for the entities in a response that was just committed to the database. Only the ones the commit wrote are published, as given by persistence.BatchInsertWritten, so that an entity the node already had does not arrive at the subscribers again.
+func Publish(resp *api.Response, written map[api.Fingerprint]bool) {
+	if !globals.EventsEnabled {
+		return
+	}
+	evs := eventsFromResponse(resp, written)
+	if len(evs) == 0 {
+		return
+	}
+	// In-process subscribers, including the Unix socket clients.
+	subscribersLock.Lock()
+	for _, s := range subscribers {
+		for _, e := range evs {
+			if s.filter.Matches(e) {
+				select {
+				case s.ch <- e:
+				default:
+					// The subscriber is not keeping up.
+				}
+			}
+		}
+	}
+	subscribersLock.Unlock()
+	// Webhooks
+	for _, wh := range globals.EventWebhooks {
+		f := Filter{Boards: wh.Boards, Types: wh.Types}
+		var matched []Event
+		for _, e := range evs {
+			if f.Matches(e) {
+				matched = append(matched, e)
+			}
+		}
+		if len(matched) > 0 {
+			queueWebhook(webhookDelivery{wh.Url, matched})
+		}
+	}
+}
+
+// The webhooks are delivered by a fixed number of workers from a bounded queue. When the queue is full, because the webhooks are slow or down, the deliveries are dropped rather than held, so that neither the ingest nor the memory waits on them.
+const webhookWorkers = 4
+const webhookQueueSize = 256
+
+type webhookDelivery struct {
+	url string
+	evs []Event
+}
+
+var webhookQueue chan webhookDelivery
+var startWebhookWorkers sync.Once
+
+// queueWebhook adds a delivery to the queue, starting the workers the first time.
+func queueWebhook(d webhookDelivery) {
+	startWebhookWorkers.Do(func() {
+		webhookQueue = make(chan webhookDelivery, webhookQueueSize)
+		for i := 0; i < webhookWorkers; i++ {
+			go func() {
+				for d := range webhookQueue {
+					deliverWebhook(d.url, d.evs)
+				}
+			}()
+		}
+	})
+	select {
+	case webhookQueue <- d:
+	default:
+		logging.LogSampled("events", "webhook-queue-full", 1, fmt.Sprintf("The webhook queue is full. The events are dropped for this webhook. URL: %s, Events: %d", d.url, len(d.evs)))
+	}
+}
+
+// deliverWebhook POSTs the events to the webhook as a JSON array. Failures are logged and not retried.
+func deliverWebhook(url string, evs []Event) {
+	body, err := json.Marshal(evs)
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("Events could not be converted to JSON for the webhook. URL: %s, Error: %s", url, err))
+		return
+	}
+	client := &http.Client{Timeout: globals.EventWebhookTimeout}
+	resp, err2 := client.Post(url, "application/json", bytes.NewReader(body))
+	if err2 != nil {
+		logging.Log(1, fmt.Sprintf("Webhook delivery failed. URL: %s, Error: %s", url, err2))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logging.Log(1, fmt.Sprintf("Webhook responded with a non-2xx status. URL: %s, Status: %d", url, resp.StatusCode))
+	}
+}
@@ -0,0 +1,78 @@
+package events_test
+
+import (
+	"aether-core/backend/events"
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"os"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+	globals.EventsEnabled = true
+}
+
+func teardown() {
+}
+
+// Tests
+
+func TestFilterMatches_Success(t *testing.T) {
+	f := events.Filter{Boards: []string{"board1"}, Types: []string{"posts"}}
+	if !f.Matches(events.Event{Type: "posts", Board: "board1"}) {
+		t.Errorf("The filter should have matched the event.")
+	}
+	if f.Matches(events.Event{Type: "threads", Board: "board1"}) {
+		t.Errorf("The filter should not have matched an event of another type.")
+	}
+	if f.Matches(events.Event{Type: "posts", Board: "board2"}) {
+		t.Errorf("The filter should not have matched an event of another board.")
+	}
+}
+
+func TestPublish_Success(t *testing.T) {
+	id, ch := events.Subscribe(events.Filter{Boards: []string{"board1"}}, 10)
+	defer events.Unsubscribe(id)
+	var resp api.Response
+	var p1, p2 api.Post
+	p1.Fingerprint = "post1"
+	p1.Board = "board1"
+	p2.Fingerprint = "post2"
+	p2.Board = "board2"
+	resp.Posts = []api.Post{p1, p2}
+	events.Publish(&resp, map[api.Fingerprint]bool{"post1": true, "post2": true})
+	if len(ch) != 1 {
+		t.Errorf("Expected 1 event, got %d.", len(ch))
+	} else if e := <-ch; e.Fingerprint != "post1" || e.Type != "posts" {
+		t.Errorf("The event is wrong. Event: %#v", e)
+	}
+}
+
+func TestPublish_Success_OnlyWritten(t *testing.T) {
+	id, ch := events.Subscribe(events.Filter{}, 10)
+	defer events.Unsubscribe(id)
+	var resp api.Response
+	var p1, p2 api.Post
+	p1.Fingerprint = "post1"
+	p1.Board = "board1"
+	p2.Fingerprint = "post2"
+	p2.Board = "board1"
+	resp.Posts = []api.Post{p1, p2}
+	// post2 was already in the database, so the commit did not write it.
+	events.Publish(&resp, map[api.Fingerprint]bool{"post1": true})
+	if len(ch) != 1 {
+		t.Errorf("Expected 1 event, got %d.", len(ch))
+	} else if e := <-ch; e.Fingerprint != "post1" {
+		t.Errorf("The event is wrong. Event: %#v", e)
+	}
+}
@@ -0,0 +1,61 @@
+// Backend > Events > Socket
+// This file serves the events over a Unix socket. Every connected client receives all events as JSON lines.
+
+package events
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+)
+
+var socketListener net.Listener
+
+// ServeSocket listens on the configured Unix socket and streams the events to every client that connects. It blocks until StopSocket is called.
+func ServeSocket() {
+	if !globals.EventsEnabled || len(globals.EventSocketPath) == 0 {
+		return
+	}
+	// A socket file left over from a prior run blocks the listen.
+	os.Remove(globals.EventSocketPath)
+	l, err := net.Listen("unix", globals.EventSocketPath)
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The events socket could not be opened. Path: %s, Error: %s", globals.EventSocketPath, err))
+		return
+	}
+	socketListener = l
+	logging.Log(1, fmt.Sprintf("The events socket is listening at %s", globals.EventSocketPath))
+	for {
+		conn, err2 := l.Accept()
+		if err2 != nil {
+			// The listener was closed.
+			return
+		}
+		go streamToConn(conn)
+	}
+}
+
+// StopSocket closes the events socket.
+func StopSocket() {
+	if socketListener != nil {
+		socketListener.Close()
+		socketListener = nil
+	}
+}
+
+func streamToConn(conn net.Conn) {
+	defer conn.Close()
+	id, ch := Subscribe(Filter{}, 1000)
+	defer Unsubscribe(id)
+	enc := json.NewEncoder(conn) // Encode writes a newline after each value.
+	for e := range ch {
+		err := enc.Encode(e)
+		if err != nil {
+			// The client went away.
+			return
+		}
+	}
+}
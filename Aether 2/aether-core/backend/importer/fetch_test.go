@@ -0,0 +1,43 @@
+// This test is in the package itself rather than in importer_test, since fetching the feeds and naming the threads are not exported.
+
+package importer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestThreadName_Success(t *testing.T) {
+	title := strings.Repeat("é", maxThreadNameLength+10)
+	name := threadName(FeedItem{Title: title})
+	if !utf8.ValidString(name) || utf8.RuneCountInString(name) != maxThreadNameLength {
+		t.Errorf("The name should be cut by characters. Valid: %t, Characters: %d", utf8.ValidString(name), utf8.RuneCountInString(name))
+	}
+	if threadName(FeedItem{Link: "http://example.com/1"}) != "http://example.com/1" {
+		t.Errorf("An item without a title should be named by its link.")
+	}
+}
+
+func TestFetchFeed_Fail_TooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", maxFeedBytes+1)))
+	}))
+	defer server.Close()
+	if _, err := fetchFeed(server.URL); err == nil {
+		t.Errorf("A feed larger than the importer reads should be refused.")
+	}
+}
+
+func TestFetchFeed_Success(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<rss></rss>"))
+	}))
+	defer server.Close()
+	data, err := fetchFeed(server.URL)
+	if err != nil || string(data) != "<rss></rss>" {
+		t.Errorf("The feed should be read as it is. Data: %s, Err: '%v'", data, err)
+	}
+}
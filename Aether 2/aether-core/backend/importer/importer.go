@@ -0,0 +1,320 @@
+// Backend > Importer
+// This package polls the configured RSS and Atom feeds, and converts their items into threads in the designated boards, signed with a bridge key of their own. NNTP sources are out of scope: a newsgroup is a stream of replies rather than a list of items, and it would need a different mapping onto the threads and posts.
+
+package importer
+
+import (
+	"aether-core/backend/ranking"
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/create"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FeedItem is the common form of an RSS item or an Atom entry.
+type FeedItem struct {
+	Id      string
+	Title   string
+	Link    string
+	Summary string
+}
+
+type rssDocument struct {
+	Items []struct {
+		Guid        string `xml:"guid"`
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		Description string `xml:"description"`
+	} `xml:"channel>item"`
+}
+
+type atomDocument struct {
+	Entries []struct {
+		Id    string `xml:"id"`
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Summary string `xml:"summary"`
+		Content string `xml:"content"`
+	} `xml:"entry"`
+}
+
+// Thread names are capped by the database schema, in characters.
+const maxThreadNameLength = 255
+
+// maxFeedBytes is the most of a feed that is read. A feed larger than this is refused rather than parsed cut short.
+const maxFeedBytes = 8 * 1024 * 1024
+
+// ParseFeed parses an RSS 2.0 or an Atom document into feed items. Items without any usable id are dropped, since they cannot be deduplicated.
+func ParseFeed(data []byte) ([]FeedItem, error) {
+	var root struct {
+		XMLName xml.Name
+	}
+	err := xml.Unmarshal(data, &root)
+	if err != nil {
+		return []FeedItem{}, errors.New(fmt.Sprintf("The feed could not be parsed. Error: %s", err))
+	}
+	var items []FeedItem
+	switch root.XMLName.Local {
+	case "rss":
+		var doc rssDocument
+		err2 := xml.Unmarshal(data, &doc)
+		if err2 != nil {
+			return []FeedItem{}, errors.New(fmt.Sprintf("The RSS feed could not be parsed. Error: %s", err2))
+		}
+		for _, it := range doc.Items {
+			var item FeedItem
+			item.Id = strings.TrimSpace(it.Guid)
+			item.Link = strings.TrimSpace(it.Link)
+			if len(item.Id) == 0 {
+				item.Id = item.Link
+			}
+			item.Title = strings.TrimSpace(it.Title)
+			item.Summary = strings.TrimSpace(it.Description)
+			if len(item.Id) > 0 {
+				items = append(items, item)
+			}
+		}
+	case "feed":
+		var doc atomDocument
+		err2 := xml.Unmarshal(data, &doc)
+		if err2 != nil {
+			return []FeedItem{}, errors.New(fmt.Sprintf("The Atom feed could not be parsed. Error: %s", err2))
+		}
+		for _, en := range doc.Entries {
+			var item FeedItem
+			item.Id = strings.TrimSpace(en.Id)
+			for _, l := range en.Links {
+				// The alternate link is the one that points to the item itself. A link without a rel is alternate by default.
+				if l.Rel == "" || l.Rel == "alternate" {
+					item.Link = strings.TrimSpace(l.Href)
+					break
+				}
+			}
+			if len(item.Id) == 0 {
+				item.Id = item.Link
+			}
+			item.Title = strings.TrimSpace(en.Title)
+			item.Summary = strings.TrimSpace(en.Summary)
+			if len(item.Summary) == 0 {
+				item.Summary = strings.TrimSpace(en.Content)
+			}
+			if len(item.Id) > 0 {
+				items = append(items, item)
+			}
+		}
+	default:
+		return []FeedItem{}, errors.New(fmt.Sprintf("The feed is neither RSS nor Atom. Root element: %s", root.XMLName.Local))
+	}
+	return items, nil
+}
+
+// itemKey is the deduplication key of an item. Ids are only unique within a feed, so the feed URL is part of the key.
+func itemKey(feedUrl string, item FeedItem) string {
+	calculator := sha256.New()
+	calculator.Write([]byte(fmt.Sprint(feedUrl, "\n", item.Id)))
+	return hex.EncodeToString(calculator.Sum(nil))
+}
+
+func fetchFeed(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return []byte{}, errors.New(fmt.Sprintf("The feed responded with a non-200 status. URL: %s, Status: %d", url, resp.StatusCode))
+	}
+	data, err2 := ioutil.ReadAll(io.LimitReader(resp.Body, maxFeedBytes+1))
+	if err2 != nil {
+		return []byte{}, err2
+	}
+	if len(data) > maxFeedBytes {
+		return []byte{}, errors.New(fmt.Sprintf("The feed is larger than the importer reads. URL: %s, Limit: %d bytes", url, maxFeedBytes))
+	}
+	return data, nil
+}
+
+// threadName is the name of the thread of an item: its title, or its link if it has none, cut to the length a thread name can have. It is cut by characters, so that a character is never cut in half.
+func threadName(item FeedItem) string {
+	name := item.Title
+	if len(name) == 0 {
+		name = item.Link
+	}
+	if utf8.RuneCountInString(name) > maxThreadNameLength {
+		name = string([]rune(name)[:maxThreadNameLength])
+	}
+	return name
+}
+
+// bridgeKeyName is the name the key entity of the bridge is published with.
+const bridgeKeyName = "Feed importer"
+
+// LoadBridgeKey loads the key pair of the bridge from ImporterBridgeKeyFile, and finds its key entity, or publishes one if there is none yet. The imported threads are owned by that key entity, and signed with the key pair.
+func LoadBridgeKey() error {
+	if len(globals.ImporterBridgeKeyFile) == 0 {
+		return errors.New("The importer is enabled, but there is no bridge key file set.")
+	}
+	data, err := ioutil.ReadFile(globals.ImporterBridgeKeyFile)
+	if err != nil {
+		return errors.New(fmt.Sprintf("The bridge key file could not be read. Path: %s, Error: %s", globals.ImporterBridgeKeyFile, err))
+	}
+	keyPair, err2 := parseBridgeKey(data)
+	if err2 != nil {
+		return err2
+	}
+	publicKey := hex.EncodeToString(elliptic.Marshal(elliptic.P521(), keyPair.PublicKey.X, keyPair.PublicKey.Y))
+	fp, found, err3 := persistence.ReadKeyFingerprint(publicKey)
+	if err3 != nil {
+		return err3
+	}
+	if !found {
+		keyEntity, err4 := create.CreateKeySigned("", publicKey, bridgeKeyName, []api.CurrencyAddress{}, "", keyPair)
+		if err4 != nil {
+			return err4
+		}
+		err5 := persistence.BatchInsert([]interface{}{keyEntity})
+		if err5 != nil {
+			return err5
+		}
+		fp = keyEntity.Fingerprint
+		logging.Log(1, fmt.Sprintf("The key entity of the bridge key of the importer was published. Fingerprint: %s", fp))
+	}
+	globals.ImporterBridgeKeyPair = keyPair
+	globals.ImporterBridgeKeyFingerprint = string(fp)
+	return nil
+}
+
+// parseBridgeKey parses the key pair of the bridge, which is written as the hex of the DER encoded EC private key, as in the identity of the node.
+func parseBridgeKey(data []byte) (*ecdsa.PrivateKey, error) {
+	der, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("The bridge key could not be decoded. Error: %s", err))
+	}
+	keyPair, err2 := x509.ParseECPrivateKey(der)
+	if err2 != nil {
+		return nil, errors.New(fmt.Sprintf("The bridge key could not be parsed. Error: %s", err2))
+	}
+	return keyPair, nil
+}
+
+// CheckBridgeKey tells whether the importer can create threads. The bridge key has to be loaded, and it has to be a key of its own: the threads signed with the key of the user would count against the limits of the user, and would look to the others as if the user wrote them.
+func CheckBridgeKey() error {
+	if globals.ImporterBridgeKeyPair == nil || len(globals.ImporterBridgeKeyFingerprint) == 0 {
+		return errors.New("The importer is enabled, but the bridge key is not loaded.")
+	}
+	userKey := globals.UserSigningKey()
+	if globals.ImporterBridgeKeyFingerprint == globals.CurrentUserKeyFingerprint() || (userKey != nil && globals.ImporterBridgeKeyPair.D.Cmp(userKey.D) == 0) {
+		return errors.New(fmt.Sprintf("The bridge key of the importer is the key of the local user. It has to be a key of its own. Bridge key: %s", globals.ImporterBridgeKeyFingerprint))
+	}
+	return nil
+}
+
+// importedTimes are when the threads of the last minute were imported, for the limit of the bridge key.
+var importedTimes []time.Time
+
+// allowImport counts an item against ImporterMaxItemsPerMinute, and gives false if the bridge key is over it. The polls run one at a time, so this is not locked.
+func allowImport(now time.Time) bool {
+	i := 0
+	for i < len(importedTimes) && now.Sub(importedTimes[i]) >= time.Minute {
+		i++
+	}
+	importedTimes = importedTimes[i:]
+	if len(importedTimes) >= globals.ImporterMaxItemsPerMinute {
+		return false
+	}
+	importedTimes = append(importedTimes, now)
+	return true
+}
+
+// importFeed fetches a feed and converts at most ImporterMaxItemsPerPoll new items into threads. It returns the number of threads created.
+func importFeed(feed globals.ImporterFeed) (int, error) {
+	data, err := fetchFeed(feed.Url)
+	if err != nil {
+		return 0, err
+	}
+	items, err2 := ParseFeed(data)
+	if err2 != nil {
+		return 0, err2
+	}
+	imported := 0
+	for _, item := range items {
+		if imported >= globals.ImporterMaxItemsPerPoll {
+			logging.Log(1, fmt.Sprintf("The importer reached the per-poll item limit for this feed. The rest will be imported in the next poll. Feed: %s", feed.Url))
+			break
+		}
+		key := itemKey(feed.Url, item)
+		exists, err3 := persistence.ImportedItemExists(key)
+		if err3 != nil {
+			return imported, err3
+		}
+		if exists {
+			continue
+		}
+		if !allowImport(clock.Now()) {
+			logging.Log(1, fmt.Sprintf("The importer reached the per-minute item limit of the bridge key. The rest will be imported in the next poll. Feed: %s", feed.Url))
+			break
+		}
+		thread, err4 := create.CreateThreadSigned(
+			api.Fingerprint(feed.Board), threadName(item), item.Summary, item.Link,
+			api.Fingerprint(globals.ImporterBridgeKeyFingerprint), globals.ImporterBridgeKeyPair)
+		if err4 != nil {
+			return imported, err4
+		}
+		err5 := persistence.BatchInsert([]interface{}{thread})
+		if err5 != nil {
+			return imported, err5
+		}
+		ranking.Update(&api.Response{Threads: []api.Thread{thread}})
+		var ii persistence.DbImportedItem
+		ii.ItemKey = key
+		ii.FeedUrl = feed.Url
+		ii.Thread = thread.Fingerprint
+		ii.LocalArrival = api.Timestamp(clock.Unix())
+		err6 := persistence.InsertImportedItem(ii)
+		if err6 != nil {
+			return imported, err6
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// Import polls all configured feeds once. This is what the scheduler calls.
+func Import() {
+	if !globals.ImporterEnabled {
+		return
+	}
+	if err := CheckBridgeKey(); err != nil {
+		logging.Log(1, fmt.Sprintf("%s Skipping this poll.", err))
+		return
+	}
+	logging.Log(1, "Import from feeds has started.")
+	defer logging.Log(1, "Import from feeds is complete.")
+	for _, feed := range globals.ImporterFeeds {
+		n, err := importFeed(feed)
+		if err != nil {
+			logging.Log(1, fmt.Sprintf("Importing this feed failed. Feed: %s, Error: %s", feed.Url, err))
+		}
+		logging.Log(1, fmt.Sprintf("Imported %d items from the feed: %s", n, feed.Url))
+	}
+}
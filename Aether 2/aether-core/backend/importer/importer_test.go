@@ -0,0 +1,124 @@
+package importer_test
+
+import (
+	"aether-core/backend/importer"
+	"aether-core/services/globals"
+	"aether-core/services/signaturing"
+	"crypto/ecdsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+}
+
+func teardown() {
+}
+
+// Tests
+
+func TestParseFeed_RSS_Success(t *testing.T) {
+	feed := []byte(`<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Example</title>
+<item><title>First</title><link>http://example.com/1</link><guid>id-1</guid><description>One</description></item>
+<item><title>Second</title><link>http://example.com/2</link><description>Two</description></item>
+</channel></rss>`)
+	items, err := importer.ParseFeed(feed)
+	if err != nil {
+		t.Errorf("Feed parsing failed. Err: '%s'", err)
+	}
+	if len(items) != 2 {
+		t.Errorf("Expected 2 items, got %d.", len(items))
+	} else if items[0].Id != "id-1" || items[1].Id != "http://example.com/2" {
+		t.Errorf("Item ids are wrong. Items: %#v", items)
+	}
+}
+
+func TestParseFeed_Atom_Success(t *testing.T) {
+	feed := []byte(`<?xml version="1.0"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>Example</title>
+<entry><id>urn:1</id><title>First</title><link rel="self" href="http://example.com/self"/><link href="http://example.com/1"/><content>One</content></entry>
+</feed>`)
+	items, err := importer.ParseFeed(feed)
+	if err != nil {
+		t.Errorf("Feed parsing failed. Err: '%s'", err)
+	}
+	if len(items) != 1 {
+		t.Errorf("Expected 1 item, got %d.", len(items))
+	} else if items[0].Link != "http://example.com/1" || items[0].Summary != "One" {
+		t.Errorf("Item fields are wrong. Item: %#v", items[0])
+	}
+}
+
+func TestParseFeed_UnknownFormat_Fail(t *testing.T) {
+	_, err := importer.ParseFeed([]byte(`<html><body></body></html>`))
+	if err == nil {
+		t.Errorf("Expected an error for a document that is neither RSS nor Atom.")
+	}
+}
+
+func TestCheckBridgeKey_Success(t *testing.T) {
+	globals.GenerateUserKeyPair()
+	bridge, _ := signaturing.CreateKeyPair()
+	globals.UserKeyFingerprint = "user key"
+	globals.ImporterBridgeKeyPair, globals.ImporterBridgeKeyFingerprint = bridge, "bridge key"
+	defer func() {
+		globals.UserKeyFingerprint, globals.ImporterBridgeKeyFingerprint, globals.ImporterBridgeKeyPair = "", "", nil
+	}()
+	if err := importer.CheckBridgeKey(); err != nil {
+		t.Errorf("A bridge key of its own should be usable. Err: '%s'", err)
+	}
+}
+
+func TestCheckBridgeKey_Fail(t *testing.T) {
+	globals.GenerateUserKeyPair()
+	bridge, _ := signaturing.CreateKeyPair()
+	globals.UserKeyFingerprint = "user key"
+	defer func() {
+		globals.UserKeyFingerprint, globals.ImporterBridgeKeyFingerprint, globals.ImporterBridgeKeyPair = "", "", nil
+	}()
+	cases := []struct {
+		name        string
+		keyPair     *ecdsa.PrivateKey
+		fingerprint string
+	}{
+		{"NotLoaded", nil, ""},
+		{"NoKeyEntity", bridge, ""},
+		{"KeyEntityOfTheUser", bridge, "user key"},
+		{"KeyPairOfTheUser", globals.UserSigningKey(), "bridge key"},
+	}
+	for _, c := range cases {
+		globals.ImporterBridgeKeyPair, globals.ImporterBridgeKeyFingerprint = c.keyPair, c.fingerprint
+		if err := importer.CheckBridgeKey(); err == nil {
+			t.Errorf("The bridge key should be refused. Case: %s", c.name)
+		}
+	}
+}
+
+func TestLoadBridgeKey_Fail(t *testing.T) {
+	defer func(v string) { globals.ImporterBridgeKeyFile = v }(globals.ImporterBridgeKeyFile)
+	dir, err := ioutil.TempDir("", "aether-bridgekey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bad := filepath.Join(dir, "bridge.key")
+	ioutil.WriteFile(bad, []byte("not a key"), 0600)
+	for _, path := range []string{"", filepath.Join(dir, "missing.key"), bad} {
+		globals.ImporterBridgeKeyFile = path
+		if err := importer.LoadBridgeKey(); err == nil {
+			t.Errorf("A bridge key that can't be read should be refused. Path: %q", path)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+// This test is in the package itself rather than in importer_test, since the limit of the bridge key is counted by a function that is not exported.
+
+package importer
+
+import (
+	"aether-core/services/globals"
+	"testing"
+	"time"
+)
+
+func TestAllowImport_Success(t *testing.T) {
+	defer func(v int) { globals.ImporterMaxItemsPerMinute, importedTimes = v, nil }(globals.ImporterMaxItemsPerMinute)
+	globals.ImporterMaxItemsPerMinute = 3
+	importedTimes = nil
+	start := time.Unix(1500000000, 0)
+	for i := 0; i < 3; i++ {
+		if !allowImport(start.Add(time.Duration(i) * time.Second)) {
+			t.Fatalf("The items under the limit should be allowed. Item: %d", i)
+		}
+	}
+	if allowImport(start.Add(10 * time.Second)) {
+		t.Errorf("The item over the limit should not be allowed.")
+	}
+	// A minute after the first one, there is room for one more.
+	if !allowImport(start.Add(time.Minute)) {
+		t.Errorf("The item should be allowed once the first one is out of the minute.")
+	}
+}
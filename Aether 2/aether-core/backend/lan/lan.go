@@ -0,0 +1,297 @@
+// Backend > LAN
+// This package finds the other Aether nodes on the local network with mDNS / DNS-SD, so that nodes at a conference or on an offline mesh can sync without reaching the internet. The node answers the queries for the Aether service with its own address, and asks for the others in regular intervals. Every node that answers is connected to once to verify it, and then saved as a local address.
+
+package lan
+
+import (
+	"aether-core/backend/dispatch"
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"aether-core/services/peerrules"
+	"errors"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const serviceName = "_aether._tcp.local."
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+var conn *net.UDPConn
+
+// verified keeps when each found node was last verified, so that a node answering every query is not connected to every time.
+var verified = make(map[string]time.Time)
+var verifiedLock sync.Mutex
+
+// instanceName is the name of this node within the service. Node ids are too long for a DNS label, so only their beginning is used.
+func instanceName() string {
+	return fmt.Sprint(shortId(), ".", serviceName)
+}
+
+func hostName() string {
+	return fmt.Sprint(shortId(), ".local.")
+}
+
+func shortId() string {
+	if len(globals.NodeId) > 32 {
+		return globals.NodeId[:32]
+	}
+	return globals.NodeId
+}
+
+// advertisedIPs returns the IPv4 addresses the advertised listener can be reached at on the local network. A listener on the loopback interface can't be reached from the network, so there is nothing to advertise then.
+func advertisedIPs() []net.IP {
+	var ips []net.IP
+	for _, l := range globals.Listeners {
+		if !l.Advertise {
+			continue
+		}
+		if len(l.Interface) > 0 {
+			ip := net.ParseIP(l.Interface)
+			if ip != nil && !ip.IsLoopback() && ip.To4() != nil && !ip.IsUnspecified() {
+				ips = append(ips, ip.To4())
+			}
+			if ip == nil || !ip.IsUnspecified() {
+				continue
+			}
+		}
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return ips
+		}
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+				ips = append(ips, ipNet.IP.To4())
+			}
+		}
+	}
+	return ips
+}
+
+// buildAnswer creates the response that announces this node.
+func buildAnswer(ips []net.IP) ([]byte, error) {
+	service, err := dnsmessage.NewName(serviceName)
+	if err != nil {
+		return []byte{}, err
+	}
+	instance, err2 := dnsmessage.NewName(instanceName())
+	if err2 != nil {
+		return []byte{}, err2
+	}
+	host, err3 := dnsmessage.NewName(hostName())
+	if err3 != nil {
+		return []byte{}, err3
+	}
+	ttl := uint32(globals.LanDiscoveryInterval.Seconds() * 2)
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{Response: true, Authoritative: true})
+	b.EnableCompression()
+	b.StartAnswers()
+	b.PTRResource(dnsmessage.ResourceHeader{Name: service, Class: dnsmessage.ClassINET, TTL: ttl}, dnsmessage.PTRResource{PTR: instance})
+	b.StartAdditionals()
+	b.SRVResource(dnsmessage.ResourceHeader{Name: instance, Class: dnsmessage.ClassINET, TTL: ttl}, dnsmessage.SRVResource{Port: globals.AddressPort, Target: host})
+	b.TXTResource(dnsmessage.ResourceHeader{Name: instance, Class: dnsmessage.ClassINET, TTL: ttl}, dnsmessage.TXTResource{TXT: []string{fmt.Sprint("node_id=", globals.NodeId)}})
+	for _, ip := range ips {
+		var a [4]byte
+		copy(a[:], ip.To4())
+		b.AResource(dnsmessage.ResourceHeader{Name: host, Class: dnsmessage.ClassINET, TTL: ttl}, dnsmessage.AResource{A: a})
+	}
+	return b.Finish()
+}
+
+// buildQuery creates the query that asks the other nodes to announce themselves.
+func buildQuery() ([]byte, error) {
+	service, err := dnsmessage.NewName(serviceName)
+	if err != nil {
+		return []byte{}, err
+	}
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET})
+	return b.Finish()
+}
+
+// found is a node that announced itself.
+type found struct {
+	nodeId string
+	host   string
+	port   uint16
+	ips    []net.IP
+}
+
+// readAnnouncements reads the nodes announced in a response.
+func readAnnouncements(p *dnsmessage.Parser) []found {
+	var resources []dnsmessage.Resource
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return []found{}
+	}
+	resources = append(resources, answers...)
+	p.SkipAllAuthorities()
+	additionals, _ := p.AllAdditionals()
+	resources = append(resources, additionals...)
+	instances := make(map[string]*found)
+	hostIPs := make(map[string][]net.IP)
+	for _, r := range resources {
+		name := r.Header.Name.String()
+		switch body := r.Body.(type) {
+		case *dnsmessage.SRVResource:
+			if !strings.HasSuffix(name, serviceName) {
+				continue
+			}
+			f, ok := instances[name]
+			if !ok {
+				f = &found{}
+				instances[name] = f
+			}
+			f.host = body.Target.String()
+			f.port = body.Port
+		case *dnsmessage.TXTResource:
+			if !strings.HasSuffix(name, serviceName) {
+				continue
+			}
+			f, ok := instances[name]
+			if !ok {
+				f = &found{}
+				instances[name] = f
+			}
+			for _, txt := range body.TXT {
+				if strings.HasPrefix(txt, "node_id=") {
+					f.nodeId = strings.TrimPrefix(txt, "node_id=")
+				}
+			}
+		case *dnsmessage.AResource:
+			hostIPs[name] = append(hostIPs[name], net.IP(body.A[:]))
+		}
+	}
+	var result []found
+	for _, f := range instances {
+		f.ips = hostIPs[f.host]
+		if f.port > 0 && len(f.ips) > 0 {
+			result = append(result, *f)
+		}
+	}
+	return result
+}
+
+// verify connects to the announced node, and saves it as a local address if it responds as an Aether node. Anyone on the local network can announce anything, so nothing is saved before that.
+func verify(f found) {
+	if f.nodeId == globals.NodeId {
+		return
+	}
+	for _, ip := range f.ips {
+		key := fmt.Sprint(ip, ":", f.port)
+		verifiedLock.Lock()
+		last, ok := verified[key]
+		if ok && clock.Since(last) < globals.LanDiscoveryInterval {
+			verifiedLock.Unlock()
+			continue
+		}
+		verified[key] = clock.Now()
+		verifiedLock.Unlock()
+		if !peerrules.Allowed(ip.String(), f.nodeId) {
+			continue
+		}
+		candidate := api.Address{Location: api.Location(ip.String()), LocationType: api.LocationTypeIPv4, Port: f.port, Local: true}
+		addr, _, apiResp, _, err := dispatch.CheckEndpoints(candidate)
+		if err != nil {
+			logging.Log(2, fmt.Sprintf("A node announced on the local network could not be verified. Address: %s, Error: %s", key, err))
+			continue
+		}
+		if len(f.nodeId) > 0 && string(apiResp.NodeId) != f.nodeId {
+			logging.Log(1, fmt.Sprintf("A node announced on the local network responded with a different node id. Address: %s, Announced: %s, Responded: %s", key, f.nodeId, apiResp.NodeId))
+			continue
+		}
+		addr.Local = true
+		err2 := persistence.InsertOrUpdateAddress(addr)
+		if err2 != nil {
+			logging.Log(1, err2)
+			continue
+		}
+		logging.Log(1, fmt.Sprintf("Found a node on the local network. Address: %s, Node: %s", key, apiResp.NodeId))
+		return
+	}
+}
+
+// handle responds to a query for the Aether service, or reads the nodes in a response.
+func handle(packet []byte) {
+	var p dnsmessage.Parser
+	h, err := p.Start(packet)
+	if err != nil {
+		return
+	}
+	if h.Response {
+		p.SkipAllQuestions()
+		for _, f := range readAnnouncements(&p) {
+			go verify(f)
+		}
+		return
+	}
+	questions, err2 := p.AllQuestions()
+	if err2 != nil {
+		return
+	}
+	for _, q := range questions {
+		if q.Name.String() == serviceName && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL) {
+			announce()
+			return
+		}
+	}
+}
+
+// announce sends the address of this node to the local network, if it can be reached from there. A node in privacy mode looks for the others, but doesn't announce itself.
+func announce() {
+	ips := advertisedIPs()
+	if len(ips) == 0 || conn == nil || globals.PrivacyMode {
+		return
+	}
+	msg, err := buildAnswer(ips)
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The local network announcement could not be created. Error: %s", err))
+		return
+	}
+	conn.WriteToUDP(msg, mdnsGroup)
+}
+
+// Query asks the other nodes on the local network to announce themselves. It is run on a schedule.
+func Query() {
+	if conn == nil {
+		return
+	}
+	msg, err := buildQuery()
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The local network query could not be created. Error: %s", err))
+		return
+	}
+	conn.WriteToUDP(msg, mdnsGroup)
+}
+
+// Serve joins the mDNS group and handles the queries and responses on it until the connection closes.
+func Serve() error {
+	c, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Local network discovery could not join the mDNS group. Error: %s", err))
+	}
+	conn = c
+	if len(advertisedIPs()) == 0 {
+		logging.Log(1, "The node listens only on the loopback interface. It will look for the nodes on the local network, but it will not announce itself.")
+	}
+	announce()
+	buf := make([]byte, 9000)
+	for {
+		n, _, err2 := c.ReadFromUDP(buf)
+		if err2 != nil {
+			return err2
+		}
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		handle(packet)
+	}
+}
@@ -0,0 +1,125 @@
+// This test is in the package itself rather than in lan_test, since the mDNS messages are built and read by functions that are not exported. Nothing is sent to the network here.
+
+package lan
+
+import (
+	"aether-core/services/globals"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"strings"
+	"testing"
+)
+
+func parseResponse(t *testing.T, msg []byte) []found {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		t.Fatalf("The message could not be parsed. Error: %s", err)
+	}
+	if !h.Response {
+		t.Fatalf("The message should be a response.")
+	}
+	p.SkipAllQuestions()
+	return readAnnouncements(&p)
+}
+
+func TestShortId_Success(t *testing.T) {
+	old := globals.NodeId
+	defer func() { globals.NodeId = old }()
+	globals.NodeId = strings.Repeat("a", 64)
+	if s := shortId(); len(s) != 32 {
+		t.Errorf("A long node id should be cut to fit into a DNS label. Id: %s", s)
+	}
+	globals.NodeId = "short"
+	if s := shortId(); s != "short" {
+		t.Errorf("A short node id should be used as is. Id: %s", s)
+	}
+}
+
+func TestBuildAnswer_Success(t *testing.T) {
+	globals.SetGlobals()
+	old := globals.NodeId
+	defer func() { globals.NodeId = old }()
+	globals.NodeId = strings.Repeat("b", 64)
+	msg, err := buildAnswer([]net.IP{net.IPv4(192, 168, 1, 20), net.IPv4(10, 0, 0, 5)})
+	if err != nil {
+		t.Fatalf("The answer could not be built. Error: %s", err)
+	}
+	nodes := parseResponse(t, msg)
+	if len(nodes) != 1 {
+		t.Fatalf("The answer should announce one node. Nodes: %#v", nodes)
+	}
+	f := nodes[0]
+	if f.nodeId != globals.NodeId {
+		t.Errorf("The full node id should be announced. Node id: %s", f.nodeId)
+	}
+	if f.port != globals.AddressPort {
+		t.Errorf("The port of the node should be announced. Port: %d", f.port)
+	}
+	if len(f.ips) != 2 || !f.ips[0].Equal(net.IPv4(192, 168, 1, 20)) || !f.ips[1].Equal(net.IPv4(10, 0, 0, 5)) {
+		t.Errorf("All addresses of the node should be announced. Addresses: %v", f.ips)
+	}
+}
+
+func TestReadAnnouncements_Fail_NoAddress(t *testing.T) {
+	globals.SetGlobals()
+	msg, err := buildAnswer([]net.IP{})
+	if err != nil {
+		t.Fatalf("The answer could not be built. Error: %s", err)
+	}
+	if nodes := parseResponse(t, msg); len(nodes) != 0 {
+		t.Errorf("A node without an address can't be connected to, so it should not be read. Nodes: %#v", nodes)
+	}
+}
+
+func TestReadAnnouncements_Fail_OtherService(t *testing.T) {
+	instance := dnsmessage.MustNewName("printer._ipp._tcp.local.")
+	host := dnsmessage.MustNewName("printer.local.")
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{Response: true})
+	b.StartAnswers()
+	b.SRVResource(dnsmessage.ResourceHeader{Name: instance, Class: dnsmessage.ClassINET}, dnsmessage.SRVResource{Port: 631, Target: host})
+	b.AResource(dnsmessage.ResourceHeader{Name: host, Class: dnsmessage.ClassINET}, dnsmessage.AResource{A: [4]byte{192, 168, 1, 30}})
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatalf("The answer could not be built. Error: %s", err)
+	}
+	if nodes := parseResponse(t, msg); len(nodes) != 0 {
+		t.Errorf("A service other than Aether should not be read. Nodes: %#v", nodes)
+	}
+}
+
+func TestBuildQuery_Success(t *testing.T) {
+	msg, err := buildQuery()
+	if err != nil {
+		t.Fatalf("The query could not be built. Error: %s", err)
+	}
+	var p dnsmessage.Parser
+	h, err2 := p.Start(msg)
+	if err2 != nil || h.Response {
+		t.Fatalf("The query should parse as a question. Error: %v", err2)
+	}
+	q, err3 := p.Question()
+	if err3 != nil {
+		t.Fatalf("The query should have a question. Error: %s", err3)
+	}
+	if q.Name.String() != serviceName || q.Type != dnsmessage.TypePTR {
+		t.Errorf("The query should ask for the Aether service. Question: %#v", q)
+	}
+}
+
+func TestAdvertisedIPs_Success(t *testing.T) {
+	old := globals.Listeners
+	defer func() { globals.Listeners = old }()
+	globals.Listeners = []globals.Listener{
+		{Name: "public", Interface: "192.168.1.20", Advertise: true},
+		{Name: "hidden", Interface: "192.168.1.21", Advertise: false},
+	}
+	ips := advertisedIPs()
+	if len(ips) != 1 || !ips[0].Equal(net.IPv4(192, 168, 1, 20)) {
+		t.Errorf("Only the advertised listener should be announced. Addresses: %v", ips)
+	}
+	globals.Listeners = []globals.Listener{{Name: "local", Interface: "127.0.0.1", Advertise: true}}
+	if ips := advertisedIPs(); len(ips) != 0 {
+		t.Errorf("A listener on the loopback interface can't be reached from the network, so it should not be announced. Addresses: %v", ips)
+	}
+}
@@ -5,31 +5,161 @@
 package main
 
 import (
+	"aether-core/backend/backup"
+	"aether-core/backend/bundle"
+	"aether-core/backend/compaction"
+	"aether-core/backend/conformance"
+	"aether-core/backend/diagnostics"
 	"aether-core/backend/dispatch"
+	"aether-core/backend/drafts"
+	"aether-core/backend/events"
+	"aether-core/backend/importer"
+	"aether-core/backend/lan"
+	"aether-core/backend/migration"
+	"aether-core/backend/profiling"
+	"aether-core/backend/publicapi"
+	"aether-core/backend/ranking"
+	"aether-core/backend/replytree"
 	"aether-core/backend/responsegenerator"
 	"aether-core/backend/server"
-	// "aether-core/io/api"
+	"aether-core/backend/setup"
+	"aether-core/backend/storagereport"
+	"aether-core/backend/telemetry"
+	"aether-core/io/api"
 	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/configstore"
 	"aether-core/services/globals"
+	"aether-core/services/jobs"
 	// "aether-core/services/verify"
 	// "crypto/ecdsa"
 	"aether-core/services/logging"
+	"aether-core/services/peerrules"
 	"aether-core/services/scheduling"
 	"aether-core/services/upnp"
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"time"
 )
 
+// RegisterJobs registers the long-running jobs of the backend with the job queue.
+func RegisterJobs() {
+	jobs.Register("cache generation", jobs.Kind{Heavy: true, Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		return responsegenerator.GenerateCachesWatched(cancel, func(p responsegenerator.GenerationProgress) {
+			jobs.ReportProgress(cancel, p)
+		})
+	}})
+	jobs.Register("cache pruning", jobs.Kind{Heavy: true, Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		_, err := responsegenerator.PruneCaches()
+		responsegenerator.SweepRetiredCaches()
+		if globals.CacheArchiveAfterMonths > 0 {
+			if _, err5 := responsegenerator.ArchiveCaches(); err5 != nil {
+				logging.Log(1, fmt.Sprintf("The old caches could not be moved to the archives. Error: %s", err5))
+			}
+		}
+		if _, err2 := responsegenerator.CollectStatics(); err2 != nil {
+			logging.Log(1, fmt.Sprintf("The statics directory could not be collected. Error: %s", err2))
+		}
+		if globals.CacheDedupEnabled {
+			responsegenerator.DedupCaches()
+		}
+		if _, err3 := responsegenerator.DeleteExpiredStoredResponses(); err3 != nil {
+			logging.Log(1, fmt.Sprintf("The expired responses could not be deleted from the database. Error: %s", err3))
+		}
+		if globals.ProvenanceRetentionDays > 0 {
+			cutoff := api.Timestamp(clock.Now().AddDate(0, 0, -globals.ProvenanceRetentionDays).Unix())
+			if _, err4 := persistence.DeleteProvenanceBefore(cutoff); err4 != nil {
+				logging.Log(1, fmt.Sprintf("The old provenance records could not be deleted. Error: %s", err4))
+			}
+		}
+		return err
+	}})
+	jobs.Register("vote compaction", jobs.Kind{Heavy: true, Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		compaction.CompactVotes()
+		// The compacted votes are deleted from the database, and so have to be from the live caches too.
+		responsegenerator.ResetLiveCaches()
+		return nil
+	}})
+	// A backup that fails is tried again, so that a full disk, once cleared, doesn't leave the node without one until the next interval.
+	jobs.Register("backup", jobs.Kind{Heavy: true, MaxAttempts: 3, Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		_, err := backup.Run()
+		return err
+	}})
+	jobs.Register("index backfill", jobs.Kind{Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		persistence.EnsureIndexes()
+		return nil
+	}})
+	jobs.Register("score backfill", jobs.Kind{Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		ranking.RebuildIfEmpty()
+		return nil
+	}})
+	jobs.Register("cache witnessing", jobs.Kind{Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		added, err := responsegenerator.CollectWitnesses()
+		if added > 0 {
+			logging.Log(1, fmt.Sprintf("The witnesses signed %d caches.", added))
+		}
+		return err
+	}})
+	jobs.Register("reply tree backfill", jobs.Kind{Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		replytree.RebuildIfEmpty()
+		return nil
+	}})
+	// The scheduled drafts. A draft that fails to publish is marked failed for the user to fix, rather than tried again.
+	jobs.Register(drafts.JobKind, jobs.Kind{Run: drafts.RunPublishJob})
+}
+
 func StartSchedules() {
 	logging.Log(1, "Setting up cyclical tasks is starting.")
 	defer logging.Log(1, "Setting up cyclical tasks is complete.")
 
-	globals.StopLiveDispatcherCycle = scheduling.Schedule(func() { dispatch.Dispatcher(2) }, 1*time.Minute)
-	globals.StopStaticDispatcherCycle = scheduling.Schedule(func() { dispatch.Dispatcher(255) }, 1*time.Hour)
-	globals.StopAddressScannerCycle = scheduling.Schedule(func() { dispatch.AddressScanner() }, 6*time.Hour)
+	// The syncs run at random times around their intervals in privacy mode.
+	// The live dispatcher syncs more often when the syncs bring a lot, and less when they bring nothing. The static one doesn't, because the caches of the static nodes change only when they are generated again.
+	globals.StopLiveDispatcherCycle = scheduling.ScheduleAdaptive(func() int { return dispatch.Dispatcher(2) }, dispatch.LiveSyncBounds)
+	globals.StopStaticDispatcherCycle = scheduling.ScheduleJittered(func() { dispatch.Dispatcher(255) }, 1*time.Hour)
+	globals.StopAddressScannerCycle = scheduling.ScheduleJittered(func() {
+		dispatch.AddressScanner()
+		dispatch.DecayAddresses()
+	}, 6*time.Hour)
 	globals.StopUPNPCycle = scheduling.Schedule(func() { upnp.MapPort() }, 10*time.Minute)
+	globals.StopConfigReloadCycle = scheduling.Schedule(func() { configstore.Reload() }, globals.ConfigReloadInterval)
+	globals.StopTelemetryCycle = scheduling.Schedule(func() { telemetry.Send() }, time.Hour)
+	if globals.ImporterEnabled {
+		if err := importer.LoadBridgeKey(); err != nil {
+			logging.Log(1, fmt.Sprintf("The importer will not start. Error: %s", err))
+		} else if err := importer.CheckBridgeKey(); err != nil {
+			logging.Log(1, fmt.Sprintf("The importer will not start. Error: %s", err))
+		} else {
+			globals.StopImporterCycle = scheduling.Schedule(func() { importer.Import() }, globals.ImporterPollInterval)
+		}
+	}
+	if globals.LanDiscoveryEnabled {
+		globals.StopLanDiscoveryCycle = scheduling.Schedule(func() { lan.Query() }, globals.LanDiscoveryInterval)
+	}
+	if globals.VoteCompactionEnabled {
+		globals.StopVoteCompactionCycle = jobs.Schedule("vote compaction", jobs.PriorityLow, globals.VoteCompactionInterval)
+	}
+	if globals.ProfileSnapshotsEnabled {
+		globals.StopProfileSnapshotCycle = scheduling.Schedule(func() { profiling.Snapshot() }, globals.ProfileSnapshotInterval)
+	}
+	if globals.BackupEnabled {
+		globals.StopBackupCycle = jobs.Schedule("backup", jobs.PriorityNormal, globals.BackupInterval)
+	}
+	// The witnesses that can't be reached are asked again at the next interval, so a failed collection is not retried sooner.
+	globals.StopCacheWitnessCycle = jobs.Schedule("cache witnessing", jobs.PriorityLow, globals.CacheWitnessInterval)
+	if persistence.ReplicaConfigured() {
+		globals.StopReplicaCheckCycle = scheduling.Schedule(func() { persistence.CheckReplica() }, globals.ReplicaCheckInterval)
+	}
+	globals.StopLogSamplingCycle = scheduling.Schedule(func() { logging.FlushSampled() }, globals.LogSampleWindow)
+	globals.StopOrphanFetchCycle = scheduling.ScheduleJittered(func() { dispatch.FetchMissingParents() }, globals.OrphanFetchInterval)
+	globals.StopNonceSweepCycle = scheduling.Schedule(func() { server.SweepNonces() }, globals.ResponseBindingWindow)
+	// The janitor runs even if there is no cache retention, so that setting one in the config file takes effect without a restart.
+	// The vote compaction, the janitor, the backups and the cache generation are heavy jobs in the job queue: they wait for the maintenance windows, and for each other.
+	globals.StopCacheJanitorCycle = jobs.Schedule("cache pruning", jobs.PriorityNormal, globals.CacheJanitorInterval)
 	/*
 		For cache generation, the logic is like this:
 		- Start a schedule that checks every 5 minutes if the node is mature
@@ -43,7 +173,7 @@ func StartSchedules() {
 		if mature {
 			// If the node is mature, stop the immature cycle and start the mature.
 			logging.Log(1, "The local node is as of now mature. Stopping the maturity check scheduling and starting the cache generation schedule")
-			globals.StopMatureCacheGenerationCycle = scheduling.Schedule(func() { responsegenerator.GenerateCaches() }, 6*time.Hour)
+			globals.StopMatureCacheGenerationCycle = jobs.Schedule("cache generation", jobs.PriorityHigh, 6*time.Hour)
 			globals.StopImmatureCacheGenerationCycle <- true
 		}
 	}
@@ -51,10 +181,71 @@ func StartSchedules() {
 
 }
 
-func ReadFlags() {
-	logIntPtr := flag.Int("logginglevel", 0, "Determines the logging level of the application. Logging level 1 is core messages, 2 is everything. Mind that the more logging you have enabled, the more the app will slow down.")
+// StartupFlags are the command line flags that change what the app does at start, rather than setting a global.
+type StartupFlags struct {
+	DryRun        bool
+	ExportNode    string
+	ExportCaches  bool
+	ImportNode    string
+	ExportBundle  string
+	BundleStart   int64
+	BundleEnd     int64
+	ImportBundle  string
+	Check         bool
+	Repair        bool
+	WriteVectors  string
+	CheckVectors  string
+	StorageReport bool
+	WriteOpenAPI  string
+	RestoreBackup string
+	VerifyBackups bool
+}
+
+// ReadFlags reads the command line flags into globals, and returns the ones that change what happens at start.
+func ReadFlags() StartupFlags {
+	logIntPtr := flag.Int("logginglevel", globals.LoggingLevel, "Determines the logging level of the application. Logging level 1 is core messages, 2 is everything. Mind that the more logging you have enabled, the more the app will slow down.")
+	verboseCacheGenPtr := flag.Bool("verbose-cachegen", globals.CacheGenerationVerbose, "Logs the plan of every cache generation run (entity and page counts per cache) before running it.")
+	prettyJsonPtr := flag.Bool("pretty-json", false, "Writes the responses and the caches as indented JSON, to read them by hand while debugging. They are larger, but the remotes read them the same. This overrides the output_encoders of the config file, until they are changed in it.")
+	dryRunPtr := flag.Bool("dry-run", false, "Prints the plan of the next cache generation run and exits, without writing anything.")
+	exportNodePtr := flag.String("export-node", "", "Writes the identity, database and sync state of this node into the given archive and exits. Use this to move the node to a new machine.")
+	exportCachesPtr := flag.Bool("export-caches", false, "Includes the caches in the archive written by -export-node. Without it, the new machine generates its caches again.")
+	importNodePtr := flag.String("import-node", "", "Restores a node from an archive written by -export-node, then starts as that node.")
+	exportBundlePtr := flag.String("export-bundle", "", "Writes the entities that arrived within the time range given by -bundle-start and -bundle-end into a signed bundle at the given path, and exits. The bundle can be carried to another node and imported there without a network connection.")
+	bundleStartPtr := flag.Int64("bundle-start", 0, "Start of the time range of -export-bundle, as a unix timestamp.")
+	bundleEndPtr := flag.Int64("bundle-end", 0, "End of the time range of -export-bundle, as a unix timestamp. 0 means now.")
+	importBundlePtr := flag.String("import-bundle", "", "Verifies the bundle at the given path, imports the entities in it, and exits.")
+	checkPtr := flag.Bool("check", false, "Checks the database schema, the cache indexes, the key file, the ports and the disk space before starting, prints what it finds, and offers to repair what it can.")
+	repairPtr := flag.Bool("repair", false, "With -check, repairs what can be repaired without asking.")
+	writeVectorsPtr := flag.String("write-test-vectors", "", "Writes the conformance test vectors of the protocol (sample entities, and the responses and caches the node builds out of them) into the given directory, and exits.")
+	checkVectorsPtr := flag.String("check-test-vectors", "", "Builds the responses of the test vectors in the given directory again, prints where they differ from the saved ones, and exits.")
+	writeOpenAPIPtr := flag.String("write-openapi", "", "Writes the OpenAPI description of the peer protocol, the local endpoints and the public API into the given file, and exits. A running node serves the same at /v0/openapi.json.")
+	storageReportPtr := flag.Bool("storage-report", false, "Prints how much the node stores per entity type, in the database and in the caches, how much it grew in the last week, and the largest boards and threads, and exits.")
+	restoreBackupPtr := flag.String("restore-backup", "", "Restores the node from the backup of the given name in the backup destination, or from the last one if given \"latest\", then starts as that node. The backups it builds on are checked first, and nothing is restored if any of them is damaged.")
+	verifyBackupsPtr := flag.Bool("verify-backups", false, "Checks every backup in the backup destination against the hash it was written with, prints the ones that are damaged, and exits.")
 	flag.Parse()
 	globals.LoggingLevel = *logIntPtr
+	globals.CacheGenerationVerbose = *verboseCacheGenPtr
+	if *prettyJsonPtr {
+		globals.OutputEncoders = map[string]string{responsegenerator.DestinationResponses: "json-pretty", responsegenerator.DestinationCaches: "json-pretty"}
+	}
+	return StartupFlags{
+		DryRun:        *dryRunPtr,
+		ExportNode:    *exportNodePtr,
+		ExportCaches:  *exportCachesPtr,
+		ImportNode:    *importNodePtr,
+		ExportBundle:  *exportBundlePtr,
+		BundleStart:   *bundleStartPtr,
+		BundleEnd:     *bundleEndPtr,
+		ImportBundle:  *importBundlePtr,
+		Check:         *checkPtr,
+		Repair:        *repairPtr,
+		WriteVectors:  *writeVectorsPtr,
+		CheckVectors:  *checkVectorsPtr,
+		StorageReport: *storageReportPtr,
+		WriteOpenAPI:  *writeOpenAPIPtr,
+		RestoreBackup: *restoreBackupPtr,
+		VerifyBackups: *verifyBackupsPtr,
+	}
 }
 
 func ShowIntro() {
@@ -88,12 +279,257 @@ func ShowIntro() {
 	fmt.Println("Aether Runtime Environment. Version: dev.v0.0.1")
 }
 
+// ExportNode writes the archive of the node, and exits.
+func ExportNode(path string, includeCaches bool) {
+	err := migration.Export(path, includeCaches)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("The node could not be exported. Error: %s", err))
+		os.Exit(1)
+	}
+	fmt.Println(fmt.Sprintf("The node is exported to %s.", path))
+	os.Exit(0)
+}
+
+// ExportBundle writes the bundle of the time range, and exits.
+func ExportBundle(path string, start int64, end int64) {
+	err := bundle.Export(path, api.Timestamp(start), api.Timestamp(end))
+	if err != nil {
+		fmt.Println(fmt.Sprintf("The bundle could not be exported. Error: %s", err))
+		os.Exit(1)
+	}
+	fmt.Println(fmt.Sprintf("The bundle is exported to %s.", path))
+	os.Exit(0)
+}
+
+// ImportBundle imports the bundle, and exits.
+func ImportBundle(path string) {
+	err := bundle.Import(path)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("The bundle could not be imported. Error: %s", err))
+		os.Exit(1)
+	}
+	fmt.Println(fmt.Sprintf("The bundle is imported from %s.", path))
+	os.Exit(0)
+}
+
+// WriteVectors writes the conformance test vectors, and exits.
+func WriteVectors(dir string) {
+	err := conformance.Write(dir)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("The test vectors could not be written. Error: %s", err))
+		os.Exit(1)
+	}
+	fmt.Println(fmt.Sprintf("The test vectors are written to %s.", dir))
+	os.Exit(0)
+}
+
+// CheckVectors checks the conformance test vectors, and exits.
+func CheckVectors(dir string) {
+	problems, err := conformance.Check(dir)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("The test vectors could not be checked. Error: %s", err))
+		os.Exit(1)
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Println(fmt.Sprintf("The test vectors in %s have %d problems.", dir, len(problems)))
+		os.Exit(1)
+	}
+	fmt.Println(fmt.Sprintf("The test vectors in %s match.", dir))
+	os.Exit(0)
+}
+
+// ImportNode restores the node from the archive. The app continues to start as the imported node afterwards.
+func ImportNode(path string) {
+	err := migration.Import(path)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("The node could not be imported. Error: %s", err))
+		os.Exit(1)
+	}
+	fmt.Println(fmt.Sprintf("The node is imported from %s.", path))
+}
+
+// RestoreBackup restores the node from the backup. The app continues to start as the restored node afterwards.
+func RestoreBackup(name string) {
+	restored, err := backup.Restore(name)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("The node could not be restored. Error: %s", err))
+		os.Exit(1)
+	}
+	for _, s := range restored {
+		fmt.Println(fmt.Sprintf("Restored %s.", s.Name))
+	}
+	fmt.Println(fmt.Sprintf("The node is restored from %s.", backup.Destination()))
+}
+
+// VerifyBackups checks the backups, and exits.
+func VerifyBackups() {
+	problems, err := backup.Verify()
+	if err != nil {
+		fmt.Println(fmt.Sprintf("The backups could not be checked. Error: %s", err))
+		os.Exit(1)
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Println(fmt.Sprintf("%d of the backups in %s are damaged.", len(problems), backup.Destination()))
+		os.Exit(1)
+	}
+	fmt.Println(fmt.Sprintf("The backups in %s are intact.", backup.Destination()))
+	os.Exit(0)
+}
+
+// Check prints the diagnostics of the node, and repairs what can be repaired if the operator agrees, or if repair is set. The app continues to start afterwards.
+func Check(repair bool) {
+	report := diagnostics.Run()
+	fmt.Print(report.String())
+	if !report.Repairable() {
+		return
+	}
+	if !repair {
+		fmt.Print("Repair the problems that can be repaired? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		repair = answer == "y" || answer == "yes"
+	}
+	if !repair {
+		return
+	}
+	for _, result := range diagnostics.Repair(&report) {
+		fmt.Println(result)
+	}
+}
+
+// WriteOpenAPI writes the API description, and exits.
+func WriteOpenAPI(path string) {
+	data, err := json.MarshalIndent(server.OpenAPI(true), "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		fmt.Println(fmt.Sprintf("The API description could not be written. Error: %s", err))
+		os.Exit(1)
+	}
+	fmt.Println(fmt.Sprintf("The API description is written to %s.", path))
+	os.Exit(0)
+}
+
+// StorageReport prints what the node stores, and exits.
+func StorageReport() {
+	report, err := storagereport.Generate(globals.StorageReportLargest)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("The storage report could not be generated. Error: %s", err))
+		os.Exit(1)
+	}
+	fmt.Print(report.String())
+	os.Exit(0)
+}
+
+// DryRun prints what the next cache generation run would create, and exits.
+func DryRun() {
+	gp, err := responsegenerator.PlanCaches()
+	if err != nil {
+		fmt.Println(fmt.Sprintf("The cache generation plan could not be created. Error: %s", err))
+		os.Exit(1)
+	}
+	fmt.Print(responsegenerator.FormatPlan(gp))
+	os.Exit(0)
+}
+
 func Startup() {
 	globals.SetGlobals()
+	// The config file is applied before the flags are read, so that the flags can still override it.
+	err0 := configstore.Load()
+	if err0 != nil {
+		logging.LogCrash(err0)
+	}
+	flags := ReadFlags()
+	if flags.DryRun {
+		// The plan only reads the database, so nothing is created or set up for it.
+		DryRun()
+	}
 	persistence.CreateDatabase()
 	ShowIntro()
-	ReadFlags()
+	err := migration.LoadIdentity()
+	if err != nil {
+		logging.LogCrash(err)
+	}
+	err2 := peerrules.Load()
+	if err2 != nil {
+		logging.LogCrash(err2)
+	}
+	if len(flags.ExportNode) > 0 {
+		ExportNode(flags.ExportNode, flags.ExportCaches)
+	}
+	if len(flags.ImportNode) > 0 {
+		ImportNode(flags.ImportNode)
+	}
+	if flags.VerifyBackups {
+		VerifyBackups()
+	}
+	if len(flags.RestoreBackup) > 0 {
+		RestoreBackup(flags.RestoreBackup)
+	}
+	if len(flags.ExportBundle) > 0 {
+		ExportBundle(flags.ExportBundle, flags.BundleStart, flags.BundleEnd)
+	}
+	if len(flags.ImportBundle) > 0 {
+		ImportBundle(flags.ImportBundle)
+	}
+	if len(flags.WriteVectors) > 0 {
+		WriteVectors(flags.WriteVectors)
+	}
+	if len(flags.CheckVectors) > 0 {
+		CheckVectors(flags.CheckVectors)
+	}
+	if flags.StorageReport {
+		StorageReport()
+	}
+	if len(flags.WriteOpenAPI) > 0 {
+		WriteOpenAPI(flags.WriteOpenAPI)
+	}
+	if flags.Check {
+		Check(flags.Repair)
+	}
+	responsegenerator.CleanStaging()
+	err4 := setup.LoadSubscriptions()
+	if err4 != nil {
+		logging.Log(1, fmt.Sprintf("The subscriptions could not be read from the setup. The vote sync policies take the user as subscribed to no boards. Error: %s", err4))
+	}
+	// The listeners are bound before anything that reads the port of the address is started, since the port is only known once they are.
+	err5 := server.Bind()
+	if err5 != nil {
+		logging.LogCrash(err5)
+	}
+	RegisterJobs()
+	err3 := jobs.Start()
+	if err3 != nil {
+		logging.LogCrash(err3)
+	}
+	jobs.EnqueueOnce("index backfill", jobs.PriorityHigh)
+	jobs.EnqueueOnce("score backfill", jobs.PriorityNormal)
+	jobs.EnqueueOnce("reply tree backfill", jobs.PriorityNormal)
+	go events.ServeSocket()
+	go publicapi.Serve()
+	if globals.LanDiscoveryEnabled {
+		go func() {
+			err := lan.Serve()
+			if err != nil {
+				logging.Log(1, err)
+			}
+		}()
+	}
 	StartSchedules()
+	if len(flags.ImportNode) > 0 {
+		// The listeners need a moment to come up before remotes can connect back to the new location.
+		go func() {
+			time.Sleep(10 * time.Second)
+			migration.Announce(globals.MigrationAnnounceCount)
+		}()
+	}
 }
 
 func Shutdown() {
@@ -103,6 +539,34 @@ func Shutdown() {
 	globals.StopStaticDispatcherCycle <- true
 	globals.StopAddressScannerCycle <- true
 	globals.StopUPNPCycle <- true
+	globals.StopConfigReloadCycle <- true
+	globals.StopTelemetryCycle <- true
+	globals.StopCacheJanitorCycle <- true
+	globals.StopCacheWitnessCycle <- true
+	globals.StopLogSamplingCycle <- true
+	globals.StopOrphanFetchCycle <- true
+	globals.StopNonceSweepCycle <- true
+	if globals.ImporterEnabled && globals.StopImporterCycle != nil {
+		// The importer doesn't start without a usable bridge key.
+		globals.StopImporterCycle <- true
+	}
+	if globals.VoteCompactionEnabled {
+		globals.StopVoteCompactionCycle <- true
+	}
+	if globals.LanDiscoveryEnabled {
+		globals.StopLanDiscoveryCycle <- true
+	}
+	if globals.ProfileSnapshotsEnabled {
+		globals.StopProfileSnapshotCycle <- true
+	}
+	if globals.BackupEnabled {
+		globals.StopBackupCycle <- true
+	}
+	if persistence.ReplicaConfigured() {
+		globals.StopReplicaCheckCycle <- true
+	}
+	events.StopSocket()
+	jobs.Stop()
 	mature, err := persistence.LocalNodeIsMature()
 	if err != nil {
 		logging.LogCrash(err)
@@ -0,0 +1,517 @@
+// Backend > Migration
+// This package moves a node to a new machine. It packages the identity, the database, the sync state and optionally the caches of the node into one archive, and restores them from it on the other side.
+
+package migration
+
+import (
+	"aether-core/backend/dispatch"
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveVersion is increased when the layout of the archive changes in a way older versions can't read.
+const archiveVersion = 1
+
+// entityTypes are the types that are moved through the archive. Addresses are moved too, but they are restored differently.
+var entityTypes = []string{"boards", "threads", "posts", "votes", "keys", "truststates", "tombstones", "addresses"}
+
+// Manifest describes what is in the archive.
+type Manifest struct {
+	Version        int            `json:"version"`
+	Created        int64          `json:"created"`
+	NodeId         string         `json:"node_id"`
+	IncludesCaches bool           `json:"includes_caches"`
+	EntityCounts   map[string]int `json:"entity_counts"`
+	Since          int64          `json:"since,omitempty"` // The entities in the archive are the ones that arrived after this. 0 is all of them. The addresses are always all there.
+	Until          int64          `json:"until"`           // The entities that arrived up to this are in the archive.
+}
+
+// Identity is what makes the node the same node on the new machine.
+type Identity struct {
+	NodeId                       string `json:"node_id"`
+	PrivateKey                   string `json:"private_key"` // Hex of the DER encoded EC private key.
+	UserKeyFingerprint           string `json:"user_key_fingerprint"`
+	UserPrivateKey               string `json:"user_private_key,omitempty"` // Hex of the DER encoded EC private key the entities of the user are signed with, if it is not the key of the node.
+	NetworkId                    string `json:"network_id"`
+	NetworkMembershipKey         string `json:"network_membership_key"`
+	LastCacheGenerationTimestamp int64  `json:"last_cache_generation_timestamp"`
+}
+
+func currentIdentity() (Identity, error) {
+	var id Identity
+	der, err := x509.MarshalECPrivateKey(globals.KeyPair)
+	if err != nil {
+		return id, errors.New(fmt.Sprintf("The key of the node could not be encoded. Error: %s", err))
+	}
+	id.NodeId = globals.NodeId
+	id.PrivateKey = hex.EncodeToString(der)
+	userFp, userKey := globals.UserIdentity()
+	id.UserKeyFingerprint = userFp
+	if userKey != nil {
+		userDer, err2 := x509.MarshalECPrivateKey(userKey)
+		if err2 != nil {
+			return id, errors.New(fmt.Sprintf("The key of the user could not be encoded. Error: %s", err2))
+		}
+		id.UserPrivateKey = hex.EncodeToString(userDer)
+	}
+	id.NetworkId = globals.NetworkId
+	id.NetworkMembershipKey = globals.NetworkMembershipKey
+	id.LastCacheGenerationTimestamp = globals.LastCacheGenerationTimestamp
+	return id, nil
+}
+
+func applyIdentity(id Identity) error {
+	der, err := hex.DecodeString(id.PrivateKey)
+	if err != nil {
+		return errors.New(fmt.Sprintf("The key in the identity could not be decoded. Error: %s", err))
+	}
+	key, err2 := x509.ParseECPrivateKey(der)
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The key in the identity could not be parsed. Error: %s", err2))
+	}
+	var userKey *ecdsa.PrivateKey
+	if len(id.UserPrivateKey) > 0 {
+		userDer, err3 := hex.DecodeString(id.UserPrivateKey)
+		if err3 != nil {
+			return errors.New(fmt.Sprintf("The key of the user in the identity could not be decoded. Error: %s", err3))
+		}
+		userKey, err3 = x509.ParseECPrivateKey(userDer)
+		if err3 != nil {
+			return errors.New(fmt.Sprintf("The key of the user in the identity could not be parsed. Error: %s", err3))
+		}
+	}
+	globals.KeyPair = key
+	globals.SetUserIdentity(id.UserKeyFingerprint, userKey)
+	globals.MarshaledPubKey = hex.EncodeToString(elliptic.Marshal(elliptic.P521(), key.PublicKey.X, key.PublicKey.Y))
+	globals.NodeId = id.NodeId
+	globals.NetworkId = id.NetworkId
+	globals.NetworkMembershipKey = id.NetworkMembershipKey
+	globals.LastCacheGenerationTimestamp = id.LastCacheGenerationTimestamp
+	return nil
+}
+
+func identityPath() string {
+	return fmt.Sprint(globals.UserDirectory, "/identity.json")
+}
+
+// LoadIdentity restores the identity saved by an earlier import, if there is one. This has to run after SetGlobals, since it overrides what SetGlobals generated.
+func LoadIdentity() error {
+	data, err := ioutil.ReadFile(identityPath())
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var id Identity
+	err2 := json.Unmarshal(data, &id)
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The saved identity could not be read. Error: %s", err2))
+	}
+	return applyIdentity(id)
+}
+
+// CheckIdentity checks that the saved identity, if there is one, can be read, and that its key is the one the node is running with. It returns false if there is no saved identity.
+func CheckIdentity() (bool, error) {
+	data, err := ioutil.ReadFile(identityPath())
+	if err != nil && os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return true, err
+	}
+	var id Identity
+	err2 := json.Unmarshal(data, &id)
+	if err2 != nil {
+		return true, errors.New(fmt.Sprintf("The saved identity could not be read. Error: %s", err2))
+	}
+	der, err3 := hex.DecodeString(id.PrivateKey)
+	if err3 != nil {
+		return true, errors.New(fmt.Sprintf("The key in the identity could not be decoded. Error: %s", err3))
+	}
+	key, err4 := x509.ParseECPrivateKey(der)
+	if err4 != nil {
+		return true, errors.New(fmt.Sprintf("The key in the identity could not be parsed. Error: %s", err4))
+	}
+	if globals.KeyPair == nil || key.D.Cmp(globals.KeyPair.D) != 0 {
+		return true, errors.New("The key in the identity is not the key the node is running with.")
+	}
+	return true, nil
+}
+
+// writeIdentity saves the identity, so that the node starts as it from now on.
+func writeIdentity(id Identity) error {
+	data, err := json.Marshal(id)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(globals.UserDirectory, 0755)
+	tmp := fmt.Sprint(identityPath(), ".tmp")
+	err2 := ioutil.WriteFile(tmp, data, 0600)
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The identity could not be saved. Error: %s", err2))
+	}
+	return os.Rename(tmp, identityPath())
+}
+
+// SaveIdentity saves the identity the node is running with, so that it starts with the same key and node id from now on, instead of the ones SetGlobals generates.
+func SaveIdentity() error {
+	id, err := currentIdentity()
+	if err != nil {
+		return err
+	}
+	return writeIdentity(id)
+}
+
+// SetIdentityNetwork changes the network in the saved identity, if there is one. The saved identity overrides the config file at start, so a change of the network has to be made in both.
+func SetIdentityNetwork(networkId string, membershipKey string) error {
+	data, err := ioutil.ReadFile(identityPath())
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var id Identity
+	err2 := json.Unmarshal(data, &id)
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The saved identity could not be read. Error: %s", err2))
+	}
+	id.NetworkId = networkId
+	id.NetworkMembershipKey = membershipKey
+	return writeIdentity(id)
+}
+
+// addFile adds a single file to the archive.
+func addFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: clock.Now()}
+	err := tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err2 := tw.Write(data)
+	return err2
+}
+
+// addIdentity adds the identity the node is running with to the archive.
+func addIdentity(tw *tar.Writer) error {
+	id, err := currentIdentity()
+	if err != nil {
+		return err
+	}
+	idJson, _ := json.Marshal(id)
+	return addFile(tw, "identity.json", idJson)
+}
+
+// addDirectory adds everything under dir to the archive, under the given prefix.
+func addDirectory(tw *tar.Writer, dir string, prefix string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err2 := filepath.Rel(dir, path)
+		if err2 != nil {
+			return err2
+		}
+		data, err3 := ioutil.ReadFile(path)
+		if err3 != nil {
+			return err3
+		}
+		return addFile(tw, fmt.Sprint(prefix, "/", filepath.ToSlash(rel)), data)
+	})
+}
+
+// readEntities reads the entities of a type that arrived after since and up to until, regardless of the caches. The addresses are read whole.
+func readEntities(entityType string, since int64, until int64) (api.Response, error) {
+	if entityType == "addresses" {
+		var resp api.Response
+		addresses, err := persistence.ReadAddresses("", "", 0, 0, 0, 0, 0, 0)
+		resp.Addresses = addresses
+		return resp, err
+	}
+	return persistence.ReadInRange(entityType, api.Timestamp(since), api.Timestamp(until+1))
+}
+
+func countEntities(r *api.Response) int {
+	return len(r.Boards) + len(r.Threads) + len(r.Posts) + len(r.Votes) + len(r.Keys) + len(r.Truststates) + len(r.Tombstones) + len(r.Addresses)
+}
+
+// Export writes the archive of the node to the given path. Caches can be left out, since the new machine can generate them again from the database.
+func Export(archivePath string, includeCaches bool) error {
+	_, err := ExportSince(archivePath, includeCaches, 0)
+	return err
+}
+
+// ExportSince writes an archive of the node with only the entities that arrived after since, along with the identity, the sync state and the addresses as they are now. Importing it on top of the archives before it brings the node up to date. 0 exports all of the entities, as Export does.
+func ExportSince(archivePath string, includeCaches bool, since int64) (Manifest, error) {
+	manifest := Manifest{Version: archiveVersion, Created: clock.Unix(), NodeId: globals.NodeId, IncludesCaches: includeCaches, EntityCounts: make(map[string]int), Since: since}
+	manifest.Until = manifest.Created
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return manifest, errors.New(fmt.Sprintf("The archive could not be created. Error: %s", err))
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	defer gz.Close()
+	tw := tar.NewWriter(gz)
+	defer tw.Close()
+	err3 := addIdentity(tw)
+	if err3 != nil {
+		return manifest, err3
+	}
+	nodes, err4 := persistence.ReadAllNodes()
+	if err4 != nil {
+		return manifest, err4
+	}
+	nodesJson, _ := json.Marshal(nodes)
+	err5 := addFile(tw, "nodes.json", nodesJson)
+	if err5 != nil {
+		return manifest, err5
+	}
+	for _, entityType := range entityTypes {
+		resp, err6 := readEntities(entityType, since, manifest.Until)
+		if err6 != nil {
+			return manifest, errors.New(fmt.Sprintf("The entities could not be read. Entity type: %s, Error: %s", entityType, err6))
+		}
+		manifest.EntityCounts[entityType] = countEntities(&resp)
+		respJson, err7 := json.Marshal(resp)
+		if err7 != nil {
+			return manifest, err7
+		}
+		err8 := addFile(tw, fmt.Sprint("entities/", entityType, ".json"), respJson)
+		if err8 != nil {
+			return manifest, err8
+		}
+	}
+	if includeCaches {
+		if _, statErr := os.Stat(globals.CachesLocation); statErr == nil {
+			err9 := addDirectory(tw, globals.CachesLocation, "caches")
+			if err9 != nil {
+				return manifest, errors.New(fmt.Sprintf("The caches could not be added to the archive. Error: %s", err9))
+			}
+		}
+	}
+	// The manifest goes last, so that an archive cut short is noticed on import.
+	manifestJson, _ := json.Marshal(manifest)
+	err10 := addFile(tw, "manifest.json", manifestJson)
+	if err10 != nil {
+		return manifest, err10
+	}
+	logging.Log(1, fmt.Sprintf("The node is exported to %s. Entities: %v, Since: %d, Caches included: %t", archivePath, manifest.EntityCounts, since, includeCaches))
+	return manifest, nil
+}
+
+// CheckArchive reads the archive through without importing anything, and gives its manifest. It fails if the archive is damaged: if the gzip checksum doesn't match, if a file in it can't be read, or if the manifest, which goes last, is missing.
+func CheckArchive(archivePath string) (Manifest, error) {
+	var manifest Manifest
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return manifest, errors.New(fmt.Sprintf("The archive could not be opened. Error: %s", err))
+	}
+	defer f.Close()
+	gz, err2 := gzip.NewReader(f)
+	if err2 != nil {
+		return manifest, errors.New(fmt.Sprintf("The archive is not a valid gzip file. Error: %s", err2))
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	sawManifest := false
+	for {
+		hdr, err3 := tr.Next()
+		if err3 == io.EOF {
+			break
+		}
+		if err3 != nil {
+			return manifest, errors.New(fmt.Sprintf("The archive could not be read. Error: %s", err3))
+		}
+		data, err4 := ioutil.ReadAll(tr)
+		if err4 != nil {
+			return manifest, errors.New(fmt.Sprintf("A file in the archive could not be read. File: %s, Error: %s", hdr.Name, err4))
+		}
+		if hdr.Name == "manifest.json" {
+			err5 := json.Unmarshal(data, &manifest)
+			if err5 != nil {
+				return manifest, errors.New(fmt.Sprintf("The manifest of the archive could not be read. Error: %s", err5))
+			}
+			sawManifest = true
+		}
+	}
+	// The gzip checksum is checked when the end of the stream is read.
+	if _, err6 := io.Copy(ioutil.Discard, gz); err6 != nil {
+		return manifest, errors.New(fmt.Sprintf("The archive is damaged. Error: %s", err6))
+	}
+	if !sawManifest {
+		return manifest, errors.New("The archive has no manifest. It is either not a node archive, or it was cut short.")
+	}
+	return manifest, nil
+}
+
+func moveEntitiesToInterfacePack(r *api.Response) []interface{} {
+	var carrier []interface{}
+	for i, _ := range r.Boards {
+		carrier = append(carrier, r.Boards[i])
+	}
+	for i, _ := range r.Threads {
+		carrier = append(carrier, r.Threads[i])
+	}
+	for i, _ := range r.Posts {
+		carrier = append(carrier, r.Posts[i])
+	}
+	for i, _ := range r.Votes {
+		carrier = append(carrier, r.Votes[i])
+	}
+	for i, _ := range r.Keys {
+		carrier = append(carrier, r.Keys[i])
+	}
+	for i, _ := range r.Truststates {
+		carrier = append(carrier, r.Truststates[i])
+	}
+	for i, _ := range r.Tombstones {
+		carrier = append(carrier, r.Tombstones[i])
+	}
+	return carrier
+}
+
+// safeCachePath returns where a cache file in the archive goes, making sure it can't end up outside of the caches directory.
+func safeCachePath(name string) (string, error) {
+	rel := strings.TrimPrefix(name, "caches/")
+	clean := filepath.Clean(filepath.FromSlash(rel))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, fmt.Sprint("..", string(filepath.Separator))) {
+		return "", errors.New(fmt.Sprintf("The archive has a cache file outside of the caches directory. Name: %s", name))
+	}
+	return filepath.Join(globals.CachesLocation, clean), nil
+}
+
+// Import restores a node from the archive. The database has to exist already. The identity is saved into the user directory, so that it survives restarts.
+func Import(archivePath string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return errors.New(fmt.Sprintf("The archive could not be opened. Error: %s", err))
+	}
+	defer f.Close()
+	gz, err2 := gzip.NewReader(f)
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The archive is not a valid gzip file. Error: %s", err2))
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	sawManifest := false
+	for {
+		hdr, err3 := tr.Next()
+		if err3 == io.EOF {
+			break
+		}
+		if err3 != nil {
+			return errors.New(fmt.Sprintf("The archive could not be read. Error: %s", err3))
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err4 := ioutil.ReadAll(tr)
+		if err4 != nil {
+			return err4
+		}
+		switch {
+		case hdr.Name == "manifest.json":
+			var manifest Manifest
+			err5 := json.Unmarshal(data, &manifest)
+			if err5 != nil {
+				return errors.New(fmt.Sprintf("The manifest of the archive could not be read. Error: %s", err5))
+			}
+			if manifest.Version > archiveVersion {
+				return errors.New(fmt.Sprintf("The archive was created by a newer version of the app. Archive version: %d", manifest.Version))
+			}
+			sawManifest = true
+		case hdr.Name == "identity.json":
+			var id Identity
+			err5 := json.Unmarshal(data, &id)
+			if err5 != nil {
+				return errors.New(fmt.Sprintf("The identity in the archive could not be read. Error: %s", err5))
+			}
+			err6 := applyIdentity(id)
+			if err6 != nil {
+				return err6
+			}
+			os.MkdirAll(globals.UserDirectory, 0755)
+			err7 := ioutil.WriteFile(identityPath(), data, 0600)
+			if err7 != nil {
+				return errors.New(fmt.Sprintf("The identity could not be saved. Error: %s", err7))
+			}
+		case hdr.Name == "nodes.json":
+			var nodes []persistence.DbNode
+			err5 := json.Unmarshal(data, &nodes)
+			if err5 != nil {
+				return errors.New(fmt.Sprintf("The node states in the archive could not be read. Error: %s", err5))
+			}
+			for i, _ := range nodes {
+				err6 := persistence.InsertNode(nodes[i])
+				if err6 != nil {
+					logging.Log(1, fmt.Sprintf("A node state could not be restored. Node: %s, Error: %s", nodes[i].Fingerprint, err6))
+				}
+			}
+		case strings.HasPrefix(hdr.Name, "entities/"):
+			var resp api.Response
+			err5 := json.Unmarshal(data, &resp)
+			if err5 != nil {
+				return errors.New(fmt.Sprintf("The entities in the archive could not be read. File: %s, Error: %s", hdr.Name, err5))
+			}
+			// Addresses are our own records here, not something a remote told us, so they go in as they are.
+			persistence.InsertOrUpdateAddresses(&resp.Addresses)
+			pack := moveEntitiesToInterfacePack(&resp)
+			if len(pack) > 0 {
+				err6 := persistence.BatchInsertFrom(pack, persistence.Source{Node: persistence.LocalSource.Node, Via: "migration"})
+				if err6 != nil {
+					return errors.New(fmt.Sprintf("The entities could not be restored. File: %s, Error: %s", hdr.Name, err6))
+				}
+			}
+		case strings.HasPrefix(hdr.Name, "caches/"):
+			path, err5 := safeCachePath(hdr.Name)
+			if err5 != nil {
+				return err5
+			}
+			os.MkdirAll(filepath.Dir(path), 0755)
+			err6 := ioutil.WriteFile(path, data, 0644)
+			if err6 != nil {
+				return err6
+			}
+		}
+	}
+	if !sawManifest {
+		return errors.New("The archive has no manifest. It is either not a node archive, or it was cut short.")
+	}
+	logging.Log(1, fmt.Sprintf("The node is imported from %s.", archivePath))
+	return nil
+}
+
+// Announce syncs with a few online nodes right away, so that the network learns the new location of the node without waiting for the regular dispatch cycles.
+func Announce(count int) {
+	addrs, err := dispatch.GetOnlineAddresses(count, []api.Address{}, 2)
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The new location of the node could not be announced. Error: %s", err))
+		return
+	}
+	for i, _ := range addrs {
+		_, err2 := dispatch.Sync(addrs[i])
+		if err2 != nil {
+			logging.Log(1, fmt.Sprintf("Announcing the new location to a remote failed. Address: %s:%d, Error: %s", addrs[i].Location, addrs[i].Port, err2))
+		}
+	}
+	logging.Log(1, fmt.Sprintf("The new location of the node is announced to %d remotes.", len(addrs)))
+}
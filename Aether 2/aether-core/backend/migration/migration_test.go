@@ -0,0 +1,95 @@
+// This test is in the package itself rather than in migration_test, since the archive is written with functions that are not exported, and the parts of it that come from the database can't be written without one.
+
+package migration
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/signaturing"
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeIdentityArchive writes an archive with the identity of the node and the manifest, as Export does, but without the database.
+func writeIdentityArchive(t *testing.T, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	defer gz.Close()
+	tw := tar.NewWriter(gz)
+	defer tw.Close()
+	err2 := addIdentity(tw)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	manifestJson, _ := json.Marshal(Manifest{Version: archiveVersion, NodeId: globals.NodeId})
+	err3 := addFile(tw, "manifest.json", manifestJson)
+	if err3 != nil {
+		t.Fatal(err3)
+	}
+}
+
+func TestImport_Success_Identity(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "migration")
+	defer os.RemoveAll(dir)
+	globals.SetGlobals()
+	globals.UserDirectory = filepath.Join(dir, "old")
+	globals.NodeId = "old node"
+	globals.UserKeyFingerprint = "user key"
+	globals.UserKeyPair, _ = signaturing.CreateKeyPair()
+	globals.NetworkId = "friends"
+	globals.NetworkMembershipKey = "membership key"
+	globals.LastCacheGenerationTimestamp = 1600000000
+	old, _ := currentIdentity()
+	archivePath := filepath.Join(dir, "node.tar.gz")
+	writeIdentityArchive(t, archivePath)
+	// The new machine starts with what SetGlobals generates.
+	globals.SetGlobals()
+	globals.UserDirectory = filepath.Join(dir, "new")
+	globals.UserKeyPair = nil
+	globals.NetworkId = ""
+	globals.NetworkMembershipKey = ""
+	globals.LastCacheGenerationTimestamp = 0
+	err := Import(archivePath)
+	if err != nil {
+		t.Fatalf("The archive should have been imported. Error: %s", err)
+	}
+	imported, _ := currentIdentity()
+	if imported != old {
+		t.Errorf("The identity was not restored as it was exported. Exported: %#v, Imported: %#v", old, imported)
+	}
+	// The identity is saved, so that it is the one the node starts with from now on.
+	globals.SetGlobals()
+	globals.UserDirectory = filepath.Join(dir, "new")
+	err2 := LoadIdentity()
+	if err2 != nil {
+		t.Fatalf("The saved identity should have been loaded. Error: %s", err2)
+	}
+	loaded, _ := currentIdentity()
+	if loaded != old {
+		t.Errorf("The saved identity is not the one that was exported. Exported: %#v, Loaded: %#v", old, loaded)
+	}
+	if saved, err3 := CheckIdentity(); !saved || err3 != nil {
+		t.Errorf("The saved identity should match the key the node runs with. Saved: %t, Error: %v", saved, err3)
+	}
+}
+
+func TestCheckArchive_Fail_CutShort(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "migration")
+	defer os.RemoveAll(dir)
+	globals.SetGlobals()
+	archivePath := filepath.Join(dir, "node.tar.gz")
+	writeIdentityArchive(t, archivePath)
+	data, _ := ioutil.ReadFile(archivePath)
+	ioutil.WriteFile(archivePath, data[:len(data)/2], 0600)
+	if _, err := CheckArchive(archivePath); err == nil {
+		t.Errorf("An archive that was cut short was accepted.")
+	}
+}
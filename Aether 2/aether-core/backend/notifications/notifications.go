@@ -0,0 +1,227 @@
+// Backend > Notifications
+// This package watches the newly ingested posts for replies to the content of the local user, and for mentions of the local user's key, and it saves them as notifications for the frontend to show. A reply can arrive before its parent; when a thread or a post of the user arrives, the replies to it that are already in the database are looked at again. It also flags the new activity in the threads and boards the user watches, see backend/watches: the posts in a watched thread, and the threads in a watched board.
+
+package notifications
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Notification is the frontend-facing form of a notification.
+type Notification struct {
+	Post      api.Fingerprint `json:"post"`
+	Type      string          `json:"type"`   // "reply", "mention" or "watch"
+	Target    api.Fingerprint `json:"target"` // For a watch, the watched thread or board.
+	Thread    api.Fingerprint `json:"thread"`
+	Owner     api.Fingerprint `json:"owner"`
+	Creation  api.Timestamp   `json:"creation"`
+	Seen      bool            `json:"seen"`
+	Collapsed bool            `json:"collapsed,omitempty"` // The post matched a content filter of the user that collapses rather than hides.
+}
+
+// isMention checks whether the body mentions the given key fingerprint. Mentions are in the form of @fingerprint.
+func isMention(body string, keyFp api.Fingerprint) bool {
+	if len(keyFp) == 0 {
+		return false
+	}
+	return strings.Contains(body, fmt.Sprint("@", keyFp))
+}
+
+// findParentOwner finds the owner of the parent of a post, looking first into the response the post came in, and then into the database. The parent can either be a thread or a post.
+func findParentOwner(post api.Post, resp *api.Response) (api.Fingerprint, error) {
+	for i, _ := range resp.Posts {
+		if resp.Posts[i].Fingerprint == post.Parent {
+			return resp.Posts[i].Owner, nil
+		}
+	}
+	for i, _ := range resp.Threads {
+		if resp.Threads[i].Fingerprint == post.Parent {
+			return resp.Threads[i].Owner, nil
+		}
+	}
+	posts, err := persistence.ReadPosts([]api.Fingerprint{post.Parent}, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	if len(posts) > 0 {
+		return posts[0].Owner, nil
+	}
+	threads, err2 := persistence.ReadThreads([]api.Fingerprint{post.Parent}, 0, 0)
+	if err2 != nil {
+		return "", err2
+	}
+	if len(threads) > 0 {
+		return threads[0].Owner, nil
+	}
+	// The parent hasn't arrived yet.
+	return "", nil
+}
+
+// readWatches reads the threads and the boards the user watches.
+func readWatches(userFp api.Fingerprint) (map[api.Fingerprint]bool, map[api.Fingerprint]bool) {
+	threads := make(map[api.Fingerprint]bool)
+	boards := make(map[api.Fingerprint]bool)
+	ws, err := persistence.ReadWatches(userFp)
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The watches of the user could not be read while generating notifications. Error: %s", err))
+	}
+	for i, _ := range ws {
+		switch ws[i].Type {
+		case "thread":
+			threads[ws[i].Target] = true
+		case "board":
+			boards[ws[i].Target] = true
+		}
+	}
+	return threads, boards
+}
+
+// userParents gives the threads and the posts of the user in the response, which the replies that arrived before them are replies to.
+func userParents(resp *api.Response, userFp api.Fingerprint) []api.Fingerprint {
+	var parents []api.Fingerprint
+	for i, _ := range resp.Threads {
+		if resp.Threads[i].Owner == userFp {
+			parents = append(parents, resp.Threads[i].Fingerprint)
+		}
+	}
+	for i, _ := range resp.Posts {
+		if resp.Posts[i].Owner == userFp {
+			parents = append(parents, resp.Posts[i].Fingerprint)
+		}
+	}
+	return parents
+}
+
+// earlierReplies leaves the replies that arrived before the response, and are not the user's own. The ones in the response are looked at with the rest of it.
+func earlierReplies(replies []api.Post, resp *api.Response, userFp api.Fingerprint) []api.Post {
+	inResp := make(map[api.Fingerprint]bool)
+	for i, _ := range resp.Posts {
+		inResp[resp.Posts[i].Fingerprint] = true
+	}
+	var earlier []api.Post
+	for i, _ := range replies {
+		if !inResp[replies[i].Fingerprint] && replies[i].Owner != userFp {
+			earlier = append(earlier, replies[i])
+		}
+	}
+	return earlier
+}
+
+// replyNotification is the notification of a reply to the content of the user.
+func replyNotification(post api.Post, now api.Timestamp) persistence.DbNotification {
+	var n persistence.DbNotification
+	n.Post = post.Fingerprint
+	n.Type = "reply"
+	n.Target = post.Parent
+	n.Thread = post.Thread
+	n.Owner = post.Owner
+	n.Creation = post.Creation
+	n.LocalArrival = now
+	return n
+}
+
+// Generate looks at the posts and the threads in a response that was just committed to the database, and creates the notifications for the local user.
+func Generate(resp *api.Response) {
+	userFp := api.Fingerprint(globals.CurrentUserKeyFingerprint())
+	if len(userFp) == 0 || (len(resp.Posts) == 0 && len(resp.Threads) == 0) {
+		// The user has no key yet, so nothing can be a reply to them or a mention of them.
+		return
+	}
+	watchedThreads, watchedBoards := readWatches(userFp)
+	var ns []persistence.DbNotification
+	now := api.Timestamp(time.Now().Unix())
+	for _, thread := range resp.Threads {
+		if thread.Owner == userFp || !watchedBoards[thread.Board] {
+			continue
+		}
+		var n persistence.DbNotification
+		n.Post = thread.Fingerprint
+		n.Type = "watch"
+		n.Target = thread.Board
+		n.Thread = thread.Fingerprint
+		n.Owner = thread.Owner
+		n.Creation = thread.Creation
+		n.LocalArrival = now
+		ns = append(ns, n)
+	}
+	for _, post := range resp.Posts {
+		if post.Owner == userFp {
+			// The user's own posts do not generate notifications.
+			continue
+		}
+		parentOwner, err := findParentOwner(post, resp)
+		if err != nil {
+			logging.Log(1, fmt.Sprintf("The parent of this post could not be read while generating notifications. Post: %#v, Error: %s", post, err))
+		}
+		if parentOwner == userFp {
+			ns = append(ns, replyNotification(post, now))
+		}
+		if isMention(post.Body, userFp) {
+			var n persistence.DbNotification
+			n.Post = post.Fingerprint
+			n.Type = "mention"
+			n.Target = userFp
+			n.Thread = post.Thread
+			n.Owner = post.Owner
+			n.Creation = post.Creation
+			n.LocalArrival = now
+			ns = append(ns, n)
+		}
+		if parentOwner != userFp && !isMention(post.Body, userFp) && watchedThreads[post.Thread] {
+			// A reply or a mention flags the post already.
+			var n persistence.DbNotification
+			n.Post = post.Fingerprint
+			n.Type = "watch"
+			n.Target = post.Thread
+			n.Thread = post.Thread
+			n.Owner = post.Owner
+			n.Creation = post.Creation
+			n.LocalArrival = now
+			ns = append(ns, n)
+		}
+	}
+	// The replies that arrived before their parent weren't known to be replies to the user then. The notifications that exist already are kept as they are.
+	replies, err := persistence.ReadReplies(userParents(resp, userFp))
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The replies that arrived before their parents could not be read while generating notifications. Error: %s", err))
+	}
+	for _, post := range earlierReplies(replies, resp, userFp) {
+		ns = append(ns, replyNotification(post, now))
+	}
+	err2 := persistence.InsertNotifications(ns)
+	if err2 != nil {
+		logging.Log(1, fmt.Sprintf("Notifications could not be saved. Error: %s", err2))
+	}
+}
+
+// List returns the notifications of the local user, newest first.
+func List(onlyUnseen bool) ([]Notification, error) {
+	var result []Notification
+	dbNs, err := persistence.ReadNotifications(onlyUnseen)
+	if err != nil {
+		return result, err
+	}
+	for _, dbN := range dbNs {
+		var n Notification
+		n.Post = dbN.Post
+		n.Type = dbN.Type
+		n.Target = dbN.Target
+		n.Thread = dbN.Thread
+		n.Owner = dbN.Owner
+		n.Creation = dbN.Creation
+		n.Seen = dbN.Seen
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// MarkSeen marks the notifications of the given posts as seen. If no posts are given, all notifications are marked as seen.
+func MarkSeen(posts []api.Fingerprint) error {
+	return persistence.MarkNotificationsSeen(posts)
+}
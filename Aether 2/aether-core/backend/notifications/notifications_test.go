@@ -0,0 +1,79 @@
+// This test is in the package itself rather than in notifications_test, since the replies that arrived before their parents are picked by functions that are not exported. Reading them and saving the notifications needs the database, so only the picking is tested here.
+
+package notifications
+
+import (
+	"aether-core/io/api"
+	"reflect"
+	"testing"
+)
+
+func notificationsTestPost(fp api.Fingerprint, owner api.Fingerprint, parent api.Fingerprint) api.Post {
+	var p api.Post
+	p.Fingerprint = fp
+	p.Owner = owner
+	p.Parent = parent
+	p.Thread = "thread"
+	return p
+}
+
+func TestIsMention_Success(t *testing.T) {
+	if !isMention("Thanks @userkey, that helped.", "userkey") {
+		t.Errorf("A mention of the key should have been found.")
+	}
+	if isMention("Thanks userkey.", "userkey") || isMention("Thanks @.", "") {
+		t.Errorf("A key without the @, or an empty key, is not a mention.")
+	}
+}
+
+func TestFindParentOwner_Success_InResponse(t *testing.T) {
+	var resp api.Response
+	resp.Threads = []api.Thread{api.Thread{ProvableFieldSet: api.ProvableFieldSet{Fingerprint: "thread"}, Owner: "threadowner"}}
+	resp.Posts = []api.Post{notificationsTestPost("parent", "parentowner", "thread")}
+	if owner, err := findParentOwner(notificationsTestPost("reply", "other", "parent"), &resp); owner != "parentowner" || err != nil {
+		t.Errorf("The owner of the parent post in the response should have been found. Owner: %s, Error: %v", owner, err)
+	}
+	if owner, err := findParentOwner(notificationsTestPost("reply", "other", "thread"), &resp); owner != "threadowner" || err != nil {
+		t.Errorf("The owner of the parent thread in the response should have been found. Owner: %s, Error: %v", owner, err)
+	}
+}
+
+func TestUserParents_Success(t *testing.T) {
+	var resp api.Response
+	resp.Threads = []api.Thread{
+		api.Thread{ProvableFieldSet: api.ProvableFieldSet{Fingerprint: "userthread"}, Owner: "user"},
+		api.Thread{ProvableFieldSet: api.ProvableFieldSet{Fingerprint: "otherthread"}, Owner: "other"},
+	}
+	resp.Posts = []api.Post{
+		notificationsTestPost("userpost", "user", "otherthread"),
+		notificationsTestPost("otherpost", "other", "userthread"),
+	}
+	expected := []api.Fingerprint{"userthread", "userpost"}
+	if parents := userParents(&resp, "user"); !reflect.DeepEqual(parents, expected) {
+		t.Errorf("Only the threads and the posts of the user should be parents to look at. Parents: %v", parents)
+	}
+	if parents := userParents(&api.Response{}, "user"); len(parents) != 0 {
+		t.Errorf("An empty response has no parents. Parents: %v", parents)
+	}
+}
+
+func TestEarlierReplies_Success(t *testing.T) {
+	var resp api.Response
+	resp.Posts = []api.Post{
+		notificationsTestPost("userpost", "user", "thread"),
+		notificationsTestPost("arrivedtogether", "other", "userpost"),
+	}
+	replies := []api.Post{
+		notificationsTestPost("orphan", "other", "userpost"),
+		notificationsTestPost("arrivedtogether", "other", "userpost"),
+		notificationsTestPost("ownreply", "user", "userpost"),
+	}
+	earlier := earlierReplies(replies, &resp, "user")
+	if len(earlier) != 1 || earlier[0].Fingerprint != "orphan" {
+		t.Errorf("Only the reply that arrived before its parent, from someone else, should be looked at again. Replies: %#v", earlier)
+	}
+	n := replyNotification(earlier[0], 100)
+	if n.Post != "orphan" || n.Type != "reply" || n.Target != "userpost" || n.Thread != "thread" || n.LocalArrival != 100 {
+		t.Errorf("Unexpected notification of the reply. Notification: %#v", n)
+	}
+}
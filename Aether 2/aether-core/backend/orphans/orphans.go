@@ -0,0 +1,114 @@
+// Backend > Orphans
+// This package deals with the entities whose parents haven't arrived yet, such as a thread whose board is still missing. The frontend gets a placeholder with only the fingerprint of the missing parent, so that it can show what is there, and the fingerprint is kept here as wanted. The dispatcher asks remotes for the wanted parents by fingerprint, and once they arrive, the placeholders are replaced by the real entities.
+
+package orphans
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/globals"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Placeholder stands in for a parent that hasn't arrived yet.
+type Placeholder struct {
+	Fingerprint api.Fingerprint `json:"fingerprint"`
+	EntityType  string          `json:"entity_type"`
+	Placeholder bool            `json:"placeholder"` // Always true. This is what tells a placeholder apart from the entity.
+}
+
+type wantedParent struct {
+	entityType string
+	attempts   int
+}
+
+var lock sync.Mutex
+var wanted = make(map[api.Fingerprint]*wantedParent)
+
+func parentType(entityType string) bool {
+	return entityType == "boards" || entityType == "threads"
+}
+
+// Want marks a parent as missing, so that it is asked for. Only boards and threads can be wanted, since they are the parents of the others. Past OrphanMaxWanted, new parents are not taken until some of the wanted arrive or are given up on.
+func Want(entityType string, fp api.Fingerprint) error {
+	if !parentType(entityType) {
+		return errors.New(fmt.Sprintf("Only boards and threads can be asked for as parents. Entity type: %s", entityType))
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if _, ok := wanted[fp]; ok {
+		return nil
+	}
+	if len(wanted) >= globals.OrphanMaxWanted {
+		return errors.New(fmt.Sprintf("There are too many parents wanted already. Maximum: %d", globals.OrphanMaxWanted))
+	}
+	wanted[fp] = &wantedParent{entityType: entityType}
+	return nil
+}
+
+// Wanted gives the wanted parents by entity type, in the order of their fingerprints. Every call counts as an attempt to get them; the ones that were attempted OrphanMaxAttempts times without arriving are given up on.
+func Wanted() map[string][]api.Fingerprint {
+	lock.Lock()
+	defer lock.Unlock()
+	result := make(map[string][]api.Fingerprint)
+	for fp, w := range wanted {
+		if w.attempts >= globals.OrphanMaxAttempts {
+			delete(wanted, fp)
+			continue
+		}
+		w.attempts++
+		result[w.entityType] = append(result[w.entityType], fp)
+	}
+	for t, _ := range result {
+		fps := result[t]
+		sort.Slice(fps, func(i, j int) bool { return fps[i] < fps[j] })
+	}
+	return result
+}
+
+// Arrived marks parents as no longer wanted.
+func Arrived(fps []api.Fingerprint) {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, fp := range fps {
+		delete(wanted, fp)
+	}
+}
+
+// IsWanted checks whether a parent is wanted.
+func IsWanted(fp api.Fingerprint) bool {
+	lock.Lock()
+	defer lock.Unlock()
+	_, ok := wanted[fp]
+	return ok
+}
+
+// BoardOrPlaceholder gives the board with the given fingerprint, or, if it hasn't arrived yet, a placeholder for it. A missing board is wanted from then on.
+func BoardOrPlaceholder(fp api.Fingerprint) (interface{}, error) {
+	boards, err := persistence.ReadBoards([]api.Fingerprint{fp}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(boards) > 0 {
+		return boards[0], nil
+	}
+	// Being over the limit of wanted parents doesn't stop the placeholder from being given.
+	Want("boards", fp)
+	return Placeholder{Fingerprint: fp, EntityType: "boards", Placeholder: true}, nil
+}
+
+// ThreadOrPlaceholder gives the thread with the given fingerprint, or, if it hasn't arrived yet, a placeholder for it. A missing thread is wanted from then on.
+func ThreadOrPlaceholder(fp api.Fingerprint) (interface{}, error) {
+	threads, err := persistence.ReadThreads([]api.Fingerprint{fp}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(threads) > 0 {
+		return threads[0], nil
+	}
+	Want("threads", fp)
+	return Placeholder{Fingerprint: fp, EntityType: "threads", Placeholder: true}, nil
+}
@@ -0,0 +1,66 @@
+package orphans_test
+
+import (
+	"aether-core/backend/orphans"
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"os"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+}
+
+func teardown() {
+}
+
+// Tests
+
+func TestWanted_Success(t *testing.T) {
+	orphans.Want("threads", "thread2")
+	orphans.Want("boards", "board1")
+	orphans.Want("threads", "thread1")
+	orphans.Want("threads", "thread1")
+	want := orphans.Wanted()
+	if len(want["boards"]) != 1 || len(want["threads"]) != 2 || want["threads"][0] != "thread1" {
+		t.Errorf("Unexpected wanted parents. Wanted: %v", want)
+	}
+	orphans.Arrived([]api.Fingerprint{"board1", "thread1", "thread2"})
+	if orphans.IsWanted("board1") || len(orphans.Wanted()) != 0 {
+		t.Errorf("The parents that arrived should no longer be wanted.")
+	}
+}
+
+func TestWanted_Fail_GivenUp(t *testing.T) {
+	globals.OrphanMaxAttempts = 2
+	defer func() { globals.OrphanMaxAttempts = 5 }()
+	orphans.Want("boards", "never")
+	orphans.Wanted()
+	orphans.Wanted()
+	if len(orphans.Wanted()) != 0 || orphans.IsWanted("never") {
+		t.Errorf("A parent that didn't arrive after the maximum attempts should be given up on.")
+	}
+}
+
+func TestWant_Fail(t *testing.T) {
+	if err := orphans.Want("posts", "post1"); err == nil {
+		t.Errorf("Posts are not parents of anything that can be asked for.")
+	}
+	globals.OrphanMaxWanted = 1
+	defer func() { globals.OrphanMaxWanted = 1000 }()
+	orphans.Want("boards", "first")
+	defer orphans.Arrived([]api.Fingerprint{"first"})
+	if err := orphans.Want("boards", "second"); err == nil || orphans.IsWanted("second") {
+		t.Errorf("Over the limit, new parents should not be wanted.")
+	}
+}
@@ -0,0 +1,301 @@
+// Backend > Pairing
+// This package lets the user post from more than one device with one identity. The device the user has the key on exports a pairing bundle: the key of the user, and the history of what the user authored with it, encrypted with a passphrase. The other device imports it, and from then on signs the entities of the user with that key, while keeping its own node key and node id, so that the remotes see two nodes and one user.
+// The history in the bundle is committed on the other device as the entities the operator creates are, so the user's own threads and posts show there before a sync brings them, and the replies and the mentions already in its database become notifications. What either device publishes afterwards reaches the other through the network, as anything else does.
+// The bundle holds the private key of the user, which on the device the user started on is also the key of the node. The passphrase is stretched with PBKDF2 and the bundle sealed with AES-GCM, but it is only as safe as the passphrase; it should be moved between the devices directly and deleted after the import.
+
+package pairing
+
+import (
+	"aether-core/backend/migration"
+	"aether-core/backend/notifications"
+	"aether-core/backend/readmarkers"
+	"aether-core/backend/responsegenerator"
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// bundleVersion is increased when the layout of the bundle changes in a way older versions can't read.
+	bundleVersion = 1
+	// minPassphraseLength is the shortest passphrase a bundle is sealed with.
+	minPassphraseLength = 12
+	// kdfIterations is how many times the passphrase is hashed into the key the bundle is sealed with. The ones a bundle was sealed with are in it, so this can grow.
+	kdfIterations = 600000
+	// maxKdfIterations is the most iterations a bundle can ask for. A bundle asking for more would keep the node hashing before the passphrase could even be checked.
+	maxKdfIterations = 10 * kdfIterations
+)
+
+// Bundle is the sealed pairing bundle, as it is moved between the devices.
+type Bundle struct {
+	Version    int    `json:"version"`
+	Kdf        string `json:"kdf"` // "pbkdf2-sha256"
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`       // Hex.
+	Nonce      string `json:"nonce"`      // Hex.
+	Ciphertext string `json:"ciphertext"` // Base64 of the sealed contents.
+}
+
+// contents is what is sealed in the bundle.
+type contents struct {
+	UserKeyFingerprint api.Fingerprint      `json:"user_key_fingerprint"`
+	UserPrivateKey     string               `json:"user_private_key"` // Hex of the DER encoded EC private key.
+	Origin             string               `json:"origin"`           // The node id of the device that exported it.
+	Created            int64                `json:"created"`
+	Authored           api.Answer           `json:"authored"`
+	ReadMarkers        []readmarkers.Marker `json:"read_markers,omitempty"`
+}
+
+// ImportReport is what importing a bundle did.
+type ImportReport struct {
+	UserKeyFingerprint api.Fingerprint `json:"user_key_fingerprint"`
+	Origin             string          `json:"origin"`
+	Created            int64           `json:"created"`
+	Authored           int             `json:"authored"` // The entities of the user in the bundle.
+	Accepted           int             `json:"accepted"` // The ones that were committed, or were here already.
+	Rejected           int             `json:"rejected"`
+	Notifications      int             `json:"notifications"` // The replies and the mentions already here that were looked at for notifications.
+	ReadMarkers        int             `json:"read_markers"`  // The read markers that were further than the ones here.
+}
+
+// sealingKey stretches the passphrase into the key the bundle is sealed with.
+func sealingKey(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err2 := aes.NewCipher(key)
+	if err2 != nil {
+		return nil, err2
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts the plaintext into a bundle with the passphrase.
+func Seal(plaintext []byte, passphrase string) (Bundle, error) {
+	var b Bundle
+	if len(passphrase) < minPassphraseLength {
+		return b, errors.New(fmt.Sprintf("The passphrase of the pairing bundle has to be at least %d characters.", minPassphraseLength))
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return b, err
+	}
+	aead, err2 := sealingKey(passphrase, salt, kdfIterations)
+	if err2 != nil {
+		return b, err2
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err3 := rand.Read(nonce); err3 != nil {
+		return b, err3
+	}
+	b.Version = bundleVersion
+	b.Kdf = "pbkdf2-sha256"
+	b.Iterations = kdfIterations
+	b.Salt = hex.EncodeToString(salt)
+	b.Nonce = hex.EncodeToString(nonce)
+	// The header is bound to the ciphertext, so that the iterations can't be lowered to guess the passphrase faster.
+	b.Ciphertext = base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, header(b)))
+	return b, nil
+}
+
+// Open decrypts the bundle with the passphrase.
+func Open(b Bundle, passphrase string) ([]byte, error) {
+	if b.Version != bundleVersion || b.Kdf != "pbkdf2-sha256" {
+		return nil, errors.New(fmt.Sprintf("The pairing bundle is of a version this node can't read. Version: %d, Kdf: %s", b.Version, b.Kdf))
+	}
+	if b.Iterations < 1 || b.Iterations > maxKdfIterations {
+		return nil, errors.New(fmt.Sprintf("The pairing bundle is malformed. Iterations: %d, Maximum: %d", b.Iterations, maxKdfIterations))
+	}
+	salt, err := hex.DecodeString(b.Salt)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("The pairing bundle is malformed. Error: %s", err))
+	}
+	nonce, err2 := hex.DecodeString(b.Nonce)
+	if err2 != nil {
+		return nil, errors.New(fmt.Sprintf("The pairing bundle is malformed. Error: %s", err2))
+	}
+	sealed, err3 := base64.StdEncoding.DecodeString(b.Ciphertext)
+	if err3 != nil {
+		return nil, errors.New(fmt.Sprintf("The pairing bundle is malformed. Error: %s", err3))
+	}
+	aead, err4 := sealingKey(passphrase, salt, b.Iterations)
+	if err4 != nil {
+		return nil, err4
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New(fmt.Sprintf("The pairing bundle is malformed. Nonce size: %d", len(nonce)))
+	}
+	plaintext, err5 := aead.Open(nil, nonce, sealed, header(b))
+	if err5 != nil {
+		return nil, errors.New("The pairing bundle could not be opened. The passphrase is wrong, or the bundle was changed.")
+	}
+	return plaintext, nil
+}
+
+// header is the part of the bundle that is authenticated but not encrypted.
+func header(b Bundle) []byte {
+	return []byte(fmt.Sprintf("%d|%s|%d|%s|%s", b.Version, b.Kdf, b.Iterations, b.Salt, b.Nonce))
+}
+
+// publicKeyOf is the public key as the key entities carry it.
+func publicKeyOf(key *ecdsa.PrivateKey) string {
+	return hex.EncodeToString(elliptic.Marshal(elliptic.P521(), key.PublicKey.X, key.PublicKey.Y))
+}
+
+// Export seals the key of the local user and what the user authored with it into a pairing bundle.
+func Export(passphrase string) (Bundle, error) {
+	userFp := api.Fingerprint(globals.CurrentUserKeyFingerprint())
+	if len(userFp) == 0 {
+		return Bundle{}, errors.New("The user has no key yet, so there is no identity to pair with.")
+	}
+	der, err := x509.MarshalECPrivateKey(globals.UserSigningKey())
+	if err != nil {
+		return Bundle{}, errors.New(fmt.Sprintf("The key of the user could not be encoded. Error: %s", err))
+	}
+	authored, err2 := persistence.ReadAuthored(userFp)
+	if err2 != nil {
+		return Bundle{}, err2
+	}
+	markers, err3 := readmarkers.Export()
+	if err3 != nil {
+		return Bundle{}, err3
+	}
+	c := contents{
+		UserKeyFingerprint: userFp,
+		UserPrivateKey:     hex.EncodeToString(der),
+		Origin:             globals.NodeId,
+		Created:            clock.Unix(),
+		Authored:           api.Answer{Boards: authored.Boards, Threads: authored.Threads, Posts: authored.Posts, Votes: authored.Votes, Keys: authored.Keys, Truststates: authored.Truststates, Tombstones: authored.Tombstones},
+		ReadMarkers:        markers,
+	}
+	plaintext, err4 := json.Marshal(c)
+	if err4 != nil {
+		return Bundle{}, err4
+	}
+	b, err5 := Seal(plaintext, passphrase)
+	if err5 != nil {
+		return b, err5
+	}
+	logging.Log(1, fmt.Sprintf("A pairing bundle was exported. User key: %s, Authored entities: %d", userFp, countAuthored(&c.Authored)))
+	return b, nil
+}
+
+func countAuthored(a *api.Answer) int {
+	return len(a.Boards) + len(a.Threads) + len(a.Posts) + len(a.Votes) + len(a.Keys) + len(a.Truststates) + len(a.Tombstones)
+}
+
+// Import opens a pairing bundle, makes its key the key the entities of the local user are signed with, commits the history of the user in it, and creates the notifications of the user from what is already in the database. The identity is saved before the history is committed, so that a bundle whose identity can't be saved leaves nothing of it behind.
+func Import(b Bundle, passphrase string) (ImportReport, error) {
+	var report ImportReport
+	plaintext, err := Open(b, passphrase)
+	if err != nil {
+		return report, err
+	}
+	var c contents
+	err2 := json.Unmarshal(plaintext, &c)
+	if err2 != nil {
+		return report, errors.New(fmt.Sprintf("The contents of the pairing bundle could not be read. Error: %s", err2))
+	}
+	der, err3 := hex.DecodeString(c.UserPrivateKey)
+	if err3 != nil {
+		return report, errors.New(fmt.Sprintf("The key in the pairing bundle could not be decoded. Error: %s", err3))
+	}
+	key, err4 := x509.ParseECPrivateKey(der)
+	if err4 != nil {
+		return report, errors.New(fmt.Sprintf("The key in the pairing bundle could not be parsed. Error: %s", err4))
+	}
+	// The key entity of the user has to be the one of the private key, or the entities signed with it would not verify as the user's.
+	matched := false
+	for i, _ := range c.Authored.Keys {
+		if c.Authored.Keys[i].Fingerprint == c.UserKeyFingerprint && c.Authored.Keys[i].Key == publicKeyOf(key) {
+			matched = true
+		}
+	}
+	if !matched {
+		return report, errors.New(fmt.Sprintf("The key entity of the user in the pairing bundle is missing, or it is not of the key in it. User key: %s", c.UserKeyFingerprint))
+	}
+	report.UserKeyFingerprint, report.Origin, report.Created = c.UserKeyFingerprint, c.Origin, c.Created
+	report.Authored = countAuthored(&c.Authored)
+	previousFp, previousKey := globals.UserIdentity()
+	userKey := key
+	if key.D.Cmp(globals.KeyPair.D) == 0 {
+		// Paired with itself. The key of the node is the key of the user already.
+		userKey = nil
+	}
+	globals.SetUserIdentity(string(c.UserKeyFingerprint), userKey)
+	err5 := migration.SaveIdentity()
+	if err5 != nil {
+		globals.SetUserIdentity(previousFp, previousKey)
+		return report, err5
+	}
+	for _, s := range responsegenerator.AcceptLocal(c.Authored) {
+		if s.Status == api.EntityAccepted {
+			report.Accepted++
+		} else {
+			report.Rejected++
+		}
+	}
+	// The markers are saved after the identity, since they belong to the profile of the user.
+	saved, err6 := readmarkers.Merge(c.ReadMarkers)
+	report.ReadMarkers = saved
+	if err6 != nil {
+		logging.Log(1, fmt.Sprintf("The read markers of the paired user could not be saved. Error: %s", err6))
+	}
+	n, err7 := backfillNotifications(c.UserKeyFingerprint)
+	report.Notifications = n
+	if err7 != nil {
+		// The pairing is done; the notifications of the replies that arrive from now on are created as they arrive.
+		logging.Log(1, fmt.Sprintf("The notifications of the paired user could not be created from the database. Error: %s", err7))
+	}
+	logging.Log(1, fmt.Sprintf("The device is paired. User key: %s, Origin: %s, Authored: %d, Accepted: %d, Rejected: %d", report.UserKeyFingerprint, report.Origin, report.Authored, report.Accepted, report.Rejected))
+	return report, nil
+}
+
+// backfillNotifications creates the notifications of the user from the replies to the threads and posts of the user, and from the mentions of the user, that are already in the database. The notifications that exist are kept as they are.
+func backfillNotifications(userFp api.Fingerprint) (int, error) {
+	authored, err := persistence.ReadAuthored(userFp)
+	if err != nil {
+		return 0, err
+	}
+	var parents []api.Fingerprint
+	for i, _ := range authored.Threads {
+		parents = append(parents, authored.Threads[i].Fingerprint)
+	}
+	for i, _ := range authored.Posts {
+		parents = append(parents, authored.Posts[i].Fingerprint)
+	}
+	replies, err2 := persistence.ReadReplies(parents)
+	if err2 != nil {
+		return 0, err2
+	}
+	mentions, err3 := persistence.ReadMentions(userFp)
+	if err3 != nil {
+		return 0, err3
+	}
+	seen := make(map[api.Fingerprint]bool)
+	var resp api.Response
+	for _, p := range append(replies, mentions...) {
+		if !seen[p.Fingerprint] {
+			seen[p.Fingerprint] = true
+			resp.Posts = append(resp.Posts, p)
+		}
+	}
+	notifications.Generate(&resp)
+	return len(resp.Posts), nil
+}
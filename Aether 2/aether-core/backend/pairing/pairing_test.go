@@ -0,0 +1,124 @@
+package pairing_test
+
+import (
+	"aether-core/backend/pairing"
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"aether-core/services/signaturing"
+	"bytes"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+const passphrase = "correct horse battery staple"
+
+func TestSeal_Success(t *testing.T) {
+	plaintext := []byte(`{"user_key_fingerprint": "abc"}`)
+	b, err := pairing.Seal(plaintext, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains([]byte(b.Ciphertext), plaintext) {
+		t.Errorf("The bundle is not encrypted. Bundle: %#v", b)
+	}
+	opened, err2 := pairing.Open(b, passphrase)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("The opened bundle is not what was sealed. Opened: %s", opened)
+	}
+}
+
+func TestSeal_Fail_ShortPassphrase(t *testing.T) {
+	_, err := pairing.Seal([]byte("{}"), "short")
+	if err == nil {
+		t.Errorf("A bundle was sealed with a passphrase too short.")
+	}
+}
+
+func TestOpen_Fail_WrongPassphrase(t *testing.T) {
+	b, err := pairing.Seal([]byte("{}"), passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err2 := pairing.Open(b, "incorrect horse battery staple")
+	if err2 == nil {
+		t.Errorf("A bundle was opened with the wrong passphrase.")
+	}
+}
+
+func TestOpen_Fail_LoweredIterations(t *testing.T) {
+	b, err := pairing.Seal([]byte("{}"), passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Iterations = 1
+	_, err2 := pairing.Open(b, passphrase)
+	if err2 == nil {
+		t.Errorf("A bundle was opened after its iterations were changed.")
+	}
+}
+
+func TestOpen_Fail_TooManyIterations(t *testing.T) {
+	b, err := pairing.Seal([]byte("{}"), passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Iterations = 1 << 30
+	start := time.Now()
+	_, err2 := pairing.Open(b, passphrase)
+	if err2 == nil {
+		t.Errorf("A bundle asking for more iterations than the maximum was opened.")
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("The iterations of the bundle should have been refused before the passphrase was hashed.")
+	}
+}
+
+func TestImport_Fail_IdentityNotSaved(t *testing.T) {
+	globals.SetGlobals()
+	// The identity can't be saved under a file, so the import has to stop before anything of the bundle is committed.
+	f, err := ioutil.TempFile("", "aether-pairing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	globals.UserDirectory = f.Name() + "/user"
+	globals.SetUserIdentity("previous user key", nil)
+	defer globals.SetUserIdentity("", nil)
+	key, _ := signaturing.CreateKeyPair()
+	der, _ := x509.MarshalECPrivateKey(key)
+	pub := hex.EncodeToString(elliptic.Marshal(elliptic.P521(), key.PublicKey.X, key.PublicKey.Y))
+	var k api.Key
+	k.Fingerprint = "paired user key"
+	k.Key = pub
+	contents := map[string]interface{}{
+		"user_key_fingerprint": "paired user key",
+		"user_private_key":     hex.EncodeToString(der),
+		"origin":               "other node",
+		"authored":             api.Answer{Keys: []api.Key{k}},
+	}
+	plaintext, _ := json.Marshal(contents)
+	b, err2 := pairing.Seal(plaintext, passphrase)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	report, err3 := pairing.Import(b, passphrase)
+	if err3 == nil {
+		t.Errorf("The import should have failed when the identity could not be saved.")
+	}
+	if report.Accepted != 0 || report.Rejected != 0 {
+		t.Errorf("The history should not have been committed before the identity was saved. Report: %#v", report)
+	}
+	if fp, userKey := globals.UserIdentity(); fp != "previous user key" || userKey != nil {
+		t.Errorf("The identity of the user should have been restored. Fingerprint: %s", fp)
+	}
+}
@@ -0,0 +1,135 @@
+// Backend > Profiling
+// This package lets the operator see where the memory and the goroutines of the node go. Profiles can be taken on demand from the admin endpoints, or written periodically into the user directory while investigating a problem that takes hours to show up, such as the memory growing during cache generation.
+
+package profiling
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotProfiles are the profiles written into every snapshot.
+var SnapshotProfiles = []string{"heap", "goroutine"}
+
+// snapshotPrefix starts the name of every snapshot file, so that the rotation only ever deletes the files it created.
+const snapshotPrefix = "snapshot_"
+
+// SnapshotDirectory is where the periodic snapshots are written.
+func SnapshotDirectory() string {
+	return filepath.Join(globals.UserDirectory, "profiles")
+}
+
+// WriteProfile writes the named runtime profile, such as "heap" or "goroutine", in the format go tool pprof reads. With a debug level above 0, it is written as text instead.
+func WriteProfile(w io.Writer, name string, debug int) error {
+	p := pprof.Lookup(name)
+	if p == nil {
+		return errors.New(fmt.Sprintf("This profile is unknown. Profile: %s", name))
+	}
+	if name == "heap" {
+		// The heap profile shows the state as of the last garbage collection, which can be minutes old on an idle node.
+		runtime.GC()
+	}
+	return p.WriteTo(w, debug)
+}
+
+// ProfileNames returns the names of the profiles that can be written.
+func ProfileNames() []string {
+	var names []string
+	for _, p := range pprof.Profiles() {
+		names = append(names, p.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WriteCPUProfile profiles the CPU for the given duration, and writes the result. Only one CPU profile can run at a time.
+func WriteCPUProfile(w io.Writer, d time.Duration) error {
+	err := pprof.StartCPUProfile(w)
+	if err != nil {
+		return errors.New(fmt.Sprintf("The CPU profile could not be started. Error: %s", err))
+	}
+	time.Sleep(d)
+	pprof.StopCPUProfile()
+	return nil
+}
+
+// WriteSnapshot writes every profile in SnapshotProfiles into the snapshot directory, named after the time of the snapshot, and deletes the oldest snapshots beyond the number to keep.
+func WriteSnapshot() error {
+	dir := SnapshotDirectory()
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	stamp := clock.Now().UTC().Format("20060102T150405")
+	for _, name := range SnapshotProfiles {
+		path := filepath.Join(dir, fmt.Sprint(snapshotPrefix, stamp, "_", name, ".pprof"))
+		f, err2 := os.Create(path)
+		if err2 != nil {
+			return err2
+		}
+		err3 := WriteProfile(f, name, 0)
+		f.Close()
+		if err3 != nil {
+			return err3
+		}
+	}
+	return rotate(dir)
+}
+
+// rotate deletes the oldest snapshots, keeping the newest ProfileSnapshotsKept of them. The names start with the time of the snapshot, so sorting them by name sorts them by time.
+func rotate(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var stamps []string
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), snapshotPrefix) {
+			continue
+		}
+		stamp := strings.SplitN(strings.TrimPrefix(f.Name(), snapshotPrefix), "_", 2)[0]
+		if !seen[stamp] {
+			seen[stamp] = true
+			stamps = append(stamps, stamp)
+		}
+	}
+	sort.Strings(stamps)
+	if len(stamps) <= globals.ProfileSnapshotsKept {
+		return nil
+	}
+	expired := make(map[string]bool)
+	for _, stamp := range stamps[0 : len(stamps)-globals.ProfileSnapshotsKept] {
+		expired[stamp] = true
+	}
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), snapshotPrefix) {
+			continue
+		}
+		stamp := strings.SplitN(strings.TrimPrefix(f.Name(), snapshotPrefix), "_", 2)[0]
+		if expired[stamp] {
+			os.Remove(filepath.Join(dir, f.Name()))
+		}
+	}
+	return nil
+}
+
+// Snapshot is the scheduled form of WriteSnapshot. It logs the errors instead of returning them.
+func Snapshot() {
+	err := WriteSnapshot()
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The profile snapshot could not be written. Error: %s", err))
+	}
+}
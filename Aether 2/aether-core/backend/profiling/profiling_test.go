@@ -0,0 +1,75 @@
+package profiling_test
+
+import (
+	"aether-core/backend/profiling"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Infrastructure, setup and teardown
+
+var dir string
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+	var err error
+	dir, err = ioutil.TempDir("", "aether-profiling")
+	if err != nil {
+		panic(err)
+	}
+	globals.UserDirectory = dir
+}
+
+func teardown() {
+	clock.Reset()
+	os.RemoveAll(dir)
+}
+
+// Tests
+
+func TestWriteSnapshot_Success(t *testing.T) {
+	globals.ProfileSnapshotsKept = 2
+	mc := clock.NewMockClock(time.Unix(1500000000, 0))
+	clock.Set(mc)
+	for i := 0; i < 3; i++ {
+		err := profiling.WriteSnapshot()
+		if err != nil {
+			t.Fatalf("The snapshot could not be written. Error: %s", err)
+		}
+		mc.Advance(time.Minute)
+	}
+	files, err := ioutil.ReadDir(profiling.SnapshotDirectory())
+	if err != nil {
+		t.Fatalf("The snapshot directory could not be read. Error: %s", err)
+	}
+	expected := 2 * len(profiling.SnapshotProfiles)
+	if len(files) != expected {
+		t.Errorf("Expected %d files after the rotation, got %d.", expected, len(files))
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), "snapshot_20170714T024000") {
+			t.Errorf("The oldest snapshot should have been deleted. File: %s", f.Name())
+		}
+	}
+}
+
+func TestWriteProfile_Fail_Unknown(t *testing.T) {
+	var buf bytes.Buffer
+	err := profiling.WriteProfile(&buf, "no such profile", 0)
+	if err == nil {
+		t.Errorf("An unknown profile should not be written.")
+	}
+}
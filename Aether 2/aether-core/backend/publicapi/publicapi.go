@@ -0,0 +1,342 @@
+// Backend > Public API
+// This package provides an opt-in, read-only, rate-limited REST API over the node's data for third-party apps and bots. It is separate from the peer protocol and it listens on its own port.
+
+package publicapi
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route is an endpoint of the public API, for the API description.
+type Route struct {
+	Path    string   // With the fingerprints in braces.
+	Params  []string // The query parameters.
+	Summary string
+	// Item is a value of the type of the entities a list endpoint gives in its pages. Entity is the same for the endpoints that give a single entity.
+	Item   interface{}
+	Entity interface{}
+}
+
+// Routes are the endpoints of the public API, all GET. The list endpoints accept "limit" and "cursor", and respond with {"data": [...], "next_cursor": "..."}. The next cursor is empty on the last page.
+var Routes = []Route{
+	{Path: "/api/v1/boards", Params: []string{"limit", "cursor"}, Summary: "The boards.", Item: api.Board{}},
+	{Path: "/api/v1/boards/{fingerprint}", Summary: "A board.", Entity: api.Board{}},
+	{Path: "/api/v1/boards/{fingerprint}/threads", Params: []string{"limit", "cursor"}, Summary: "The threads of a board.", Item: api.Thread{}},
+	{Path: "/api/v1/threads/{fingerprint}", Summary: "A thread.", Entity: api.Thread{}},
+	{Path: "/api/v1/threads/{fingerprint}/posts", Params: []string{"limit", "cursor"}, Summary: "The posts of a thread.", Item: api.Post{}},
+	{Path: "/api/v1/posts/{fingerprint}", Summary: "A post.", Entity: api.Post{}},
+	{Path: "/api/v1/search", Params: []string{"q", "type", "limit", "cursor"}, Summary: "The threads, or with type=posts the posts, whose text has the query.", Item: api.Thread{}},
+}
+
+// Page is the response of a list endpoint.
+type Page struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Rate limiting
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+var rateLock sync.Mutex
+var rateWindows = make(map[string]*rateWindow)
+
+// allow counts the request against the per-minute budget of the remote IP.
+func allow(ip string) bool {
+	rateLock.Lock()
+	defer rateLock.Unlock()
+	now := time.Now()
+	w, ok := rateWindows[ip]
+	if !ok || now.Sub(w.start) > time.Minute {
+		// Drop the expired windows every so often, so that the map does not grow with every IP seen.
+		if len(rateWindows) > 10000 {
+			for k, v := range rateWindows {
+				if now.Sub(v.start) > time.Minute {
+					delete(rateWindows, k)
+				}
+			}
+		}
+		rateWindows[ip] = &rateWindow{start: now, count: 1}
+		return true
+	}
+	if w.count >= globals.PublicApiRequestsPerMinute {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// Cursors
+
+// EncodeCursor creates the opaque cursor that points after the given entity.
+func EncodeCursor(creation api.Timestamp, fp api.Fingerprint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", creation, fp)))
+}
+
+// DecodeCursor parses a cursor created by EncodeCursor. An empty cursor is the first page.
+func DecodeCursor(cursor string) (api.Timestamp, api.Fingerprint, error) {
+	if len(cursor) == 0 {
+		return 0, "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", errors.New(fmt.Sprintf("The cursor is malformed. Cursor: %s", cursor))
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return 0, "", errors.New(fmt.Sprintf("The cursor is malformed. Cursor: %s", cursor))
+	}
+	creation, err2 := strconv.ParseInt(parts[0], 10, 64)
+	if err2 != nil || creation <= 0 {
+		return 0, "", errors.New(fmt.Sprintf("The cursor is malformed. Cursor: %s", cursor))
+	}
+	return api.Timestamp(creation), api.Fingerprint(parts[1]), nil
+}
+
+// Helpers
+
+func writeJson(w http.ResponseWriter, status int, data interface{}) {
+	jsonResp, err := json.Marshal(data)
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The public API response could not be converted to JSON. Error: %s", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(jsonResp)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJson(w, status, errorResponse{Error: msg})
+}
+
+// pageParams reads the limit and the cursor of a list request.
+func pageParams(r *http.Request) (int, api.Timestamp, api.Fingerprint, error) {
+	limit := globals.PublicApiDefaultPageSize
+	if l := r.URL.Query().Get("limit"); len(l) > 0 {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 {
+			return 0, 0, "", errors.New(fmt.Sprintf("The limit is invalid. Limit: %s", l))
+		}
+		limit = parsed
+	}
+	if limit > globals.PublicApiMaxPageSize {
+		limit = globals.PublicApiMaxPageSize
+	}
+	creation, fp, err := DecodeCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		return 0, 0, "", err
+	}
+	return limit, creation, fp, nil
+}
+
+// Handlers
+
+func handleBoards(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) == 1 {
+		limit, creation, fp, err := pageParams(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		boards, err2 := persistence.ReadBoardsAfterCursor(creation, fp, limit)
+		if err2 != nil {
+			logging.Log(1, fmt.Sprintf("Public API could not read boards. Error: %s", err2))
+			writeError(w, http.StatusInternalServerError, "Internal error.")
+			return
+		}
+		page := Page{Data: boards}
+		if boards == nil {
+			page.Data = []api.Board{}
+		}
+		if len(boards) == limit {
+			page.NextCursor = EncodeCursor(boards[len(boards)-1].Creation, boards[len(boards)-1].Fingerprint)
+		}
+		writeJson(w, http.StatusOK, page)
+		return
+	}
+	if len(parts) == 2 {
+		boards, err := persistence.ReadBoards([]api.Fingerprint{api.Fingerprint(parts[1])}, 0, 0)
+		if err != nil {
+			logging.Log(1, fmt.Sprintf("Public API could not read the board. Error: %s", err))
+			writeError(w, http.StatusInternalServerError, "Internal error.")
+			return
+		}
+		if len(boards) == 0 {
+			writeError(w, http.StatusNotFound, "Board not found.")
+			return
+		}
+		writeJson(w, http.StatusOK, boards[0])
+		return
+	}
+	if len(parts) == 3 && parts[2] == "threads" {
+		writeThreadsPage(w, r, api.Fingerprint(parts[1]), "")
+		return
+	}
+	writeError(w, http.StatusNotFound, "Not found.")
+}
+
+func handleThreads(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) == 2 {
+		threads, err := persistence.ReadThreads([]api.Fingerprint{api.Fingerprint(parts[1])}, 0, 0)
+		if err != nil {
+			logging.Log(1, fmt.Sprintf("Public API could not read the thread. Error: %s", err))
+			writeError(w, http.StatusInternalServerError, "Internal error.")
+			return
+		}
+		if len(threads) == 0 {
+			writeError(w, http.StatusNotFound, "Thread not found.")
+			return
+		}
+		writeJson(w, http.StatusOK, threads[0])
+		return
+	}
+	if len(parts) == 3 && parts[2] == "posts" {
+		writePostsPage(w, r, api.Fingerprint(parts[1]), "")
+		return
+	}
+	writeError(w, http.StatusNotFound, "Not found.")
+}
+
+func handlePosts(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) == 2 {
+		posts, err := persistence.ReadPosts([]api.Fingerprint{api.Fingerprint(parts[1])}, 0, 0)
+		if err != nil {
+			logging.Log(1, fmt.Sprintf("Public API could not read the post. Error: %s", err))
+			writeError(w, http.StatusInternalServerError, "Internal error.")
+			return
+		}
+		if len(posts) == 0 {
+			writeError(w, http.StatusNotFound, "Post not found.")
+			return
+		}
+		writeJson(w, http.StatusOK, posts[0])
+		return
+	}
+	writeError(w, http.StatusNotFound, "Not found.")
+}
+
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len(q) == 0 {
+		writeError(w, http.StatusBadRequest, "The search query is empty.")
+		return
+	}
+	switch r.URL.Query().Get("type") {
+	case "", "threads":
+		writeThreadsPage(w, r, "", q)
+	case "posts":
+		writePostsPage(w, r, "", q)
+	default:
+		writeError(w, http.StatusBadRequest, "The search type should be either threads or posts.")
+	}
+}
+
+func writeThreadsPage(w http.ResponseWriter, r *http.Request, board api.Fingerprint, search string) {
+	limit, creation, fp, err := pageParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	threads, err2 := persistence.ReadThreadsAfterCursor(board, search, creation, fp, limit)
+	if err2 != nil {
+		logging.Log(1, fmt.Sprintf("Public API could not read threads. Error: %s", err2))
+		writeError(w, http.StatusInternalServerError, "Internal error.")
+		return
+	}
+	page := Page{Data: threads}
+	if threads == nil {
+		page.Data = []api.Thread{}
+	}
+	if len(threads) == limit {
+		page.NextCursor = EncodeCursor(threads[len(threads)-1].Creation, threads[len(threads)-1].Fingerprint)
+	}
+	writeJson(w, http.StatusOK, page)
+}
+
+func writePostsPage(w http.ResponseWriter, r *http.Request, thread api.Fingerprint, search string) {
+	limit, creation, fp, err := pageParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	posts, err2 := persistence.ReadPostsAfterCursor(thread, search, creation, fp, limit)
+	if err2 != nil {
+		logging.Log(1, fmt.Sprintf("Public API could not read posts. Error: %s", err2))
+		writeError(w, http.StatusInternalServerError, "Internal error.")
+		return
+	}
+	page := Page{Data: posts}
+	if posts == nil {
+		page.Data = []api.Post{}
+	}
+	if len(posts) == limit {
+		page.NextCursor = EncodeCursor(posts[len(posts)-1].Creation, posts[len(posts)-1].Fingerprint)
+	}
+	writeJson(w, http.StatusOK, page)
+}
+
+func route(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "The public API is read-only.")
+		return
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !allow(host) {
+		writeError(w, http.StatusTooManyRequests, "Rate limit exceeded. Try again in a minute.")
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1"), "/")
+	parts := strings.Split(path, "/")
+	switch parts[0] {
+	case "boards":
+		handleBoards(w, r, parts)
+	case "threads":
+		handleThreads(w, r, parts)
+	case "posts":
+		handlePosts(w, r, parts)
+	case "search":
+		handleSearch(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "Not found.")
+	}
+}
+
+// Serve starts the public API server, if it is enabled. It blocks.
+func Serve() {
+	if !globals.PublicApiEnabled {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/", route)
+	logging.Log(1, fmt.Sprintf("Public API is starting to serve at port %d.", globals.PublicApiPort))
+	err := http.ListenAndServe(fmt.Sprint(":", globals.PublicApiPort), mux)
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("Public API server stopped. Error: %s", err))
+	}
+}
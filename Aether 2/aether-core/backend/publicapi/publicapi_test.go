@@ -0,0 +1,50 @@
+package publicapi_test
+
+import (
+	"aether-core/backend/publicapi"
+	"aether-core/io/api"
+	"os"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+}
+
+func teardown() {
+}
+
+// Tests
+
+func TestCursor_RoundTrip_Success(t *testing.T) {
+	cursor := publicapi.EncodeCursor(api.Timestamp(1500000000), api.Fingerprint("abc:def"))
+	creation, fp, err := publicapi.DecodeCursor(cursor)
+	if err != nil {
+		t.Errorf("Cursor decoding failed. Err: '%s'", err)
+	}
+	if creation != 1500000000 || fp != "abc:def" {
+		t.Errorf("Cursor round trip changed the values. Creation: %d, Fingerprint: %s", creation, fp)
+	}
+}
+
+func TestCursor_Empty_Success(t *testing.T) {
+	creation, fp, err := publicapi.DecodeCursor("")
+	if err != nil || creation != 0 || fp != "" {
+		t.Errorf("An empty cursor should point to the first page.")
+	}
+}
+
+func TestCursor_Malformed_Fail(t *testing.T) {
+	_, _, err := publicapi.DecodeCursor("not a cursor")
+	if err == nil {
+		t.Errorf("Expected an error for a malformed cursor.")
+	}
+}
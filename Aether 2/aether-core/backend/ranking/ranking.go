@@ -0,0 +1,233 @@
+// Backend > Ranking
+// This package ranks the threads of a board for the frontend, by hot, top or new. The scores are kept in the database and updated as the votes and the posts of a thread arrive, so that the frontend can page through a ranked board without pulling all of it.
+
+package ranking
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Orders the threads can be ranked by.
+const (
+	OrderHot = "hot" // Votes and replies, weighed down by age.
+	OrderTop = "top" // Upvotes minus downvotes.
+	OrderNew = "new" // Creation, newest first.
+)
+
+// RankedThread is a thread with its scores, as given to the frontend.
+type RankedThread struct {
+	Thread    api.Thread    `json:"thread"`
+	Upvotes   int64         `json:"upvotes"`
+	Downvotes int64         `json:"downvotes"`
+	Replies   int64         `json:"replies"`
+	LastReply api.Timestamp `json:"last_reply"`
+	Hot       float64       `json:"hot"`
+	Top       int64         `json:"top"`
+	Collapsed bool          `json:"collapsed,omitempty"` // Matched a content filter of the user that collapses rather than hides.
+}
+
+// Score computes the hot and top scores of a thread. The hot score only depends on the activity and on the creation of the thread, not on the current time, so the scores don't have to be computed again as time passes: a newer thread simply starts higher.
+func Score(upvotes int64, downvotes int64, replies int64, creation api.Timestamp) (float64, int64) {
+	top := upvotes - downvotes
+	activity := float64(top) + float64(replies)*globals.RankingReplyWeight
+	order := math.Log10(math.Max(math.Abs(activity), 1))
+	sign := 0.0
+	if activity > 0 {
+		sign = 1
+	} else if activity < 0 {
+		sign = -1
+	}
+	hot := sign*order + float64(creation)/globals.RankingHotDecaySeconds
+	return hot, top
+}
+
+func column(order string) (string, error) {
+	switch order {
+	case OrderHot:
+		return "Hot", nil
+	case OrderTop:
+		return "Top", nil
+	case OrderNew:
+		return "Creation", nil
+	}
+	return "", errors.New(fmt.Sprintf("This order is unknown. Order: %s", order))
+}
+
+// EncodeCursor creates the opaque cursor that points after the given thread in the given order.
+func EncodeCursor(order string, ts persistence.DbThreadScore) string {
+	var value string
+	switch order {
+	case OrderHot:
+		value = strconv.FormatFloat(ts.Hot, 'g', -1, 64)
+	case OrderTop:
+		value = strconv.FormatInt(ts.Top, 10)
+	case OrderNew:
+		value = strconv.FormatInt(int64(ts.Creation), 10)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprint(value, ":", ts.Thread)))
+}
+
+// DecodeCursor reads a cursor created by EncodeCursor for the same order. An empty cursor is the first page, and gives a nil value.
+func DecodeCursor(order string, cursor string) (interface{}, api.Fingerprint, error) {
+	if len(cursor) == 0 {
+		return nil, "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("The cursor is malformed. Cursor: %s", cursor))
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return nil, "", errors.New(fmt.Sprintf("The cursor is malformed. Cursor: %s", cursor))
+	}
+	var value interface{}
+	var err2 error
+	if order == OrderHot {
+		value, err2 = strconv.ParseFloat(parts[0], 64)
+	} else {
+		value, err2 = strconv.ParseInt(parts[0], 10, 64)
+	}
+	if err2 != nil {
+		return nil, "", errors.New(fmt.Sprintf("The cursor is malformed. Cursor: %s", cursor))
+	}
+	return value, api.Fingerprint(parts[1]), nil
+}
+
+// refresh computes the scores of the given threads again from the database, and saves them.
+func refresh(threads []api.Fingerprint) error {
+	var scores []persistence.DbThreadScore
+	for _, thread := range threads {
+		ts, found, err := persistence.ReadThreadActivity(thread, api.VoteUp, api.VoteDown)
+		if err != nil {
+			return err
+		}
+		if !found {
+			// The thread hasn't arrived yet. It will be scored when it does, with everything that arrived before it.
+			continue
+		}
+		ts.Hot, ts.Top = Score(ts.Upvotes, ts.Downvotes, ts.Replies, ts.Creation)
+		scores = append(scores, ts)
+	}
+	return persistence.InsertThreadScores(scores)
+}
+
+// touchedThreads finds the threads whose scores change with the entities in the response.
+func touchedThreads(resp *api.Response) []api.Fingerprint {
+	seen := make(map[api.Fingerprint]bool)
+	var threads []api.Fingerprint
+	add := func(fp api.Fingerprint) {
+		if len(fp) > 0 && !seen[fp] {
+			seen[fp] = true
+			threads = append(threads, fp)
+		}
+	}
+	for i, _ := range resp.Threads {
+		add(resp.Threads[i].Fingerprint)
+	}
+	for i, _ := range resp.Posts {
+		add(resp.Posts[i].Thread)
+	}
+	for i, _ := range resp.Votes {
+		// Only the votes on the thread itself count, not the ones on its posts.
+		if resp.Votes[i].Target == resp.Votes[i].Thread {
+			add(resp.Votes[i].Thread)
+		}
+	}
+	return threads
+}
+
+// Update computes the scores of the threads affected by a response that was just committed to the database. Only those threads are looked at, so this is cheap enough to run after every commit.
+func Update(resp *api.Response) {
+	threads := touchedThreads(resp)
+	if len(threads) == 0 {
+		return
+	}
+	err := refresh(threads)
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The scores of the threads could not be updated. Threads: %v, Error: %s", threads, err))
+	}
+}
+
+// Rebuild computes the scores of all threads from scratch.
+func Rebuild() error {
+	threads, err := persistence.ReadThreadFingerprints()
+	if err != nil {
+		return err
+	}
+	return refresh(threads)
+}
+
+// RebuildIfEmpty computes the scores of all threads if none are saved, such as on the first start after an upgrade, or after an import.
+func RebuildIfEmpty() {
+	count, err := persistence.CountThreadScores()
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The thread scores could not be counted. Error: %s", err))
+		return
+	}
+	if count > 0 {
+		return
+	}
+	err2 := Rebuild()
+	if err2 != nil {
+		logging.Log(1, fmt.Sprintf("The thread scores could not be rebuilt. Error: %s", err2))
+		return
+	}
+	logging.Log(1, "The thread scores are rebuilt.")
+}
+
+// List gives a page of the threads of a board in the given order, and the cursor of the next page. The next cursor is empty on the last page.
+func List(board api.Fingerprint, order string, cursor string, limit int) ([]RankedThread, string, error) {
+	var result []RankedThread
+	col, err := column(order)
+	if err != nil {
+		return result, "", err
+	}
+	afterValue, afterFp, err2 := DecodeCursor(order, cursor)
+	if err2 != nil {
+		return result, "", err2
+	}
+	scores, err3 := persistence.ReadThreadScoresAfterCursor(board, col, afterValue, afterFp, limit)
+	if err3 != nil {
+		return result, "", err3
+	}
+	if len(scores) == 0 {
+		return result, "", nil
+	}
+	var fps []api.Fingerprint
+	for i, _ := range scores {
+		fps = append(fps, scores[i].Thread)
+	}
+	threads, err4 := persistence.ReadThreads(fps, 0, 0)
+	if err4 != nil {
+		return result, "", err4
+	}
+	byFp := make(map[api.Fingerprint]api.Thread)
+	for i, _ := range threads {
+		byFp[threads[i].Fingerprint] = threads[i]
+	}
+	for i, _ := range scores {
+		var rt RankedThread
+		rt.Thread = byFp[scores[i].Thread]
+		rt.Upvotes = scores[i].Upvotes
+		rt.Downvotes = scores[i].Downvotes
+		rt.Replies = scores[i].Replies
+		rt.LastReply = scores[i].LastReply
+		rt.Hot = scores[i].Hot
+		rt.Top = scores[i].Top
+		result = append(result, rt)
+	}
+	var next string
+	if len(scores) == limit {
+		next = EncodeCursor(order, scores[len(scores)-1])
+	}
+	return result, next, nil
+}
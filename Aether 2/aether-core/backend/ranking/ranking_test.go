@@ -0,0 +1,101 @@
+package ranking_test
+
+import (
+	"aether-core/backend/ranking"
+	"aether-core/io/persistence"
+	"aether-core/services/globals"
+	"os"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+}
+
+func teardown() {
+}
+
+// Tests
+
+func TestScore_Success(t *testing.T) {
+	quiet, _ := ranking.Score(0, 0, 0, 1500000000)
+	voted, top := ranking.Score(10, 2, 0, 1500000000)
+	if top != 8 {
+		t.Errorf("Expected the top score to be 8, got %d.", top)
+	}
+	if voted <= quiet {
+		t.Errorf("A thread with votes should be hotter than one without. Voted: %f, Quiet: %f", voted, quiet)
+	}
+	replied, _ := ranking.Score(0, 0, 16, 1500000000)
+	if replied <= quiet {
+		t.Errorf("A thread with replies should be hotter than one without. Replied: %f, Quiet: %f", replied, quiet)
+	}
+	// A thread one decay period newer needs ten times less activity to be as hot.
+	older, _ := ranking.Score(100, 0, 0, 1500000000)
+	newer, _ := ranking.Score(10, 0, 0, 1500000000+45000)
+	if older != newer {
+		t.Errorf("The newer thread should be as hot as the older. Older: %f, Newer: %f", older, newer)
+	}
+}
+
+func TestScore_Fail_Downvoted(t *testing.T) {
+	quiet, _ := ranking.Score(0, 0, 0, 1500000000)
+	downvoted, top := ranking.Score(1, 10, 0, 1500000000)
+	if top != -9 {
+		t.Errorf("Expected the top score to be -9, got %d.", top)
+	}
+	if downvoted >= quiet {
+		t.Errorf("A downvoted thread should be colder than one without votes. Downvoted: %f, Quiet: %f", downvoted, quiet)
+	}
+}
+
+func TestCursor_Success(t *testing.T) {
+	var ts persistence.DbThreadScore
+	ts.Thread = "thread1"
+	ts.Hot = 33333.123456789
+	ts.Top = 42
+	ts.Creation = 1500000000
+	for _, order := range []string{ranking.OrderHot, ranking.OrderTop, ranking.OrderNew} {
+		value, fp, err := ranking.DecodeCursor(order, ranking.EncodeCursor(order, ts))
+		if err != nil {
+			t.Errorf("The cursor could not be decoded. Order: %s, Error: %s", order, err)
+		}
+		if fp != ts.Thread {
+			t.Errorf("The cursor points to the wrong thread. Order: %s, Thread: %s", order, fp)
+		}
+		switch order {
+		case ranking.OrderHot:
+			if value.(float64) != ts.Hot {
+				t.Errorf("The hot score in the cursor is wrong. Got: %v", value)
+			}
+		case ranking.OrderTop:
+			if value.(int64) != ts.Top {
+				t.Errorf("The top score in the cursor is wrong. Got: %v", value)
+			}
+		case ranking.OrderNew:
+			if value.(int64) != int64(ts.Creation) {
+				t.Errorf("The creation in the cursor is wrong. Got: %v", value)
+			}
+		}
+	}
+}
+
+func TestCursor_Fail_Malformed(t *testing.T) {
+	_, _, err := ranking.DecodeCursor(ranking.OrderTop, "not a cursor")
+	if err == nil {
+		t.Errorf("A malformed cursor should not be accepted.")
+	}
+	_, _, err2 := ranking.DecodeCursor(ranking.OrderTop, ranking.EncodeCursor(ranking.OrderHot, persistence.DbThreadScore{Thread: "thread1", Hot: 1.5}))
+	if err2 == nil {
+		t.Errorf("A cursor of another order should not be accepted.")
+	}
+}
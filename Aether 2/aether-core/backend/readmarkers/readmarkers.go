@@ -0,0 +1,197 @@
+// Backend > Read Markers
+// This package keeps how far the local user read each thread, so that the frontend can show how many posts are unread in each board and thread, and jump to the first unread post of a thread. The markers are kept in the database per profile, which is the key of the user, and are never given to the remotes.
+// A marker only moves forward: marking a thread read up to a post older than the marker leaves the marker as it is, so that a device that is behind can't make the user read a thread again. Marking a thread unread removes the marker. The markers move with the pairing bundle to the other devices of the user, where the later marker of each thread is kept.
+
+package readmarkers
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Marker is the frontend-facing form of a read marker.
+type Marker struct {
+	Thread       api.Fingerprint `json:"thread"`
+	Board        api.Fingerprint `json:"board"`
+	LastRead     api.Timestamp   `json:"last_read"`                // The creation of the last post read.
+	LastReadPost api.Fingerprint `json:"last_read_post,omitempty"` // Empty if the thread was read up to a time rather than to a post.
+	LastUpdate   api.Timestamp   `json:"last_update"`
+}
+
+// ThreadState is how much of a thread the user read.
+type ThreadState struct {
+	Thread      api.Fingerprint `json:"thread"`
+	Board       api.Fingerprint `json:"board"`
+	Unread      int             `json:"unread"`
+	FirstUnread api.Fingerprint `json:"first_unread,omitempty"` // The post to jump to.
+	Marker      *Marker         `json:"marker,omitempty"`       // Nil if the user has not read the thread.
+}
+
+// BoardState is how many posts are unread in a board.
+type BoardState struct {
+	Board  api.Fingerprint `json:"board"`
+	Unread int             `json:"unread"`
+}
+
+// lock makes sure that a marker is not moved by two callers at once, so that it only moves forward.
+var lock sync.Mutex
+
+// profile is the user the markers belong to. Markers saved before the user has a key belong to the empty profile.
+func profile() api.Fingerprint {
+	return api.Fingerprint(globals.CurrentUserKeyFingerprint())
+}
+
+func toMarker(m persistence.DbReadMarker) Marker {
+	return Marker{Thread: m.Thread, Board: m.Board, LastRead: m.LastRead, LastReadPost: m.LastReadPost, LastUpdate: m.LastUpdate}
+}
+
+// after checks whether the marker a is further in the thread than the marker b, in the order the posts are read in.
+func after(a persistence.DbReadMarker, b persistence.DbReadMarker) bool {
+	if a.LastRead != b.LastRead {
+		return a.LastRead > b.LastRead
+	}
+	return a.LastReadPost > b.LastReadPost
+}
+
+// save saves the marker if it is further than the one the thread has, and gives the one the thread has after. The caller holds the lock.
+func save(m persistence.DbReadMarker) (persistence.DbReadMarker, bool, error) {
+	existing, found, err := persistence.ReadReadMarker(m.Profile, m.Thread)
+	if err != nil {
+		return m, false, err
+	}
+	if found && !after(m, existing) {
+		return existing, false, nil
+	}
+	err2 := persistence.InsertReadMarker(m)
+	if err2 != nil {
+		return m, false, err2
+	}
+	return m, true, nil
+}
+
+// MarkRead marks the thread read up to the given post, or up to now if no post is given.
+func MarkRead(thread api.Fingerprint, post api.Fingerprint) (Marker, error) {
+	threads, err := persistence.ReadThreads([]api.Fingerprint{thread}, 0, 0)
+	if err != nil {
+		return Marker{}, err
+	}
+	if len(threads) == 0 {
+		return Marker{}, errors.New(fmt.Sprintf("There is no such thread. Thread: %s", thread))
+	}
+	now := api.Timestamp(clock.Unix())
+	m := persistence.DbReadMarker{Profile: profile(), Thread: thread, Board: threads[0].Board, LastRead: now, LastUpdate: now}
+	if len(post) > 0 {
+		posts, err2 := persistence.ReadPosts([]api.Fingerprint{post}, 0, 0)
+		if err2 != nil {
+			return Marker{}, err2
+		}
+		if len(posts) == 0 || posts[0].Thread != thread {
+			return Marker{}, errors.New(fmt.Sprintf("There is no such post in the thread. Thread: %s, Post: %s", thread, post))
+		}
+		m.LastRead, m.LastReadPost = posts[0].Creation, post
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	saved, _, err3 := save(m)
+	return toMarker(saved), err3
+}
+
+// MarkUnread makes all of the thread unread.
+func MarkUnread(thread api.Fingerprint) error {
+	lock.Lock()
+	defer lock.Unlock()
+	return persistence.DeleteReadMarker(profile(), thread)
+}
+
+// Get gives how much of the thread the user read, and the first unread post in it.
+func Get(thread api.Fingerprint) (ThreadState, error) {
+	s := ThreadState{Thread: thread}
+	threads, err := persistence.ReadThreads([]api.Fingerprint{thread}, 0, 0)
+	if err != nil {
+		return s, err
+	}
+	if len(threads) == 0 {
+		return s, errors.New(fmt.Sprintf("There is no such thread. Thread: %s", thread))
+	}
+	s.Board = threads[0].Board
+	m, found, err2 := persistence.ReadReadMarker(profile(), thread)
+	if err2 != nil {
+		return s, err2
+	}
+	if found {
+		marker := toMarker(m)
+		s.Marker = &marker
+	}
+	count, err3 := persistence.CountUnreadInThread(profile(), thread)
+	if err3 != nil || count == 0 {
+		return s, err3
+	}
+	s.Unread = count
+	first, _, err4 := persistence.ReadFirstUnread(profile(), thread)
+	s.FirstUnread = first
+	return s, err4
+}
+
+// Threads gives how many posts are unread in each thread of the board. The threads with nothing unread are left out.
+func Threads(board api.Fingerprint) ([]ThreadState, error) {
+	result := []ThreadState{}
+	counts, err := persistence.CountUnreadInThreads(profile(), board)
+	if err != nil {
+		return result, err
+	}
+	for _, c := range counts {
+		result = append(result, ThreadState{Thread: c.Thread, Board: c.Board, Unread: c.Unread})
+	}
+	return result, nil
+}
+
+// Boards gives how many posts are unread in each of the boards. The boards with nothing unread are left out.
+func Boards(boards []api.Fingerprint) ([]BoardState, error) {
+	result := []BoardState{}
+	counts, err := persistence.CountUnreadInBoards(profile(), boards)
+	if err != nil {
+		return result, err
+	}
+	for _, c := range counts {
+		result = append(result, BoardState{Board: c.Board, Unread: c.Unread})
+	}
+	return result, nil
+}
+
+// Export gives all the markers of the user, for the pairing bundle.
+func Export() ([]Marker, error) {
+	result := []Marker{}
+	ms, err := persistence.ReadReadMarkers(profile())
+	if err != nil {
+		return result, err
+	}
+	for _, m := range ms {
+		result = append(result, toMarker(m))
+	}
+	return result, nil
+}
+
+// Merge saves the markers of the user from another device, where they are further than the ones here, and gives how many were saved.
+func Merge(markers []Marker) (int, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	saved := 0
+	for _, m := range markers {
+		if len(m.Thread) == 0 {
+			continue
+		}
+		_, moved, err := save(persistence.DbReadMarker{Profile: profile(), Thread: m.Thread, Board: m.Board, LastRead: m.LastRead, LastReadPost: m.LastReadPost, LastUpdate: m.LastUpdate})
+		if err != nil {
+			return saved, err
+		}
+		if moved {
+			saved++
+		}
+	}
+	return saved, nil
+}
@@ -0,0 +1,27 @@
+// This test is in the package itself rather than in readmarkers_test, since the order the markers move forward in is decided by a function that is not exported. Saving the markers needs the database.
+
+package readmarkers
+
+import (
+	"aether-core/io/persistence"
+	"testing"
+)
+
+func TestAfter_Success(t *testing.T) {
+	earlier := persistence.DbReadMarker{LastRead: 100, LastReadPost: "ff"}
+	later := persistence.DbReadMarker{LastRead: 200, LastReadPost: "00"}
+	if !after(later, earlier) || after(earlier, later) {
+		t.Errorf("The marker of the later post is not after the one of the earlier post.")
+	}
+	sameTime := persistence.DbReadMarker{LastRead: 100, LastReadPost: "aa"}
+	if !after(earlier, sameTime) || after(sameTime, earlier) {
+		t.Errorf("The markers of the same time are not ordered by their posts.")
+	}
+}
+
+func TestAfter_Fail_Same(t *testing.T) {
+	m := persistence.DbReadMarker{LastRead: 100, LastReadPost: "aa"}
+	if after(m, m) {
+		t.Errorf("A marker is after itself, so saving it again would move it.")
+	}
+}
@@ -0,0 +1,269 @@
+// Backend > Reply tree
+// This package keeps the reply trees of the threads for the frontend. Every post has a materialized path in the database, made of the segments of the posts above it, so a subtree of any size is read in order with one query on a prefix, and can be paged through and cut at a depth without walking the posts one by one.
+// A post that arrives before its parent is put at the top of a detached subtree of its own, which is not shown. When the parent arrives, the detached subtree is moved under it.
+
+package replytree
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/logging"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// segmentLength is the length of the segment a post adds to the path: its creation and the start of its fingerprint, so that the replies to a post are in the order they were written.
+const segmentLength = 16
+
+// MaxDepth is how deep the paths go. It is what fits into the path column. The replies deeper than this are put next to their parents instead of under them.
+const MaxDepth = 2048 / segmentLength
+
+// rebuildPageSize is how many posts are read at a time when the reply trees are rebuilt.
+const rebuildPageSize = 1000
+
+// Node is a post in the reply tree of a thread, as given to the frontend.
+type Node struct {
+	Fingerprint api.Fingerprint `json:"fingerprint"`
+	Parent      api.Fingerprint `json:"parent"`
+	Depth       int             `json:"depth"`                  // Below the root of the tree asked for. The direct replies to it are at 1.
+	Post        *api.Post       `json:"post,omitempty"`         // Missing if the post could not be read, or matched a content filter that hides it.
+	MoreReplies int             `json:"more_replies,omitempty"` // The direct replies that were not given because the node is at the depth limit.
+	Collapsed   bool            `json:"collapsed,omitempty"`    // Matched a content filter of the user that collapses rather than hides.
+	Hidden      bool            `json:"hidden,omitempty"`       // Matched a content filter of the user that hides. The node is kept so that the replies under it still have a place.
+}
+
+// segment gives the segment a post adds to the path.
+func segment(p api.Post) string {
+	fp := fmt.Sprint(p.Fingerprint, "00000000")
+	return fmt.Sprintf("%08x%s", uint32(p.Creation), fp[:8])
+}
+
+// place gives the place of a post in the reply tree of its thread, given the place of its parent, or nil if the parent hasn't arrived.
+func place(p api.Post, parent *persistence.DbReplyPath) persistence.DbReplyPath {
+	rp := persistence.DbReplyPath{Post: p.Fingerprint, Thread: p.Thread, Parent: p.Parent}
+	seg := segment(p)
+	switch {
+	case p.Parent == p.Thread || len(p.Parent) == 0:
+		rp.Path = seg
+		rp.Depth = 1
+	case parent == nil || parent.Thread != p.Thread:
+		// The parent hasn't arrived, or is a post of another thread, which it can't be. Either way the post waits in a detached subtree.
+		rp.Path = seg
+		rp.Depth = 1
+		rp.Detached = true
+	case parent.Depth >= MaxDepth:
+		rp.Path = fmt.Sprint(parent.Path[:len(parent.Path)-segmentLength], seg)
+		rp.Depth = parent.Depth
+		rp.Detached = parent.Detached
+	default:
+		rp.Path = fmt.Sprint(parent.Path, seg)
+		rp.Depth = parent.Depth + 1
+		rp.Detached = parent.Detached
+	}
+	return rp
+}
+
+// add gives the posts their places in the reply trees, and moves the detached subtrees that were waiting for them under them.
+func add(posts []api.Post) error {
+	sorted := make([]api.Post, len(posts))
+	copy(sorted, posts)
+	// The older first, so that a parent that arrives in the same batch as its replies is placed before them.
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Creation < sorted[j].Creation })
+	var fps []api.Fingerprint
+	for i, _ := range sorted {
+		fps = append(fps, sorted[i].Fingerprint)
+		if sorted[i].Parent != sorted[i].Thread {
+			fps = append(fps, sorted[i].Parent)
+		}
+	}
+	known, err := persistence.ReadReplyPaths(fps)
+	if err != nil {
+		return err
+	}
+	var rows []persistence.DbReplyPath
+	for i, _ := range sorted {
+		p := sorted[i]
+		if _, placed := known[p.Fingerprint]; placed {
+			// The posts don't move once they are placed. An update of a post doesn't change its parent.
+			continue
+		}
+		var parent *persistence.DbReplyPath
+		if rp, found := known[p.Parent]; found {
+			parent = &rp
+		}
+		rp := place(p, parent)
+		known[p.Fingerprint] = rp
+		rows = append(rows, rp)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	err2 := persistence.InsertReplyPaths(rows)
+	if err2 != nil {
+		return err2
+	}
+	var added []api.Fingerprint
+	for i, _ := range rows {
+		added = append(added, rows[i].Post)
+	}
+	waiting, err3 := persistence.ReadDetachedReplies(added)
+	if err3 != nil {
+		return err3
+	}
+	for i, _ := range waiting {
+		parent := known[waiting[i].Parent]
+		if parent.Thread != waiting[i].Thread {
+			continue
+		}
+		prefix := parent.Path
+		depthDelta := parent.Depth
+		if parent.Depth >= MaxDepth {
+			prefix = parent.Path[:len(parent.Path)-segmentLength]
+			depthDelta = parent.Depth - 1
+		}
+		err4 := persistence.MoveReplySubtree(waiting[i].Thread, waiting[i].Path, prefix, depthDelta, parent.Detached)
+		if err4 != nil {
+			return err4
+		}
+	}
+	return nil
+}
+
+// Update places the posts of a response that was just committed to the database in the reply trees. Only those posts and the subtrees waiting for them are looked at, so this is cheap enough to run after every commit.
+func Update(resp *api.Response) {
+	if len(resp.Posts) == 0 {
+		return
+	}
+	err := add(resp.Posts)
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The reply trees could not be updated. Error: %s", err))
+	}
+}
+
+// Rebuild places all posts in the reply trees, oldest first.
+func Rebuild() error {
+	var afterCreation api.Timestamp
+	var afterFp api.Fingerprint
+	for {
+		posts, err := persistence.ReadPostLinksAfterCursor(afterCreation, afterFp, rebuildPageSize)
+		if err != nil {
+			return err
+		}
+		if len(posts) == 0 {
+			return nil
+		}
+		err2 := add(posts)
+		if err2 != nil {
+			return err2
+		}
+		last := posts[len(posts)-1]
+		afterCreation, afterFp = last.Creation, last.Fingerprint
+	}
+}
+
+// RebuildIfEmpty places all posts in the reply trees if none are, such as on the first start after an upgrade, or after an import.
+func RebuildIfEmpty() {
+	count, err := persistence.CountReplyPaths()
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The reply paths could not be counted. Error: %s", err))
+		return
+	}
+	if count > 0 {
+		return
+	}
+	err2 := Rebuild()
+	if err2 != nil {
+		logging.Log(1, fmt.Sprintf("The reply trees could not be rebuilt. Error: %s", err2))
+		return
+	}
+	logging.Log(1, "The reply trees are rebuilt.")
+}
+
+// EncodeCursor creates the opaque cursor that points after the post with the given path.
+func EncodeCursor(path string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(path))
+}
+
+// DecodeCursor reads a cursor created by EncodeCursor. An empty cursor is the first page, and gives an empty path.
+func DecodeCursor(cursor string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(raw)%segmentLength != 0 {
+		return "", errors.New(fmt.Sprintf("The cursor is malformed. Cursor: %s", cursor))
+	}
+	return string(raw), nil
+}
+
+// Tree gives a page of the reply tree of a thread, depth first, in the order the replies were written, and the cursor of the next page. The tree starts below the root, which is the thread, or one of its posts if given, and goes down depth levels below it. The next cursor is empty on the last page.
+func Tree(thread api.Fingerprint, root api.Fingerprint, depth int, cursor string, limit int) ([]Node, string, error) {
+	var result []Node
+	prefix := ""
+	baseDepth := 0
+	if len(root) > 0 && root != thread {
+		paths, err := persistence.ReadReplyPaths([]api.Fingerprint{root})
+		if err != nil {
+			return result, "", err
+		}
+		rp, found := paths[root]
+		if !found || rp.Thread != thread || rp.Detached {
+			return result, "", errors.New(fmt.Sprintf("This post is not in the reply tree of the thread. Post: %s, Thread: %s", root, thread))
+		}
+		prefix = rp.Path
+		baseDepth = rp.Depth
+	}
+	afterPath, err2 := DecodeCursor(cursor)
+	if err2 != nil {
+		return result, "", err2
+	}
+	if !strings.HasPrefix(afterPath, prefix) {
+		// The root itself has the prefix as its path, so starting after it leaves it out.
+		afterPath = prefix
+	}
+	if depth > MaxDepth {
+		depth = MaxDepth
+	}
+	maxDepth := baseDepth + depth
+	rows, err3 := persistence.ReadReplySubtree(thread, prefix, afterPath, maxDepth, limit)
+	if err3 != nil {
+		return result, "", err3
+	}
+	if len(rows) == 0 {
+		return result, "", nil
+	}
+	var fps []api.Fingerprint
+	var atLimit []api.Fingerprint
+	for i, _ := range rows {
+		fps = append(fps, rows[i].Post)
+		if rows[i].Depth == maxDepth {
+			atLimit = append(atLimit, rows[i].Post)
+		}
+	}
+	posts, err4 := persistence.ReadPosts(fps, 0, 0)
+	if err4 != nil {
+		return result, "", err4
+	}
+	byFp := make(map[api.Fingerprint]*api.Post)
+	for i, _ := range posts {
+		byFp[posts[i].Fingerprint] = &posts[i]
+	}
+	more, err5 := persistence.CountReplies(atLimit)
+	if err5 != nil {
+		return result, "", err5
+	}
+	for i, _ := range rows {
+		var n Node
+		n.Fingerprint = rows[i].Post
+		n.Parent = rows[i].Parent
+		n.Depth = rows[i].Depth - baseDepth
+		n.Post = byFp[rows[i].Post]
+		n.MoreReplies = more[rows[i].Post]
+		result = append(result, n)
+	}
+	var next string
+	if len(rows) == limit {
+		next = EncodeCursor(rows[len(rows)-1].Path)
+	}
+	return result, next, nil
+}
@@ -0,0 +1,62 @@
+// This test is in the package itself rather than in replytree_test, since where a post goes in the tree is decided by functions that are not exported. Reading and moving the subtrees needs the database, so the places are computed here without it.
+
+package replytree
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"strings"
+	"testing"
+)
+
+func post(fp string, thread string, parent string, creation api.Timestamp) api.Post {
+	var p api.Post
+	p.Fingerprint = api.Fingerprint(fp)
+	p.Thread = api.Fingerprint(thread)
+	p.Parent = api.Fingerprint(parent)
+	p.Creation = creation
+	return p
+}
+
+func TestPlace_Success(t *testing.T) {
+	top := place(post("aaaaaaaa11", "thread", "thread", 100), nil)
+	if top.Depth != 1 || top.Detached || len(top.Path) != segmentLength {
+		t.Fatalf("A reply to the thread should be at the top of the tree. Place: %v", top)
+	}
+	reply := place(post("bbbbbbbb22", "thread", "aaaaaaaa11", 200), &top)
+	if reply.Depth != 2 || !strings.HasPrefix(reply.Path, top.Path) || len(reply.Path) != 2*segmentLength {
+		t.Errorf("A reply to a post should be under it. Place: %v", reply)
+	}
+	// The replies to a post are in the order they were written.
+	later := place(post("00000000", "thread", "aaaaaaaa11", 300), &top)
+	if later.Path <= reply.Path {
+		t.Errorf("The later reply should come after the earlier one. Earlier: %s, Later: %s", reply.Path, later.Path)
+	}
+	cursor, err := DecodeCursor(EncodeCursor(reply.Path))
+	if err != nil || cursor != reply.Path {
+		t.Errorf("The cursor should give back the path. Path: %s, Cursor: %s, Error: %v", reply.Path, cursor, err)
+	}
+}
+
+func TestPlace_Success_DepthCap(t *testing.T) {
+	deepest := persistence.DbReplyPath{Post: "deep", Thread: "thread", Path: strings.Repeat("0", MaxDepth*segmentLength), Depth: MaxDepth}
+	capped := place(post("cccccccc33", "thread", "deep", 400), &deepest)
+	if capped.Depth != MaxDepth || len(capped.Path) != len(deepest.Path) {
+		t.Errorf("A reply below the deepest level should go next to its parent. Place: %v", capped)
+	}
+}
+
+func TestPlace_Fail_MissingParent(t *testing.T) {
+	orphan := place(post("dddddddd44", "thread", "missing", 500), nil)
+	if !orphan.Detached || orphan.Depth != 1 {
+		t.Errorf("A reply whose parent hasn't arrived should be detached. Place: %v", orphan)
+	}
+	other := persistence.DbReplyPath{Post: "other", Thread: "another thread", Path: "0000000000000000", Depth: 1}
+	crossed := place(post("eeeeeeee55", "thread", "other", 600), &other)
+	if !crossed.Detached {
+		t.Errorf("A reply to a post of another thread should be detached. Place: %v", crossed)
+	}
+	if _, err := DecodeCursor("not a cursor"); err == nil {
+		t.Errorf("A malformed cursor should not be read.")
+	}
+}
@@ -0,0 +1,383 @@
+// Backend > ResponseGenerator > Archive
+// This file moves the old caches to cold storage. The caches that ended more than the given number of months ago are rarely asked for, but a node that keeps its whole history has most of its statics directory in them. They are moved into one zip archive per entity type and month, compressed, in a location that can be on a slower and larger disk, and are served from there: a page of an archived cache is read out of its archive when it is asked for, and the pages read most recently are kept in memory.
+// An archived cache stays in the index, so the remotes see no difference other than the time it takes to serve it. Its folder in the statics directory is deleted only after the archive it was moved into is written and read back in full. An archive is written next to the old one and moved over it, so a page is never served from half of one.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"archive/zip"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArchiveReport lists the caches ArchiveCaches moved to the archives, and the ones it dropped from them, by entity type.
+type ArchiveReport struct {
+	Cutoff   api.Timestamp       `json:"cutoff"` // Caches that end before this were archived. 0 if the caches are not archived.
+	Archived map[string][]string `json:"archived"`
+	Dropped  map[string][]string `json:"dropped"` // Archived caches that are neither in the index nor retired anymore.
+}
+
+// archivedPage is a page read out of an archive, kept in memory.
+type archivedPage struct {
+	path string
+	data []byte
+}
+
+// The archive each archived cache is in, by entity type and cache name, read from the archives when first needed, and the pages read most recently, the most recent first.
+var archiveLock sync.Mutex
+var archiveCatalogs = make(map[string]map[string]string)
+var archivedPages = list.New()
+var archivedPageElements = make(map[string]*list.Element)
+
+// archiveLocation is the folder of the archives.
+func archiveLocation() string {
+	if len(globals.CacheArchiveLocation) > 0 {
+		return globals.CacheArchiveLocation
+	}
+	return fmt.Sprint(globals.UserDirectory, "/cold-caches")
+}
+
+// archivePath is the archive of the caches of the entity type that end in the month of the timestamp.
+func archivePath(respType string, endsAt api.Timestamp) string {
+	return fmt.Sprint(archiveLocation(), "/", respType, "/", time.Unix(int64(endsAt), 0).UTC().Format("2006-01"), ".zip")
+}
+
+// archivedCacheName is the cache the entry of an archive belongs to.
+func archivedCacheName(entry string) string {
+	return strings.SplitN(entry, "/", 2)[0]
+}
+
+// archiveCatalog gives the archive each archived cache of the entity type is in. The caller holds archiveLock.
+func archiveCatalog(respType string) (map[string]string, error) {
+	if catalog, ok := archiveCatalogs[respType]; ok {
+		return catalog, nil
+	}
+	catalog := make(map[string]string)
+	archives, err := filepath.Glob(fmt.Sprint(archiveLocation(), "/", respType, "/*.zip"))
+	if err != nil {
+		return catalog, err
+	}
+	for _, a := range archives {
+		zr, err2 := zip.OpenReader(a)
+		if err2 != nil {
+			return catalog, errors.New(fmt.Sprintf("The archive of the caches could not be read. Archive: %s, Error: %s", a, err2))
+		}
+		for _, f := range zr.File {
+			catalog[archivedCacheName(f.Name)] = a
+		}
+		zr.Close()
+	}
+	archiveCatalogs[respType] = catalog
+	return catalog, nil
+}
+
+// forgetArchives makes the catalogs be read again from the archives, and drops the pages kept in memory.
+func forgetArchives() {
+	archiveLock.Lock()
+	defer archiveLock.Unlock()
+	archiveCatalogs = make(map[string]map[string]string)
+	archivedPages.Init()
+	archivedPageElements = make(map[string]*list.Element)
+}
+
+// isArchivedCache is true if the cache is served from the archives: its folder is not in the statics directory, and an archive has it.
+func isArchivedCache(respType string, cacheName string) bool {
+	if _, err := os.Stat(fmt.Sprint(globals.CachesLocation, "/", respType, "/", cacheName)); err == nil {
+		return false
+	}
+	archiveLock.Lock()
+	defer archiveLock.Unlock()
+	catalog, err := archiveCatalog(respType)
+	if err != nil {
+		logging.LogSampled("responsegenerator", "archive-catalog", 1, err)
+	}
+	_, ok := catalog[cacheName]
+	return ok
+}
+
+// ReadArchivedCachePage reads the file of the caches at the path, such as "posts/cache_x/3.json", out of the archives. It gives false if no archive has it.
+func ReadArchivedCachePage(path string) ([]byte, bool, error) {
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) != 3 || !isCacheEntityType(parts[0]) || !isValidCacheName(parts[1]) || strings.Contains(parts[2], "..") {
+		return nil, false, nil
+	}
+	respType, cacheName, page := parts[0], parts[1], parts[2]
+	archiveLock.Lock()
+	defer archiveLock.Unlock()
+	if e, ok := archivedPageElements[path]; ok {
+		archivedPages.MoveToFront(e)
+		return e.Value.(*archivedPage).data, true, nil
+	}
+	catalog, err := archiveCatalog(respType)
+	if err != nil {
+		return nil, false, err
+	}
+	archive, ok := catalog[cacheName]
+	if !ok {
+		return nil, false, nil
+	}
+	zr, err2 := zip.OpenReader(archive)
+	if err2 != nil {
+		return nil, false, errors.New(fmt.Sprintf("The archive of the caches could not be read. Archive: %s, Error: %s", archive, err2))
+	}
+	defer zr.Close()
+	name := fmt.Sprint(cacheName, "/", page)
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err3 := f.Open()
+		if err3 != nil {
+			return nil, false, err3
+		}
+		data, err4 := ioutil.ReadAll(rc)
+		rc.Close()
+		if err4 != nil {
+			return nil, false, errors.New(fmt.Sprintf("The page could not be read out of the archive. Archive: %s, Page: %s, Error: %s", archive, name, err4))
+		}
+		noteCacheServed(respType, cacheName)
+		if globals.CacheArchiveCachedPages > 0 {
+			archivedPageElements[path] = archivedPages.PushFront(&archivedPage{path: path, data: data})
+			for archivedPages.Len() > globals.CacheArchiveCachedPages {
+				oldest := archivedPages.Back()
+				archivedPages.Remove(oldest)
+				delete(archivedPageElements, oldest.Value.(*archivedPage).path)
+			}
+		}
+		return data, true, nil
+	}
+	return nil, false, nil
+}
+
+// ArchiveCaches moves the caches that end before the cutoff from the statics directory to the archives, and drops the caches that are no longer in the index or retired from the archives.
+func ArchiveCaches() (ArchiveReport, error) {
+	report := ArchiveReport{Archived: make(map[string][]string), Dropped: make(map[string][]string)}
+	if globals.CacheArchiveAfterMonths <= 0 {
+		return report, nil
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	report.Cutoff = api.Timestamp(clock.Now().AddDate(0, -globals.CacheArchiveAfterMonths, 0).Unix())
+	for _, respType := range cacheEntityTypes {
+		archived, dropped, err := archiveCachesOf(respType, report.Cutoff)
+		if len(archived) > 0 {
+			report.Archived[respType] = archived
+		}
+		if len(dropped) > 0 {
+			report.Dropped[respType] = dropped
+		}
+		if err != nil {
+			return report, err
+		}
+		if len(archived) > 0 || len(dropped) > 0 {
+			logging.Log(1, fmt.Sprintf("Archived %d caches of %s that ended before %d, and dropped %d from the archives.", len(archived), respType, report.Cutoff, len(dropped)))
+		}
+	}
+	return report, nil
+}
+
+// archiveCachesOf archives the caches of the entity type that end before the cutoff. The caller holds cacheLock.
+func archiveCachesOf(respType string, cutoff api.Timestamp) ([]string, []string, error) {
+	cacheIndex, err := readCacheIndex(respType)
+	if err != nil {
+		// A broken index is for the repair to fix. Without it, what can be dropped from the archives is not known.
+		logging.Log(1, fmt.Sprintf("The caches of %s could not be archived. Error: %s", respType, err))
+		return nil, nil, nil
+	}
+	keep := retiredNames(respType)
+	toAdd := make(map[string][]string) // Archive > caches
+	for _, c := range cacheIndex.Results {
+		keep[c.ResponseUrl] = true
+		if c.EndsAt >= cutoff || !isValidCacheName(c.ResponseUrl) {
+			continue
+		}
+		if _, statErr := os.Stat(fmt.Sprint(globals.CachesLocation, "/", respType, "/", c.ResponseUrl, "/0.json")); statErr != nil {
+			continue
+		}
+		a := archivePath(respType, c.EndsAt)
+		toAdd[a] = append(toAdd[a], c.ResponseUrl)
+	}
+	archiveLock.Lock()
+	catalog, err2 := archiveCatalog(respType)
+	archiveLock.Unlock()
+	if err2 != nil {
+		return nil, nil, err2
+	}
+	// The archives that have a cache to drop are rewritten even if nothing is added to them.
+	for name, a := range catalog {
+		if !keep[name] {
+			if _, ok := toAdd[a]; !ok {
+				toAdd[a] = nil
+			}
+		}
+	}
+	var archives []string
+	for a, _ := range toAdd {
+		archives = append(archives, a)
+	}
+	sort.Strings(archives)
+	var archived, dropped []string
+	var err3 error
+	for _, a := range archives {
+		d, err4 := rewriteArchive(respType, a, keep, toAdd[a])
+		if err4 != nil {
+			err3 = err4
+			break
+		}
+		archived = append(archived, toAdd[a]...)
+		dropped = append(dropped, d...)
+	}
+	// The pages of the caches in the archives that were written are served from there from here on, so their folders can go.
+	forgetArchives()
+	for _, name := range archived {
+		os.RemoveAll(fmt.Sprint(globals.CachesLocation, "/", respType, "/", name))
+	}
+	return archived, dropped, err3
+}
+
+// rewriteArchive writes the archive again with the caches in it that are to be kept, and the caches to add from the statics directory, and gives the caches it dropped. An archive left with nothing in it is deleted.
+func rewriteArchive(respType string, archive string, keep map[string]bool, add []string) ([]string, error) {
+	err := os.MkdirAll(filepath.Dir(archive), 0755)
+	if err != nil {
+		return nil, err
+	}
+	tmp := fmt.Sprint(archive, ".tmp")
+	f, err2 := os.Create(tmp)
+	if err2 != nil {
+		return nil, errors.New(fmt.Sprintf("The archive of the caches could not be written. Archive: %s, Error: %s", archive, err2))
+	}
+	defer os.Remove(tmp)
+	zw := zip.NewWriter(f)
+	adding := make(map[string]bool)
+	for _, name := range add {
+		adding[name] = true
+	}
+	droppedSet := make(map[string]bool)
+	entries := 0
+	if zr, err3 := zip.OpenReader(archive); err3 == nil {
+		for _, entry := range zr.File {
+			name := archivedCacheName(entry.Name)
+			if !keep[name] {
+				droppedSet[name] = true
+				continue
+			}
+			if adding[name] {
+				// The cache is in the statics directory again, since it was regenerated. That one replaces the archived one.
+				continue
+			}
+			if err4 := zw.Copy(entry); err4 != nil {
+				zr.Close()
+				f.Close()
+				return nil, err4
+			}
+			entries++
+		}
+		zr.Close()
+	} else if !os.IsNotExist(err3) {
+		f.Close()
+		return nil, errors.New(fmt.Sprintf("The archive of the caches could not be read. Archive: %s, Error: %s", archive, err3))
+	}
+	for _, name := range add {
+		n, err5 := addCacheToArchive(zw, fmt.Sprint(globals.CachesLocation, "/", respType, "/", name), name)
+		if err5 != nil {
+			f.Close()
+			return nil, err5
+		}
+		entries += n
+	}
+	if err6 := zw.Close(); err6 != nil {
+		f.Close()
+		return nil, err6
+	}
+	f.Sync()
+	if err7 := f.Close(); err7 != nil {
+		return nil, err7
+	}
+	var dropped []string
+	for name, _ := range droppedSet {
+		dropped = append(dropped, name)
+	}
+	sort.Strings(dropped)
+	if entries == 0 {
+		if err8 := os.Remove(archive); err8 != nil && !os.IsNotExist(err8) {
+			return nil, err8
+		}
+		return dropped, nil
+	}
+	if err9 := verifyArchive(tmp, entries); err9 != nil {
+		return nil, errors.New(fmt.Sprintf("The archive of the caches did not read back as it was written, and it is not used. Archive: %s, Error: %s", archive, err9))
+	}
+	return dropped, os.Rename(tmp, archive)
+}
+
+// addCacheToArchive adds the files in the folder of the cache to the archive, compressed, and gives how many it added.
+func addCacheToArchive(zw *zip.Writer, dir string, cacheName string) (int, error) {
+	count := 0
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		rel, err2 := filepath.Rel(dir, p)
+		if err2 != nil {
+			return err2
+		}
+		w, err3 := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprint(cacheName, "/", filepath.ToSlash(rel)), Method: zip.Deflate, Modified: info.ModTime()})
+		if err3 != nil {
+			return err3
+		}
+		src, err4 := os.Open(p)
+		if err4 != nil {
+			return err4
+		}
+		defer src.Close()
+		if _, err5 := io.Copy(w, src); err5 != nil {
+			return err5
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, errors.New(fmt.Sprintf("The cache could not be added to the archive. Cache: %s, Error: %s", dir, err))
+	}
+	return count, nil
+}
+
+// verifyArchive reads every file in the archive, which checks them against their checksums.
+func verifyArchive(archive string, entries int) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	if len(zr.File) != entries {
+		return errors.New(fmt.Sprintf("The archive has a different number of files than were written. Written: %d, Read: %d", entries, len(zr.File)))
+	}
+	for _, f := range zr.File {
+		rc, err2 := f.Open()
+		if err2 != nil {
+			return err2
+		}
+		_, err3 := io.Copy(ioutil.Discard, rc)
+		rc.Close()
+		if err3 != nil {
+			return err3
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// This test is in the package itself rather than in responsegenerator_test, since the cache it archives is saved with functions that are not exported.
+
+package responsegenerator
+
+import (
+	"aether-core/services/globals"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveCaches_Success(t *testing.T) {
+	cacheName := saveRepairTestCache(t)
+	defer os.RemoveAll(globals.CachesLocation)
+	globals.CacheArchiveLocation = filepath.Join(globals.CachesLocation, "cold")
+	defer func() { globals.CacheArchiveLocation = "" }()
+	cacheDir := filepath.Join(globals.CachesLocation, "posts", cacheName)
+	hot, err := ioutil.ReadFile(filepath.Join(cacheDir, "2.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals.CacheArchiveAfterMonths = 1
+	defer func() { globals.CacheArchiveAfterMonths = 0 }()
+	report, err2 := ArchiveCaches()
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	if len(report.Archived["posts"]) != 1 || report.Archived["posts"][0] != cacheName {
+		t.Errorf("The old cache should be archived. Report: %#v", report)
+	}
+	if _, statErr := os.Stat(filepath.Join(globals.CacheArchiveLocation, "posts", "2017-07.zip")); statErr != nil {
+		t.Errorf("The cache should be in the archive of the month it ends in. Error: %s", statErr)
+	}
+	if _, statErr := os.Stat(cacheDir); !os.IsNotExist(statErr) {
+		t.Errorf("The folder of the archived cache should be deleted.")
+	}
+	for i := 0; i < 2; i++ {
+		// The second time, the page comes from memory.
+		cold, found, err3 := ReadArchivedCachePage(fmt.Sprint("posts/", cacheName, "/2.json"))
+		if err3 != nil || !found || !bytes.Equal(hot, cold) {
+			t.Errorf("The page should be read out of the archive as it was. Found: %t, Error: %v", found, err3)
+		}
+	}
+	path := fmt.Sprint("posts/", cacheName, "/index/0.json")
+	if served, err4 := EnsureCachePage(path); served != path || err4 != nil {
+		t.Errorf("An archived cache should not be repaired. Served: %s, Error: %v", served, err4)
+	}
+	repair, err5 := InspectCacheIndex("posts")
+	if err5 != nil || repair.NeedsRepair() {
+		t.Errorf("An archived cache should stay in the index. Report: %#v, Error: %v", repair, err5)
+	}
+}
+
+func TestArchiveCaches_Fail_Dropped(t *testing.T) {
+	cacheName := saveRepairTestCache(t)
+	defer os.RemoveAll(globals.CachesLocation)
+	globals.CacheArchiveLocation = filepath.Join(globals.CachesLocation, "cold")
+	defer func() { globals.CacheArchiveLocation = "" }()
+	globals.CacheArchiveAfterMonths = 1
+	defer func() { globals.CacheArchiveAfterMonths = 0 }()
+	if _, err := ArchiveCaches(); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := ReadArchivedCachePage(fmt.Sprint("posts/", cacheName, "/999.json")); found {
+		t.Errorf("A page the archived cache doesn't have should not be found.")
+	}
+	if _, found, _ := ReadArchivedCachePage(fmt.Sprint("posts/", cacheName, "/../../x.json")); found {
+		t.Errorf("A path outside of the cache should not be read.")
+	}
+	// Once the cache is out of the index, the next run drops it from the archive, and the archive, left empty, is deleted.
+	if err2 := DeleteCache("posts", cacheName); err2 != nil {
+		t.Fatal(err2)
+	}
+	report, err3 := ArchiveCaches()
+	if err3 != nil || len(report.Dropped["posts"]) != 1 {
+		t.Errorf("The cache that is no longer in the index should be dropped from the archive. Report: %#v, Error: %v", report, err3)
+	}
+	if _, found, _ := ReadArchivedCachePage(fmt.Sprint("posts/", cacheName, "/2.json")); found {
+		t.Errorf("A page of a dropped cache should not be served.")
+	}
+	if _, statErr := os.Stat(filepath.Join(globals.CacheArchiveLocation, "posts", "2017-07.zip")); !os.IsNotExist(statErr) {
+		t.Errorf("An archive left with nothing in it should be deleted.")
+	}
+}
@@ -0,0 +1,39 @@
+// Backend > ResponseGenerator > Boards
+// This file applies the board filter of a request: only the threads, the posts and the votes in one of the requested boards are given. A remote whose vote sync policies leave out the votes of the boards it doesn't want asks for the votes of the rest this way. The other entity types pass through.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+)
+
+// filterByBoard leaves out the threads, the posts and the votes that are not in one of the given boards. If no boards are given, nothing is filtered.
+func filterByBoard(resp api.Response, boards []api.Fingerprint) api.Response {
+	if len(boards) == 0 {
+		return resp
+	}
+	wanted := make(map[api.Fingerprint]bool)
+	for _, b := range boards {
+		wanted[b] = true
+	}
+	cleanedResp := resp
+	cleanedResp.Threads = nil
+	cleanedResp.Posts = nil
+	cleanedResp.Votes = nil
+	for i, _ := range resp.Threads {
+		if wanted[resp.Threads[i].Board] {
+			cleanedResp.Threads = append(cleanedResp.Threads, resp.Threads[i])
+		}
+	}
+	for i, _ := range resp.Posts {
+		if wanted[resp.Posts[i].Board] {
+			cleanedResp.Posts = append(cleanedResp.Posts, resp.Posts[i])
+		}
+	}
+	for i, _ := range resp.Votes {
+		if wanted[resp.Votes[i].Board] {
+			cleanedResp.Votes = append(cleanedResp.Votes, resp.Votes[i])
+		}
+	}
+	return cleanedResp
+}
@@ -0,0 +1,170 @@
+// Backend > ResponseGenerator > Budget
+// This file keeps the paths that don't split the entities in memory in line with the page byte budget. The caches, the paged POST responses and the cursor pages all cut their pages where pageRanges cuts them, and the indexes of a cache point at the pages their entities were put in, wherever the pages were closed.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/globals"
+)
+
+// entitySizes gives the sizes of the entities of a page, in their order. A page has a single type.
+func entitySizes(r *api.Response) []int {
+	var sizes []int
+	for i, _ := range r.Boards {
+		sizes = append(sizes, entitySize(r.Boards[i]))
+	}
+	for i, _ := range r.Threads {
+		sizes = append(sizes, entitySize(r.Threads[i]))
+	}
+	for i, _ := range r.Posts {
+		sizes = append(sizes, entitySize(r.Posts[i]))
+	}
+	for i, _ := range r.Votes {
+		sizes = append(sizes, entitySize(r.Votes[i]))
+	}
+	for i, _ := range r.Keys {
+		sizes = append(sizes, entitySize(r.Keys[i]))
+	}
+	for i, _ := range r.Truststates {
+		sizes = append(sizes, entitySize(r.Truststates[i]))
+	}
+	for i, _ := range r.Tombstones {
+		sizes = append(sizes, entitySize(r.Tombstones[i]))
+	}
+	for i, _ := range r.Addresses {
+		sizes = append(sizes, entitySize(r.Addresses[i]))
+	}
+	return sizes
+}
+
+// indexSizes gives the sizes of the indexes in the response, in their order.
+func indexSizes(r *api.Response) []int {
+	var sizes []int
+	for i, _ := range r.BoardIndexes {
+		sizes = append(sizes, entitySize(r.BoardIndexes[i]))
+	}
+	for i, _ := range r.ThreadIndexes {
+		sizes = append(sizes, entitySize(r.ThreadIndexes[i]))
+	}
+	for i, _ := range r.PostIndexes {
+		sizes = append(sizes, entitySize(r.PostIndexes[i]))
+	}
+	for i, _ := range r.VoteIndexes {
+		sizes = append(sizes, entitySize(r.VoteIndexes[i]))
+	}
+	for i, _ := range r.KeyIndexes {
+		sizes = append(sizes, entitySize(r.KeyIndexes[i]))
+	}
+	for i, _ := range r.TruststateIndexes {
+		sizes = append(sizes, entitySize(r.TruststateIndexes[i]))
+	}
+	for i, _ := range r.TombstoneIndexes {
+		sizes = append(sizes, entitySize(r.TombstoneIndexes[i]))
+	}
+	return sizes
+}
+
+// truncateEntities keeps the first n entities of a page. A page has a single type.
+func truncateEntities(r *api.Response, n int) {
+	switch {
+	case len(r.Boards) > n:
+		r.Boards = r.Boards[:n]
+	case len(r.Threads) > n:
+		r.Threads = r.Threads[:n]
+	case len(r.Posts) > n:
+		r.Posts = r.Posts[:n]
+	case len(r.Votes) > n:
+		r.Votes = r.Votes[:n]
+	case len(r.Keys) > n:
+		r.Keys = r.Keys[:n]
+	case len(r.Truststates) > n:
+		r.Truststates = r.Truststates[:n]
+	case len(r.Tombstones) > n:
+		r.Tombstones = r.Tombstones[:n]
+	}
+}
+
+// cutToBudget cuts a page read by the page size down to the first page pageRanges would make of it, if there is a page byte budget. It gives whether the page was cut, and the fingerprint of the last entity that stayed, which is where the next page starts.
+func cutToBudget(r *api.Response, pageSize int) (bool, api.Fingerprint) {
+	if globals.PageByteBudget <= 0 {
+		return false, ""
+	}
+	sizes := entitySizes(r)
+	if len(sizes) == 0 {
+		return false, ""
+	}
+	first := pageRanges(len(sizes), pageSize, func(i int) int { return sizes[i] })[0]
+	if first.end == len(sizes) {
+		return false, ""
+	}
+	truncateEntities(r, first.end)
+	fps := fingerprintsOf(r)
+	return true, fps[len(fps)-1]
+}
+
+// rangesOf gives the positions of the entities of the pages, before anything is filtered out of them.
+func rangesOf(pages *[]api.Response) []pageRange {
+	var ranges []pageRange
+	beg := 0
+	for i, _ := range *pages {
+		n := countEntities(&(*pages)[i])
+		ranges = append(ranges, pageRange{beg, beg + n})
+		beg += n
+	}
+	return ranges
+}
+
+// planRanges gives where the pages of a plan are cut. Without a page byte budget, every page but the last has the page size. With one, the entities are read through once, a page of the plan at a time, to be sized, and only their sizes are kept.
+func planRanges(plan persistence.PagePlan) ([]pageRange, error) {
+	var ranges []pageRange
+	if globals.PageByteBudget <= 0 {
+		for i := 0; i < plan.Pages; i++ {
+			ranges = append(ranges, pageRange{i * plan.PageSize, (i + 1) * plan.PageSize})
+		}
+		return ranges, nil
+	}
+	var sizes []int
+	for i := 0; i < plan.Pages; i++ {
+		page, err := persistence.ReadPage(plan, i)
+		if err != nil {
+			return ranges, err
+		}
+		sizes = append(sizes, entitySizes(&page)...)
+	}
+	return pageRanges(len(sizes), plan.PageSize, func(i int) int { return sizes[i] }), nil
+}
+
+// numberIndexes sets the page number of every index to the page its position falls into.
+func numberIndexes(r *api.Response, ranges []pageRange) {
+	page := 0
+	pageOf := func(position int) int {
+		for page < len(ranges)-1 && position >= ranges[page].end {
+			page++
+		}
+		return page
+	}
+	// The indexes are in the order of the entities, so the page only moves forward.
+	for i, _ := range r.BoardIndexes {
+		r.BoardIndexes[i].PageNumber = pageOf(i)
+	}
+	for i, _ := range r.ThreadIndexes {
+		r.ThreadIndexes[i].PageNumber = pageOf(i)
+	}
+	for i, _ := range r.PostIndexes {
+		r.PostIndexes[i].PageNumber = pageOf(i)
+	}
+	for i, _ := range r.VoteIndexes {
+		r.VoteIndexes[i].PageNumber = pageOf(i)
+	}
+	for i, _ := range r.KeyIndexes {
+		r.KeyIndexes[i].PageNumber = pageOf(i)
+	}
+	for i, _ := range r.TruststateIndexes {
+		r.TruststateIndexes[i].PageNumber = pageOf(i)
+	}
+	for i, _ := range r.TombstoneIndexes {
+		r.TombstoneIndexes[i].PageNumber = pageOf(i)
+	}
+}
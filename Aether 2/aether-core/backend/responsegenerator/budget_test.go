@@ -0,0 +1,76 @@
+// This test is in the package itself rather than in responsegenerator_test, since the pages are cut by functions that are not exported.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"testing"
+)
+
+func TestNumberIndexes_Budget_Success(t *testing.T) {
+	globals.SetGlobals()
+	defer func(v int) { globals.PageByteBudget = v }(globals.PageByteBudget)
+	data := syntheticPosts(10)
+	size := entitySize(data.Posts[0])
+	// Three posts fit the budget, so every page is closed well before the page size of 100.
+	globals.PageByteBudget = 3 * (size + 1)
+	ranges := pageRanges(10, 100, func(i int) int { return entitySize(data.Posts[i]) })
+	if len(ranges) < 2 {
+		t.Fatalf("The budget should split the posts before the page size. Ranges: %v", ranges)
+	}
+	var indexes api.Response
+	for i, _ := range data.Posts {
+		indexes.PostIndexes = append(indexes.PostIndexes, api.PostIndex{Fingerprint: data.Posts[i].Fingerprint})
+	}
+	numberIndexes(&indexes, ranges)
+	for page, r := range ranges {
+		for i := r.beg; i < r.end; i++ {
+			if indexes.PostIndexes[i].PageNumber != page {
+				t.Errorf("The index points at the wrong page. Position: %d, Page: %d, Expected: %d", i, indexes.PostIndexes[i].PageNumber, page)
+			}
+		}
+	}
+}
+
+func TestCutToBudget_Success(t *testing.T) {
+	globals.SetGlobals()
+	defer func(v int) { globals.PageByteBudget = v }(globals.PageByteBudget)
+	data := syntheticPosts(10)
+	globals.PageByteBudget = 3 * (entitySize(data.Posts[0]) + 1)
+	cut, fp := cutToBudget(&data, 100)
+	if !cut || len(data.Posts) != 3 {
+		t.Fatalf("The page should be cut to the posts that fit the budget. Cut: %v, Posts: %d", cut, len(data.Posts))
+	}
+	if fp != data.Posts[2].Fingerprint {
+		t.Errorf("The next page should start after the last post that stayed. Fingerprint: %s", fp)
+	}
+	// A page that fits is not cut.
+	cut, _ = cutToBudget(&data, 100)
+	if cut || len(data.Posts) != 3 {
+		t.Errorf("A page that fits the budget should not be cut. Posts: %d", len(data.Posts))
+	}
+	globals.PageByteBudget = 0
+	data = syntheticPosts(10)
+	if cut, _ = cutToBudget(&data, 5); cut || len(data.Posts) != 10 {
+		t.Errorf("Without a budget, a page should not be cut. Posts: %d", len(data.Posts))
+	}
+}
+
+func TestRangesOf_Success(t *testing.T) {
+	globals.SetGlobals()
+	defer func(v int) { globals.PageByteBudget = v }(globals.PageByteBudget)
+	data := syntheticPosts(10)
+	globals.PageByteBudget = 3 * (entitySize(data.Posts[0]) + 1)
+	expected := pageRanges(10, globals.EntityPageSizesObj.Posts, func(i int) int { return entitySize(data.Posts[i]) })
+	ranges := rangesOf(splitEntitiesToPages(&data))
+	if len(ranges) != len(expected) {
+		t.Fatalf("The ranges of the pages are not where the pages were cut. Ranges: %v, Expected: %v", ranges, expected)
+	}
+	for i, _ := range ranges {
+		if ranges[i] != expected[i] {
+			t.Errorf("The ranges of the pages are not where the pages were cut. Ranges: %v, Expected: %v", ranges, expected)
+			break
+		}
+	}
+}
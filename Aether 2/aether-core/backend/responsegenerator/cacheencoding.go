@@ -0,0 +1,140 @@
+// Backend > ResponseGenerator > Cache Encoding
+// This file encodes the pages of a cache into JSON and writes them to disk with a small pool of workers. On big caches, encoding the pages one at a time is what takes the longest. The pool is bounded, so that at most one encoded page per worker is held in memory at a time, and the files come out the same as they would one at a time.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// pageJob is a page to be encoded and written into dir as filename. If hashed, the SHA256 of the encoded page is recorded in the page hashes of the index. Every page is listed in the manifest of the cache as manifestName.
+type pageJob struct {
+	page         *api.ApiResponse
+	dir          string
+	filename     string
+	hashed       bool
+	manifestName string
+}
+
+// writePages encodes and writes the pages with the number of workers in CacheEncodingWorkers, and returns the hashes of the hashed pages by file name, and the manifest entries of all pages, in the order of the jobs. Every page is tried even if some fail; the first error is returned. If the run the pages are written for is cancelled, or a page finds the disk full, the pages not started yet are not written.
+func writePages(jobs []pageJob, run *generationRun) (map[string]string, []api.ManifestPage, error) {
+	return streamPages(len(jobs), func(i int) (pageJob, error) { return jobs[i], nil }, run)
+}
+
+// streamPages is writePages for count pages that are made one at a time, in order, as the workers take them. The pages of a streamed cache are read from the database this way, so that only about one page per worker is held in memory. A page that can't be made stops the ones after it, and its error is returned.
+func streamPages(count int, jobOf func(int) (pageJob, error), run *generationRun) (map[string]string, []api.ManifestPage, error) {
+	hashes := make(map[string]string)
+	manifest := make([]api.ManifestPage, count)
+	workers := globals.CacheEncodingWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > count {
+		workers = count
+	}
+	type queuedJob struct {
+		i   int
+		job pageJob
+	}
+	var lock sync.Mutex
+	var firstErr error
+	queue := make(chan queuedJob)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for q := range queue {
+				listed, err := writePage(q.job)
+				lock.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if err == nil {
+					run.update(func(p *GenerationProgress) { p.PagesWritten++ })
+					manifest[q.i] = listed
+					if q.job.hashed {
+						hashes[q.job.filename] = listed.Sha256
+					}
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		// A full disk fails every page after it too, so they are not tried.
+		if run.cancelled() || diskFullPaused() {
+			break
+		}
+		job, err := jobOf(i)
+		if err != nil {
+			lock.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			lock.Unlock()
+			break
+		}
+		queue <- queuedJob{i, job}
+	}
+	close(queue)
+	wg.Wait()
+	if firstErr == nil && run.cancelled() {
+		firstErr = errGenerationCancelled
+	}
+	if firstErr == nil && diskFullPaused() {
+		firstErr = errors.New("The cache pages were not written, since the disk is full.")
+	}
+	return hashes, manifest, firstErr
+}
+
+// writePage writes the page, and gives its entry in the manifest.
+func writePage(job pageJob) (api.ManifestPage, error) {
+	json, err := EncodeSignedResponse(job.page, DestinationCaches)
+	if err != nil {
+		return api.ManifestPage{}, err
+	}
+	path := fmt.Sprint(job.dir, "/", job.filename)
+	err2 := noteWriteError(path, ioutil.WriteFile(path, json, 0755))
+	if err2 != nil {
+		return api.ManifestPage{}, errors.New(fmt.Sprintf("A cache page could not be written. Path: %s/%s, Error: %s", job.dir, job.filename, err2))
+	}
+	return api.ManifestPage{Name: job.manifestName, Size: int64(len(json)), Sha256: api.HashBytes(json)}, nil
+}
+
+// syncDir flushes the entries of a directory to disk, so that the pages written into it are still there after a crash. Some platforms can't sync a directory; that is logged, not returned.
+func syncDir(path string) {
+	d, err := os.Open(path)
+	if err != nil {
+		logging.Log(2, fmt.Sprintf("The directory could not be opened to be synced. Path: %s, Error: %s", path, err))
+		return
+	}
+	defer d.Close()
+	err2 := d.Sync()
+	if err2 != nil {
+		logging.Log(2, fmt.Sprintf("The directory could not be synced. Path: %s, Error: %s", path, err2))
+	}
+}
+
+// writeManifest writes the manifest of the cache into its folder, after all of its pages, and gives its hash for the index.
+func writeManifest(cacheDir string, cacheName string, pages []api.ManifestPage) (string, error) {
+	m := api.CacheManifest{Cache: cacheName, Timestamp: api.Timestamp(clock.Unix()), Pages: pages}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("The manifest of the cache could not be encoded. Cache: %s, Error: %s", cacheName, err))
+	}
+	err2 := noteWriteError(cacheDir, ioutil.WriteFile(fmt.Sprint(cacheDir, "/", api.ManifestFile), data, 0755))
+	if err2 != nil {
+		return "", errors.New(fmt.Sprintf("The manifest of the cache could not be written. Path: %s/%s, Error: %s", cacheDir, api.ManifestFile, err2))
+	}
+	return api.HashBytes(data), nil
+}
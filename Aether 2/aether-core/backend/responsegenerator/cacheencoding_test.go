@@ -0,0 +1,147 @@
+// This test is in the package itself rather than in responsegenerator_test, since saving a cache to disk is not exported.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// saveWithWorkers saves the same cache with the given number of workers, and returns the folder it was saved into and the page hashes.
+func saveWithWorkers(t *testing.T, dir string, workers int) (string, map[string]string) {
+	globals.CacheEncodingWorkers = workers
+	data := syntheticPosts(5000)
+	pages := splitEntitiesToPages(&data)
+	cacheData, err := buildCacheResponse(pages, createIndexes(pages), 1500000000, 1500086400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The name is random, and it is in every page.
+	cacheData.cacheName = "cache_encoding_test"
+	entityDir := filepath.Join(dir, fmt.Sprint("workers_", workers))
+	createPath(entityDir)
+	err2 := saveCacheToDisk(entityDir, &cacheData, "posts")
+	if err2 != nil {
+		t.Fatalf("The cache could not be saved. Workers: %d, Error: %s", workers, err2)
+	}
+	return filepath.Join(entityDir, cacheData.cacheName), cacheData.pageHashes
+}
+
+func TestSaveCacheToDisk_Success(t *testing.T) {
+	globals.SetGlobals()
+	// ECDSA signatures are different every time, so the pages are compared unsigned.
+	globals.SignResponses = false
+	clock.Set(clock.NewMockClock(time.Unix(1500100000, 0)))
+	defer clock.Reset()
+	dir, err := ioutil.TempDir("", "aether-cacheencoding")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sequential, seqHashes := saveWithWorkers(t, dir, 1)
+	parallel, parHashes := saveWithWorkers(t, dir, 8)
+	for _, sub := range []string{"", "index"} {
+		files, err2 := ioutil.ReadDir(filepath.Join(sequential, sub))
+		if err2 != nil {
+			t.Fatal(err2)
+		}
+		for _, f := range files {
+			if f.IsDir() {
+				continue
+			}
+			a, _ := ioutil.ReadFile(filepath.Join(sequential, sub, f.Name()))
+			b, err3 := ioutil.ReadFile(filepath.Join(parallel, sub, f.Name()))
+			if err3 != nil || !bytes.Equal(a, b) {
+				t.Errorf("The page came out different with parallel encoding. Page: %s", filepath.Join(sub, f.Name()))
+			}
+		}
+	}
+	if len(seqHashes) == 0 || len(seqHashes) != len(parHashes) {
+		t.Errorf("Expected the same number of page hashes. Sequential: %d, Parallel: %d", len(seqHashes), len(parHashes))
+	}
+	for name, hash := range seqHashes {
+		if parHashes[name] != hash {
+			t.Errorf("The hash of the page came out different with parallel encoding. Page: %s", name)
+		}
+	}
+}
+
+func TestSaveCacheToDisk_Manifest_Success(t *testing.T) {
+	globals.SetGlobals()
+	globals.SignResponses = false
+	dir, err := ioutil.TempDir("", "aether-cachemanifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := syntheticPosts(500)
+	pages := splitEntitiesToPages(&data)
+	cacheData, err2 := buildCacheResponse(pages, createIndexes(pages), 1500000000, 1500086400)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	err3 := saveCacheToDisk(dir, &cacheData, "posts")
+	if err3 != nil {
+		t.Fatal(err3)
+	}
+	cacheDir := filepath.Join(dir, cacheData.cacheName)
+	raw, err4 := ioutil.ReadFile(filepath.Join(cacheDir, api.ManifestFile))
+	if err4 != nil {
+		t.Fatalf("The manifest should have been written. Error: %s", err4)
+	}
+	m, err5 := api.ParseManifest(raw, cacheData.manifest)
+	if err5 != nil {
+		t.Fatalf("The manifest should match the hash in the index. Error: %s", err5)
+	}
+	if m.EntityPageCount() != len(*pages) || len(m.Pages) <= len(*pages) || m.Pages[0].Name != "0.json" {
+		t.Errorf("The manifest should list the entity pages first, then the index pages. Pages: %#v", m.Pages)
+	}
+	for _, p := range m.Pages {
+		page, _ := ioutil.ReadFile(filepath.Join(cacheDir, p.Name))
+		if err := p.Verify(page); err != nil {
+			t.Errorf("The page on disk should match the manifest. Error: %s", err)
+		}
+		if hash, ok := cacheData.pageHashes[p.Name]; ok && hash != p.Sha256 {
+			t.Errorf("The page hashes of the index and the manifest should agree. Page: %s", p.Name)
+		}
+	}
+}
+
+func TestStreamPages_Fail_PageNotMade(t *testing.T) {
+	globals.SetGlobals()
+	globals.SignResponses = false
+	globals.CacheEncodingWorkers = 2
+	dir, err := ioutil.TempDir("", "aether-streampages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var made []int
+	_, _, err2 := streamPages(10, func(i int) (pageJob, error) {
+		if i == 4 {
+			return pageJob{}, errCacheEntitiesMoved
+		}
+		made = append(made, i)
+		page := GeneratePrefilledApiResponse()
+		name := fmt.Sprint(i, ".json")
+		return pageJob{page, dir, name, true, name}, nil
+	}, nil)
+	if err2 != errCacheEntitiesMoved {
+		t.Errorf("The error of the page that could not be made should have been returned. Error: %v", err2)
+	}
+	if len(made) != 4 {
+		t.Errorf("The pages after the one that could not be made should not have been made. Made: %v", made)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 4 {
+		t.Errorf("Only the pages before the one that could not be made should have been written. Files: %d", len(files))
+	}
+}
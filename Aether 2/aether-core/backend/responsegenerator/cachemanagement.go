@@ -0,0 +1,352 @@
+// Backend > ResponseGenerator > Cache Management
+// This file provides the operations that let the operator fix the caches of the node by hand: regenerating a single cache, deleting a corrupted one, repairing the index, and rebuilding everything.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// cacheLock makes sure that the scheduled cache generation and the manual operations don't write the same index at the same time.
+var cacheLock sync.Mutex
+
+// RepairReport lists what RepairCacheIndex had to fix.
+type RepairReport struct {
+	EntityType     string   `json:"entity_type"`
+	IndexRebuilt   bool     `json:"index_rebuilt"`   // The index.json could not be read, and a new one was started.
+	RemovedEntries []string `json:"removed_entries"` // Index entries whose cache folder was missing or broken.
+	RemovedFolders []string `json:"removed_folders"` // Cache folders that no index entry pointed to.
+}
+
+func isCacheEntityType(respType string) bool {
+	for _, t := range cacheEntityTypes {
+		if t == respType {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidCacheName checks that the name is one generateCacheName could have created, so that it can't point outside of the caches directory.
+func isValidCacheName(cacheName string) bool {
+	return strings.HasPrefix(cacheName, "cache_") && !strings.ContainsAny(cacheName, "/\\.")
+}
+
+// readCacheIndex reads the index.json of an entity type. A missing index is not an error, it just means there are no caches yet.
+func readCacheIndex(respType string) (api.ApiResponse, error) {
+	var cacheIndex api.ApiResponse
+	cacheIndexAsJson, err := ioutil.ReadFile(fmt.Sprint(globals.CachesLocation, "/", respType, "/index.json"))
+	if err != nil && os.IsNotExist(err) {
+		return *GeneratePrefilledApiResponse(), nil
+	} else if err != nil {
+		return cacheIndex, err
+	}
+	err2 := json.Unmarshal(cacheIndexAsJson, &cacheIndex)
+	if err2 != nil {
+		return cacheIndex, errors.New(fmt.Sprintf("The cache index is corrupted. Entity type: %s, Error: %s", respType, err2))
+	}
+	return cacheIndex, nil
+}
+
+func writeCacheIndex(respType string, cacheIndex *api.ApiResponse) error {
+	cacheIndex.Timestamp = api.Timestamp(clock.Unix())
+	json, err := EncodeSignedResponse(cacheIndex, DestinationCaches)
+	if err != nil {
+		return err
+	}
+	entityCacheDir := fmt.Sprint(globals.CachesLocation, "/", respType)
+	createPath(entityCacheDir)
+	// The index is written next to the old one and moved over it, so that a remote never reads half of it, and the caches it links to change all at once.
+	tmp := fmt.Sprint(entityCacheDir, "/index.json.tmp")
+	err2 := noteWriteError(tmp, ioutil.WriteFile(tmp, json, 0755))
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The cache index could not be written. Entity type: %s, Error: %s", respType, err2))
+	}
+	return os.Rename(tmp, fmt.Sprint(entityCacheDir, "/index.json"))
+}
+
+// RegenerateCache creates the cache of the given time range again from the database, and replaces the caches that cover exactly that range, if any, with it. The index switches to the new cache in a single write after it is complete, and the old ones are retired, so that the remotes downloading them can finish.
+func RegenerateCache(respType string, start api.Timestamp, end api.Timestamp) error {
+	if !isCacheEntityType(respType) {
+		return errors.New(fmt.Sprintf("The requested entity type is unknown to the cache generator. Entity type: %s", respType))
+	}
+	if start >= end {
+		return errors.New(fmt.Sprintf("The start of the range has to be before its end. Start: %d, End: %d", start, end))
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	cacheIndex, err := readCacheIndex(respType)
+	if err != nil {
+		return err
+	}
+	cacheData, err2 := bakeCache(respType, start, end, "")
+	if err2 != nil {
+		return err2
+	}
+	var kept []api.ResultCache
+	var replaced []string
+	for _, c := range cacheIndex.Results {
+		if c.StartsFrom == start && c.EndsAt == end && isValidCacheName(c.ResponseUrl) {
+			logging.Log(1, fmt.Sprintf("The cache %s of %s is regenerated as %s.", c.ResponseUrl, respType, cacheData.cacheName))
+			replaced = append(replaced, c.ResponseUrl)
+			continue
+		}
+		kept = append(kept, c)
+	}
+	cacheIndex.Results = kept
+	updateCacheIndex(&cacheIndex, &cacheData)
+	return switchCaches(respType, &cacheIndex, replaced)
+}
+
+// switchCaches writes the index, which no longer links to the replaced caches, and retires them. The caller holds cacheLock.
+func switchCaches(respType string, cacheIndex *api.ApiResponse, replaced []string) error {
+	err := writeCacheIndex(respType, cacheIndex)
+	if err != nil {
+		return err
+	}
+	err2 := retireCaches(respType, replaced)
+	if err2 != nil {
+		// The folders are out of the index, so the repair of the index will delete them later.
+		logging.Log(1, err2)
+	}
+	sweepRetiredCaches(respType)
+	return nil
+}
+
+// DeleteCache deletes a single cache folder and removes it from the index. The time range it covered will not be available from caches anymore, until it is regenerated.
+func DeleteCache(respType string, cacheName string) error {
+	if !isCacheEntityType(respType) {
+		return errors.New(fmt.Sprintf("The requested entity type is unknown to the cache generator. Entity type: %s", respType))
+	}
+	if !isValidCacheName(cacheName) {
+		return errors.New(fmt.Sprintf("This is not a valid cache name. Cache name: %s", cacheName))
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	err := os.RemoveAll(fmt.Sprint(globals.CachesLocation, "/", respType, "/", cacheName))
+	if err != nil {
+		return err
+	}
+	cacheIndex, err2 := readCacheIndex(respType)
+	if err2 != nil {
+		// The folder is gone, but the index can't be updated. Repairing the index will fix this.
+		return err2
+	}
+	var kept []api.ResultCache
+	for _, c := range cacheIndex.Results {
+		if c.ResponseUrl != cacheName {
+			kept = append(kept, c)
+		}
+	}
+	cacheIndex.Results = kept
+	return writeCacheIndex(respType, &cacheIndex)
+}
+
+// RepairCacheIndex makes the index and the cache folders of an entity type agree. Entries whose folder is missing or has no first page, and that are not in the archives, are removed from the index, and folders that are not in the index are deleted, since without an index entry nobody can know which time range they cover. If the index itself can't be read, a new, empty one is started, and every cache folder ends up being deleted.
+func RepairCacheIndex(respType string) (RepairReport, error) {
+	return repairCacheIndex(respType, true)
+}
+
+// InspectCacheIndex reports what RepairCacheIndex would fix, without changing anything.
+func InspectCacheIndex(respType string) (RepairReport, error) {
+	return repairCacheIndex(respType, false)
+}
+
+// NeedsRepair is true if the repair has something to fix.
+func (r *RepairReport) NeedsRepair() bool {
+	return r.IndexRebuilt || len(r.RemovedEntries) > 0 || len(r.RemovedFolders) > 0
+}
+
+func repairCacheIndex(respType string, apply bool) (RepairReport, error) {
+	var report RepairReport
+	report.EntityType = respType
+	if !isCacheEntityType(respType) {
+		return report, errors.New(fmt.Sprintf("The requested entity type is unknown to the cache generator. Entity type: %s", respType))
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	entityCacheDir := fmt.Sprint(globals.CachesLocation, "/", respType)
+	cacheIndex, err := readCacheIndex(respType)
+	if err != nil {
+		if apply {
+			logging.Log(1, fmt.Sprintf("The cache index could not be read, it will be rebuilt. Error: %s", err))
+		}
+		cacheIndex = *GeneratePrefilledApiResponse()
+		cacheIndex.Caching.ServedFromCache = true
+		cacheIndex.Caching.CacheScope = "day"
+		report.IndexRebuilt = true
+	}
+	referenced := make(map[string]bool)
+	var kept []api.ResultCache
+	for _, c := range cacheIndex.Results {
+		_, statErr := os.Stat(fmt.Sprint(entityCacheDir, "/", c.ResponseUrl, "/0.json"))
+		if !isValidCacheName(c.ResponseUrl) || (statErr != nil && !isArchivedCache(respType, c.ResponseUrl)) {
+			report.RemovedEntries = append(report.RemovedEntries, c.ResponseUrl)
+			continue
+		}
+		referenced[c.ResponseUrl] = true
+		kept = append(kept, c)
+	}
+	cacheIndex.Results = kept
+	folders, err2 := ioutil.ReadDir(entityCacheDir)
+	if err2 != nil && !os.IsNotExist(err2) {
+		return report, err2
+	}
+	// The retired folders are not in the index, but they are still being downloaded.
+	retired := retiredNames(respType)
+	for _, f := range folders {
+		if f.IsDir() && isValidCacheName(f.Name()) && !referenced[f.Name()] && !retired[f.Name()] {
+			if apply {
+				os.RemoveAll(fmt.Sprint(entityCacheDir, "/", f.Name()))
+			}
+			report.RemovedFolders = append(report.RemovedFolders, f.Name())
+		}
+	}
+	if !apply {
+		return report, nil
+	}
+	err3 := writeCacheIndex(respType, &cacheIndex)
+	if err3 != nil {
+		return report, err3
+	}
+	logging.Log(1, fmt.Sprintf("The cache index of %s is repaired. Removed entries: %d, Removed folders: %d", respType, len(report.RemovedEntries), len(report.RemovedFolders)))
+	return report, nil
+}
+
+// cacheWindow is the time range of the caches a generation run makes.
+type cacheWindow struct {
+	start api.Timestamp
+	end   api.Timestamp
+}
+
+// reindexWindows gives the time ranges the caches are made again for: the ones the caches in the indexes were cut at, from the start of time to the end of the last, and from there to now. Every generation run makes the caches of every entity type for the same range, so the ends of the caches of all types together are where the runs were; with no caches, it is one range up to now, as the first run makes.
+func reindexWindows(indexes map[string]api.ApiResponse, now api.Timestamp) []cacheWindow {
+	ends := make(map[api.Timestamp]bool)
+	for _, cacheIndex := range indexes {
+		for _, c := range cacheIndex.Results {
+			if isValidCacheName(c.ResponseUrl) && c.EndsAt > 0 && c.EndsAt < now {
+				ends[c.EndsAt] = true
+			}
+		}
+	}
+	var cuts []api.Timestamp
+	for end, _ := range ends {
+		cuts = append(cuts, end)
+	}
+	sort.Slice(cuts, func(i, j int) bool { return cuts[i] < cuts[j] })
+	var windows []cacheWindow
+	var start api.Timestamp
+	for _, end := range append(cuts, now) {
+		windows = append(windows, cacheWindow{start, end})
+		start = end
+	}
+	return windows
+}
+
+// Reindex replaces all caches of all entity types with ones created again from the database, covering everything up to now. They are made as the scheduled generation makes them, a run at a time, over the time ranges the caches were cut at. This is the last resort when the caches can't be trusted. The old caches are retired, like those of RegenerateCache, once all the new ones are saved, and an index that can't be read is started again.
+func Reindex() error {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	now := api.Timestamp(clock.Unix())
+	indexes := make(map[string]api.ApiResponse)
+	for _, respType := range cacheEntityTypes {
+		cacheIndex, err := readCacheIndex(respType)
+		if err != nil {
+			cacheIndex = *GeneratePrefilledApiResponse()
+		}
+		indexes[respType] = cacheIndex
+	}
+	var baked []bakedCache
+	for _, w := range reindexWindows(indexes, now) {
+		windowBaked, err := bakeCaches(nil, w.start, w.end)
+		if err != nil {
+			discardCaches(baked)
+			return err
+		}
+		baked = append(baked, windowBaked...)
+	}
+	for _, respType := range cacheEntityTypes {
+		cacheIndex := indexes[respType]
+		var replaced []string
+		for _, c := range cacheIndex.Results {
+			if isValidCacheName(c.ResponseUrl) {
+				replaced = append(replaced, c.ResponseUrl)
+			}
+		}
+		cacheIndex.Results = nil
+		for i, _ := range baked {
+			if baked[i].respType == respType {
+				updateCacheIndex(&cacheIndex, &baked[i].cacheData)
+			}
+		}
+		err2 := switchCaches(respType, &cacheIndex, replaced)
+		if err2 != nil {
+			return err2
+		}
+	}
+	globals.LastCacheGenerationTimestamp = int64(now)
+	logging.Log(1, "Reindex of all caches is complete.")
+	return nil
+}
+
+// CacheEntityTypes returns the entity types that have caches.
+func CacheEntityTypes() []string {
+	return cacheEntityTypes
+}
+
+// PruneReport lists the caches PruneCaches deleted, by entity type.
+type PruneReport struct {
+	Cutoff  api.Timestamp       `json:"cutoff"` // Caches that end before this were deleted. 0 if there is no cache retention.
+	Removed map[string][]string `json:"removed"`
+}
+
+// PruneCaches deletes the caches that end before the cache retention, and removes them from the indexes. The entities in them stay in the database; only what the node serves from caches changes. A cache that starts before the cutoff but ends after it is kept whole, so that the remaining caches still cover the whole retention period.
+func PruneCaches() (PruneReport, error) {
+	report := PruneReport{Removed: make(map[string][]string)}
+	if globals.CacheRetentionDays <= 0 {
+		return report, nil
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	report.Cutoff = api.Timestamp(clock.Unix() - int64(globals.CacheRetentionDays)*24*60*60)
+	for _, respType := range cacheEntityTypes {
+		cacheIndex, err := readCacheIndex(respType)
+		if err != nil {
+			// A broken index is for the repair to fix. Pruning the others still makes sense.
+			logging.Log(1, fmt.Sprintf("The caches of %s could not be pruned. Error: %s", respType, err))
+			continue
+		}
+		var kept []api.ResultCache
+		var removed []string
+		for _, c := range cacheIndex.Results {
+			if c.EndsAt < report.Cutoff && isValidCacheName(c.ResponseUrl) {
+				removed = append(removed, c.ResponseUrl)
+				continue
+			}
+			kept = append(kept, c)
+		}
+		if len(removed) == 0 {
+			continue
+		}
+		// The caches are retired rather than deleted, so that the remotes downloading them can finish.
+		cacheIndex.Results = kept
+		err2 := switchCaches(respType, &cacheIndex, removed)
+		if err2 != nil {
+			return report, err2
+		}
+		report.Removed[respType] = removed
+		logging.Log(1, fmt.Sprintf("Retired %d caches of %s that ended before %d.", len(removed), respType, report.Cutoff))
+	}
+	return report, nil
+}
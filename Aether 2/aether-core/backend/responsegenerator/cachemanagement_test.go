@@ -0,0 +1,183 @@
+// This test is in the package itself rather than in responsegenerator_test, since it builds the caches on disk with the same functions as the tests of the repair, which are not exported. Regenerating the caches needs the database, so only the time ranges of the reindex are tested here.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIsValidCacheName_Success(t *testing.T) {
+	cases := map[string]bool{
+		"cache_3f2a":        true,
+		"cache_":            true,
+		"":                  false,
+		"index.json":        false,
+		"3f2a":              false,
+		"../cache_3f2a":     false,
+		"cache_..":          false,
+		"cache_a/../../etc": false,
+		"cache_a\\..\\b":    false,
+		"cache_a/b":         false,
+		"cache_a.json":      false,
+	}
+	for name, valid := range cases {
+		if isValidCacheName(name) != valid {
+			t.Errorf("Unexpected validity of a cache name. Name: %q, Expected: %t", name, valid)
+		}
+	}
+}
+
+func TestDeleteCache_Success(t *testing.T) {
+	cacheName := saveRepairTestCache(t)
+	defer os.RemoveAll(globals.CachesLocation)
+	err := DeleteCache("posts", cacheName)
+	if err != nil {
+		t.Fatalf("The cache could not be deleted. Error: %s", err)
+	}
+	if _, statErr := os.Stat(filepath.Join(globals.CachesLocation, "posts", cacheName)); !os.IsNotExist(statErr) {
+		t.Errorf("The folder of the cache should have been deleted.")
+	}
+	cacheIndex, _ := readCacheIndex("posts")
+	if len(cacheIndex.Results) != 0 {
+		t.Errorf("The cache should have been removed from the index. Index: %#v", cacheIndex.Results)
+	}
+}
+
+func TestDeleteCache_Fail(t *testing.T) {
+	cacheName := saveRepairTestCache(t)
+	defer os.RemoveAll(globals.CachesLocation)
+	// A folder next to the caches, which the names that get out of the caches directory would reach.
+	outside := filepath.Join(globals.CachesLocation, "outside")
+	os.MkdirAll(outside, 0755)
+	cases := []struct {
+		respType  string
+		cacheName string
+	}{
+		{"posts", "../outside"},
+		{"posts", "cache_/../../outside"},
+		{"posts", ".."},
+		{"posts", "outside"},
+		{"posts", ""},
+		{"unknown", cacheName},
+		{"../posts", cacheName},
+	}
+	for _, c := range cases {
+		if err := DeleteCache(c.respType, c.cacheName); err == nil {
+			t.Errorf("The deletion should have been refused. Entity type: %s, Cache name: %s", c.respType, c.cacheName)
+		}
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("A folder outside of the caches was deleted.")
+	}
+	if _, err := os.Stat(filepath.Join(globals.CachesLocation, "posts", cacheName, "0.json")); err != nil {
+		t.Errorf("The cache was deleted by a refused deletion.")
+	}
+}
+
+func TestRepairCacheIndex_Success(t *testing.T) {
+	cases := []struct {
+		name            string
+		damage          func(dir string, cacheName string)
+		rebuilt         bool
+		removedEntries  []string
+		removedFolders  []string
+		cacheKept       bool
+		outsideRequired bool
+	}{
+		{"intact", func(dir string, cacheName string) {}, false, nil, nil, true, false},
+		{"missing folder", func(dir string, cacheName string) {
+			os.RemoveAll(filepath.Join(dir, "posts", cacheName))
+		}, false, []string{"the saved cache"}, nil, false, false},
+		{"missing first page", func(dir string, cacheName string) {
+			os.Remove(filepath.Join(dir, "posts", cacheName, "0.json"))
+		}, false, []string{"the saved cache"}, []string{"the saved cache"}, false, false},
+		{"extra folder", func(dir string, cacheName string) {
+			os.MkdirAll(filepath.Join(dir, "posts", "cache_extra"), 0755)
+			ioutil.WriteFile(filepath.Join(dir, "posts", "cache_extra", "0.json"), []byte("{}"), 0644)
+		}, false, nil, []string{"cache_extra"}, true, false},
+		{"entry outside of the caches", func(dir string, cacheName string) {
+			os.MkdirAll(filepath.Join(dir, "outside"), 0755)
+			ioutil.WriteFile(filepath.Join(dir, "outside", "0.json"), []byte("{}"), 0644)
+			cacheIndex, _ := readCacheIndex("posts")
+			cacheIndex.Results = append(cacheIndex.Results, api.ResultCache{ResponseUrl: "../outside", StartsFrom: 1, EndsAt: 2})
+			writeCacheIndex("posts", &cacheIndex)
+		}, false, []string{"../outside"}, nil, true, true},
+		{"corrupted index", func(dir string, cacheName string) {
+			ioutil.WriteFile(filepath.Join(dir, "posts", "index.json"), []byte("{not json"), 0644)
+		}, true, nil, []string{"the saved cache"}, false, false},
+	}
+	for _, c := range cases {
+		cacheName := saveRepairTestCache(t)
+		dir := globals.CachesLocation
+		c.damage(dir, cacheName)
+		// The name of the cache is only known once it is saved.
+		for i, _ := range c.removedEntries {
+			if c.removedEntries[i] == "the saved cache" {
+				c.removedEntries[i] = cacheName
+			}
+		}
+		for i, _ := range c.removedFolders {
+			if c.removedFolders[i] == "the saved cache" {
+				c.removedFolders[i] = cacheName
+			}
+		}
+		inspected, err := InspectCacheIndex("posts")
+		if err != nil {
+			t.Fatalf("%s: The index could not be inspected. Error: %s", c.name, err)
+		}
+		report, err2 := RepairCacheIndex("posts")
+		if err2 != nil {
+			t.Fatalf("%s: The index could not be repaired. Error: %s", c.name, err2)
+		}
+		if !reflect.DeepEqual(inspected, report) {
+			t.Errorf("%s: The inspection should report what the repair does. Inspected: %#v, Repaired: %#v", c.name, inspected, report)
+		}
+		if report.IndexRebuilt != c.rebuilt || !reflect.DeepEqual(report.RemovedEntries, c.removedEntries) || !reflect.DeepEqual(report.RemovedFolders, c.removedFolders) {
+			t.Errorf("%s: Unexpected repair. Report: %#v", c.name, report)
+		}
+		cacheIndex, err3 := readCacheIndex("posts")
+		if err3 != nil {
+			t.Errorf("%s: The repaired index could not be read. Error: %s", c.name, err3)
+		}
+		kept := len(cacheIndex.Results) == 1 && cacheIndex.Results[0].ResponseUrl == cacheName
+		if kept != c.cacheKept {
+			t.Errorf("%s: Unexpected index after the repair. Index: %#v", c.name, cacheIndex.Results)
+		}
+		if _, statErr := os.Stat(filepath.Join(dir, "posts", "cache_extra")); statErr == nil {
+			t.Errorf("%s: The folder no entry points to should have been deleted.", c.name)
+		}
+		if _, statErr := os.Stat(filepath.Join(dir, "outside", "0.json")); c.outsideRequired && statErr != nil {
+			t.Errorf("%s: A folder outside of the caches was deleted.", c.name)
+		}
+		if again, _ := InspectCacheIndex("posts"); again.NeedsRepair() {
+			t.Errorf("%s: The repaired index should need no more repairs. Report: %#v", c.name, again)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestReindexWindows_Success(t *testing.T) {
+	indexes := map[string]api.ApiResponse{
+		"boards": api.ApiResponse{Results: []api.ResultCache{{ResponseUrl: "cache_a", StartsFrom: 0, EndsAt: 100}, {ResponseUrl: "cache_b", StartsFrom: 100, EndsAt: 200}}},
+		"posts":  api.ApiResponse{Results: []api.ResultCache{{ResponseUrl: "cache_c", StartsFrom: 100, EndsAt: 200}, {ResponseUrl: "cache_d", StartsFrom: 200, EndsAt: 300}}},
+		// The entries that are not caches, or end after now, don't cut the ranges.
+		"votes": api.ApiResponse{Results: []api.ResultCache{{ResponseUrl: "../x", StartsFrom: 0, EndsAt: 150}, {ResponseUrl: "cache_e", StartsFrom: 300, EndsAt: 900}}},
+	}
+	expected := []cacheWindow{{0, 100}, {100, 200}, {200, 300}, {300, 400}}
+	if windows := reindexWindows(indexes, 400); !reflect.DeepEqual(windows, expected) {
+		t.Errorf("Unexpected reindex ranges. Ranges: %v", windows)
+	}
+}
+
+func TestReindexWindows_Success_NoCaches(t *testing.T) {
+	expected := []cacheWindow{{0, 400}}
+	if windows := reindexWindows(map[string]api.ApiResponse{"posts": api.ApiResponse{}}, 400); !reflect.DeepEqual(windows, expected) {
+		t.Errorf("Without caches, the reindex should make one range up to now, as the first run does. Ranges: %v", windows)
+	}
+}
@@ -0,0 +1,117 @@
+// Backend > ResponseGenerator > Cache Stream
+// This file generates the caches of the provable entity types without holding their entities in memory. The indexes are read on their own, with only the columns they need, and they decide where the pages are cut. The entity pages are then read from the database one at a time as they are written. If the entities changed between the reads, the cache is generated again from a full read.
+
+package responsegenerator
+
+import (
+	"aether-core/backend/syncpolicy"
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/globals"
+	"aether-core/services/verify"
+	"errors"
+	"fmt"
+)
+
+// errCacheEntitiesMoved is given when a page read for a streamed cache does not have the entities its indexes say it has. This happens when something was tombstoned or removed between the reads.
+var errCacheEntitiesMoved = errors.New("The entities of the cache changed while it was being generated.")
+
+// cachePageSource is where the entity pages of a streamed cache are read from.
+type cachePageSource struct {
+	plan    persistence.PagePlan
+	ranges  []pageRange
+	indexes *api.Response            // Numbered by the ranges.
+	fps     []api.Fingerprint        // The fingerprints of the indexes, which the pages read have to match.
+	dropped map[api.Fingerprint]bool // The entities the filters dropped from the pages read so far.
+}
+
+// streamedCacheResponse prepares the cache of a provable entity type from its indexes alone. Its entity pages are read when the cache is saved.
+func streamedCacheResponse(respType string, start api.Timestamp, end api.Timestamp) (CacheResponse, error) {
+	var resp CacheResponse
+	indexes, dbError := persistence.ReadIndexes(respType, start, end)
+	if dbError != nil {
+		return resp, errors.New(fmt.Sprintf("This cache generation request caused an error in the local database while trying to respond to this request. Error: %#v\n", dbError))
+	}
+	plan, dbError2 := persistence.PlanRange(respType, start, end, entityPageSize(respType))
+	if dbError2 != nil {
+		return resp, errors.New(fmt.Sprintf("This cache generation request caused an error in the local database while trying to respond to this request. Error: %#v\n", dbError2))
+	}
+	fps := indexFingerprintsOf(&indexes)
+	ranges, err := cacheRanges(plan, len(fps))
+	if err != nil {
+		return resp, err
+	}
+	numberIndexes(&indexes, ranges)
+	cn, err2 := generateCacheName()
+	if err2 != nil {
+		return resp, errors.New(fmt.Sprintf("There was an error in the cache generation request serving. Error: %#v\n", err2))
+	}
+	resp.cacheName = cn
+	resp.start = start
+	resp.end = end
+	resp.source = &cachePageSource{plan, ranges, &indexes, fps, make(map[api.Fingerprint]bool)}
+	return resp, nil
+}
+
+// cacheRanges gives where the pages of a streamed cache are cut. Without a page byte budget, only the count is needed, and a count divisible by the page size gets its empty last page, as in splitEntitiesToPages. With one, the entities are read through once to be sized, and only their sizes are kept.
+func cacheRanges(plan persistence.PagePlan, count int) ([]pageRange, error) {
+	if globals.PageByteBudget <= 0 {
+		return pageRanges(count, plan.PageSize, nil), nil
+	}
+	sizes, err := readEntitySizes(plan, count)
+	if err != nil {
+		return nil, err
+	}
+	if len(sizes) != count {
+		return nil, errCacheEntitiesMoved
+	}
+	return pageRanges(count, plan.PageSize, func(i int) int { return sizes[i] }), nil
+}
+
+// readEntitySizes reads the entities of the plan a page at a time, and keeps only their sizes.
+func readEntitySizes(plan persistence.PagePlan, count int) ([]int, error) {
+	var sizes []int
+	for beg := 0; beg < count; beg += plan.PageSize {
+		page, err := persistence.ReadPageRange(plan, beg, plan.PageSize)
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, entitySizes(&page)...)
+	}
+	return sizes, nil
+}
+
+// readPage reads an entity page of the cache, and filters it as a full read is filtered. The pages are read in order, by one goroutine.
+func (s *cachePageSource) readPage(page int) (api.Response, error) {
+	r := s.ranges[page]
+	data, err := persistence.ReadPageRange(s.plan, r.beg, r.end-r.beg)
+	if err != nil {
+		return data, err
+	}
+	read := fingerprintsOf(&data)
+	if len(read) != r.end-r.beg {
+		return data, errCacheEntitiesMoved
+	}
+	for i, _ := range read {
+		if read[i] != s.fps[r.beg+i] {
+			return data, errCacheEntitiesMoved
+		}
+	}
+	data = syncpolicy.FilterServed(api.FilterByPolicy(verify.FilterByMinPoW(data)))
+	kept := make(map[api.Fingerprint]bool)
+	for _, fp := range fingerprintsOf(&data) {
+		kept[fp] = true
+	}
+	for _, fp := range read {
+		if !kept[fp] {
+			s.dropped[fp] = true
+		}
+	}
+	return data, nil
+}
+
+// indexPages splits the indexes into pages, without the ones of the entities dropped from the entity pages. This is only known once all entity pages are read.
+func (s *cachePageSource) indexPages() *[]api.Response {
+	removeIndexesOf(s.indexes, s.dropped)
+	return splitEntityIndexesToPages(s.indexes)
+}
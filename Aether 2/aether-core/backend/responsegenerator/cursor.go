@@ -0,0 +1,89 @@
+// Backend > ResponseGenerator > Cursor
+// This file creates the responses of the cursor (keyset) pagination mode.
+
+package responsegenerator
+
+import (
+	"aether-core/backend/syncpolicy"
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/logging"
+	"aether-core/services/verify"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EncodeProtocolCursor creates the opaque cursor that points right after the given entity. The remote should not look into it, it just sends it back to get the next page.
+func EncodeProtocolCursor(lastArrival api.Timestamp, lastFp api.Fingerprint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprint(lastArrival, ":", lastFp)))
+}
+
+// DecodeProtocolCursor reads a cursor created by EncodeProtocolCursor. An empty cursor points to the beginning.
+func DecodeProtocolCursor(cursor string) (api.Timestamp, api.Fingerprint, error) {
+	if len(cursor) == 0 {
+		return 0, "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", errors.New(fmt.Sprintf("The cursor could not be decoded. Cursor: %s, Error: %#v", cursor, err))
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return 0, "", errors.New(fmt.Sprintf("The cursor is malformed. Cursor: %s", cursor))
+	}
+	arrival, err2 := strconv.ParseInt(parts[0], 10, 64)
+	if err2 != nil || arrival < 0 {
+		return 0, "", errors.New(fmt.Sprintf("The cursor is malformed. Cursor: %s", cursor))
+	}
+	return api.Timestamp(arrival), api.Fingerprint(parts[1]), nil
+}
+
+// bakeCursorApiResponse reads the page after the cursor in the filters and returns it directly, with the cursor of the next page. Unlike the numbered pages of a multipart response, nothing is saved to disk, and the pages stay correct when new entities arrive in the middle of an iteration.
+func bakeCursorApiResponse(respType string, filters FilterSet) (*api.ApiResponse, error) {
+	resp := GeneratePrefilledApiResponse()
+	afterArrival, afterFp, err := DecodeProtocolCursor(filters.Cursor)
+	if err != nil {
+		return resp, err
+	}
+	pageSize := entityPageSize(respType)
+	pageData, lastArrival, lastFp, err2 := persistence.ReadPageAfterCursorContext(logging.WithTrace(context.Background(), filters.TraceId), respType, filters.TimeStart, filters.TimeEnd, afterArrival, afterFp, pageSize)
+	if err2 != nil {
+		return resp, err2
+	}
+	if cut, cutFp := cutToBudget(&pageData, pageSize); cut {
+		// The page byte budget closed the page early, so the next one starts after the last entity that stayed.
+		cutArrival, err5 := persistence.ReadArrival(respType, cutFp)
+		if err5 != nil {
+			return resp, err5
+		}
+		lastArrival, lastFp = cutArrival, cutFp
+	}
+	// Do not serve what this node would not accept itself, nor the votes the vote sync policies keep.
+	pageData = syncpolicy.FilterServed(api.FilterByPolicy(verify.FilterByMinPoW(pageData)))
+	// The next cursor comes from the page before filtering, so a page can come out empty and still have a next cursor.
+	pageData, err3 := filterByLanguage(pageData, filters.Languages)
+	if err3 != nil {
+		return resp, err3
+	}
+	pageData = filterByBoard(pageData, filters.Boards)
+	err4 := runPrePaginateHooks(respType, DestinationResponses, &pageData)
+	if err4 != nil {
+		return resp, err4
+	}
+	recordSent(filters, pageData)
+	resp = &(*convertResponsesToApiResponses(&[]api.Response{pageData}))[0]
+	// How many pages and entities there are is not known before the end in this mode.
+	resp.Pagination.Pages = 0
+	resp.Pagination.TotalPages = 0
+	resp.Pagination.TotalEntities = 0
+	if len(lastFp) > 0 {
+		// The page was full, so there might be more. The remote is done when it gets a page without a next cursor.
+		resp.Pagination.NextCursor = EncodeProtocolCursor(lastArrival, lastFp)
+	}
+	resp.Endpoint = "cursor_post_response"
+	return resp, nil
+}
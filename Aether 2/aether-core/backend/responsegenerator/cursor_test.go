@@ -0,0 +1,52 @@
+package responsegenerator_test
+
+import (
+	"aether-core/backend/responsegenerator"
+	"aether-core/io/api"
+	"encoding/base64"
+	"testing"
+)
+
+func TestDecodeProtocolCursor_Success(t *testing.T) {
+	cases := []struct {
+		name    string
+		arrival api.Timestamp
+		fp      api.Fingerprint
+	}{
+		{"Beginning", 0, ""},
+		{"Entity", 1600000000, "ab12"},
+		// The fingerprint is everything after the first separator.
+		{"SeparatorInFingerprint", 5, "ab:12"},
+	}
+	for _, c := range cases {
+		arrival, fp, err := responsegenerator.DecodeProtocolCursor(responsegenerator.EncodeProtocolCursor(c.arrival, c.fp))
+		if err != nil || arrival != c.arrival || fp != c.fp {
+			t.Errorf("The cursor did not come back as it was made. Case: %s, Arrival: %d, Fingerprint: %s, Error: %v", c.name, arrival, fp, err)
+		}
+	}
+	arrival, fp, err2 := responsegenerator.DecodeProtocolCursor("")
+	if err2 != nil || arrival != 0 || len(fp) != 0 {
+		t.Errorf("An empty cursor should point to the beginning. Arrival: %d, Fingerprint: %s, Error: %v", arrival, fp, err2)
+	}
+}
+
+func TestDecodeProtocolCursor_Fail(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	cases := []struct {
+		name   string
+		cursor string
+	}{
+		{"BadBase64", "not base64!"},
+		{"PaddedBase64", base64.URLEncoding.EncodeToString([]byte("1:ab"))},
+		{"MissingSeparator", encode("1600000000ab12")},
+		{"NegativeArrival", encode("-1:ab12")},
+		{"ArrivalNotNumber", encode("now:ab12")},
+		{"EmptyArrival", encode(":ab12")},
+	}
+	for _, c := range cases {
+		_, _, err := responsegenerator.DecodeProtocolCursor(c.cursor)
+		if err == nil {
+			t.Errorf("A malformed cursor was accepted. Case: %s, Cursor: %s", c.name, c.cursor)
+		}
+	}
+}
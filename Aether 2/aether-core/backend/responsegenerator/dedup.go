@@ -0,0 +1,163 @@
+// Backend > ResponseGenerator > Dedup
+// This file finds the pages that are the same, byte for byte, in different caches, and makes them links to a single file. A cache that is generated again over the same range, or a consolidated one, has many of the pages of the caches it replaces, and on a node that runs for a long time the copies add up. The remotes see the same bytes either way; only the disk usage changes.
+// Only the caches that are in their index or retired are looked at. Those are complete, and nothing writes into them anymore, so a file shared between two of them is never changed through one of them. A duplicate is replaced by a link made beside it and renamed over it, so that there is no moment where the page is not there to be served.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DedupReport lists what a deduplication of the caches found and did.
+type DedupReport struct {
+	Generated  int64 `json:"generated"`
+	Files      int   `json:"files"`       // The page files looked at.
+	Duplicates int   `json:"duplicates"`  // The files that have the same bytes as another, and are not yet a link to it.
+	Linked     int   `json:"linked"`      // The duplicates that were made links.
+	SavedBytes int64 `json:"saved_bytes"` // The bytes the links freed.
+}
+
+// dedupLock makes sure that two deduplications don't link the same files at the same time.
+var dedupLock sync.Mutex
+
+// dedupFile is a page file of a cache, with where it is on disk.
+type dedupFile struct {
+	disk string
+	info os.FileInfo
+}
+
+// cacheFolders gives the folders of the caches of the entity type that are in the index or retired. The caller holds cacheLock.
+func cacheFolders(respType string) ([]string, error) {
+	cacheIndex, err := readCacheIndex(respType)
+	if err != nil {
+		return nil, err
+	}
+	names := retiredNames(respType)
+	for _, c := range cacheIndex.Results {
+		names[c.ResponseUrl] = true
+	}
+	var folders []string
+	for name, _ := range names {
+		folders = append(folders, fmt.Sprint(globals.CachesLocation, "/", respType, "/", name))
+	}
+	sort.Strings(folders)
+	return folders, nil
+}
+
+// findDedupFiles gives the files in the folders of the caches of every entity type, grouped by their sizes. Files of different sizes can't be the same, so only the groups with more than one file need to be read.
+func findDedupFiles() (map[int64][]dedupFile, int) {
+	bySize := make(map[int64][]dedupFile)
+	count := 0
+	for _, respType := range cacheEntityTypes {
+		cacheLock.Lock()
+		folders, err := cacheFolders(respType)
+		cacheLock.Unlock()
+		if err != nil {
+			logging.Log(1, fmt.Sprintf("The caches of %s could not be checked for duplicate pages. Error: %s", respType, err))
+			continue
+		}
+		for _, folder := range folders {
+			filepath.Walk(folder, func(p string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() || strings.HasSuffix(p, ".tmp") || info.Size() == 0 {
+					return nil
+				}
+				bySize[info.Size()] = append(bySize[info.Size()], dedupFile{disk: p, info: info})
+				count++
+				return nil
+			})
+		}
+	}
+	return bySize, count
+}
+
+// linkDuplicate makes the duplicate a link to the original. The link is made beside the duplicate and renamed over it, and only if both are still where they were found, under cacheLock, so that a cache folder deleted meanwhile is not brought back.
+func linkDuplicate(original string, duplicate string) error {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	origInfo, err := os.Stat(original)
+	if err != nil {
+		return err
+	}
+	dupInfo, err2 := os.Stat(duplicate)
+	if err2 != nil {
+		return err2
+	}
+	if os.SameFile(origInfo, dupInfo) {
+		return nil
+	}
+	tmp := fmt.Sprint(duplicate, ".link.tmp")
+	os.Remove(tmp)
+	err3 := os.Link(original, tmp)
+	if err3 != nil {
+		return err3
+	}
+	err4 := os.Rename(tmp, duplicate)
+	if err4 != nil {
+		os.Remove(tmp)
+		return err4
+	}
+	return nil
+}
+
+// DedupCaches makes the pages that are the same in different caches, or in the same one, links to a single file.
+func DedupCaches() (DedupReport, error) {
+	dedupLock.Lock()
+	defer dedupLock.Unlock()
+	report := DedupReport{Generated: clock.Unix()}
+	bySize, count := findDedupFiles()
+	report.Files = count
+	// A link that can't be made is most likely one the file system doesn't support at all, so it is logged once, with how many there were.
+	var firstErr error
+	failed := 0
+	for size, files := range bySize {
+		if len(files) < 2 {
+			continue
+		}
+		// The first file with the bytes is kept, and the others are made links to it.
+		byHash := make(map[string]dedupFile)
+		for _, f := range files {
+			data, err := ioutil.ReadFile(f.disk)
+			if err != nil {
+				// Deleted since it was found.
+				continue
+			}
+			hash := api.HashBytes(data)
+			original, seen := byHash[hash]
+			if !seen {
+				byHash[hash] = f
+				continue
+			}
+			if os.SameFile(original.info, f.info) {
+				continue
+			}
+			report.Duplicates++
+			err2 := linkDuplicate(original.disk, f.disk)
+			if err2 != nil {
+				if firstErr == nil {
+					firstErr = err2
+				}
+				failed++
+				continue
+			}
+			report.Linked++
+			report.SavedBytes += size
+		}
+	}
+	if failed > 0 {
+		logging.Log(1, fmt.Sprintf("Some of the duplicate pages of the caches could not be made links. Failed: %d, First error: %s", failed, firstErr))
+	}
+	if report.Linked > 0 {
+		logging.Log(1, fmt.Sprintf("The caches are deduplicated. Files: %d, Linked: %d, Freed bytes: %d", report.Files, report.Linked, report.SavedBytes))
+	}
+	return report, nil
+}
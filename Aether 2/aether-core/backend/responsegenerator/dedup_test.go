@@ -0,0 +1,95 @@
+// This test is in the package itself rather than in responsegenerator_test, since it builds the caches on disk with the same functions as the tests of the repair, which are not exported.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// saveDedupTestCaches builds a cache in the index, and a copy of it under another name, in the index too, as a cache generated again over the same range would be. It gives the names of both.
+func saveDedupTestCaches(t *testing.T) (string, string) {
+	cacheName := saveRepairTestCache(t)
+	copyName := "cache_copy"
+	src := filepath.Join(globals.CachesLocation, "posts", cacheName)
+	filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(src, p)
+		dest := filepath.Join(globals.CachesLocation, "posts", copyName, rel)
+		os.MkdirAll(filepath.Dir(dest), 0755)
+		data, _ := ioutil.ReadFile(p)
+		return ioutil.WriteFile(dest, data, 0644)
+	})
+	cacheIndex, err := readCacheIndex("posts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := cacheIndex.Results[0]
+	link.ResponseUrl = copyName
+	cacheIndex.Results = append(cacheIndex.Results, link)
+	if err2 := writeCacheIndex("posts", &cacheIndex); err2 != nil {
+		t.Fatal(err2)
+	}
+	return cacheName, copyName
+}
+
+func sameFile(t *testing.T, a string, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bi, err2 := os.Stat(b)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	return os.SameFile(ai, bi)
+}
+
+func TestDedupCaches_Success(t *testing.T) {
+	cacheName, copyName := saveDedupTestCaches(t)
+	defer os.RemoveAll(globals.CachesLocation)
+	report, err := DedupCaches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Linked == 0 || report.Linked != report.Duplicates || report.SavedBytes == 0 {
+		t.Fatalf("The pages of the copy should have been made links. Report: %#v", report)
+	}
+	for _, page := range []string{"0.json", "index/0.json", api.ManifestFile} {
+		a := filepath.Join(globals.CachesLocation, "posts", cacheName, page)
+		b := filepath.Join(globals.CachesLocation, "posts", copyName, page)
+		if !sameFile(t, a, b) {
+			t.Errorf("The page should be a single file in both caches. Page: %s", page)
+		}
+	}
+	if err2 := checkCachePage("posts", copyName, "1.json"); err2 != nil {
+		t.Errorf("A linked page should still pass the check against the manifest. Error: %s", err2)
+	}
+	report2, _ := DedupCaches()
+	if report2.Linked != 0 || report2.Duplicates != 0 {
+		t.Errorf("The pages that are links already should be left as they are. Report: %#v", report2)
+	}
+}
+
+func TestDedupCaches_Fail_Orphan(t *testing.T) {
+	cacheName := saveRepairTestCache(t)
+	defer os.RemoveAll(globals.CachesLocation)
+	page := filepath.Join(globals.CachesLocation, "posts", cacheName, "0.json")
+	orphan := filepath.Join(globals.CachesLocation, "posts", "cache_orphan", "0.json")
+	os.MkdirAll(filepath.Dir(orphan), 0755)
+	data, _ := ioutil.ReadFile(page)
+	ioutil.WriteFile(orphan, data, 0644)
+	report, err := DedupCaches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Linked != 0 || sameFile(t, page, orphan) {
+		t.Errorf("A cache folder that is neither in the index nor retired could still be written into, and should not be linked. Report: %#v", report)
+	}
+}
@@ -0,0 +1,76 @@
+// Backend > ResponseGenerator > Disk Full
+// This file pauses the cache writes when the disk fills up. A cache whose pages could not all be written is removed, since the remotes would take a cache with pages missing for one that has fewer entities, and the rest of the caches of the run are removed with it, as with a run that is cancelled, so that the run is made again as a whole. After that, the caches are not written until there are CacheResumeFreeDiskBytes free where they are kept, and the health report of the node warns about it meanwhile.
+
+package responsegenerator
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/diskspace"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DiskFullStatus is the state of the cache writes after one of them found the disk full.
+type DiskFullStatus struct {
+	Paused    bool   `json:"paused"`
+	Since     int64  `json:"since,omitempty"`      // When the write failed.
+	Path      string `json:"path,omitempty"`       // The file that could not be written.
+	Error     string `json:"error,omitempty"`      // The error of the write.
+	FreeBytes uint64 `json:"free_bytes,omitempty"` // The free space at the last check, if it can be read on the platform.
+	Checked   int64  `json:"checked,omitempty"`    // When the free space was last checked.
+}
+
+var diskFullLock sync.Mutex
+var diskFull DiskFullStatus
+
+// noteWriteError looks at the error of a write of a cache file, and pauses the cache writes if it is the disk being full. The error is given back as it is.
+func noteWriteError(path string, err error) error {
+	if err == nil || !diskspace.IsFull(err) {
+		return err
+	}
+	diskFullLock.Lock()
+	defer diskFullLock.Unlock()
+	if !diskFull.Paused {
+		logging.Log(1, fmt.Sprintf("The disk is full, so the cache writes are paused until there are %d MB free. Path: %s, Error: %s", globals.CacheResumeFreeDiskBytes/(1024*1024), path, err))
+		diskFull = DiskFullStatus{Paused: true, Since: clock.Unix(), Path: path, Error: err.Error()}
+	}
+	return err
+}
+
+// diskFullPaused tells whether the cache writes are paused for a full disk.
+func diskFullPaused() bool {
+	diskFullLock.Lock()
+	defer diskFullLock.Unlock()
+	return diskFull.Paused
+}
+
+// resumeIfSpace checks the free space where the caches are kept, if the cache writes are paused, and resumes them if there is enough. It gives an error if they stay paused. If the free space can't be read, they resume, and the next write tells whether the disk is still full.
+func resumeIfSpace() error {
+	diskFullLock.Lock()
+	defer diskFullLock.Unlock()
+	if !diskFull.Paused {
+		return nil
+	}
+	diskFull.Checked = clock.Unix()
+	free, err := diskspace.Free(globals.CachesLocation)
+	if err == nil && int64(free) < globals.CacheResumeFreeDiskBytes {
+		diskFull.FreeBytes = free
+		return errors.New(fmt.Sprintf("The cache writes are paused, since the disk was full. They resume when there are %d MB free. Free: %d MB, Since: %d", globals.CacheResumeFreeDiskBytes/(1024*1024), free/(1024*1024), diskFull.Since))
+	}
+	if err != nil && err != diskspace.ErrUnsupported {
+		logging.Log(1, fmt.Sprintf("The free disk space could not be read, so the cache writes resume. Error: %s", err))
+	}
+	logging.Log(1, fmt.Sprintf("There is space on the disk again, so the cache writes resume. Paused since: %d", diskFull.Since))
+	diskFull = DiskFullStatus{}
+	return nil
+}
+
+// DiskFull gives the state of the cache writes, as of the last write that found the disk full, and the last check of the free space since.
+func DiskFull() DiskFullStatus {
+	diskFullLock.Lock()
+	defer diskFullLock.Unlock()
+	return diskFull
+}
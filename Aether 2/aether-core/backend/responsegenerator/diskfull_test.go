@@ -0,0 +1,54 @@
+// This test is in the package itself rather than in responsegenerator_test, since the pause of the cache writes is not exported.
+
+package responsegenerator
+
+import (
+	"aether-core/services/globals"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestDiskFull_Success(t *testing.T) {
+	globals.SetGlobals()
+	globals.SignResponses = false
+	dir, err := ioutil.TempDir("", "aether-diskfull")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	globals.CachesLocation = dir
+	noteWriteError(filepath.Join(dir, "0.json"), &os.PathError{Op: "write", Path: filepath.Join(dir, "0.json"), Err: syscall.ENOSPC})
+	if status := DiskFull(); !status.Paused || status.Since == 0 {
+		t.Fatalf("A write that found the disk full should pause the cache writes. Status: %#v", status)
+	}
+	data := syntheticPosts(500)
+	pages := splitEntitiesToPages(&data)
+	cacheData, err2 := buildCacheResponse(pages, createIndexes(pages), 1500000000, 1500086400)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	if err3 := saveCacheToDisk(dir, &cacheData, "posts"); err3 == nil {
+		t.Errorf("A cache should not be saved while the cache writes are paused.")
+	}
+	if files, _ := ioutil.ReadDir(filepath.Join(dir, cacheData.cacheName)); len(files) > 1 {
+		t.Errorf("No page should have been written while the cache writes are paused, only the index folder. Files: %d", len(files))
+	}
+	globals.CacheResumeFreeDiskBytes = 1 << 62
+	if err4 := resumeIfSpace(); err4 == nil || DiskFull().Checked == 0 {
+		t.Errorf("The cache writes should stay paused without enough free space. Status: %#v", DiskFull())
+	}
+	globals.CacheResumeFreeDiskBytes = 0
+	if err5 := resumeIfSpace(); err5 != nil || diskFullPaused() {
+		t.Errorf("The cache writes should resume once there is space. Error: %v", err5)
+	}
+}
+
+func TestDiskFull_Fail_OtherErrors(t *testing.T) {
+	noteWriteError("0.json", &os.PathError{Op: "open", Path: "0.json", Err: syscall.EACCES})
+	if diskFullPaused() {
+		t.Errorf("Only the errors of a full disk should pause the cache writes.")
+	}
+}
@@ -0,0 +1,148 @@
+// Backend > ResponseGenerator > Encoders
+// This file keeps the encoders the responses and the caches are written with. Compact JSON is what goes out by default; indented JSON is there for reading them by hand while debugging. The encoder is chosen per destination, the POST responses or the caches, in the output encoders setting, and the operator can pick one for the responses of a single endpoint from the admin API, to look at that endpoint without changing the others. A new format, such as a binary one, is added by registering its encoder.
+// The signatures of the responses are made over their canonical JSON, and the hashes of the cache pages over the bytes as written, so the remotes read and check what any encoder writes the same way, as long as they can parse it.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// DestinationResponses is where the responses to the requests of the remotes go: the POST responses, their pages, and the node response.
+	DestinationResponses = "responses"
+	// DestinationCaches is where the caches go: their pages and their indexes.
+	DestinationCaches = "caches"
+)
+
+// DefaultEncoder is the encoder of the destinations that don't set one.
+const DefaultEncoder = "json"
+
+// Encoder turns a response into the bytes that are written or sent.
+type Encoder struct {
+	Name   string
+	Encode func(resp *api.ApiResponse) ([]byte, error)
+}
+
+var encodersLock sync.Mutex
+var encoders = make(map[string]Encoder)
+
+// debugEncoders are the encoders the operator picked for the responses of single endpoints, by the names of the endpoints. They are not saved; a restart goes back to the setting.
+var debugEncoders = make(map[string]string)
+
+func init() {
+	mustRegisterEncoder(Encoder{Name: DefaultEncoder, Encode: func(resp *api.ApiResponse) ([]byte, error) {
+		return json.Marshal(resp)
+	}})
+	mustRegisterEncoder(Encoder{Name: "json-pretty", Encode: func(resp *api.ApiResponse) ([]byte, error) {
+		return json.MarshalIndent(resp, "", "  ")
+	}})
+}
+
+// RegisterEncoder adds an encoder that the destinations and the endpoints can be set to.
+func RegisterEncoder(e Encoder) error {
+	if len(e.Name) == 0 || e.Encode == nil {
+		return errors.New(fmt.Sprintf("An encoder needs a name and an encoding function. Name: %s", e.Name))
+	}
+	encodersLock.Lock()
+	defer encodersLock.Unlock()
+	if _, exists := encoders[e.Name]; exists {
+		return errors.New(fmt.Sprintf("An encoder with this name is already registered. Name: %s", e.Name))
+	}
+	encoders[e.Name] = e
+	return nil
+}
+
+func mustRegisterEncoder(e Encoder) {
+	err := RegisterEncoder(e)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Encoders gives the names of the registered encoders, in order.
+func Encoders() []string {
+	encodersLock.Lock()
+	defer encodersLock.Unlock()
+	var names []string
+	for name, _ := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetEndpointEncoder sets the encoder of the responses of an endpoint, over the one of their destination. An empty name goes back to the one of the destination.
+func SetEndpointEncoder(endpoint string, name string) error {
+	encodersLock.Lock()
+	defer encodersLock.Unlock()
+	if len(name) == 0 {
+		delete(debugEncoders, endpoint)
+		return nil
+	}
+	if _, exists := encoders[name]; !exists {
+		return errors.New(fmt.Sprintf("There is no encoder with this name. Name: %s", name))
+	}
+	debugEncoders[endpoint] = name
+	return nil
+}
+
+// EndpointEncoders gives the encoders the operator picked for the responses of single endpoints.
+func EndpointEncoders() map[string]string {
+	encodersLock.Lock()
+	defer encodersLock.Unlock()
+	picked := make(map[string]string)
+	for endpoint, name := range debugEncoders {
+		picked[endpoint] = name
+	}
+	return picked
+}
+
+// encoderFor gives the encoder of a response of the endpoint that goes to the destination. An encoder that is set but not registered falls back to the default, so that a typo in the config file doesn't stop the node from responding.
+func encoderFor(destination string, endpoint string) Encoder {
+	encodersLock.Lock()
+	defer encodersLock.Unlock()
+	name, picked := debugEncoders[endpoint]
+	if !picked || destination != DestinationResponses {
+		name = globals.OutputEncoders[destination]
+	}
+	if len(name) == 0 {
+		name = DefaultEncoder
+	}
+	e, exists := encoders[name]
+	if !exists {
+		logging.LogSampled("responsegenerator", "unknown-encoder", 1, fmt.Sprintf("The encoder set for the destination is not known, the default is used instead. Destination: %s, Encoder: %s", destination, name))
+		return encoders[DefaultEncoder]
+	}
+	return e
+}
+
+// EncodeResponse encodes the response with the encoder of the destination.
+func EncodeResponse(resp *api.ApiResponse, destination string) ([]byte, error) {
+	e := encoderFor(destination, resp.Entity)
+	result, err := e.Encode(resp)
+	if err != nil {
+		return result, errors.New(fmt.Sprintf("This ApiResponse failed to encode. Encoder: %s, Error: %s", e.Name, err))
+	}
+	return result, nil
+}
+
+// EncodeSignedResponse runs the pre-serialize hooks over the response, signs it with the node key, if responses are signed, and encodes it with the encoder of the destination. This is for what is served to everyone, like the caches, and not bound to a request.
+func EncodeSignedResponse(resp *api.ApiResponse, destination string) ([]byte, error) {
+	errHooks := runPreSerializeHooks(destination, resp)
+	if errHooks != nil {
+		return []byte{}, errHooks
+	}
+	err := signForEveryone(resp)
+	if err != nil {
+		return []byte{}, err
+	}
+	return EncodeResponse(resp, destination)
+}
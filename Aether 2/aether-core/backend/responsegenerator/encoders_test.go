@@ -0,0 +1,64 @@
+package responsegenerator_test
+
+import (
+	"aether-core/backend/responsegenerator"
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestEncodeResponse_Success(t *testing.T) {
+	globals.OutputEncoders = map[string]string{responsegenerator.DestinationResponses: "json-pretty"}
+	globals.SignResponses = true
+	defer func() { globals.OutputEncoders = map[string]string{} }()
+	resp := responsegenerator.GeneratePrefilledApiResponse()
+	resp.Entity = "threads"
+	pretty, err := responsegenerator.EncodeSignedResponse(resp, responsegenerator.DestinationResponses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(pretty, []byte("\n  ")) {
+		t.Errorf("The responses should be indented. Response: %s", pretty)
+	}
+	var parsed api.ApiResponse
+	json.Unmarshal(pretty, &parsed)
+	if err2 := api.VerifyResponse(pretty, &parsed, ""); err2 != nil || len(parsed.ResponseSignature) == 0 {
+		t.Errorf("The signature of the indented response should check. Error: %v", err2)
+	}
+	compact, err3 := responsegenerator.EncodeResponse(resp, responsegenerator.DestinationCaches)
+	if err3 != nil || bytes.Contains(compact, []byte("\n")) {
+		t.Errorf("The caches should stay compact. Cache: %s, Error: %v", compact, err3)
+	}
+	// An endpoint picked from the admin API is indented in the responses, and only there.
+	globals.OutputEncoders = map[string]string{}
+	err4 := responsegenerator.SetEndpointEncoder("threads", "json-pretty")
+	if err4 != nil {
+		t.Fatal(err4)
+	}
+	defer responsegenerator.SetEndpointEncoder("threads", "")
+	picked, _ := responsegenerator.EncodeResponse(resp, responsegenerator.DestinationResponses)
+	cache, _ := responsegenerator.EncodeResponse(resp, responsegenerator.DestinationCaches)
+	resp.Entity = "posts"
+	other, _ := responsegenerator.EncodeResponse(resp, responsegenerator.DestinationResponses)
+	if !bytes.Contains(picked, []byte("\n  ")) || bytes.Contains(cache, []byte("\n")) || bytes.Contains(other, []byte("\n")) {
+		t.Errorf("Only the responses of the picked endpoint should be indented.")
+	}
+}
+
+func TestEncodeResponse_Fail_UnknownEncoder(t *testing.T) {
+	if err := responsegenerator.SetEndpointEncoder("threads", "xml"); err == nil {
+		t.Errorf("An encoder that is not registered should not be picked.")
+	}
+	if err := responsegenerator.RegisterEncoder(responsegenerator.Encoder{Name: "json", Encode: func(resp *api.ApiResponse) ([]byte, error) { return json.Marshal(resp) }}); err == nil {
+		t.Errorf("An encoder should not be registered twice.")
+	}
+	// An encoder in the config file that is not registered falls back to compact JSON.
+	globals.OutputEncoders = map[string]string{responsegenerator.DestinationCaches: "xml"}
+	defer func() { globals.OutputEncoders = map[string]string{} }()
+	data, err := responsegenerator.EncodeResponse(responsegenerator.GeneratePrefilledApiResponse(), responsegenerator.DestinationCaches)
+	if err != nil || bytes.Contains(data, []byte("\n")) || !json.Valid(data) {
+		t.Errorf("An unknown encoder should fall back to compact JSON. Data: %s, Error: %v", data, err)
+	}
+}
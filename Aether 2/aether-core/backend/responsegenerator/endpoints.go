@@ -0,0 +1,229 @@
+// Backend > ResponseGenerator > Endpoints
+// This file keeps the registry of the endpoints the generator serves. Each endpoint says how its entities are read for a POST request and for a cache, how large its pages are, and whether it has indexes. The generator looks these up instead of switching on the entity type, so a new entity type is served by registering it here.
+
+package responsegenerator
+
+import (
+	"aether-core/backend/compaction"
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/globals"
+	"errors"
+	"fmt"
+)
+
+// Endpoint is what the generator needs to know to serve an entity type.
+type Endpoint struct {
+	Name string
+	// Summary says what the endpoint serves, for the API description.
+	Summary string
+	// Provable entities are signed by their owners. Remotes can submit them, the PoW policy applies to them, and large reads of them are paged or iterated with a cursor in the database. Their POST reads and caches come from the generic reads of the persistence package.
+	Provable bool
+	// PageSize and IndexPageSize give the current page sizes. IndexPageSize is nil if the entities are their own index.
+	PageSize      func() int
+	IndexPageSize func() int
+	// ReadPOST reads what a POST request asks for. Not needed for the provable entities.
+	ReadPOST func(filters FilterSet) (api.Response, error)
+	// ReadRange reads the entities of a cache, and CountRange counts them for the plan. Not needed for the provable entities. If neither is given, the endpoint has no caches.
+	ReadRange  func(start api.Timestamp, end api.Timestamp) (api.Response, error)
+	CountRange func(start api.Timestamp, end api.Timestamp) (int, error)
+	// Respond builds the whole response, for the endpoints that don't give pages of entities.
+	Respond func(filters FilterSet) (*api.ApiResponse, error)
+	// ResponseEndpoint, if given, is the endpoint field of the POST response.
+	ResponseEndpoint string
+}
+
+// Cached is true if the endpoint has caches.
+func (e *Endpoint) Cached() bool {
+	return e.Provable || e.ReadRange != nil
+}
+
+// Indexed is true if the caches of the endpoint have index pages.
+func (e *Endpoint) Indexed() bool {
+	return e.IndexPageSize != nil
+}
+
+var endpoints = make(map[string]*Endpoint)
+
+// endpointNames are the names of the endpoints, in the order they are registered in.
+var endpointNames []string
+
+// cacheEntityTypes are the endpoints that have caches, in the order GenerateCaches creates them, which is the order they are registered in.
+var cacheEntityTypes []string
+
+// RegisterEndpoint adds an endpoint to the registry. It is meant to be called from init functions, before anything is served.
+func RegisterEndpoint(e Endpoint) error {
+	if len(e.Name) == 0 || e.PageSize == nil {
+		return errors.New(fmt.Sprintf("An endpoint needs a name and a page size. Endpoint: %s", e.Name))
+	}
+	if _, exists := endpoints[e.Name]; exists {
+		return errors.New(fmt.Sprintf("This endpoint is already registered. Endpoint: %s", e.Name))
+	}
+	if !e.Provable && e.Respond == nil && e.ReadPOST == nil {
+		return errors.New(fmt.Sprintf("An endpoint that is not provable needs either a reader or its own response. Endpoint: %s", e.Name))
+	}
+	if (e.ReadRange == nil) != (e.CountRange == nil) {
+		return errors.New(fmt.Sprintf("An endpoint with caches needs both a range reader and a range counter. Endpoint: %s", e.Name))
+	}
+	endpoints[e.Name] = &e
+	endpointNames = append(endpointNames, e.Name)
+	if e.Cached() {
+		cacheEntityTypes = append(cacheEntityTypes, e.Name)
+	}
+	return nil
+}
+
+// LookupEndpoint gives the registered endpoint with the given name.
+func LookupEndpoint(name string) (*Endpoint, bool) {
+	e, ok := endpoints[name]
+	return e, ok
+}
+
+// RegisteredEndpoints gives the registered endpoints, in the order they are registered in.
+func RegisteredEndpoints() []Endpoint {
+	var result []Endpoint
+	for _, name := range endpointNames {
+		result = append(result, *endpoints[name])
+	}
+	return result
+}
+
+// provableEndpoint is the registration of a provable entity type.
+func provableEndpoint(name string, summary string, pageSize func() int, indexPageSize func() int) Endpoint {
+	return Endpoint{Name: name, Summary: summary, Provable: true, PageSize: pageSize, IndexPageSize: indexPageSize}
+}
+
+func mustRegister(e Endpoint) {
+	err := RegisterEndpoint(e)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func init() {
+	// The registration order is the order of the caches.
+	mustRegister(provableEndpoint("boards", "The boards, and the updates of their names, descriptions and moderators.",
+		func() int { return globals.EntityPageSizesObj.Boards },
+		func() int { return globals.EntityPageSizesObj.BoardIndexes }))
+	mustRegister(provableEndpoint("threads", "The threads of the boards.",
+		func() int { return globals.EntityPageSizesObj.Threads },
+		func() int { return globals.EntityPageSizesObj.ThreadIndexes }))
+	mustRegister(provableEndpoint("posts", "The posts of the threads, and the replies to other posts.",
+		func() int { return globals.EntityPageSizesObj.Posts },
+		func() int { return globals.EntityPageSizesObj.PostIndexes }))
+	mustRegister(provableEndpoint("votes", "The votes on the threads and the posts.",
+		func() int { return globals.EntityPageSizesObj.Votes },
+		func() int { return globals.EntityPageSizesObj.VoteIndexes }))
+	mustRegister(Endpoint{
+		Name:     "addresses",
+		Summary:  "The addresses of the nodes this node knows, by when they were last seen. The ones that have not been reached for a while are left out.",
+		PageSize: func() int { return globals.EntityPageSizesObj.Addresses },
+		// Addresses can't do address search by loc/subloc/port. Only time search is available, since addresses don't have fingerprints defined.
+		ReadPOST: func(filters FilterSet) (api.Response, error) {
+			return readAddresses(filters.TimeStart, filters.TimeEnd)
+		},
+		ReadRange:        readAddresses,
+		CountRange:       persistence.CountAddresses,
+		ResponseEndpoint: "entity",
+	})
+	mustRegister(provableEndpoint("keys", "The public keys of the users, with their names and info.",
+		func() int { return globals.EntityPageSizesObj.Keys },
+		func() int { return globals.EntityPageSizesObj.KeyIndexes }))
+	mustRegister(provableEndpoint("truststates", "The trust and the moderation given by the users to other users.",
+		func() int { return globals.EntityPageSizesObj.Truststates },
+		func() int { return globals.EntityPageSizesObj.TruststateIndexes }))
+	mustRegister(provableEndpoint("tombstones", "The deletions of the threads and the posts by their owners.",
+		func() int { return globals.EntityPageSizesObj.Tombstones },
+		func() int { return globals.EntityPageSizesObj.TombstoneIndexes }))
+	mustRegister(Endpoint{
+		Name:     "node",
+		Summary:  "The node response: the address, the protocol and the client of this node, and its policies.",
+		PageSize: func() int { return 1 },
+		Respond: func(filters FilterSet) (*api.ApiResponse, error) {
+			return GeneratePrefilledApiResponse(), nil
+		},
+	})
+	mustRegister(Endpoint{
+		Name:     "votesummaries",
+		Summary:  "The summaries of the compacted votes of the given entities.",
+		PageSize: func() int { return globals.EntityPageSizesObj.Votes },
+		// Summaries of the compacted votes. These are small, and they fit in a single page.
+		Respond: func(filters FilterSet) (*api.ApiResponse, error) {
+			summaries, err := compaction.ReadSummaries(filters.Fingerprints)
+			if err != nil {
+				return nil, err
+			}
+			r := GeneratePrefilledApiResponse()
+			r.ResponseBody.VoteSummaries = summaries
+			r.Endpoint = "votesummaries"
+			return r, nil
+		},
+	})
+	mustRegister(Endpoint{
+		Name:     "peers",
+		Summary:  "A sample of the addresses this node knows, leaving out the ones the requester gives as known.",
+		PageSize: func() int { return globals.PexSampleSize },
+		Respond: func(filters FilterSet) (*api.ApiResponse, error) {
+			peers, err := selectPeers(filters.KnownPeers)
+			if err != nil {
+				return nil, err
+			}
+			r := GeneratePrefilledApiResponse()
+			r.ResponseBody.Addresses = peers
+			r.Endpoint = "peers"
+			return r, nil
+		},
+	})
+	mustRegister(Endpoint{
+		Name:     "handshake",
+		Summary:  "The handshake of this node, in answer to the one of the requester: its node id, protocol, extensions, time and serving mode, signed.",
+		PageSize: func() int { return 1 },
+		Respond:  respondHandshake,
+	})
+	mustRegister(Endpoint{
+		Name:     "session",
+		Summary:  "The digests of the entities this node sent in its POST responses to the sync with the trace id of the request, by entity type.",
+		PageSize: func() int { return 1 },
+		Respond: func(filters FilterSet) (*api.ApiResponse, error) {
+			r := GeneratePrefilledApiResponse()
+			r.SessionDigests = sessionDigests(filters.TraceId)
+			r.Endpoint = "session"
+			return r, nil
+		},
+	})
+	mustRegister(Endpoint{
+		Name:     "witness",
+		Summary:  "The signatures of this node, as a witness, over the caches of the requester in the witness filters.",
+		PageSize: func() int { return witnessBatchSize },
+		Respond:  respondWitness,
+	})
+	mustRegister(Endpoint{
+		Name:     "fingerprints",
+		Summary:  "The fingerprints and the last updates of the entities that arrived in a time range, without their bodies, for the requester to see what it needs before downloading them.",
+		PageSize: func() int { return globals.FingerprintManifestMaxEntries },
+		Respond:  respondFingerprints,
+	})
+}
+
+func readAddresses(start api.Timestamp, end api.Timestamp) (api.Response, error) {
+	var data api.Response
+	addresses, err := persistence.ReadLiveAddresses(start, end)
+	data.Addresses = addresses
+	return data, err
+}
+
+// entityPageSize returns the page size of the given entity type.
+func entityPageSize(respType string) int {
+	if e, ok := LookupEndpoint(respType); ok {
+		return e.PageSize()
+	}
+	return 0
+}
+
+// indexPageSize returns the index page size of the given entity type, or 0 if it has no indexes.
+func indexPageSize(respType string) int {
+	if e, ok := LookupEndpoint(respType); ok && e.Indexed() {
+		return e.IndexPageSize()
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package responsegenerator_test
+
+import (
+	"aether-core/backend/responsegenerator"
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+}
+
+func teardown() {
+}
+
+// Tests
+
+func TestRegisterEndpoint_Success(t *testing.T) {
+	err := responsegenerator.RegisterEndpoint(responsegenerator.Endpoint{
+		Name:     "testgreetings",
+		PageSize: func() int { return 10 },
+		Respond: func(filters responsegenerator.FilterSet) (*api.ApiResponse, error) {
+			r := responsegenerator.GeneratePrefilledApiResponse()
+			r.Endpoint = "testgreetings"
+			return r, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("The endpoint could not be registered. Error: %s", err)
+	}
+	e, ok := responsegenerator.LookupEndpoint("testgreetings")
+	if !ok || e.Cached() {
+		t.Errorf("The endpoint should be registered, without caches.")
+	}
+	raw, err2 := responsegenerator.GeneratePOSTResponse("testgreetings", api.ApiResponse{})
+	if err2 != nil {
+		t.Fatalf("The registered endpoint could not respond. Error: %s", err2)
+	}
+	var resp api.ApiResponse
+	json.Unmarshal(raw, &resp)
+	if resp.Endpoint != "testgreetings" || resp.Entity != "testgreetings" {
+		t.Errorf("The response did not come from the registered endpoint. Endpoint: %s, Entity: %s", resp.Endpoint, resp.Entity)
+	}
+	for _, name := range responsegenerator.CacheEntityTypes() {
+		if name == "testgreetings" {
+			t.Errorf("An endpoint without caches should not be in the cache entity types.")
+		}
+	}
+}
+
+func TestRegisterEndpoint_Fail_Invalid(t *testing.T) {
+	invalid := []responsegenerator.Endpoint{
+		{Name: "boards", Provable: true, PageSize: func() int { return 10 }},
+		{Name: "", Provable: true, PageSize: func() int { return 10 }},
+		{Name: "testnopagesize", Provable: true},
+		{Name: "testnoreader", PageSize: func() int { return 10 }},
+		{Name: "testnocounter", PageSize: func() int { return 10 }, ReadPOST: func(f responsegenerator.FilterSet) (api.Response, error) { return api.Response{}, nil }, ReadRange: func(s api.Timestamp, e api.Timestamp) (api.Response, error) { return api.Response{}, nil }},
+	}
+	for _, e := range invalid {
+		if err := responsegenerator.RegisterEndpoint(e); err == nil {
+			t.Errorf("This endpoint should have been refused. Endpoint: %s", e.Name)
+		}
+	}
+	expected := []string{"boards", "threads", "posts", "votes", "addresses", "keys", "truststates", "tombstones"}
+	got := responsegenerator.CacheEntityTypes()
+	if len(got) != len(expected) {
+		t.Fatalf("Expected the cache entity types %v, got %v.", expected, got)
+	}
+	for i, _ := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Expected the cache entity types %v, got %v.", expected, got)
+			break
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Backend > ResponseGenerator > Fields
+// This file gives the responses to the requests with a fields filter only the fields of the entities the requester asked for. The entities are read with only the columns of those fields where the database can (see fields.go in persistence), and each page says which fields it has and is written with only those, whether it is sent in the response or saved as a page of a multipart response.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+)
+
+// selectFields makes the page have only the fields the requester asked for. No fields is all of them.
+func selectFields(page *api.ApiResponse, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	page.Fields = fields
+	page.ResponseBody.SelectFields(fields)
+}
@@ -0,0 +1,58 @@
+// This test is in the package itself rather than in responsegenerator_test, since the pages are given their fields by a function that is not exported, as the responses are baked.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSelectFields_Success(t *testing.T) {
+	globals.SetGlobals()
+	data := syntheticPosts(5)
+	page := (*convertResponsesToApiResponses(&[]api.Response{data}))[0]
+	selectFields(&page, api.NormaliseFields([]string{"Thread", "owner", "thread", " "}))
+	if err := api.BindResponse(&page, "nonce"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := EncodeResponse(&page, DestinationResponses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "Lorem ipsum") || strings.Contains(string(raw), `"parent"`) {
+		t.Errorf("The fields that were not asked for should not be sent. Response: %s", raw)
+	}
+	var parsed api.ApiResponse
+	if err2 := json.Unmarshal(raw, &parsed); err2 != nil {
+		t.Fatal(err2)
+	}
+	if len(parsed.ResponseBody.Posts) != 5 || parsed.ResponseBody.Posts[0].Thread != data.Posts[0].Thread || parsed.ResponseBody.Posts[0].Fingerprint != data.Posts[0].Fingerprint {
+		t.Errorf("The posts should have the fingerprints and the fields that were asked for. Posts: %#v", parsed.ResponseBody.Posts)
+	}
+	if len(parsed.Fields) != 3 || parsed.Fields[0] != "fingerprint" {
+		t.Errorf("The response should say which fields it has. Fields: %v", parsed.Fields)
+	}
+	if err3 := api.VerifyResponse(raw, &parsed, ""); err3 != nil {
+		t.Errorf("The signature should be over the response as it is sent. Error: %s", err3)
+	}
+}
+
+func TestSelectFields_Fail_NoFields(t *testing.T) {
+	globals.SetGlobals()
+	data := syntheticPosts(1)
+	if fields := api.NormaliseFields([]string{"", "fingerprint"}); fields != nil {
+		t.Errorf("A fields filter that asks for nothing but the fingerprint should give all of the fields. Fields: %v", fields)
+	}
+	page := (*convertResponsesToApiResponses(&[]api.Response{data}))[0]
+	selectFields(&page, nil)
+	raw, err := EncodeResponse(&page, DestinationResponses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), "Lorem ipsum") || strings.Contains(string(raw), `"fields"`) {
+		t.Errorf("A response without a fields filter should have all of the fields. Response: %s", raw)
+	}
+}
@@ -0,0 +1,58 @@
+// Backend > ResponseGenerator > Fingerprints
+// This file serves the manifests of the recent fingerprints: for the time range of the request, the fingerprint and the last update of every entity that arrived in it, read from the same index-only reads as the cache indexes, without the bodies. A remote can diff these against what it has, and ask for only what it needs, by its fingerprints. See fingerprints.go in the api package.
+// The manifests list what the node has, not what it serves: an entity that a remote would be refused, for its PoW or the sync policies, is listed, and not sent when it is asked for.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/globals"
+	"errors"
+	"fmt"
+)
+
+// fingerprintEntityTypes gives the entity types of the request, or the provable ones, if it gives none. The types that are not provable, and the same type given more than once, are refused, before anything is read.
+func fingerprintEntityTypes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		var all []string
+		for _, name := range endpointNames {
+			if endpoints[name].Provable {
+				all = append(all, name)
+			}
+		}
+		return all, nil
+	}
+	seen := make(map[string]bool)
+	for _, entityType := range requested {
+		if e, ok := LookupEndpoint(entityType); !ok || !e.Provable {
+			return nil, errors.New(fmt.Sprintf("Fingerprint manifests are only available for the entity types that are signed by their owners. Entity type: %s", entityType))
+		}
+		if seen[entityType] {
+			return nil, errors.New(fmt.Sprintf("This entity type is asked for more than once. Entity type: %s", entityType))
+		}
+		seen[entityType] = true
+	}
+	return requested, nil
+}
+
+func respondFingerprints(filters FilterSet) (*api.ApiResponse, error) {
+	if !globals.FingerprintManifestsEnabled {
+		return nil, errors.New("This node does not serve fingerprint manifests.")
+	}
+	entityTypes, err := fingerprintEntityTypes(filters.EntityTypes)
+	if err != nil {
+		return nil, err
+	}
+	r := GeneratePrefilledApiResponse()
+	r.Fingerprints = []api.FpManifest{}
+	for _, entityType := range entityTypes {
+		m, err2 := persistence.ReadFpManifest(entityType, filters.TimeStart, filters.TimeEnd, globals.FingerprintManifestMaxEntries)
+		if err2 != nil {
+			return nil, err2
+		}
+		r.Fingerprints = append(r.Fingerprints, m)
+	}
+	r.Endpoint = "fingerprints"
+	return r, nil
+}
@@ -0,0 +1,32 @@
+package responsegenerator_test
+
+import (
+	"aether-core/backend/responsegenerator"
+	"aether-core/services/globals"
+	"testing"
+)
+
+func fingerprintsEndpoint(t *testing.T) *responsegenerator.Endpoint {
+	e, ok := responsegenerator.LookupEndpoint("fingerprints")
+	if !ok {
+		t.Fatal("The fingerprints endpoint should be registered.")
+	}
+	return e
+}
+
+func TestFingerprints_Fail_Disabled(t *testing.T) {
+	globals.FingerprintManifestsEnabled = false
+	defer func() { globals.FingerprintManifestsEnabled = true }()
+	if _, err := fingerprintsEndpoint(t).Respond(responsegenerator.FilterSet{EntityTypes: []string{"posts"}}); err == nil {
+		t.Errorf("A node that doesn't serve the manifests should refuse the request.")
+	}
+}
+
+func TestFingerprints_Fail_EntityTypes(t *testing.T) {
+	globals.FingerprintManifestsEnabled = true
+	for _, types := range [][]string{{"addresses"}, {"node"}, {"unknown"}, {"posts", "posts"}} {
+		if _, err := fingerprintsEndpoint(t).Respond(responsegenerator.FilterSet{EntityTypes: types}); err == nil {
+			t.Errorf("The request should be refused before anything is read. Entity types: %v", types)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Backend > ResponseGenerator > GC
+// This file finds what is left in the statics directory that nothing points to anymore: the cache folders that are neither in their index nor retired, the POST responses whose links can't be active, and what a crash left in staging. The expiry of the responses and the sweep of the retired caches only know about what they made, so these would otherwise stay forever. An orphan is flagged when it is first found, and deleted only if it is still an orphan StaticsOrphanGrace later, so that something written while the scan runs is never taken for one.
+
+package responsegenerator
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// orphansFile keeps when each orphan was first found, in the statics directory, so that the grace survives a restart.
+const orphansFile = "orphans.json"
+
+// StaticsOrphan is something in the statics directory that nothing points to.
+type StaticsOrphan struct {
+	Path      string `json:"path"` // Such as "caches/posts/cache_x", "responses/x" or "staging/x".
+	Reason    string `json:"reason"`
+	Bytes     int64  `json:"bytes"`
+	FirstSeen int64  `json:"first_seen"`
+}
+
+// StaticsReport lists the orphans the scan of the statics directory found.
+type StaticsReport struct {
+	Generated    int64           `json:"generated"`
+	Flagged      []StaticsOrphan `json:"flagged"` // Still within their grace.
+	Removed      []StaticsOrphan `json:"removed"`
+	RemovedBytes int64           `json:"removed_bytes"`
+}
+
+// staticsLock makes sure that two scans don't flag and delete at the same time.
+var staticsLock sync.Mutex
+
+func staticsLocation() string {
+	return fmt.Sprint(globals.UserDirectory, "/statics")
+}
+
+func responsesLocation() string {
+	return fmt.Sprint(staticsLocation(), "/responses")
+}
+
+// orphanCandidate is an orphan found by the scan, with where it is on disk.
+type orphanCandidate struct {
+	path     string
+	disk     string
+	reason   string
+	respType string // Set for the cache folders.
+	name     string
+}
+
+func readOrphanFlags() map[string]int64 {
+	flags := make(map[string]int64)
+	data, err := ioutil.ReadFile(fmt.Sprint(staticsLocation(), "/", orphansFile))
+	if err != nil {
+		return flags
+	}
+	err2 := json.Unmarshal(data, &flags)
+	if err2 != nil {
+		// Without the list, the orphans are flagged again from now, which only delays their deletion.
+		logging.Log(1, fmt.Sprintf("The list of the orphans in the statics directory is corrupted, it will be started again. Error: %s", err2))
+		return make(map[string]int64)
+	}
+	return flags
+}
+
+func writeOrphanFlags(flags map[string]int64) error {
+	data, err := json.Marshal(flags)
+	if err != nil {
+		return err
+	}
+	createPath(staticsLocation())
+	tmp := fmt.Sprint(staticsLocation(), "/", orphansFile, ".tmp")
+	err2 := ioutil.WriteFile(tmp, data, 0755)
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The list of the orphans in the statics directory could not be written. Error: %s", err2))
+	}
+	return os.Rename(tmp, fmt.Sprint(staticsLocation(), "/", orphansFile))
+}
+
+// diskUsage gives the bytes of the files at the path, and under it if it is a folder.
+func diskUsage(path string) int64 {
+	var bytes int64
+	filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			bytes += info.Size()
+		}
+		return nil
+	})
+	return bytes
+}
+
+// listDir lists a folder. A folder that doesn't exist has nothing in it.
+func listDir(dir string) ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && os.IsNotExist(err) {
+		return entries, nil
+	}
+	return entries, err
+}
+
+// findCacheOrphans finds the cache folders of the entity type that are neither in the index nor retired, and the temporary files left by an index or a list of the retired caches that was not moved into place. The caller holds cacheLock, so no cache is being written while it looks.
+func findCacheOrphans(respType string) ([]orphanCandidate, error) {
+	var orphans []orphanCandidate
+	cacheIndex, err := readCacheIndex(respType)
+	if err != nil {
+		// Without the index, every folder would look like an orphan. The repair of the index is for this.
+		return orphans, err
+	}
+	referenced := retiredNames(respType)
+	for _, c := range cacheIndex.Results {
+		referenced[c.ResponseUrl] = true
+	}
+	entityCacheDir := fmt.Sprint(globals.CachesLocation, "/", respType)
+	entries, err2 := listDir(entityCacheDir)
+	if err2 != nil {
+		return orphans, err2
+	}
+	for _, e := range entries {
+		o := orphanCandidate{path: fmt.Sprint("caches/", respType, "/", e.Name()), disk: fmt.Sprint(entityCacheDir, "/", e.Name())}
+		switch {
+		case e.IsDir() && !referenced[e.Name()]:
+			o.reason = "The cache folder is not in the index, and is not retired."
+			o.respType, o.name = respType, e.Name()
+		case !e.IsDir() && strings.HasSuffix(e.Name(), ".tmp"):
+			o.reason = "The temporary file was not moved into place."
+		default:
+			continue
+		}
+		orphans = append(orphans, o)
+	}
+	return orphans, nil
+}
+
+// findResponseOrphans finds the POST responses whose links can't be active: the ones whose names are not those a response is published under, the ones without a first page, and the ones whose links expired. The expired ones are usually deleted by their expiry first; the name is what the links carry, so this finds the ones whose files were touched since.
+func findResponseOrphans() ([]orphanCandidate, error) {
+	var orphans []orphanCandidate
+	entries, err := listDir(responsesLocation())
+	if err != nil {
+		return orphans, err
+	}
+	now := clock.Unix()
+	for _, e := range entries {
+		o := orphanCandidate{path: fmt.Sprint("responses/", e.Name()), disk: fmt.Sprint(responsesLocation(), "/", e.Name())}
+		parts := strings.SplitN(e.Name(), "_", 2)
+		expiry, parseErr := strconv.ParseInt(parts[0], 10, 64)
+		_, statErr := os.Stat(fmt.Sprint(o.disk, "/0.json"))
+		switch {
+		case !e.IsDir() || len(parts) != 2 || parseErr != nil || len(parts[1]) == 0:
+			o.reason = "This is not a response a link could point to."
+		case statErr != nil:
+			o.reason = "The response has no first page."
+		case expiry < now:
+			o.reason = "The links to the response expired."
+		default:
+			continue
+		}
+		orphans = append(orphans, o)
+	}
+	return orphans, nil
+}
+
+// findStagingOrphans finds what is in staging. A response is there only while it is written, so what stays there past the grace was left by a crash.
+func findStagingOrphans() ([]orphanCandidate, error) {
+	var orphans []orphanCandidate
+	entries, err := listDir(stagingLocation())
+	if err != nil {
+		return orphans, err
+	}
+	for _, e := range entries {
+		orphans = append(orphans, orphanCandidate{path: fmt.Sprint("staging/", e.Name()), disk: fmt.Sprint(stagingLocation(), "/", e.Name()), reason: "The response was never published."})
+	}
+	return orphans, nil
+}
+
+// findStaticsOrphans finds all orphans in the statics directory. An entity type whose caches can't be looked at is skipped, so that the others are still collected.
+func findStaticsOrphans() ([]orphanCandidate, error) {
+	var orphans []orphanCandidate
+	for _, respType := range cacheEntityTypes {
+		cacheLock.Lock()
+		found, err := findCacheOrphans(respType)
+		cacheLock.Unlock()
+		if err != nil {
+			logging.Log(1, fmt.Sprintf("The caches of %s could not be checked for orphans. Error: %s", respType, err))
+			continue
+		}
+		orphans = append(orphans, found...)
+	}
+	found, err := findResponseOrphans()
+	if err != nil {
+		return orphans, err
+	}
+	orphans = append(orphans, found...)
+	found2, err2 := findStagingOrphans()
+	if err2 != nil {
+		return orphans, err2
+	}
+	return append(orphans, found2...), nil
+}
+
+// removeOrphan deletes an orphan. A cache folder is looked up in the index and the retired caches again under the lock first, since it could have been added to either since the scan.
+func removeOrphan(o orphanCandidate) error {
+	if len(o.respType) == 0 {
+		return os.RemoveAll(o.disk)
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	_, inIndex, err := findCacheLink(o.respType, o.name)
+	if err != nil {
+		return err
+	}
+	if inIndex || retiredNames(o.respType)[o.name] {
+		return errors.New(fmt.Sprintf("The cache folder is no longer an orphan. Path: %s", o.path))
+	}
+	return os.RemoveAll(o.disk)
+}
+
+// InspectStatics reports the orphans in the statics directory, and which of them the next collection would delete, without flagging or deleting anything.
+func InspectStatics() (StaticsReport, error) {
+	return collectStatics(false)
+}
+
+// CollectStatics flags the orphans in the statics directory that are new, and deletes the ones that were flagged more than StaticsOrphanGrace ago and are still orphans.
+func CollectStatics() (StaticsReport, error) {
+	return collectStatics(true)
+}
+
+func collectStatics(apply bool) (StaticsReport, error) {
+	staticsLock.Lock()
+	defer staticsLock.Unlock()
+	report := StaticsReport{Generated: clock.Unix()}
+	orphans, err := findStaticsOrphans()
+	if err != nil {
+		return report, err
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].path < orphans[j].path })
+	flags := readOrphanFlags()
+	// What is no longer an orphan loses its flag, so that it gets the whole grace again if it becomes one later.
+	current := make(map[string]int64)
+	for _, o := range orphans {
+		firstSeen, flagged := flags[o.path]
+		if !flagged {
+			firstSeen = report.Generated
+		}
+		so := StaticsOrphan{Path: o.path, Reason: o.reason, Bytes: diskUsage(o.disk), FirstSeen: firstSeen}
+		if clock.Since(time.Unix(firstSeen, 0)) < globals.StaticsOrphanGrace {
+			current[o.path] = firstSeen
+			report.Flagged = append(report.Flagged, so)
+			continue
+		}
+		if apply {
+			err2 := removeOrphan(o)
+			if err2 != nil {
+				logging.Log(1, fmt.Sprintf("An orphan in the statics directory could not be deleted. Path: %s, Error: %s", o.path, err2))
+				current[o.path] = firstSeen
+				continue
+			}
+		}
+		report.Removed = append(report.Removed, so)
+		report.RemovedBytes += so.Bytes
+	}
+	if !apply {
+		return report, nil
+	}
+	err3 := writeOrphanFlags(current)
+	if err3 != nil {
+		return report, err3
+	}
+	for _, o := range report.Removed {
+		logging.Log(1, fmt.Sprintf("Deleted an orphan from the statics directory. Path: %s, Reason: %s, Bytes: %d", o.Path, o.Reason, o.Bytes))
+	}
+	if len(report.Removed) > 0 || len(report.Flagged) > 0 {
+		logging.Log(1, fmt.Sprintf("The statics directory is collected. Flagged: %d, Removed: %d, Freed bytes: %d", len(report.Flagged), len(report.Removed), report.RemovedBytes))
+	}
+	return report, nil
+}
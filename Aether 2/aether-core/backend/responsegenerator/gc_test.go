@@ -0,0 +1,118 @@
+// This test is in the package itself rather than in responsegenerator_test, since it builds the caches on disk with the same functions as the tests of the repair, which are not exported.
+
+package responsegenerator
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// saveStaticsTestTree builds a statics directory with a cache in its index, a valid response, and an orphan of every kind.
+func saveStaticsTestTree(t *testing.T) string {
+	cacheName := saveRepairTestCache(t)
+	dir, err := ioutil.TempDir("", "aether-statics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals.UserDirectory = dir
+	paths := []string{
+		filepath.Join(globals.CachesLocation, "posts", "cache_orphan", "0.json"),
+		filepath.Join(globals.CachesLocation, "posts", "index.json.tmp"),
+		filepath.Join(dir, "statics", "responses", "4000000000_abc", "0.json"),
+		filepath.Join(dir, "statics", "responses", "garbage", "0.json"),
+		filepath.Join(dir, "statics", "responses", "1500000000_old", "0.json"),
+		filepath.Join(dir, "statics", "staging", "1600000600_crashed", "0.json"),
+	}
+	for _, p := range paths {
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err2 := ioutil.WriteFile(p, []byte("{}"), 0644); err2 != nil {
+			t.Fatal(err2)
+		}
+	}
+	return cacheName
+}
+
+func orphanPaths(orphans []StaticsOrphan) map[string]bool {
+	paths := make(map[string]bool)
+	for _, o := range orphans {
+		paths[o.Path] = true
+	}
+	return paths
+}
+
+func TestCollectStatics_Success(t *testing.T) {
+	cacheName := saveStaticsTestTree(t)
+	defer os.RemoveAll(globals.CachesLocation)
+	defer os.RemoveAll(globals.UserDirectory)
+	mock := clock.NewMockClock(time.Unix(1600000000, 0))
+	clock.Set(mock)
+	defer clock.Reset()
+	expected := []string{"caches/posts/cache_orphan", "caches/posts/index.json.tmp", "responses/garbage", "responses/1500000000_old", "staging/1600000600_crashed"}
+	report, err := CollectStatics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flagged := orphanPaths(report.Flagged)
+	if len(report.Removed) != 0 || len(flagged) != len(expected) {
+		t.Fatalf("The orphans should be flagged, and nothing deleted yet. Flagged: %v, Removed: %v", report.Flagged, report.Removed)
+	}
+	for _, p := range expected {
+		if !flagged[p] {
+			t.Errorf("The orphan should be flagged. Path: %s", p)
+		}
+	}
+	mock.Advance(globals.StaticsOrphanGrace + time.Minute)
+	inspected, err2 := InspectStatics()
+	if err2 != nil || len(inspected.Removed) != len(expected) {
+		t.Errorf("The inspection should list the orphans past their grace as the ones the collection deletes. Report: %v, Error: %v", inspected, err2)
+	}
+	report2, err3 := CollectStatics()
+	if err3 != nil {
+		t.Fatal(err3)
+	}
+	if len(report2.Removed) != len(expected) || len(report2.Flagged) != 0 || report2.RemovedBytes == 0 {
+		t.Errorf("The orphans past their grace should be deleted. Report: %v", report2)
+	}
+	for _, p := range []string{filepath.Join(globals.CachesLocation, "posts", "cache_orphan"), filepath.Join(globals.UserDirectory, "statics", "staging", "1600000600_crashed")} {
+		if _, err4 := os.Stat(p); !os.IsNotExist(err4) {
+			t.Errorf("The orphan should be gone. Path: %s", p)
+		}
+	}
+	for _, p := range []string{filepath.Join(globals.CachesLocation, "posts", cacheName, "0.json"), filepath.Join(globals.UserDirectory, "statics", "responses", "4000000000_abc", "0.json")} {
+		if _, err5 := os.Stat(p); err5 != nil {
+			t.Errorf("What is still pointed to should be kept. Path: %s, Error: %s", p, err5)
+		}
+	}
+}
+
+func TestCollectStatics_Fail_NoLongerOrphan(t *testing.T) {
+	saveStaticsTestTree(t)
+	defer os.RemoveAll(globals.CachesLocation)
+	defer os.RemoveAll(globals.UserDirectory)
+	mock := clock.NewMockClock(time.Unix(1600000000, 0))
+	clock.Set(mock)
+	defer clock.Reset()
+	if _, err := CollectStatics(); err != nil {
+		t.Fatal(err)
+	}
+	// The flagged cache folder is retired within its grace, as if a regeneration had only just written the list of the retired caches.
+	cacheLock.Lock()
+	retireCaches("posts", []string{"cache_orphan"})
+	cacheLock.Unlock()
+	mock.Advance(globals.StaticsOrphanGrace + time.Minute)
+	report, err2 := CollectStatics()
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	if orphanPaths(report.Removed)["caches/posts/cache_orphan"] {
+		t.Errorf("A cache folder that is retired should not be deleted as an orphan.")
+	}
+	if _, err3 := os.Stat(filepath.Join(globals.CachesLocation, "posts", "cache_orphan")); err3 != nil {
+		t.Errorf("The retired cache folder should still be there. Error: %s", err3)
+	}
+}
@@ -0,0 +1,129 @@
+// Backend > ResponseGenerator > Generation Progress
+// This file keeps track of a cache generation run while it goes on, so that a run over a long time range, which can take hours on a big node, can be watched and cancelled. The run reports how many entities it has read into its caches, and how many pages it has written, after every page; it is checked for a cancel between the entity types, and between the pages of a cache.
+// A run that is cancelled leaves nothing behind: the caches of a run are all saved before any of them is added to its index, and a cancelled run removes the ones it saved, and doesn't move the last cache generation timestamp, so the next run makes the caches of the same time range again.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// errGenerationCancelled is given by the parts of a cache generation run that stopped because it was cancelled.
+var errGenerationCancelled = errors.New("The cache generation was cancelled.")
+
+// GenerationProgress is how far a cache generation run has got.
+type GenerationProgress struct {
+	EntityType   string `json:"entity_type"`   // The entity type whose cache is being made.
+	CachesDone   int    `json:"caches_done"`   // The caches saved, out of CachesTotal. They are added to their indexes together, after the last one.
+	CachesTotal  int    `json:"caches_total"`  // The caches the run makes, one per entity type.
+	EntitiesRead int64  `json:"entities_read"` // The entities read into the caches so far.
+	PagesWritten int64  `json:"pages_written"` // The pages written so far, the entity pages and the index pages.
+}
+
+// generationRun is a cache generation run that is watched, and can be cancelled. A nil run, as the admin commands and the repairs make their caches with, can't be cancelled and reports nowhere.
+type generationRun struct {
+	cancel   <-chan struct{}
+	report   func(GenerationProgress)
+	lock     sync.Mutex
+	progress GenerationProgress
+}
+
+// cancelled tells whether the run has been asked to stop.
+func (r *generationRun) cancelled() bool {
+	if r == nil || r.cancel == nil {
+		return false
+	}
+	select {
+	case <-r.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// update changes the progress of the run, and reports it. The report is made under the lock, so that the reports of the workers that write the pages arrive in order.
+func (r *generationRun) update(change func(p *GenerationProgress)) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	change(&r.progress)
+	if r.report != nil {
+		r.report(r.progress)
+	}
+}
+
+// bakedCache is a cache a run has saved, which is not in its index yet.
+type bakedCache struct {
+	respType  string
+	cacheData CacheResponse
+}
+
+// discardCaches removes the caches a run saved, but won't add to their indexes, since it was cancelled, or found the disk full. Nothing points to them yet, so they can be removed as they are.
+func discardCaches(baked []bakedCache) {
+	for i, _ := range baked {
+		cacheDir := fmt.Sprint(globals.CachesLocation, "/", baked[i].respType, "/", baked[i].cacheData.cacheName)
+		err := os.RemoveAll(cacheDir)
+		if err != nil {
+			logging.Log(1, fmt.Sprintf("A cache of a cache generation run that stopped could not be removed. Path: %s, Error: %s", cacheDir, err))
+		}
+	}
+}
+
+// generateCaches makes the caches of every cached entity type for the time range, and adds them to their indexes once all of them are saved. If the run is cancelled before that, or finds the disk full, the caches it saved are removed, and the indexes are left as they were. The caller holds the cache lock.
+func generateCaches(run *generationRun, start api.Timestamp, end api.Timestamp) error {
+	baked, err := bakeCaches(run, start, end)
+	if err != nil {
+		return err
+	}
+	for i, _ := range baked {
+		err2 := addCacheLink(baked[i].respType, &baked[i].cacheData)
+		if err2 != nil {
+			logging.Log(1, err2)
+		}
+	}
+	return nil
+}
+
+// bakeCaches saves the caches of every cached entity type for the time range, without adding them to their indexes. An entity type whose cache fails is skipped. If the run is cancelled, or finds the disk full, the caches it saved are removed, and it gives an error. The caller holds the cache lock.
+func bakeCaches(run *generationRun, start api.Timestamp, end api.Timestamp) ([]bakedCache, error) {
+	run.update(func(p *GenerationProgress) { p.CachesTotal = len(cacheEntityTypes) })
+	var baked []bakedCache
+	for _, respType := range cacheEntityTypes {
+		if run.cancelled() {
+			discardCaches(baked)
+			return nil, errGenerationCancelled
+		}
+		run.update(func(p *GenerationProgress) { p.EntityType = respType })
+		cacheData, err := bakeRunCache(run, respType, start, end, "")
+		if err != nil && run.cancelled() {
+			discardCaches(baked)
+			return nil, errGenerationCancelled
+		}
+		if err != nil && diskFullPaused() {
+			// The caches of the run are made again as a whole once there is space.
+			discardCaches(baked)
+			return nil, err
+		}
+		if err != nil {
+			// As before, an entity type whose cache fails doesn't hold up the others.
+			logging.Log(1, err)
+			continue
+		}
+		baked = append(baked, bakedCache{respType, cacheData})
+		run.update(func(p *GenerationProgress) { p.CachesDone++ })
+	}
+	return baked, nil
+}
+
+// GenerateCachesWatched is GenerateCaches for a run that can be cancelled by closing the cancel channel, and that gives its progress to the report function as it goes, such as the cache generation job. It gives an error if the run was cancelled, or the cache writes are paused for a full disk, in which case it leaves the caches and their indexes as they were.
+func GenerateCachesWatched(cancel <-chan struct{}, report func(GenerationProgress)) error {
+	return generateDueCaches(&generationRun{cancel: cancel, report: report})
+}
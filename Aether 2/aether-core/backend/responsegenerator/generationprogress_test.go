@@ -0,0 +1,72 @@
+// This test is in the package itself rather than in responsegenerator_test, since the runs of the cache generation are not exported.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerationRun_Success(t *testing.T) {
+	globals.SetGlobals()
+	globals.SignResponses = false
+	dir, err := ioutil.TempDir("", "aether-generationrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := syntheticPosts(500)
+	pages := splitEntitiesToPages(&data)
+	cacheData, err2 := buildCacheResponse(pages, createIndexes(pages), 1500000000, 1500086400)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	var reports []GenerationProgress
+	cacheData.run = &generationRun{cancel: make(chan struct{}), report: func(p GenerationProgress) {
+		reports = append(reports, p)
+	}}
+	err3 := saveCacheToDisk(dir, &cacheData, "posts")
+	if err3 != nil {
+		t.Fatal(err3)
+	}
+	written := int64(len(*pages) + len(*cacheData.indexPages))
+	if len(reports) != int(written) || reports[len(reports)-1].PagesWritten != written {
+		t.Errorf("Every page written should have been reported. Reports: %d, Pages: %d", len(reports), written)
+	}
+}
+
+func TestGenerationRun_Fail_Cancelled(t *testing.T) {
+	globals.SetGlobals()
+	globals.SignResponses = false
+	dir, err := ioutil.TempDir("", "aether-generationrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	globals.CachesLocation = dir
+	data := syntheticPosts(500)
+	pages := splitEntitiesToPages(&data)
+	cacheData, err2 := buildCacheResponse(pages, createIndexes(pages), 1500000000, 1500086400)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	cancel := make(chan struct{})
+	close(cancel)
+	cacheData.run = &generationRun{cancel: cancel}
+	entityDir := filepath.Join(dir, "posts")
+	createPath(entityDir)
+	if err3 := saveCacheToDisk(entityDir, &cacheData, "posts"); err3 != errGenerationCancelled {
+		t.Errorf("A cancelled run should stop saving the cache. Error: %v", err3)
+	}
+	if _, err4 := os.Stat(filepath.Join(entityDir, cacheData.cacheName, api.ManifestFile)); err4 == nil {
+		t.Errorf("The manifest of a cache that was cancelled should not have been written.")
+	}
+	discardCaches([]bakedCache{{"posts", cacheData}})
+	if _, err5 := os.Stat(filepath.Join(entityDir, cacheData.cacheName)); !os.IsNotExist(err5) {
+		t.Errorf("The folder of a cancelled cache should have been removed. Error: %v", err5)
+	}
+}
@@ -0,0 +1,68 @@
+// Backend > ResponseGenerator > Handshake
+// This file answers the handshakes of the remotes, and makes the handshake of this node for the syncs it starts. The handshake of a remote is kept as its inbound connection, so that what this node does for its requests afterwards goes by what it announced, rather than by guesses made from each request. See io/api/handshake.go.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+)
+
+// LocalHandshake makes the handshake of this node, with the extensions it announces in its node response.
+func LocalHandshake(nonce string) (api.Handshake, error) {
+	return api.NewHandshake(GeneratePrefilledApiResponse().Address.Protocol.Extensions, nonce)
+}
+
+// respondHandshake answers the handshake of the requester with the one of this node, which signs back its nonce. The handshake of the requester is kept if it is the requester's own; a program that is not a node doesn't sign its handshake, and it is kept as such.
+func respondHandshake(filters FilterSet) (*api.ApiResponse, error) {
+	if !globals.HandshakeEnabled {
+		return nil, errors.New("This node does not take handshakes.")
+	}
+	if filters.Handshake == nil {
+		return nil, errors.New("The request has no handshake.")
+	}
+	remote := *filters.Handshake
+	if remote.NodeId != filters.Requester {
+		return nil, errors.New(fmt.Sprintf("The handshake is not of the node that sent it. Handshake: %s, Requester: %s", remote.NodeId, filters.Requester))
+	}
+	verified := false
+	if len(remote.Signature) > 0 {
+		err := api.VerifyHandshake(remote, "", remote.Nonce)
+		if err != nil {
+			return nil, err
+		}
+		verified = true
+	}
+	local, err2 := LocalHandshake(remote.Nonce)
+	if err2 != nil {
+		return nil, errors.New(fmt.Sprintf("The handshake of this node could not be signed. Error: %s", err2))
+	}
+	if len(remote.NodeId) > 0 {
+		c := api.NewConnection(local, remote, clock.Now(), verified)
+		if err3 := c.CheckClock(); err3 != nil {
+			// The remote decides whether to sync with this node; the offset is only logged here.
+			logging.LogTrace(filters.TraceId, 1, err3.Error())
+		}
+		api.SetInboundConnection(remote.NodeId, c)
+	}
+	r := GeneratePrefilledApiResponse()
+	r.Handshake = &local
+	r.Endpoint = "handshake"
+	return r, nil
+}
+
+// requesterHas checks whether the requester supports the extension. A requester that made a handshake supports what both sides announced in it. One that didn't is taken to support it, as before the handshake.
+func requesterHas(filters FilterSet, extension string) bool {
+	if len(filters.Requester) == 0 {
+		return true
+	}
+	c, ok := api.InboundConnection(filters.Requester)
+	if !ok {
+		return true
+	}
+	return c.Has(extension)
+}
@@ -0,0 +1,76 @@
+package responsegenerator_test
+
+import (
+	"aether-core/backend/responsegenerator"
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"strings"
+	"testing"
+	"time"
+)
+
+// handshakeWith sends the handshake to this node, as a remote would.
+func handshakeWith(t *testing.T, h api.Handshake) (*api.ApiResponse, error) {
+	e, ok := responsegenerator.LookupEndpoint("handshake")
+	if !ok {
+		t.Fatal("The handshake endpoint should be registered.")
+	}
+	return e.Respond(responsegenerator.FilterSet{Requester: h.NodeId, Handshake: &h})
+}
+
+func TestHandshake_Success(t *testing.T) {
+	globals.NodeId = strings.Repeat("a", 64)
+	defer func() { globals.NodeId = "" }()
+	nonce := api.NewNonce()
+	local, err := responsegenerator.LocalHandshake(nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sentAt := clock.Now()
+	r, err2 := handshakeWith(t, local)
+	if err2 != nil {
+		t.Fatalf("The handshake should have been answered. Error: %s", err2)
+	}
+	if r.Handshake == nil {
+		t.Fatal("The response should have the handshake of the node.")
+	}
+	if err3 := api.VerifyHandshake(*r.Handshake, globals.MarshaledPubKey, nonce); err3 != nil {
+		t.Errorf("The handshake of the node should be signed with its key, over the nonce sent. Error: %s", err3)
+	}
+	c := api.NewConnection(local, *r.Handshake, sentAt, true)
+	if !c.Has(api.HandshakeExtension) || !c.Has("cursor") || c.CheckClock() != nil {
+		t.Errorf("The connection should have the extensions both sides announced, and the clocks should agree. Connection: %#v", c)
+	}
+	inbound, ok := api.InboundConnection(local.NodeId)
+	if !ok || !inbound.Verified || inbound.Remote.Nonce != nonce {
+		t.Errorf("The node should have kept the handshake of the requester. Connection: %#v", inbound)
+	}
+}
+
+func TestHandshake_Fail(t *testing.T) {
+	globals.NodeId = strings.Repeat("b", 64)
+	defer func() { globals.NodeId = "" }()
+	nonce := api.NewNonce()
+	local, _ := responsegenerator.LocalHandshake(nonce)
+	// A handshake changed after it was signed.
+	changed := local
+	changed.Extensions = append([]string{"extra"}, local.Extensions...)
+	if _, err := handshakeWith(t, changed); err == nil {
+		t.Errorf("A handshake that was changed after it was signed should be refused.")
+	}
+	r, err2 := handshakeWith(t, local)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	// A handshake of another sync.
+	if err3 := api.VerifyHandshake(*r.Handshake, globals.MarshaledPubKey, api.NewNonce()); err3 == nil {
+		t.Errorf("A handshake that answers another nonce should be refused.")
+	}
+	// A remote whose clock is an hour ahead.
+	ahead := *r.Handshake
+	ahead.Time += api.Timestamp(time.Hour / time.Second)
+	if err4 := api.NewConnection(local, ahead, clock.Now(), true).CheckClock(); err4 == nil {
+		t.Errorf("A remote whose clock is further off than allowed should be refused.")
+	}
+}
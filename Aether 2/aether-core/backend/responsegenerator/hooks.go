@@ -0,0 +1,264 @@
+// Backend > ResponseGenerator > Hooks
+// This file lets the operator transform what the node serves, such as redacting the email addresses in the bodies of the posts, or marking the entities imported by a bridge. A hook is registered with a function for one or both of two stages: before the entities are split into pages, where it sees all of them at once and can drop some, and before a page is signed and written, where it sees the page as it will go out. The hooks run in the order they were registered, on the POST responses and on the caches alike.
+// A hook can't take the node down with it. It is given a copy of the lists of the entities, and what it changed is kept only if it returns without an error, within the hook timeout, and without panicking. Otherwise its changes are thrown away and the response goes out without them, unless the hook is required, in which case the response isn't served at all; a redaction that failed should not let through what it was to redact. A hook that fails too many times in a row is disabled until the operator enables it again.
+// The entities are signed by their authors, so a hook that changes what they signed makes them fail verification at the remotes. That is for the operator to weigh; the hooks are meant for the nodes that serve the readers rather than the other nodes, such as the ones in front of a frontend or a bridge.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// StagePrePaginate is the stage of the hooks that see the entities of a response before they are split into pages.
+	StagePrePaginate = "pre-paginate"
+	// StagePreSerialize is the stage of the hooks that see a page before it is signed and written.
+	StagePreSerialize = "pre-serialize"
+)
+
+// HookContext tells a hook what it is transforming.
+type HookContext struct {
+	Stage       string
+	Entity      string // The entity type of the response, such as "posts".
+	Destination string // DestinationResponses or DestinationCaches.
+}
+
+// Hook is a transform of what the node serves. Only the lists of the entities are copied for the hook, so one that changes what the entities point to, such as the owners of a board, should replace it rather than change it in place.
+type Hook struct {
+	Name         string
+	Required     bool // If the hook fails, the response is not served, rather than served without the hook.
+	PrePaginate  func(ctx HookContext, data *api.Response) error
+	PreSerialize func(ctx HookContext, page *api.ApiResponse) error
+}
+
+// HookStatus is how a hook has been doing.
+type HookStatus struct {
+	Name          string   `json:"name"`
+	Stages        []string `json:"stages"`
+	Required      bool     `json:"required"`
+	Enabled       bool     `json:"enabled"`
+	Runs          int64    `json:"runs"`
+	Failures      int64    `json:"failures"`
+	FailureStreak int      `json:"failure_streak"`
+	LastError     string   `json:"last_error,omitempty"`
+}
+
+type registeredHook struct {
+	hook   Hook
+	status HookStatus
+}
+
+var hooksLock sync.Mutex
+var hooks []*registeredHook
+
+// RegisterHook adds a hook after the ones already registered.
+func RegisterHook(h Hook) error {
+	if len(h.Name) == 0 || (h.PrePaginate == nil && h.PreSerialize == nil) {
+		return errors.New(fmt.Sprintf("A hook needs a name and a function for at least one stage. Name: %s", h.Name))
+	}
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+	for i, _ := range hooks {
+		if hooks[i].hook.Name == h.Name {
+			return errors.New(fmt.Sprintf("A hook with this name is already registered. Name: %s", h.Name))
+		}
+	}
+	rh := &registeredHook{hook: h, status: HookStatus{Name: h.Name, Required: h.Required, Enabled: true}}
+	if h.PrePaginate != nil {
+		rh.status.Stages = append(rh.status.Stages, StagePrePaginate)
+	}
+	if h.PreSerialize != nil {
+		rh.status.Stages = append(rh.status.Stages, StagePreSerialize)
+	}
+	hooks = append(hooks, rh)
+	return nil
+}
+
+// UnregisterHook removes a hook.
+func UnregisterHook(name string) error {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+	for i, _ := range hooks {
+		if hooks[i].hook.Name == name {
+			hooks = append(hooks[:i], hooks[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("There is no hook with this name. Name: %s", name))
+}
+
+// SetHookEnabled enables or disables a hook. Enabling it clears its failure streak.
+func SetHookEnabled(name string, enabled bool) error {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+	for i, _ := range hooks {
+		if hooks[i].hook.Name == name {
+			hooks[i].status.Enabled = enabled
+			if enabled {
+				hooks[i].status.FailureStreak = 0
+			}
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("There is no hook with this name. Name: %s", name))
+}
+
+// Hooks gives the statuses of the registered hooks, in the order they run.
+func Hooks() []HookStatus {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+	statuses := []HookStatus{}
+	for i, _ := range hooks {
+		statuses = append(statuses, hooks[i].status)
+	}
+	return statuses
+}
+
+// hooksOf gives the hooks that have a function for the stage, in order.
+func hooksOf(stage string) []*registeredHook {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+	var staged []*registeredHook
+	for i, _ := range hooks {
+		if (stage == StagePrePaginate && hooks[i].hook.PrePaginate != nil) || (stage == StagePreSerialize && hooks[i].hook.PreSerialize != nil) {
+			staged = append(staged, hooks[i])
+		}
+	}
+	return staged
+}
+
+// sandboxed runs the function of a hook, and gives its error, or an error if it panics or doesn't return within the hook timeout. A hook that runs over the timeout is left to finish on its own, on the copy that is thrown away.
+func sandboxed(run func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- errors.New(fmt.Sprintf("The hook panicked. Panic: %v", r))
+			}
+		}()
+		done <- run()
+	}()
+	timer := time.NewTimer(globals.ResponseHookTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errors.New(fmt.Sprintf("The hook did not return in time. Timeout: %s", globals.ResponseHookTimeout))
+	}
+}
+
+// runHook runs a hook, if it is enabled, and counts how it did. It gives whether its changes should be kept, and an error if the response should not be served.
+func runHook(rh *registeredHook, ctx HookContext, run func() error) (bool, error) {
+	hooksLock.Lock()
+	enabled := rh.status.Enabled
+	hooksLock.Unlock()
+	if !enabled {
+		if rh.hook.Required {
+			return false, errors.New(fmt.Sprintf("A required hook is disabled, so the response is not served. Hook: %s, Stage: %s, Entity: %s", rh.hook.Name, ctx.Stage, ctx.Entity))
+		}
+		return false, nil
+	}
+	err := sandboxed(run)
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+	rh.status.Runs++
+	if err == nil {
+		rh.status.FailureStreak = 0
+		return true, nil
+	}
+	rh.status.Failures++
+	rh.status.FailureStreak++
+	rh.status.LastError = err.Error()
+	logging.LogSampled("responsegenerator", fmt.Sprint("hook-", rh.hook.Name), 1, fmt.Sprintf("A response hook failed, and its changes are thrown away. Hook: %s, Stage: %s, Entity: %s, Error: %s", rh.hook.Name, ctx.Stage, ctx.Entity, err))
+	if globals.ResponseHookMaxFailures > 0 && rh.status.FailureStreak >= globals.ResponseHookMaxFailures && rh.status.Enabled {
+		rh.status.Enabled = false
+		logging.Log(1, fmt.Sprintf("A response hook failed too many times in a row, and it is disabled. Hook: %s, Failures: %d", rh.hook.Name, rh.status.FailureStreak))
+	}
+	if rh.hook.Required {
+		return false, errors.New(fmt.Sprintf("A required hook failed, so the response is not served. Hook: %s, Error: %s", rh.hook.Name, err))
+	}
+	return false, nil
+}
+
+// runPrePaginateHooks runs the pre-paginate hooks over the entities of a response.
+func runPrePaginateHooks(entity string, destination string, data *api.Response) error {
+	ctx := HookContext{Stage: StagePrePaginate, Entity: entity, Destination: destination}
+	for _, rh := range hooksOf(StagePrePaginate) {
+		c := cloneResponse(*data)
+		keep, err := runHook(rh, ctx, func() error { return rh.hook.PrePaginate(ctx, &c) })
+		if err != nil {
+			return err
+		}
+		if keep {
+			*data = c
+		}
+	}
+	return nil
+}
+
+// runPreSerializeHooks runs the pre-serialize hooks over a page.
+func runPreSerializeHooks(destination string, page *api.ApiResponse) error {
+	ctx := HookContext{Stage: StagePreSerialize, Entity: page.Entity, Destination: destination}
+	for _, rh := range hooksOf(StagePreSerialize) {
+		c := *page
+		c.ResponseBody = cloneAnswer(page.ResponseBody)
+		keep, err := runHook(rh, ctx, func() error { return rh.hook.PreSerialize(ctx, &c) })
+		if err != nil {
+			return err
+		}
+		if keep {
+			*page = c
+		}
+	}
+	return nil
+}
+
+// cloneResponse copies the lists of the entities of a response.
+func cloneResponse(r api.Response) api.Response {
+	r.Boards = append([]api.Board(nil), r.Boards...)
+	r.BoardIndexes = append([]api.BoardIndex(nil), r.BoardIndexes...)
+	r.Threads = append([]api.Thread(nil), r.Threads...)
+	r.ThreadIndexes = append([]api.ThreadIndex(nil), r.ThreadIndexes...)
+	r.Posts = append([]api.Post(nil), r.Posts...)
+	r.PostIndexes = append([]api.PostIndex(nil), r.PostIndexes...)
+	r.Votes = append([]api.Vote(nil), r.Votes...)
+	r.VoteIndexes = append([]api.VoteIndex(nil), r.VoteIndexes...)
+	r.Keys = append([]api.Key(nil), r.Keys...)
+	r.KeyIndexes = append([]api.KeyIndex(nil), r.KeyIndexes...)
+	r.Addresses = append([]api.Address(nil), r.Addresses...)
+	r.AddressIndexes = append([]api.AddressIndex(nil), r.AddressIndexes...)
+	r.Truststates = append([]api.Truststate(nil), r.Truststates...)
+	r.TruststateIndexes = append([]api.TruststateIndex(nil), r.TruststateIndexes...)
+	r.Tombstones = append([]api.Tombstone(nil), r.Tombstones...)
+	r.TombstoneIndexes = append([]api.TombstoneIndex(nil), r.TombstoneIndexes...)
+	return r
+}
+
+// cloneAnswer copies the lists of the entities of an answer.
+func cloneAnswer(a api.Answer) api.Answer {
+	a.Boards = append([]api.Board(nil), a.Boards...)
+	a.BoardIndexes = append([]api.BoardIndex(nil), a.BoardIndexes...)
+	a.Threads = append([]api.Thread(nil), a.Threads...)
+	a.ThreadIndexes = append([]api.ThreadIndex(nil), a.ThreadIndexes...)
+	a.Posts = append([]api.Post(nil), a.Posts...)
+	a.PostIndexes = append([]api.PostIndex(nil), a.PostIndexes...)
+	a.Votes = append([]api.Vote(nil), a.Votes...)
+	a.VoteIndexes = append([]api.VoteIndex(nil), a.VoteIndexes...)
+	a.Keys = append([]api.Key(nil), a.Keys...)
+	a.KeyIndexes = append([]api.KeyIndex(nil), a.KeyIndexes...)
+	a.Addresses = append([]api.Address(nil), a.Addresses...)
+	a.AddressIndexes = append([]api.AddressIndex(nil), a.AddressIndexes...)
+	a.Truststates = append([]api.Truststate(nil), a.Truststates...)
+	a.TruststateIndexes = append([]api.TruststateIndex(nil), a.TruststateIndexes...)
+	a.Tombstones = append([]api.Tombstone(nil), a.Tombstones...)
+	a.TombstoneIndexes = append([]api.TombstoneIndex(nil), a.TombstoneIndexes...)
+	a.VoteSummaries = append([]api.VoteSummary(nil), a.VoteSummaries...)
+	return a
+}
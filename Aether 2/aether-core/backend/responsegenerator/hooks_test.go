@@ -0,0 +1,91 @@
+// This test is in the package itself rather than in responsegenerator_test, since the hooks are run by functions that are not exported, as the responses are baked.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunHooks_Success(t *testing.T) {
+	globals.SetGlobals()
+	err := RegisterHook(Hook{
+		Name: "redact",
+		PrePaginate: func(ctx HookContext, data *api.Response) error {
+			for i, _ := range data.Posts {
+				data.Posts[i].Body = strings.Replace(data.Posts[i].Body, "Lorem", "[redacted]", -1)
+			}
+			return nil
+		},
+		PreSerialize: func(ctx HookContext, page *api.ApiResponse) error {
+			page.ResponseBody.Posts = page.ResponseBody.Posts[:1]
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterHook("redact")
+	if RegisterHook(Hook{Name: "redact", PrePaginate: func(HookContext, *api.Response) error { return nil }}) == nil {
+		t.Errorf("Two hooks should not be registered with the same name.")
+	}
+	original := syntheticPosts(3)
+	data := original
+	if err2 := runPrePaginateHooks("posts", DestinationResponses, &data); err2 != nil {
+		t.Fatal(err2)
+	}
+	if !strings.HasPrefix(data.Posts[0].Body, "[redacted]") || !strings.HasPrefix(original.Posts[0].Body, "Lorem") {
+		t.Errorf("The hook should change a copy of the entities. Body: %s, Original: %s", data.Posts[0].Body, original.Posts[0].Body)
+	}
+	page := (*convertResponsesToApiResponses(&[]api.Response{data}))[0]
+	raw, err3 := EncodeSignedResponse(&page, DestinationResponses)
+	if err3 != nil {
+		t.Fatal(err3)
+	}
+	if strings.Count(string(raw), "[redacted]") != 1 {
+		t.Errorf("The page should go out as the pre-serialize hook left it. Response: %s", raw)
+	}
+}
+
+func TestRunHooks_Fail_Sandboxed(t *testing.T) {
+	globals.SetGlobals()
+	defer func(v int) { globals.ResponseHookMaxFailures = v }(globals.ResponseHookMaxFailures)
+	globals.ResponseHookMaxFailures = 2
+	RegisterHook(Hook{
+		Name: "panics",
+		PrePaginate: func(ctx HookContext, data *api.Response) error {
+			data.Posts[0].Body = "changed"
+			panic("broken hook")
+		},
+	})
+	defer UnregisterHook("panics")
+	data := syntheticPosts(1)
+	if err := runPrePaginateHooks("posts", DestinationCaches, &data); err != nil {
+		t.Errorf("A hook that isn't required should not stop the response. Error: %s", err)
+	}
+	if data.Posts[0].Body == "changed" {
+		t.Errorf("The changes of a hook that failed should be thrown away.")
+	}
+	RegisterHook(Hook{
+		Name:     "required",
+		Required: true,
+		PrePaginate: func(ctx HookContext, data *api.Response) error {
+			return errors.New("failed")
+		},
+	})
+	defer UnregisterHook("required")
+	if err2 := runPrePaginateHooks("posts", DestinationCaches, &data); err2 == nil {
+		t.Errorf("A required hook that fails should stop the response.")
+	}
+	for _, s := range Hooks() {
+		if s.Name == "panics" && (s.Enabled || s.Failures != 2) {
+			t.Errorf("A hook that fails too many times in a row should be disabled. Status: %#v", s)
+		}
+	}
+	if err3 := runPrePaginateHooks("posts", DestinationCaches, &data); err3 == nil || !strings.Contains(err3.Error(), "required") {
+		t.Errorf("A required hook that is disabled should still stop the response. Error: %v", err3)
+	}
+}
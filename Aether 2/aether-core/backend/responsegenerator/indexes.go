@@ -0,0 +1,126 @@
+// Backend > ResponseGenerator > Indexes
+// This file has the helpers that keep the indexes read from the database in line with the entity pages of a cache.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+)
+
+func countEntities(r *api.Response) int {
+	return len(r.Boards) + len(r.Threads) + len(r.Posts) + len(r.Votes) + len(r.Keys) + len(r.Truststates) + len(r.Tombstones)
+}
+
+func countIndexes(r *api.Response) int {
+	return len(r.BoardIndexes) + len(r.ThreadIndexes) + len(r.PostIndexes) + len(r.VoteIndexes) + len(r.KeyIndexes) + len(r.TruststateIndexes) + len(r.TombstoneIndexes)
+}
+
+// fingerprintsOf lists the fingerprints of all entities in the response.
+func fingerprintsOf(r *api.Response) []api.Fingerprint {
+	var fps []api.Fingerprint
+	for i, _ := range r.Boards {
+		fps = append(fps, r.Boards[i].Fingerprint)
+	}
+	for i, _ := range r.Threads {
+		fps = append(fps, r.Threads[i].Fingerprint)
+	}
+	for i, _ := range r.Posts {
+		fps = append(fps, r.Posts[i].Fingerprint)
+	}
+	for i, _ := range r.Votes {
+		fps = append(fps, r.Votes[i].Fingerprint)
+	}
+	for i, _ := range r.Keys {
+		fps = append(fps, r.Keys[i].Fingerprint)
+	}
+	for i, _ := range r.Truststates {
+		fps = append(fps, r.Truststates[i].Fingerprint)
+	}
+	for i, _ := range r.Tombstones {
+		fps = append(fps, r.Tombstones[i].Fingerprint)
+	}
+	return fps
+}
+
+// indexFingerprintsOf lists the fingerprints of all indexes in the response, in the order of the entities they index.
+func indexFingerprintsOf(r *api.Response) []api.Fingerprint {
+	var fps []api.Fingerprint
+	for i, _ := range r.BoardIndexes {
+		fps = append(fps, r.BoardIndexes[i].Fingerprint)
+	}
+	for i, _ := range r.ThreadIndexes {
+		fps = append(fps, r.ThreadIndexes[i].Fingerprint)
+	}
+	for i, _ := range r.PostIndexes {
+		fps = append(fps, r.PostIndexes[i].Fingerprint)
+	}
+	for i, _ := range r.VoteIndexes {
+		fps = append(fps, r.VoteIndexes[i].Fingerprint)
+	}
+	for i, _ := range r.KeyIndexes {
+		fps = append(fps, r.KeyIndexes[i].Fingerprint)
+	}
+	for i, _ := range r.TruststateIndexes {
+		fps = append(fps, r.TruststateIndexes[i].Fingerprint)
+	}
+	for i, _ := range r.TombstoneIndexes {
+		fps = append(fps, r.TombstoneIndexes[i].Fingerprint)
+	}
+	return fps
+}
+
+// removeIndexesOf removes the indexes of the given entities, i.e. the ones that were dropped from the entity pages.
+func removeIndexesOf(r *api.Response, fps map[api.Fingerprint]bool) {
+	if len(fps) == 0 {
+		return
+	}
+	var boardIndexes []api.BoardIndex
+	for _, idx := range r.BoardIndexes {
+		if !fps[idx.Fingerprint] {
+			boardIndexes = append(boardIndexes, idx)
+		}
+	}
+	r.BoardIndexes = boardIndexes
+	var threadIndexes []api.ThreadIndex
+	for _, idx := range r.ThreadIndexes {
+		if !fps[idx.Fingerprint] {
+			threadIndexes = append(threadIndexes, idx)
+		}
+	}
+	r.ThreadIndexes = threadIndexes
+	var postIndexes []api.PostIndex
+	for _, idx := range r.PostIndexes {
+		if !fps[idx.Fingerprint] {
+			postIndexes = append(postIndexes, idx)
+		}
+	}
+	r.PostIndexes = postIndexes
+	var voteIndexes []api.VoteIndex
+	for _, idx := range r.VoteIndexes {
+		if !fps[idx.Fingerprint] {
+			voteIndexes = append(voteIndexes, idx)
+		}
+	}
+	r.VoteIndexes = voteIndexes
+	var keyIndexes []api.KeyIndex
+	for _, idx := range r.KeyIndexes {
+		if !fps[idx.Fingerprint] {
+			keyIndexes = append(keyIndexes, idx)
+		}
+	}
+	r.KeyIndexes = keyIndexes
+	var truststateIndexes []api.TruststateIndex
+	for _, idx := range r.TruststateIndexes {
+		if !fps[idx.Fingerprint] {
+			truststateIndexes = append(truststateIndexes, idx)
+		}
+	}
+	r.TruststateIndexes = truststateIndexes
+	var tombstoneIndexes []api.TombstoneIndex
+	for _, idx := range r.TombstoneIndexes {
+		if !fps[idx.Fingerprint] {
+			tombstoneIndexes = append(tombstoneIndexes, idx)
+		}
+	}
+	r.TombstoneIndexes = tombstoneIndexes
+}
@@ -0,0 +1,66 @@
+// Backend > ResponseGenerator > Inline
+// This file decides whether the results of a POST response are sent in the response itself, or saved as a multipart response. A multipart response costs the remote a round trip for every page, so results of more than one page are still sent inline if they are small enough together.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"encoding/json"
+)
+
+// inlineLimit gives the most that can be sent inline in the response to the request, in bytes: POSTInlineMaxBytes, or less if the requester asked for less.
+func inlineLimit(filters FilterSet) int64 {
+	if filters.MaxInline >= 0 && filters.MaxInline < globals.POSTInlineMaxBytes {
+		return filters.MaxInline
+	}
+	return globals.POSTInlineMaxBytes
+}
+
+// fitsInline checks whether the pages can be sent inline as a single page: the JSON of their entities comes to no more than the limit, and the page would not have more entities than the remotes accept in a page by default.
+func fitsInline(pages *[]api.ApiResponse, limit int64) bool {
+	// Every page has the count of the entities of all of them.
+	if (*pages)[0].Pagination.TotalEntities > uint64(globals.InboundMaxPageEntities) {
+		return false
+	}
+	var size int64
+	for i, _ := range *pages {
+		data, err := json.Marshal((*pages)[i].ResponseBody)
+		if err != nil {
+			return false
+		}
+		size += int64(len(data))
+		if size > limit {
+			return false
+		}
+	}
+	return true
+}
+
+// mergePages gives the first page with the results of all the pages in it. The pages are slices of the same entity list, so the results are copied into a new body rather than appended to that of the first page.
+func mergePages(pages *[]api.ApiResponse) api.ApiResponse {
+	merged := (*pages)[0]
+	merged.ResponseBody = api.Answer{}
+	b := &merged.ResponseBody
+	for _, page := range *pages {
+		a := page.ResponseBody
+		b.Boards = append(b.Boards, a.Boards...)
+		b.BoardIndexes = append(b.BoardIndexes, a.BoardIndexes...)
+		b.Threads = append(b.Threads, a.Threads...)
+		b.ThreadIndexes = append(b.ThreadIndexes, a.ThreadIndexes...)
+		b.Posts = append(b.Posts, a.Posts...)
+		b.PostIndexes = append(b.PostIndexes, a.PostIndexes...)
+		b.Votes = append(b.Votes, a.Votes...)
+		b.VoteIndexes = append(b.VoteIndexes, a.VoteIndexes...)
+		b.Keys = append(b.Keys, a.Keys...)
+		b.KeyIndexes = append(b.KeyIndexes, a.KeyIndexes...)
+		b.Addresses = append(b.Addresses, a.Addresses...)
+		b.AddressIndexes = append(b.AddressIndexes, a.AddressIndexes...)
+		b.Truststates = append(b.Truststates, a.Truststates...)
+		b.TruststateIndexes = append(b.TruststateIndexes, a.TruststateIndexes...)
+		b.Tombstones = append(b.Tombstones, a.Tombstones...)
+		b.TombstoneIndexes = append(b.TombstoneIndexes, a.TombstoneIndexes...)
+		b.VoteSummaries = append(b.VoteSummaries, a.VoteSummaries...)
+	}
+	return merged
+}
@@ -0,0 +1,67 @@
+// This test is in the package itself rather than in responsegenerator_test, since the inline decision is made by functions that are not exported. The multipart responses are saved to the user directory, so only the inline ones are baked here.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"testing"
+)
+
+func TestInlineLimit_Success(t *testing.T) {
+	globals.SetGlobals()
+	req := GeneratePrefilledApiResponse()
+	if l := inlineLimit(processFilters(req)); l != globals.POSTInlineMaxBytes {
+		t.Errorf("Without a preference, the limit should be that of the node. Limit: %d", l)
+	}
+	req.Filters = []api.Filter{{Type: "max_inline", Values: []string{"1000"}}}
+	if l := inlineLimit(processFilters(req)); l != 1000 {
+		t.Errorf("The requester should be able to ask for less. Limit: %d", l)
+	}
+	req.Filters = []api.Filter{{Type: "max_inline", Values: []string{"1000000000"}}}
+	if l := inlineLimit(processFilters(req)); l != globals.POSTInlineMaxBytes {
+		t.Errorf("The requester should not be able to ask for more than the node sends. Limit: %d", l)
+	}
+	req.Filters = []api.Filter{{Type: "max_inline", Values: []string{"-5"}}}
+	if l := inlineLimit(processFilters(req)); l != globals.POSTInlineMaxBytes {
+		t.Errorf("A preference that is not a size should be ignored. Limit: %d", l)
+	}
+}
+
+func TestBakeFinalApiResponse_Inline_Success(t *testing.T) {
+	globals.SetGlobals()
+	data := syntheticPosts(250)
+	pages := convertResponsesToApiResponses(splitEntitiesToPages(&data))
+	if len(*pages) < 2 {
+		t.Fatalf("The posts should be split into more than one page. Pages: %d", len(*pages))
+	}
+	resp, err := bakeFinalApiResponse(pages, globals.POSTInlineMaxBytes, "inline_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Endpoint != "singular_post_response" || len(resp.Results) != 0 {
+		t.Fatalf("Pages under the inline limit should be sent inline. Endpoint: %s", resp.Endpoint)
+	}
+	if len(resp.ResponseBody.Posts) != 250 || resp.Pagination.TotalEntities != 250 {
+		t.Errorf("The inline response should have the results of all the pages. Posts: %d, Pagination: %#v", len(resp.ResponseBody.Posts), resp.Pagination)
+	}
+	for i, _ := range resp.ResponseBody.Posts {
+		if resp.ResponseBody.Posts[i].Fingerprint != data.Posts[i].Fingerprint {
+			t.Fatalf("The inline response should have the results in order. Position: %d", i)
+		}
+	}
+}
+
+func TestFitsInline_Fail(t *testing.T) {
+	globals.SetGlobals()
+	defer func(v int) { globals.InboundMaxPageEntities = v }(globals.InboundMaxPageEntities)
+	data := syntheticPosts(250)
+	pages := convertResponsesToApiResponses(splitEntitiesToPages(&data))
+	if fitsInline(pages, 0) || fitsInline(pages, 1000) {
+		t.Errorf("Pages over the inline limit should not be sent inline.")
+	}
+	globals.InboundMaxPageEntities = 100
+	if fitsInline(pages, globals.POSTInlineMaxBytes) {
+		t.Errorf("Pages with more entities than the remotes accept in a page should not be sent inline.")
+	}
+}
@@ -0,0 +1,66 @@
+// Backend > ResponseGenerator > Language
+// This file applies the language filter of a request: only the boards and the threads in one of the requested languages are given. The other entity types have no language of their own, and they pass through.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/language"
+)
+
+// normaliseLanguages turns the values of a language filter into the form the entities are tagged with. The values that are not language tags are dropped.
+func normaliseLanguages(values []string) []string {
+	var langs []string
+	for _, v := range values {
+		if lang := language.Normalise(v); len(lang) > 0 {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+// filterByLanguage leaves out the boards and the threads that are not in one of the given languages. Entities whose language is not known are left out too, since the remote asked for content it can read. If no languages are given, nothing is filtered.
+func filterByLanguage(resp api.Response, languages []string) (api.Response, error) {
+	if len(languages) == 0 {
+		return resp, nil
+	}
+	wanted := make(map[string]bool)
+	for _, lang := range languages {
+		wanted[lang] = true
+	}
+	cleanedResp := resp
+	if len(resp.Boards) > 0 {
+		var fps []api.Fingerprint
+		for i, _ := range resp.Boards {
+			fps = append(fps, resp.Boards[i].Fingerprint)
+		}
+		langs, err := persistence.ReadLanguages("boards", fps)
+		if err != nil {
+			return resp, err
+		}
+		cleanedResp.Boards = nil
+		for i, _ := range resp.Boards {
+			if wanted[langs[resp.Boards[i].Fingerprint]] {
+				cleanedResp.Boards = append(cleanedResp.Boards, resp.Boards[i])
+			}
+		}
+	}
+	if len(resp.Threads) > 0 {
+		var fps []api.Fingerprint
+		for i, _ := range resp.Threads {
+			fps = append(fps, resp.Threads[i].Fingerprint)
+		}
+		langs, err := persistence.ReadLanguages("threads", fps)
+		if err != nil {
+			return resp, err
+		}
+		cleanedResp.Threads = nil
+		for i, _ := range resp.Threads {
+			if wanted[langs[resp.Threads[i].Fingerprint]] {
+				cleanedResp.Threads = append(cleanedResp.Threads, resp.Threads[i])
+			}
+		}
+	}
+	return cleanedResp, nil
+}
@@ -0,0 +1,279 @@
+// Backend > ResponseGenerator > LiveCache
+// This file keeps the entities that arrived within the last LiveCacheWindow in memory, per entity type, with when each arrived, and answers the POST requests for what is new within that window from there. Most of the POST requests the remotes make are of this kind, since they ask for what arrived after their last sync, and without this every one of them reads the database.
+// A cache is loaded from the database the first time it is needed, and from then on only what arrived after it was last read is read, as entities are ingested and before each request, so that it has everything the database would give. The entities are read with Read, so what comes out of the cache is what Read would give for the same range: the updates replace what they update, and the threads and the posts deleted by their owners are taken out as the tombstones arrive.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// liveEntity is an entity in the live cache, with when it arrived.
+type liveEntity struct {
+	arrival api.Timestamp
+	owner   api.Fingerprint // Only for the threads and the posts, to match them with their tombstones.
+	entity  interface{}
+}
+
+// liveCache is the live cache of an entity type. It has every entity of the type that arrived after from and before upTo.
+type liveCache struct {
+	window   time.Duration // The window it was loaded with. A cache loaded with another window is loaded again.
+	from     api.Timestamp
+	upTo     api.Timestamp
+	entities map[api.Fingerprint]liveEntity
+}
+
+var liveCachesLock sync.Mutex
+var liveCaches = make(map[string]*liveCache)
+
+// liveOverflows are the entity types that had more than LiveCacheMaxEntities in the window, by until when they are read from the database instead.
+var liveOverflows = make(map[string]api.Timestamp)
+
+// liveItems gives the entities of the type in the response, by their fingerprints.
+func liveItems(respType string, resp api.Response) map[api.Fingerprint]liveEntity {
+	items := make(map[api.Fingerprint]liveEntity)
+	switch respType {
+	case "boards":
+		for i, _ := range resp.Boards {
+			items[resp.Boards[i].Fingerprint] = liveEntity{entity: resp.Boards[i]}
+		}
+	case "threads":
+		for i, _ := range resp.Threads {
+			items[resp.Threads[i].Fingerprint] = liveEntity{owner: resp.Threads[i].Owner, entity: resp.Threads[i]}
+		}
+	case "posts":
+		for i, _ := range resp.Posts {
+			items[resp.Posts[i].Fingerprint] = liveEntity{owner: resp.Posts[i].Owner, entity: resp.Posts[i]}
+		}
+	case "votes":
+		for i, _ := range resp.Votes {
+			items[resp.Votes[i].Fingerprint] = liveEntity{entity: resp.Votes[i]}
+		}
+	case "keys":
+		for i, _ := range resp.Keys {
+			items[resp.Keys[i].Fingerprint] = liveEntity{entity: resp.Keys[i]}
+		}
+	case "truststates":
+		for i, _ := range resp.Truststates {
+			items[resp.Truststates[i].Fingerprint] = liveEntity{entity: resp.Truststates[i]}
+		}
+	case "tombstones":
+		for i, _ := range resp.Tombstones {
+			items[resp.Tombstones[i].Fingerprint] = liveEntity{entity: resp.Tombstones[i]}
+		}
+	}
+	return items
+}
+
+// appendLiveItem adds the entity into the response.
+func appendLiveItem(resp *api.Response, entity interface{}) {
+	switch e := entity.(type) {
+	case api.Board:
+		resp.Boards = append(resp.Boards, e)
+	case api.Thread:
+		resp.Threads = append(resp.Threads, e)
+	case api.Post:
+		resp.Posts = append(resp.Posts, e)
+	case api.Vote:
+		resp.Votes = append(resp.Votes, e)
+	case api.Key:
+		resp.Keys = append(resp.Keys, e)
+	case api.Truststate:
+		resp.Truststates = append(resp.Truststates, e)
+	case api.Tombstone:
+		resp.Tombstones = append(resp.Tombstones, e)
+	}
+}
+
+// liveCacheable checks whether the entity type has a live cache.
+func liveCacheable(respType string) bool {
+	_, ok := liveCacheTypes[respType]
+	return ok
+}
+
+var liveCacheTypes = map[string]bool{"boards": true, "threads": true, "posts": true, "votes": true, "keys": true, "truststates": true, "tombstones": true}
+
+// refreshLive reads what arrived after the cache of the entity type was last read, up to now, and drops what went out of the window. A cache that isn't loaded is loaded. The caller holds liveCachesLock.
+func refreshLive(respType string, now api.Timestamp) (*liveCache, error) {
+	lastCache := api.Timestamp(globals.LastCacheGenerationTimestamp)
+	window := api.Timestamp(globals.LiveCacheWindow / time.Second)
+	c := liveCaches[respType]
+	if c == nil || c.window != globals.LiveCacheWindow {
+		from := now - window
+		// The database gives nothing that arrived before the last cache for a time range, so neither does the live cache.
+		if from < lastCache {
+			from = lastCache
+		}
+		c = &liveCache{window: globals.LiveCacheWindow, from: from, upTo: from + 1, entities: make(map[api.Fingerprint]liveEntity)}
+		liveCaches[respType] = c
+	}
+	if now <= c.upTo {
+		return c, nil
+	}
+	// The range read overlaps the last one by a second, since what arrived in the second it ended was not read yet. Read doesn't give what arrived before the last cache, so the range doesn't start before it.
+	begin := c.upTo - 1
+	if begin < lastCache {
+		begin = lastCache
+	}
+	data, err := persistence.Read(respType, nil, nil, begin, now)
+	if err != nil {
+		delete(liveCaches, respType)
+		return nil, err
+	}
+	arrivals, err2 := persistence.ReadArrivalsInRange(respType, begin, now)
+	if err2 != nil {
+		delete(liveCaches, respType)
+		return nil, err2
+	}
+	for fp, e := range liveItems(respType, data) {
+		arrival, ok := arrivals[fp]
+		if !ok {
+			// Updated between the two reads. It arrived again just now, and is read again with the next range.
+			arrival = now - 1
+		}
+		e.arrival = arrival
+		c.entities[fp] = e
+	}
+	if respType == "threads" || respType == "posts" {
+		err3 := removeLiveTombstoned(c, respType, begin, now)
+		if err3 != nil {
+			delete(liveCaches, respType)
+			return nil, err3
+		}
+	}
+	c.upTo = now
+	if cutoff := now - window; c.from < cutoff {
+		for fp, e := range c.entities {
+			if e.arrival <= cutoff {
+				delete(c.entities, fp)
+			}
+		}
+		c.from = cutoff
+	}
+	if len(c.entities) > globals.LiveCacheMaxEntities {
+		delete(liveCaches, respType)
+		liveOverflows[respType] = now + window
+		return nil, errors.New(fmt.Sprintf("The live cache has more entities than it can keep. It is read from the database for a window. Entity type: %s, Entities: %d, Max: %d", respType, len(c.entities), globals.LiveCacheMaxEntities))
+	}
+	return c, nil
+}
+
+// removeLiveTombstoned takes out of the cache the threads or the posts that the tombstones that arrived within the range delete. The ones that were deleted before they arrived were never in it, since Read doesn't give them.
+func removeLiveTombstoned(c *liveCache, respType string, begin api.Timestamp, end api.Timestamp) error {
+	tombstones, err := persistence.Read("tombstones", nil, nil, begin, end)
+	if err != nil {
+		return err
+	}
+	for _, t := range tombstones.Tombstones {
+		e, ok := c.entities[t.Target]
+		if ok && t.TargetType == respType && len(t.Owner) > 0 && t.Owner == e.owner {
+			delete(c.entities, t.Target)
+		}
+	}
+	return nil
+}
+
+// readLive gives what the database would give for the POST request, from the live cache, if the request is for what arrived within the window. It gives false for the requests it can't answer, which are read from the database.
+func readLive(respType string, filters FilterSet) (api.Response, bool) {
+	var resp api.Response
+	if !globals.LiveCacheEnabled || !liveCacheable(respType) || len(filters.Fingerprints) > 0 || len(filters.Embeds) > 0 || filters.CursorMode {
+		return resp, false
+	}
+	now := api.Timestamp(clock.Unix())
+	// The range is sanitised the way Read does it. Only the ranges that go up to now are answered.
+	begin := filters.TimeStart
+	clamped := false
+	if begin < api.Timestamp(globals.LastCacheGenerationTimestamp) {
+		begin = api.Timestamp(globals.LastCacheGenerationTimestamp)
+		clamped = true
+	}
+	if !clamped && filters.TimeEnd != 0 && filters.TimeEnd < now {
+		return resp, false
+	}
+	if begin >= now {
+		return resp, false
+	}
+	liveCachesLock.Lock()
+	defer liveCachesLock.Unlock()
+	if liveOverflows[respType] > now {
+		return resp, false
+	}
+	c, err := refreshLive(respType, now)
+	if err != nil {
+		logging.LogTrace(filters.TraceId, 1, fmt.Sprintf("The live cache could not be read. Entity type: %s, Error: %s", respType, err))
+		return resp, false
+	}
+	if begin < c.from {
+		return resp, false
+	}
+	var matched []liveEntity
+	var fps []api.Fingerprint
+	for fp, e := range c.entities {
+		if e.arrival > begin && e.arrival < now {
+			matched = append(matched, e)
+			fps = append(fps, fp)
+		}
+	}
+	// In the order the database pages are cut in.
+	order := make([]int, len(matched))
+	for i, _ := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := matched[order[i]], matched[order[j]]
+		if a.arrival != b.arrival {
+			return a.arrival < b.arrival
+		}
+		return fps[order[i]] < fps[order[j]]
+	})
+	for _, i := range order {
+		appendLiveItem(&resp, matched[i].entity)
+	}
+	return resp, true
+}
+
+// NoteIngested reads into the live caches what was just ingested, so that the requests after it don't have to. The tombstones take out the threads and the posts they delete.
+func NoteIngested(resp *api.Response) {
+	if !globals.LiveCacheEnabled {
+		return
+	}
+	var types []string
+	counts := map[string]int{"boards": len(resp.Boards), "threads": len(resp.Threads), "posts": len(resp.Posts), "votes": len(resp.Votes), "keys": len(resp.Keys), "truststates": len(resp.Truststates), "tombstones": len(resp.Tombstones)}
+	for respType, count := range counts {
+		if count > 0 {
+			types = append(types, respType)
+		}
+	}
+	if len(resp.Tombstones) > 0 {
+		types = append(types, "threads", "posts")
+	}
+	now := api.Timestamp(clock.Unix())
+	liveCachesLock.Lock()
+	defer liveCachesLock.Unlock()
+	for _, respType := range types {
+		// Only the caches that are loaded are kept up to date. The others are loaded when they are first needed.
+		if _, loaded := liveCaches[respType]; !loaded {
+			continue
+		}
+		if _, err := refreshLive(respType, now); err != nil {
+			logging.Log(1, fmt.Sprintf("The live cache could not be refreshed. Entity type: %s, Error: %s", respType, err))
+		}
+	}
+}
+
+// ResetLiveCaches drops the live caches, so that they are loaded again from the database when they are next needed. This is for the changes in the database that don't come through ingest, such as the deletions.
+func ResetLiveCaches() {
+	liveCachesLock.Lock()
+	defer liveCachesLock.Unlock()
+	liveCaches = make(map[string]*liveCache)
+	liveOverflows = make(map[string]api.Timestamp)
+}
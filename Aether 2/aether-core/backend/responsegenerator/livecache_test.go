@@ -0,0 +1,77 @@
+// This test is in the package itself rather than in responsegenerator_test, since it fills the live cache directly. A cache that was read up to now is answered without reading the database, so no database is needed.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"testing"
+	"time"
+)
+
+// fillLiveCache puts the posts into the live cache as read up to now, each arrived a minute after the one before, the last a minute ago. It gives now.
+func fillLiveCache(t *testing.T, posts []api.Post) api.Timestamp {
+	globals.SetGlobals()
+	globals.LastCacheGenerationTimestamp = 0
+	clock.Set(clock.NewMockClock(time.Unix(1600000000, 0)))
+	now := api.Timestamp(clock.Unix())
+	c := &liveCache{window: globals.LiveCacheWindow, from: now - 3600, upTo: now, entities: make(map[api.Fingerprint]liveEntity)}
+	for i, _ := range posts {
+		arrival := now - api.Timestamp(60*(len(posts)-i))
+		c.entities[posts[i].Fingerprint] = liveEntity{arrival: arrival, owner: posts[i].Owner, entity: posts[i]}
+	}
+	ResetLiveCaches()
+	liveCaches["posts"] = c
+	return now
+}
+
+func TestReadLive_Success(t *testing.T) {
+	data := syntheticPosts(30)
+	now := fillLiveCache(t, data.Posts)
+	defer clock.Reset()
+	defer ResetLiveCaches()
+	// What arrived in the last ten minutes.
+	resp, ok := readLive("posts", FilterSet{TimeStart: now - 600})
+	if !ok {
+		t.Fatal("The request for what arrived within the window should be answered from the live cache.")
+	}
+	if len(resp.Posts) != 9 {
+		t.Fatalf("Only the posts that arrived after the start of the range should be given. Posts: %d", len(resp.Posts))
+	}
+	for i, _ := range resp.Posts {
+		if resp.Posts[i].Fingerprint != data.Posts[21+i].Fingerprint {
+			t.Fatalf("The posts should be in the order they arrived in. Position: %d", i)
+		}
+	}
+	// The start of the range is given as before the last cache, so it starts there.
+	globals.LastCacheGenerationTimestamp = int64(now - 300)
+	resp2, ok2 := readLive("posts", FilterSet{TimeStart: 1, TimeEnd: 2})
+	if !ok2 || len(resp2.Posts) != 4 {
+		t.Errorf("A range that starts before the last cache should start at the last cache, and go up to now. Answered: %v, Posts: %d", ok2, len(resp2.Posts))
+	}
+}
+
+func TestReadLive_Fail_OutOfWindow(t *testing.T) {
+	data := syntheticPosts(10)
+	now := fillLiveCache(t, data.Posts)
+	defer clock.Reset()
+	defer ResetLiveCaches()
+	if _, ok := readLive("posts", FilterSet{TimeStart: now - 7200}); ok {
+		t.Errorf("A range that starts before the window should be read from the database.")
+	}
+	if _, ok := readLive("posts", FilterSet{TimeStart: now - 600, TimeEnd: now - 300}); ok {
+		t.Errorf("A range that ends before now should be read from the database.")
+	}
+	if _, ok := readLive("posts", FilterSet{TimeStart: now - 600, CursorMode: true}); ok {
+		t.Errorf("The cursor requests should be read from the database.")
+	}
+	if _, ok := readLive("posts", FilterSet{Fingerprints: []api.Fingerprint{data.Posts[0].Fingerprint}}); ok {
+		t.Errorf("The requests for fingerprints should be read from the database.")
+	}
+	globals.LiveCacheEnabled = false
+	defer func() { globals.LiveCacheEnabled = true }()
+	if _, ok := readLive("posts", FilterSet{TimeStart: now - 600}); ok {
+		t.Errorf("Nothing should be answered from the live cache with it disabled.")
+	}
+}
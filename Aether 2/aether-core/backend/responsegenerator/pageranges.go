@@ -0,0 +1,70 @@
+// Backend > ResponseGenerator > Page Ranges
+// This file reads a range of the pages of a cache in one go, for a remote that needs only some of them, such as the rest of a cache it stopped downloading partway, instead of asking for every page by itself. The pages are given one after another, as they are on disk, with nothing between them: the remote has the manifest of the cache, and splits them by the sizes the manifest gives, and checks every one against its hash.
+// Only the caches with a manifest in their index can be read in ranges, since the pages can't be split without one. A page that doesn't match the manifest fails the whole range; the remote asks for the pages by themselves then, which repairs the cache.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// readCacheFile reads a file in the folder of the cache, from the statics directory, or from the archives if the cache is archived.
+func readCacheFile(respType string, cacheName string, name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(fmt.Sprint(globals.CachesLocation, "/", respType, "/", cacheName, "/", name))
+	if err == nil || !os.IsNotExist(err) {
+		return data, err
+	}
+	archived, found, err2 := ReadArchivedCachePage(fmt.Sprint(respType, "/", cacheName, "/", name))
+	if err2 != nil {
+		return nil, err2
+	}
+	if !found {
+		return nil, err
+	}
+	return archived, nil
+}
+
+// ReadCachePages reads the entity pages from first to last, both included, of the cache, checks every one of them against the manifest of the cache, and gives them one after another. It gives false if the node has no such cache, or it has no manifest.
+func ReadCachePages(respType string, cacheName string, first int, last int) ([]byte, bool, error) {
+	if !isCacheEntityType(respType) || !isValidCacheName(cacheName) {
+		return nil, false, nil
+	}
+	if first < 0 || last < first || last-first+1 > globals.CachePageRangeMaxPages {
+		return nil, true, errors.New(fmt.Sprintf("The page range is not valid, or has more pages than a range can have. First: %d, Last: %d, Maximum: %d", first, last, globals.CachePageRangeMaxPages))
+	}
+	link, found, err := findCacheLink(respType, cacheName)
+	if err != nil || !found || len(link.Manifest) == 0 {
+		return nil, false, err
+	}
+	noteCacheServed(respType, cacheName)
+	manifestData, err2 := readCacheFile(respType, cacheName, api.ManifestFile)
+	if err2 != nil {
+		return nil, true, errors.New(fmt.Sprintf("The manifest of the cache could not be read. Cache: %s/%s, Error: %s", respType, cacheName, err2))
+	}
+	m, err3 := api.ParseManifest(manifestData, link.Manifest)
+	if err3 != nil {
+		return nil, true, err3
+	}
+	if last >= m.EntityPageCount() {
+		return nil, true, errors.New(fmt.Sprintf("The page range goes past the last page of the cache. Cache: %s/%s, Last: %d, Pages: %d", respType, cacheName, last, m.EntityPageCount()))
+	}
+	var result []byte
+	for i := first; i <= last; i++ {
+		name := fmt.Sprint(i, ".json")
+		data, err4 := readCacheFile(respType, cacheName, name)
+		if err4 != nil {
+			return nil, true, errors.New(fmt.Sprintf("A page of the range could not be read. Cache: %s/%s, Page: %s, Error: %s", respType, cacheName, name, err4))
+		}
+		listed, _ := m.Page(name)
+		if err5 := listed.Verify(data); err5 != nil {
+			return nil, true, errors.New(fmt.Sprintf("A page of the range does not match the manifest of the cache. Cache: %s/%s, Error: %s", respType, cacheName, err5))
+		}
+		result = append(result, data...)
+	}
+	return result, true, nil
+}
@@ -0,0 +1,60 @@
+// This test is in the package itself rather than in responsegenerator_test, since the cache it reads is saved with functions that are not exported.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadCachePages_Success(t *testing.T) {
+	cacheName := saveRepairTestCache(t)
+	defer os.RemoveAll(globals.CachesLocation)
+	data, found, err := ReadCachePages("posts", cacheName, 1, 2)
+	if err != nil || !found {
+		t.Fatalf("The range should have been read. Found: %t, Error: %v", found, err)
+	}
+	manifestData, _ := ioutil.ReadFile(filepath.Join(globals.CachesLocation, "posts", cacheName, api.ManifestFile))
+	m, err2 := api.ParseManifest(manifestData, "")
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	pages, err3 := api.SplitPageRange(data, m, 1, 2)
+	if err3 != nil || len(pages) != 2 {
+		t.Fatalf("The range should split into its pages by the manifest. Error: %v", err3)
+	}
+	second, _ := ioutil.ReadFile(filepath.Join(globals.CachesLocation, "posts", cacheName, "2.json"))
+	if string(pages[1]) != string(second) {
+		t.Errorf("The pages of the range should be the pages on disk, as they are.")
+	}
+	if _, err4 := api.SplitPageRange(data[:len(data)-1], m, 1, 2); err4 == nil {
+		t.Errorf("A range cut short should not split.")
+	}
+	if _, err5 := api.SplitPageRange(data, m, 0, 1); err5 == nil {
+		t.Errorf("A range split by the sizes of other pages should not match the manifest.")
+	}
+}
+
+func TestReadCachePages_Fail(t *testing.T) {
+	cacheName := saveRepairTestCache(t)
+	defer os.RemoveAll(globals.CachesLocation)
+	if _, found, _ := ReadCachePages("posts", "cache_missing", 0, 1); found {
+		t.Errorf("A cache that is not in the index should not be found.")
+	}
+	if _, _, err := ReadCachePages("posts", cacheName, 0, 100); err == nil {
+		t.Errorf("A range past the last page of the cache should be refused.")
+	}
+	globals.CachePageRangeMaxPages = 1
+	if _, _, err := ReadCachePages("posts", cacheName, 0, 1); err == nil {
+		t.Errorf("A range with more pages than a range can have should be refused.")
+	}
+	globals.CachePageRangeMaxPages = 32
+	ioutil.WriteFile(filepath.Join(globals.CachesLocation, "posts", cacheName, "1.json"), []byte("{}"), 0755)
+	if _, _, err := ReadCachePages("posts", cacheName, 0, 2); err == nil {
+		t.Errorf("A range with a page that doesn't match the manifest should be refused.")
+	}
+}
@@ -0,0 +1,77 @@
+// Backend > ResponseGenerator > Pagination
+// This file fills in the pagination of the pages of the caches and the POST responses, so that they say the same thing in the same way whichever path created them.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+)
+
+// stampPagination sets the page number and the page count of a page. legacyPages is what the older versions of this path wrote into the pages field, which is kept there while PaginationLegacyPages is set.
+func stampPagination(p *api.Pagination, pageNum int, pageCount int, legacyPages int) {
+	p.CurrentPage = uint64(pageNum)
+	p.TotalPages = uint64(pageCount)
+	p.Pages = uint64(pageCount)
+	if globals.PaginationLegacyPages {
+		p.Pages = uint64(legacyPages)
+	}
+}
+
+// countPageEntities counts everything on the page: the entities, the addresses, and the indexes.
+func countPageEntities(r *api.Response) int {
+	return countEntities(r) + countIndexes(r) + len(r.Addresses) + len(r.AddressIndexes)
+}
+
+// pageSizeOf gives the page size of the type on the page, which is the most of it a page can have. A page has a single type.
+func pageSizeOf(r *api.Response) int {
+	s := globals.EntityPageSizesObj
+	switch {
+	case len(r.Boards) > 0:
+		return s.Boards
+	case len(r.BoardIndexes) > 0:
+		return s.BoardIndexes
+	case len(r.Threads) > 0:
+		return s.Threads
+	case len(r.ThreadIndexes) > 0:
+		return s.ThreadIndexes
+	case len(r.Posts) > 0:
+		return s.Posts
+	case len(r.PostIndexes) > 0:
+		return s.PostIndexes
+	case len(r.Votes) > 0:
+		return s.Votes
+	case len(r.VoteIndexes) > 0:
+		return s.VoteIndexes
+	case len(r.Addresses) > 0:
+		return s.Addresses
+	case len(r.AddressIndexes) > 0:
+		return s.AddressIndexes
+	case len(r.Keys) > 0:
+		return s.Keys
+	case len(r.KeyIndexes) > 0:
+		return s.KeyIndexes
+	case len(r.Truststates) > 0:
+		return s.Truststates
+	case len(r.TruststateIndexes) > 0:
+		return s.TruststateIndexes
+	case len(r.Tombstones) > 0:
+		return s.Tombstones
+	case len(r.TombstoneIndexes) > 0:
+		return s.TombstoneIndexes
+	}
+	return 0
+}
+
+// paginationTotals gives how many entities the pages have in total, and the page size of the pages. When the pages have more than one type, such as a POST response with embeds, the page size is the largest of theirs, so that no page has more than the page size.
+func paginationTotals(r *[]api.Response) (uint64, int) {
+	var total uint64
+	pageSize := 0
+	for i, _ := range *r {
+		total += uint64(countPageEntities(&(*r)[i]))
+		if size := pageSizeOf(&(*r)[i]); size > pageSize {
+			pageSize = size
+		}
+	}
+	return total, pageSize
+}
@@ -0,0 +1,77 @@
+// This test is in the package itself rather than in responsegenerator_test, since the pages are stamped by functions that are not exported.
+
+package responsegenerator
+
+import (
+	"aether-core/services/globals"
+	"testing"
+)
+
+func TestConvertResponsesToApiResponses_Pagination_Success(t *testing.T) {
+	globals.SetGlobals()
+	defer func(v bool) { globals.PaginationLegacyPages = v }(globals.PaginationLegacyPages)
+	data := syntheticPosts(250)
+	pages := *convertResponsesToApiResponses(splitEntitiesToPages(&data))
+	if len(pages) != 3 {
+		t.Fatalf("250 posts should be split into 3 pages. Pages: %d", len(pages))
+	}
+	for i, _ := range pages {
+		p := pages[i].Pagination
+		if p.CurrentPage != uint64(i) || p.TotalPages != 3 || p.TotalEntities != 250 || p.PageSize != globals.EntityPageSizesObj.Posts {
+			t.Errorf("The page has the wrong pagination. Page: %d, Pagination: %#v", i, p)
+		}
+		if p.Pages != 2 {
+			t.Errorf("The legacy page count of a cache is the number of its last page. Pagination: %#v", p)
+		}
+		stampMultipartPage(&pages[i], i, len(pages))
+		if pages[i].Pagination.Pages != 3 || pages[i].Pagination.TotalEntities != 250 {
+			t.Errorf("The legacy page count of a POST response is the count of its pages. Pagination: %#v", pages[i].Pagination)
+		}
+	}
+	single := singularPostResponse(pages[0])
+	if single.Pagination.Pages != 0 || single.Pagination.TotalPages != 1 {
+		t.Errorf("A singular POST response has one page. Pagination: %#v", single.Pagination)
+	}
+	globals.PaginationLegacyPages = false
+	pages = *convertResponsesToApiResponses(splitEntitiesToPages(&data))
+	if pages[0].Pagination.Pages != 3 {
+		t.Errorf("Without the legacy page counts, the page count is the count of the pages. Pagination: %#v", pages[0].Pagination)
+	}
+}
+
+func TestPageRanges_Success(t *testing.T) {
+	defer func(v int) { globals.PageByteBudget = v }(globals.PageByteBudget)
+	cases := []struct {
+		name     string
+		budget   int
+		pageSize int
+		sizes    []int
+		expected []pageRange
+	}{
+		{"Empty", 100, 10, []int{}, []pageRange{{0, 0}}},
+		{"EmptyWithoutBudget", 0, 10, []int{}, []pageRange{{0, 0}}},
+		// The sizes of the entities are not read without a budget, and a count divisible by the page size still gets its empty last page, as before.
+		{"WithoutBudget", 0, 10, make([]int, 25), []pageRange{{0, 10}, {10, 20}, {20, 25}}},
+		{"WithoutBudgetDivisible", -1, 10, make([]int, 20), []pageRange{{0, 10}, {10, 20}, {20, 20}}},
+		{"EntityLargerThanBudget", 100, 10, []int{10, 500, 10}, []pageRange{{0, 1}, {1, 2}, {2, 3}}},
+		// Every entity takes 10 bytes with its comma, so the first three fill the budget exactly.
+		{"ExactlyAtBudget", 30, 10, []int{9, 9, 9, 9}, []pageRange{{0, 3}, {3, 4}}},
+		{"PageSizeUnderBudget", 1000, 2, []int{9, 9, 9, 9, 9}, []pageRange{{0, 2}, {2, 4}, {4, 5}}},
+		{"PageSizeAndBudget", 25, 2, []int{4, 4, 4, 20, 4}, []pageRange{{0, 2}, {2, 3}, {3, 4}, {4, 5}}},
+	}
+	for _, c := range cases {
+		globals.PageByteBudget = c.budget
+		sizes := c.sizes
+		ranges := pageRanges(len(sizes), c.pageSize, func(i int) int { return sizes[i] })
+		if len(ranges) != len(c.expected) {
+			t.Errorf("The entities were split into the wrong number of pages. Case: %s, Ranges: %v", c.name, ranges)
+			continue
+		}
+		for i, _ := range ranges {
+			if ranges[i] != c.expected[i] {
+				t.Errorf("The entities were split at the wrong places. Case: %s, Ranges: %v, Expected: %v", c.name, ranges, c.expected)
+				break
+			}
+		}
+	}
+}
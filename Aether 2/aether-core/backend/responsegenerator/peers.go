@@ -0,0 +1,62 @@
+// Backend > ResponseGenerator > Peers
+// This file selects the addresses that are given out in a peer exchange response.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+)
+
+// PeerKey is how an address is identified in the known_peers filter of a peers request.
+func PeerKey(a api.Address) string {
+	return fmt.Sprint(a.Location, "/", a.Sublocation, ":", a.Port)
+}
+
+// isPublicAddress checks whether the address is reachable from the outside. Private, loopback and link-local addresses only make sense within the network they were found in, so they are not shared.
+func isPublicAddress(a api.Address) bool {
+	ip := net.ParseIP(string(a.Location))
+	if ip == nil {
+		// This is a hostname. We can't tell without resolving it, and we don't want to resolve here.
+		return len(a.Location) > 0
+	}
+	return !(ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast())
+}
+
+// selectPeers picks a random sample out of the most recently online addresses, skipping the ones the requester already knows. The sampling means no single requester can walk the whole address table by asking repeatedly with the same filter.
+func selectPeers(known map[string]bool) ([]api.Address, error) {
+	onlineAfter := api.Timestamp(clock.Now().Add(-globals.PexMaxAge).Unix())
+	if cutoff := persistence.AddressLivenessCutoff(); cutoff > onlineAfter {
+		// The demoted addresses are not shared.
+		onlineAfter = cutoff
+	}
+	candidates, err := persistence.ReadPeerCandidates(onlineAfter, globals.PexCandidatePool)
+	if err != nil {
+		return []api.Address{}, err
+	}
+	return samplePeers(candidates, known), nil
+}
+
+// samplePeers gives at most PexSampleSize of the public candidates that are not known, chosen randomly, best first.
+func samplePeers(candidates []api.Address, known map[string]bool) []api.Address {
+	var eligible []api.Address
+	for i, _ := range candidates {
+		if !isPublicAddress(candidates[i]) || known[PeerKey(candidates[i])] {
+			continue
+		}
+		eligible = append(eligible, candidates[i])
+	}
+	rand.Shuffle(len(eligible), func(i, j int) { eligible[i], eligible[j] = eligible[j], eligible[i] })
+	if len(eligible) > globals.PexSampleSize {
+		eligible = eligible[:globals.PexSampleSize]
+	}
+	// Best first, so that a requester that only tries a few will try the best of the sample.
+	sort.Slice(eligible, func(i, j int) bool { return eligible[i].LastOnline > eligible[j].LastOnline })
+	return eligible
+}
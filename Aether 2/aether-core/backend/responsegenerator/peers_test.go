@@ -0,0 +1,107 @@
+// This test is in the package itself rather than in responsegenerator_test, since the address checks of the peer exchange are not exported. The sampling itself reads from the database, so only what decides its input is tested here.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"fmt"
+	"testing"
+)
+
+func TestPeerKey_Success(t *testing.T) {
+	a := api.Address{Location: "1.2.3.4", Sublocation: "sub", Port: 8001}
+	if k := PeerKey(a); k != "1.2.3.4/sub:8001" {
+		t.Errorf("The key should have the location, sublocation and port. Key: %s", k)
+	}
+	b := a
+	b.Port = 8002
+	if PeerKey(a) == PeerKey(b) {
+		t.Errorf("Addresses on different ports should have different keys.")
+	}
+}
+
+func TestIsPublicAddress_Success(t *testing.T) {
+	public := []api.Location{"8.8.8.8", "2001:4860:4860::8888", "example.com"}
+	for _, l := range public {
+		if !isPublicAddress(api.Address{Location: l}) {
+			t.Errorf("This address should be shared. Location: %s", l)
+		}
+	}
+}
+
+func TestIsPublicAddress_Fail_NotPublic(t *testing.T) {
+	notPublic := []api.Location{"", "10.0.0.1", "192.168.1.5", "172.16.3.4", "127.0.0.1", "::1", "169.254.1.1", "fe80::1", "0.0.0.0", "224.0.0.1"}
+	for _, l := range notPublic {
+		if isPublicAddress(api.Address{Location: l}) {
+			t.Errorf("This address should not be shared. Location: %s", l)
+		}
+	}
+}
+
+func TestProcessFilters_Success_KnownPeers(t *testing.T) {
+	globals.SetGlobals()
+	req := GeneratePrefilledApiResponse()
+	req.Filters = []api.Filter{{Type: "known_peers", Values: []string{"1.2.3.4/:8001", "5.6.7.8/:8001"}}}
+	fs := processFilters(req)
+	if len(fs.KnownPeers) != 2 || !fs.KnownPeers["1.2.3.4/:8001"] || !fs.KnownPeers["5.6.7.8/:8001"] {
+		t.Errorf("The known peers should be read from the filter. Known peers: %#v", fs.KnownPeers)
+	}
+}
+
+func TestProcessFilters_Success_KnownPeersCapped(t *testing.T) {
+	globals.SetGlobals()
+	var keys []string
+	for i := 0; i < globals.PexMaxExcludes+10; i++ {
+		keys = append(keys, fmt.Sprint("1.2.3.4/:", i))
+	}
+	req := GeneratePrefilledApiResponse()
+	req.Filters = []api.Filter{{Type: "known_peers", Values: keys}}
+	fs := processFilters(req)
+	if len(fs.KnownPeers) != globals.PexMaxExcludes {
+		t.Errorf("The known peers should be capped, so that a requester can't make the node hold an unbounded filter. Count: %d", len(fs.KnownPeers))
+	}
+	if fs.KnownPeers[keys[len(keys)-1]] {
+		t.Errorf("The known peers past the cap should be ignored.")
+	}
+}
+
+func TestSamplePeers_Success(t *testing.T) {
+	globals.SetGlobals()
+	var candidates []api.Address
+	for i := 0; i < globals.PexSampleSize*2; i++ {
+		candidates = append(candidates, api.Address{Location: api.Location(fmt.Sprint("8.8.", i/256, ".", i%256)), Port: 8001, LastOnline: api.Timestamp(1000 + i)})
+	}
+	candidates = append(candidates, api.Address{Location: "192.168.1.1", Port: 8001, LastOnline: 5000})
+	known := map[string]bool{PeerKey(candidates[len(candidates)-2]): true}
+	peers := samplePeers(candidates, known)
+	if len(peers) != globals.PexSampleSize {
+		t.Errorf("The sample should be capped at the sample size. Count: %d", len(peers))
+	}
+	for i, _ := range peers {
+		if known[PeerKey(peers[i])] {
+			t.Errorf("An address the requester knows should not be sent. Address: %#v", peers[i])
+		}
+		if peers[i].Location == "192.168.1.1" {
+			t.Errorf("A private address should not be sent.")
+		}
+		if i > 0 && peers[i].LastOnline > peers[i-1].LastOnline {
+			t.Errorf("The sample should be sorted best first.")
+		}
+	}
+}
+
+func TestSamplePeers_Success_Few(t *testing.T) {
+	globals.SetGlobals()
+	candidates := []api.Address{
+		{Location: "8.8.8.8", Port: 8001, LastOnline: 1},
+		{Location: "8.8.4.4", Port: 8001, LastOnline: 2},
+	}
+	peers := samplePeers(candidates, map[string]bool{})
+	if len(peers) != 2 || peers[0].Location != "8.8.4.4" {
+		t.Errorf("With fewer candidates than the sample size, all should be sent, best first. Peers: %#v", peers)
+	}
+	if len(samplePeers(candidates, map[string]bool{PeerKey(candidates[0]): true, PeerKey(candidates[1]): true})) != 0 {
+		t.Errorf("Nothing should be sent when the requester knows all candidates.")
+	}
+}
@@ -0,0 +1,134 @@
+// Backend > ResponseGenerator > Plan
+// This file estimates what a cache generation run will produce, without generating anything.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CachePlan is the estimate for a single cache. The counts come from COUNT queries, so entities that are dropped at generation time (i.e. for failing the PoW policy) are still counted here. The real cache can be a little smaller, but not larger. The pages are counted as the generation cuts them; with a page byte budget, this needs the sizes of the entities, so they are read through once.
+type CachePlan struct {
+	EntityType  string        `json:"entity_type"`
+	Start       api.Timestamp `json:"start"`
+	End         api.Timestamp `json:"end"`
+	EntityCount int           `json:"entity_count"`
+	EntityPages int           `json:"entity_pages"`
+	IndexPages  int           `json:"index_pages"` // Addresses are their own index, so they have none.
+}
+
+// GenerationPlan is the plan of a whole GenerateCaches run.
+type GenerationPlan struct {
+	Due    bool          `json:"due"` // Whether GenerateCaches would actually create caches if it ran now.
+	Start  api.Timestamp `json:"start"`
+	End    api.Timestamp `json:"end"`
+	Caches []CachePlan   `json:"caches"`
+}
+
+// plannedPages gives how many pages count entities take, as pageRanges cuts them. readSizes gives the sizes of the entities, and is only called if there is a page byte budget. If the entities changed since they were counted, the ones read are planned.
+func plannedPages(count int, pageSize int, readSizes func() ([]int, error)) (int, error) {
+	if pageSize <= 0 {
+		return 1, nil
+	}
+	if globals.PageByteBudget <= 0 {
+		return len(pageRanges(count, pageSize, nil)), nil
+	}
+	sizes, err := readSizes()
+	if err != nil {
+		return 0, err
+	}
+	return len(pageRanges(len(sizes), pageSize, func(i int) int { return sizes[i] })), nil
+}
+
+// PlanCache estimates the size of the cache CreateCache would create for the given entity type and time range. The range is taken as-is, as the cache generation takes it.
+func PlanCache(respType string, start api.Timestamp, end api.Timestamp) (CachePlan, error) {
+	var plan CachePlan
+	plan.EntityType = respType
+	endpoint, known := LookupEndpoint(respType)
+	switch {
+	case !known || !endpoint.Cached():
+		return plan, errors.New(fmt.Sprintf("The requested entity type is unknown to the cache generator. Entity type: %s", respType))
+	case endpoint.Provable:
+		pp, err := persistence.PlanRange(respType, start, end, entityPageSize(respType))
+		if err != nil {
+			return plan, err
+		}
+		plan.Start = pp.Begin
+		plan.End = pp.End
+		plan.EntityCount = pp.Count
+		entityPages, err2 := plannedPages(pp.Count, pp.PageSize, func() ([]int, error) { return readEntitySizes(pp, pp.Count) })
+		if err2 != nil {
+			return plan, err2
+		}
+		plan.EntityPages = entityPages
+		indexPages, err3 := plannedPages(pp.Count, indexPageSize(respType), func() ([]int, error) {
+			indexes, err4 := persistence.ReadIndexes(respType, pp.Begin, pp.End)
+			return indexSizes(&indexes), err4
+		})
+		if err3 != nil {
+			return plan, err3
+		}
+		plan.IndexPages = indexPages
+	default:
+		count, err := endpoint.CountRange(start, end)
+		if err != nil {
+			return plan, err
+		}
+		plan.Start = start
+		plan.End = end
+		plan.EntityCount = count
+		entityPages, err2 := plannedPages(count, entityPageSize(respType), func() ([]int, error) {
+			data, err3 := endpoint.ReadRange(start, end)
+			return entitySizes(&data), err3
+		})
+		if err2 != nil {
+			return plan, err2
+		}
+		plan.EntityPages = entityPages
+	}
+	return plan, nil
+}
+
+// PlanCaches produces the plan of the next GenerateCaches run. Nothing is written, so this is safe to call at any time.
+func PlanCaches() (GenerationPlan, error) {
+	var gp GenerationPlan
+	now := clock.Unix()
+	lastCacheGenTs := globals.LastCacheGenerationTimestamp
+	gp.Due = clock.Since(time.Unix(lastCacheGenTs, 0)) > 24*time.Hour
+	gp.Start = api.Timestamp(lastCacheGenTs)
+	gp.End = api.Timestamp(now)
+	for _, respType := range cacheEntityTypes {
+		cp, err := PlanCache(respType, gp.Start, gp.End)
+		if err != nil {
+			return gp, err
+		}
+		gp.Caches = append(gp.Caches, cp)
+	}
+	return gp, nil
+}
+
+// FormatPlan renders the plan in a human readable form, one cache per line.
+func FormatPlan(gp GenerationPlan) string {
+	out := fmt.Sprintf("Cache generation plan for %d - %d (due: %t)\n", gp.Start, gp.End, gp.Due)
+	for _, cp := range gp.Caches {
+		out = fmt.Sprint(out, fmt.Sprintf("  %-12s entities: %-8d entity pages: %-6d index pages: %d\n", cp.EntityType, cp.EntityCount, cp.EntityPages, cp.IndexPages))
+	}
+	return out
+}
+
+// logPlan logs the plan of the run that is about to start.
+func logPlan() {
+	gp, err := PlanCaches()
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The cache generation plan could not be created. Error: %s", err))
+		return
+	}
+	logging.Log(1, FormatPlan(gp))
+}
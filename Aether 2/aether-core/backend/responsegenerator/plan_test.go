@@ -0,0 +1,53 @@
+// This test is in the package itself rather than in responsegenerator_test, since the pages are planned by functions that are not exported.
+
+package responsegenerator
+
+import (
+	"aether-core/services/globals"
+	"errors"
+	"testing"
+)
+
+func TestPlannedPages_Success(t *testing.T) {
+	globals.SetGlobals()
+	defer func(v int) { globals.PageByteBudget = v }(globals.PageByteBudget)
+	pageSize := globals.EntityPageSizesObj.Posts
+	// A count divisible by the page size gets its empty last page too, under a budget that cuts the pages early, and without a budget.
+	for _, budget := range []int{0, 2000} {
+		for _, count := range []int{0, 1, pageSize, 2*pageSize + 7} {
+			globals.PageByteBudget = budget
+			data := syntheticPosts(count)
+			pages := splitEntitiesToPages(&data)
+			planned, err := plannedPages(count, pageSize, func() ([]int, error) { return entitySizes(&data), nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if planned != len(*pages) {
+				t.Errorf("The planned entity pages are not the pages generated. Budget: %d, Count: %d, Planned: %d, Generated: %d", budget, count, planned, len(*pages))
+			}
+			indexes := createIndexes(pages)
+			indexPages := splitEntityIndexesToPages(indexes)
+			plannedIndexes, err2 := plannedPages(count, globals.EntityPageSizesObj.PostIndexes, func() ([]int, error) { return indexSizes(indexes), nil })
+			if err2 != nil {
+				t.Fatal(err2)
+			}
+			if plannedIndexes != len(*indexPages) {
+				t.Errorf("The planned index pages are not the pages generated. Budget: %d, Count: %d, Planned: %d, Generated: %d", budget, count, plannedIndexes, len(*indexPages))
+			}
+		}
+	}
+}
+
+func TestPlannedPages_Fail(t *testing.T) {
+	globals.SetGlobals()
+	defer func(v int) { globals.PageByteBudget = v }(globals.PageByteBudget)
+	globals.PageByteBudget = 0
+	read := false
+	if _, err := plannedPages(10, 5, func() ([]int, error) { read = true; return nil, nil }); err != nil || read {
+		t.Errorf("The sizes should not be read without a budget. Read: %v, Error: %v", read, err)
+	}
+	globals.PageByteBudget = 100
+	if _, err := plannedPages(10, 5, func() ([]int, error) { return nil, errors.New("read failed") }); err == nil {
+		t.Errorf("An error reading the sizes should be returned.")
+	}
+}
@@ -0,0 +1,34 @@
+package responsegenerator_test
+
+import (
+	"aether-core/backend/responsegenerator"
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"testing"
+)
+
+// These are the fields of the requests and the responses of this node that privacy mode changes.
+func TestGeneratePrefilledApiResponse_Privacy_Success(t *testing.T) {
+	globals.AdvertisedEndpoints = []globals.AdvertisedEndpoint{{Location: "node.example.com", Port: 443, TLS: true}}
+	defer func() { globals.AdvertisedEndpoints = []globals.AdvertisedEndpoint{} }()
+	open := responsegenerator.GeneratePrefilledApiResponse()
+	if open.Address.Client.ClientName != globals.ClientName || len(open.Address.Endpoints) != 1 || api.IsUnlisted(open.Address) {
+		t.Fatalf("Outside privacy mode, the client and the endpoints should be given. Address: %#v", open.Address)
+	}
+	globals.PrivacyMode = true
+	defer func() { globals.PrivacyMode = false }()
+	private := responsegenerator.GeneratePrefilledApiResponse()
+	if private.Address.Client != (api.Client{}) {
+		t.Errorf("In privacy mode, the client name and version should be left out. Client: %#v", private.Address.Client)
+	}
+	if len(private.Address.Endpoints) != 0 {
+		t.Errorf("In privacy mode, the endpoints should not be advertised. Endpoints: %#v", private.Address.Endpoints)
+	}
+	if !api.IsUnlisted(private.Address) {
+		t.Errorf("In privacy mode, the remotes should be asked not to save the address. Extensions: %v", private.Address.Protocol.Extensions)
+	}
+	// What the remotes need to talk to the node stays.
+	if private.NodeId != open.NodeId || private.Address.Port != open.Address.Port || private.Address.Protocol.VersionMajor != open.Address.Protocol.VersionMajor || private.PoWPolicy != open.PoWPolicy {
+		t.Errorf("In privacy mode, the node id, the port, the protocol version and the proof of work policy should stay. Response: %#v", private)
+	}
+}
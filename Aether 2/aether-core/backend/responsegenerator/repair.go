@@ -0,0 +1,160 @@
+// Backend > ResponseGenerator > Repair
+// This file repairs the caches lazily. The pages of a cache can be lost or damaged on disk after they are written, and a remote asking for one of them would get a 404 or garbage. When a page is about to be served, it is checked against the manifest of its cache, and if it doesn't match, the cache is regenerated from the database for the time range the index gives it, as a new version of the cache, and the page is served from that.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The pages that were checked and found to match their manifest, by path, with the modification time and the size they had then. A page is checked again only if it changes.
+var checkedLock sync.Mutex
+var checkedPages = make(map[string]string)
+
+// The last time each cache was repaired, by entity type and cache name.
+var repairsLock sync.Mutex
+var lastRepairs = make(map[string]time.Time)
+
+func fileStamp(fi os.FileInfo) string {
+	return fmt.Sprint(fi.ModTime().UnixNano(), "-", fi.Size())
+}
+
+// findCacheLink gives the link to the cache in the index of the entity type.
+func findCacheLink(respType string, cacheName string) (api.ResultCache, bool, error) {
+	cacheIndex, err := readCacheIndex(respType)
+	if err != nil {
+		return api.ResultCache{}, false, err
+	}
+	for _, c := range cacheIndex.Results {
+		if c.ResponseUrl == cacheName {
+			return c, true, nil
+		}
+	}
+	return api.ResultCache{}, false, nil
+}
+
+// checkCachePage checks the page at the path under the folder of the cache, such as "3.json" or "index/0.json", against the manifest of the cache. The caches without a manifest in the index, and the paths that are not pages of the cache, can't be checked, and pass.
+func checkCachePage(respType string, cacheName string, page string) error {
+	path := fmt.Sprint(globals.CachesLocation, "/", respType, "/", cacheName, "/", page)
+	fi, statErr := os.Stat(path)
+	if statErr == nil {
+		checkedLock.Lock()
+		checked := checkedPages[path] == fileStamp(fi)
+		checkedLock.Unlock()
+		if checked {
+			return nil
+		}
+	}
+	link, found, err := findCacheLink(respType, cacheName)
+	if err != nil || !found || len(link.Manifest) == 0 {
+		return nil
+	}
+	manifestData, err2 := ioutil.ReadFile(fmt.Sprint(globals.CachesLocation, "/", respType, "/", cacheName, "/", api.ManifestFile))
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The manifest of the cache could not be read. Error: %s", err2))
+	}
+	m, err3 := api.ParseManifest(manifestData, link.Manifest)
+	if err3 != nil {
+		return err3
+	}
+	listed, ok := m.Page(page)
+	if page != api.ManifestFile && !ok {
+		return nil
+	}
+	if page != api.ManifestFile {
+		data, err4 := ioutil.ReadFile(path)
+		if err4 != nil {
+			return errors.New(fmt.Sprintf("The page listed in the manifest could not be read. Page: %s, Error: %s", page, err4))
+		}
+		err5 := listed.Verify(data)
+		if err5 != nil {
+			return err5
+		}
+	}
+	if statErr == nil {
+		checkedLock.Lock()
+		checkedPages[path] = fileStamp(fi)
+		checkedLock.Unlock()
+	}
+	return nil
+}
+
+// EnsureCachePage checks the file of the caches at the path, such as "posts/cache_x/3.json", before it is served, and if it is a page of a cache that is missing or doesn't match the manifest of its cache, regenerates the cache. It gives the path to serve, which is that of the page in the regenerated cache if there is one.
+func EnsureCachePage(path string) (string, error) {
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) != 3 || !isCacheEntityType(parts[0]) || !isValidCacheName(parts[1]) {
+		return path, nil
+	}
+	respType, cacheName, page := parts[0], parts[1], parts[2]
+	noteCacheServed(respType, cacheName)
+	if !globals.LazyCacheRepair || isArchivedCache(respType, cacheName) {
+		// An archived cache is served from its archive, which was read back in full when it was written.
+		return path, nil
+	}
+	problem := checkCachePage(respType, cacheName, page)
+	if problem == nil {
+		return path, nil
+	}
+	key := fmt.Sprint(respType, "/", cacheName)
+	repairsLock.Lock()
+	last, repairedBefore := lastRepairs[key]
+	if repairedBefore && clock.Since(last) < globals.CacheRepairCooldown {
+		repairsLock.Unlock()
+		return path, errors.New(fmt.Sprintf("A page of the cache is broken, but the cache was repaired less than %s ago, so it is served as it is. Cache: %s, Page: %s, Problem: %s", globals.CacheRepairCooldown, key, page, problem))
+	}
+	lastRepairs[key] = clock.Now()
+	repairsLock.Unlock()
+	logging.Log(1, fmt.Sprintf("A page of the cache is broken, the cache will be regenerated from the database. Cache: %s, Page: %s, Problem: %s", key, page, problem))
+	newName, err := RepairCache(respType, cacheName)
+	if err != nil {
+		return path, err
+	}
+	logging.Log(1, fmt.Sprintf("The cache was regenerated. Cache: %s, New version: %s", key, newName))
+	return fmt.Sprint(respType, "/", newName, "/", page), nil
+}
+
+// RepairCache regenerates the cache from the database, for the time range the index gives it, as the next version of the cache, switches the index over to it, and retires the broken one. It gives the name of the new version.
+func RepairCache(respType string, cacheName string) (string, error) {
+	if !isCacheEntityType(respType) || !isValidCacheName(cacheName) {
+		return "", errors.New(fmt.Sprintf("This is not a cache of the node. Entity type: %s, Cache name: %s", respType, cacheName))
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	cacheIndex, err := readCacheIndex(respType)
+	if err != nil {
+		return "", err
+	}
+	entry := -1
+	for i, _ := range cacheIndex.Results {
+		if cacheIndex.Results[i].ResponseUrl == cacheName {
+			entry = i
+		}
+	}
+	if entry == -1 {
+		return "", errors.New(fmt.Sprintf("The cache is not in the index, so its time range is not known. Entity type: %s, Cache name: %s", respType, cacheName))
+	}
+	link := cacheIndex.Results[entry]
+	newName := nextCacheVersion(cacheName)
+	os.RemoveAll(fmt.Sprint(globals.CachesLocation, "/", respType, "/", newName))
+	cacheData, err2 := bakeCache(respType, link.StartsFrom, link.EndsAt, newName)
+	if err2 != nil {
+		return "", err2
+	}
+	// The new version takes the place of the old one in the index, with the hashes of its own pages, and its own mirror.
+	cacheIndex.Results[entry] = cacheLink(&cacheData)
+	err3 := switchCaches(respType, &cacheIndex, []string{cacheName})
+	if err3 != nil {
+		return "", err3
+	}
+	return newName, nil
+}
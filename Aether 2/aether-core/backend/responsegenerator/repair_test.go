@@ -0,0 +1,85 @@
+// This test is in the package itself rather than in responsegenerator_test, since the cache it checks is saved with functions that are not exported. Regenerating the cache needs the database, so only the checks are tested here.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// saveRepairTestCache saves a cache of posts under a temporary caches location, with its link in the index, and gives its name.
+func saveRepairTestCache(t *testing.T) string {
+	globals.SetGlobals()
+	globals.SignResponses = false
+	dir, err := ioutil.TempDir("", "aether-repair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals.CachesLocation = dir
+	data := syntheticPosts(300)
+	pages := splitEntitiesToPages(&data)
+	cacheData, err2 := buildCacheResponse(pages, createIndexes(pages), 1500000000, 1500086400)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	err3 := saveCacheToDisk(filepath.Join(dir, "posts"), &cacheData, "posts")
+	if err3 != nil {
+		t.Fatal(err3)
+	}
+	cacheIndex := *GeneratePrefilledApiResponse()
+	updateCacheIndex(&cacheIndex, &cacheData)
+	err4 := writeCacheIndex("posts", &cacheIndex)
+	if err4 != nil {
+		t.Fatal(err4)
+	}
+	return cacheData.cacheName
+}
+
+func TestCheckCachePage_Success(t *testing.T) {
+	cacheName := saveRepairTestCache(t)
+	defer os.RemoveAll(globals.CachesLocation)
+	for _, page := range []string{"0.json", "2.json", "index/0.json", api.ManifestFile, "7.json"} {
+		if err := checkCachePage("posts", cacheName, page); err != nil {
+			t.Errorf("An intact page, or a path that is not a page of the cache, should pass. Page: %s, Error: %s", page, err)
+		}
+	}
+	path := fmt.Sprint("posts/", cacheName, "/1.json")
+	served, err := EnsureCachePage(path)
+	if served != path || err != nil {
+		t.Errorf("An intact page should be served as it is. Error: %v", err)
+	}
+}
+
+func TestCheckCachePage_Fail(t *testing.T) {
+	cacheName := saveRepairTestCache(t)
+	defer os.RemoveAll(globals.CachesLocation)
+	cacheDir := filepath.Join(globals.CachesLocation, "posts", cacheName)
+	// Checked once, and then damaged: the check has to notice the change.
+	checkCachePage("posts", cacheName, "1.json")
+	ioutil.WriteFile(filepath.Join(cacheDir, "1.json"), []byte("{}"), 0755)
+	if err := checkCachePage("posts", cacheName, "1.json"); err == nil {
+		t.Errorf("A page that doesn't match the manifest should be caught.")
+	}
+	os.Remove(filepath.Join(cacheDir, "index", "0.json"))
+	if err := checkCachePage("posts", cacheName, "index/0.json"); err == nil {
+		t.Errorf("A page listed in the manifest that is missing should be caught.")
+	}
+	os.Remove(filepath.Join(cacheDir, api.ManifestFile))
+	if err := checkCachePage("posts", cacheName, "0.json"); err == nil {
+		t.Errorf("A cache whose manifest is missing should be caught.")
+	}
+	// The cache was just repaired, so it is not regenerated again.
+	lastRepairs[fmt.Sprint("posts/", cacheName)] = clock.Now()
+	path := fmt.Sprint("posts/", cacheName, "/1.json")
+	served, err := EnsureCachePage(path)
+	if served != path || err == nil || !strings.Contains(err.Error(), "repaired less than") {
+		t.Errorf("A cache repaired within the cooldown should not be regenerated again. Error: %v", err)
+	}
+}
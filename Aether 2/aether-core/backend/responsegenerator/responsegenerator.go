@@ -4,11 +4,17 @@
 package responsegenerator
 
 import (
+	"aether-core/services/clock"
 	// "fmt"
+	"aether-core/backend/cdn"
+	"aether-core/backend/syncpolicy"
 	"aether-core/io/api"
 	"aether-core/io/persistence"
 	"aether-core/services/globals"
 	"aether-core/services/logging"
+	"aether-core/services/membership"
+	"aether-core/services/verify"
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/json"
@@ -26,18 +32,87 @@ import (
 func GeneratePrefilledApiResponse() *api.ApiResponse {
 	var resp api.ApiResponse
 	resp.NodeId = api.Fingerprint(globals.NodeId)
+	resp.NetworkId = globals.NetworkId
+	resp.MembershipProof = membership.CreateProof(globals.NodeId, clock.Now())
 	resp.Address.LocationType = uint8(globals.AddressType)
 	resp.Address.Port = uint16(globals.AddressPort)
 	resp.Address.Protocol.VersionMajor = uint8(globals.ProtocolVersionMajor)
 	resp.Address.Protocol.VersionMinor = uint16(globals.ProtocolVersionMinor)
-	resp.Address.Protocol.Extensions = globals.ProtocolExtensions
-	resp.Address.Client.VersionMajor = uint8(globals.ClientVersionMajor)
-	resp.Address.Client.VersionMinor = uint16(globals.ClientVersionMinor)
-	resp.Address.Client.VersionPatch = uint16(globals.ClientVersionPatch)
-	resp.Address.Client.ClientName = globals.ClientName
+	resp.Address.Protocol.Extensions = append([]string{}, globals.ProtocolExtensions...)
+	if globals.SignResponses {
+		resp.Address.Protocol.Extensions = append(resp.Address.Protocol.Extensions, api.SignedResponsesExtension)
+	}
+	if globals.WitnessEnabled {
+		resp.Address.Protocol.Extensions = append(resp.Address.Protocol.Extensions, api.WitnessExtension)
+	}
+	if globals.SessionDigestsEnabled {
+		resp.Address.Protocol.Extensions = append(resp.Address.Protocol.Extensions, api.SessionDigestExtension)
+	}
+	if globals.HandshakeEnabled {
+		resp.Address.Protocol.Extensions = append(resp.Address.Protocol.Extensions, api.HandshakeExtension)
+	}
+	if globals.FingerprintManifestsEnabled {
+		resp.Address.Protocol.Extensions = append(resp.Address.Protocol.Extensions, api.FingerprintsExtension)
+	}
+	if globals.CachePageRangeMaxPages > 0 {
+		resp.Address.Protocol.Extensions = append(resp.Address.Protocol.Extensions, api.PageRangesExtension)
+	}
+	// In privacy mode, the client and the endpoints are left out, and the remotes are asked not to save the address.
+	if globals.PrivacyMode {
+		resp.Address.Protocol.Extensions = append(resp.Address.Protocol.Extensions, api.UnlistedExtension)
+	} else {
+		resp.Address.Client.VersionMajor = uint8(globals.ClientVersionMajor)
+		resp.Address.Client.VersionMinor = uint16(globals.ClientVersionMinor)
+		resp.Address.Client.VersionPatch = uint16(globals.ClientVersionPatch)
+		resp.Address.Client.ClientName = globals.ClientName
+		for _, e := range globals.AdvertisedEndpoints {
+			resp.Address.Endpoints = append(resp.Address.Endpoints, api.AddressEndpoint{
+				Location:     api.Location(e.Location),
+				Sublocation:  api.Location(e.Sublocation),
+				LocationType: e.LocationType,
+				Port:         e.Port,
+				TLS:          e.TLS,
+				Priority:     e.Priority,
+			})
+		}
+	}
+	// Advertise the minimum PoW this node accepts, so remotes know what will be refused here.
+	resp.PoWPolicy.Board = globals.MinPoWStrengths.Board
+	resp.PoWPolicy.BoardUpdate = globals.MinPoWStrengths.BoardUpdate
+	resp.PoWPolicy.Thread = globals.MinPoWStrengths.Thread
+	resp.PoWPolicy.Post = globals.MinPoWStrengths.Post
+	resp.PoWPolicy.Vote = globals.MinPoWStrengths.Vote
+	resp.PoWPolicy.VoteUpdate = globals.MinPoWStrengths.VoteUpdate
+	resp.PoWPolicy.Key = globals.MinPoWStrengths.Key
+	resp.PoWPolicy.KeyUpdate = globals.MinPoWStrengths.KeyUpdate
+	resp.PoWPolicy.Truststate = globals.MinPoWStrengths.Truststate
+	resp.PoWPolicy.TruststateUpdate = globals.MinPoWStrengths.TruststateUpdate
+	resp.PoWPolicy.Tombstone = globals.MinPoWStrengths.Tombstone
 	return &resp
 }
 
+// signForEveryone signs the response with the node key, if responses are signed, and removes its signature otherwise.
+func signForEveryone(resp *api.ApiResponse) error {
+	if globals.SignResponses {
+		err := api.SignResponse(resp)
+		if err != nil {
+			return errors.New(fmt.Sprintf("This ApiResponse could not be signed. Error: %s", err))
+		}
+	} else {
+		api.UnsignResponse(resp)
+	}
+	return nil
+}
+
+// ConvertSignedApiResponseToJson signs the response with the node key, if responses are signed, and converts it to compact JSON. This is for what is served to everyone, like the caches, and not bound to a request. What the node writes and sends goes through EncodeSignedResponse instead, which uses the encoder of its destination.
+func ConvertSignedApiResponseToJson(resp *api.ApiResponse) ([]byte, error) {
+	err := signForEveryone(resp)
+	if err != nil {
+		return []byte{}, err
+	}
+	return ConvertApiResponseToJson(resp)
+}
+
 func ConvertApiResponseToJson(resp *api.ApiResponse) ([]byte, error) {
 	result, err := json.Marshal(resp)
 	var jsonErr error
@@ -53,23 +128,100 @@ type FilterSet struct {
 	TimeStart    api.Timestamp
 	TimeEnd      api.Timestamp
 	Embeds       []string
+	KnownPeers   map[string]bool // Addresses the requester already knows, as PeerKey values. Only used by the peers response.
+	CursorMode   bool            // The requester wants a single page after Cursor, instead of all pages.
+	Cursor       string
+	Languages    []string          // Normalised language tags. If given, only the boards and the threads in these languages are returned.
+	Boards       []api.Fingerprint // If given, only the threads, the posts and the votes in these boards are returned.
+	Witness      [][]string        // The values of the witness filters, a cache to sign in each. Only used by the witness response.
+	MaxInline    int64             // The most the requester wants sent inline in a POST response, in bytes. -1 if it didn't say.
+	TraceId      string            // The sync session the request is a part of, for its digest.
+	Requester    api.Fingerprint   // The node id of the requester.
+	Handshake    *api.Handshake    // The handshake of the requester. Only used by the handshake response.
+	Fields       []string          // The fields of the entities the requester wants, if not all of them. See fields.go in the api package.
+	Window       string            // The window the time range was worked out from, if it was given by its name. See window.go.
+	EntityTypes  []string          // The entity types the requester wants the fingerprints of. Only used by the fingerprints response.
 }
 
 func processFilters(req *api.ApiResponse) FilterSet {
 	var fs FilterSet
+	fs.KnownPeers = make(map[string]bool)
+	fs.MaxInline = -1
+	fs.TraceId = req.TraceId
+	fs.Requester = req.NodeId
+	fs.Handshake = req.Handshake
 	for _, filter := range req.Filters {
+		// Known peers
+		if filter.Type == "known_peers" {
+			for i, key := range filter.Values {
+				if i >= globals.PexMaxExcludes {
+					break
+				}
+				fs.KnownPeers[key] = true
+			}
+		}
+		// Cursor. An empty cursor value starts from the beginning of the time range.
+		if filter.Type == "cursor" {
+			fs.CursorMode = true
+			if len(filter.Values) > 0 {
+				fs.Cursor = filter.Values[0]
+			}
+		}
 		// Fingerprint
 		if filter.Type == "fingerprint" {
 			for _, fp := range filter.Values {
 				fs.Fingerprints = append(fs.Fingerprints, api.Fingerprint(fp))
 			}
 		}
+		// Language
+		if filter.Type == "language" {
+			fs.Languages = append(fs.Languages, normaliseLanguages(filter.Values)...)
+		}
+		// Board
+		if filter.Type == "board" {
+			for _, fp := range filter.Values {
+				fs.Boards = append(fs.Boards, api.Fingerprint(fp))
+			}
+		}
+		// Witness
+		if filter.Type == "witness" {
+			fs.Witness = append(fs.Witness, filter.Values)
+		}
+		// Max inline. Values that are not a size in bytes are ignored.
+		if filter.Type == "max_inline" && len(filter.Values) > 0 {
+			if max, err := strconv.ParseInt(filter.Values[0], 10, 64); err == nil && max >= 0 {
+				fs.MaxInline = max
+			}
+		}
+		// Fields
+		if filter.Type == api.FieldsFilter {
+			fs.Fields = api.NormaliseFields(filter.Values)
+		}
+		// Entity types
+		if filter.Type == api.EntityTypeFilter {
+			fs.EntityTypes = append(fs.EntityTypes, filter.Values...)
+		}
 		// Embeds
 		if filter.Type == "embed" {
 			for _, embed := range filter.Values {
 				fs.Embeds = append(fs.Embeds, embed)
 			}
 		}
+		// Window. The time range is worked out here, and the one of a timestamp filter after it is used instead. A window that is not known is ignored, as if no time range was given.
+		if filter.Type == api.WindowFilter && len(filter.Values) > 0 {
+			zone := ""
+			if len(filter.Values) > 1 {
+				zone = filter.Values[1]
+			}
+			start, err := resolveWindow(filter.Values[0], zone, clock.Now())
+			if err != nil {
+				logging.LogSampledTrace(fs.TraceId, "responsegenerator", "unknown-window", 2, fmt.Sprintf("The window filter of the request is ignored. Error: %s", err))
+			} else {
+				fs.Window = filter.Values[0]
+				fs.TimeStart = start
+				fs.TimeEnd = 0
+			}
+		}
 		// If a time filter is given, timeStart is either the timestamp provided by the remote if it's larger than the end date of the last cache, or the end timestamp of the last cache.
 		// In essence, we do not provide anything that is already cached from the live server.
 		if filter.Type == "timestamp" {
@@ -82,6 +234,7 @@ func processFilters(req *api.ApiResponse) FilterSet {
 			if start > 0 || end > 0 {
 				fs.TimeStart = api.Timestamp(start)
 				fs.TimeEnd = api.Timestamp(end)
+				fs.Window = ""
 			}
 
 		}
@@ -89,6 +242,50 @@ func processFilters(req *api.ApiResponse) FilterSet {
 	return fs
 }
 
+// pageRange is the part of an entity list that goes into one page.
+type pageRange struct {
+	beg int
+	end int
+}
+
+// pageRanges splits the given number of entities into pages of at most pageSize entities. If there is a page byte budget, a page is also closed when the next entity would take it over the budget; sizeOf gives the size of the entity at the given position. An entity larger than the whole budget still gets a page of its own.
+func pageRanges(count int, pageSize int, sizeOf func(int) int) []pageRange {
+	var ranges []pageRange
+	if globals.PageByteBudget <= 0 {
+		numPages := count/pageSize + 1
+		// The division above is floored.
+		for i := 0; i < numPages; i++ {
+			beg := i * pageSize
+			end := (i + 1) * pageSize
+			// This is to protect from 'slice bounds out of range'
+			if end > count {
+				end = count
+			}
+			ranges = append(ranges, pageRange{beg, end})
+		}
+		return ranges
+	}
+	beg := 0
+	size := 0
+	for i := 0; i < count; i++ {
+		s := sizeOf(i) + 1 // +1 for the comma between the entities.
+		if i > beg && (i-beg >= pageSize || size+s > globals.PageByteBudget) {
+			ranges = append(ranges, pageRange{beg, i})
+			beg = i
+			size = 0
+		}
+		size += s
+	}
+	ranges = append(ranges, pageRange{beg, count})
+	return ranges
+}
+
+// entitySize is the size of the entity in JSON, as it will be in the page.
+func entitySize(entity interface{}) int {
+	data, _ := json.Marshal(entity)
+	return len(data)
+}
+
 func splitEntityIndexesToPages(fullData *api.Response) *[]api.Response {
 	var entityTypes []string
 	if len(fullData.BoardIndexes) > 0 {
@@ -112,6 +309,9 @@ func splitEntityIndexesToPages(fullData *api.Response) *[]api.Response {
 	if len(fullData.TruststateIndexes) > 0 {
 		entityTypes = append(entityTypes, "truststateindexes")
 	}
+	if len(fullData.TombstoneIndexes) > 0 {
+		entityTypes = append(entityTypes, "tombstoneindexes")
+	}
 
 	var pages []api.Response
 	// This is a lot of copy paste. This is because there is no automatic conversion from []api.Boards being recognised as []api.Provable. Without that, I have to convert them explicitly to be able to put them into a map[string:struct] which is a lot of extra work - more work than copy paste.
@@ -120,18 +320,9 @@ func splitEntityIndexesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "boardindexes" {
 			dataSet := fullData.BoardIndexes
 			pageSize := globals.EntityPageSizesObj.BoardIndexes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.BoardIndexes = pageData
 				pages = append(pages, page)
@@ -140,18 +331,9 @@ func splitEntityIndexesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "threadindexes" {
 			dataSet := fullData.ThreadIndexes
 			pageSize := globals.EntityPageSizesObj.ThreadIndexes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.ThreadIndexes = pageData
 				pages = append(pages, page)
@@ -160,18 +342,9 @@ func splitEntityIndexesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "postindexes" {
 			dataSet := fullData.PostIndexes
 			pageSize := globals.EntityPageSizesObj.PostIndexes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.PostIndexes = pageData
 				pages = append(pages, page)
@@ -180,18 +353,9 @@ func splitEntityIndexesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "voteindexes" {
 			dataSet := fullData.VoteIndexes
 			pageSize := globals.EntityPageSizesObj.VoteIndexes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.VoteIndexes = pageData
 				pages = append(pages, page)
@@ -200,18 +364,9 @@ func splitEntityIndexesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "keyindexes" {
 			dataSet := fullData.KeyIndexes
 			pageSize := globals.EntityPageSizesObj.KeyIndexes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.KeyIndexes = pageData
 				pages = append(pages, page)
@@ -220,18 +375,9 @@ func splitEntityIndexesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "addressindexes" {
 			dataSet := fullData.AddressIndexes
 			pageSize := globals.EntityPageSizesObj.AddressIndexes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.AddressIndexes = pageData
 				pages = append(pages, page)
@@ -240,23 +386,25 @@ func splitEntityIndexesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "truststateindexes" {
 			dataSet := fullData.TruststateIndexes
 			pageSize := globals.EntityPageSizesObj.TruststateIndexes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.TruststateIndexes = pageData
 				pages = append(pages, page)
 			}
 		}
+		if entityTypes[i] == "tombstoneindexes" {
+			dataSet := fullData.TombstoneIndexes
+			pageSize := globals.EntityPageSizesObj.TombstoneIndexes
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
+				var page api.Response
+				page.TombstoneIndexes = pageData
+				pages = append(pages, page)
+			}
+		}
 	}
 	if len(entityTypes) == 0 {
 		// The result is empty
@@ -308,9 +456,15 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 	if len(fullData.Truststates) > 0 {
 		entityTypes = append(entityTypes, "truststates")
 	}
+	if len(fullData.Tombstones) > 0 {
+		entityTypes = append(entityTypes, "tombstones")
+	}
 	if len(fullData.TruststateIndexes) > 0 {
 		entityTypes = append(entityTypes, "truststateindexes")
 	}
+	if len(fullData.TombstoneIndexes) > 0 {
+		entityTypes = append(entityTypes, "tombstoneindexes")
+	}
 
 	var pages []api.Response
 	// This is a lot of copy paste. This is because there is no automatic conversion from []api.Boards being recognised as []api.Provable. Without that, I have to convert them explicitly to be able to put them into a map[string:struct] which is a lot of extra work - more work than copy paste.
@@ -318,18 +472,9 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "boards" {
 			dataSet := fullData.Boards
 			pageSize := globals.EntityPageSizesObj.Boards
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.Boards = pageData
 				pages = append(pages, page)
@@ -338,18 +483,9 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "threads" {
 			dataSet := fullData.Threads
 			pageSize := globals.EntityPageSizesObj.Threads
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.Threads = pageData
 				pages = append(pages, page)
@@ -358,18 +494,9 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "posts" {
 			dataSet := fullData.Posts
 			pageSize := globals.EntityPageSizesObj.Posts
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.Posts = pageData
 				pages = append(pages, page)
@@ -378,18 +505,9 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "votes" {
 			dataSet := fullData.Votes
 			pageSize := globals.EntityPageSizesObj.Votes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.Votes = pageData
 				pages = append(pages, page)
@@ -398,18 +516,9 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "addresses" {
 			dataSet := fullData.Addresses
 			pageSize := globals.EntityPageSizesObj.Addresses
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.Addresses = pageData
 				pages = append(pages, page)
@@ -418,18 +527,9 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "keys" {
 			dataSet := fullData.Keys
 			pageSize := globals.EntityPageSizesObj.Keys
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.Keys = pageData
 				pages = append(pages, page)
@@ -438,39 +538,32 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "truststates" {
 			dataSet := fullData.Truststates
 			pageSize := globals.EntityPageSizesObj.Truststates
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.Truststates = pageData
 				pages = append(pages, page)
 			}
 		}
+		if entityTypes[i] == "tombstones" {
+			dataSet := fullData.Tombstones
+			pageSize := globals.EntityPageSizesObj.Tombstones
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
+				var page api.Response
+				page.Tombstones = pageData
+				pages = append(pages, page)
+			}
+		}
 		// Index entities
 		if entityTypes[i] == "boardindexes" {
 			dataSet := fullData.BoardIndexes
 			pageSize := globals.EntityPageSizesObj.BoardIndexes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.BoardIndexes = pageData
 				pages = append(pages, page)
@@ -479,18 +572,9 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "threadindexes" {
 			dataSet := fullData.ThreadIndexes
 			pageSize := globals.EntityPageSizesObj.ThreadIndexes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.ThreadIndexes = pageData
 				pages = append(pages, page)
@@ -499,18 +583,9 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "postindexes" {
 			dataSet := fullData.PostIndexes
 			pageSize := globals.EntityPageSizesObj.PostIndexes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.PostIndexes = pageData
 				pages = append(pages, page)
@@ -519,18 +594,9 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "voteindexes" {
 			dataSet := fullData.VoteIndexes
 			pageSize := globals.EntityPageSizesObj.VoteIndexes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.VoteIndexes = pageData
 				pages = append(pages, page)
@@ -539,18 +605,9 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "keyindexes" {
 			dataSet := fullData.KeyIndexes
 			pageSize := globals.EntityPageSizesObj.KeyIndexes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.KeyIndexes = pageData
 				pages = append(pages, page)
@@ -559,18 +616,9 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "addressindexes" {
 			dataSet := fullData.AddressIndexes
 			pageSize := globals.EntityPageSizesObj.AddressIndexes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.AddressIndexes = pageData
 				pages = append(pages, page)
@@ -579,23 +627,25 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 		if entityTypes[i] == "truststateindexes" {
 			dataSet := fullData.TruststateIndexes
 			pageSize := globals.EntityPageSizesObj.TruststateIndexes
-			numPages := len(dataSet)/pageSize + 1
-			// The division above is floored.
-			for i := 0; i < numPages; i++ {
-				beg := i * pageSize
-				var end int
-				// This is to protect from 'slice bounds out of range'
-				if (i+1)*pageSize > len(dataSet) {
-					end = len(dataSet)
-				} else {
-					end = (i + 1) * pageSize
-				}
-				pageData := dataSet[beg:end]
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
 				var page api.Response
 				page.TruststateIndexes = pageData
 				pages = append(pages, page)
 			}
 		}
+		if entityTypes[i] == "tombstoneindexes" {
+			dataSet := fullData.TombstoneIndexes
+			pageSize := globals.EntityPageSizesObj.TombstoneIndexes
+			sizeOf := func(j int) int { return entitySize(dataSet[j]) }
+			for _, r := range pageRanges(len(dataSet), pageSize, sizeOf) {
+				pageData := dataSet[r.beg:r.end]
+				var page api.Response
+				page.TombstoneIndexes = pageData
+				pages = append(pages, page)
+			}
+		}
 	}
 	if len(entityTypes) == 0 {
 		// The result is empty
@@ -607,30 +657,40 @@ func splitEntitiesToPages(fullData *api.Response) *[]api.Response {
 
 func convertResponsesToApiResponses(r *[]api.Response) *[]api.ApiResponse {
 	var responses []api.ApiResponse
+	totalEntities, pageSize := paginationTotals(r)
 	for i, _ := range *r {
-		resp := GeneratePrefilledApiResponse()
-		resp.ResponseBody.Boards = (*r)[i].Boards
-		resp.ResponseBody.Threads = (*r)[i].Threads
-		resp.ResponseBody.Posts = (*r)[i].Posts
-		resp.ResponseBody.Votes = (*r)[i].Votes
-		resp.ResponseBody.Addresses = (*r)[i].Addresses
-		resp.ResponseBody.Keys = (*r)[i].Keys
-		resp.ResponseBody.Truststates = (*r)[i].Truststates
-		// Indexes
-		resp.ResponseBody.BoardIndexes = (*r)[i].BoardIndexes
-		resp.ResponseBody.ThreadIndexes = (*r)[i].ThreadIndexes
-		resp.ResponseBody.PostIndexes = (*r)[i].PostIndexes
-		resp.ResponseBody.VoteIndexes = (*r)[i].VoteIndexes
-		resp.ResponseBody.AddressIndexes = (*r)[i].AddressIndexes
-		resp.ResponseBody.KeyIndexes = (*r)[i].KeyIndexes
-		resp.ResponseBody.TruststateIndexes = (*r)[i].TruststateIndexes
-		resp.Pagination.Pages = uint64(len(*r) - 1) // pagination starts from 0
-		resp.Pagination.CurrentPage = uint64(i)
-		responses = append(responses, *resp)
+		responses = append(responses, convertResponseToApiResponse(&(*r)[i], i, len(*r), totalEntities, pageSize))
 	}
 	return &responses
 }
 
+// convertResponseToApiResponse converts one page of a set of pageCount pages, with the totals of the whole set. The pages that are read one at a time, as the ones of a streamed cache, are converted with this as they are read.
+func convertResponseToApiResponse(r *api.Response, pageNum int, pageCount int, totalEntities uint64, pageSize int) api.ApiResponse {
+	resp := GeneratePrefilledApiResponse()
+	resp.ResponseBody.Boards = r.Boards
+	resp.ResponseBody.Threads = r.Threads
+	resp.ResponseBody.Posts = r.Posts
+	resp.ResponseBody.Votes = r.Votes
+	resp.ResponseBody.Addresses = r.Addresses
+	resp.ResponseBody.Keys = r.Keys
+	resp.ResponseBody.Truststates = r.Truststates
+	resp.ResponseBody.Tombstones = r.Tombstones
+	// Indexes
+	resp.ResponseBody.BoardIndexes = r.BoardIndexes
+	resp.ResponseBody.ThreadIndexes = r.ThreadIndexes
+	resp.ResponseBody.PostIndexes = r.PostIndexes
+	resp.ResponseBody.VoteIndexes = r.VoteIndexes
+	resp.ResponseBody.AddressIndexes = r.AddressIndexes
+	resp.ResponseBody.KeyIndexes = r.KeyIndexes
+	resp.ResponseBody.TruststateIndexes = r.TruststateIndexes
+	resp.ResponseBody.TombstoneIndexes = r.TombstoneIndexes
+	// The caches gave the number of their last page as their page count.
+	stampPagination(&resp.Pagination, pageNum, pageCount, pageCount-1)
+	resp.Pagination.TotalEntities = totalEntities
+	resp.Pagination.PageSize = pageSize
+	return *resp
+}
+
 func generateRandomHash() (string, error) {
 	const LETTERS = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	saltBytes := make([]byte, 16)
@@ -650,7 +710,7 @@ func generateRandomHash() (string, error) {
 
 func generateExpiryTimestamp() int64 {
 	expiry := time.Duration(globals.PostResponseExpiryMinutes) * time.Minute
-	expiryTs := int64(time.Now().Add(expiry).Unix())
+	expiryTs := clock.ExpiryUnix(expiry)
 	return expiryTs
 }
 
@@ -676,6 +736,9 @@ func findEntityInApiResponse(resp api.ApiResponse) string {
 	if len(resp.ResponseBody.Truststates) > 0 {
 		return "truststates"
 	}
+	if len(resp.ResponseBody.Tombstones) > 0 {
+		return "tombstones"
+	}
 	return ""
 }
 
@@ -683,116 +746,286 @@ func createPath(path string) {
 	os.MkdirAll(path, 0755)
 }
 
-func saveFileToDisk(fileContents []byte, path string, filename string) {
-	ioutil.WriteFile(fmt.Sprint(path, "/", filename), fileContents, 0755)
+// saveFileToDisk writes a file, and gives the error if it could not be written, so that a file cut short by a full disk is not taken for a whole one.
+func saveFileToDisk(fileContents []byte, path string, filename string) error {
+	file := fmt.Sprint(path, "/", filename)
+	return noteWriteError(file, ioutil.WriteFile(file, fileContents, 0755))
+}
+
+// stampMultipartPage sets the timestamp, the entity type, the total page count and the page number of a page of a multiple-page post response.
+func stampMultipartPage(resultPage *api.ApiResponse, pageNum int, pageCount int) {
+	entityType := findEntityInApiResponse(*resultPage)
+	stampPagination(&resultPage.Pagination, pageNum, pageCount, pageCount)
+	resultPage.Timestamp = api.Timestamp(clock.Unix())
+	resultPage.Entity = entityType
+	resultPage.Endpoint = fmt.Sprint(entityType, "_post")
+}
+
+// linkMultipartResponse turns the response into the link to the pages of a multiple-page post response, which are in the given folder.
+func linkMultipartResponse(resp *api.ApiResponse, foldername string, pageCount int) {
+	for i := 0; i < pageCount; i++ {
+		var c api.ResultCache
+		c.ResponseUrl = foldername
+		resp.Results = append(resp.Results, c)
+	}
+	resp.Endpoint = "multipart_post_response"
 }
 
-// bakeFinalApiResponse looks at the resultpages. If there is one, it is directly provided as is. If there is more, the results are committed into the file system, and a cachelink page is provided instead.
-func bakeFinalApiResponse(resultPages *[]api.ApiResponse) (*api.ApiResponse, error) {
+// singularPostResponse is the post response that has all of its results in one page.
+func singularPostResponse(page api.ApiResponse) *api.ApiResponse {
 	resp := GeneratePrefilledApiResponse()
-	if len(*resultPages) > 1 {
+	// These gave 0 as their page count.
+	stampPagination(&resp.Pagination, 0, 1, 0)
+	resp.Pagination.TotalEntities = page.Pagination.TotalEntities
+	resp.Pagination.PageSize = page.Pagination.PageSize
+	resp.Entity = findEntityInApiResponse(page)
+	resp.Endpoint = "singular_post_response"
+	resp.ResponseBody = page.ResponseBody
+	return resp
+}
+
+// bakeFinalApiResponse looks at the resultpages. If there is one, or they come to no more than inlineMax bytes together, they are directly provided as one page. If there is more, the results are committed into the response store, and a cachelink page is provided instead. What goes wrong is logged with the trace id of the request.
+func bakeFinalApiResponse(resultPages *[]api.ApiResponse, inlineMax int64, traceId string) (*api.ApiResponse, error) {
+	resp := GeneratePrefilledApiResponse()
+	if len(*resultPages) > 1 && fitsInline(resultPages, inlineMax) {
+		resp = singularPostResponse(mergePages(resultPages))
+	} else if len(*resultPages) > 1 {
 		// Create a random SHA256 hash as folder name
 		dirname, err := generateRandomHash()
 		if err != nil {
 			return resp, err
 		}
 		// Generate the responses directory if doesn't exist. Add the expiry date to the folder name to be searched for.
-		foldername := fmt.Sprint(generateExpiryTimestamp(), "_", dirname)
-		responsedir := fmt.Sprint(globals.UserDirectory, "/statics/responses/", foldername)
-		os.MkdirAll(responsedir, 0755)
+		expiry := generateExpiryTimestamp()
+		foldername := fmt.Sprint(expiry, "_", dirname)
+		// The pages are written into staging first, and published all at once when they're all there.
+		stage, err := stageResponse(foldername, expiry)
+		if err != nil {
+			return resp, err
+		}
 		var jsons [][]byte
 		// For each response, number it, set timestamps etc. And save to disk.
 		for i, _ := range *resultPages {
 			resultPage := (*resultPages)[i]
-			entityType := findEntityInApiResponse(resultPage)
-			// Set timestamp, number of items in it, total page count, and which page.
-
-			resultPage.Pagination.Pages = uint64(len(*resultPages))
-			resultPage.Pagination.CurrentPage = uint64(i)
-			resultPage.Timestamp = api.Timestamp(time.Now().Unix())
-			resultPage.Entity = entityType
-			resultPage.Endpoint = fmt.Sprint(entityType, "_post")
-			jsonResp, err := ConvertApiResponseToJson(&resultPage)
+			stampMultipartPage(&resultPage, i, len(*resultPages))
+			jsonResp, err := EncodeSignedResponse(&resultPage, DestinationResponses)
 			if err != nil {
-				logging.Log(1, fmt.Sprintf("This page of a multiple-page post response failed to convert to JSON. Error: %#v\n, Request Body: %#v\n", err, resultPage))
+				logging.LogTrace(traceId, 1, fmt.Sprintf("This page of a multiple-page post response failed to convert to JSON. Error: %#v\n, Request Body: %#v\n", err, resultPage))
 			}
 			jsons = append(jsons, jsonResp)
 		}
 		// Insert these jsons into the filesystem.
 		for i, _ := range jsons {
-			name := fmt.Sprint(i, ".json")
-			createPath(responsedir)
-			saveFileToDisk(jsons[i], responsedir, name)
-			var c api.ResultCache
-			c.ResponseUrl = foldername
-			resp.Results = append(resp.Results, c)
-		}
-		resp.Endpoint = "multipart_post_response"
-
+			err2 := stage.savePage(i, jsons[i])
+			if err2 != nil {
+				stage.discard()
+				return resp, err2
+			}
+		}
+		err3 := stage.publish()
+		if err3 != nil {
+			return resp, err3
+		}
+		linkMultipartResponse(resp, foldername, len(jsons))
 	} else if len(*resultPages) == 1 {
 		// There is only one response page here.
-		entityType := findEntityInApiResponse((*resultPages)[0])
-		resp.Pagination.Pages = 0 // These start to count from 0
-		resp.Pagination.CurrentPage = 0
-		resp.Entity = entityType
-		resp.Endpoint = "singular_post_response"
-		resp.ResponseBody = (*resultPages)[0].ResponseBody
+		resp = singularPostResponse((*resultPages)[0])
 	} else {
 		logging.LogCrash(fmt.Sprintf("This post request produced both no results and no resulting apiResponses. []ApiResponse: %#v", *resultPages))
 	}
 	return resp, nil
 }
 
+// bakePagedApiResponse is the paged counterpart of bakeFinalApiResponse. It reads the pages of the plan from the database one at a time, and saves each to the response store before reading the next, so only one page is held in memory. With a page byte budget, the pages are cut where the budget closes them, as in the other responses.
+func bakePagedApiResponse(plan persistence.PagePlan, filters FilterSet) (*api.ApiResponse, error) {
+	resp := GeneratePrefilledApiResponse()
+	ranges, err0 := planRanges(plan)
+	if err0 != nil {
+		return resp, err0
+	}
+	dirname, err := generateRandomHash()
+	if err != nil {
+		return resp, err
+	}
+	expiry := generateExpiryTimestamp()
+	foldername := fmt.Sprint(expiry, "_", dirname)
+	stage, err := stageResponse(foldername, expiry)
+	if err != nil {
+		return resp, err
+	}
+	for i, r := range ranges {
+		pageData, err2 := persistence.ReadPageRangeContext(logging.WithTrace(context.Background(), filters.TraceId), plan, r.beg, r.end-r.beg)
+		if err2 != nil {
+			stage.discard()
+			return resp, err2
+		}
+		// Do not serve what this node would not accept itself, nor the votes the vote sync policies keep.
+		pageData = syncpolicy.FilterServed(api.FilterByPolicy(verify.FilterByMinPoW(pageData)))
+		pageData, err2 = filterByLanguage(pageData, filters.Languages)
+		if err2 != nil {
+			stage.discard()
+			return resp, err2
+		}
+		pageData = filterByBoard(pageData, filters.Boards)
+		errHooks := runPrePaginateHooks(plan.EntityType, DestinationResponses, &pageData)
+		if errHooks != nil {
+			stage.discard()
+			return resp, errHooks
+		}
+		recordSent(filters, pageData)
+		resultPage := (*convertResponsesToApiResponses(&[]api.Response{pageData}))[0]
+		selectFields(&resultPage, filters.Fields)
+		stampPagination(&resultPage.Pagination, i, len(ranges), len(ranges))
+		// The pages are filtered after they are read, so this is how many there are at most.
+		resultPage.Pagination.TotalEntities = uint64(plan.Count)
+		resultPage.Pagination.PageSize = plan.PageSize
+		resultPage.Timestamp = api.Timestamp(clock.Unix())
+		resultPage.Entity = plan.EntityType
+		resultPage.Endpoint = fmt.Sprint(plan.EntityType, "_post")
+		jsonResp, err3 := EncodeSignedResponse(&resultPage, DestinationResponses)
+		if err3 != nil {
+			logging.LogTrace(filters.TraceId, 1, fmt.Sprintf("This page of a multiple-page post response failed to convert to JSON. Error: %#v\n, Request Body: %#v\n", err3, resultPage))
+		}
+		err4 := stage.savePage(i, jsonResp)
+		if err4 != nil {
+			stage.discard()
+			return resp, err4
+		}
+	}
+	err5 := stage.publish()
+	if err5 != nil {
+		return resp, err5
+	}
+	linkMultipartResponse(resp, foldername, len(ranges))
+	return resp, nil
+}
+
 // GeneratePOSTResponse creates a response that is directly returned to a custom request by the remote.
 func GeneratePOSTResponse(respType string, req api.ApiResponse) ([]byte, error) {
 	var resp api.ApiResponse
 	// Look at filters to figure out what is being requested
 	filters := processFilters(&req)
-	switch respType {
-	case "node":
-		r := GeneratePrefilledApiResponse()
+	// The reads of the database log with the trace id of the request.
+	ctx := logging.WithTrace(context.Background(), filters.TraceId)
+	// Entities the remote submitted are taken in before the query runs, so that the accepted ones are already part of the response.
+	var statuses []api.EntityStatus
+	endpoint, known := LookupEndpoint(respType)
+	if known && endpoint.Provable {
+		statuses = acceptSubmitted(&req)
+	}
+	switch {
+	case !known:
+	case endpoint.Respond != nil:
+		r, dbError := endpoint.Respond(filters)
+		if dbError != nil {
+			return []byte{}, errors.New(fmt.Sprintf("The query coming from the remote caused an error in the local database while trying to respond to this request. Error: %#v\n, Request: %#v\n", dbError, req))
+		}
 		resp = *r
-		// resp.Endpoint = "node"
-		resp.Entity = "node"
-	case "boards", "threads", "posts", "votes", "keys", "truststates":
-		localData, dbError := persistence.Read(respType, filters.Fingerprints, filters.Embeds, filters.TimeStart, filters.TimeEnd)
+	case endpoint.Provable:
+		// In cursor mode, only the page after the cursor is returned, and the remote asks for the next one itself.
+		if filters.CursorMode && len(filters.Fingerprints) == 0 && len(filters.Embeds) == 0 {
+			cursorResponse, err := bakeCursorApiResponse(respType, filters)
+			if err != nil {
+				return []byte{}, errors.New(fmt.Sprintf("An error was encountered while trying to finalise the API response. Error: %#v\n, Request: %#v\n", err, req))
+			}
+			resp = *cursorResponse
+			break
+		}
+		// What is new within the window of the live cache is answered from memory.
+		localData, live := readLive(respType, filters)
+		if !live {
+			// Large time range queries without embeds are paged in the database, instead of being read whole into memory.
+			if len(filters.Fingerprints) == 0 && len(filters.Embeds) == 0 {
+				plan, planErr := persistence.PlanPagesContext(ctx, respType, filters.TimeStart, filters.TimeEnd, entityPageSize(respType))
+				if planErr != nil {
+					return []byte{}, errors.New(fmt.Sprintf("The query coming from the remote caused an error in the local database while trying to respond to this request. Error: %#v\n, Request: %#v\n", planErr, req))
+				}
+				if plan.Count > globals.POSTPagedReadThreshold {
+					pagedResponse, err := bakePagedApiResponse(plan, filters)
+					if err != nil {
+						return []byte{}, errors.New(fmt.Sprintf("An error was encountered while trying to finalise the API response. Error: %#v\n, Request: %#v\n", err, req))
+					}
+					resp = *pagedResponse
+					break
+				}
+			}
+			var readErr error
+			localData, readErr = persistence.ReadFieldsContext(ctx, respType, filters.Fingerprints, filters.Embeds, filters.TimeStart, filters.TimeEnd, filters.Fields)
+			if readErr != nil {
+				return []byte{}, errors.New(fmt.Sprintf("The query coming from the remote caused an error in the local database while trying to respond to this request. Error: %#v\n, Request: %#v\n", readErr, req))
+			}
+		}
+		// Do not serve what this node would not accept itself, nor the votes the vote sync policies keep.
+		localData = syncpolicy.FilterServed(api.FilterByPolicy(verify.FilterByMinPoW(localData)))
+		localData, dbError := filterByLanguage(localData, filters.Languages)
 		if dbError != nil {
 			return []byte{}, errors.New(fmt.Sprintf("The query coming from the remote caused an error in the local database while trying to respond to this request. Error: %#v\n, Request: %#v\n", dbError, req))
 		}
+		localData = filterByBoard(localData, filters.Boards)
+		hookErr := runPrePaginateHooks(respType, DestinationResponses, &localData)
+		if hookErr != nil {
+			return []byte{}, errors.New(fmt.Sprintf("A response hook stopped the response. Error: %s\n, Request: %#v\n", hookErr, req))
+		}
+		recordSent(filters, localData)
 		pages := splitEntitiesToPages(&localData)
 		pagesAsApiResponses := convertResponsesToApiResponses(pages)
-		finalResponse, err := bakeFinalApiResponse(pagesAsApiResponses)
-		// fmt.Printf("%#v", finalResponse)
+		for i, _ := range *pagesAsApiResponses {
+			selectFields(&(*pagesAsApiResponses)[i], filters.Fields)
+		}
+		finalResponse, err := bakeFinalApiResponse(pagesAsApiResponses, inlineLimit(filters), filters.TraceId)
 		if err != nil {
 			return []byte{}, errors.New(fmt.Sprintf("An error was encountered while trying to finalise the API response. Error: %#v\n, Request: %#v\n", err, req))
 		}
 		resp = *finalResponse
-		// resp.Endpoint = "entity"
-	case "addresses": // Addresses can't do address search by loc/subloc/port. Only time search is available, since addresses don't have fingerprints defined.
-		addresses, dbError := persistence.ReadAddresses("", "", 0, filters.TimeStart, filters.TimeEnd, 0, 0, 0)
-		var localData api.Response
-		localData.Addresses = addresses
+	default:
+		localData, dbError := endpoint.ReadPOST(filters)
 		if dbError != nil {
 			return []byte{}, errors.New(fmt.Sprintf("The query coming from the remote caused an error in the local database while trying to respond to this request. Error: %#v\n, Request: %#v\n", dbError, req))
 		}
+		hookErr := runPrePaginateHooks(respType, DestinationResponses, &localData)
+		if hookErr != nil {
+			return []byte{}, errors.New(fmt.Sprintf("A response hook stopped the response. Error: %s\n, Request: %#v\n", hookErr, req))
+		}
+		recordSent(filters, localData)
 		pages := splitEntitiesToPages(&localData)
 		pagesAsApiResponses := convertResponsesToApiResponses(pages)
-		finalResponse, err := bakeFinalApiResponse(pagesAsApiResponses)
+		for i, _ := range *pagesAsApiResponses {
+			selectFields(&(*pagesAsApiResponses)[i], filters.Fields)
+		}
+		finalResponse, err := bakeFinalApiResponse(pagesAsApiResponses, inlineLimit(filters), filters.TraceId)
 		if err != nil {
 			return []byte{}, errors.New(fmt.Sprintf("An error was encountered while trying to finalise the API response. Error: %#v\n, Request: %#v\n", err, req))
 		}
 		resp = *finalResponse
-		resp.Endpoint = "entity"
 	}
+	if known && len(endpoint.ResponseEndpoint) > 0 {
+		resp.Endpoint = endpoint.ResponseEndpoint
+	}
+	// The pages merged into the response, and the page of a cursor, are given the fields here.
+	selectFields(&resp, filters.Fields)
 	// Build the response itself
 	resp.Entity = respType
-	resp.Timestamp = api.Timestamp(time.Now().Unix())
+	resp.Timestamp = api.Timestamp(clock.Unix())
+	resp.TraceId = req.TraceId
+	resp.Statuses = statuses
+	if len(filters.Window) > 0 {
+		resp.StartsFrom, resp.EndsAt = resolvedRange(filters, resp.Timestamp)
+	}
+	errHooks := runPreSerializeHooks(DestinationResponses, &resp)
+	if errHooks != nil {
+		return []byte{}, errors.New(fmt.Sprintf("A response hook stopped the response. Error: %s\n, Request: %#v\n", errHooks, req))
+	}
+	// Binding comes last, since the signature covers everything else in the response.
+	errBind := api.BindResponse(&resp, req.Nonce)
+	if errBind != nil {
+		return []byte{}, errors.New(fmt.Sprintf("The response could not be bound to the request. Error: %#v\n, Request: %#v\n", errBind, req))
+	}
 	// Construct the query, and run an index to determine how many entries we have for the filter.
-	jsonResp, err := ConvertApiResponseToJson(&resp)
+	jsonResp, err := EncodeResponse(&resp, DestinationResponses)
 	if err != nil {
 		return []byte{}, errors.New(fmt.Sprintf("The response that was prepared to respond to this query failed to convert to JSON. Error: %#v\n, Request Body: %#v\n", err, req))
 	}
-	return jsonResp, nil
+	return api.PadResponse(jsonResp), nil
 }
 
 func createBoardIndex(entity *api.Board, pageNum int) api.BoardIndex {
@@ -854,6 +1087,15 @@ func createTruststateIndex(entity *api.Truststate, pageNum int) api.TruststateIn
 	return entityIndex
 }
 
+func createTombstoneIndex(entity *api.Tombstone, pageNum int) api.TombstoneIndex {
+	var entityIndex api.TombstoneIndex
+	entityIndex.Target = entity.Target
+	entityIndex.Creation = entity.Creation
+	entityIndex.Fingerprint = entity.GetFingerprint()
+	entityIndex.PageNumber = pageNum
+	return entityIndex
+}
+
 // createIndexes creates the index variant of every entity in an api.Response, and puts it back inside one single container for all indexes.
 func createIndexes(fullData *[]api.Response) *api.Response {
 	fd := *fullData
@@ -898,6 +1140,12 @@ func createIndexes(fullData *[]api.Response) *api.Response {
 					resp.TruststateIndexes = append(resp.TruststateIndexes, entityIndex)
 				}
 			}
+			if len(fd[i].Tombstones) > 0 {
+				for j, _ := range fd[i].Tombstones {
+					entityIndex := createTombstoneIndex(&fd[i].Tombstones[j], i)
+					resp.TombstoneIndexes = append(resp.TombstoneIndexes, entityIndex)
+				}
+			}
 		}
 	}
 	return &resp
@@ -919,38 +1167,54 @@ type CacheResponse struct {
 	end         api.Timestamp
 	entityPages *[]api.Response
 	indexPages  *[]api.Response
+	pageHashes  map[string]string // Filled in when the entity pages are saved to disk.
+	mirrorUrl   string            // Filled in when the cache is uploaded to the CDN.
+	manifest    string            // The hash of the manifest of the cache. Filled in when the cache is saved to disk.
+	run         *generationRun    // The cache generation run that makes the cache, if it is watched.
+	source      *cachePageSource  // Where the entity pages are read from when they are saved, if the cache is streamed instead of read whole into entityPages.
+}
+
+// buildCacheResponse puts together the cache of an entity type that has indexes, from its entity pages and its indexes.
+func buildCacheResponse(entityPages *[]api.Response, indexes *api.Response, start api.Timestamp, end api.Timestamp) (CacheResponse, error) {
+	var resp CacheResponse
+	indexPages := splitEntityIndexesToPages(indexes)
+	cn, err := generateCacheName()
+	if err != nil {
+		return resp, errors.New(fmt.Sprintf("There was an error in the cache generation request serving. Error: %#v\n", err))
+	}
+	resp.cacheName = cn
+	resp.start = start
+	resp.end = end
+	resp.indexPages = indexPages
+	resp.entityPages = entityPages
+	return resp, nil
 }
 
 // GenerateCacheResponse responds to a cache generation request. This returns an Api.Response entity with entities, entity indexes, and the cache link that needs to be inserted into the index of the endpoint.
 // This has no filters.
 func GenerateCacheResponse(respType string, start api.Timestamp, end api.Timestamp) (CacheResponse, error) {
 	var resp CacheResponse
-	switch respType {
-	case "boards", "threads", "posts", "votes", "keys", "truststates":
-		localData, dbError := persistence.Read(respType, []api.Fingerprint{}, []string{}, start, end)
-		if dbError != nil {
-			return resp, errors.New(fmt.Sprintf("This cache generation request caused an error in the local database while trying to respond to this request. Error: %#v\n", dbError))
-		}
-		entityPages := splitEntitiesToPages(&localData)
-		indexes := createIndexes(entityPages)
-		indexPages := splitEntityIndexesToPages(indexes)
-		cn, err := generateCacheName()
-		if err != nil {
-			return resp, errors.New(fmt.Sprintf("There was an error in the cache generation request serving. Error: %#v\n", err))
+	endpoint, known := LookupEndpoint(respType)
+	switch {
+	case !known || !endpoint.Cached():
+		return resp, errors.New(fmt.Sprintf("The requested entity type is unknown to the cache generator. Entity type: %s", respType))
+	case endpoint.Provable:
+		// A hook that changes the entities needs all of them at once, so the cache can only be streamed if there is none.
+		if len(hooksOf(StagePrePaginate)) == 0 {
+			return streamedCacheResponse(respType, start, end)
 		}
-		resp.cacheName = cn
-		resp.start = start
-		resp.end = end
-		resp.indexPages = indexPages
-		resp.entityPages = entityPages
+		return readCacheResponse(respType, start, end)
 
-	case "addresses":
-		addresses, dbError := persistence.ReadAddresses("", "", 0, start, end, 0, 0, 0)
-		var localData api.Response
-		localData.Addresses = addresses
+	default:
+		// The endpoints that are not provable are their own index.
+		localData, dbError := endpoint.ReadRange(start, end)
 		if dbError != nil {
 			return resp, errors.New(fmt.Sprintf("This cache generation request caused an error in the local database while trying to respond to this request. Error: %#v\n", dbError))
 		}
+		hookErr := runPrePaginateHooks(respType, DestinationCaches, &localData)
+		if hookErr != nil {
+			return resp, hookErr
+		}
 		entityPages := splitEntitiesToPages(&localData)
 		cn, err := generateCacheName()
 		if err != nil {
@@ -960,87 +1224,259 @@ func GenerateCacheResponse(respType string, start api.Timestamp, end api.Timesta
 		resp.start = start
 		resp.end = end
 		resp.entityPages = entityPages
-
-	default:
-		return resp, errors.New(fmt.Sprintf("The requested entity type is unknown to the cache generator. Entity type: %s", respType))
 	}
 	return resp, nil
 }
 
-func updateCacheIndex(cacheIndex *api.ApiResponse, cacheData *CacheResponse) {
-	// Save the cache link into the index.
+// readCacheResponse makes the cache of a provable entity type from a full read of its entities. This is for when the cache can't be streamed: when pre-paginate hooks need the entities, or when the entities changed while the cache was streamed.
+func readCacheResponse(respType string, start api.Timestamp, end api.Timestamp) (CacheResponse, error) {
+	var resp CacheResponse
+	// The range is read as-is, so that already cached ranges can be regenerated.
+	localData, dbError := persistence.ReadInRange(respType, start, end)
+	if dbError != nil {
+		return resp, errors.New(fmt.Sprintf("This cache generation request caused an error in the local database while trying to respond to this request. Error: %#v\n", dbError))
+	}
+	// A hook that drops entities makes the indexes read from the database not match, and they are built from the pages below.
+	hookErr := runPrePaginateHooks(respType, DestinationCaches, &localData)
+	if hookErr != nil {
+		return resp, hookErr
+	}
+	entityPages := splitEntitiesToPages(&localData)
+	entityCount := countEntities(&localData)
+	localData = api.Response{} // The pages hold the entities from here on.
+	// Where the pages were cut, by count or by the page byte budget, before anything is filtered out of them.
+	ranges := rangesOf(entityPages)
+	// The PoW filter goes over the pages one by one, so the entities that stay keep their page numbers.
+	dropped := make(map[api.Fingerprint]bool)
+	for i, _ := range *entityPages {
+		before := fingerprintsOf(&(*entityPages)[i])
+		(*entityPages)[i] = syncpolicy.FilterServed(api.FilterByPolicy(verify.FilterByMinPoW((*entityPages)[i])))
+		after := make(map[api.Fingerprint]bool)
+		for _, fp := range fingerprintsOf(&(*entityPages)[i]) {
+			after[fp] = true
+		}
+		for _, fp := range before {
+			if !after[fp] {
+				dropped[fp] = true
+			}
+		}
+	}
+	// The indexes are read from the database separately, with only the columns they need.
+	indexes, dbError2 := persistence.ReadIndexes(respType, start, end)
+	if dbError2 != nil || countIndexes(&indexes) != entityCount {
+		// Something arrived or got tombstoned between the two reads, so the page numbers can't be trusted. Build the indexes from the pages instead.
+		logging.Log(1, fmt.Sprintf("The indexes read from the database do not match the entities of the cache, they will be built from the entities. Entity type: %s, Error: %v", respType, dbError2))
+		return buildCacheResponse(entityPages, createIndexes(entityPages), start, end)
+	}
+	numberIndexes(&indexes, ranges)
+	removeIndexesOf(&indexes, dropped)
+	return buildCacheResponse(entityPages, &indexes, start, end)
+}
+
+// cacheLink is the link to the cache in the index.
+func cacheLink(cacheData *CacheResponse) api.ResultCache {
 	var c api.ResultCache
 	c.ResponseUrl = cacheData.cacheName
 	c.StartsFrom = cacheData.start
 	c.EndsAt = cacheData.end
-	cacheIndex.Results = append(cacheIndex.Results, c)
-	cacheIndex.Timestamp = api.Timestamp(int64(time.Now().Unix()))
+	c.MirrorUrl = cacheData.mirrorUrl
+	c.PageHashes = cacheData.pageHashes
+	c.Manifest = cacheData.manifest
+	return c
+}
+
+func updateCacheIndex(cacheIndex *api.ApiResponse, cacheData *CacheResponse) {
+	// Save the cache link into the index.
+	cacheIndex.Results = api.TrimCacheLinks(append(cacheIndex.Results, cacheLink(cacheData)))
+	cacheIndex.Timestamp = api.Timestamp(clock.Unix())
 	cacheIndex.Caching.ServedFromCache = true
 	cacheIndex.Caching.CacheScope = "day"
 	// TODO: How many places am I setting this ".Caching" data?
 
 }
 
-// saveCacheToDisk saves an entire cache's data (entities and indexes, inside a folder named based on the cache name) into the proper location on the disk.
+// stampCachePage sets the fields that mark a page as a part of a cache.
+func stampCachePage(page *api.ApiResponse, endpoint string, respType string, cacheName string) {
+	page.Endpoint = endpoint
+	page.Entity = respType
+	page.Timestamp = api.Timestamp(clock.Unix())
+	page.Caching.ServedFromCache = true
+	page.Caching.CurrentCacheUrl = cacheName
+	// page.Caching.PrevCacheUrl // TODO Pulling this is expensive as heck here. Reconsider the need.
+	page.Caching.CacheScope = "day"
+}
+
+// saveCacheToDisk saves an entire cache's data (entities and indexes, inside a folder named based on the cache name) into the proper location on the disk. The entity pages are saved first: a streamed cache reads them from the database as they are saved, and only knows which of its indexes to leave out after.
 func saveCacheToDisk(entityCacheDir string, cacheData *CacheResponse, respType string) error {
 	// Create the index directory.
 	cacheDir := fmt.Sprint(entityCacheDir, "/", cacheData.cacheName)
 	createPath(cacheDir)
-	var indexPages []api.ApiResponse
+	pageCount, totalEntities, pageSize := entityPageTotals(cacheData)
+	// Stamp the pages, then convert them to JSON and save them. The stamping is done here rather than in the workers, so that it happens in page order.
+	hashes, manifest, err := streamPages(pageCount, func(i int) (pageJob, error) {
+		data, err := entityPageOf(cacheData, i)
+		if err != nil {
+			return pageJob{}, err
+		}
+		page := convertResponseToApiResponse(&data, i, pageCount, totalEntities, pageSize)
+		stampCachePage(&page, "entity", respType, cacheData.cacheName)
+		// Record the hash of the page, so that the copies of it on a CDN can be verified.
+		name := fmt.Sprint(i, ".json")
+		return pageJob{&page, cacheDir, name, true, name}, nil
+	}, cacheData.run)
+	if err != nil {
+		return err
+	}
+	cacheData.pageHashes = hashes
 	var indexDir string
-	if respType != "addresses" {
+	if indexPageSize(respType) > 0 {
 		indexDir = fmt.Sprint(entityCacheDir, "/", cacheData.cacheName, "/index")
 		createPath(indexDir)
-		indexPages = *convertResponsesToApiResponses(cacheData.indexPages)
-	}
-	// Convert api.Responses to api.ApiResponses for saving.
-	entityPages := *convertResponsesToApiResponses(cacheData.entityPages)
-	// Iterate over the data, convert api.ApiResponses to JSON, and save.
-	for i, _ := range indexPages {
-		indexPages[i].Endpoint = "entity_index"
-		indexPages[i].Entity = respType
-		indexPages[i].Timestamp = api.Timestamp(int64(time.Now().Unix()))
-		indexPages[i].Caching.ServedFromCache = true
-		indexPages[i].Caching.CurrentCacheUrl = cacheData.cacheName
-		// indexPages[i].Caching.PrevCacheUrl // TODO Pulling this is expensive as heck here. Reconsider the need.
-		indexPages[i].Caching.CacheScope = "day"
-		// For each index, look at the page number and save the result as that.
-		json, _ := ConvertApiResponseToJson(&indexPages[i])
-		saveFileToDisk(json, indexDir, fmt.Sprint(indexPages[i].Pagination.CurrentPage, ".json"))
-	}
-	for i, _ := range entityPages {
-		entityPages[i].Endpoint = "entity"
-		entityPages[i].Entity = respType
-		entityPages[i].Timestamp = api.Timestamp(int64(time.Now().Unix()))
-		entityPages[i].Caching.ServedFromCache = true
-		entityPages[i].Caching.CurrentCacheUrl = cacheData.cacheName
-		// indexPages[i].Caching.PrevCacheUrl // TODO Pulling this is expensive as heck here. Reconsider the need.
-		entityPages[i].Caching.CacheScope = "day"
-		// For each index, look at the page number and save the result as that.
-		json, _ := ConvertApiResponseToJson(&entityPages[i])
-		saveFileToDisk(json, cacheDir, fmt.Sprint(entityPages[i].Pagination.CurrentPage, ".json"))
+		if cacheData.source != nil {
+			cacheData.indexPages = cacheData.source.indexPages()
+		}
+		indexPages := *convertResponsesToApiResponses(cacheData.indexPages)
+		var jobs []pageJob
+		for i, _ := range indexPages {
+			stampCachePage(&indexPages[i], "entity_index", respType, cacheData.cacheName)
+			// For each index, look at the page number and save the result as that.
+			name := fmt.Sprint(indexPages[i].Pagination.CurrentPage, ".json")
+			jobs = append(jobs, pageJob{&indexPages[i], indexDir, name, false, fmt.Sprint("index/", name)})
+		}
+		_, indexManifest, err2 := writePages(jobs, cacheData.run)
+		if err2 != nil {
+			return err2
+		}
+		// The manifest lists the entity pages first, as the remotes download them.
+		manifest = append(manifest, indexManifest...)
+	}
+	manifestHash, err3 := writeManifest(cacheDir, cacheData.cacheName, manifest)
+	if err3 != nil {
+		return err3
+	}
+	cacheData.manifest = manifestHash
+	if len(indexDir) > 0 {
+		syncDir(indexDir)
 	}
+	syncDir(cacheDir)
 	return nil
 }
 
-// CreateCache creates the cache for the given entity type for the given time range.
-func CreateCache(respType string, start api.Timestamp, end api.Timestamp) error {
-	// - Pull the data from the DB
-	// - Look at the cache folder. If there is a cache folder and an index there, save the cache and add to index.
-	// - If there is no cache present there, create the index and add it as the first entry.
+// entityPageTotals gives the count of the entity pages of the cache, and the totals their pagination is stamped with. The entities of a streamed cache are counted from its indexes, before the filters drop any, since the pages are saved before the last of them is read.
+func entityPageTotals(cacheData *CacheResponse) (int, uint64, int) {
+	if cacheData.source == nil {
+		totalEntities, pageSize := paginationTotals(cacheData.entityPages)
+		return len(*cacheData.entityPages), totalEntities, pageSize
+	}
+	pageSize := 0
+	if len(cacheData.source.fps) > 0 {
+		pageSize = cacheData.source.plan.PageSize
+	}
+	return len(cacheData.source.ranges), uint64(len(cacheData.source.fps)), pageSize
+}
+
+// entityPageOf gives an entity page of the cache, read from the database if the cache is streamed. The pages read are reported to the run as they are read.
+func entityPageOf(cacheData *CacheResponse, page int) (api.Response, error) {
+	if cacheData.source == nil {
+		return (*cacheData.entityPages)[page], nil
+	}
+	data, err := cacheData.source.readPage(page)
+	if err != nil {
+		return data, err
+	}
+	r := cacheData.source.ranges[page]
+	cacheData.run.update(func(p *GenerationProgress) { p.EntitiesRead += int64(r.end - r.beg) })
+	return data, nil
+}
+
+// bakeCache generates the cache of the given entity type for the given time range from the database, saves it to disk under the given name, or a new one if the name is empty, and uploads it to the CDN if there is one. Nothing points to the cache until its link is added to the index.
+func bakeCache(respType string, start api.Timestamp, end api.Timestamp, cacheName string) (CacheResponse, error) {
+	return bakeRunCache(nil, respType, start, end, cacheName)
+}
+
+// bakeRunCache is bakeCache as a part of a cache generation run, which it reports its progress to, and stops for if it is cancelled. A cache that could not be saved whole is removed, since nothing points to it. Nothing is read while the cache writes are paused for a full disk.
+func bakeRunCache(run *generationRun, respType string, start api.Timestamp, end api.Timestamp, cacheName string) (CacheResponse, error) {
+	if err0 := resumeIfSpace(); err0 != nil {
+		return CacheResponse{}, err0
+	}
 	cacheData, err := GenerateCacheResponse(respType, start, end)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cache creation process encountered an error. Error: %s", err))
+		return cacheData, errors.New(fmt.Sprintf("Cache creation process encountered an error. Error: %s", err))
+	}
+	if len(cacheName) > 0 {
+		cacheData.cacheName = cacheName
 	}
+	cacheData.run = run
 	entityCacheDir := fmt.Sprint(globals.CachesLocation, "/", respType)
 	// Create the caches dir and the appropriate endpoint if does not exist.
 	createPath(entityCacheDir)
 	// Save the cache to disk.
-	err2 := saveCacheToDisk(entityCacheDir, &cacheData, respType)
+	err2 := saveRunCache(entityCacheDir, &cacheData, respType)
+	if err2 == errCacheEntitiesMoved {
+		// Something was tombstoned or removed between the reads of the streamed cache. A full read can't be split that way, so the cache is made again from one, under the same name.
+		logging.Log(1, fmt.Sprintf("The entities of the cache changed while it was being generated, it will be generated again from a full read. Entity type: %s, Cache: %s", respType, cacheData.cacheName))
+		os.RemoveAll(fmt.Sprint(entityCacheDir, "/", cacheData.cacheName))
+		readData, err3 := readCacheResponse(respType, start, end)
+		if err3 != nil {
+			return readData, errors.New(fmt.Sprintf("Cache creation process encountered an error. Error: %s", err3))
+		}
+		readData.cacheName = cacheData.cacheName
+		readData.run = run
+		cacheData = readData
+		err2 = saveRunCache(entityCacheDir, &cacheData, respType)
+	}
 	// TODO: above needs to add caching tag, entity and endpoint fields, and the current timestamp.
+	if err2 == nil && run.cancelled() {
+		// The last pages were written as the run was cancelled. It is not uploaded, as it is removed with the others of the run.
+		err2 = errGenerationCancelled
+	}
 	if err2 != nil {
-		return errors.New(fmt.Sprintf("Cache creation process encountered an error. Error: %s", err2))
+		os.RemoveAll(fmt.Sprint(entityCacheDir, "/", cacheData.cacheName))
+		return cacheData, errors.New(fmt.Sprintf("Cache creation process encountered an error. Error: %s", err2))
+	}
+	if globals.CdnEnabled {
+		// If the upload fails, the cache is still served from the origin, it just won't have a mirror.
+		mirrorUrl, err5 := cdn.UploadCache(respType, cacheData.cacheName, fmt.Sprint(entityCacheDir, "/", cacheData.cacheName))
+		if err5 != nil {
+			logging.Log(1, fmt.Sprintf("Cache upload to the CDN failed. Error: %s", err5))
+		} else {
+			cacheData.mirrorUrl = mirrorUrl
+		}
+	}
+	return cacheData, nil
+}
+
+// saveRunCache saves the cache as a part of the run it is made for. The entities of a cache that was read whole are reported as read here, and the ones of a streamed cache as its pages are read.
+func saveRunCache(entityCacheDir string, cacheData *CacheResponse, respType string) error {
+	if cacheData.source == nil {
+		var entitiesRead int64
+		for i, _ := range *cacheData.entityPages {
+			page := &(*cacheData.entityPages)[i]
+			entitiesRead += int64(countEntities(page) + len(page.Addresses))
+		}
+		cacheData.run.update(func(p *GenerationProgress) { p.EntitiesRead += entitiesRead })
+	}
+	if cacheData.run.cancelled() {
+		return errGenerationCancelled
 	}
+	return saveCacheToDisk(entityCacheDir, cacheData, respType)
+}
+
+// CreateCache creates the cache for the given entity type for the given time range.
+func CreateCache(respType string, start api.Timestamp, end api.Timestamp) error {
+	// - Pull the data from the DB
+	// - Look at the cache folder. If there is a cache folder and an index there, save the cache and add to index.
+	// - If there is no cache present there, create the index and add it as the first entry.
+	cacheData, err := bakeCache(respType, start, end, "")
+	if err != nil {
+		return err
+	}
+	return addCacheLink(respType, &cacheData)
+}
+
+// addCacheLink adds the link to a cache that is saved to disk to the index of its entity type, and creates the index if there is none yet.
+func addCacheLink(respType string, cacheData *CacheResponse) error {
+	entityCacheDir := fmt.Sprint(globals.CachesLocation, "/", respType)
 	var apiResp api.ApiResponse
 	// Look for the index.json in it. If it doesn't exist, create.
 	cacheIndexAsJson, err3 := ioutil.ReadFile(fmt.Sprint(entityCacheDir, "/index.json"))
@@ -1054,30 +1490,37 @@ func CreateCache(respType string, start api.Timestamp, end api.Timestamp) error
 		json.Unmarshal(cacheIndexAsJson, &apiResp)
 	}
 	// If the file exists, go through with regular processing.
-	updateCacheIndex(&apiResp, &cacheData)
-	json, err4 := ConvertApiResponseToJson(&apiResp)
-	if err4 != nil {
-		return err
-	}
-	saveFileToDisk(json, entityCacheDir, "index.json")
-	return nil
+	updateCacheIndex(&apiResp, cacheData)
+	return writeCacheIndex(respType, &apiResp)
 }
 
 // GenerateCaches generates all day caches for all entities and saves them to disk.
 func GenerateCaches() {
-	now := int64(time.Now().Unix())
+	generateDueCaches(nil)
+}
+
+// generateDueCaches runs the cache generation, if it is due.
+func generateDueCaches(run *generationRun) error {
+	if globals.ServingMode == "light" {
+		// A light node doesn't serve caches. The ones it has are left to the janitor.
+		return nil
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	now := clock.Unix()
 	lastCacheGenTs := globals.LastCacheGenerationTimestamp
 	lastCacheGenTime := time.Unix(lastCacheGenTs, 0)
 	// If more than 24 hours has passed since the last cache generation, generated a new cache for that timeframe.
-	if time.Since(lastCacheGenTime) > 24*time.Hour {
-		CreateCache("boards", api.Timestamp(lastCacheGenTs), api.Timestamp(now))
-		CreateCache("threads", api.Timestamp(lastCacheGenTs), api.Timestamp(now))
-		CreateCache("posts", api.Timestamp(lastCacheGenTs), api.Timestamp(now))
-		CreateCache("votes", api.Timestamp(lastCacheGenTs), api.Timestamp(now))
-		CreateCache("addresses", api.Timestamp(lastCacheGenTs), api.Timestamp(now))
-		CreateCache("keys", api.Timestamp(lastCacheGenTs), api.Timestamp(now))
-		CreateCache("truststates", api.Timestamp(lastCacheGenTs), api.Timestamp(now))
+	if clock.Since(lastCacheGenTime) > 24*time.Hour {
+		if globals.CacheGenerationVerbose {
+			logPlan()
+		}
+		err := generateCaches(run, api.Timestamp(lastCacheGenTs), api.Timestamp(now))
+		if err != nil {
+			return err
+		}
 		// After successfully generating the caches, make the last cache generation timestamp to current.
 		globals.LastCacheGenerationTimestamp = now
 	}
+	return nil
 }
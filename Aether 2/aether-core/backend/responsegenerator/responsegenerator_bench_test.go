@@ -0,0 +1,102 @@
+// These benchmarks are in the package itself rather than in responsegenerator_test, since the hot paths they measure are not exported. Run them with run-benchmarks.sh, which keeps the previous results for comparison.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+var benchmarkSizes = []int{10000, 100000, 1000000}
+
+// syntheticPosts creates a response with n posts spread over 100 threads in 10 boards. The posts don't have valid PoW or signatures, since nothing here verifies them.
+func syntheticPosts(n int) api.Response {
+	var resp api.Response
+	resp.Posts = make([]api.Post, n)
+	for i := 0; i < n; i++ {
+		p := &resp.Posts[i]
+		p.Fingerprint = api.Fingerprint(fmt.Sprintf("%064d", i))
+		p.Creation = api.Timestamp(1500000000 + i)
+		p.Board = api.Fingerprint(fmt.Sprintf("board%059d", i%10))
+		p.Thread = api.Fingerprint(fmt.Sprintf("thread%058d", i%100))
+		p.Parent = p.Thread
+		p.Owner = api.Fingerprint(fmt.Sprintf("owner%059d", i%1000))
+		p.Body = "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua."
+	}
+	return resp
+}
+
+// runSized runs the benchmark for every dataset size. The largest size is skipped in short mode, as it needs a few gigabytes of memory.
+func runSized(b *testing.B, fn func(b *testing.B, data *api.Response)) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			if testing.Short() && n > 100000 {
+				b.Skip("Skipping the largest dataset in short mode.")
+			}
+			data := syntheticPosts(n)
+			b.ResetTimer()
+			fn(b, &data)
+		})
+	}
+}
+
+func BenchmarkSplitEntitiesToPages(b *testing.B) {
+	globals.SetGlobals()
+	runSized(b, func(b *testing.B, data *api.Response) {
+		for i := 0; i < b.N; i++ {
+			splitEntitiesToPages(data)
+		}
+	})
+}
+
+func BenchmarkCreateIndexes(b *testing.B) {
+	globals.SetGlobals()
+	runSized(b, func(b *testing.B, data *api.Response) {
+		pages := splitEntitiesToPages(data)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			createIndexes(pages)
+		}
+	})
+}
+
+func BenchmarkConvertResponsesToApiResponses(b *testing.B) {
+	globals.SetGlobals()
+	runSized(b, func(b *testing.B, data *api.Response) {
+		pages := splitEntitiesToPages(data)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			convertResponsesToApiResponses(pages)
+		}
+	})
+}
+
+// BenchmarkCreateCache covers everything CreateCache does after the database reads: paging, indexing, encoding and writing to disk. The database reads are left out, since they measure the database more than this code, so the indexes are built in memory here.
+func BenchmarkCreateCache(b *testing.B) {
+	globals.SetGlobals()
+	dir, err := ioutil.TempDir("", "aether-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runSized(b, func(b *testing.B, data *api.Response) {
+		for i := 0; i < b.N; i++ {
+			pages := splitEntitiesToPages(data)
+			cacheData, err := buildCacheResponse(pages, createIndexes(pages), 0, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+			err2 := saveCacheToDisk(dir, &cacheData, "posts")
+			if err2 != nil {
+				b.Fatal(err2)
+			}
+			b.StopTimer()
+			os.RemoveAll(fmt.Sprint(dir, "/", cacheData.cacheName))
+			b.StartTimer()
+		}
+	})
+}
@@ -0,0 +1,83 @@
+// Backend > ResponseGenerator > ResponseStore
+// This file keeps the pages of the multipart POST responses in the database, if the response store is "db". A response is written page by page, like into staging, and made servable with a single update once all of its pages are in, so there is nothing to rename and nothing half-written to clean up: what is left of a response that was never published expires with the rest. The expired responses go with a single delete, and how much the responses take is a sum in the database, so the quota is exact.
+// The responses are served from the same URLs either way. A response written into the statics directory before the store was switched is still served from there until it expires.
+
+package responsegenerator
+
+import (
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+)
+
+// dbStage is a response written into the database.
+type dbStage struct {
+	name   string
+	expiry int64
+	// What the response store can still take, or -1 if it has no quota.
+	room int64
+}
+
+func stageDbResponse(foldername string, expiry int64) (responseStage, error) {
+	s := dbStage{name: foldername, expiry: expiry, room: -1}
+	if globals.ResponseStoreQuotaBytes > 0 {
+		usage, err := persistence.ReadResponsePagesUsage()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("The usage of the response store could not be read. Error: %s", err))
+		}
+		s.room = globals.ResponseStoreQuotaBytes - usage.Bytes
+	}
+	return &s, nil
+}
+
+func (s *dbStage) savePage(page int, data []byte) error {
+	if s.room >= 0 {
+		if int64(len(data)) > s.room {
+			return errors.New(fmt.Sprintf("The response store is over its quota. Quota: %d bytes, Response: %s", globals.ResponseStoreQuotaBytes, s.name))
+		}
+		s.room -= int64(len(data))
+	}
+	return persistence.InsertResponsePage(s.name, page, data, s.expiry)
+}
+
+func (s *dbStage) publish() error {
+	err := persistence.PublishResponsePages(s.name)
+	if err != nil {
+		s.discard()
+		return errors.New(fmt.Sprintf("The response could not be published. Error: %s", err))
+	}
+	return nil
+}
+
+func (s *dbStage) discard() {
+	err := persistence.DeleteResponsePages(s.name)
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("A staged response could not be removed from the database. Response: %s, Error: %s", s.name, err))
+	}
+}
+
+// ReadStoredResponsePage gives a page of a published response in the database, if it is there and has not expired.
+func ReadStoredResponsePage(response string, page int) ([]byte, bool, error) {
+	return persistence.ReadResponsePage(response, page, clock.Unix())
+}
+
+// DeleteExpiredStoredResponses deletes the pages of the responses in the database that expired, and gives how many pages it deleted. The responses that were never published expire the same way.
+func DeleteExpiredStoredResponses() (int64, error) {
+	return persistence.DeleteExpiredResponsePages(clock.Unix())
+}
+
+// ResponseStoreReport is the state of the response store.
+type ResponseStoreReport struct {
+	Store      string                         `json:"store"`
+	QuotaBytes int64                          `json:"quota_bytes"`
+	Database   persistence.ResponsePagesUsage `json:"database"`
+}
+
+// InspectResponseStore gives the store in use, and how much the responses in the database take.
+func InspectResponseStore() (ResponseStoreReport, error) {
+	usage, err := persistence.ReadResponsePagesUsage()
+	return ResponseStoreReport{Store: globals.ResponseStore, QuotaBytes: globals.ResponseStoreQuotaBytes, Database: usage}, err
+}
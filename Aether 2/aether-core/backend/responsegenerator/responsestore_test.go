@@ -0,0 +1,21 @@
+// This test is in the package itself rather than in responsegenerator_test, since the responses are staged with functions that are not exported. The pages that fit are written into the database, so only the ones over the quota are tested here; the rest is in the tests of persistence.
+
+package responsegenerator
+
+import (
+	"aether-core/services/globals"
+	"testing"
+)
+
+func TestDbStageSavePage_Fail_OverQuota(t *testing.T) {
+	globals.ResponseStoreQuotaBytes = 100
+	defer func() { globals.ResponseStoreQuotaBytes = 0 }()
+	s := dbStage{name: "1600000000_quota", expiry: 1600000600, room: 10}
+	err := s.savePage(0, make([]byte, 11))
+	if err == nil {
+		t.Errorf("A page larger than what the response store can still take was accepted.")
+	}
+	if s.room != 10 {
+		t.Errorf("A page that was refused should not take from the room left. Room: %d", s.room)
+	}
+}
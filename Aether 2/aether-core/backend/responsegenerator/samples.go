@@ -0,0 +1,93 @@
+// Backend > ResponseGenerator > Samples
+// This file builds the responses of the node out of the given entities instead of the database, and without writing anything to disk, so that examples of them can be given to the implementers of the protocol.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// SampleCache is a cache as it would be saved to disk: its entity pages, its index pages, and the index.json of the entity type that points to it. Page n of the entities is saved as n.json in the cache folder, page n of the indexes as index/n.json.
+type SampleCache struct {
+	EntityPages []api.ApiResponse
+	IndexPages  []api.ApiResponse
+	Index       api.ApiResponse
+}
+
+// SampleCacheResponses builds the cache of the given entities, in the same way as a cache generation run.
+func SampleCacheResponses(respType string, data api.Response, cacheName string, start api.Timestamp, end api.Timestamp) (SampleCache, error) {
+	var sample SampleCache
+	entityPages := splitEntitiesToPages(&data)
+	var cacheData CacheResponse
+	cacheData.cacheName = cacheName
+	cacheData.start = start
+	cacheData.end = end
+	cacheData.entityPages = entityPages
+	cacheData.pageHashes = make(map[string]string)
+	if indexPageSize(respType) > 0 {
+		cacheData.indexPages = splitEntityIndexesToPages(createIndexes(entityPages))
+		sample.IndexPages = *convertResponsesToApiResponses(cacheData.indexPages)
+		for i, _ := range sample.IndexPages {
+			stampCachePage(&sample.IndexPages[i], "entity_index", respType, cacheName)
+		}
+	}
+	sample.EntityPages = *convertResponsesToApiResponses(entityPages)
+	for i, _ := range sample.EntityPages {
+		stampCachePage(&sample.EntityPages[i], "entity", respType, cacheName)
+		json, err := ConvertApiResponseToJson(&sample.EntityPages[i])
+		if err != nil {
+			return sample, err
+		}
+		hash := sha256.Sum256(json)
+		cacheData.pageHashes[fmt.Sprint(sample.EntityPages[i].Pagination.CurrentPage, ".json")] = hex.EncodeToString(hash[:])
+	}
+	sample.Index = *GeneratePrefilledApiResponse()
+	updateCacheIndex(&sample.Index, &cacheData)
+	return sample, nil
+}
+
+// SamplePOSTResponse builds the response to a POST request whose results are the given entities. If they don't fit into one page, the response is the link to the pages, and the pages are returned separately; the pages would be in the given folder under /responses.
+func SamplePOSTResponse(respType string, data api.Response, foldername string) (api.ApiResponse, []api.ApiResponse) {
+	pages := *convertResponsesToApiResponses(splitEntitiesToPages(&data))
+	var resp *api.ApiResponse
+	var resultPages []api.ApiResponse
+	if len(pages) > 1 {
+		for i, _ := range pages {
+			stampMultipartPage(&pages[i], i, len(pages))
+		}
+		resultPages = pages
+		resp = GeneratePrefilledApiResponse()
+		linkMultipartResponse(resp, foldername, len(pages))
+	} else {
+		resp = singularPostResponse(pages[0])
+	}
+	if e, ok := LookupEndpoint(respType); ok && len(e.ResponseEndpoint) > 0 {
+		resp.Endpoint = e.ResponseEndpoint
+	}
+	resp.Entity = respType
+	resp.Timestamp = api.Timestamp(clock.Unix())
+	return *resp, resultPages
+}
+
+// SampleNodeResponse builds the response of the node endpoint.
+func SampleNodeResponse() api.ApiResponse {
+	resp := *GeneratePrefilledApiResponse()
+	resp.Endpoint = "node"
+	resp.Entity = "node"
+	resp.Timestamp = api.Timestamp(clock.Unix())
+	return resp
+}
+
+// SampleListResponse builds the responses that list what they have in a single page, such as the peers and the vote summaries.
+func SampleListResponse(respType string, data api.Answer) api.ApiResponse {
+	resp := *GeneratePrefilledApiResponse()
+	resp.ResponseBody = data
+	resp.Endpoint = respType
+	resp.Entity = respType
+	resp.Timestamp = api.Timestamp(clock.Unix())
+	return resp
+}
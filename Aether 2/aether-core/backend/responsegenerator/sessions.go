@@ -0,0 +1,76 @@
+// Backend > ResponseGenerator > Sessions
+// This file keeps the digests of what this node sent to each sync session, by the trace id the remote sends with every request of a sync, so that the remote can ask for them at the end and check them against what it received. See io/api/digest.go.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"sync"
+	"time"
+)
+
+// maxSessions bounds how many sessions are kept. A sync is a few minutes long, so this is far more than the remotes that can be syncing at once.
+const maxSessions = 1024
+
+type session struct {
+	digests  *api.DigestAccumulator
+	lastSent time.Time
+}
+
+var sessionsLock sync.Mutex
+var sessions = make(map[string]*session)
+
+// sessionExpiry is how long a session is kept after the last response sent to it. The remotes ask for the digests right after the last response of their sync, and the pages of that response are available for as long.
+func sessionExpiry() time.Duration {
+	return time.Duration(globals.PostResponseExpiryMinutes) * time.Minute
+}
+
+// expireSessions drops the sessions that expired, and if there are still too many, the one sent to the longest ago. The caller holds sessionsLock.
+func expireSessions() {
+	var oldest string
+	var oldestTime time.Time
+	for traceId, s := range sessions {
+		if clock.Since(s.lastSent) > sessionExpiry() {
+			delete(sessions, traceId)
+			continue
+		}
+		if len(oldest) == 0 || s.lastSent.Before(oldestTime) {
+			oldest, oldestTime = traceId, s.lastSent
+		}
+	}
+	if len(sessions) >= maxSessions {
+		delete(sessions, oldest)
+	}
+}
+
+// recordSent adds what is sent in a POST response into the digests of the session. The requests without a trace id are not a part of any session, and the requesters that said in their handshakes that they don't check the digests don't need them kept.
+func recordSent(filters FilterSet, data api.Response) {
+	traceId := filters.TraceId
+	// The partial entities of a fields filter can't be ingested, so the requester has nothing to check them against.
+	if !globals.SessionDigestsEnabled || len(traceId) == 0 || len(filters.Fields) > 0 || !requesterHas(filters, api.SessionDigestExtension) {
+		return
+	}
+	sessionsLock.Lock()
+	s, ok := sessions[traceId]
+	if !ok {
+		expireSessions()
+		s = &session{digests: api.NewDigestAccumulator()}
+		sessions[traceId] = s
+	}
+	s.lastSent = clock.Now()
+	sessionsLock.Unlock()
+	s.digests.Add(data)
+}
+
+// sessionDigests gives the digests of what was sent to the session. A session nothing was sent to has none.
+func sessionDigests(traceId string) []api.SessionDigest {
+	sessionsLock.Lock()
+	s, ok := sessions[traceId]
+	sessionsLock.Unlock()
+	if !ok || len(traceId) == 0 {
+		return []api.SessionDigest{}
+	}
+	return s.digests.Digests()
+}
@@ -0,0 +1,68 @@
+// This test is in the package itself rather than in responsegenerator_test, since what is sent to a session is recorded by functions that are not exported, as the responses are baked.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"testing"
+)
+
+func TestSessionDigests_Success(t *testing.T) {
+	globals.SetGlobals()
+	data := syntheticPosts(100)
+	// The remote sends the posts in two pages, and the requester receives them in the other order.
+	first := api.Response{Posts: data.Posts[:60]}
+	second := api.Response{Posts: data.Posts[60:]}
+	recordSent(FilterSet{TraceId: "session_success"}, first)
+	recordSent(FilterSet{TraceId: "session_success"}, second)
+	received := api.NewDigestAccumulator()
+	received.Add(second)
+	received.Add(first)
+	e, ok := LookupEndpoint("session")
+	if !ok {
+		t.Fatal("The session endpoint should be registered.")
+	}
+	r, err := e.Respond(FilterSet{TraceId: "session_success"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.SessionDigests) != 1 || r.SessionDigests[0].Entity != "posts" || r.SessionDigests[0].Entities != 100 {
+		t.Fatalf("The session should have the digest of the posts sent. Digests: %#v", r.SessionDigests)
+	}
+	if differ := api.CompareDigests(received.Digests(), r.SessionDigests); len(differ) != 0 {
+		t.Errorf("The digests should not depend on the order the entities arrive in. Differ: %v", differ)
+	}
+	other, _ := e.Respond(FilterSet{TraceId: "session_other"})
+	if len(other.SessionDigests) != 0 {
+		t.Errorf("A session nothing was sent to should have no digests. Digests: %#v", other.SessionDigests)
+	}
+}
+
+func TestSessionDigests_Fail_Changed(t *testing.T) {
+	globals.SetGlobals()
+	data := syntheticPosts(10)
+	recordSent(FilterSet{TraceId: "session_changed"}, data)
+	// A post arrives changed, and another doesn't arrive at all.
+	changed := api.Response{Posts: append([]api.Post{}, data.Posts[:9]...)}
+	changed.Posts[0].Signature = "changed"
+	received := api.NewDigestAccumulator()
+	received.Add(changed)
+	differ := api.CompareDigests(received.Digests(), sessionDigests("session_changed"))
+	if len(differ) != 1 || differ[0] != "posts" {
+		t.Errorf("The posts should differ. Differ: %v", differ)
+	}
+	// A count that matches is not enough.
+	changed.Posts = append(changed.Posts, data.Posts[9])
+	received2 := api.NewDigestAccumulator()
+	received2.Add(changed)
+	if differ2 := api.CompareDigests(received2.Digests(), sessionDigests("session_changed")); len(differ2) != 1 {
+		t.Errorf("A changed post should make the digests differ even if the count is the same. Differ: %v", differ2)
+	}
+	globals.SessionDigestsEnabled = false
+	defer func() { globals.SessionDigestsEnabled = true }()
+	recordSent(FilterSet{TraceId: "session_disabled"}, data)
+	if len(sessionDigests("session_disabled")) != 0 {
+		t.Errorf("Nothing should be recorded with the session digests disabled.")
+	}
+}
@@ -0,0 +1,114 @@
+// Backend > ResponseGenerator > Staging
+// This file stages multipart POST responses in a temporary directory until all of their pages are written, so that a remote never sees a response that is only partly there. With the "db" response store, the pages are staged in the database instead, see responsestore.go.
+
+package responsegenerator
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// stagingLocation is where the responses are written before they are published. It is within the user directory, so that it is on the same filesystem as the responses directory, and a rename between the two is atomic.
+func stagingLocation() string {
+	return fmt.Sprint(globals.UserDirectory, "/statics/staging")
+}
+
+// responseStage is a multipart response while its pages are written. Its pages are not served until it is published, and then all of them are.
+type responseStage interface {
+	savePage(page int, data []byte) error
+	publish() error
+	discard()
+}
+
+// stageResponse starts a multipart response with the given name, which expires at the given time, in the response store in use.
+func stageResponse(foldername string, expiry int64) (responseStage, error) {
+	if globals.ResponseStore == "db" {
+		return stageDbResponse(foldername, expiry)
+	}
+	stagingDir := fmt.Sprint(stagingLocation(), "/", foldername)
+	err := os.MkdirAll(stagingDir, 0755)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("The staging directory of the response could not be created. Error: %s", err))
+	}
+	return &fileStage{dir: stagingDir, name: foldername}, nil
+}
+
+// fileStage is a response written into the staging directory, and published into the responses directory.
+type fileStage struct {
+	dir  string
+	name string
+}
+
+// savePage writes a page of a staged response. It reports the error, since a response with a missing page can't be published.
+func (f *fileStage) savePage(page int, data []byte) error {
+	return ioutil.WriteFile(fmt.Sprint(f.dir, "/", page, ".json"), data, 0755)
+}
+
+// publish moves a fully written response from staging into the responses directory, in one rename. Links to the response should only be given out after this returns without error.
+func (f *fileStage) publish() error {
+	responsesDir := fmt.Sprint(globals.UserDirectory, "/statics/responses")
+	createPath(responsesDir)
+	err := os.Rename(f.dir, fmt.Sprint(responsesDir, "/", f.name))
+	if err != nil {
+		f.discard()
+		return errors.New(fmt.Sprintf("The response could not be published. Error: %s", err))
+	}
+	return nil
+}
+
+// discard removes a staged response that won't be published.
+func (f *fileStage) discard() {
+	err := os.RemoveAll(f.dir)
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("A staged response could not be removed. Path: %s, Error: %s", f.dir, err))
+	}
+}
+
+// CleanStaging removes whatever is left in staging. Anything there was left behind by a crash mid-write, since the responses are either published or discarded before the request returns. This should be called at startup, before the server starts.
+func CleanStaging() {
+	err := os.RemoveAll(stagingLocation())
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The staging directory could not be cleaned. Error: %s", err))
+	}
+}
+
+// StaleResponses returns the names of the POST responses that are older than their expiry. Remotes are only given links to a response until it expires, so nothing should be reading these anymore.
+func StaleResponses() ([]string, error) {
+	var stale []string
+	responsesDir := fmt.Sprint(globals.UserDirectory, "/statics/responses")
+	folders, err := ioutil.ReadDir(responsesDir)
+	if err != nil && os.IsNotExist(err) {
+		return stale, nil
+	} else if err != nil {
+		return stale, err
+	}
+	cutoff := clock.Now().Add(-time.Duration(globals.PostResponseExpiryMinutes) * time.Minute)
+	for _, f := range folders {
+		if f.ModTime().Before(cutoff) {
+			stale = append(stale, f.Name())
+		}
+	}
+	return stale, nil
+}
+
+// DeleteStaleResponses deletes the POST responses that are older than their expiry, and returns how many it deleted.
+func DeleteStaleResponses() (int, error) {
+	stale, err := StaleResponses()
+	if err != nil {
+		return 0, err
+	}
+	responsesDir := fmt.Sprint(globals.UserDirectory, "/statics/responses")
+	for i, _ := range stale {
+		err2 := os.RemoveAll(fmt.Sprint(responsesDir, "/", stale[i]))
+		if err2 != nil {
+			return i, err2
+		}
+	}
+	return len(stale), nil
+}
@@ -0,0 +1,76 @@
+// This test is in the package itself rather than in responsegenerator_test, since the responses are staged and published with functions that are not exported.
+
+package responsegenerator
+
+import (
+	"aether-core/services/globals"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// stageTestResponse stages a response of two pages in a new user directory, without publishing it.
+func stageTestResponse(t *testing.T) responseStage {
+	dir, err := ioutil.TempDir("", "aether-staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals.UserDirectory = dir
+	globals.ResponseStore = "files"
+	stage, err2 := stageResponse("1600000000_staged", 1600000600)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	for i := 0; i < 2; i++ {
+		err3 := stage.savePage(i, []byte("{}"))
+		if err3 != nil {
+			t.Fatal(err3)
+		}
+	}
+	return stage
+}
+
+// publishedPages gives the pages of the response that can be served, if there is such a response.
+func publishedPages() []string {
+	files, _ := ioutil.ReadDir(filepath.Join(globals.UserDirectory, "statics", "responses", "1600000000_staged"))
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+func TestStageResponse_Success(t *testing.T) {
+	stage := stageTestResponse(t)
+	defer os.RemoveAll(globals.UserDirectory)
+	if len(publishedPages()) != 0 {
+		t.Errorf("A response should not be served before it is published.")
+	}
+	err := stage.publish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages := publishedPages(); len(pages) != 2 {
+		t.Errorf("The response should be served with all of its pages once it is published. Pages: %v", pages)
+	}
+}
+
+func TestStageResponse_Fail_Interrupted(t *testing.T) {
+	stage := stageTestResponse(t)
+	defer os.RemoveAll(globals.UserDirectory)
+	stage.discard()
+	if len(publishedPages()) != 0 {
+		t.Errorf("A response that was discarded should not be served.")
+	}
+	// A crash mid-write leaves the response in staging, which is cleaned at the next start.
+	stageTestResponse(t)
+	defer os.RemoveAll(globals.UserDirectory)
+	CleanStaging()
+	if len(publishedPages()) != 0 {
+		t.Errorf("A response left in staging by a crash should not be served.")
+	}
+	if _, err := os.Stat(stagingLocation()); !os.IsNotExist(err) {
+		t.Errorf("The staging directory should be cleaned at start. Error: %v", err)
+	}
+}
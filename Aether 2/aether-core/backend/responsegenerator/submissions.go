@@ -0,0 +1,110 @@
+// Backend > ResponseGenerator > Submissions
+// This file deals with the entities that a remote submits in the body of its POST request. Each of them gets a status in the response, so that a well-behaved remote can stop sending the ones that are rejected.
+
+package responsegenerator
+
+import (
+	"aether-core/backend/events"
+	"aether-core/backend/ranking"
+	"aether-core/backend/replytree"
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/logging"
+	"aether-core/services/verify"
+	"fmt"
+)
+
+// submittedEntities returns the entities in the body of the request. Indexes and addresses are not submissions: indexes carry nothing to commit, and addresses are learnt by connecting to them.
+func submittedEntities(req *api.ApiResponse) api.Response {
+	var r api.Response
+	r.Boards = req.ResponseBody.Boards
+	r.Threads = req.ResponseBody.Threads
+	r.Posts = req.ResponseBody.Posts
+	r.Votes = req.ResponseBody.Votes
+	r.Keys = req.ResponseBody.Keys
+	r.Truststates = req.ResponseBody.Truststates
+	r.Tombstones = req.ResponseBody.Tombstones
+	return r
+}
+
+func moveEntitiesToInterfacePack(r *api.Response) *[]interface{} {
+	var carrier []interface{}
+	for i, _ := range r.Boards {
+		carrier = append(carrier, r.Boards[i])
+	}
+	for i, _ := range r.Threads {
+		carrier = append(carrier, r.Threads[i])
+	}
+	for i, _ := range r.Posts {
+		carrier = append(carrier, r.Posts[i])
+	}
+	for i, _ := range r.Votes {
+		carrier = append(carrier, r.Votes[i])
+	}
+	for i, _ := range r.Keys {
+		carrier = append(carrier, r.Keys[i])
+	}
+	for i, _ := range r.Truststates {
+		carrier = append(carrier, r.Truststates[i])
+	}
+	for i, _ := range r.Tombstones {
+		carrier = append(carrier, r.Tombstones[i])
+	}
+	return &carrier
+}
+
+// rejectAll gives every submitted entity the same rejection.
+func rejectAll(r *api.Response, reason string) []api.EntityStatus {
+	var statuses []api.EntityStatus
+	for _, fp := range fingerprintsOf(r) {
+		statuses = append(statuses, api.EntityStatus{Fingerprint: fp, Status: api.EntityRejected, Reason: reason})
+	}
+	return statuses
+}
+
+// acceptSubmitted verifies and commits the entities submitted in the request, and returns their statuses. It returns nothing if the request submitted nothing.
+func acceptSubmitted(req *api.ApiResponse) []api.EntityStatus {
+	submitted := submittedEntities(req)
+	if countEntities(&submitted) == 0 {
+		return nil
+	}
+	err := api.CheckPageLimits(req)
+	if err != nil {
+		logging.LogTrace(req.TraceId, 1, fmt.Sprintf("The submission of the remote is over the inbound limits. Node: %s, Error: %s", req.NodeId, err))
+		return rejectAll(&submitted, api.RejectedOverLimits)
+	}
+	accepted, statuses := verify.VerifySubmission(submitted)
+	if countEntities(&accepted) == 0 {
+		return statuses
+	}
+	source := persistence.Source{Node: req.NodeId, Via: "submission", TraceId: req.TraceId}
+	if req.NodeId == persistence.LocalSource.Node {
+		source = persistence.LocalSource
+		source.TraceId = req.TraceId
+	}
+	written, err2 := persistence.BatchInsertWritten(*moveEntitiesToInterfacePack(&accepted), source)
+	if err2 != nil {
+		logging.LogTrace(req.TraceId, 1, fmt.Sprintf("The accepted submissions of the remote could not be committed. Node: %s, Error: %s", req.NodeId, err2))
+		for i, _ := range statuses {
+			if statuses[i].Status == api.EntityAccepted {
+				statuses[i].Status = api.EntityRejected
+				statuses[i].Reason = api.RejectedStorageFailure
+			}
+		}
+		return statuses
+	}
+	ranking.Update(&accepted)
+	replytree.Update(&accepted)
+	events.Publish(&accepted, written)
+	NoteIngested(&accepted)
+	logging.LogTrace(req.TraceId, 1, fmt.Sprintf("Submissions of the remote are processed. Node: %s, Submitted: %d, Accepted: %d", req.NodeId, len(statuses), countEntities(&accepted)))
+	return statuses
+}
+
+// AcceptLocal verifies and commits the entities given by the operator of the node, such as the ones the developer tools create, the same way as the ones the remotes submit.
+func AcceptLocal(body api.Answer) []api.EntityStatus {
+	var req api.ApiResponse
+	req.NodeId = "local"
+	req.ResponseBody = body
+	return acceptSubmitted(&req)
+}
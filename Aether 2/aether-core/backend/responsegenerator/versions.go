@@ -0,0 +1,152 @@
+// Backend > ResponseGenerator > Versions
+// This file lets a cache be regenerated without a remote ever getting a mix of its old and new pages. A regenerated cache is written into a folder of its own, a new version of the old one, and the index is switched over to it in a single write once all of it is there. The old folder is retired rather than deleted: it is kept while remotes are still downloading it, and deleted once nobody has asked for it for CacheRetiredGrace.
+
+package responsegenerator
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retiredFile lists the retired cache folders of an entity type, in the folder of the entity type.
+const retiredFile = "retired.json"
+
+type retiredCache struct {
+	Name      string `json:"name"`
+	RetiredAt int64  `json:"retired_at"`
+}
+
+// The last time a page of each cache was served, by entity type and cache name.
+var servedLock sync.Mutex
+var lastServed = make(map[string]time.Time)
+
+// noteCacheServed records that a page of the cache is being served.
+func noteCacheServed(respType string, cacheName string) {
+	servedLock.Lock()
+	defer servedLock.Unlock()
+	lastServed[fmt.Sprint(respType, "/", cacheName)] = clock.Now()
+}
+
+func lastServedAt(respType string, cacheName string) time.Time {
+	servedLock.Lock()
+	defer servedLock.Unlock()
+	return lastServed[fmt.Sprint(respType, "/", cacheName)]
+}
+
+// nextCacheVersion gives the name of the next version of the cache, such as cache_x_v2 for cache_x, and cache_x_v3 for cache_x_v2.
+func nextCacheVersion(cacheName string) string {
+	base, version := cacheName, 1
+	if i := strings.LastIndex(cacheName, "_v"); i != -1 {
+		if v, err := strconv.Atoi(cacheName[i+2:]); err == nil && v > 1 {
+			base, version = cacheName[:i], v
+		}
+	}
+	return fmt.Sprint(base, "_v", version+1)
+}
+
+func readRetired(respType string) []retiredCache {
+	var retired []retiredCache
+	data, err := ioutil.ReadFile(fmt.Sprint(globals.CachesLocation, "/", respType, "/", retiredFile))
+	if err != nil {
+		return retired
+	}
+	err2 := json.Unmarshal(data, &retired)
+	if err2 != nil {
+		// Without the list, the retired folders are no longer in the index, so the repair of the index deletes them.
+		logging.Log(1, fmt.Sprintf("The list of the retired caches is corrupted, it will be started again. Entity type: %s, Error: %s", respType, err2))
+		return []retiredCache{}
+	}
+	return retired
+}
+
+func writeRetired(respType string, retired []retiredCache) error {
+	data, err := json.Marshal(retired)
+	if err != nil {
+		return err
+	}
+	entityCacheDir := fmt.Sprint(globals.CachesLocation, "/", respType)
+	tmp := fmt.Sprint(entityCacheDir, "/", retiredFile, ".tmp")
+	err2 := ioutil.WriteFile(tmp, data, 0755)
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The list of the retired caches could not be written. Entity type: %s, Error: %s", respType, err2))
+	}
+	return os.Rename(tmp, fmt.Sprint(entityCacheDir, "/", retiredFile))
+}
+
+// retireCaches marks the cache folders as retired. They have to be out of the index already. The caller holds cacheLock.
+func retireCaches(respType string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	retired := readRetired(respType)
+	now := clock.Unix()
+	for _, name := range names {
+		retired = append(retired, retiredCache{Name: name, RetiredAt: now})
+	}
+	return writeRetired(respType, retired)
+}
+
+// retiredNames gives the names of the retired cache folders of the entity type that are still kept.
+func retiredNames(respType string) map[string]bool {
+	names := make(map[string]bool)
+	for _, r := range readRetired(respType) {
+		names[r.Name] = true
+	}
+	return names
+}
+
+// sweepRetiredCaches deletes the retired cache folders of the entity type that nobody has asked for since CacheRetiredGrace, and gives their names. The caller holds cacheLock.
+func sweepRetiredCaches(respType string) []string {
+	retired := readRetired(respType)
+	var kept []retiredCache
+	var deleted []string
+	for _, r := range retired {
+		lastUse := time.Unix(r.RetiredAt, 0)
+		if served := lastServedAt(respType, r.Name); served.After(lastUse) {
+			lastUse = served
+		}
+		if clock.Since(lastUse) < globals.CacheRetiredGrace || !isValidCacheName(r.Name) {
+			kept = append(kept, r)
+			continue
+		}
+		err := os.RemoveAll(fmt.Sprint(globals.CachesLocation, "/", respType, "/", r.Name))
+		if err != nil {
+			logging.Log(1, fmt.Sprintf("A retired cache could not be deleted. Entity type: %s, Cache: %s, Error: %s", respType, r.Name, err))
+			kept = append(kept, r)
+			continue
+		}
+		deleted = append(deleted, r.Name)
+	}
+	if len(deleted) == 0 {
+		return deleted
+	}
+	err2 := writeRetired(respType, kept)
+	if err2 != nil {
+		logging.Log(1, err2)
+	}
+	logging.Log(2, fmt.Sprintf("Deleted %d retired caches of %s.", len(deleted), respType))
+	return deleted
+}
+
+// SweepRetiredCaches deletes the retired cache folders of all entity types that nobody has asked for since CacheRetiredGrace.
+func SweepRetiredCaches() map[string][]string {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	deleted := make(map[string][]string)
+	for _, respType := range cacheEntityTypes {
+		if d := sweepRetiredCaches(respType); len(d) > 0 {
+			deleted[respType] = d
+		}
+	}
+	return deleted
+}
@@ -0,0 +1,71 @@
+// This test is in the package itself rather than in responsegenerator_test, since the retired caches are kept and swept by functions that are not exported.
+
+package responsegenerator
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNextCacheVersion_Success(t *testing.T) {
+	cases := map[string]string{
+		"cache_ab12":     "cache_ab12_v2",
+		"cache_ab12_v2":  "cache_ab12_v3",
+		"cache_ab12_v9":  "cache_ab12_v10",
+		"cache_ab12_v1":  "cache_ab12_v1_v2",
+		"cache_ab12_vx2": "cache_ab12_vx2_v2",
+	}
+	for name, next := range cases {
+		if v := nextCacheVersion(name); v != next || !isValidCacheName(v) {
+			t.Errorf("The next version of the cache has the wrong name. Cache: %s, Next version: %s, Expected: %s", name, v, next)
+		}
+	}
+}
+
+func TestSweepRetiredCaches_Success(t *testing.T) {
+	cacheName := saveRepairTestCache(t)
+	defer os.RemoveAll(globals.CachesLocation)
+	mock := clock.NewMockClock(time.Unix(1600000000, 0))
+	clock.Set(mock)
+	defer clock.Reset()
+	// The cache is taken out of the index and retired, as a regeneration would.
+	cacheIndex, err := readCacheIndex("posts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheIndex.Results = nil
+	err2 := switchCaches("posts", &cacheIndex, []string{cacheName})
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	cacheDir := filepath.Join(globals.CachesLocation, "posts", cacheName)
+	report, err3 := repairCacheIndex("posts", true)
+	if err3 != nil || len(report.RemovedFolders) != 0 {
+		t.Errorf("The repair of the index should spare a retired cache. Removed folders: %v, Error: %v", report.RemovedFolders, err3)
+	}
+	// A page served near the end of the grace keeps the folder for another grace.
+	mock.Advance(globals.CacheRetiredGrace - time.Minute)
+	noteCacheServed("posts", cacheName)
+	mock.Advance(2 * time.Minute)
+	if deleted := SweepRetiredCaches(); len(deleted) != 0 {
+		t.Errorf("A retired cache that is still being downloaded should be kept. Deleted: %v", deleted)
+	}
+	if _, err4 := os.Stat(cacheDir); err4 != nil {
+		t.Errorf("The retired cache folder should still be there. Error: %s", err4)
+	}
+	mock.Advance(globals.CacheRetiredGrace)
+	deleted := SweepRetiredCaches()
+	if len(deleted["posts"]) != 1 || deleted["posts"][0] != cacheName {
+		t.Errorf("A retired cache nobody asked for during the grace should be deleted. Deleted: %v", deleted)
+	}
+	if _, err5 := os.Stat(cacheDir); !os.IsNotExist(err5) {
+		t.Errorf("The retired cache folder should be gone. Error: %v", err5)
+	}
+	if len(retiredNames("posts")) != 0 {
+		t.Errorf("The deleted cache should be out of the list of the retired caches.")
+	}
+}
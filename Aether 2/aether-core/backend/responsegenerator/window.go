@@ -0,0 +1,73 @@
+// Backend > ResponseGenerator > Window
+// This file works out the time ranges of the window filters (see window.go in the api package), with the clock of the node and the end of its last cache. The range is worked out once, when the filters of the request are read, so every page of the response is of the same range.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxWindowDays is the longest a "last" window can be. The ranges before the last cache are cut at its end anyway.
+const maxWindowDays = 366
+
+// windowUnits are the units of the "last" windows.
+var windowUnits = map[byte]time.Duration{'m': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour}
+
+// resolveWindow gives the start of the window at now. The windows end now. zone is the time zone of "today", such as "+02:00"; empty is UTC.
+func resolveWindow(name string, zone string, now time.Time) (api.Timestamp, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch {
+	case name == api.WindowToday:
+		loc := time.UTC
+		if len(zone) > 0 {
+			offset, err := parseZoneOffset(zone)
+			if err != nil {
+				return 0, err
+			}
+			loc = time.FixedZone(zone, offset)
+		}
+		local := now.In(loc)
+		return api.Timestamp(time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc).Unix()), nil
+	case name == api.WindowSinceLastCache:
+		return api.Timestamp(globals.LastCacheGenerationTimestamp), nil
+	case strings.HasPrefix(name, "last") && len(name) > len("last")+1:
+		unit, ok := windowUnits[name[len(name)-1]]
+		count, err := strconv.Atoi(name[len("last") : len(name)-1])
+		if !ok || err != nil || count <= 0 || time.Duration(count)*unit > maxWindowDays*24*time.Hour {
+			return 0, errors.New(fmt.Sprintf("This window is not known. Window: %s", name))
+		}
+		return api.Timestamp(now.Add(-time.Duration(count) * unit).Unix()), nil
+	}
+	return 0, errors.New(fmt.Sprintf("This window is not known. Window: %s", name))
+}
+
+// parseZoneOffset gives the offset of a time zone given as "+02:00", "-0530" or "Z", in seconds.
+func parseZoneOffset(zone string) (int, error) {
+	if zone == "Z" {
+		return 0, nil
+	}
+	t, err := time.Parse("-07:00", zone)
+	if err != nil {
+		t, err = time.Parse("-0700", zone)
+	}
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("This time zone is not an offset from UTC. Time zone: %s", zone))
+	}
+	_, offset := t.Zone()
+	return offset, nil
+}
+
+// resolvedRange is the range a response with a window filter says it was worked out to: what the database gives for the range, which doesn't start before the end of the last cache.
+func resolvedRange(filters FilterSet, now api.Timestamp) (api.Timestamp, api.Timestamp) {
+	start := filters.TimeStart
+	if lastCache := api.Timestamp(globals.LastCacheGenerationTimestamp); start < lastCache {
+		start = lastCache
+	}
+	return start, now
+}
@@ -0,0 +1,66 @@
+// This test is in the package itself rather than in responsegenerator_test, since the windows are worked out by functions that are not exported, as the filters of a request are read.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"testing"
+	"time"
+)
+
+func TestResolveWindow_Success(t *testing.T) {
+	globals.SetGlobals()
+	// 2020-09-13 12:26:40 UTC.
+	now := time.Unix(1600000000, 0)
+	cases := []struct {
+		name  string
+		zone  string
+		start int64
+	}{
+		{"today", "", 1599955200},
+		{"today", "+14:00", 1600000000 - 2*3600 - 26*60 - 40},
+		{"Last24h", "", 1600000000 - 86400},
+		{"last30m", "", 1600000000 - 1800},
+		{"last7d", "", 1600000000 - 7*86400},
+	}
+	for _, c := range cases {
+		start, err := resolveWindow(c.name, c.zone, now)
+		if err != nil || int64(start) != c.start {
+			t.Errorf("The window should start at %d. Window: %s %s, Start: %d, Error: %v", c.start, c.name, c.zone, start, err)
+		}
+	}
+	globals.LastCacheGenerationTimestamp = 1599990000
+	defer func() { globals.LastCacheGenerationTimestamp = 0 }()
+	clock.Set(clock.NewMockClock(now))
+	defer clock.Reset()
+	fs := processFilters(&api.ApiResponse{Filters: []api.Filter{{Type: api.WindowFilter, Values: []string{"today"}}}})
+	if fs.Window != "today" || fs.TimeStart != 1599955200 || fs.TimeEnd != 0 {
+		t.Errorf("The filters should have the range of the window, to now. Filters: %#v", fs)
+	}
+	if start, end := resolvedRange(fs, api.Timestamp(now.Unix())); start != 1599990000 || end != 1600000000 {
+		t.Errorf("The range the response gives should start at the end of the last cache. Range: %d-%d", start, end)
+	}
+}
+
+func TestResolveWindow_Fail(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	for _, name := range []string{"yesterday", "last", "lasth", "last0h", "last-1h", "last5y", "last400d"} {
+		if _, err := resolveWindow(name, "", now); err == nil {
+			t.Errorf("This window should not be known. Window: %s", name)
+		}
+	}
+	if _, err := resolveWindow("today", "Europe/Berlin", now); err == nil {
+		t.Errorf("A time zone that is not an offset should be refused.")
+	}
+	// A window that is not known is ignored, and a timestamp filter after a window is used instead of it.
+	fs := processFilters(&api.ApiResponse{Filters: []api.Filter{{Type: api.WindowFilter, Values: []string{"yesterday"}}}})
+	if len(fs.Window) > 0 || fs.TimeStart != 0 {
+		t.Errorf("A window that is not known should give no range. Filters: %#v", fs)
+	}
+	fs2 := processFilters(&api.ApiResponse{Filters: []api.Filter{{Type: api.WindowFilter, Values: []string{"last1h"}}, {Type: "timestamp", Values: []string{"5", "10"}}}})
+	if len(fs2.Window) > 0 || fs2.TimeStart != 5 || fs2.TimeEnd != 10 {
+		t.Errorf("The timestamp filter after the window should be used. Filters: %#v", fs2)
+	}
+}
@@ -0,0 +1,166 @@
+// Backend > ResponseGenerator > Witness
+// This file has both sides of the witness protocol. As a witness, the node signs the caches other nodes send it, if WitnessEnabled. As the origin, it sends the manifests of its own caches to the witnesses in CacheWitnesses, and keeps their signatures in the cache index, next to the caches, so that they are served with them. A witness that can't be reached is asked again at the next collection.
+
+package responsegenerator
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// witnessBatchSize is how many caches are sent to a witness in one request, each in a filter of its own. It stays under the filters the validation policy allows by default.
+const witnessBatchSize = 8
+
+// witnessIds are the node ids of the witnesses, by their addresses, as they gave them in their last response. A cache that has the signature of a witness already is not sent to it again.
+var witnessIds = make(map[string]api.Fingerprint)
+var witnessIdsLock sync.Mutex
+
+// respondWitness signs the caches in the witness filters of a request, as a witness.
+func respondWitness(filters FilterSet) (*api.ApiResponse, error) {
+	if !globals.WitnessEnabled {
+		return nil, errors.New("This node is not a witness.")
+	}
+	now := api.Timestamp(clock.Unix())
+	r := GeneratePrefilledApiResponse()
+	for _, values := range filters.Witness {
+		node, entityType, c, err := api.ParseWitnessFilter(values)
+		if err != nil {
+			return nil, err
+		}
+		sig, err2 := api.SignWitness(node, entityType, c, now)
+		if err2 != nil {
+			return nil, err2
+		}
+		c.Witnesses = []api.WitnessSignature{sig}
+		r.Results = append(r.Results, c)
+	}
+	r.Endpoint = "witness"
+	return r, nil
+}
+
+// hasWitness checks whether the cache has the signature of the witness.
+func hasWitness(c api.ResultCache, witness api.Fingerprint) bool {
+	for _, w := range c.Witnesses {
+		if w.Witness == witness {
+			return true
+		}
+	}
+	return false
+}
+
+// askWitness sends the caches of the entity type to the witness at the address, and gives the signatures it sent back, by the names of the caches. The signatures that don't verify are left out.
+func askWitness(address string, entityType string, caches []api.ResultCache) (map[string]api.WitnessSignature, error) {
+	sigs := make(map[string]api.WitnessSignature)
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return sigs, errors.New(fmt.Sprintf("The address of the witness is not valid. Address: %s, Error: %s", address, err))
+	}
+	port, err2 := strconv.ParseUint(portStr, 10, 16)
+	if err2 != nil {
+		return sigs, errors.New(fmt.Sprintf("The port of the witness is not valid. Address: %s", address))
+	}
+	for beg := 0; beg < len(caches); beg += witnessBatchSize {
+		end := beg + witnessBatchSize
+		if end > len(caches) {
+			end = len(caches)
+		}
+		req := GeneratePrefilledApiResponse()
+		for _, c := range caches[beg:end] {
+			req.Filters = append(req.Filters, api.WitnessFilter(api.Fingerprint(globals.NodeId), entityType, c))
+		}
+		resp, err3 := api.GetPageBound(host, "", uint16(port), "witness", *req)
+		if err3 != nil {
+			return sigs, err3
+		}
+		witnessIdsLock.Lock()
+		witnessIds[address] = resp.NodeId
+		witnessIdsLock.Unlock()
+		for _, c := range resp.Results {
+			for _, w := range c.Witnesses {
+				if w.Witness != resp.NodeId {
+					continue
+				}
+				if err4 := api.VerifyWitness(api.Fingerprint(globals.NodeId), entityType, c, w); err4 != nil {
+					logging.Log(1, fmt.Sprintf("A witness sent back a signature that is not valid. Witness: %s, Error: %s", address, err4))
+					continue
+				}
+				sigs[fmt.Sprint(c.ResponseUrl, ":", c.Manifest)] = w
+			}
+		}
+	}
+	return sigs, nil
+}
+
+// CollectWitnesses sends the caches that don't have the signatures of all the witnesses in CacheWitnesses to the ones missing, and adds what they sign into the cache indexes. It gives how many signatures were added.
+func CollectWitnesses() (int, error) {
+	added := 0
+	if len(globals.CacheWitnesses) == 0 {
+		return added, nil
+	}
+	var firstErr error
+	for _, respType := range cacheEntityTypes {
+		cacheLock.Lock()
+		cacheIndex, err := readCacheIndex(respType)
+		cacheLock.Unlock()
+		if err != nil {
+			return added, err
+		}
+		// The witnesses are asked without holding the lock, since they can take a while. The signatures are matched to the caches by their names and their manifests, so a cache that was regenerated in the meantime doesn't get the signature of the old one.
+		collected := make(map[string][]api.WitnessSignature)
+		for _, address := range globals.CacheWitnesses {
+			witnessIdsLock.Lock()
+			known := witnessIds[address]
+			witnessIdsLock.Unlock()
+			var missing []api.ResultCache
+			for _, c := range cacheIndex.Results {
+				if len(c.Manifest) > 0 && (len(known) == 0 || !hasWitness(c, known)) {
+					missing = append(missing, c)
+				}
+			}
+			if len(missing) == 0 {
+				continue
+			}
+			sigs, err2 := askWitness(address, respType, missing)
+			if err2 != nil {
+				logging.Log(1, fmt.Sprintf("A witness could not sign the caches. It will be asked again at the next collection. Witness: %s, Entity type: %s, Error: %s", address, respType, err2))
+				if firstErr == nil {
+					firstErr = err2
+				}
+			}
+			for key, sig := range sigs {
+				collected[key] = append(collected[key], sig)
+			}
+		}
+		if len(collected) == 0 {
+			continue
+		}
+		cacheLock.Lock()
+		current, err3 := readCacheIndex(respType)
+		if err3 != nil {
+			cacheLock.Unlock()
+			return added, err3
+		}
+		for i, _ := range current.Results {
+			c := &current.Results[i]
+			for _, sig := range collected[fmt.Sprint(c.ResponseUrl, ":", c.Manifest)] {
+				if !hasWitness(*c, sig.Witness) {
+					c.Witnesses = append(c.Witnesses, sig)
+					added++
+				}
+			}
+		}
+		err4 := writeCacheIndex(respType, &current)
+		cacheLock.Unlock()
+		if err4 != nil {
+			return added, err4
+		}
+	}
+	return added, firstErr
+}
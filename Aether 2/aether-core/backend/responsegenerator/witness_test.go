@@ -0,0 +1,65 @@
+package responsegenerator_test
+
+import (
+	"aether-core/backend/responsegenerator"
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"strings"
+	"testing"
+)
+
+func witnessedCache(t *testing.T) api.ResultCache {
+	c := api.ResultCache{ResponseUrl: "cache_ab12", StartsFrom: 1000, EndsAt: 2000, Manifest: strings.Repeat("a", 64)}
+	e, ok := responsegenerator.LookupEndpoint("witness")
+	if !ok {
+		t.Fatal("The witness endpoint should be registered.")
+	}
+	r, err := e.Respond(responsegenerator.FilterSet{Witness: [][]string{api.WitnessFilter("origin", "posts", c).Values}})
+	if err != nil {
+		t.Fatalf("The witness should have signed the cache. Error: %s", err)
+	}
+	if len(r.Results) != 1 || len(r.Results[0].Witnesses) != 1 {
+		t.Fatalf("The witness should have sent back the cache with its signature. Results: %v", r.Results)
+	}
+	return r.Results[0]
+}
+
+func TestWitness_Success(t *testing.T) {
+	globals.WitnessEnabled = true
+	defer func() { globals.WitnessEnabled = false }()
+	c := witnessedCache(t)
+	w := c.Witnesses[0]
+	if err := api.VerifyWitness("origin", "posts", c, w); err != nil {
+		t.Errorf("The signature of the witness should verify. Error: %s", err)
+	}
+	globals.TrustedWitnesses = map[string]string{string(w.Witness): w.PublicKey}
+	defer func() { globals.TrustedWitnesses = map[string]string{} }()
+	if err := api.CheckWitnesses("origin", "posts", []api.ResultCache{c}); err != nil {
+		t.Errorf("The cache signed by the trusted witness should be taken. Error: %s", err)
+	}
+}
+
+func TestWitness_Fail_Rewritten(t *testing.T) {
+	globals.WitnessEnabled = true
+	c := witnessedCache(t)
+	globals.WitnessEnabled = false
+	w := c.Witnesses[0]
+	globals.TrustedWitnesses = map[string]string{string(w.Witness): w.PublicKey}
+	defer func() { globals.TrustedWitnesses = map[string]string{} }()
+	// The origin serves another manifest for the cache, with the signature of the old one.
+	rewritten := c
+	rewritten.Manifest = strings.Repeat("b", 64)
+	if err := api.CheckWitnesses("origin", "posts", []api.ResultCache{rewritten}); err == nil {
+		t.Errorf("The cache whose manifest changed after it was witnessed should be refused.")
+	}
+	if err := api.CheckWitnesses("impostor", "posts", []api.ResultCache{c}); err == nil {
+		t.Errorf("The signature of the cache of another node should be refused.")
+	}
+	if _, err := responsegenerator.CollectWitnesses(); err != nil {
+		t.Errorf("Without witnesses to send the caches to, nothing should be collected. Error: %s", err)
+	}
+	e, _ := responsegenerator.LookupEndpoint("witness")
+	if _, err := e.Respond(responsegenerator.FilterSet{Witness: [][]string{api.WitnessFilter("origin", "posts", c).Values}}); err == nil {
+		t.Errorf("A node that is not a witness should not sign.")
+	}
+}
@@ -0,0 +1,560 @@
+// Backend > Server > Admin
+// This file provides the endpoints that let the operator of the node inspect and manage it. Like the frontend endpoints, they are only available from the local machine.
+
+package server
+
+import (
+	"aether-core/backend/responsegenerator"
+	"aether-core/backend/storagereport"
+	"aether-core/backend/telemetry"
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/configstore"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"aether-core/services/peerrules"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CachePlanHandler responds to GET with the plan of the next cache generation run: how many entities and pages each cache would have. It does not generate or write anything. If the "format" query parameter is "text", the plan is returned in the same form as the --dry-run flag prints it.
+func CachePlanHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	gp, err := responsegenerator.PlanCaches()
+	if err != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("The cache generation plan could not be created. Error: %s", err)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(responsegenerator.FormatPlan(gp)))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	jsonResp, err2 := json.Marshal(gp)
+	if err2 != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("The cache generation plan could not be converted to JSON. Error: %s", err2)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResp)
+}
+
+// CacheCommand is the body of the cache management requests. Which fields are needed depends on the command.
+type CacheCommand struct {
+	EntityType string        `json:"entity_type"`
+	Start      api.Timestamp `json:"start"`
+	End        api.Timestamp `json:"end"`
+	CacheName  string        `json:"cache_name"`
+}
+
+// readCacheCommand checks that the request is a local POST, and reads its body.
+func readCacheCommand(w http.ResponseWriter, r *http.Request) (CacheCommand, bool) {
+	var cmd CacheCommand
+	if !isLoopback(r) || r.Method != "POST" {
+		w.WriteHeader(http.StatusNotFound)
+		return cmd, false
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return cmd, false
+	}
+	if len(body) > 0 {
+		err2 := json.Unmarshal(body, &cmd)
+		if err2 != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return cmd, false
+		}
+	}
+	return cmd, true
+}
+
+// respondToCacheCommand writes the outcome of a cache management command. Errors are returned in the body, since they are usually caused by the command itself (i.e. an unknown entity type) and the operator needs to see why.
+func respondToCacheCommand(w http.ResponseWriter, result interface{}, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("Cache management command failed. Error: %s", err)))
+		w.WriteHeader(http.StatusInternalServerError)
+		jsonResp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		w.Write(jsonResp)
+		return
+	}
+	if result == nil {
+		result = map[string]string{"status": "ok"}
+	}
+	jsonResp, err2 := json.Marshal(result)
+	if err2 != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResp)
+}
+
+// CacheRegenerateHandler regenerates the cache of an entity type for a time range. Body: {"entity_type", "start", "end"}
+func CacheRegenerateHandler(w http.ResponseWriter, r *http.Request) {
+	cmd, ok := readCacheCommand(w, r)
+	if !ok {
+		return
+	}
+	err := responsegenerator.RegenerateCache(cmd.EntityType, cmd.Start, cmd.End)
+	respondToCacheCommand(w, nil, err)
+}
+
+// CacheDeleteHandler deletes a cache folder and removes it from the index. Body: {"entity_type", "cache_name"}
+func CacheDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	cmd, ok := readCacheCommand(w, r)
+	if !ok {
+		return
+	}
+	err := responsegenerator.DeleteCache(cmd.EntityType, cmd.CacheName)
+	respondToCacheCommand(w, nil, err)
+}
+
+// CacheRepairHandler makes the index.json of an entity type agree with the cache folders on disk. Body: {"entity_type"}
+func CacheRepairHandler(w http.ResponseWriter, r *http.Request) {
+	cmd, ok := readCacheCommand(w, r)
+	if !ok {
+		return
+	}
+	report, err := responsegenerator.RepairCacheIndex(cmd.EntityType)
+	respondToCacheCommand(w, report, err)
+}
+
+// CacheReindexHandler deletes all caches and creates them again from the database. No body is needed.
+func CacheReindexHandler(w http.ResponseWriter, r *http.Request) {
+	_, ok := readCacheCommand(w, r)
+	if !ok {
+		return
+	}
+	err := responsegenerator.Reindex()
+	respondToCacheCommand(w, nil, err)
+}
+
+// CachePruneHandler deletes the caches older than the cache retention right away, instead of waiting for the janitor. No body is needed.
+func CachePruneHandler(w http.ResponseWriter, r *http.Request) {
+	_, ok := readCacheCommand(w, r)
+	if !ok {
+		return
+	}
+	report, err := responsegenerator.PruneCaches()
+	respondToCacheCommand(w, report, err)
+}
+
+// CacheArchiveHandler moves the caches older than the archive threshold to the archives right away, instead of waiting for the janitor. No body is needed.
+func CacheArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	_, ok := readCacheCommand(w, r)
+	if !ok {
+		return
+	}
+	report, err := responsegenerator.ArchiveCaches()
+	respondToCacheCommand(w, report, err)
+}
+
+// StaticsHandler responds to GET with the orphans in the statics directory, and which of them the next collection would delete. POST collects them right away, instead of waiting for the janitor: the new orphans are flagged, and the ones flagged for longer than the grace are deleted. No body is needed.
+func StaticsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		report, err := responsegenerator.InspectStatics()
+		respondToCacheCommand(w, report, err)
+	case "POST":
+		report, err := responsegenerator.CollectStatics()
+		respondToCacheCommand(w, report, err)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// DedupHandler responds to POST by making the pages that are the same in different caches links to a single file, right away, instead of waiting for the janitor. No body is needed.
+func DedupHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || r.Method != "POST" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	report, err := responsegenerator.DedupCaches()
+	respondToCacheCommand(w, report, err)
+}
+
+// ResponseStoreHandler responds to GET with the response store in use, its quota, and how much the multipart POST responses in the database take.
+func ResponseStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	report, err := responsegenerator.InspectResponseStore()
+	respondToCacheCommand(w, report, err)
+}
+
+// EncoderCommand is the body of the requests that pick the encoder of the responses of an endpoint.
+type EncoderCommand struct {
+	Endpoint string `json:"endpoint"`
+	Encoder  string `json:"encoder"` // Empty goes back to the encoder of the destination.
+}
+
+// EncodersHandler responds to GET with the registered encoders, the encoders of the destinations, and the ones picked for single endpoints. POST picks the encoder of the responses of an endpoint, such as "json-pretty" for "posts", to inspect them without changing the others, until the next restart. Body: {"endpoint", "encoder"}
+func EncodersHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		respondToCacheCommand(w, map[string]interface{}{"encoders": responsegenerator.Encoders(), "destinations": globals.OutputEncoders, "endpoints": responsegenerator.EndpointEncoders()}, nil)
+	case "POST":
+		var cmd EncoderCommand
+		body, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(body, &cmd)
+		}
+		if err == nil && len(cmd.Endpoint) == 0 {
+			err = errors.New("The endpoint is missing.")
+		}
+		if err == nil {
+			err = responsegenerator.SetEndpointEncoder(cmd.Endpoint, cmd.Encoder)
+		}
+		respondToCacheCommand(w, nil, err)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// HookCommand is the body of the requests that enable or disable a response hook.
+type HookCommand struct {
+	Hook    string `json:"hook"`
+	Enabled bool   `json:"enabled"`
+}
+
+// HooksHandler responds to GET with the response hooks, in the order they run, and how they have been doing. POST enables or disables a hook, such as one that was disabled for failing too many times in a row. Body: {"hook", "enabled"}
+func HooksHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		respondToCacheCommand(w, responsegenerator.Hooks(), nil)
+	case "POST":
+		var cmd HookCommand
+		body, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(body, &cmd)
+		}
+		if err == nil && len(cmd.Hook) == 0 {
+			err = errors.New("The hook is missing.")
+		}
+		if err == nil {
+			err = responsegenerator.SetHookEnabled(cmd.Hook, cmd.Enabled)
+		}
+		respondToCacheCommand(w, nil, err)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// respondToPeerRuleCommand writes the outcome of a peer rule command, in the same way as the cache commands.
+func respondToPeerRuleCommand(w http.ResponseWriter, result interface{}, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("Peer rule command failed. Error: %s", err)))
+		w.WriteHeader(http.StatusBadRequest)
+		jsonResp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		w.Write(jsonResp)
+		return
+	}
+	jsonResp, err2 := json.Marshal(result)
+	if err2 != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResp)
+}
+
+// readPeerRule reads a peer rule from the body of the request.
+func readPeerRule(r *http.Request) (globals.PeerRule, error) {
+	var rule globals.PeerRule
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return rule, err
+	}
+	err2 := json.Unmarshal(body, &rule)
+	if err2 != nil {
+		return rule, errors.New(fmt.Sprintf("The peer rule could not be parsed. Error: %s", err2))
+	}
+	return rule, nil
+}
+
+// PeerRulesHandler responds to GET with the peer rules in effect, and adds a rule on POST. Body: {"node_id", "cidr", "allow", "reason", "expiry"}
+func PeerRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		respondToPeerRuleCommand(w, map[string]interface{}{"whitelist_only": globals.PeerWhitelistOnly, "rules": peerrules.List()}, nil)
+	case "POST":
+		rule, err := readPeerRule(r)
+		if err == nil {
+			err = peerrules.Add(rule)
+		}
+		respondToPeerRuleCommand(w, map[string]string{"status": "ok"}, err)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// PeerRulesRemoveHandler removes the rules added at runtime with the given node id and IP range. Body: {"node_id", "cidr"}
+func PeerRulesRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || r.Method != "POST" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	rule, err := readPeerRule(r)
+	if err != nil {
+		respondToPeerRuleCommand(w, nil, err)
+		return
+	}
+	removed, err2 := peerrules.Remove(rule.NodeId, rule.CIDR)
+	respondToPeerRuleCommand(w, map[string]int{"removed": removed}, err2)
+}
+
+// ConfigHandler responds to GET with the outcome of the last time the config file was read: the settings applied, the ones that need a restart, and the error if the changes were rejected. POST checks the file for changes right away, instead of waiting for the next check.
+func ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || (r.Method != "GET" && r.Method != "POST") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == "POST" {
+		configstore.Reload()
+	}
+	jsonResp, err := json.Marshal(configstore.LastReport())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResp)
+}
+
+// QueryTimingsHandler responds to GET with the timings of the database queries since the start, the ones that took the most time in total first. POST resets them, so that the effect of a change, such as a new index, can be seen.
+func QueryTimingsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || (r.Method != "GET" && r.Method != "POST") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == "POST" {
+		persistence.ResetQueryTimings()
+	}
+	jsonResp, err := json.Marshal(persistence.QueryTimings())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResp)
+}
+
+// IndexesHandler responds to GET with the indexes the filters need, and whether the database has them, is creating them, or failed to. The states are as of the check at the start; POST checks the database again.
+func IndexesHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || (r.Method != "GET" && r.Method != "POST") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == "POST" {
+		_, err := persistence.MissingIndexes()
+		if err != nil {
+			logging.Log(1, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	jsonResp, err2 := json.Marshal(persistence.IndexStates())
+	if err2 != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResp)
+}
+
+// ReplicaHandler responds to GET with the state of the read replica of the database: whether the reads go to it, how far behind it is, and why not if they don't. POST checks the replica again right away, instead of waiting for the next check.
+func ReplicaHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || (r.Method != "GET" && r.Method != "POST") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == "POST" {
+		persistence.CheckReplica()
+	}
+	jsonResp, err := json.Marshal(persistence.GetReplicaStatus())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResp)
+}
+
+// StorageReportHandler responds to GET with how much the node stores per entity type, in the database and in the caches, how much it grew in the last week, and the largest boards and threads. If the "format" query parameter is "text", the report is returned in the same form as the --storage-report flag prints it.
+func StorageReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	report, err := storagereport.Generate(globals.StorageReportLargest)
+	if err != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("The storage report could not be generated. Error: %s", err)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(report.String()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	jsonResp, err2 := json.Marshal(report)
+	if err2 != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResp)
+}
+
+// maxProvenanceLimit bounds how many records a provenance request gives.
+const maxProvenanceLimit = 10000
+
+// ProvenanceHandler responds to GET with where the entities came from the first time they arrived. With the comma separated "fingerprints" query parameter, it gives the provenance of those entities; with "node", the entities that node was the first to deliver, the latest first; with neither, how many entities each node was the first to deliver, per entity type, the most first. "since" leaves out what was first seen before it, and "limit" bounds how many are given, 100 unless given.
+func ProvenanceHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	since, limit := int64(0), 100
+	var err error
+	if len(q.Get("since")) > 0 {
+		since, err = strconv.ParseInt(q.Get("since"), 10, 64)
+	}
+	if err == nil && len(q.Get("limit")) > 0 {
+		limit, err = strconv.Atoi(q.Get("limit"))
+	}
+	if err != nil || since < 0 || limit <= 0 || limit > maxProvenanceLimit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("The since and the limit have to be positive numbers, and the limit no more than %d.", maxProvenanceLimit)))
+		return
+	}
+	var result interface{}
+	var err2 error
+	switch {
+	case len(q.Get("fingerprints")) > 0:
+		var fps []api.Fingerprint
+		for _, fp := range strings.Split(q.Get("fingerprints"), ",") {
+			if len(fp) > 0 {
+				fps = append(fps, api.Fingerprint(fp))
+			}
+		}
+		result, err2 = persistence.ReadProvenance(fps)
+	case len(q.Get("node")) > 0:
+		result, err2 = persistence.ReadProvenanceOfNode(api.Fingerprint(q.Get("node")), api.Timestamp(since), limit)
+	default:
+		result, err2 = persistence.ReadProvenanceByNode(api.Timestamp(since), limit)
+	}
+	respondToCacheCommand(w, result, err2)
+}
+
+// LocalEntities is the response to the operator adding entities.
+type LocalEntities struct {
+	Statuses       []api.EntityStatus `json:"statuses"`
+	AddressesAdded int                `json:"addresses_added"`
+}
+
+// EntitiesHandler responds to POST by adding the entities in the body, which is in the form of the body of a page, and to GET with when the entities given by the "type" and the comma separated "fingerprints" query parameters arrived, leaving out the ones that haven't. The entities are verified like the ones the remotes submit. The addresses are added as they are, as if the node had connected to them; this is how the operator, or a developer tool, gives the node its first peers.
+func EntitiesHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || (r.Method != "GET" && r.Method != "POST") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var result interface{}
+	if r.Method == "GET" {
+		var fps []api.Fingerprint
+		for _, fp := range strings.Split(r.URL.Query().Get("fingerprints"), ",") {
+			if len(fp) > 0 {
+				fps = append(fps, api.Fingerprint(fp))
+			}
+		}
+		arrivals, err := persistence.ReadArrivals(r.URL.Query().Get("type"), fps)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		result = arrivals
+	} else {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var body api.Answer
+		err2 := json.Unmarshal(b, &body)
+		if err2 != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("The entities could not be parsed. Error: %s", err2)))
+			return
+		}
+		var le LocalEntities
+		for i, _ := range body.Addresses {
+			err3 := persistence.InsertOrUpdateAddress(body.Addresses[i])
+			if err3 != nil {
+				logging.Log(1, errors.New(fmt.Sprintf("The address given by the operator could not be added. Error: %s", err3)))
+				continue
+			}
+			le.AddressesAdded++
+		}
+		body.Addresses = nil
+		le.Statuses = responsegenerator.AcceptLocal(body)
+		result = le
+	}
+	jsonResp, err4 := json.Marshal(result)
+	if err4 != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResp)
+}
+
+// TelemetryHandler responds to GET with the state of the telemetry, and the report as it would be sent now, so that the operator can see what is reported before opting in.
+func TelemetryHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	status, err := telemetry.Current()
+	if err != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("The telemetry report could not be generated. Error: %s", err)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	jsonResp, err2 := json.Marshal(status)
+	if err2 != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResp)
+}
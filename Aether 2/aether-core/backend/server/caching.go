@@ -0,0 +1,118 @@
+// Backend > Server > Caching
+// This file serves the caches and the multi-page POST responses with the headers that let remotes ask for them conditionally. Remotes poll the index.json of every entity type on every sync, and most of the time it has not changed since the last one; with an ETag, such a poll is answered with a 304 and no body.
+
+package server
+
+import (
+	"aether-core/backend/responsegenerator"
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheETag is the entity tag of a served file. The files are rewritten whenever the caches are regenerated, so the time of the last write, with the size, changes whenever the content does.
+func cacheETag(fi os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", fi.ModTime().UnixNano(), fi.Size())
+}
+
+// ServeCacheFile serves a file of the caches or of the responses. http.ServeFile sets Last-Modified, and answers If-None-Match and If-Modified-Since with a 304 once the ETag is set.
+func ServeCacheFile(w http.ResponseWriter, r *http.Request, path string) {
+	fi, err := os.Stat(path)
+	if err == nil && !fi.IsDir() {
+		w.Header().Set("ETag", cacheETag(fi))
+		// The remotes can keep a copy, but they have to ask whether it is still current before they use it.
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	http.ServeFile(w, r, path)
+}
+
+// cachePagePath gives the file to serve for a request of the caches. The page of a cache is checked against the manifest of its cache first, and if it is missing or damaged, the cache is regenerated, and the remote gets the page of the regenerated cache instead of a 404 or garbage.
+func cachePagePath(r *http.Request) string {
+	path := strings.TrimPrefix(r.URL.Path, "/v0/")
+	if path == r.URL.Path {
+		return fmt.Sprint(globals.UserDirectory, "/statics/caches", r.URL.Path)
+	}
+	served, err := responsegenerator.EnsureCachePage(path)
+	if err != nil {
+		logging.LogTrace(traceOf(r), 1, err)
+	}
+	return fmt.Sprint(globals.CachesLocation, "/", served)
+}
+
+// serveArchivedPage serves a file of the caches from the archives, if it is not in the statics directory and an archive has it. It gives false if the file was not served, for the file server to answer.
+func serveArchivedPage(w http.ResponseWriter, r *http.Request, path string) bool {
+	if _, err := os.Stat(path); err == nil || !strings.HasPrefix(path, globals.CachesLocation+"/") {
+		return false
+	}
+	data, found, err2 := responsegenerator.ReadArchivedCachePage(strings.TrimPrefix(path, globals.CachesLocation+"/"))
+	if err2 != nil {
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("A page of a cache could not be read from the archives. Path: %s, Error: %s", r.URL.Path, err2))
+	}
+	if !found {
+		return false
+	}
+	// The archived caches don't change, so the hash of a page is its ETag.
+	w.Header().Set("ETag", fmt.Sprintf("\"%s\"", api.HashBytes(data)[:32]))
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, filepath.Base(path), time.Time{}, bytes.NewReader(data))
+	return true
+}
+
+// serveStoredResponse serves a page of a multipart POST response from the database, if it is not in the statics directory and is in the database. It gives false if the page was not served, for the file server to answer.
+func serveStoredResponse(w http.ResponseWriter, r *http.Request, path string) bool {
+	if _, err := os.Stat(path); err == nil {
+		return false
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/responses/"), "/")
+	if len(parts) != 2 || !strings.HasSuffix(parts[1], ".json") {
+		return false
+	}
+	page, err := strconv.Atoi(strings.TrimSuffix(parts[1], ".json"))
+	if err != nil || page < 0 {
+		return false
+	}
+	data, found, err2 := responsegenerator.ReadStoredResponsePage(parts[0], page)
+	if err2 != nil {
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("A page of a response could not be read from the database. Path: %s, Error: %s", r.URL.Path, err2))
+	}
+	if !found {
+		return false
+	}
+	// The pages don't change once published, so the hash of a page is its ETag.
+	w.Header().Set("ETag", fmt.Sprintf("\"%s\"", api.HashBytes(data)[:32]))
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, parts[1], time.Time{}, bytes.NewReader(data))
+	return true
+}
+
+// serveCachePageRange serves the entity pages of a cache in the range parameter of the request, such as "12-20" of "/v0/posts/cache_x?range=12-20", one after another. A range that is malformed is a 400, and one that can't be served, for any reason, a 404, so that the remote asks for the pages one by one.
+func serveCachePageRange(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v0/"), "/"), "/")
+	if !strings.HasPrefix(r.URL.Path, "/v0/") || len(parts) != 2 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	first, last, err := api.ParsePageRange(r.URL.Query().Get("range"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	data, found, err2 := responsegenerator.ReadCachePages(parts[0], parts[1], first, last)
+	if err2 != nil {
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("A page range could not be served. Path: %s, Range: %d-%d, Error: %s", r.URL.Path, first, last, err2))
+	}
+	if !found || err2 != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(data)
+}
@@ -0,0 +1,39 @@
+// Backend > Server > Clients
+// This file reads the X-Aether headers of the requests from the remotes into the statistics of their clients, and lets the operator see them.
+
+package server
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/logging"
+	"aether-core/services/peerclients"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// noteClient counts the request in the statistics of the client of the remote, if its headers say which client it is.
+func noteClient(r *http.Request) {
+	c, found := api.ParseClientHeaders(r.Header)
+	if !found {
+		return
+	}
+	peerclients.Note(remoteHost(r), c)
+}
+
+// PeerClientsHandler responds to GET with the clients of the remotes that reached this node recently, as their headers give them, and how many of them run each client version.
+func PeerClientsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	jsonResp, err := json.Marshal(peerclients.GetReport())
+	if err != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("The statistics of the peer clients could not be converted to JSON. Error: %s", err)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResp)
+}
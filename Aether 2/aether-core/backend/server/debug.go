@@ -0,0 +1,82 @@
+// Backend > Server > Debug
+// This file serves the runtime profiles of the node to the operator, so that go tool pprof can be pointed at a running node. net/http/pprof is not used, because importing it registers its handlers on the default mux, which is the one the public endpoints are served from; these handlers only answer to the local machine.
+
+package server
+
+import (
+	"aether-core/backend/profiling"
+	"aether-core/services/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCPUProfileSeconds is the longest CPU profile that can be asked for.
+const maxCPUProfileSeconds = 300
+
+// ProfileHandler responds to GET with a runtime profile. /admin/debug/pprof/ lists the profiles, /admin/debug/pprof/<name> writes the profile with that name, and /admin/debug/pprof/profile?seconds=30 profiles the CPU for that long. The "debug" query parameter is passed on as the debug level, so debug=1 gives text instead of the binary format.
+func ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/admin/debug/pprof/")
+	if len(name) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		jsonResp, _ := json.Marshal(append(profiling.ProfileNames(), "profile"))
+		w.Write(jsonResp)
+		return
+	}
+	debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	}
+	if name == "profile" {
+		seconds, err := strconv.Atoi(r.URL.Query().Get("seconds"))
+		if err != nil || seconds <= 0 {
+			seconds = 30
+		}
+		if seconds > maxCPUProfileSeconds {
+			seconds = maxCPUProfileSeconds
+		}
+		err2 := profiling.WriteCPUProfile(w, time.Duration(seconds)*time.Second)
+		if err2 != nil {
+			logging.Log(1, err2)
+			w.Header().Del("Content-Disposition")
+			w.WriteHeader(http.StatusConflict)
+		}
+		return
+	}
+	err := profiling.WriteProfile(w, name, debug)
+	if err != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("The profile could not be written. Error: %s", err)))
+		w.Header().Del("Content-Disposition")
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// ProfileSnapshotHandler writes a snapshot of the heap and the goroutines into the profiles folder of the user directory right away, whether or not the periodic snapshots are enabled. POST, no body is needed.
+func ProfileSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || r.Method != "POST" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := profiling.WriteSnapshot()
+	if err != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("The profile snapshot could not be written. Error: %s", err)))
+		w.WriteHeader(http.StatusInternalServerError)
+		jsonResp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		w.Write(jsonResp)
+		return
+	}
+	jsonResp, _ := json.Marshal(map[string]string{"directory": profiling.SnapshotDirectory()})
+	w.Write(jsonResp)
+}
@@ -0,0 +1,96 @@
+// Backend > Server > Drafts
+// This file provides the drafts of the local user to the frontend: saving them, and scheduling them to be published later, by the backend, whether the frontend is open then or not.
+
+package server
+
+import (
+	"aether-core/backend/drafts"
+	"aether-core/io/api"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// draftCommand is the body of the commands on a saved draft.
+type draftCommand struct {
+	Id        string        `json:"id"`
+	PublishAt api.Timestamp `json:"publish_at"` // schedule
+}
+
+func readDraftCommand(r *http.Request) (draftCommand, error) {
+	var cmd draftCommand
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return cmd, err
+	}
+	err2 := json.Unmarshal(body, &cmd)
+	if err2 != nil {
+		return cmd, errors.New(fmt.Sprintf("The draft command could not be parsed. Error: %s", err2))
+	}
+	if len(cmd.Id) == 0 {
+		return cmd, errors.New("The draft command needs the id of the draft.")
+	}
+	return cmd, nil
+}
+
+// DraftsHandler responds to GET with the drafts of the user, or the one with the "id" query parameter, and saves a draft on POST. A draft without an id is new. Body: {"id", "kind": "thread" | "post", "board", "thread", "parent", "name", "body", "link"}
+func DraftsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		if id := r.URL.Query().Get("id"); len(id) > 0 {
+			d, err := drafts.Get(id)
+			respondToFrontendCommand(w, d, err)
+			return
+		}
+		ds, err := drafts.List()
+		respondToFrontendCommand(w, ds, err)
+	case "POST":
+		var d drafts.Draft
+		body, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(body, &d)
+		}
+		if err != nil {
+			respondToFrontendCommand(w, nil, errors.New(fmt.Sprintf("The draft could not be parsed. Error: %s", err)))
+			return
+		}
+		saved, err2 := drafts.Save(d)
+		respondToFrontendCommand(w, saved, err2)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// DraftCommandHandler runs a command on a saved draft of the user: /frontend/drafts/delete, /frontend/drafts/schedule or /frontend/drafts/unschedule. Body: {"id"}, and {"publish_at"} for schedule, where 0 publishes it right away.
+func DraftCommandHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) || r.Method != "POST" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	cmd, err := readDraftCommand(r)
+	if err != nil {
+		respondToFrontendCommand(w, nil, err)
+		return
+	}
+	switch r.URL.Path {
+	case "/frontend/drafts/delete":
+		err2 := drafts.Delete(cmd.Id)
+		respondToFrontendCommand(w, map[string]string{"status": "ok"}, err2)
+	case "/frontend/drafts/schedule":
+		d, err3 := drafts.Schedule(cmd.Id, cmd.PublishAt)
+		respondToFrontendCommand(w, d, err3)
+	case "/frontend/drafts/unschedule":
+		d, err4 := drafts.Unschedule(cmd.Id)
+		respondToFrontendCommand(w, d, err4)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,150 @@
+// Backend > Server > Enumeration
+// This file keeps the remotes from scraping the database through the fingerprint filters of the POST endpoints. A remote can ask for only so many fingerprints in a request and in an hour, and one whose requests walk the fingerprints in order, request after request, is taken to be enumerating them and is refused for an hour. The refused requests are answered with 429 Too Many Requests, and the remote is scored down as if it went over the inbound limits.
+
+package server
+
+import (
+	"aether-core/backend/dispatch"
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fingerprintQueryWindow is the window the hourly limit counts in.
+const fingerprintQueryWindow = time.Hour
+
+type fingerprintUsage struct {
+	windowStart    time.Time
+	count          int             // Fingerprints asked for in the window.
+	ascendingRun   int             // Requests in a row that started after where the previous one ended.
+	lastMax        api.Fingerprint // The largest fingerprint of the previous request.
+	throttledUntil time.Time       // Set when the remote is found scanning.
+}
+
+var fingerprintUsageLock sync.Mutex
+var fingerprintUsageMap = make(map[string]*fingerprintUsage) // Remote host > usage
+
+// fingerprintQuery is the part of a request the limits look at.
+type fingerprintQuery struct {
+	Address struct {
+		Port uint16 `json:"port"`
+	} `json:"address"`
+	Filters []api.Filter `json:"filters"`
+}
+
+func (q *fingerprintQuery) fingerprints() []api.Fingerprint {
+	var fps []api.Fingerprint
+	for _, filter := range q.Filters {
+		if filter.Type == "fingerprint" {
+			for _, fp := range filter.Values {
+				fps = append(fps, api.Fingerprint(fp))
+			}
+		}
+	}
+	return fps
+}
+
+// admitFingerprints counts the fingerprints asked for by the remote against its limits. If the request is refused, it returns for how long the remote should wait before asking again.
+func admitFingerprints(host string, fps []api.Fingerprint) (time.Duration, error) {
+	if len(fps) == 0 {
+		return 0, nil
+	}
+	if globals.FingerprintQueryMaxPerRequest > 0 && len(fps) > globals.FingerprintQueryMaxPerRequest {
+		return 0, errors.New(fmt.Sprintf("The remote asked for too many fingerprints in a single request. Address: %s, Fingerprints: %d, Maximum: %d", host, len(fps), globals.FingerprintQueryMaxPerRequest))
+	}
+	now := clock.Now()
+	fingerprintUsageLock.Lock()
+	defer fingerprintUsageLock.Unlock()
+	for h, u := range fingerprintUsageMap {
+		if now.Sub(u.windowStart) >= fingerprintQueryWindow && now.After(u.throttledUntil) {
+			delete(fingerprintUsageMap, h)
+		}
+	}
+	u, ok := fingerprintUsageMap[host]
+	if !ok {
+		u = &fingerprintUsage{windowStart: now}
+		fingerprintUsageMap[host] = u
+	}
+	if now.Before(u.throttledUntil) {
+		return u.throttledUntil.Sub(now), errors.New(fmt.Sprintf("The remote was found scanning the fingerprints, and it is refused for a while. Address: %s, Until: %s", host, u.throttledUntil.Format(time.RFC3339)))
+	}
+	if now.Sub(u.windowStart) >= fingerprintQueryWindow {
+		u.windowStart = now
+		u.count = 0
+	}
+	if globals.FingerprintQueryMaxPerHour > 0 && u.count+len(fps) > globals.FingerprintQueryMaxPerHour {
+		return u.windowStart.Add(fingerprintQueryWindow).Sub(now), errors.New(fmt.Sprintf("The remote asked for too many fingerprints in the last hour. Address: %s, Fingerprints: %d, Maximum: %d", host, u.count+len(fps), globals.FingerprintQueryMaxPerHour))
+	}
+	u.count += len(fps)
+	min, max := fps[0], fps[0]
+	for _, fp := range fps {
+		if fp < min {
+			min = fp
+		}
+		if fp > max {
+			max = fp
+		}
+	}
+	if len(u.lastMax) > 0 && min > u.lastMax {
+		u.ascendingRun++
+	} else {
+		u.ascendingRun = 1
+	}
+	u.lastMax = max
+	if globals.FingerprintScanRunLength > 0 && u.ascendingRun >= globals.FingerprintScanRunLength {
+		u.ascendingRun = 0
+		u.lastMax = ""
+		u.throttledUntil = now.Add(fingerprintQueryWindow)
+		return fingerprintQueryWindow, errors.New(fmt.Sprintf("The remote is walking the fingerprints in order. It seems to be enumerating the database. Address: %s, Requests in order: %d", host, globals.FingerprintScanRunLength))
+	}
+	return 0, nil
+}
+
+// LimitFingerprintQueries refuses the POST requests that go over the fingerprint query limits, before they reach the handler.
+func LimitFingerprintQueries(handler http.Handler) http.Handler {
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || !strings.HasPrefix(r.URL.Path, "/v0/") {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// The handler reads the body again.
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		var q fingerprintQuery
+		err2 := json.Unmarshal(b, &q)
+		if err2 != nil {
+			// Not ours to refuse. The handler will find it unparseable.
+			handler.ServeHTTP(w, r)
+			return
+		}
+		host := remoteHost(r)
+		retryAfter, err3 := admitFingerprints(host, q.fingerprints())
+		if err3 != nil {
+			logging.LogSampled("server", "fingerprint-limits", 1, err3)
+			dispatch.RecordAbuse(api.Address{Location: api.Location(host), Port: q.Address.Port}, err3)
+			if retryAfter > 0 {
+				w.Header().Set("Retry-After", fmt.Sprint(int64((retryAfter+time.Second-1)/time.Second)))
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
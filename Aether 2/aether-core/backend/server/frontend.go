@@ -0,0 +1,415 @@
+// Backend > Server > Frontend
+// This file provides the endpoints that only the local frontend can access, such as the notifications of the local user and the ranked threads of a board. The content filters of the user are applied to everything given from here.
+
+package server
+
+import (
+	"aether-core/backend/contentfilters"
+	"aether-core/backend/notifications"
+	"aether-core/backend/orphans"
+	"aether-core/backend/ranking"
+	"aether-core/backend/replytree"
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/composition"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"aether-core/services/syncprogress"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// isLoopback checks whether the request is coming from the local machine. Frontend endpoints are not available to remotes.
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return ip.IsLoopback()
+}
+
+// NotificationsHandler responds to GET with the notifications of the local user. If the "unseen" query parameter is "true", only the notifications that are not seen yet are returned.
+func NotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) || r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	onlyUnseen := r.URL.Query().Get("unseen") == "true"
+	ns, err := notifications.List(onlyUnseen)
+	if err != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("Notifications could not be read. Error: %s", err)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	ns = filterNotifications(ns)
+	if ns == nil {
+		ns = []notifications.Notification{}
+	}
+	jsonResp, err2 := json.Marshal(ns)
+	if err2 != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("Notifications could not be converted to JSON. Error: %s", err2)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResp)
+}
+
+// SyncProgressHandler responds to GET with the progress of the running syncs, and of the ones that finished in the last hour: the cache pages expected and arrived, the bytes, and an estimate of the seconds left.
+func SyncProgressHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) || r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	jsonResp, err := json.Marshal(syncprogress.Report())
+	if err != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("Sync progress could not be converted to JSON. Error: %s", err)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResp)
+}
+
+// CompositionHandler responds to GET with the composition limits of the network the node is in, and how much of them the user used in the last minute, so that the frontend can tell the user before they hit one.
+func CompositionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) || r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	respondToFrontendCommand(w, composition.Current(), nil)
+}
+
+// loadContentFilters loads the content filters of the user. If they can't be read, the view is given unfiltered rather than not at all.
+func loadContentFilters() *contentfilters.Set {
+	set, err := contentfilters.Load()
+	if err != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("Content filters could not be read. Error: %s", err)))
+	}
+	return set
+}
+
+// filterNotifications leaves out the notifications of the posts the user muted, and marks the ones to be collapsed.
+func filterNotifications(ns []notifications.Notification) []notifications.Notification {
+	if len(ns) == 0 {
+		return ns
+	}
+	set := loadContentFilters()
+	var fps []api.Fingerprint
+	for i, _ := range ns {
+		fps = append(fps, ns[i].Post)
+	}
+	posts, err := persistence.ReadPosts(fps, 0, 0)
+	if err != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("The posts of the notifications could not be read to apply the content filters. Error: %s", err)))
+	}
+	byFp := make(map[api.Fingerprint]api.Post)
+	for i, _ := range posts {
+		byFp[posts[i].Fingerprint] = posts[i]
+	}
+	// The notifications of the threads in the watched boards are of the threads themselves.
+	var threadFps []api.Fingerprint
+	for i, _ := range ns {
+		if _, ok := byFp[ns[i].Post]; !ok && ns[i].Post == ns[i].Thread {
+			threadFps = append(threadFps, ns[i].Post)
+		}
+	}
+	threadsByFp := make(map[api.Fingerprint]api.Thread)
+	if len(threadFps) > 0 {
+		threads, err2 := persistence.ReadThreads(threadFps, 0, 0)
+		if err2 != nil {
+			logging.Log(1, errors.New(fmt.Sprintf("The threads of the notifications could not be read to apply the content filters. Error: %s", err2)))
+		}
+		for i, _ := range threads {
+			threadsByFp[threads[i].Fingerprint] = threads[i]
+		}
+	}
+	var result []notifications.Notification
+	for i, _ := range ns {
+		p := byFp[ns[i].Post]
+		action := set.Match(p.Board, ns[i].Owner, p.Body)
+		if t, ok := threadsByFp[ns[i].Post]; ok {
+			action = set.Match(t.Board, t.Owner, t.Name, t.Body, t.Link)
+		}
+		switch action {
+		case contentfilters.ActionHide:
+			continue
+		case contentfilters.ActionCollapse:
+			ns[i].Collapsed = true
+		}
+		result = append(result, ns[i])
+	}
+	return result
+}
+
+// filterThreads leaves out the threads the user muted, and marks the ones to be collapsed.
+func filterThreads(threads []ranking.RankedThread) []ranking.RankedThread {
+	if len(threads) == 0 {
+		return threads
+	}
+	set := loadContentFilters()
+	var result []ranking.RankedThread
+	for i, _ := range threads {
+		t := threads[i].Thread
+		switch set.Match(t.Board, t.Owner, t.Name, t.Body, t.Link) {
+		case contentfilters.ActionHide:
+			continue
+		case contentfilters.ActionCollapse:
+			threads[i].Collapsed = true
+		}
+		result = append(result, threads[i])
+	}
+	return result
+}
+
+// NotificationsSeenHandler responds to POST by marking the notifications of the given posts as seen. The body is a JSON array of post fingerprints. An empty array marks all notifications as seen.
+func NotificationsSeenHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) || r.Method != "POST" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var posts []api.Fingerprint
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(body) > 0 {
+		err2 := json.Unmarshal(body, &posts)
+		if err2 != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	err3 := notifications.MarkSeen(posts)
+	if err3 != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("Notifications could not be marked as seen. Error: %s", err3)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// rankedThreadsPage is the response of the ranked threads endpoint.
+type rankedThreadsPage struct {
+	Board      interface{}            `json:"board"` // The board, or a placeholder for it if it hasn't arrived yet.
+	Data       []ranking.RankedThread `json:"data"`
+	NextCursor string                 `json:"next_cursor"` // Empty on the last page.
+}
+
+// RankedThreadsHandler responds to GET with a page of the threads of a board, ranked. The query parameters are "board" (required), "order" ("hot", "top" or "new", hot by default), "limit", and "cursor" (the next_cursor of the previous page).
+func RankedThreadsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) || r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	board := api.Fingerprint(q.Get("board"))
+	if len(board) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	order := q.Get("order")
+	if len(order) == 0 {
+		order = ranking.OrderHot
+	}
+	limit := globals.RankingPageSize
+	if len(q.Get("limit")) > 0 {
+		l, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || l < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+	if limit > globals.RankingMaxPageSize {
+		limit = globals.RankingMaxPageSize
+	}
+	threads, next, err2 := ranking.List(board, order, q.Get("cursor"), limit)
+	if err2 != nil {
+		logging.Log(2, errors.New(fmt.Sprintf("Ranked threads could not be read. Error: %s", err2)))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	threads = filterThreads(threads)
+	if threads == nil {
+		threads = []ranking.RankedThread{}
+	}
+	// The threads of a board can arrive before the board itself. They are still shown, with a placeholder for the board until it arrives.
+	boardEntity, err4 := orphans.BoardOrPlaceholder(board)
+	if err4 != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("The board of the ranked threads could not be read. Error: %s", err4)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	jsonResp, err3 := json.Marshal(rankedThreadsPage{Board: boardEntity, Data: threads, NextCursor: next})
+	if err3 != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("Ranked threads could not be converted to JSON. Error: %s", err3)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResp)
+}
+
+// filterReplyTree marks the nodes of the posts the user muted as hidden, and the ones to be collapsed. The hidden nodes stay, without their posts, so that the replies under them still have a place.
+func filterReplyTree(nodes []replytree.Node) {
+	if len(nodes) == 0 {
+		return
+	}
+	set := loadContentFilters()
+	for i, _ := range nodes {
+		p := nodes[i].Post
+		if p == nil {
+			continue
+		}
+		switch set.Match(p.Board, p.Owner, p.Body) {
+		case contentfilters.ActionHide:
+			nodes[i].Post = nil
+			nodes[i].Hidden = true
+		case contentfilters.ActionCollapse:
+			nodes[i].Collapsed = true
+		}
+	}
+}
+
+// replyTreePage is the response of the reply tree endpoint.
+type replyTreePage struct {
+	Data       []replytree.Node `json:"data"`
+	NextCursor string           `json:"next_cursor"` // Empty on the last page.
+}
+
+// ReplyTreeHandler responds to GET with a page of the reply tree of a thread, depth first. The query parameters are "thread" (required), "root" (a post of the thread to give the replies under, instead of the whole thread), "depth" (how many levels under the root), "limit", and "cursor" (the next_cursor of the previous page).
+func ReplyTreeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) || r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	thread := api.Fingerprint(q.Get("thread"))
+	if len(thread) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	depth := globals.ReplyTreeDepth
+	if len(q.Get("depth")) > 0 {
+		d, err := strconv.Atoi(q.Get("depth"))
+		if err != nil || d < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		depth = d
+	}
+	limit := globals.ReplyTreePageSize
+	if len(q.Get("limit")) > 0 {
+		l, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || l < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+	if limit > globals.ReplyTreeMaxPageSize {
+		limit = globals.ReplyTreeMaxPageSize
+	}
+	nodes, next, err2 := replytree.Tree(thread, api.Fingerprint(q.Get("root")), depth, q.Get("cursor"), limit)
+	if err2 != nil {
+		logging.Log(2, errors.New(fmt.Sprintf("The reply tree could not be read. Error: %s", err2)))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	filterReplyTree(nodes)
+	if nodes == nil {
+		nodes = []replytree.Node{}
+	}
+	jsonResp, err3 := json.Marshal(replyTreePage{Data: nodes, NextCursor: next})
+	if err3 != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("The reply tree could not be converted to JSON. Error: %s", err3)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResp)
+}
+
+// respondToFrontendCommand writes the result of a command as JSON, or the error with a 400.
+func respondToFrontendCommand(w http.ResponseWriter, result interface{}, err error) {
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		jsonResp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		w.Write(jsonResp)
+		return
+	}
+	jsonResp, err2 := json.Marshal(result)
+	if err2 != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResp)
+}
+
+// readContentFilter reads a content filter from the body of the request.
+func readContentFilter(r *http.Request) (contentfilters.Filter, error) {
+	var f contentfilters.Filter
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return f, err
+	}
+	err2 := json.Unmarshal(body, &f)
+	if err2 != nil {
+		return f, errors.New(fmt.Sprintf("The content filter could not be parsed. Error: %s", err2))
+	}
+	return f, nil
+}
+
+// ContentFiltersHandler responds to GET with the content filters of the user, and adds a filter on POST. Body: {"type": "keyword" | "regex" | "author" | "board", "value", "action": "hide" | "collapse"}
+func ContentFiltersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		fs, err := contentfilters.List()
+		if fs == nil {
+			fs = []contentfilters.Filter{}
+		}
+		respondToFrontendCommand(w, fs, err)
+	case "POST":
+		f, err := readContentFilter(r)
+		if err == nil {
+			err = contentfilters.Add(f)
+		}
+		respondToFrontendCommand(w, map[string]string{"status": "ok"}, err)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// ContentFiltersRemoveHandler removes the content filter with the given type and value. Body: {"type", "value"}
+func ContentFiltersRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) || r.Method != "POST" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f, err := readContentFilter(r)
+	if err != nil {
+		respondToFrontendCommand(w, nil, err)
+		return
+	}
+	removed, err2 := contentfilters.Remove(f.Type, f.Value)
+	respondToFrontendCommand(w, map[string]int64{"removed": removed}, err2)
+}
@@ -0,0 +1,177 @@
+// Backend > Server > Health
+// This file provides the health check that load balancers and monitoring can poll. It probes the things the node can't work without, and reports each of them separately.
+
+package server
+
+import (
+	"aether-core/backend/responsegenerator"
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	HealthPass = "pass"
+	HealthWarn = "warn"
+	HealthFail = "fail"
+)
+
+// HealthCheck is the result of a single probe.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Details string `json:"details,omitempty"`
+}
+
+// HealthReport is the body of the health response. Its status is the worst status of its checks.
+type HealthReport struct {
+	Status    string        `json:"status"`
+	Timestamp int64         `json:"timestamp"`
+	Checks    []HealthCheck `json:"checks"`
+}
+
+// minSaneTimestamp is a moment before this code was written. A clock that says it is earlier than this is wrong.
+const minSaneTimestamp = 1483228800 // 2017-01-01
+
+// maxClockDrift is how far the local clock can be from the database clock before it is reported.
+const maxClockDrift = 5 * time.Minute
+
+// checkDatabase probes the database, and compares its clock with the local one.
+func checkDatabase() (HealthCheck, HealthCheck) {
+	dbCheck := HealthCheck{Name: "database", Status: HealthPass}
+	clockCheck := HealthCheck{Name: "clock", Status: HealthPass}
+	now := clock.Unix()
+	if now < minSaneTimestamp {
+		clockCheck.Status = HealthFail
+		clockCheck.Details = fmt.Sprintf("The local clock is in the past. Local time: %d", now)
+	}
+	dbNow, err := persistence.CheckConnection()
+	if err != nil {
+		dbCheck.Status = HealthFail
+		dbCheck.Details = fmt.Sprintf("The database can't be reached. Error: %s", err)
+		return dbCheck, clockCheck
+	}
+	drift := time.Duration(now-dbNow) * time.Second
+	if drift < 0 {
+		drift = -drift
+	}
+	if drift > maxClockDrift && clockCheck.Status == HealthPass {
+		clockCheck.Status = HealthWarn
+		clockCheck.Details = fmt.Sprintf("The local clock and the database clock are %s apart.", drift)
+	}
+	return dbCheck, clockCheck
+}
+
+// checkDisk makes sure that caches can be written, by writing and removing a small file where they are kept.
+func checkDisk() HealthCheck {
+	check := HealthCheck{Name: "disk", Status: HealthPass}
+	err := os.MkdirAll(globals.CachesLocation, 0755)
+	if err != nil {
+		check.Status = HealthFail
+		check.Details = fmt.Sprintf("The caches directory can't be created. Error: %s", err)
+		return check
+	}
+	probe := fmt.Sprint(globals.CachesLocation, "/.healthcheck")
+	err2 := ioutil.WriteFile(probe, []byte("ok"), 0644)
+	if err2 != nil {
+		check.Status = HealthFail
+		check.Details = fmt.Sprintf("The caches directory is not writable. Error: %s", err2)
+		return check
+	}
+	os.Remove(probe)
+	return check
+}
+
+// checkCacheWrites warns if the cache writes are paused, since a cache write found the disk full. The node still serves the caches it has, but makes no new ones.
+func checkCacheWrites() HealthCheck {
+	check := HealthCheck{Name: "cache writes", Status: HealthPass}
+	status := responsegenerator.DiskFull()
+	if status.Paused {
+		check.Status = HealthWarn
+		check.Details = fmt.Sprintf("The cache writes are paused, since the disk was full. They resume when there are %d MB free. Since: %d, Path: %s, Error: %s", globals.CacheResumeFreeDiskBytes/(1024*1024), status.Since, status.Path, status.Error)
+	}
+	return check
+}
+
+// checkListener makes sure the server accepts connections on every address it is bound to, by connecting to them. Some listeners being down is a warning, all of them being down is a failure.
+func checkListener() HealthCheck {
+	check := HealthCheck{Name: "listener", Status: HealthPass}
+	addrs := BoundAddresses()
+	if len(addrs) == 0 {
+		check.Status = HealthFail
+		check.Details = "The server is not listening on any address."
+		return check
+	}
+	down := 0
+	for _, addr := range addrs {
+		conn, err := net.DialTimeout("tcp", addr, globals.TCPConnectTimeout)
+		if err != nil {
+			down++
+			check.Details = fmt.Sprint(check.Details, fmt.Sprintf("The server does not accept connections. Address: %s, Error: %s. ", addr, err))
+			continue
+		}
+		conn.Close()
+	}
+	if down == len(addrs) {
+		check.Status = HealthFail
+	} else if down > 0 {
+		check.Status = HealthWarn
+	}
+	return check
+}
+
+// worse returns the worse of two statuses.
+func worse(a string, b string) string {
+	if a == HealthFail || b == HealthFail {
+		return HealthFail
+	}
+	if a == HealthWarn || b == HealthWarn {
+		return HealthWarn
+	}
+	return HealthPass
+}
+
+// CheckHealth runs all probes.
+func CheckHealth() HealthReport {
+	var report HealthReport
+	report.Timestamp = clock.Unix()
+	dbCheck, clockCheck := checkDatabase()
+	report.Checks = []HealthCheck{dbCheck, checkDisk(), checkCacheWrites(), clockCheck, checkListener()}
+	report.Status = HealthPass
+	for _, c := range report.Checks {
+		report.Status = worse(report.Status, c.Status)
+	}
+	return report
+}
+
+// HealthHandler responds to GET with the health report. The details of the checks are only given to the local machine. The status code is 200 if the node can serve, even with warnings, and 503 if it can't, so that a load balancer can act on the status code alone.
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	report := CheckHealth()
+	if !isLoopback(r) {
+		// The details can include paths and error messages from the database, which are only for the operator.
+		for i, _ := range report.Checks {
+			report.Checks[i].Details = ""
+		}
+	}
+	jsonResp, err := json.Marshal(report)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if report.Status == HealthFail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(jsonResp)
+}
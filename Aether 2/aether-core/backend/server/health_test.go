@@ -0,0 +1,118 @@
+// This test is in the package itself rather than in server_test, since the probes of the health check are not exported. The database probe needs a database, so it is not tested here.
+
+package server
+
+import (
+	"aether-core/services/globals"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setBoundAddresses(addrs []string) []string {
+	boundAddressesLock.Lock()
+	defer boundAddressesLock.Unlock()
+	old := boundAddresses
+	boundAddresses = addrs
+	return old
+}
+
+func TestWorse_Success(t *testing.T) {
+	cases := [][3]string{
+		{HealthPass, HealthPass, HealthPass},
+		{HealthPass, HealthWarn, HealthWarn},
+		{HealthWarn, HealthPass, HealthWarn},
+		{HealthWarn, HealthFail, HealthFail},
+		{HealthFail, HealthPass, HealthFail},
+	}
+	for _, c := range cases {
+		if s := worse(c[0], c[1]); s != c[2] {
+			t.Errorf("The worse of %s and %s should be %s. Status: %s", c[0], c[1], c[2], s)
+		}
+	}
+}
+
+func TestCheckDisk_Success(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aether-health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := globals.CachesLocation
+	defer func() { globals.CachesLocation = old }()
+	globals.CachesLocation = filepath.Join(dir, "caches")
+	if c := checkDisk(); c.Status != HealthPass {
+		t.Errorf("A writable caches directory should pass. Check: %#v", c)
+	}
+	if _, err2 := os.Stat(filepath.Join(globals.CachesLocation, ".healthcheck")); !os.IsNotExist(err2) {
+		t.Errorf("The probe file should be removed after the check.")
+	}
+}
+
+func TestCheckDisk_Fail_NotADirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aether-health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	ioutil.WriteFile(file, []byte("x"), 0644)
+	old := globals.CachesLocation
+	defer func() { globals.CachesLocation = old }()
+	globals.CachesLocation = filepath.Join(file, "caches")
+	if c := checkDisk(); c.Status != HealthFail || len(c.Details) == 0 {
+		t.Errorf("A caches directory that can't be created should fail, with the reason. Check: %#v", c)
+	}
+}
+
+func TestCheckListener_Success(t *testing.T) {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nl.Close()
+	old := setBoundAddresses([]string{nl.Addr().String()})
+	defer setBoundAddresses(old)
+	if c := checkListener(); c.Status != HealthPass {
+		t.Errorf("A listener that accepts connections should pass. Check: %#v", c)
+	}
+}
+
+func TestCheckListener_Fail_SomeDown(t *testing.T) {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nl.Close()
+	closed, err2 := net.Listen("tcp", "127.0.0.1:0")
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+	old := setBoundAddresses([]string{nl.Addr().String(), closedAddr})
+	defer setBoundAddresses(old)
+	if c := checkListener(); c.Status != HealthWarn {
+		t.Errorf("Some listeners being down should be a warning. Check: %#v", c)
+	}
+	setBoundAddresses([]string{closedAddr})
+	if c := checkListener(); c.Status != HealthFail {
+		t.Errorf("All listeners being down should be a failure. Check: %#v", c)
+	}
+	setBoundAddresses(nil)
+	if c := checkListener(); c.Status != HealthFail {
+		t.Errorf("Not listening at all should be a failure. Check: %#v", c)
+	}
+}
+
+func TestHealthHandler_Fail_NotGet(t *testing.T) {
+	w := httptest.NewRecorder()
+	HealthHandler(w, httptest.NewRequest("POST", "/health", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("The health check should only respond to GET. Status code: %d", w.Code)
+	}
+}
@@ -0,0 +1,99 @@
+// Backend > Server > Jobs
+// This file lets the operator see the jobs of the job queue, and cancel or retry them.
+
+package server
+
+import (
+	"aether-core/services/jobs"
+	"aether-core/services/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// respondToJobCommand writes the outcome of a job command, in the same way as the peer rule commands.
+func respondToJobCommand(w http.ResponseWriter, result interface{}, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("Job command failed. Error: %s", err)))
+		w.WriteHeader(http.StatusBadRequest)
+		jsonResp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		w.Write(jsonResp)
+		return
+	}
+	jsonResp, err2 := json.Marshal(result)
+	if err2 != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResp)
+}
+
+// readJobId reads the id of the job from the body of the request.
+func readJobId(r *http.Request) (string, error) {
+	var cmd struct {
+		Id string `json:"id"`
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	err2 := json.Unmarshal(body, &cmd)
+	if err2 != nil {
+		return "", errors.New(fmt.Sprintf("The job command could not be parsed. Error: %s", err2))
+	}
+	if len(cmd.Id) == 0 {
+		return "", errors.New("The job command has no job id.")
+	}
+	return cmd.Id, nil
+}
+
+// JobsHandler responds to GET with the jobs of the job queue, oldest first: the ones queued and running, and the last ones that ended. The "state" query parameter gives only the jobs in that state, and "kind" only the ones of that kind.
+func JobsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	state := r.URL.Query().Get("state")
+	kind := r.URL.Query().Get("kind")
+	result := []jobs.Job{}
+	for _, j := range jobs.List() {
+		if (len(state) > 0 && j.State != state) || (len(kind) > 0 && j.Kind != kind) {
+			continue
+		}
+		result = append(result, j)
+	}
+	respondToJobCommand(w, result, nil)
+}
+
+// JobsCancelHandler cancels a job. A queued job is cancelled right away, and a running one when it stops. Body: {"id"}
+func JobsCancelHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || r.Method != "POST" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	id, err := readJobId(r)
+	if err != nil {
+		respondToJobCommand(w, nil, err)
+		return
+	}
+	j, err2 := jobs.Cancel(id)
+	respondToJobCommand(w, j, err2)
+}
+
+// JobsRetryHandler queues a job that failed or was cancelled again. Body: {"id"}
+func JobsRetryHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) || r.Method != "POST" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	id, err := readJobId(r)
+	if err != nil {
+		respondToJobCommand(w, nil, err)
+		return
+	}
+	j, err2 := jobs.Retry(id)
+	respondToJobCommand(w, j, err2)
+}
@@ -0,0 +1,129 @@
+// Backend > Server > Listeners
+// This file binds the listeners of the server. Every listener takes the first free port in its range, and the one that is advertised updates the address given to the remotes and the port mapped on the router. The listeners are bound at start, before anything that reads the port of the address runs, and they are served on later.
+
+package server
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"aether-core/services/peerrules"
+	"aether-core/services/upnp"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// boundAddresses are the addresses the server is actually listening on, after the port ranges are resolved.
+var boundAddresses []string
+var boundAddressesLock sync.Mutex
+
+// boundListener is a listener bound by Bind, waiting to be served on.
+type boundListener struct {
+	name string
+	nl   net.Listener
+}
+
+// boundListeners are guarded by boundAddressesLock, as the addresses are.
+var boundListeners []boundListener
+
+// BoundAddresses returns the addresses the server is listening on.
+func BoundAddresses() []string {
+	boundAddressesLock.Lock()
+	defer boundAddressesLock.Unlock()
+	return append([]string{}, boundAddresses...)
+}
+
+// bind listens on the first free port in the range of the listener. A range starting at 0 takes any free port the system gives, and the port given is the one it got.
+func bind(l globals.Listener) (net.Listener, uint16, error) {
+	end := l.PortEnd
+	if end < l.PortStart {
+		end = l.PortStart
+	}
+	var lastErr error
+	for port := int(l.PortStart); port <= int(end); port++ {
+		nl, err := net.Listen("tcp", net.JoinHostPort(l.Interface, fmt.Sprint(port)))
+		if err == nil {
+			return nl, uint16(nl.Addr().(*net.TCPAddr).Port), nil
+		}
+		// The port is probably taken. Try the next one.
+		logging.Log(2, fmt.Sprintf("Listener %s could not bind to port %d. Error: %s", l.Name, port, err))
+		lastErr = err
+	}
+	return nil, 0, errors.New(fmt.Sprintf("Listener %s could not bind to any port in its range. Interface: %s, Ports: %d-%d, Last error: %s", l.Name, l.Interface, l.PortStart, end, lastErr))
+}
+
+// Bind binds every listener, and sets the port of the address to the one the advertised listener got. It is called at start, before the goroutines that read the port are started. A listener that can't bind is skipped, but if none can, it returns an error.
+func Bind() error {
+	boundAddressesLock.Lock()
+	defer boundAddressesLock.Unlock()
+	if len(boundListeners) > 0 {
+		return nil
+	}
+	for _, l := range globals.Listeners {
+		nl, port, err := bind(l)
+		if err != nil {
+			logging.Log(1, err)
+			continue
+		}
+		addr := nl.Addr().String()
+		boundAddresses = append(boundAddresses, addr)
+		boundListeners = append(boundListeners, boundListener{l.Name, nl})
+		logging.Log(1, fmt.Sprintf("Listener %s is listening on %s.", l.Name, addr))
+		if l.Advertise && port != globals.AddressPort {
+			// The remotes need to know the port we actually got, and the router needs to forward it.
+			globals.AddressPort = port
+			go upnp.MapPort()
+		}
+	}
+	if len(boundListeners) == 0 {
+		return errors.New("None of the listeners could bind. The server can't serve.")
+	}
+	return nil
+}
+
+// listenAll serves on every listener bound, binding them first if they are not yet. It returns when all of them stop.
+func listenAll(handler http.Handler) error {
+	err := Bind()
+	if err != nil {
+		return err
+	}
+	boundAddressesLock.Lock()
+	listeners := append([]boundListener{}, boundListeners...)
+	boundAddressesLock.Unlock()
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(name string, nl net.Listener) {
+			defer wg.Done()
+			err := http.Serve(nl, refuseBlocked(ShedLoad(LimitFingerprintQueries(handler))))
+			logging.Log(1, fmt.Sprintf("Listener %s stopped. Error: %s", name, err))
+		}(l.name, l.nl)
+	}
+	wg.Wait()
+	return nil
+}
+
+// remoteHost returns the IP address of the remote that made the request.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// refuseBlocked closes the door on the remotes blocked by the peer rules, before anything else is done with their requests. The local machine is never blocked, so that the operator can't lock themselves out of the admin endpoints.
+func refuseBlocked(handler http.Handler) http.Handler {
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLoopback(r) && !peerrules.Allowed(remoteHost(r), "") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,50 @@
+// This test is in the package itself rather than in server_test, since bind is not exported.
+
+package server
+
+import (
+	"aether-core/services/globals"
+	"net"
+	"testing"
+)
+
+func TestBind_Success_AnyPort(t *testing.T) {
+	nl, port, err := bind(globals.Listener{Name: "test", Interface: "127.0.0.1", PortStart: 0, PortEnd: 0})
+	if err != nil {
+		t.Fatalf("The listener could not bind. Error: %s", err)
+	}
+	defer nl.Close()
+	if port == 0 || int(port) != nl.Addr().(*net.TCPAddr).Port {
+		t.Errorf("The port given should be the one the listener got. Port: %d, Address: %s", port, nl.Addr())
+	}
+}
+
+func TestBind_Success_NextFreePort(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("A port could not be taken for the test. Error: %s", err)
+	}
+	defer taken.Close()
+	start := uint16(taken.Addr().(*net.TCPAddr).Port)
+	nl, port, err2 := bind(globals.Listener{Name: "test", Interface: "127.0.0.1", PortStart: start, PortEnd: start + 20})
+	if err2 != nil {
+		t.Fatalf("The listener could not bind. Error: %s", err2)
+	}
+	defer nl.Close()
+	if port <= start || port > start+20 {
+		t.Errorf("The listener should have taken a later port in its range. Taken: %d, Port: %d", start, port)
+	}
+}
+
+func TestBind_Fail_RangeTaken(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("A port could not be taken for the test. Error: %s", err)
+	}
+	defer taken.Close()
+	port := uint16(taken.Addr().(*net.TCPAddr).Port)
+	if nl, _, err2 := bind(globals.Listener{Name: "test", Interface: "127.0.0.1", PortStart: port, PortEnd: port}); err2 == nil {
+		nl.Close()
+		t.Errorf("A listener whose only port is taken should not have bound.")
+	}
+}
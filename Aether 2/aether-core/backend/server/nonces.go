@@ -0,0 +1,52 @@
+// Backend > Server > Nonces
+// This file refuses the POST requests that are replayed. A request with a nonce has to have a fresh timestamp, and its nonce can be used only once within the binding window; the nonces are forgotten once the requests carrying them would be out of the window anyway.
+
+package server
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var noncesLock sync.Mutex
+var seenNonces = make(map[string]time.Time) // Nonce > when it is forgotten
+
+// admitNonce checks the nonce and the timestamp of a request, and remembers the nonce. The requests without a nonce come from remotes that don't bind the responses yet; they are let through, and their responses are not bound.
+func admitNonce(req api.ApiResponse) error {
+	if len(req.Nonce) == 0 {
+		return nil
+	}
+	if !api.ValidNonce(req.Nonce) {
+		return errors.New(fmt.Sprintf("The nonce of the request is not valid. Node: %s", req.NodeId))
+	}
+	now := clock.Now()
+	if !api.InBindingWindow(req.Timestamp, now) {
+		return errors.New(fmt.Sprintf("The timestamp of the request is out of the binding window. Node: %s, Timestamp: %d, Window: %s", req.NodeId, req.Timestamp, globals.ResponseBindingWindow))
+	}
+	noncesLock.Lock()
+	defer noncesLock.Unlock()
+	// The nonces that are forgotten but not yet swept are not counted.
+	if expiry, seen := seenNonces[req.Nonce]; seen && !now.After(expiry) {
+		return errors.New(fmt.Sprintf("The nonce of the request was already used. The request might have been replayed. Node: %s, Nonce: %s", req.NodeId, req.Nonce))
+	}
+	// The timestamp can be up to a window in the future, so the nonce is kept for two windows.
+	seenNonces[req.Nonce] = now.Add(2 * globals.ResponseBindingWindow)
+	return nil
+}
+
+// SweepNonces forgets the nonces whose requests would be out of the binding window by now. It runs on a timer rather than with every request, so that a flood of requests doesn't scan the whole map each time.
+func SweepNonces() {
+	now := clock.Now()
+	noncesLock.Lock()
+	defer noncesLock.Unlock()
+	for nonce, expiry := range seenNonces {
+		if now.After(expiry) {
+			delete(seenNonces, nonce)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// This test is in the package itself rather than in server_test, since the nonces are checked and kept by functions and a map that are not exported.
+
+package server
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"testing"
+	"time"
+)
+
+func nonceRequest(nonce string) api.ApiResponse {
+	var req api.ApiResponse
+	req.NodeId = "remote"
+	req.Nonce = nonce
+	req.Timestamp = api.Timestamp(clock.Unix())
+	return req
+}
+
+func TestAdmitNonce_Fail_Replayed(t *testing.T) {
+	req := nonceRequest(api.NewNonce())
+	if err := admitNonce(req); err != nil {
+		t.Fatalf("The first request with the nonce should be admitted. Error: %s", err)
+	}
+	if err := admitNonce(req); err == nil {
+		t.Errorf("The replayed request should have been refused.")
+	}
+}
+
+func TestAdmitNonce_Success_ForgottenNotSwept(t *testing.T) {
+	req := nonceRequest(api.NewNonce())
+	noncesLock.Lock()
+	seenNonces[req.Nonce] = clock.Now().Add(-time.Second)
+	noncesLock.Unlock()
+	if err := admitNonce(req); err != nil {
+		t.Errorf("A nonce that is forgotten should be admitted again, even before it is swept. Error: %s", err)
+	}
+}
+
+func TestSweepNonces_Success(t *testing.T) {
+	expired, live := api.NewNonce(), api.NewNonce()
+	noncesLock.Lock()
+	seenNonces[expired] = clock.Now().Add(-time.Second)
+	seenNonces[live] = clock.Now().Add(time.Minute)
+	noncesLock.Unlock()
+	SweepNonces()
+	noncesLock.Lock()
+	defer noncesLock.Unlock()
+	if _, ok := seenNonces[expired]; ok {
+		t.Errorf("The expired nonce should have been swept.")
+	}
+	if _, ok := seenNonces[live]; !ok {
+		t.Errorf("The nonce that is still in the window should have been kept.")
+	}
+}
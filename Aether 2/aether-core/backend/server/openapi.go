@@ -0,0 +1,282 @@
+// Backend > Server > OpenAPI
+// This file describes the endpoints of the node as an OpenAPI document, so that the clients in other languages can be generated from it instead of written from the README. The peer protocol is described from the registry of the response generator, the local endpoints from the routes they are registered with, and the public API from its routes. The schemas are made from the Go types the endpoints read and write, by the same JSON names, so the description changes with the code.
+// The remotes get the peer protocol only. The local machine gets the local endpoints and the public API too.
+
+package server
+
+import (
+	"aether-core/backend/publicapi"
+	"aether-core/backend/responsegenerator"
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const openapiVersion = "3.0.3"
+
+// schemaBuilder makes the schemas of Go types. The named structs go into the components, under the names of their packages and types, and are referred to from where they are used.
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+var timeType = reflect.TypeOf(time.Time{})
+
+func (b *schemaBuilder) schemaOf(value interface{}) map[string]interface{} {
+	if value == nil {
+		return nil
+	}
+	return b.schema(reflect.TypeOf(value))
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == rawMessageType {
+		return map[string]interface{}{}
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Byte slices are written as base64.
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return b.object(t)
+		}
+		name := fmt.Sprint(path.Base(t.PkgPath()), ".", t.Name())
+		if _, exists := b.components[name]; !exists {
+			// The name is taken before the fields are looked at, so that a type that contains itself refers to itself.
+			b.components[name] = map[string]interface{}{}
+			b.components[name] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": fmt.Sprint("#/components/schemas/", name)}
+	}
+	// Interfaces, and anything else, can be anything.
+	return map[string]interface{}{}
+}
+
+// object makes the schema of a struct. The fields of the embedded structs without a JSON name are its own, as the JSON encoder writes them. The fields that are always written are required.
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	b.addFields(t, properties, &required)
+	s := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func (b *schemaBuilder) addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+		if f.Anonymous && len(name) == 0 && f.Type.Kind() == reflect.Struct {
+			b.addFields(f.Type, properties, required)
+			continue
+		}
+		if len(f.PkgPath) > 0 {
+			// Not exported, not written.
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		properties[name] = b.schema(f.Type)
+		omitted := false
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				omitted = true
+			}
+		}
+		if !omitted {
+			*required = append(*required, name)
+		}
+	}
+}
+
+var operationIdChars = regexp.MustCompile("[^a-zA-Z0-9]+")
+
+// operation describes a method of a path. The body and the response are schemas, and either can be nil.
+func operation(method string, p string, tag string, summary string, params []map[string]interface{}, body map[string]interface{}, response map[string]interface{}) map[string]interface{} {
+	op := map[string]interface{}{
+		"operationId": strings.Trim(operationIdChars.ReplaceAllString(fmt.Sprint(method, "_", p), "_"), "_"),
+		"tags":        []string{tag},
+		"summary":     summary,
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if body != nil {
+		op["requestBody"] = map[string]interface{}{"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": body}}}
+	}
+	ok := map[string]interface{}{"description": "OK"}
+	if response != nil {
+		ok["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": response}}
+	}
+	op["responses"] = map[string]interface{}{"200": ok}
+	return op
+}
+
+func parameter(name string, in string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "in": in, "required": in == "path", "schema": map[string]interface{}{"type": "string"}}
+}
+
+// pathParameters gives the parameters in the braces of the path.
+func pathParameters(p string) []map[string]interface{} {
+	var params []map[string]interface{}
+	for _, part := range strings.Split(p, "/") {
+		if strings.HasPrefix(part, "{") {
+			params = append(params, parameter(strings.Trim(strings.TrimSuffix(part, ".json"), "{}"), "path"))
+		}
+	}
+	return params
+}
+
+// addPeerPaths describes the peer protocol: the status, the POST requests and the caches of the endpoints in the registry, and the pages of the POST responses.
+func addPeerPaths(b *schemaBuilder, paths map[string]interface{}) {
+	apiResp := b.schemaOf(api.ApiResponse{})
+	manifest := b.schemaOf(api.CacheManifest{})
+	get := func(p string, summary string, response map[string]interface{}) {
+		paths[p] = map[string]interface{}{"get": operation("get", p, "peer", summary, pathParameters(p), nil, response)}
+	}
+	paths["/v0/status"] = map[string]interface{}{"get": operation("get", "/v0/status", "peer", "Whether the node is up: 200 if it is, 429 if it is overloaded.", nil, nil, nil)}
+	for _, e := range responsegenerator.RegisteredEndpoints() {
+		p := fmt.Sprint("/v0/", e.Name)
+		summary := e.Summary
+		if e.Provable {
+			summary = fmt.Sprint(summary, " The request can submit new ones too.")
+		}
+		item := map[string]interface{}{"post": operation("post", p, "peer", summary, nil, apiResp, apiResp)}
+		if e.Name == "node" {
+			item["get"] = operation("get", p, "peer", e.Summary, nil, nil, apiResp)
+		}
+		paths[p] = item
+		if !e.Cached() {
+			continue
+		}
+		get(fmt.Sprint(p, "/index.json"), fmt.Sprintf("The index of the caches of the %s: their links, time ranges, manifests and witness signatures.", e.Name), apiResp)
+		get(fmt.Sprint(p, "/{cache}/", api.ManifestFile), fmt.Sprintf("The manifest of a cache of the %s: the hashes of its pages.", e.Name), manifest)
+		rangePath := fmt.Sprint(p, "/{cache}")
+		paths[rangePath] = map[string]interface{}{"get": operation("get", rangePath, "peer", fmt.Sprintf("A range of the pages of a cache of the %s, such as 12-20, one after another, to be split by the sizes in the manifest of the cache.", e.Name), append(pathParameters(rangePath), parameter("range", "query")), nil, nil)}
+		get(fmt.Sprint(p, "/{cache}/{page}.json"), fmt.Sprintf("A page of a cache of the %s.", e.Name), apiResp)
+		if e.Indexed() {
+			get(fmt.Sprint(p, "/{cache}/index/{page}.json"), fmt.Sprintf("A page of the index of a cache of the %s.", e.Name), apiResp)
+		}
+	}
+	get("/responses/{response}/{page}.json", "A page of a POST response whose results didn't fit into it, as the response links to it.", apiResp)
+	paths["/v0/openapi.json"] = map[string]interface{}{"get": operation("get", "/v0/openapi.json", "peer", "This description. The local machine gets the local endpoints and the public API too.", nil, nil, map[string]interface{}{"type": "object"})}
+}
+
+// addLocalPaths describes the local endpoints. A path that ends with a slash takes its first parameter after the slash.
+func addLocalPaths(b *schemaBuilder, paths map[string]interface{}) {
+	for _, route := range localRoutes {
+		p := route.Path
+		var params []map[string]interface{}
+		for i, name := range route.Params {
+			if i == 0 && strings.HasSuffix(p, "/") {
+				p = fmt.Sprint(p, "{", name, "}")
+				params = append(params, parameter(name, "path"))
+				continue
+			}
+			params = append(params, parameter(name, "query"))
+		}
+		tag := strings.Split(strings.Trim(route.Path, "/"), "/")[0]
+		hasGet := false
+		for _, m := range route.Methods {
+			hasGet = hasGet || m == "GET"
+		}
+		item := make(map[string]interface{})
+		for _, m := range route.Methods {
+			var body, response map[string]interface{}
+			if m == "POST" {
+				body = b.schemaOf(route.Body)
+			}
+			if m == "GET" || !hasGet {
+				response = b.schemaOf(route.Response)
+			}
+			item[strings.ToLower(m)] = operation(strings.ToLower(m), p, tag, route.Summary, params, body, response)
+		}
+		paths[p] = item
+	}
+}
+
+// addPublicApiPaths describes the public API, which listens on a port of its own.
+func addPublicApiPaths(b *schemaBuilder, paths map[string]interface{}) {
+	servers := []map[string]interface{}{{"url": fmt.Sprint("http://localhost:", globals.PublicApiPort)}}
+	for _, route := range publicapi.Routes {
+		params := pathParameters(route.Path)
+		for _, name := range route.Params {
+			params = append(params, parameter(name, "query"))
+		}
+		response := b.schemaOf(route.Entity)
+		if route.Item != nil {
+			response = map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"data":        map[string]interface{}{"type": "array", "items": b.schemaOf(route.Item)},
+					"next_cursor": map[string]interface{}{"type": "string"},
+				},
+				"required": []string{"data", "next_cursor"},
+			}
+		}
+		paths[route.Path] = map[string]interface{}{
+			"servers": servers,
+			"get":     operation("get", route.Path, "public", route.Summary, params, nil, response),
+		}
+	}
+}
+
+// OpenAPI gives the OpenAPI document of the node. The local endpoints and the public API are left out unless local is true.
+func OpenAPI(local bool) map[string]interface{} {
+	b := schemaBuilder{components: make(map[string]interface{})}
+	paths := make(map[string]interface{})
+	addPeerPaths(&b, paths)
+	if local {
+		addLocalPaths(&b, paths)
+		addPublicApiPaths(&b, paths)
+	}
+	return map[string]interface{}{
+		"openapi": openapiVersion,
+		"info": map[string]interface{}{
+			"title":       "Aether",
+			"version":     fmt.Sprintf("%d.%d", globals.ProtocolVersionMajor, globals.ProtocolVersionMinor),
+			"description": "The peer protocol of the Aether nodes, and the local endpoints of a node. The version is the version of the protocol.",
+		},
+		"servers":    []map[string]interface{}{{"url": fmt.Sprint("http://localhost:", globals.AddressPort)}},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": b.components},
+	}
+}
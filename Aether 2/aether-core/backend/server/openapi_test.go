@@ -0,0 +1,83 @@
+package server_test
+
+import (
+	"aether-core/backend/server"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// refs gives the components the document refers to.
+func refs(v interface{}, found map[string]bool) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, val := range x {
+			if s, ok := val.(string); ok && k == "$ref" {
+				found[strings.TrimPrefix(s, "#/components/schemas/")] = true
+				continue
+			}
+			refs(val, found)
+		}
+	case []interface{}:
+		for _, val := range x {
+			refs(val, found)
+		}
+	}
+}
+
+// openapiDoc gives the document as a client would read it.
+func openapiDoc(t *testing.T, local bool) map[string]interface{} {
+	data, err := json.Marshal(server.OpenAPI(local))
+	if err != nil {
+		t.Fatalf("The API description could not be converted to JSON. Error: %s", err)
+	}
+	var doc map[string]interface{}
+	json.Unmarshal(data, &doc)
+	return doc
+}
+
+// Tests
+
+func TestOpenAPI_Success(t *testing.T) {
+	doc := openapiDoc(t, true)
+	paths := doc["paths"].(map[string]interface{})
+	for _, p := range []string{"/v0/posts", "/v0/posts/index.json", "/v0/node", "/v0/witness", "/admin/encoders", "/frontend/setup/{step}", "/api/v1/boards/{fingerprint}"} {
+		if _, ok := paths[p]; !ok {
+			t.Errorf("The path should have been described. Path: %s", p)
+		}
+	}
+	if _, ok := paths["/v0/witness/index.json"]; ok {
+		t.Errorf("An endpoint without caches should not have a cache index described.")
+	}
+	if _, ok := paths["/v0/posts"].(map[string]interface{})["post"]; !ok {
+		t.Errorf("The POST of an endpoint of the registry should have been described.")
+	}
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	apiResp, ok := schemas["api.ApiResponse"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("The schema of the ApiResponse should have been in the components.")
+	}
+	if _, ok := apiResp["properties"].(map[string]interface{})["node_id"]; !ok {
+		t.Errorf("The properties should have the JSON names of the fields. Properties: %v", apiResp["properties"])
+	}
+	post := schemas["api.Post"].(map[string]interface{})["properties"].(map[string]interface{})
+	if _, ok := post["fingerprint"]; !ok {
+		t.Errorf("The fields of the embedded structs should have been the fields of the struct. Properties: %v", post)
+	}
+	found := make(map[string]bool)
+	refs(doc, found)
+	for name, _ := range found {
+		if _, ok := schemas[name]; !ok {
+			t.Errorf("The document refers to a schema it doesn't have. Schema: %s", name)
+		}
+	}
+}
+
+func TestOpenAPI_Fail_LocalToRemote(t *testing.T) {
+	doc := openapiDoc(t, false)
+	for p, _ := range doc["paths"].(map[string]interface{}) {
+		if !strings.HasPrefix(p, "/v0/") && !strings.HasPrefix(p, "/responses/") {
+			t.Errorf("The remotes should only get the peer protocol described. Path: %s", p)
+		}
+	}
+}
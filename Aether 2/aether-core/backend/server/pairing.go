@@ -0,0 +1,47 @@
+// Backend > Server > Pairing
+// This file lets the frontend pair the device with another one the user posts from: exporting the pairing bundle of the user, and importing the one exported on the other device.
+
+package server
+
+import (
+	"aether-core/backend/pairing"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// pairingRequest is the body of the pairing commands.
+type pairingRequest struct {
+	Passphrase string         `json:"passphrase"`
+	Bundle     pairing.Bundle `json:"bundle"` // import
+}
+
+// PairingHandler exports the pairing bundle of the user on POST to /frontend/pairing/export, and imports one on POST to /frontend/pairing/import. Body: {"passphrase"}, and {"bundle"} for import.
+func PairingHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) || r.Method != "POST" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var req pairingRequest
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		respondToFrontendCommand(w, nil, errors.New(fmt.Sprintf("The pairing request could not be parsed. Error: %s", err)))
+		return
+	}
+	switch r.URL.Path {
+	case "/frontend/pairing/export":
+		b, err2 := pairing.Export(req.Passphrase)
+		respondToFrontendCommand(w, b, err2)
+	case "/frontend/pairing/import":
+		report, err3 := pairing.Import(req.Bundle, req.Passphrase)
+		respondToFrontendCommand(w, report, err3)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,78 @@
+// Backend > Server > Read Markers
+// This file provides the read markers of the local user to the frontend: how many posts are unread in each board and thread, and the first unread post of a thread, and marking the threads read and unread.
+
+package server
+
+import (
+	"aether-core/backend/readmarkers"
+	"aether-core/io/api"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// readMarkerCommand is the body of the commands on the read markers.
+type readMarkerCommand struct {
+	Thread api.Fingerprint `json:"thread"`
+	Post   api.Fingerprint `json:"post"` // read. Empty marks all of the thread read.
+}
+
+// ReadMarkersHandler responds to GET at /frontend/read with how much of the thread in the "thread" query parameter the user read, or with the unread posts of each thread of the "board", or of each of the comma separated "boards". POST to /frontend/read marks a thread read up to a post, and POST to /frontend/read/unread marks it unread. Body: {"thread", "post"}
+func ReadMarkersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/frontend/read":
+		q := r.URL.Query()
+		switch {
+		case len(q.Get("thread")) > 0:
+			s, err := readmarkers.Get(api.Fingerprint(q.Get("thread")))
+			respondToFrontendCommand(w, s, err)
+		case len(q.Get("board")) > 0:
+			ts, err := readmarkers.Threads(api.Fingerprint(q.Get("board")))
+			respondToFrontendCommand(w, ts, err)
+		case len(q.Get("boards")) > 0:
+			var boards []api.Fingerprint
+			for _, b := range strings.Split(q.Get("boards"), ",") {
+				if b = strings.TrimSpace(b); len(b) > 0 {
+					boards = append(boards, api.Fingerprint(b))
+				}
+			}
+			bs, err := readmarkers.Boards(boards)
+			respondToFrontendCommand(w, bs, err)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	case r.Method == "POST":
+		var cmd readMarkerCommand
+		body, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(body, &cmd)
+		}
+		if err == nil && len(cmd.Thread) == 0 {
+			err = errors.New("The read marker command needs the thread.")
+		}
+		if err != nil {
+			respondToFrontendCommand(w, nil, errors.New(fmt.Sprintf("The read marker command could not be parsed. Error: %s", err)))
+			return
+		}
+		switch r.URL.Path {
+		case "/frontend/read":
+			m, err2 := readmarkers.MarkRead(cmd.Thread, cmd.Post)
+			respondToFrontendCommand(w, m, err2)
+		case "/frontend/read/unread":
+			err3 := readmarkers.MarkUnread(cmd.Thread)
+			respondToFrontendCommand(w, map[string]string{"status": "ok"}, err3)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,95 @@
+// Backend > Server > Routes
+// This file lists the local endpoints: the ones the frontend and the operator use, which only answer to the local machine. The server registers them from this list, and the API description is made from it, so an endpoint added here is described too.
+
+package server
+
+import (
+	"aether-core/backend/contentfilters"
+	"aether-core/backend/drafts"
+	"aether-core/backend/notifications"
+	"aether-core/backend/pairing"
+	"aether-core/backend/readmarkers"
+	"aether-core/backend/responsegenerator"
+	"aether-core/backend/storagereport"
+	"aether-core/backend/telemetry"
+	"aether-core/backend/watches"
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/composition"
+	"aether-core/services/configstore"
+	"aether-core/services/globals"
+	"aether-core/services/jobs"
+	"aether-core/services/peerclients"
+	"aether-core/services/syncprogress"
+	"net/http"
+)
+
+// LocalRoute is a local endpoint.
+type LocalRoute struct {
+	Path    string
+	Methods []string
+	Summary string
+	// Params are the query parameters, or for a path that ends with a slash, what comes after it.
+	Params []string
+	// Body and Response are values of the types of the body of a POST and of the response, for their schemas. The response is that of the GET, or of the POST if there is no GET. Either can be nil.
+	Body     interface{}
+	Response interface{}
+	Handler  http.HandlerFunc
+}
+
+type jobCommand struct {
+	Id string `json:"id"`
+}
+
+var localRoutes = []LocalRoute{
+	{Path: "/health", Methods: []string{"GET"}, Summary: "The health report of the node. The status code is 200 if it can serve, and 503 if it can't.", Response: HealthReport{}, Handler: HealthHandler},
+	{Path: "/frontend/notifications", Methods: []string{"GET"}, Summary: "The notifications of the local user.", Params: []string{"unseen"}, Response: []notifications.Notification{}, Handler: NotificationsHandler},
+	{Path: "/frontend/notifications/seen", Methods: []string{"POST"}, Summary: "Marks the notifications of the given posts as seen. An empty list marks all of them.", Body: []api.Fingerprint{}, Handler: NotificationsSeenHandler},
+	{Path: "/frontend/threads", Methods: []string{"GET"}, Summary: "A page of the threads of a board, ranked.", Params: []string{"board", "order", "limit", "cursor"}, Response: rankedThreadsPage{}, Handler: RankedThreadsHandler},
+	{Path: "/frontend/threads/tree", Methods: []string{"GET"}, Summary: "A page of the reply tree of a thread, depth first.", Params: []string{"thread", "root", "depth", "limit", "cursor"}, Response: replyTreePage{}, Handler: ReplyTreeHandler},
+	{Path: "/frontend/filters", Methods: []string{"GET", "POST"}, Summary: "The content filters of the user. POST adds one.", Body: contentfilters.Filter{}, Response: []contentfilters.Filter{}, Handler: ContentFiltersHandler},
+	{Path: "/frontend/filters/remove", Methods: []string{"POST"}, Summary: "Removes the content filter with the given type and value.", Body: contentfilters.Filter{}, Handler: ContentFiltersRemoveHandler},
+	{Path: "/frontend/drafts", Methods: []string{"GET", "POST"}, Summary: "The drafts of the user, or the one with the given id. POST saves one; a draft without an id is new.", Params: []string{"id"}, Body: drafts.Draft{}, Response: []drafts.Draft{}, Handler: DraftsHandler},
+	{Path: "/frontend/drafts/delete", Methods: []string{"POST"}, Summary: "Deletes a draft. A scheduled draft is not published.", Body: draftCommand{}, Handler: DraftCommandHandler},
+	{Path: "/frontend/drafts/schedule", Methods: []string{"POST"}, Summary: "Schedules a draft to be published at the given time. 0 publishes it right away.", Body: draftCommand{}, Response: drafts.Draft{}, Handler: DraftCommandHandler},
+	{Path: "/frontend/drafts/unschedule", Methods: []string{"POST"}, Summary: "Makes a scheduled draft a draft again.", Body: draftCommand{}, Response: drafts.Draft{}, Handler: DraftCommandHandler},
+	{Path: "/frontend/read", Methods: []string{"GET", "POST"}, Summary: "How much of a thread the user read and its first unread post, or the unread posts of each thread of a board, or of each of the boards. POST marks a thread read up to a post, or all of it.", Params: []string{"thread", "board", "boards"}, Body: readMarkerCommand{}, Response: readmarkers.ThreadState{}, Handler: ReadMarkersHandler},
+	{Path: "/frontend/read/unread", Methods: []string{"POST"}, Summary: "Marks a thread unread.", Body: readMarkerCommand{}, Handler: ReadMarkersHandler},
+	{Path: "/frontend/watches", Methods: []string{"GET", "POST"}, Summary: "The threads and boards the user watches. POST watches one.", Body: watches.Watch{}, Response: []watches.Watch{}, Handler: WatchesHandler},
+	{Path: "/frontend/watches/remove", Methods: []string{"POST"}, Summary: "No longer watches the thread or the board.", Body: watches.Watch{}, Handler: WatchesRemoveHandler},
+	{Path: "/frontend/watches/feed", Methods: []string{"GET"}, Summary: "A page of the posts in the watched threads and the threads in the watched boards, newest first.", Params: []string{"limit", "cursor"}, Response: watchFeedPage{}, Handler: WatchFeedHandler},
+	{Path: "/frontend/pairing/export", Methods: []string{"POST"}, Summary: "The pairing bundle of the user, sealed with the passphrase, for another device of the user to import.", Body: pairingRequest{}, Response: pairing.Bundle{}, Handler: PairingHandler},
+	{Path: "/frontend/pairing/import", Methods: []string{"POST"}, Summary: "Imports the pairing bundle exported on another device of the user: its key and the history of the user.", Body: pairingRequest{}, Response: pairing.ImportReport{}, Handler: PairingHandler},
+	{Path: "/frontend/composition", Methods: []string{"GET"}, Summary: "The composition limits of the network, and how much of them the user used in the last minute.", Response: composition.Usage{}, Handler: CompositionHandler},
+	{Path: "/frontend/sync/progress", Methods: []string{"GET"}, Summary: "The progress of the running syncs, and of the ones that finished in the last hour.", Response: syncprogress.Progress{}, Handler: SyncProgressHandler},
+	{Path: "/frontend/setup", Methods: []string{"GET"}, Summary: "The state of the first-run setup.", Handler: SetupHandler},
+	{Path: "/frontend/setup/", Methods: []string{"POST"}, Summary: "Takes a step of the first-run setup: data_directory, identity, network, serving_mode, subscriptions, reachability or complete.", Params: []string{"step"}, Body: setupRequest{}, Handler: SetupHandler},
+	{Path: "/admin/caches/plan", Methods: []string{"GET"}, Summary: "The plan of the next cache generation run.", Params: []string{"format"}, Response: responsegenerator.GenerationPlan{}, Handler: CachePlanHandler},
+	{Path: "/admin/caches/regenerate", Methods: []string{"POST"}, Summary: "Regenerates the cache of an entity type for a time range.", Body: CacheCommand{}, Handler: CacheRegenerateHandler},
+	{Path: "/admin/caches/delete", Methods: []string{"POST"}, Summary: "Deletes a cache and removes it from the index.", Body: CacheCommand{}, Handler: CacheDeleteHandler},
+	{Path: "/admin/caches/repair", Methods: []string{"POST"}, Summary: "Makes the index of an entity type agree with the caches on disk.", Body: CacheCommand{}, Handler: CacheRepairHandler},
+	{Path: "/admin/caches/reindex", Methods: []string{"POST"}, Summary: "Deletes all caches and creates them again from the database.", Handler: CacheReindexHandler},
+	{Path: "/admin/caches/prune", Methods: []string{"POST"}, Summary: "Deletes the caches older than the cache retention.", Handler: CachePruneHandler},
+	{Path: "/admin/caches/archive", Methods: []string{"POST"}, Summary: "Moves the caches older than the archive threshold to the archives.", Response: responsegenerator.ArchiveReport{}, Handler: CacheArchiveHandler},
+	{Path: "/admin/caches/dedup", Methods: []string{"POST"}, Summary: "Makes the pages that are the same in different caches links to a single file.", Response: responsegenerator.DedupReport{}, Handler: DedupHandler},
+	{Path: "/admin/responses", Methods: []string{"GET"}, Summary: "The store of the multipart POST responses, its quota, and how much the responses in the database take.", Response: responsegenerator.ResponseStoreReport{}, Handler: ResponseStoreHandler},
+	{Path: "/admin/statics", Methods: []string{"GET", "POST"}, Summary: "The orphans in the statics directory. POST collects them.", Response: responsegenerator.StaticsReport{}, Handler: StaticsHandler},
+	{Path: "/admin/encoders", Methods: []string{"GET", "POST"}, Summary: "The encoders of the responses and the caches. POST picks the encoder of the responses of an endpoint.", Body: EncoderCommand{}, Handler: EncodersHandler},
+	{Path: "/admin/hooks", Methods: []string{"GET", "POST"}, Summary: "The response hooks and how they have been doing. POST enables or disables one.", Body: HookCommand{}, Response: []responsegenerator.HookStatus{}, Handler: HooksHandler},
+	{Path: "/admin/peers/rules", Methods: []string{"GET", "POST"}, Summary: "The peer rules in effect. POST adds one.", Body: globals.PeerRule{}, Handler: PeerRulesHandler},
+	{Path: "/admin/peers/rules/remove", Methods: []string{"POST"}, Summary: "Removes the rules added at runtime with the given node id and IP range.", Body: globals.PeerRule{}, Handler: PeerRulesRemoveHandler},
+	{Path: "/admin/peers/clients", Methods: []string{"GET"}, Summary: "The clients of the remotes that reached this node recently.", Response: peerclients.Report{}, Handler: PeerClientsHandler},
+	{Path: "/admin/jobs", Methods: []string{"GET"}, Summary: "The jobs of the job queue.", Params: []string{"state", "kind"}, Response: []jobs.Job{}, Handler: JobsHandler},
+	{Path: "/admin/jobs/cancel", Methods: []string{"POST"}, Summary: "Cancels a job.", Body: jobCommand{}, Handler: JobsCancelHandler},
+	{Path: "/admin/jobs/retry", Methods: []string{"POST"}, Summary: "Queues a job that failed or was cancelled again.", Body: jobCommand{}, Handler: JobsRetryHandler},
+	{Path: "/admin/config", Methods: []string{"GET", "POST"}, Summary: "The outcome of the last read of the config file. POST reads it again.", Response: configstore.Report{}, Handler: ConfigHandler},
+	{Path: "/admin/db/queries", Methods: []string{"GET", "POST"}, Summary: "The timings of the database queries. POST resets them.", Response: []persistence.QueryTiming{}, Handler: QueryTimingsHandler},
+	{Path: "/admin/db/replica", Methods: []string{"GET", "POST"}, Summary: "The state of the read replica of the database. POST checks it again.", Response: persistence.ReplicaStatus{}, Handler: ReplicaHandler},
+	{Path: "/admin/db/indexes", Methods: []string{"GET", "POST"}, Summary: "The indexes the filters need, and their states. POST checks them again.", Response: []persistence.IndexState{}, Handler: IndexesHandler},
+	{Path: "/admin/storage", Methods: []string{"GET"}, Summary: "How much the node stores per entity type, and how fast it grows.", Params: []string{"format"}, Response: storagereport.Report{}, Handler: StorageReportHandler},
+	{Path: "/admin/telemetry", Methods: []string{"GET"}, Summary: "The state of the telemetry, and the report as it would be sent now.", Response: telemetry.Status{}, Handler: TelemetryHandler},
+	{Path: "/admin/entities", Methods: []string{"GET", "POST"}, Summary: "When the given entities arrived. POST adds the entities in the body.", Params: []string{"type", "fingerprints"}, Body: api.Answer{}, Handler: EntitiesHandler},
+	{Path: "/admin/provenance", Methods: []string{"GET"}, Summary: "Where the entities came from the first time they arrived, or how many each node was the first to deliver.", Params: []string{"fingerprints", "node", "since", "limit"}, Response: []persistence.Provenance{}, Handler: ProvenanceHandler},
+	{Path: "/admin/debug/pprof/", Methods: []string{"GET"}, Summary: "A runtime profile, or the list of the profiles.", Params: []string{"name", "seconds", "debug"}, Handler: ProfileHandler},
+	{Path: "/admin/debug/snapshot", Methods: []string{"POST"}, Summary: "Writes a snapshot of the heap and the goroutines into the profiles folder.", Handler: ProfileSnapshotHandler},
+}
@@ -7,15 +7,18 @@ import (
 	"aether-core/backend/responsegenerator"
 	"aether-core/io/api"
 	"aether-core/io/persistence"
+	"aether-core/services/clock"
 	"aether-core/services/globals"
 	"aether-core/services/logging"
+	"aether-core/services/membership"
+	"aether-core/services/peerrules"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
-	"time"
+	"strings"
 )
 
 // Server responds to GETs with the caches and to POSTS with the live data from the database.
@@ -24,21 +27,31 @@ func Serve() {
 		if r.Method == "GET" {
 			dir := fmt.Sprint(globals.UserDirectory, "/statics", r.URL.Path)
 			w.Header().Set("Content-Type", "application/json")
-			http.ServeFile(w, r, dir)
+			if serveStoredResponse(w, r, dir) {
+				return
+			}
+			ServeCacheFile(w, r, dir)
 		} else { // If not GET we bail.
 			w.WriteHeader(http.StatusNotFound)
 		}
 	})
 
+	for _, route := range localRoutes {
+		http.HandleFunc(route.Path, route.Handler)
+	}
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		// Force the content type to application/json, so even in the case of malicious file serving, it won't be executed by default.
 		w.Header().Set("Content-Type", "application/json")
+		r = withTrace(r)
+		noteClient(r)
 		if r.Method == "GET" {
+			w.Header().Set(TraceHeader, traceOf(r))
 			switch r.URL.Path {
 
 			case "/v0/status", "/v0/status/":
 				// Status GET endpoint returns HTTP 200 only if the node is up, and 429 Too Many Requests if the node is being overloaded.
-				if globals.TooManyConnections {
+				if globals.TooManyConnections || QueuedRequests() > 0 {
 					w.WriteHeader(http.StatusTooManyRequests)
 				} else {
 					w.WriteHeader(http.StatusOK)
@@ -48,25 +61,44 @@ func Serve() {
 			case "/v0/node", "/v0/node/":
 				// Node GET endpoint returns the node info.
 				var resp api.ApiResponse
-				r := responsegenerator.GeneratePrefilledApiResponse()
-				resp = *r
+				prefilled := responsegenerator.GeneratePrefilledApiResponse()
+				resp = *prefilled
 				resp.Endpoint = "node"
 				resp.Entity = "node"
-				resp.Timestamp = api.Timestamp(time.Now().Unix())
-				jsonResp, err := responsegenerator.ConvertApiResponseToJson(&resp)
+				resp.Timestamp = api.Timestamp(clock.Unix())
+				resp.TraceId = traceOf(r)
+				jsonResp, err := responsegenerator.EncodeSignedResponse(&resp, responsegenerator.DestinationResponses)
 				if err != nil {
-					logging.Log(1, errors.New(fmt.Sprintf("The response that was prepared to respond to this query failed to convert to JSON. Error: %#v\n", err)))
+					logging.LogTrace(traceOf(r), 1, errors.New(fmt.Sprintf("The response that was prepared to respond to this query failed to convert to JSON. Error: %#v\n", err)))
 				}
 				if len(jsonResp) == 0 {
 					w.WriteHeader(http.StatusBadRequest)
 					w.Write([]byte{})
 				} else {
-					w.Write(jsonResp)
+					w.Write(api.PadResponse(jsonResp))
+				}
+
+			case "/v0/openapi.json", "/v0/openapi.json/":
+				// The description of the endpoints. The local endpoints are only described to the local machine, which is the only one they answer to.
+				jsonResp, err := json.Marshal(OpenAPI(isLoopback(r)))
+				if err != nil {
+					logging.LogTrace(traceOf(r), 1, errors.New(fmt.Sprintf("The API description could not be converted to JSON. Error: %s", err)))
+					w.WriteHeader(http.StatusInternalServerError)
+					return
 				}
+				w.Write(jsonResp)
 
 			default:
 				// TODO: Convert this into a whitelist. This should not respond to the random requests, only the endpoints. It also should not list directories.
-				http.ServeFile(w, r, fmt.Sprint(globals.UserDirectory, "/statics/caches", r.URL.Path))
+				if len(r.URL.Query().Get("range")) > 0 {
+					serveCachePageRange(w, r)
+					return
+				}
+				path := cachePagePath(r)
+				if serveArchivedPage(w, r, path) {
+					return
+				}
+				ServeCacheFile(w, r, path)
 			}
 
 		} else if r.Method == "POST" {
@@ -75,8 +107,22 @@ func Serve() {
 			case "/v0/node", "/v0/node/":
 				resp, err := NodePOST(r)
 				if err != nil {
-					logging.Log(1, err)
+					logging.LogTrace(traceOf(r), 1, err)
 				}
+				w.Header().Set(TraceHeader, traceOf(r))
+				if len(resp) == 0 {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte{})
+				} else {
+					w.Write(resp)
+				}
+
+			case "/v0/peers", "/v0/peers/":
+				resp, err := PeersPOST(r)
+				if err != nil {
+					logging.LogTrace(traceOf(r), 1, err)
+				}
+				w.Header().Set(TraceHeader, traceOf(r))
 				if len(resp) == 0 {
 					w.WriteHeader(http.StatusBadRequest)
 					w.Write([]byte{})
@@ -87,8 +133,9 @@ func Serve() {
 			case "/v0/boards", "/v0/boards/":
 				resp, err := BoardsPOST(r)
 				if err != nil {
-					logging.Log(1, err)
+					logging.LogTrace(traceOf(r), 1, err)
 				}
+				w.Header().Set(TraceHeader, traceOf(r))
 				if len(resp) == 0 {
 					w.WriteHeader(http.StatusBadRequest)
 					w.Write([]byte{})
@@ -99,8 +146,9 @@ func Serve() {
 			case "/v0/threads", "/v0/threads/":
 				resp, err := ThreadsPOST(r)
 				if err != nil {
-					logging.Log(1, err)
+					logging.LogTrace(traceOf(r), 1, err)
 				}
+				w.Header().Set(TraceHeader, traceOf(r))
 				if len(resp) == 0 {
 					w.WriteHeader(http.StatusBadRequest)
 					w.Write([]byte{})
@@ -111,8 +159,9 @@ func Serve() {
 			case "/v0/posts", "/v0/posts/":
 				resp, err := PostsPOST(r)
 				if err != nil {
-					logging.Log(1, err)
+					logging.LogTrace(traceOf(r), 1, err)
 				}
+				w.Header().Set(TraceHeader, traceOf(r))
 				if len(resp) == 0 {
 					w.WriteHeader(http.StatusBadRequest)
 					w.Write([]byte{})
@@ -123,8 +172,9 @@ func Serve() {
 			case "/v0/votes", "/v0/votes/":
 				resp, err := VotesPOST(r)
 				if err != nil {
-					logging.Log(1, err)
+					logging.LogTrace(traceOf(r), 1, err)
 				}
+				w.Header().Set(TraceHeader, traceOf(r))
 				if len(resp) == 0 {
 					w.WriteHeader(http.StatusBadRequest)
 					w.Write([]byte{})
@@ -135,8 +185,9 @@ func Serve() {
 			case "/v0/keys", "/v0/keys/":
 				resp, err := KeysPOST(r)
 				if err != nil {
-					logging.Log(1, err)
+					logging.LogTrace(traceOf(r), 1, err)
 				}
+				w.Header().Set(TraceHeader, traceOf(r))
 				if len(resp) == 0 {
 					w.WriteHeader(http.StatusBadRequest)
 					w.Write([]byte{})
@@ -147,8 +198,9 @@ func Serve() {
 			case "/v0/addresses", "/v0/addresses/":
 				resp, err := AddressesPOST(r)
 				if err != nil {
-					logging.Log(1, err)
+					logging.LogTrace(traceOf(r), 1, err)
 				}
+				w.Header().Set(TraceHeader, traceOf(r))
 				if len(resp) == 0 {
 					w.WriteHeader(http.StatusBadRequest)
 					w.Write([]byte{})
@@ -159,8 +211,35 @@ func Serve() {
 			case "/v0/truststates", "/v0/truststates/":
 				resp, err := TruststatesPOST(r)
 				if err != nil {
-					logging.Log(1, err)
+					logging.LogTrace(traceOf(r), 1, err)
+				}
+				w.Header().Set(TraceHeader, traceOf(r))
+				if len(resp) == 0 {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte{})
+				} else {
+					w.Write(resp)
+				}
+
+			case "/v0/votesummaries", "/v0/votesummaries/":
+				resp, err := VoteSummariesPOST(r)
+				if err != nil {
+					logging.LogTrace(traceOf(r), 1, err)
+				}
+				w.Header().Set(TraceHeader, traceOf(r))
+				if len(resp) == 0 {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte{})
+				} else {
+					w.Write(resp)
+				}
+
+			case "/v0/tombstones", "/v0/tombstones/":
+				resp, err := TombstonesPOST(r)
+				if err != nil {
+					logging.LogTrace(traceOf(r), 1, err)
 				}
+				w.Header().Set(TraceHeader, traceOf(r))
 				if len(resp) == 0 {
 					w.WriteHeader(http.StatusBadRequest)
 					w.Write([]byte{})
@@ -169,18 +248,40 @@ func Serve() {
 				}
 
 			default:
-				w.WriteHeader(http.StatusNotFound)
+				// Endpoints registered in the response generator are served here, without a case of their own.
+				name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v0/"), "/")
+				if _, known := responsegenerator.LookupEndpoint(name); !known || !strings.HasPrefix(r.URL.Path, "/v0/") {
+					w.WriteHeader(http.StatusNotFound)
+					break
+				}
+				resp, err := EndpointPOST(r, name)
+				if err != nil {
+					logging.LogTrace(traceOf(r), 1, err)
+				}
+				w.Header().Set(TraceHeader, traceOf(r))
+				if len(resp) == 0 {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte{})
+				} else {
+					w.Write(resp)
+				}
 			}
 		} else { // If not GET or POST, we bail.
 			w.WriteHeader(http.StatusNotFound)
 		}
 	})
 	logging.Log(1, "Serving setup complete. Starting to serve publicly.")
-	http.ListenAndServe(fmt.Sprint("127.0.0.1", ":", 8089), nil)
+	err := listenAll(nil)
+	if err != nil {
+		logging.LogCrash(err)
+	}
 }
 
-// MaybeSaveRemote checks if the database has data about the remote that is reaching out. If not, save a new address.
+// MaybeSaveRemote checks if the database has data about the remote that is reaching out. If not, save a new address. A remote in privacy mode asks not to be saved.
 func MaybeSaveRemote(req api.ApiResponse) {
+	if api.IsUnlisted(req.Address) {
+		return
+	}
 	// We don't insert the node, only the address. Because the remote data is untrustable.
 	persistence.InsertOrUpdateAddress(req.Address)
 }
@@ -208,9 +309,14 @@ func insertLocallySourcedRemoteAddressDetails(r *http.Request, req *api.ApiRespo
 	}
 	req.Address.Sublocation = "" // It's coming from an IP address, not a URL.
 	req.Address.Location = api.Location(host)
-	req.Address.LastOnline = api.Timestamp(time.Now().Unix())
+	req.Address.LastOnline = api.Timestamp(clock.Unix())
 	req.Address.Type = 2 // If it is making a request to you, it cannot be a static node, by definition.
+	// The only extension kept is the one that asks not to be saved, since all it can do is to keep the remote out of the database.
+	unlisted := api.IsUnlisted(req.Address)
 	req.Address.Protocol.Extensions = []string{}
+	if unlisted {
+		req.Address.Protocol.Extensions = []string{api.UnlistedExtension}
+	}
 	req.Address.Protocol.VersionMajor = 0
 	req.Address.Protocol.VersionMinor = 0
 	req.Address.Client.ClientName = ""
@@ -231,6 +337,28 @@ func ParsePOSTRequest(r *http.Request) (api.ApiResponse, error) {
 	if err2 != nil {
 		return req, errors.New(fmt.Sprintf("The HTTP body could not be parsed into a valid request. Raw Body: %#v\n, Error: %#v\n", string(b), err2.Error()))
 	}
+	// If the remote is tracing this request on its side, use its trace id so the logs of both sides match. The response carries the trace id back either way.
+	adoptTrace(r, req.TraceId)
+	req.TraceId = traceOf(r)
+	// Remotes from other networks are refused before anything else.
+	err3 := membership.Admit(req.NetworkId, string(req.NodeId), req.MembershipProof)
+	if err3 != nil {
+		return req, err3
+	}
+	// So are the ones the operator has blocked.
+	if !peerrules.Allowed(remoteHost(r), string(req.NodeId)) {
+		return req, errors.New(fmt.Sprintf("The remote is blocked by the peer rules. Node: %s, Address: %s", req.NodeId, r.RemoteAddr))
+	}
+	// A recorded request can't be replayed: its nonce is used up, or its timestamp is out of the window.
+	errNonce := admitNonce(req)
+	if errNonce != nil {
+		return req, errNonce
+	}
+	// And the ones whose filters are over the validation policy.
+	errPolicy := api.CheckRequestPolicy(&req)
+	if errPolicy != nil {
+		return req, errPolicy
+	}
 	// Rules for the request: (TODO TESTS)
 	// - http.Request content-type == application/json
 	// - Node Id always 64 chars long
@@ -255,10 +383,21 @@ func ParsePOSTRequest(r *http.Request) (api.ApiResponse, error) {
 	return req, errors.New(fmt.Sprintf("The request is syntactically valid JSON, but it does not include certain vital information"))
 }
 
+// EndpointPOST responds to a POST request to an endpoint registered in the response generator.
+func EndpointPOST(r *http.Request, name string) ([]byte, error) {
+	req, err := ParsePOSTRequest(r)
+	if err != nil {
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
+		return []byte{}, nil
+	}
+	MaybeSaveRemote(req)
+	return responsegenerator.GeneratePOSTResponse(name, req)
+}
+
 func NodePOST(r *http.Request) ([]byte, error) {
 	req, err := ParsePOSTRequest(r)
 	if err != nil {
-		logging.Log(1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
 		return []byte{}, nil
 	}
 	MaybeSaveRemote(req)
@@ -266,10 +405,25 @@ func NodePOST(r *http.Request) ([]byte, error) {
 	return respAsByte, err
 }
 
+// PeersPOST responds with a small sample of known good addresses. The requester can list the addresses it already knows in a "known_peers" filter.
+func PeersPOST(r *http.Request) ([]byte, error) {
+	req, err := ParsePOSTRequest(r)
+	if err != nil {
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
+		return []byte{}, nil
+	}
+	MaybeSaveRemote(req)
+	respAsByte, err := responsegenerator.GeneratePOSTResponse("peers", req)
+	if err != nil {
+		return respAsByte, err
+	}
+	return respAsByte, nil
+}
+
 func BoardsPOST(r *http.Request) ([]byte, error) {
 	req, err := ParsePOSTRequest(r)
 	if err != nil {
-		logging.Log(1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
 		return []byte{}, nil
 	}
 	MaybeSaveRemote(req)
@@ -283,7 +437,7 @@ func BoardsPOST(r *http.Request) ([]byte, error) {
 func ThreadsPOST(r *http.Request) ([]byte, error) {
 	req, err := ParsePOSTRequest(r)
 	if err != nil {
-		logging.Log(1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
 		return []byte{}, nil
 	}
 	MaybeSaveRemote(req)
@@ -297,7 +451,7 @@ func ThreadsPOST(r *http.Request) ([]byte, error) {
 func PostsPOST(r *http.Request) ([]byte, error) {
 	req, err := ParsePOSTRequest(r)
 	if err != nil {
-		logging.Log(1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
 		return []byte{}, nil
 	}
 	MaybeSaveRemote(req)
@@ -311,7 +465,7 @@ func PostsPOST(r *http.Request) ([]byte, error) {
 func VotesPOST(r *http.Request) ([]byte, error) {
 	req, err := ParsePOSTRequest(r)
 	if err != nil {
-		logging.Log(1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
 		return []byte{}, nil
 	}
 	MaybeSaveRemote(req)
@@ -325,7 +479,7 @@ func VotesPOST(r *http.Request) ([]byte, error) {
 func AddressesPOST(r *http.Request) ([]byte, error) {
 	req, err := ParsePOSTRequest(r)
 	if err != nil {
-		logging.Log(1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
 		return []byte{}, nil
 	}
 	MaybeSaveRemote(req)
@@ -339,7 +493,7 @@ func AddressesPOST(r *http.Request) ([]byte, error) {
 func KeysPOST(r *http.Request) ([]byte, error) {
 	req, err := ParsePOSTRequest(r)
 	if err != nil {
-		logging.Log(1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
 		return []byte{}, nil
 	}
 	MaybeSaveRemote(req)
@@ -353,7 +507,7 @@ func KeysPOST(r *http.Request) ([]byte, error) {
 func TruststatesPOST(r *http.Request) ([]byte, error) {
 	req, err := ParsePOSTRequest(r)
 	if err != nil {
-		logging.Log(1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
 		return []byte{}, nil
 	}
 	MaybeSaveRemote(req)
@@ -363,3 +517,32 @@ func TruststatesPOST(r *http.Request) ([]byte, error) {
 	}
 	return respAsByte, nil
 }
+
+func TombstonesPOST(r *http.Request) ([]byte, error) {
+	req, err := ParsePOSTRequest(r)
+	if err != nil {
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
+		return []byte{}, nil
+	}
+	MaybeSaveRemote(req)
+	respAsByte, err := responsegenerator.GeneratePOSTResponse("tombstones", req)
+	if err != nil {
+		return respAsByte, err
+	}
+	return respAsByte, nil
+}
+
+// VoteSummariesPOST responds with the summaries of the votes this node compacted. The requester can ask for specific targets with a fingerprint filter.
+func VoteSummariesPOST(r *http.Request) ([]byte, error) {
+	req, err := ParsePOSTRequest(r)
+	if err != nil {
+		logging.LogTrace(traceOf(r), 1, fmt.Sprintf("POST request parsing failed. Error: %#v\n, Request Header: %#v\n, Request Body: %#v\n", err, r.Header, req))
+		return []byte{}, nil
+	}
+	MaybeSaveRemote(req)
+	respAsByte, err := responsegenerator.GeneratePOSTResponse("votesummaries", req)
+	if err != nil {
+		return respAsByte, err
+	}
+	return respAsByte, nil
+}
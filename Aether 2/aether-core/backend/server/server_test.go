@@ -1 +1,559 @@
 package server_test
+
+import (
+	"aether-core/backend/server"
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Infrastructure, setup and teardown
+
+var dir string
+var now = time.Unix(1500000000, 0)
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+	var err error
+	dir, err = ioutil.TempDir("", "aether-server")
+	if err != nil {
+		panic(err)
+	}
+	globals.UserDirectory = dir
+	clock.Set(clock.NewMockClock(now))
+}
+
+func teardown() {
+	clock.Reset()
+	os.RemoveAll(dir)
+}
+
+func newRequest(nonce string, ts api.Timestamp) *http.Request {
+	var req api.ApiResponse
+	req.NodeId = api.Fingerprint(strings.Repeat("a", 64))
+	req.Address.Port = 49999
+	req.Address.Type = 2
+	req.Address.Protocol.Extensions = []string{"aether"}
+	req.Nonce = nonce
+	req.Timestamp = ts
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest("POST", "/v0/node", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func boundResponse(nonce string) []byte {
+	var resp api.ApiResponse
+	resp.NodeId = api.Fingerprint(globals.NodeId)
+	resp.Entity = "node"
+	resp.Timestamp = api.Timestamp(now.Unix())
+	api.BindResponse(&resp, nonce)
+	raw, _ := json.Marshal(resp)
+	return raw
+}
+
+// Tests
+
+func TestParsePOSTRequest_Success(t *testing.T) {
+	_, err := server.ParsePOSTRequest(newRequest(api.NewNonce(), api.Timestamp(now.Unix())))
+	if err != nil {
+		t.Errorf("A fresh request should be accepted. Error: %s", err)
+	}
+	// The remotes that don't send nonces yet are served as before.
+	_, err2 := server.ParsePOSTRequest(newRequest("", 0))
+	if err2 != nil {
+		t.Errorf("A request without a nonce should be accepted. Error: %s", err2)
+	}
+}
+
+func TestParsePOSTRequest_Fail_ReplayedNonce(t *testing.T) {
+	nonce := api.NewNonce()
+	_, err := server.ParsePOSTRequest(newRequest(nonce, api.Timestamp(now.Unix())))
+	if err != nil {
+		t.Fatalf("The first request should be accepted. Error: %s", err)
+	}
+	_, err2 := server.ParsePOSTRequest(newRequest(nonce, api.Timestamp(now.Unix())))
+	if err2 == nil || !strings.Contains(err2.Error(), "already used") {
+		t.Errorf("A replayed request should be refused. Error: %v", err2)
+	}
+}
+
+func TestParsePOSTRequest_Fail_StaleTimestamp(t *testing.T) {
+	stale := api.Timestamp(now.Add(-2 * globals.ResponseBindingWindow).Unix())
+	_, err := server.ParsePOSTRequest(newRequest(api.NewNonce(), stale))
+	if err == nil || !strings.Contains(err.Error(), "binding window") {
+		t.Errorf("A request out of the binding window should be refused. Error: %v", err)
+	}
+	_, err2 := server.ParsePOSTRequest(newRequest("not a nonce", api.Timestamp(now.Unix())))
+	if err2 == nil {
+		t.Errorf("A request with an invalid nonce should be refused.")
+	}
+}
+
+func TestParsePOSTRequest_Unlisted_Success(t *testing.T) {
+	req, err := server.ParsePOSTRequest(newRequest(api.NewNonce(), api.Timestamp(now.Unix())))
+	if err != nil || len(req.Address.Protocol.Extensions) != 0 {
+		t.Errorf("The extensions the remote gives should not be kept. Extensions: %v, Error: %v", req.Address.Protocol.Extensions, err)
+	}
+	r := newRequest(api.NewNonce(), api.Timestamp(now.Unix()))
+	var body api.ApiResponse
+	json.NewDecoder(r.Body).Decode(&body)
+	body.Address.Protocol.Extensions = []string{"aether", "cursor", api.UnlistedExtension}
+	raw, _ := json.Marshal(body)
+	r = httptest.NewRequest("POST", "/v0/node", bytes.NewReader(raw))
+	r.Header.Set("Content-Type", "application/json")
+	req2, err2 := server.ParsePOSTRequest(r)
+	if err2 != nil || !api.IsUnlisted(req2.Address) || len(req2.Address.Protocol.Extensions) != 1 {
+		t.Errorf("A remote that asks not to be saved should be kept unlisted, and nothing else. Extensions: %v, Error: %v", req2.Address.Protocol.Extensions, err2)
+	}
+}
+
+func TestParsePOSTRequest_Fail_OverPolicy(t *testing.T) {
+	defer func(v int) { globals.ValidationMaxFilters = v }(globals.ValidationMaxFilters)
+	globals.ValidationMaxFilters = 1
+	r := newRequest(api.NewNonce(), api.Timestamp(now.Unix()))
+	var body api.ApiResponse
+	json.NewDecoder(r.Body).Decode(&body)
+	body.Filters = []api.Filter{api.Filter{Type: "language", Values: []string{"en"}}, api.Filter{Type: "language", Values: []string{"de"}}}
+	raw, _ := json.Marshal(body)
+	r = httptest.NewRequest("POST", "/v0/threads", bytes.NewReader(raw))
+	r.Header.Set("Content-Type", "application/json")
+	_, err := server.ParsePOSTRequest(r)
+	if !api.IsLimitError(err) {
+		t.Errorf("A request with more filters than the validation policy allows should be refused. Error: %v", err)
+	}
+	links := make([]api.ResultCache, globals.ValidationMaxResultCaches+2)
+	links[2].ResponseUrl = "oldest kept"
+	if trimmed := api.TrimCacheLinks(links); len(trimmed) != globals.ValidationMaxResultCaches || trimmed[0].ResponseUrl != "oldest kept" {
+		t.Errorf("The oldest cache links over the validation policy should be left out of the index.")
+	}
+}
+
+func TestVerifyBinding_Success(t *testing.T) {
+	nonce := api.NewNonce()
+	raw := boundResponse(nonce)
+	var resp api.ApiResponse
+	json.Unmarshal(raw, &resp)
+	err := api.VerifyBinding(raw, &resp, nonce, now)
+	if err != nil {
+		t.Errorf("A bound response should verify. Error: %s", err)
+	}
+}
+
+func TestVerifyBinding_Fail_ReplayedResponse(t *testing.T) {
+	raw := boundResponse(api.NewNonce())
+	var resp api.ApiResponse
+	json.Unmarshal(raw, &resp)
+	err := api.VerifyBinding(raw, &resp, api.NewNonce(), now)
+	if err == nil || !strings.Contains(err.Error(), "another request") {
+		t.Errorf("A response made for another request should be refused. Error: %v", err)
+	}
+}
+
+func TestVerifyBinding_Fail_Tampered(t *testing.T) {
+	nonce := api.NewNonce()
+	raw := boundResponse(nonce)
+	tampered := bytes.Replace(raw, []byte(`"entity":"node"`), []byte(`"entity":"boards"`), 1)
+	var resp api.ApiResponse
+	json.Unmarshal(tampered, &resp)
+	err := api.VerifyBinding(tampered, &resp, nonce, now)
+	if err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("A response changed after signing should be refused. Error: %v", err)
+	}
+	json.Unmarshal(raw, &resp)
+	err2 := api.VerifyBinding(raw, &resp, nonce, now.Add(2*globals.ResponseBindingWindow))
+	if err2 == nil || !strings.Contains(err2.Error(), "binding window") {
+		t.Errorf("An old response should be refused. Error: %v", err2)
+	}
+}
+
+func TestVerifyBinding_Fail_Unbound(t *testing.T) {
+	var resp api.ApiResponse
+	raw, _ := json.Marshal(resp)
+	if err := api.VerifyBinding(raw, &resp, api.NewNonce(), now); err != nil {
+		t.Errorf("An unbound response should be accepted unless binding is required. Error: %s", err)
+	}
+	globals.RequireResponseBinding = true
+	defer func() { globals.RequireResponseBinding = false }()
+	if err := api.VerifyBinding(raw, &resp, api.NewNonce(), now); err == nil {
+		t.Errorf("An unbound response should be refused when binding is required.")
+	}
+}
+
+func signedResponse() []byte {
+	var resp api.ApiResponse
+	resp.NodeId = api.Fingerprint(globals.NodeId)
+	resp.Entity = "boards"
+	resp.Timestamp = api.Timestamp(now.Unix())
+	api.SignResponse(&resp)
+	raw, _ := json.Marshal(resp)
+	return raw
+}
+
+func TestVerifyResponse_Success(t *testing.T) {
+	raw := signedResponse()
+	var resp api.ApiResponse
+	json.Unmarshal(raw, &resp)
+	if err := api.VerifyResponse(raw, &resp, ""); err != nil {
+		t.Errorf("A signed response should verify. Error: %s", err)
+	}
+	if err := api.VerifyResponse(raw, &resp, globals.MarshaledPubKey); err != nil {
+		t.Errorf("A response signed by the pinned key should verify. Error: %s", err)
+	}
+	// The signature covers the JSON as it arrived, including the fields this version doesn't know of.
+	withUnknown := bytes.Replace(raw, []byte(`{`), []byte(`{"from_the_future":[1,2],`), 1)
+	if err := api.VerifyResponse(withUnknown, &resp, ""); err == nil {
+		t.Errorf("A field added after signing should break the signature.")
+	}
+}
+
+func TestPadResponse_Success(t *testing.T) {
+	raw := signedResponse()
+	if padded := api.PadResponse(raw); len(padded) != len(raw) {
+		t.Errorf("Outside privacy mode, a response should not be padded. Size: %d, Padded: %d", len(raw), len(padded))
+	}
+	globals.PrivacyMode = true
+	defer func() { globals.PrivacyMode = false }()
+	for _, size := range []int{len(raw), 1500, 5000, 3*1024*1024 + 1} {
+		data := append(append([]byte{}, raw...), bytes.Repeat([]byte(" "), size-len(raw))...)
+		padded := api.PadResponse(data)
+		bucket := len(padded)
+		if bucket < size || (bucket <= 1024*1024 && bucket&(bucket-1) != 0) || (bucket > 1024*1024 && bucket%(1024*1024) != 0) {
+			t.Errorf("A response should be padded to a size bucket. Size: %d, Padded: %d", size, bucket)
+		}
+	}
+	padded := api.PadResponse(raw)
+	var resp api.ApiResponse
+	if err := json.Unmarshal(padded, &resp); err != nil {
+		t.Fatalf("A padded response should still parse. Error: %s", err)
+	}
+	if err := api.VerifyResponse(padded, &resp, globals.MarshaledPubKey); err != nil {
+		t.Errorf("A padded response should still verify. Error: %s", err)
+	}
+}
+
+func TestVerifyResponse_Fail_OtherKey(t *testing.T) {
+	raw := signedResponse()
+	var resp api.ApiResponse
+	json.Unmarshal(raw, &resp)
+	pinned := globals.MarshaledPubKey
+	globals.GenerateUserKeyPair()
+	raw2 := signedResponse()
+	var resp2 api.ApiResponse
+	json.Unmarshal(raw2, &resp2)
+	if err := api.VerifyResponse(raw2, &resp2, pinned); err == nil || !strings.Contains(err.Error(), "another key") {
+		t.Errorf("A response signed by a key other than the pinned one should be refused. Error: %v", err)
+	}
+	// A valid signature under a substituted key is caught by the pin; a substituted key without a valid signature is caught by the signature.
+	resp.NodePublicKey = resp2.NodePublicKey
+	if err := api.VerifyResponse(raw, &resp, ""); err == nil {
+		t.Errorf("A response whose key was substituted should be refused.")
+	}
+}
+
+func TestVerifyResponse_Fail_Unsigned(t *testing.T) {
+	var resp api.ApiResponse
+	raw, _ := json.Marshal(resp)
+	if err := api.VerifyResponse(raw, &resp, ""); err != nil {
+		t.Errorf("An unsigned response should be accepted unless signatures are required. Error: %s", err)
+	}
+	globals.RequireResponseSignatures = true
+	defer func() { globals.RequireResponseSignatures = false }()
+	if err := api.VerifyResponse(raw, &resp, ""); err == nil {
+		t.Errorf("An unsigned response should be refused when signatures are required.")
+	}
+}
+
+func TestServeCacheFile_Success(t *testing.T) {
+	path := dir + "/index.json"
+	ioutil.WriteFile(path, []byte(`{"entity":"boards"}`), 0755)
+	w := httptest.NewRecorder()
+	server.ServeCacheFile(w, httptest.NewRequest("GET", "/v0/boards/index.json", nil), path)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || len(etag) == 0 || len(w.Header().Get("Last-Modified")) == 0 {
+		t.Fatalf("Expected the file with an ETag and a Last-Modified. Code: %d, ETag: %s", w.Code, etag)
+	}
+	r := httptest.NewRequest("GET", "/v0/boards/index.json", nil)
+	r.Header.Set("If-None-Match", etag)
+	w2 := httptest.NewRecorder()
+	server.ServeCacheFile(w2, r, path)
+	if w2.Code != http.StatusNotModified || w2.Body.Len() != 0 {
+		t.Errorf("An unchanged file should be answered with a 304 and no body. Code: %d", w2.Code)
+	}
+}
+
+func TestServeCacheFile_Fail_Changed(t *testing.T) {
+	path := dir + "/index.json"
+	ioutil.WriteFile(path, []byte(`{"entity":"boards"}`), 0755)
+	w := httptest.NewRecorder()
+	server.ServeCacheFile(w, httptest.NewRequest("GET", "/v0/boards/index.json", nil), path)
+	etag := w.Header().Get("ETag")
+	ioutil.WriteFile(path, []byte(`{"entity":"boards","timestamp":1}`), 0755)
+	r := httptest.NewRequest("GET", "/v0/boards/index.json", nil)
+	r.Header.Set("If-None-Match", etag)
+	w2 := httptest.NewRecorder()
+	server.ServeCacheFile(w2, r, path)
+	if w2.Code != http.StatusOK || w2.Header().Get("ETag") == etag {
+		t.Errorf("A changed file should be sent again with a new ETag. Code: %d", w2.Code)
+	}
+	w3 := httptest.NewRecorder()
+	server.ServeCacheFile(w3, httptest.NewRequest("GET", "/v0/boards/missing.json", nil), dir+"/missing.json")
+	if w3.Code != http.StatusNotFound || len(w3.Header().Get("ETag")) > 0 {
+		t.Errorf("A missing file should be a 404 without an ETag. Code: %d", w3.Code)
+	}
+}
+
+func fingerprintRequest(host string, fps ...string) *http.Request {
+	var req api.ApiResponse
+	req.Address.Port = 49999
+	req.Filters = []api.Filter{{Type: "fingerprint", Values: fps}}
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest("POST", "/v0/boards", bytes.NewReader(body))
+	r.RemoteAddr = host + ":49999"
+	return r
+}
+
+func serveLimited(r *http.Request) (int, bool) {
+	reached := false
+	h := server.LimitFingerprintQueries(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		b, _ := ioutil.ReadAll(r.Body)
+		if len(b) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code, reached
+}
+
+func TestLimitFingerprintQueries_Success(t *testing.T) {
+	code, reached := serveLimited(fingerprintRequest("192.0.2.10", "aa", "bb"))
+	if code != http.StatusOK || !reached {
+		t.Errorf("A request within the limits should reach the handler with its body. Code: %d, Reached: %v", code, reached)
+	}
+}
+
+func TestLimitFingerprintQueries_Fail_PerRequest(t *testing.T) {
+	defer func(v int) { globals.FingerprintQueryMaxPerRequest = v }(globals.FingerprintQueryMaxPerRequest)
+	globals.FingerprintQueryMaxPerRequest = 2
+	code, reached := serveLimited(fingerprintRequest("192.0.2.11", "aa", "bb", "cc"))
+	if code != http.StatusTooManyRequests || reached {
+		t.Errorf("A request over the per request limit should be throttled. Code: %d, Reached: %v", code, reached)
+	}
+}
+
+func TestLimitFingerprintQueries_Fail_PerHour(t *testing.T) {
+	defer func(v int) { globals.FingerprintQueryMaxPerHour = v }(globals.FingerprintQueryMaxPerHour)
+	globals.FingerprintQueryMaxPerHour = 3
+	code, _ := serveLimited(fingerprintRequest("192.0.2.12", "bb", "aa"))
+	if code != http.StatusOK {
+		t.Fatalf("The first request should be within the limits. Code: %d", code)
+	}
+	r := fingerprintRequest("192.0.2.12", "cc", "dd")
+	w := httptest.NewRecorder()
+	server.LimitFingerprintQueries(http.NotFoundHandler()).ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3600" {
+		t.Errorf("A request over the hourly limit should be throttled until the hour is over. Code: %d, Retry-After: %s", w.Code, w.Header().Get("Retry-After"))
+	}
+	code2, _ := serveLimited(fingerprintRequest("192.0.2.13", "cc", "dd"))
+	if code2 != http.StatusOK {
+		t.Errorf("The limits of one remote should not apply to another. Code: %d", code2)
+	}
+}
+
+func TestLimitFingerprintQueries_Fail_Scanning(t *testing.T) {
+	defer func(v int) { globals.FingerprintScanRunLength = v }(globals.FingerprintScanRunLength)
+	globals.FingerprintScanRunLength = 3
+	// Out of order requests are not a scan.
+	for _, fp := range []string{"b0", "a0", "c0", "a1"} {
+		code, _ := serveLimited(fingerprintRequest("192.0.2.14", fp))
+		if code != http.StatusOK {
+			t.Fatalf("Requests out of order should not be taken for a scan. Fingerprint: %s, Code: %d", fp, code)
+		}
+	}
+	serveLimited(fingerprintRequest("192.0.2.15", "a0", "a1"))
+	serveLimited(fingerprintRequest("192.0.2.15", "a2", "a3"))
+	code, _ := serveLimited(fingerprintRequest("192.0.2.15", "a4", "a5"))
+	if code != http.StatusTooManyRequests {
+		t.Errorf("A remote walking the fingerprints in order should be throttled. Code: %d", code)
+	}
+	code2, _ := serveLimited(fingerprintRequest("192.0.2.15", "00"))
+	if code2 != http.StatusTooManyRequests {
+		t.Errorf("A remote found scanning should stay throttled. Code: %d", code2)
+	}
+}
+
+func TestCheckMeta_Success(t *testing.T) {
+	m := api.Meta{"flair": "question", "client.version": "2.0"}
+	if err := api.CheckMeta("posts", "fp", m); err != nil {
+		t.Errorf("Test failed, err: '%s'", err)
+	}
+	if err := api.CheckMeta("posts", "fp", nil); err != nil {
+		t.Errorf("An entity without metadata should pass. Err: '%s'", err)
+	}
+}
+
+func TestCheckMeta_Fail_TooLarge(t *testing.T) {
+	m := api.Meta{"body": strings.Repeat("a", 2000)}
+	if err := api.CheckMeta("posts", "fp", m); err == nil {
+		t.Errorf("Metadata over the size limit should have been rejected.")
+	}
+	var resp api.ApiResponse
+	resp.ResponseBody.Posts = []api.Post{{Meta: m}}
+	if err := api.CheckPageLimits(&resp); err == nil {
+		t.Errorf("A page with oversized metadata should have been rejected.")
+	}
+}
+
+func TestCheckMeta_Fail_InvalidKey(t *testing.T) {
+	for _, key := range []string{"", "Flair", "flair tag", strings.Repeat("a", 65)} {
+		if err := api.CheckMeta("posts", "fp", api.Meta{key: "x"}); err == nil {
+			t.Errorf("The key should have been rejected. Key: %q", key)
+		}
+	}
+}
+
+func TestBoardFingerprint_IgnoresMeta_Success(t *testing.T) {
+	b := api.Board{Name: "board", Owner: "owner"}
+	b.Creation = 1
+	b.CreateFingerprint()
+	fp := b.Fingerprint
+	b.Meta = api.Meta{"rules.version": "3"}
+	if !b.VerifyFingerprint() {
+		t.Errorf("The metadata of a board is mutable, so it should not change its fingerprint.")
+	}
+	b.CreateFingerprint()
+	if b.Fingerprint != fp {
+		t.Errorf("The fingerprint changed with the metadata. Before: %s, After: %s", fp, b.Fingerprint)
+	}
+}
+
+func TestEntitiesHandler_Fail_NotLoopback(t *testing.T) {
+	r := httptest.NewRequest("POST", "/admin/entities", strings.NewReader(`{"posts": []}`))
+	r.RemoteAddr = "192.0.2.20:49999"
+	w := httptest.NewRecorder()
+	server.EntitiesHandler(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Only the loopback interface should be able to add entities. Code: %d", w.Code)
+	}
+}
+
+func TestEntitiesHandler_Fail_UnknownType(t *testing.T) {
+	r := httptest.NewRequest("GET", "/admin/entities?type=addresses&fingerprints=aa", nil)
+	r.RemoteAddr = "127.0.0.1:49999"
+	w := httptest.NewRecorder()
+	server.EntitiesHandler(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("The arrivals of the addresses can't be asked for. Code: %d", w.Code)
+	}
+}
+
+func TestProvenanceHandler_Fail_BadLimit(t *testing.T) {
+	for _, query := range []string{"limit=0", "limit=100000", "since=-1", "limit=ten"} {
+		r := httptest.NewRequest("GET", "/admin/provenance?"+query, nil)
+		r.RemoteAddr = "127.0.0.1:49999"
+		w := httptest.NewRecorder()
+		server.ProvenanceHandler(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("A provenance request with a bad since or limit should be refused. Query: %s, Code: %d", query, w.Code)
+		}
+	}
+	r := httptest.NewRequest("GET", "/admin/provenance", nil)
+	r.RemoteAddr = "192.0.2.20:49999"
+	w := httptest.NewRecorder()
+	server.ProvenanceHandler(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Only the loopback interface should be able to read the provenance. Code: %d", w.Code)
+	}
+}
+
+// shedRequest sends a request of the remote through the load shedding to the handler, and gives what it was answered with.
+func shedRequest(host string, handler http.Handler) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/v0/posts", strings.NewReader("{}"))
+	r.RemoteAddr = host + ":49999"
+	w := httptest.NewRecorder()
+	server.ShedLoad(handler).ServeHTTP(w, r)
+	return w
+}
+
+// waitForQueue waits until a request is waiting for a slot.
+func waitForQueue(t *testing.T) {
+	for i := 0; i < 200 && server.QueuedRequests() == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if server.QueuedRequests() == 0 {
+		t.Fatal("The request should be waiting for a slot.")
+	}
+}
+
+func TestShedLoad_Success(t *testing.T) {
+	defer func(c int, q int) { globals.ServingMaxConcurrent, globals.ServingQueueMax = c, q }(globals.ServingMaxConcurrent, globals.ServingQueueMax)
+	globals.ServingMaxConcurrent = 1
+	globals.ServingQueueMax = 1
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	// A remote that has been served well for a week.
+	for i := 0; i < 100; i++ {
+		shedRequest("192.0.2.40", ok)
+	}
+	clock.Set(clock.NewMockClock(now.Add(7 * 24 * time.Hour)))
+	defer clock.Set(clock.NewMockClock(now))
+	hold := make(chan struct{})
+	held := make(chan struct{})
+	go shedRequest("192.0.2.41", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(held)
+		<-hold
+	}))
+	<-held
+	unknown := make(chan int)
+	go func() { unknown <- shedRequest("192.0.2.42", ok).Code }()
+	waitForQueue(t)
+	known := make(chan int)
+	go func() { known <- shedRequest("192.0.2.40", ok).Code }()
+	if code := <-unknown; code != http.StatusServiceUnavailable {
+		t.Errorf("The unknown remote should make room for the one in better standing. Code: %d", code)
+	}
+	close(hold)
+	if code := <-known; code != http.StatusOK {
+		t.Errorf("The remote in better standing should be served when the slot is free. Code: %d", code)
+	}
+}
+
+func TestShedLoad_Fail_QueueFull(t *testing.T) {
+	defer func(c int, q int) { globals.ServingMaxConcurrent, globals.ServingQueueMax = c, q }(globals.ServingMaxConcurrent, globals.ServingQueueMax)
+	globals.ServingMaxConcurrent = 1
+	globals.ServingQueueMax = 0
+	hold := make(chan struct{})
+	held := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		shedRequest("192.0.2.43", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(held)
+			<-hold
+		}))
+		close(done)
+	}()
+	<-held
+	w := shedRequest("192.0.2.44", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	close(hold)
+	<-done
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "30" {
+		t.Errorf("A request that can't be served or queued should be refused with a time to come back. Code: %d, Retry-After: %s", w.Code, w.Header().Get("Retry-After"))
+	}
+}
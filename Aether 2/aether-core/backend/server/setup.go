@@ -0,0 +1,95 @@
+// Backend > Server > Setup
+// This file provides the first-run setup to the local frontend. GET gives the state of the setup, and a POST to the path of a step takes it, with the choices of the step in the body. Both give the whole state back.
+
+package server
+
+import (
+	"aether-core/backend/setup"
+	"aether-core/io/api"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// setupRequest is the body of a POST to a step of the setup. Each step reads only its own fields.
+type setupRequest struct {
+	Path          string            `json:"path"`    // data_directory
+	Replace       bool              `json:"replace"` // identity
+	Network       string            `json:"network"` // network: "public" | "private"
+	NetworkId     string            `json:"network_id"`
+	MembershipKey string            `json:"membership_key"`
+	Mode          string            `json:"mode"`   // serving_mode: "full" | "light"
+	Boards        []api.Fingerprint `json:"boards"` // subscriptions
+}
+
+func readSetupRequest(r *http.Request) (setupRequest, error) {
+	var req setupRequest
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return req, err
+	}
+	if len(body) == 0 {
+		return req, nil
+	}
+	err2 := json.Unmarshal(body, &req)
+	if err2 != nil {
+		return req, errors.New(fmt.Sprintf("The setup request could not be parsed. Error: %s", err2))
+	}
+	return req, nil
+}
+
+// takeSetupStep takes the step of the setup with the choices in the request.
+func takeSetupStep(step string, req setupRequest) (setup.State, error) {
+	switch step {
+	case setup.StepDataDirectory:
+		return setup.ChooseDataDirectory(req.Path)
+	case setup.StepIdentity:
+		return setup.GenerateIdentity(req.Replace)
+	case setup.StepNetwork:
+		return setup.ChooseNetwork(req.Network, req.NetworkId, req.MembershipKey)
+	case setup.StepServingMode:
+		return setup.ChooseServingMode(req.Mode)
+	case setup.StepSubscriptions:
+		return setup.ChooseSubscriptions(req.Boards)
+	case setup.StepReachability:
+		check := checkListener()
+		return setup.CheckReachability(BoundAddresses(), check.Status != HealthFail, check.Details)
+	case "complete":
+		return setup.Complete()
+	}
+	return setup.State{}, errors.New(fmt.Sprintf("There is no such step of the setup. Step: %s", step))
+}
+
+// SetupHandler responds to GET at /frontend/setup with the state of the first-run setup, and takes a step on POST to /frontend/setup/<step>, where the step is one of data_directory, identity, network, serving_mode, subscriptions, reachability and complete. Body: {"path"} for data_directory, {"replace"} for identity, {"network": "public" | "private", "network_id", "membership_key"} for network, {"mode": "full" | "light"} for serving_mode, {"boards"} for subscriptions. If a step fails, the error is given with the state: {"error", "state"}.
+func SetupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	step := strings.Trim(strings.TrimPrefix(r.URL.Path, "/frontend/setup"), "/")
+	switch {
+	case r.Method == "GET" && len(step) == 0:
+		st, err := setup.GetState()
+		respondToFrontendCommand(w, st, err)
+	case r.Method == "POST" && len(step) > 0:
+		req, err := readSetupRequest(r)
+		if err != nil {
+			respondToFrontendCommand(w, nil, err)
+			return
+		}
+		st, err2 := takeSetupStep(step, req)
+		if err2 != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			jsonResp, _ := json.Marshal(map[string]interface{}{"error": err2.Error(), "state": st})
+			w.Write(jsonResp)
+			return
+		}
+		respondToFrontendCommand(w, st, nil)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
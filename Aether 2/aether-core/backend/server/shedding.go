@@ -0,0 +1,218 @@
+// Backend > Server > Shedding
+// This file keeps the server serviceable when it is loaded. Only so many requests of the remotes are served at once, and the others wait for a free slot. The slots go to the remotes with the best standing first: the ones this node has seen for longer, and that were served more often without going over the limits. When too many are waiting, or one has waited too long, the remotes with the worst standing are refused with 503 Service Unavailable and told when to come back. The standings are kept in memory, so they start over when the node restarts.
+
+package server
+
+import (
+	"aether-core/backend/dispatch"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// tenureForFullScore is how long a remote has to be known for its tenure to count fully.
+	tenureForFullScore = 7 * 24 * time.Hour
+	// servedForFullScore is how many requests a remote has to have been served for its record to count fully.
+	servedForFullScore = 1000
+	// refusalWeight is how many served requests a refused one cancels out.
+	refusalWeight = 10
+	// maxStandings is how many remotes the standings are kept for. The one seen least recently is forgotten first.
+	maxStandings = 10000
+)
+
+// standing is what the server knows of how a remote behaved.
+type standing struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+	served    int // Requests answered with success.
+	refused   int // Requests refused for going over the limits, or for being malformed.
+}
+
+var standingsLock sync.Mutex
+var standings = make(map[string]*standing) // Remote host > standing
+
+// peerScore gives the standing of the remote as a score from 0 to 100. An unknown remote is 10, and one that is penalised for going over the inbound limits is 0, so it comes after the unknown ones.
+func peerScore(host string) int {
+	if dispatch.IsPenalisedHost(host) {
+		return 0
+	}
+	standingsLock.Lock()
+	defer standingsLock.Unlock()
+	s, ok := standings[host]
+	if !ok {
+		return 10
+	}
+	tenure := float64(clock.Since(s.firstSeen)) / float64(tenureForFullScore)
+	if tenure > 1 {
+		tenure = 1
+	}
+	record := float64(s.served) / float64(servedForFullScore)
+	if record > 1 {
+		record = 1
+	}
+	if s.served > 0 || s.refused > 0 {
+		record = record * float64(s.served) / float64(s.served+refusalWeight*s.refused)
+	}
+	return 10 + int(45*tenure+45*record)
+}
+
+// recordOutcome counts the response given to the remote in its standing.
+func recordOutcome(host string, status int) {
+	standingsLock.Lock()
+	defer standingsLock.Unlock()
+	now := clock.Now()
+	s, ok := standings[host]
+	if !ok {
+		if len(standings) >= maxStandings {
+			var oldest string
+			for h, st := range standings {
+				if len(oldest) == 0 || st.lastSeen.Before(standings[oldest].lastSeen) {
+					oldest = h
+				}
+			}
+			delete(standings, oldest)
+		}
+		s = &standing{firstSeen: now}
+		standings[host] = s
+	}
+	s.lastSeen = now
+	switch {
+	case status >= 200 && status < 400:
+		s.served++
+	case status >= 400 && status < 500 && status != http.StatusNotFound:
+		s.refused++
+	}
+}
+
+// waiter is a request waiting for a slot. It is told on ready whether it got one.
+type waiter struct {
+	host  string
+	score int
+	ready chan bool
+}
+
+var admissionLock sync.Mutex
+var inFlight int
+var waiting []*waiter
+
+// admit waits for a slot for a request of the remote. It returns a function that frees the slot, or an error if the request is refused.
+func admit(host string, score int) (func(), error) {
+	admissionLock.Lock()
+	if inFlight < globals.ServingMaxConcurrent && len(waiting) == 0 {
+		inFlight++
+		admissionLock.Unlock()
+		return release, nil
+	}
+	w := &waiter{host: host, score: score, ready: make(chan bool, 1)}
+	if len(waiting) >= globals.ServingQueueMax {
+		// The queue is full. The waiter with the worst standing makes room, unless this one is worse still.
+		worst := -1
+		for i, _ := range waiting {
+			// The later of the ties is shed, since it waited less.
+			if worst == -1 || waiting[i].score <= waiting[worst].score {
+				worst = i
+			}
+		}
+		if worst == -1 || waiting[worst].score >= score {
+			admissionLock.Unlock()
+			return nil, errors.New(fmt.Sprintf("The server is loaded, and the remote was refused to serve the ones in better standing. Address: %s, Score: %d", host, score))
+		}
+		waiting[worst].ready <- false
+		waiting = append(waiting[:worst], waiting[worst+1:]...)
+	}
+	waiting = append(waiting, w)
+	admissionLock.Unlock()
+	timer := time.NewTimer(globals.ServingQueueTimeout)
+	defer timer.Stop()
+	select {
+	case ok := <-w.ready:
+		if ok {
+			return release, nil
+		}
+		return nil, errors.New(fmt.Sprintf("The server is loaded, and the remote made room for one in better standing. Address: %s, Score: %d", host, score))
+	case <-timer.C:
+	}
+	admissionLock.Lock()
+	defer admissionLock.Unlock()
+	for i, _ := range waiting {
+		if waiting[i] == w {
+			waiting = append(waiting[:i], waiting[i+1:]...)
+			return nil, errors.New(fmt.Sprintf("The server is loaded, and the remote waited too long for a slot. Address: %s, Score: %d", host, score))
+		}
+	}
+	// It was given a slot, or made room, as the time ran out.
+	if <-w.ready {
+		return release, nil
+	}
+	return nil, errors.New(fmt.Sprintf("The server is loaded, and the remote made room for one in better standing. Address: %s, Score: %d", host, score))
+}
+
+// QueuedRequests gives how many requests of the remotes are waiting for a slot. While any are, the node is loaded.
+func QueuedRequests() int {
+	admissionLock.Lock()
+	defer admissionLock.Unlock()
+	return len(waiting)
+}
+
+// release frees a slot, and hands it to the waiter with the best standing, the earliest of the ties.
+func release() {
+	admissionLock.Lock()
+	defer admissionLock.Unlock()
+	if len(waiting) == 0 || inFlight > globals.ServingMaxConcurrent {
+		// The limit was lowered while the slot was taken. It is not handed on.
+		inFlight--
+		return
+	}
+	best := 0
+	for i, _ := range waiting {
+		if waiting[i].score > waiting[best].score {
+			best = i
+		}
+	}
+	waiting[best].ready <- true
+	waiting = append(waiting[:best], waiting[best+1:]...)
+}
+
+// statusRecorder keeps the status the handler responded with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// ShedLoad serves the requests of the remotes by their standing when the server is loaded, and counts what they were answered with in their standing. The local machine and the status endpoint, which tells the remotes that the node is loaded, are not held back.
+func ShedLoad(handler http.Handler) http.Handler {
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isLoopback(r) || r.URL.Path == "/v0/status" || r.URL.Path == "/v0/status/" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		host := remoteHost(r)
+		if globals.LoadSheddingEnabled {
+			done, err := admit(host, peerScore(host))
+			if err != nil {
+				logging.LogSampled("server", "load-shedding", 1, err)
+				w.Header().Set("Retry-After", fmt.Sprint(int64((globals.LoadSheddingRetryAfter+time.Second-1)/time.Second)))
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			defer done()
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(rec, r)
+		recordOutcome(host, rec.status)
+	})
+}
@@ -0,0 +1,46 @@
+// Backend > Server > Trace
+// This file gives every inbound request a trace id, which is attached to the log lines produced while serving it, and returned to the remote.
+
+package server
+
+import (
+	"aether-core/services/logging"
+	"context"
+	"net/http"
+)
+
+// TraceHeader carries the trace id in both directions. A remote can set it to have its own trace id used, and it is always set on the response.
+const TraceHeader = "X-Aether-Trace-Id"
+
+type traceKey struct{}
+
+// trace is kept in the request context as a pointer, so that a trace id found later, in the body of a POST request, can replace the one given at the start.
+type trace struct {
+	id string
+}
+
+// withTrace attaches a trace id to the request: the one given by the remote, if it is valid, or a new one.
+func withTrace(r *http.Request) *http.Request {
+	id := r.Header.Get(TraceHeader)
+	if !logging.ValidTraceId(id) {
+		id = logging.NewTraceId()
+	}
+	return r.WithContext(context.WithValue(r.Context(), traceKey{}, &trace{id: id}))
+}
+
+// traceOf returns the trace id of the request. Requests that did not go through withTrace get a new one.
+func traceOf(r *http.Request) string {
+	tr, ok := r.Context().Value(traceKey{}).(*trace)
+	if !ok {
+		return logging.NewTraceId()
+	}
+	return tr.id
+}
+
+// adoptTrace replaces the trace id of the request with the one the remote sent in the body of its request, if it is valid.
+func adoptTrace(r *http.Request, id string) {
+	tr, ok := r.Context().Value(traceKey{}).(*trace)
+	if ok && logging.ValidTraceId(id) {
+		tr.id = id
+	}
+}
@@ -0,0 +1,135 @@
+// Backend > Server > Watches
+// This file provides the watches of the local user to the frontend: watching threads and boards, and the feed of what is new in them.
+
+package server
+
+import (
+	"aether-core/backend/contentfilters"
+	"aether-core/backend/watches"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// watchFeedPage is the response of the watch feed endpoint.
+type watchFeedPage struct {
+	Data       []watches.FeedItem `json:"data"`
+	NextCursor string             `json:"next_cursor"` // Empty on the last page.
+}
+
+// readWatch reads a watch from the body of the request.
+func readWatch(r *http.Request) (watches.Watch, error) {
+	var w watches.Watch
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return w, err
+	}
+	err2 := json.Unmarshal(body, &w)
+	if err2 != nil {
+		return w, errors.New(fmt.Sprintf("The watch could not be parsed. Error: %s", err2))
+	}
+	return w, nil
+}
+
+// WatchesHandler responds to GET with the watches of the local user, and watches a thread or a board on POST. Body: {"type": "thread" | "board", "target"}
+func WatchesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		ws, err := watches.List()
+		respondToFrontendCommand(w, ws, err)
+	case "POST":
+		watch, err := readWatch(r)
+		if err == nil {
+			err = watches.Add(watch)
+		}
+		respondToFrontendCommand(w, map[string]string{"status": "ok"}, err)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// WatchesRemoveHandler responds to POST by no longer watching the thread or the board. Body: {"type", "target"}
+func WatchesRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) || r.Method != "POST" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	watch, err := readWatch(r)
+	if err != nil {
+		respondToFrontendCommand(w, nil, err)
+		return
+	}
+	removed, err2 := watches.Remove(watch.Type, watch.Target)
+	respondToFrontendCommand(w, map[string]int64{"removed": removed}, err2)
+}
+
+// WatchFeedHandler responds to GET with a page of the posts in the watched threads and the threads in the watched boards, newest first. The content the user muted is left out, or marked to be collapsed.
+func WatchFeedHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isLoopback(r) || r.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	limit := globals.WatchFeedPageSize
+	if len(q.Get("limit")) > 0 {
+		l, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || l < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+	if limit > globals.WatchFeedMaxPageSize {
+		limit = globals.WatchFeedMaxPageSize
+	}
+	items, next, err2 := watches.Feed(q.Get("cursor"), limit)
+	if err2 != nil {
+		logging.Log(2, errors.New(fmt.Sprintf("The watch feed could not be read. Error: %s", err2)))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	jsonResp, err3 := json.Marshal(watchFeedPage{Data: filterFeed(items), NextCursor: next})
+	if err3 != nil {
+		logging.Log(1, errors.New(fmt.Sprintf("The watch feed could not be converted to JSON. Error: %s", err3)))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonResp)
+}
+
+// filterFeed leaves out the items of the feed the user muted, and marks the ones to be collapsed.
+func filterFeed(items []watches.FeedItem) []watches.FeedItem {
+	result := []watches.FeedItem{}
+	if len(items) == 0 {
+		return result
+	}
+	set := loadContentFilters()
+	for i, _ := range items {
+		var action string
+		if p := items[i].Post; p != nil {
+			action = set.Match(p.Board, p.Owner, p.Body)
+		} else if t := items[i].Thread; t != nil {
+			action = set.Match(t.Board, t.Owner, t.Name, t.Body, t.Link)
+		}
+		switch action {
+		case contentfilters.ActionHide:
+			continue
+		case contentfilters.ActionCollapse:
+			items[i].Collapsed = true
+		}
+		result = append(result, items[i])
+	}
+	return result
+}
@@ -0,0 +1,376 @@
+// Backend > Setup
+// This package drives the first run of a node: choosing the data directory, generating the identity, picking the network, the serving mode and the boards to subscribe to, and testing whether the node can be reached. The steps are taken through the frontend API, and every one of them gives the whole state of the setup back, so that the GUI and the CLI can both drive it, and pick up where it was left if it is interrupted. The state is kept in the user directory.
+
+package setup
+
+import (
+	"aether-core/backend/migration"
+	"aether-core/backend/orphans"
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/configstore"
+	"aether-core/services/fingerprinting"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"aether-core/services/peerclients"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The steps of the setup, in the order they are taken.
+const (
+	StepDataDirectory = "data_directory"
+	StepIdentity      = "identity"
+	StepNetwork       = "network"
+	StepServingMode   = "serving_mode"
+	StepSubscriptions = "subscriptions"
+	StepReachability  = "reachability"
+)
+
+var steps = []string{StepDataDirectory, StepIdentity, StepNetwork, StepServingMode, StepSubscriptions, StepReachability}
+
+// The networks a node can be in.
+const (
+	NetworkPublic  = "public"
+	NetworkPrivate = "private"
+)
+
+// A remote that reached the node within this long counts as proof that it can be reached.
+const recentRemoteWindow = 3600
+
+const maxNetworkIdLength = 64
+
+// Step is a step of the setup, and whether it is done.
+type Step struct {
+	Name string `json:"name"`
+	Done bool   `json:"done"`
+}
+
+// Reachability is the outcome of the last test of whether the node can be reached.
+type Reachability struct {
+	Listening     []string `json:"listening"`   // The addresses the server is bound to.
+	Accepting     bool     `json:"accepting"`   // Whether the server accepts connections on at least one of them.
+	ExternalIp    string   `json:"external_ip"` // As the router gave it, if the port could be mapped.
+	Port          uint16   `json:"port"`
+	RecentRemotes int      `json:"recent_remotes"` // The remotes that reached the node in the last hour.
+	Reachable     bool     `json:"reachable"`
+	Details       string   `json:"details,omitempty"`
+	CheckedAt     int64    `json:"checked_at"`
+}
+
+// State is the whole state of the setup.
+type State struct {
+	Completed       bool              `json:"completed"`
+	Steps           []Step            `json:"steps"`
+	Next            string            `json:"next"`             // The first step that isn't done, empty if all of them are.
+	RestartRequired bool              `json:"restart_required"` // Some of the choices take effect only when the node is started again.
+	DataDirectory   string            `json:"data_directory"`
+	NodeId          string            `json:"node_id"`
+	Network         string            `json:"network"`
+	NetworkId       string            `json:"network_id"`
+	ServingMode     string            `json:"serving_mode"`
+	Subscriptions   []api.Fingerprint `json:"subscriptions"`
+	Reachability    *Reachability     `json:"reachability"`
+}
+
+// saved is what is kept in the setup file. The rest of the state comes from the node itself.
+type saved struct {
+	Done          map[string]bool   `json:"done"`
+	Completed     bool              `json:"completed"`
+	DataDirectory string            `json:"data_directory"`
+	Network       string            `json:"network"`
+	NetworkId     string            `json:"network_id"`
+	Subscriptions []api.Fingerprint `json:"subscriptions"`
+	Reachability  *Reachability     `json:"reachability"`
+}
+
+var lock sync.Mutex
+
+func setupPath(dir string) string {
+	return fmt.Sprint(dir, "/setup.json")
+}
+
+func load() (saved, error) {
+	s := saved{Done: make(map[string]bool)}
+	data, err := ioutil.ReadFile(setupPath(globals.UserDirectory))
+	if err != nil && os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return s, err
+	}
+	err2 := json.Unmarshal(data, &s)
+	if err2 != nil {
+		return s, errors.New(fmt.Sprintf("The setup file could not be read. Error: %s", err2))
+	}
+	if s.Done == nil {
+		s.Done = make(map[string]bool)
+	}
+	return s, nil
+}
+
+func save(dir string, s saved) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(dir, 0755)
+	tmp := fmt.Sprint(setupPath(dir), ".tmp")
+	err2 := ioutil.WriteFile(tmp, data, 0644)
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The setup file could not be written. Error: %s", err2))
+	}
+	return os.Rename(tmp, setupPath(dir))
+}
+
+// movePending checks whether the node still has to be started in the data directory that was chosen for it.
+func movePending(s saved) bool {
+	return len(s.DataDirectory) > 0 && s.DataDirectory != globals.UserDirectory
+}
+
+func state(s saved) State {
+	st := State{
+		Completed:     s.Completed,
+		DataDirectory: globals.UserDirectory,
+		Network:       s.Network,
+		NetworkId:     s.NetworkId,
+		ServingMode:   globals.ServingMode,
+		Subscriptions: s.Subscriptions,
+		Reachability:  s.Reachability,
+	}
+	if movePending(s) {
+		st.DataDirectory = s.DataDirectory
+		st.RestartRequired = true
+	}
+	if s.Done[StepNetwork] && s.NetworkId != globals.NetworkId {
+		st.RestartRequired = true
+	}
+	hasIdentity, err := migration.CheckIdentity()
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The saved identity could not be checked. Error: %s", err))
+	}
+	if hasIdentity && err == nil {
+		st.NodeId = globals.NodeId
+	}
+	for _, name := range steps {
+		done := s.Done[name]
+		if name == StepIdentity {
+			// An identity restored from another machine counts too.
+			done = len(st.NodeId) > 0
+		}
+		st.Steps = append(st.Steps, Step{Name: name, Done: done})
+		if !done && len(st.Next) == 0 {
+			st.Next = name
+		}
+	}
+	if st.Subscriptions == nil {
+		st.Subscriptions = []api.Fingerprint{}
+	}
+	return st
+}
+
+// takeStep runs a step on the saved state, and saves it if the step succeeds. The steps after the data directory can't be taken until the node is started in the directory chosen, since what they save would be left behind in the old one.
+func takeStep(name string, step func(s *saved) error) (State, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	s, err := load()
+	if err != nil {
+		return State{}, err
+	}
+	if name != StepDataDirectory && movePending(s) {
+		return state(s), errors.New(fmt.Sprintf("The node has to be started in the data directory chosen before the setup can go on. Data directory: %s", s.DataDirectory))
+	}
+	err2 := step(&s)
+	if err2 != nil {
+		return state(s), err2
+	}
+	if len(name) > 0 {
+		s.Done[name] = true
+	}
+	err3 := save(globals.UserDirectory, s)
+	if err3 != nil {
+		return state(s), err3
+	}
+	return state(s), nil
+}
+
+// GetState gives the state of the setup.
+func GetState() (State, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	s, err := load()
+	if err != nil {
+		return State{}, err
+	}
+	return state(s), nil
+}
+
+// LoadSubscriptions makes the boards the user subscribed to in the setup known to the rest of the node, such as to the vote sync policies. It is called at start.
+func LoadSubscriptions() error {
+	lock.Lock()
+	defer lock.Unlock()
+	s, err := load()
+	if err != nil {
+		return err
+	}
+	setSubscribedBoards(s.Subscriptions)
+	return nil
+}
+
+func setSubscribedBoards(boards []api.Fingerprint) {
+	subscribed := []string{}
+	for _, fp := range boards {
+		subscribed = append(subscribed, string(fp))
+	}
+	globals.SubscribedBoards = subscribed
+}
+
+// ChooseDataDirectory chooses the directory the node keeps its data in. Empty keeps the current one. A different one has to exist or be creatable, and be writable; the setup is carried over into it, and the node has to be started again with it as its user directory (with the AETHER_USER_DIRECTORY environment variable) for the setup to go on.
+func ChooseDataDirectory(dir string) (State, error) {
+	return takeStep(StepDataDirectory, func(s *saved) error {
+		if len(dir) == 0 {
+			s.DataDirectory = globals.UserDirectory
+			return nil
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return errors.New(fmt.Sprintf("The data directory is not a valid path. Path: %s, Error: %s", dir, err))
+		}
+		err2 := os.MkdirAll(abs, 0755)
+		if err2 != nil {
+			return errors.New(fmt.Sprintf("The data directory could not be created. Path: %s, Error: %s", abs, err2))
+		}
+		probe := fmt.Sprint(abs, "/.aether-write-test")
+		err3 := ioutil.WriteFile(probe, []byte{}, 0644)
+		if err3 != nil {
+			return errors.New(fmt.Sprintf("The data directory is not writable. Path: %s, Error: %s", abs, err3))
+		}
+		os.Remove(probe)
+		s.DataDirectory = abs
+		if abs == globals.UserDirectory {
+			return nil
+		}
+		carried := *s
+		carried.Done = map[string]bool{StepDataDirectory: true}
+		return save(abs, carried)
+	})
+}
+
+// GenerateIdentity generates a new key for the node, with the node id that comes from it, and saves it. If the node already has a saved identity, it is kept, unless replace is given.
+func GenerateIdentity(replace bool) (State, error) {
+	return takeStep(StepIdentity, func(s *saved) error {
+		hasIdentity, _ := migration.CheckIdentity()
+		if hasIdentity && !replace {
+			return errors.New("The node already has an identity. It is replaced only if asked for.")
+		}
+		globals.GenerateUserKeyPair()
+		globals.NodeId = fingerprinting.Create(globals.MarshaledPubKey)
+		return migration.SaveIdentity()
+	})
+}
+
+// ChooseNetwork puts the node in the public network, or in a private one with the given id and, optionally, membership key. The network is written into the config file and into the saved identity, and takes effect when the node is started again.
+func ChooseNetwork(network string, networkId string, membershipKey string) (State, error) {
+	return takeStep(StepNetwork, func(s *saved) error {
+		switch network {
+		case NetworkPublic:
+			networkId, membershipKey = "", ""
+		case NetworkPrivate:
+			if len(networkId) == 0 || len(networkId) > maxNetworkIdLength {
+				return errors.New(fmt.Sprintf("A private network needs an id of 1 to %d characters.", maxNetworkIdLength))
+			}
+		default:
+			return errors.New(fmt.Sprintf("The network has to be public or private. Network: %s", network))
+		}
+		idJson, _ := json.Marshal(networkId)
+		keyJson, _ := json.Marshal(membershipKey)
+		_, err := configstore.Update(map[string]json.RawMessage{
+			"network_id":             idJson,
+			"network_membership_key": keyJson,
+		})
+		if err != nil {
+			return err
+		}
+		err2 := migration.SetIdentityNetwork(networkId, membershipKey)
+		if err2 != nil {
+			return err2
+		}
+		s.Network = network
+		s.NetworkId = networkId
+		return nil
+	})
+}
+
+// ChooseServingMode sets the serving mode of the node, full or light. It takes effect right away.
+func ChooseServingMode(mode string) (State, error) {
+	return takeStep(StepServingMode, func(s *saved) error {
+		modeJson, _ := json.Marshal(mode)
+		_, err := configstore.Update(map[string]json.RawMessage{"serving_mode": modeJson})
+		return err
+	})
+}
+
+// ChooseSubscriptions subscribes the user to the boards, by their fingerprints. The boards are asked for from the remotes with the next fetch of the missing parents, so that they are there by the time the setup is done, even if they are older than the first sync reaches.
+func ChooseSubscriptions(boards []api.Fingerprint) (State, error) {
+	return takeStep(StepSubscriptions, func(s *saved) error {
+		for _, fp := range boards {
+			if _, err := hex.DecodeString(string(fp)); err != nil || len(fp) != 64 {
+				return errors.New(fmt.Sprintf("This is not the fingerprint of a board. Fingerprint: %s", fp))
+			}
+		}
+		for _, fp := range boards {
+			err := orphans.Want("boards", fp)
+			if err != nil {
+				logging.Log(1, fmt.Sprintf("A board subscribed to could not be asked for. It will arrive with the syncs. Board: %s, Error: %s", fp, err))
+			}
+		}
+		s.Subscriptions = boards
+		setSubscribedBoards(boards)
+		return nil
+	})
+}
+
+// CheckReachability tests whether the node can be reached, from the addresses the server is bound to and whether it accepts connections on them, which the server gives, and from what is known of the outside: the external IP the router gave, and the remotes that reached the node recently. The node counts as reachable if it accepts connections, and either a remote reached it in the last hour, or the router forwards its port. The step is done once the test is run, even if the node can't be reached, since it can still sync.
+func CheckReachability(listening []string, accepting bool, details string) (State, error) {
+	return takeStep(StepReachability, func(s *saved) error {
+		r := Reachability{
+			Listening:  listening,
+			Accepting:  accepting,
+			ExternalIp: globals.ExternalIp,
+			Port:       globals.AddressPort,
+			Details:    details,
+			CheckedAt:  clock.Unix(),
+		}
+		if r.Listening == nil {
+			r.Listening = []string{}
+		}
+		for _, p := range peerclients.GetReport().Peers {
+			if r.CheckedAt-p.LastSeen < recentRemoteWindow {
+				r.RecentRemotes++
+			}
+		}
+		r.Reachable = r.Accepting && (r.RecentRemotes > 0 || len(r.ExternalIp) > 0)
+		if r.Accepting && !r.Reachable {
+			r.Details = fmt.Sprint(r.Details, "No remote reached the node in the last hour, and the router didn't forward its port. It can still sync, but the others may not be able to sync from it.")
+		}
+		s.Reachability = &r
+		return nil
+	})
+}
+
+// Complete marks the setup as done. All the steps have to be done first.
+func Complete() (State, error) {
+	return takeStep("", func(s *saved) error {
+		st := state(*s)
+		if len(st.Next) > 0 {
+			return errors.New(fmt.Sprintf("The setup can't be completed before all of its steps are done. Next step: %s", st.Next))
+		}
+		s.Completed = true
+		return nil
+	})
+}
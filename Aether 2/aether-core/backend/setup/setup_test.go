@@ -0,0 +1,152 @@
+package setup_test
+
+import (
+	"aether-core/backend/migration"
+	"aether-core/backend/orphans"
+	"aether-core/backend/setup"
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+var tempDir string
+
+func TestMain(m *testing.M) {
+	setupTests()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setupTests() {
+	tempDir, _ = ioutil.TempDir("", "setup")
+}
+
+func teardown() {
+	os.RemoveAll(tempDir)
+}
+
+// reset starts the node afresh in a user directory of its own.
+func reset(t *testing.T, name string) {
+	globals.SetGlobals()
+	globals.UserDirectory = filepath.Join(tempDir, name)
+	os.MkdirAll(globals.UserDirectory, 0755)
+}
+
+// Tests
+
+func TestSetup_Success(t *testing.T) {
+	reset(t, "success")
+	st, err := setup.GetState()
+	if err != nil || st.Next != setup.StepDataDirectory || st.Completed {
+		t.Fatalf("A new node should start at the first step. State: %v, Error: %v", st, err)
+	}
+	if _, err := setup.ChooseDataDirectory(""); err != nil {
+		t.Fatalf("Keeping the current data directory should pass. Error: %s", err)
+	}
+	st, err = setup.GenerateIdentity(false)
+	if err != nil || len(st.NodeId) != 64 || st.NodeId != globals.NodeId {
+		t.Fatalf("The identity should have been generated and saved. State: %v, Error: %v", st, err)
+	}
+	st, err = setup.ChooseNetwork(setup.NetworkPrivate, "friends", "secret")
+	if err != nil || st.NetworkId != "friends" || !st.RestartRequired {
+		t.Fatalf("The private network should have been chosen, to take effect at the next start. State: %v, Error: %v", st, err)
+	}
+	identity, _ := ioutil.ReadFile(filepath.Join(globals.UserDirectory, "identity.json"))
+	if !strings.Contains(string(identity), `"network_id":"friends"`) {
+		t.Errorf("The network should have been written into the saved identity too.")
+	}
+	if _, err := setup.ChooseServingMode("light"); err != nil || globals.ServingMode != "light" {
+		t.Fatalf("The serving mode should have been applied right away. Error: %v", err)
+	}
+	board := api.Fingerprint(strings.Repeat("ab", 32))
+	if _, err := setup.ChooseSubscriptions([]api.Fingerprint{board}); err != nil || !orphans.IsWanted(board) {
+		t.Fatalf("The board subscribed to should have been asked for. Error: %v", err)
+	}
+	if _, err := setup.Complete(); err == nil {
+		t.Errorf("The setup should not complete before all of its steps are done.")
+	}
+	st, err = setup.CheckReachability([]string{"127.0.0.1:23420"}, true, "")
+	if err != nil || st.Reachability == nil || st.Reachability.Reachable || len(st.Reachability.Details) == 0 {
+		t.Fatalf("A node that nobody reached, and whose port isn't forwarded, should not count as reachable. State: %v, Error: %v", st, err)
+	}
+	st, err = setup.Complete()
+	if err != nil || !st.Completed || len(st.Next) > 0 {
+		t.Fatalf("The setup should have completed. State: %v, Error: %v", st, err)
+	}
+	// The state survives a restart.
+	nodeId := globals.NodeId
+	dir := globals.UserDirectory
+	globals.SetGlobals()
+	globals.UserDirectory = dir
+	migration.LoadIdentity()
+	st, _ = setup.GetState()
+	if !st.Completed || len(st.Subscriptions) != 1 || st.Network != setup.NetworkPrivate {
+		t.Errorf("The state of the setup should have been read back. State: %v", st)
+	}
+	if st.NodeId != nodeId || globals.NetworkId != "friends" {
+		t.Errorf("The node should have started with the identity generated, in the network chosen. Node id: %s", st.NodeId)
+	}
+}
+
+func TestSetup_Fail_Steps(t *testing.T) {
+	reset(t, "fail")
+	if _, err := setup.ChooseNetwork("secret", "", ""); err == nil {
+		t.Errorf("A network that is neither public nor private should be refused.")
+	}
+	if _, err := setup.ChooseNetwork(setup.NetworkPrivate, "", ""); err == nil {
+		t.Errorf("A private network without an id should be refused.")
+	}
+	if _, err := setup.ChooseServingMode("heavy"); err == nil || globals.ServingMode != "full" {
+		t.Errorf("A serving mode that is neither full nor light should be refused.")
+	}
+	if _, err := setup.ChooseSubscriptions([]api.Fingerprint{"not a board"}); err == nil {
+		t.Errorf("A board that is not a fingerprint should be refused.")
+	}
+	if _, err := setup.GenerateIdentity(false); err != nil {
+		t.Fatalf("The identity should have been generated. Error: %s", err)
+	}
+	nodeId := globals.NodeId
+	if _, err := setup.GenerateIdentity(false); err == nil || globals.NodeId != nodeId {
+		t.Errorf("An identity that exists should not be replaced unless asked for.")
+	}
+	if st, err := setup.GenerateIdentity(true); err != nil || st.NodeId == nodeId {
+		t.Errorf("The identity should have been replaced when asked for. Error: %v", err)
+	}
+	st, _ := setup.GetState()
+	for _, s := range st.Steps {
+		if s.Done != (s.Name == setup.StepIdentity) {
+			t.Errorf("Only the identity should be done, since the other steps failed. Step: %s", s.Name)
+		}
+	}
+}
+
+func TestSetup_Fail_DataDirectoryMoved(t *testing.T) {
+	reset(t, "moved")
+	newDir := filepath.Join(tempDir, "moved-new")
+	st, err := setup.ChooseDataDirectory(newDir)
+	if err != nil || !st.RestartRequired || st.DataDirectory != newDir {
+		t.Fatalf("The new data directory should have been chosen, to be used at the next start. State: %v, Error: %v", st, err)
+	}
+	if _, err := setup.GenerateIdentity(false); err == nil {
+		t.Errorf("The setup should not go on before the node is started in the new data directory.")
+	}
+	// Started again in the new directory, the setup goes on from there.
+	globals.SetGlobals()
+	globals.UserDirectory = newDir
+	st, _ = setup.GetState()
+	if st.RestartRequired || st.Next != setup.StepIdentity {
+		t.Errorf("The setup should go on in the new data directory. State: %v", st)
+	}
+	blocker := filepath.Join(tempDir, "moved-file")
+	ioutil.WriteFile(blocker, []byte("x"), 0644)
+	if _, err := setup.ChooseDataDirectory(filepath.Join(blocker, "sub")); err == nil {
+		t.Errorf("A data directory that can't be created should be refused.")
+	}
+}
@@ -0,0 +1,56 @@
+// Aether Simnet
+// Runs a network of local nodes through a scenario, and prints how the content created on them spread. See the "Network simulator" section of the README.
+
+package main
+
+import (
+	"aether-core/backend/simnet"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	scenarioPtr := flag.String("scenario", "steady", fmt.Sprintf("The scenario to run: one of %s, or the path of a scenario file in JSON.", strings.Join(simnet.ScenarioNames(), ", ")))
+	binaryPtr := flag.String("binary", "", "The backend binary the nodes run.")
+	mysqlPtr := flag.String("mysql", "root:@/", "The database server the nodes keep their databases on, as a data source name without a database.")
+	workDirPtr := flag.String("workdir", "", "Where the user directories of the nodes are created. A temporary directory if not given.")
+	basePortPtr := flag.Uint("base-port", 49000, "The nodes listen on the ports from this up, and the byzantine peers on the ports after them.")
+	outPtr := flag.String("out", "", "Also writes the results as JSON into the given file.")
+	keepPtr := flag.Bool("keep", false, "Keeps the user directories and the databases of the nodes after the run, to look into them.")
+	flag.Parse()
+	s, err := simnet.LoadScenario(*scenarioPtr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	opts := simnet.Options{
+		Binary:   *binaryPtr,
+		MySQL:    *mysqlPtr,
+		WorkDir:  *workDirPtr,
+		BasePort: uint16(*basePortPtr),
+		Keep:     *keepPtr,
+		Log: func(msg string) {
+			fmt.Printf("%s %s\n", time.Now().Format("15:04:05"), msg)
+		},
+	}
+	r, err2 := simnet.Run(s, opts)
+	if err2 != nil {
+		fmt.Fprintln(os.Stderr, err2)
+		os.Exit(1)
+	}
+	fmt.Println()
+	fmt.Print(r.String())
+	if len(*outPtr) > 0 {
+		data, _ := json.MarshalIndent(r, "", "  ")
+		err3 := ioutil.WriteFile(*outPtr, data, 0644)
+		if err3 != nil {
+			fmt.Fprintln(os.Stderr, err3)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Backend > Simnet > Byzantine
+// This file implements the byzantine peers of the simulated network. A byzantine peer answers the status and node requests like a node would, so the honest nodes go on to sync with it, and then misbehaves in what it serves.
+
+package simnet
+
+import (
+	"aether-core/io/api"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// forgedPerPage is how many forged posts a forged page has.
+const forgedPerPage = 5
+
+// stallInterval is how long a stalling peer waits between the bytes it sends.
+const stallInterval = 5 * time.Second
+
+// Byzantine is a byzantine peer.
+type Byzantine struct {
+	Behavior  string
+	nodeId    api.Fingerprint
+	networkId string
+	listener  net.Listener
+	server    *http.Server
+	lock      sync.Mutex
+	board     api.Fingerprint
+	thread    api.Fingerprint
+	forged    []api.Fingerprint
+	requests  int
+}
+
+// NewByzantine starts a byzantine peer with the given behaviour on a port of the loopback interface. It claims to be in the given network, since the nodes don't sync with the nodes of other networks.
+func NewByzantine(behavior string, networkId string, port uint16) (*Byzantine, error) {
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, err
+	}
+	b := &Byzantine{Behavior: behavior, nodeId: api.Fingerprint(randomHex(32)), networkId: networkId, listener: l}
+	b.server = &http.Server{Handler: b}
+	go b.server.Serve(l)
+	return b, nil
+}
+
+// Port is the port the byzantine peer listens on.
+func (b *Byzantine) Port() uint16 {
+	return uint16(b.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Address is the address of the byzantine peer, as the honest nodes are given it.
+func (b *Byzantine) Address() api.Address {
+	return loopbackAddress(b.Port())
+}
+
+// Target sets where the forged posts are posted to, so that they look like they belong to the content of the network.
+func (b *Byzantine) Target(board api.Fingerprint, thread api.Fingerprint) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.board = board
+	b.thread = thread
+}
+
+// Forged gives the fingerprints of the forged posts the peer has served.
+func (b *Byzantine) Forged() []api.Fingerprint {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]api.Fingerprint{}, b.forged...)
+}
+
+// Requests gives how many requests for entities the peer has been sent.
+func (b *Byzantine) Requests() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.requests
+}
+
+// Close stops the byzantine peer.
+func (b *Byzantine) Close() {
+	b.server.Close()
+}
+
+// forgePosts makes up posts whose signatures, proofs of work and fingerprints are random, and remembers their fingerprints.
+func (b *Byzantine) forgePosts() []api.Post {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var posts []api.Post
+	for i := 0; i < forgedPerPage; i++ {
+		var p api.Post
+		p.Fingerprint = api.Fingerprint(randomHex(32))
+		p.Board = b.board
+		p.Thread = b.thread
+		p.Parent = b.thread
+		p.Body = "A forged post."
+		p.Owner = api.Fingerprint(randomHex(32))
+		p.Creation = api.Timestamp(time.Now().Unix())
+		p.Signature = api.Signature(randomHex(64))
+		p.ProofOfWork = api.ProofOfWork(randomHex(32))
+		posts = append(posts, p)
+		b.forged = append(b.forged, p.Fingerprint)
+	}
+	return posts
+}
+
+func (b *Byzantine) baseResponse() api.ApiResponse {
+	var resp api.ApiResponse
+	resp.NodeId = b.nodeId
+	resp.NetworkId = b.networkId
+	resp.Address = b.Address()
+	resp.Timestamp = api.Timestamp(time.Now().Unix())
+	return resp
+}
+
+func writeJson(w http.ResponseWriter, resp api.ApiResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	data, _ := json.Marshal(resp)
+	w.Write(data)
+}
+
+// ServeHTTP answers the requests of the honest nodes.
+func (b *Byzantine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v0/"), "/")
+	// The peer has to look alive and like a node, or nothing else is asked of it.
+	if path == "status" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if path == "node" || path == "peers" {
+		writeJson(w, b.baseResponse())
+		return
+	}
+	b.lock.Lock()
+	b.requests++
+	b.lock.Unlock()
+	switch b.Behavior {
+	case ByzantineGarbage:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"node_id": "` + string(b.nodeId) + `", "response": {"posts": [{"fingerprint": `))
+	case ByzantineStall:
+		b.stall(w, r)
+	default:
+		b.serveForged(w, r, path)
+	}
+}
+
+// serveForged serves an index that links to a single cache, and a cache page, or a POST response, with forged posts in it. The other entity types are served empty.
+func (b *Byzantine) serveForged(w http.ResponseWriter, r *http.Request, path string) {
+	resp := b.baseResponse()
+	parts := strings.Split(path, "/")
+	if len(parts) == 2 && parts[1] == "index.json" {
+		resp.Results = []api.ResultCache{{ResponseUrl: "cache_0", StartsFrom: 0, EndsAt: api.Timestamp(time.Now().Unix())}}
+		writeJson(w, resp)
+		return
+	}
+	resp.Pagination = api.Pagination{Pages: 1, CurrentPage: 0}
+	if parts[0] == "posts" {
+		resp.ResponseBody.Posts = b.forgePosts()
+	}
+	writeJson(w, resp)
+}
+
+// stall sends a valid start of a response, and then the rest of it a byte at a time, until the node gives up or the peer is closed.
+func (b *Byzantine) stall(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	body := []byte(`{"node_id": "` + string(b.nodeId) + `", "response": {"posts": []}}`)
+	for i, _ := range body {
+		_, err := w.Write(body[i : i+1])
+		if err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-time.After(stallInterval):
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Backend > Simnet > Content
+// This file creates what the users of the simulated network post. The content is created and signed in the simulator, with a key of its own, and given to a node as if its user had written it.
+
+package simnet
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/create"
+	"aether-core/services/globals"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+// Author creates the content of the simulated users. There is a single key for all of them, since the nodes don't treat the content of different users differently while syncing.
+type Author struct {
+	lock    sync.Mutex
+	Key     api.Key
+	Board   api.Board
+	Threads []api.Thread
+	Posts   []api.Post
+	counter int
+}
+
+// NewAuthor creates the key of the simulated users, and the board the content goes into. It replaces the key pair in the globals, which is what the content is signed with.
+func NewAuthor() (*Author, error) {
+	a := &Author{}
+	globals.GenerateUserKeyPair()
+	key, err := create.CreateKey("", globals.MarshaledPubKey, "simnet", []api.CurrencyAddress{}, "The user of the simulated network.")
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("The key of the simulated users could not be created. Error: %s", err))
+	}
+	a.Key = key
+	board, err2 := create.CreateBoard("simnet", key.Fingerprint, []api.BoardOwner{}, "The board of the simulated network.")
+	if err2 != nil {
+		return nil, errors.New(fmt.Sprintf("The board of the simulated network could not be created. Error: %s", err2))
+	}
+	a.Board = board
+	return a, nil
+}
+
+func (a *Author) next() int {
+	a.counter++
+	return a.counter
+}
+
+// Thread creates a thread in the board.
+func (a *Author) Thread() (api.Thread, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	n := a.next()
+	t, err := create.CreateThread(a.Board.Fingerprint, fmt.Sprintf("Thread %d", n), fmt.Sprintf("The body of thread %d.", n), "", a.Key.Fingerprint)
+	if err != nil {
+		return t, err
+	}
+	a.Threads = append(a.Threads, t)
+	return t, nil
+}
+
+// Post creates a post in a random thread, in reply to the thread or to one of the earlier posts in it.
+func (a *Author) Post(r *rand.Rand) (api.Post, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if len(a.Threads) == 0 {
+		return api.Post{}, errors.New("There are no threads to post in.")
+	}
+	thread := a.Threads[r.Intn(len(a.Threads))]
+	parent := thread.Fingerprint
+	var siblings []api.Post
+	for i, _ := range a.Posts {
+		if a.Posts[i].Thread == thread.Fingerprint {
+			siblings = append(siblings, a.Posts[i])
+		}
+	}
+	if len(siblings) > 0 && r.Intn(2) == 0 {
+		parent = siblings[r.Intn(len(siblings))].Fingerprint
+	}
+	n := a.next()
+	p, err := create.CreatePost(a.Board.Fingerprint, thread.Fingerprint, parent, fmt.Sprintf("Post %d.", n), a.Key.Fingerprint)
+	if err != nil {
+		return p, err
+	}
+	a.Posts = append(a.Posts, p)
+	return p, nil
+}
+
+// Vote creates an upvote or a downvote on a random post.
+func (a *Author) Vote(r *rand.Rand) (api.Vote, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if len(a.Posts) == 0 {
+		return api.Vote{}, errors.New("There are no posts to vote on.")
+	}
+	target := a.Posts[r.Intn(len(a.Posts))]
+	voteType := uint8(1)
+	if r.Intn(4) == 0 {
+		voteType = 2
+	}
+	return create.CreateVote(a.Board.Fingerprint, target.Thread, target.Fingerprint, a.Key.Fingerprint, voteType)
+}
@@ -0,0 +1,302 @@
+// Backend > Simnet > Node
+// This file runs the nodes of the simulated network. Every node is a process of the backend binary, with its own user directory, database and port, and talks to the others over the loopback interface exactly like it would over the network. The simulator only uses the admin endpoints of a node: to give it content and peers, and to ask it what has arrived. It doesn't import the persistence package, since that connects to the database of a node as soon as it is loaded.
+
+package simnet
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"aether-core/services/signaturing"
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readyTimeout is how long a node is given to start answering after its process is started.
+const readyTimeout = 60 * time.Second
+
+// nodeIdentity is the identity file of a node, in the form the migration package reads it at the start. Giving every node its own identity gives it its own node id and key.
+type nodeIdentity struct {
+	NodeId     string `json:"node_id"`
+	PrivateKey string `json:"private_key"`
+	NetworkId  string `json:"network_id"`
+}
+
+// Node is a node of the simulated network.
+type Node struct {
+	Index    int
+	Dir      string // The user directory.
+	Database string
+	Port     uint16
+	lock     sync.Mutex
+	cmd      *exec.Cmd
+	exited   chan bool
+	logFile  *os.File
+	Restarts int
+}
+
+// Online checks whether the process of the node is running.
+func (n *Node) Online() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.cmd != nil
+}
+
+// Address is the address of the node, as the other nodes are given it.
+func (n *Node) Address() api.Address {
+	return loopbackAddress(n.Port)
+}
+
+func loopbackAddress(port uint16) api.Address {
+	var a api.Address
+	a.Location = "127.0.0.1"
+	a.LocationType = api.LocationTypeIPv4
+	a.Port = port
+	a.Type = 2 // Live.
+	a.LastOnline = api.Timestamp(time.Now().Unix())
+	a.Protocol.VersionMajor = 0
+	a.Protocol.VersionMinor = 1
+	a.Protocol.Extensions = []string{"aether"}
+	a.Client.ClientName = "aether-simnet"
+	return a
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// prepare creates the user directory of the node, with its config file and its identity, and an empty database for it.
+func (n *Node) prepare(mysql string, networkId string) error {
+	err := os.MkdirAll(n.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	config := map[string]interface{}{
+		"listeners":             []globals.Listener{{Name: "simnet", Interface: "127.0.0.1", PortStart: n.Port, PortEnd: n.Port, Advertise: true}},
+		"network_id":            networkId,
+		"lan_discovery_enabled": false,
+		"logging_level":         1,
+	}
+	configJson, _ := json.MarshalIndent(config, "", "  ")
+	err2 := ioutil.WriteFile(filepath.Join(n.Dir, "config.json"), configJson, 0644)
+	if err2 != nil {
+		return err2
+	}
+	key, err3 := signaturing.CreateKeyPair()
+	if err3 != nil {
+		return err3
+	}
+	der, err4 := x509.MarshalECPrivateKey(key)
+	if err4 != nil {
+		return err4
+	}
+	// The remotes only take the requests of the nodes whose ids are 64 characters long.
+	id := nodeIdentity{NodeId: randomHex(32), PrivateKey: hex.EncodeToString(der), NetworkId: networkId}
+	idJson, _ := json.MarshalIndent(id, "", "  ")
+	err5 := ioutil.WriteFile(filepath.Join(n.Dir, "identity.json"), idJson, 0600)
+	if err5 != nil {
+		return err5
+	}
+	return recreateDatabase(mysql, n.Database)
+}
+
+// recreateDatabase drops the database if it is left over from an earlier run, and creates it empty. The node creates the tables at the start.
+func recreateDatabase(mysql string, name string) error {
+	db, err := sqlx.Connect("mysql", mysql)
+	if err != nil {
+		return errors.New(fmt.Sprintf("The database server could not be connected to. Error: %s", err))
+	}
+	defer db.Close()
+	_, err2 := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", name))
+	if err2 != nil {
+		return err2
+	}
+	_, err3 := db.Exec(fmt.Sprintf("CREATE DATABASE %s;", name))
+	return err3
+}
+
+func dropDatabase(mysql string, name string) error {
+	db, err := sqlx.Connect("mysql", mysql)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err2 := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", name))
+	return err2
+}
+
+// databaseSource gives the data source name of the database of the node, on the database server given to the simulator.
+func databaseSource(mysql string, name string) string {
+	return strings.TrimSuffix(mysql, "/") + "/" + name
+}
+
+// Start starts the process of the node, and waits until it answers.
+func (n *Node) Start(binary string, mysql string) error {
+	n.lock.Lock()
+	if n.cmd != nil {
+		n.lock.Unlock()
+		return nil
+	}
+	logFile, err := os.OpenFile(filepath.Join(n.Dir, "node.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		n.lock.Unlock()
+		return err
+	}
+	cmd := exec.Command(binary)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprint(globals.UserDirectoryEnv, "=", n.Dir),
+		fmt.Sprint(globals.DatabaseEnv, "=", databaseSource(mysql, n.Database)))
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	err2 := cmd.Start()
+	if err2 != nil {
+		logFile.Close()
+		n.lock.Unlock()
+		return errors.New(fmt.Sprintf("The node could not be started. Node: %d, Error: %s", n.Index, err2))
+	}
+	n.cmd = cmd
+	n.logFile = logFile
+	n.exited = make(chan bool)
+	exited := n.exited
+	go func() {
+		cmd.Wait()
+		n.lock.Lock()
+		if n.cmd == cmd {
+			n.cmd = nil
+			n.logFile.Close()
+		}
+		n.lock.Unlock()
+		close(exited)
+	}()
+	n.lock.Unlock()
+	deadline := time.Now().Add(readyTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-exited:
+			return errors.New(fmt.Sprintf("The node exited while starting. See %s. Node: %d", filepath.Join(n.Dir, "node.log"), n.Index))
+		case <-time.After(500 * time.Millisecond):
+		}
+		resp, err3 := http.Get(fmt.Sprintf("http://127.0.0.1:%d/health", n.Port))
+		if err3 == nil {
+			resp.Body.Close()
+			return nil
+		}
+	}
+	n.Stop()
+	return errors.New(fmt.Sprintf("The node did not start answering in time. Node: %d, Waited: %s", n.Index, readyTimeout))
+}
+
+// Stop kills the process of the node, as if the machine went offline.
+func (n *Node) Stop() {
+	n.lock.Lock()
+	cmd := n.cmd
+	exited := n.exited
+	n.lock.Unlock()
+	if cmd == nil {
+		return
+	}
+	cmd.Process.Kill()
+	<-exited
+}
+
+func (n *Node) adminUrl(path string) string {
+	return fmt.Sprintf("http://127.0.0.1:%d/admin/%s", n.Port, path)
+}
+
+// Add gives the node entities and addresses, through its admin endpoint. It returns how many of the entities the node accepted.
+func (n *Node) Add(body api.Answer) (int, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	resp, err2 := http.Post(n.adminUrl("entities"), "application/json", bytes.NewReader(b))
+	if err2 != nil {
+		return 0, err2
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New(fmt.Sprintf("The node refused the entities. Node: %d, Status: %d, Response: %s", n.Index, resp.StatusCode, data))
+	}
+	var result struct {
+		Statuses []api.EntityStatus `json:"statuses"`
+	}
+	err3 := json.Unmarshal(data, &result)
+	if err3 != nil {
+		return 0, err3
+	}
+	accepted := 0
+	for _, s := range result.Statuses {
+		if s.Status == api.EntityAccepted {
+			accepted++
+		}
+	}
+	return accepted, nil
+}
+
+// arrivalsPerRequest is how many fingerprints are asked about in one request, to keep the URLs short.
+const arrivalsPerRequest = 200
+
+// Arrivals asks the node which of the entities have arrived, and when.
+func (n *Node) Arrivals(entityType string, fps []api.Fingerprint) (map[api.Fingerprint]api.Timestamp, error) {
+	arrivals := make(map[api.Fingerprint]api.Timestamp)
+	for start := 0; start < len(fps); start += arrivalsPerRequest {
+		end := start + arrivalsPerRequest
+		if end > len(fps) {
+			end = len(fps)
+		}
+		err := n.arrivals(entityType, fps[start:end], arrivals)
+		if err != nil {
+			return arrivals, err
+		}
+	}
+	return arrivals, nil
+}
+
+func (n *Node) arrivals(entityType string, fps []api.Fingerprint, arrivals map[api.Fingerprint]api.Timestamp) error {
+	var strs []string
+	for _, fp := range fps {
+		strs = append(strs, string(fp))
+	}
+	q := url.Values{}
+	q.Set("type", entityType)
+	q.Set("fingerprints", strings.Join(strs, ","))
+	resp, err := http.Get(n.adminUrl("entities") + "?" + q.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("The node could not give the arrivals. Node: %d, Status: %d, Response: %s", n.Index, resp.StatusCode, data))
+	}
+	var result []struct {
+		Fingerprint  api.Fingerprint `json:"fingerprint"`
+		LocalArrival api.Timestamp   `json:"local_arrival"`
+	}
+	err2 := json.Unmarshal(data, &result)
+	if err2 != nil {
+		return err2
+	}
+	for _, a := range result {
+		arrivals[a.Fingerprint] = a.LocalArrival
+	}
+	return nil
+}
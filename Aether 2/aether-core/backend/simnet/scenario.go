@@ -0,0 +1,143 @@
+// Backend > Simnet > Scenario
+// This file defines the scenarios the simulated network is driven through: how many nodes there are, how fast their users post and vote, how often nodes go offline, and how many of the peers misbehave.
+
+package simnet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// Duration is a time.Duration that is written in JSON the way Go writes it, such as "90s" or "10m", like the durations in the config file.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var str string
+	err := json.Unmarshal(b, &str)
+	if err != nil {
+		return err
+	}
+	v, err2 := time.ParseDuration(str)
+	if err2 != nil {
+		return err2
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Byzantine behaviours. A byzantine peer is not a node but a server run by the simulator, which the honest nodes are given as a peer.
+const (
+	ByzantineForged  = "forged"  // Serves posts whose signatures and fingerprints are made up.
+	ByzantineGarbage = "garbage" // Serves pages that are not valid JSON.
+	ByzantineStall   = "stall"   // Sends its responses a byte at a time, slowly enough to hold the connection open.
+)
+
+// Scenario is what the simulated network is driven through.
+type Scenario struct {
+	Name              string   `json:"name"`
+	Nodes             int      `json:"nodes"`
+	PeersPerNode      int      `json:"peers_per_node"` // How many of the other nodes each node is given as peers at the start. 0 gives every node all of them.
+	Duration          Duration `json:"duration"`       // How long the users post and vote.
+	Settle            Duration `json:"settle"`         // How long the network is given after that to converge, before the statistics are taken.
+	Threads           int      `json:"threads"`        // Threads created at the start, which the posts go into.
+	PostsPerMinute    float64  `json:"posts_per_minute"`
+	VotesPerMinute    float64  `json:"votes_per_minute"`
+	ChurnInterval     Duration `json:"churn_interval"` // How often a random node is stopped. 0 is no churn.
+	ChurnDowntime     Duration `json:"churn_downtime"` // How long a stopped node stays offline.
+	ByzantinePeers    int      `json:"byzantine_peers"`
+	ByzantineBehavior string   `json:"byzantine_behavior"`
+	PollInterval      Duration `json:"poll_interval"` // How often the nodes are asked which entities have arrived.
+}
+
+// Scenarios are the scenarios that come with the simulator. Syncs happen once a minute, so a scenario needs minutes, not seconds, to show anything.
+var Scenarios = map[string]Scenario{
+	"steady": {
+		Name: "steady", Nodes: 5, Duration: Duration(10 * time.Minute), Settle: Duration(5 * time.Minute),
+		Threads: 3, PostsPerMinute: 6, VotesPerMinute: 6, PollInterval: Duration(10 * time.Second),
+	},
+	"churn": {
+		Name: "churn", Nodes: 8, PeersPerNode: 3, Duration: Duration(15 * time.Minute), Settle: Duration(5 * time.Minute),
+		Threads: 3, PostsPerMinute: 6, VotesPerMinute: 6, ChurnInterval: Duration(2 * time.Minute), ChurnDowntime: Duration(3 * time.Minute),
+		PollInterval: Duration(10 * time.Second),
+	},
+	"burst": {
+		Name: "burst", Nodes: 5, Duration: Duration(5 * time.Minute), Settle: Duration(10 * time.Minute),
+		Threads: 10, PostsPerMinute: 120, VotesPerMinute: 240, PollInterval: Duration(10 * time.Second),
+	},
+	"byzantine": {
+		Name: "byzantine", Nodes: 5, Duration: Duration(10 * time.Minute), Settle: Duration(5 * time.Minute),
+		Threads: 3, PostsPerMinute: 6, VotesPerMinute: 6, ByzantinePeers: 2, ByzantineBehavior: ByzantineForged,
+		PollInterval: Duration(10 * time.Second),
+	},
+}
+
+// ScenarioNames are the names of the scenarios that come with the simulator, in order.
+func ScenarioNames() []string {
+	var names []string
+	for name, _ := range Scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks that the scenario can be run.
+func (s *Scenario) Validate() error {
+	if s.Nodes < 2 {
+		return errors.New(fmt.Sprintf("A scenario needs at least two nodes. Scenario: %s, Nodes: %d", s.Name, s.Nodes))
+	}
+	if s.PeersPerNode < 0 || s.PeersPerNode >= s.Nodes {
+		return errors.New(fmt.Sprintf("The peers per node have to be fewer than the nodes. Scenario: %s, Peers per node: %d, Nodes: %d", s.Name, s.PeersPerNode, s.Nodes))
+	}
+	if s.Duration <= 0 || s.PollInterval <= 0 {
+		return errors.New(fmt.Sprintf("The duration and the poll interval of a scenario have to be given. Scenario: %s", s.Name))
+	}
+	if s.Threads < 1 && (s.PostsPerMinute > 0 || s.VotesPerMinute > 0) {
+		return errors.New(fmt.Sprintf("A scenario that posts or votes needs at least one thread. Scenario: %s", s.Name))
+	}
+	if s.PostsPerMinute < 0 || s.VotesPerMinute < 0 {
+		return errors.New(fmt.Sprintf("The posting and voting rates can't be negative. Scenario: %s", s.Name))
+	}
+	if s.ChurnInterval > 0 && s.ChurnDowntime <= 0 {
+		return errors.New(fmt.Sprintf("A scenario with churn needs a downtime. Scenario: %s", s.Name))
+	}
+	if s.ByzantinePeers > 0 {
+		switch s.ByzantineBehavior {
+		case ByzantineForged, ByzantineGarbage, ByzantineStall:
+		default:
+			return errors.New(fmt.Sprintf("The byzantine behaviour is not known. Scenario: %s, Behaviour: %s", s.Name, s.ByzantineBehavior))
+		}
+	}
+	return nil
+}
+
+// LoadScenario gives the scenario with the given name, or if there is none, reads it from the JSON file at that path.
+func LoadScenario(nameOrPath string) (Scenario, error) {
+	if s, ok := Scenarios[nameOrPath]; ok {
+		return s, nil
+	}
+	var s Scenario
+	data, err := ioutil.ReadFile(nameOrPath)
+	if err != nil {
+		return s, errors.New(fmt.Sprintf("The scenario is neither one of the built in ones (%v) nor a file that can be read. Error: %s", ScenarioNames(), err))
+	}
+	err2 := json.Unmarshal(data, &s)
+	if err2 != nil {
+		return s, errors.New(fmt.Sprintf("The scenario file could not be parsed. Path: %s, Error: %s", nameOrPath, err2))
+	}
+	if len(s.Name) == 0 {
+		s.Name = nameOrPath
+	}
+	if s.PollInterval == 0 {
+		s.PollInterval = Duration(10 * time.Second)
+	}
+	return s, s.Validate()
+}
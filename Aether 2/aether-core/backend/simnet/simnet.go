@@ -0,0 +1,376 @@
+// Backend > Simnet
+// This package runs a network of local nodes through a scenario, and collects how fast, and how completely, the content created on them spreads to all of them. It is used by the aether-simnet tool.
+
+package simnet
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// entityTypes are the endpoints of the entity types the simulated users create.
+var entityTypes = []string{"keys", "boards", "threads", "posts", "votes"}
+
+// Options are how a scenario is run, as opposed to what it does.
+type Options struct {
+	Binary   string // The backend binary the nodes run.
+	MySQL    string // The data source name of the database server, without a database. The nodes get a database each on it.
+	WorkDir  string // Where the user directories of the nodes are created.
+	BasePort uint16 // The nodes listen on the ports from this up. The byzantine peers listen on the ports after them.
+	Keep     bool   // Keeps the user directories and the databases of the nodes after the run.
+	Log      func(string)
+}
+
+// network is a running simulated network.
+type network struct {
+	scenario  Scenario
+	opts      Options
+	nodes     []*Node
+	byzantine []*Byzantine
+	author    *Author
+	collector *Collector
+	random    *rand.Rand
+	randLock  sync.Mutex
+}
+
+func (n *network) log(format string, args ...interface{}) {
+	if n.opts.Log != nil {
+		n.opts.Log(fmt.Sprintf(format, args...))
+	}
+}
+
+func (n *network) intn(max int) int {
+	n.randLock.Lock()
+	defer n.randLock.Unlock()
+	return n.random.Intn(max)
+}
+
+// Run runs the scenario, and gives its statistics.
+func Run(s Scenario, opts Options) (Results, error) {
+	err := s.Validate()
+	if err != nil {
+		return Results{}, err
+	}
+	if len(opts.Binary) == 0 {
+		return Results{}, errors.New("The backend binary the nodes run has to be given.")
+	}
+	if opts.BasePort == 0 {
+		opts.BasePort = 49000
+	}
+	if len(opts.WorkDir) == 0 {
+		dir, err2 := ioutil.TempDir("", "aether-simnet-")
+		if err2 != nil {
+			return Results{}, err2
+		}
+		opts.WorkDir = dir
+	}
+	// The content is signed with the key pair in the globals, and it has to satisfy the PoW the nodes require.
+	globals.SetGlobals()
+	author, err3 := NewAuthor()
+	if err3 != nil {
+		return Results{}, err3
+	}
+	n := &network{scenario: s, opts: opts, author: author, collector: NewCollector(s.Nodes), random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	defer n.teardown()
+	networkId := fmt.Sprint("simnet-", randomHex(4))
+	for i := 0; i < s.Nodes; i++ {
+		node := &Node{Index: i, Dir: filepath.Join(opts.WorkDir, fmt.Sprint("node-", i)), Database: fmt.Sprint("aether_simnet_", i), Port: opts.BasePort + uint16(i)}
+		err4 := node.prepare(opts.MySQL, networkId)
+		if err4 != nil {
+			return Results{}, errors.New(fmt.Sprintf("The node could not be prepared. Node: %d, Error: %s", i, err4))
+		}
+		n.nodes = append(n.nodes, node)
+	}
+	for i := 0; i < s.ByzantinePeers; i++ {
+		b, err5 := NewByzantine(s.ByzantineBehavior, networkId, opts.BasePort+uint16(s.Nodes+i))
+		if err5 != nil {
+			return Results{}, errors.New(fmt.Sprintf("The byzantine peer could not be started. Error: %s", err5))
+		}
+		n.byzantine = append(n.byzantine, b)
+	}
+	n.log("Starting %d nodes in %s.", s.Nodes, opts.WorkDir)
+	for _, node := range n.nodes {
+		err6 := node.Start(opts.Binary, opts.MySQL)
+		if err6 != nil {
+			return Results{}, err6
+		}
+	}
+	err7 := n.seed()
+	if err7 != nil {
+		return Results{}, err7
+	}
+	start := time.Now()
+	n.drive(time.Duration(s.Duration))
+	n.log("The users stopped. Giving the network %s to settle.", time.Duration(s.Settle))
+	n.settle(time.Duration(s.Settle))
+	r := n.collector.Results()
+	r.Scenario = s.Name
+	r.Elapsed = Duration(time.Since(start))
+	for i, _ := range r.PerNode {
+		r.PerNode[i].Restarts = n.nodes[i].Restarts
+	}
+	if len(n.byzantine) > 0 {
+		r.Byzantine = n.byzantineResults()
+	}
+	return r, nil
+}
+
+// teardown stops the nodes and the byzantine peers, and unless asked to keep them, removes their directories and databases.
+func (n *network) teardown() {
+	for _, b := range n.byzantine {
+		b.Close()
+	}
+	for _, node := range n.nodes {
+		node.Stop()
+	}
+	if n.opts.Keep {
+		n.log("The nodes are kept in %s.", n.opts.WorkDir)
+		return
+	}
+	for _, node := range n.nodes {
+		dropDatabase(n.opts.MySQL, node.Database)
+		os.RemoveAll(node.Dir)
+	}
+}
+
+// add gives the entities to the node, and records them as created on it.
+func (n *network) add(origin int, entityType string, fp api.Fingerprint, body api.Answer) error {
+	accepted, err := n.nodes[origin].Add(body)
+	if err != nil {
+		return err
+	}
+	if accepted == 0 {
+		return errors.New(fmt.Sprintf("The node did not accept the entity. Node: %d, Type: %s, Fingerprint: %s", origin, entityType, fp))
+	}
+	n.collector.Created(Entity{Fingerprint: fp, Type: entityType, Origin: origin, Created: time.Now()})
+	return nil
+}
+
+// seed gives every node its peers, and creates the key, the board and the threads the users post in.
+func (n *network) seed() error {
+	s := n.scenario
+	for i, node := range n.nodes {
+		var addrs []api.Address
+		peers := s.PeersPerNode
+		if peers == 0 {
+			peers = s.Nodes - 1
+		}
+		// The peers of a node are the ones after it, in a ring, so that the network is connected whatever the peers per node.
+		for j := 1; j <= peers; j++ {
+			addrs = append(addrs, n.nodes[(i+j)%s.Nodes].Address())
+		}
+		for _, b := range n.byzantine {
+			addrs = append(addrs, b.Address())
+		}
+		_, err := node.Add(api.Answer{Addresses: addrs})
+		if err != nil {
+			return errors.New(fmt.Sprintf("The peers could not be given to the node. Node: %d, Error: %s", i, err))
+		}
+	}
+	err2 := n.add(0, "keys", n.author.Key.Fingerprint, api.Answer{Keys: []api.Key{n.author.Key}})
+	if err2 != nil {
+		return err2
+	}
+	err3 := n.add(0, "boards", n.author.Board.Fingerprint, api.Answer{Boards: []api.Board{n.author.Board}})
+	if err3 != nil {
+		return err3
+	}
+	for i := 0; i < s.Threads; i++ {
+		t, err4 := n.author.Thread()
+		if err4 != nil {
+			return err4
+		}
+		// The threads need the board on the node they are given to, so they go to the first node too.
+		err5 := n.add(0, "threads", t.Fingerprint, api.Answer{Threads: []api.Thread{t}})
+		if err5 != nil {
+			return err5
+		}
+	}
+	if len(n.author.Threads) > 0 {
+		for _, b := range n.byzantine {
+			b.Target(n.author.Board.Fingerprint, n.author.Threads[0].Fingerprint)
+		}
+	}
+	return nil
+}
+
+// ticker gives a channel that ticks at the given rate per minute, or never if the rate is 0.
+func ticker(perMinute float64) (<-chan time.Time, func()) {
+	if perMinute <= 0 {
+		return nil, func() {}
+	}
+	t := time.NewTicker(time.Duration(float64(time.Minute) / perMinute))
+	return t.C, t.Stop
+}
+
+// onlineNode gives a random node that is online, or -1 if there is none.
+func (n *network) onlineNode() int {
+	var online []int
+	for i, node := range n.nodes {
+		if node.Online() {
+			online = append(online, i)
+		}
+	}
+	if len(online) == 0 {
+		return -1
+	}
+	return online[n.intn(len(online))]
+}
+
+// drive has the users post and vote on random online nodes, and the nodes go offline and come back, for the given duration.
+func (n *network) drive(d time.Duration) {
+	s := n.scenario
+	posts, stopPosts := ticker(s.PostsPerMinute)
+	defer stopPosts()
+	votes, stopVotes := ticker(s.VotesPerMinute)
+	defer stopVotes()
+	var churn <-chan time.Time
+	if s.ChurnInterval > 0 {
+		t := time.NewTicker(time.Duration(s.ChurnInterval))
+		defer t.Stop()
+		churn = t.C
+	}
+	poll := time.NewTicker(time.Duration(s.PollInterval))
+	defer poll.Stop()
+	end := time.After(d)
+	var restarts sync.WaitGroup
+	defer restarts.Wait()
+	for {
+		select {
+		case <-end:
+			return
+		case <-posts:
+			origin := n.onlineNode()
+			if origin == -1 {
+				continue
+			}
+			n.randLock.Lock()
+			p, err := n.author.Post(n.random)
+			n.randLock.Unlock()
+			if err == nil {
+				err = n.add(origin, "posts", p.Fingerprint, api.Answer{Posts: []api.Post{p}})
+			}
+			if err != nil {
+				n.log("A post could not be created. Error: %s", err)
+			}
+		case <-votes:
+			origin := n.onlineNode()
+			if origin == -1 {
+				continue
+			}
+			n.randLock.Lock()
+			v, err := n.author.Vote(n.random)
+			n.randLock.Unlock()
+			if err == nil {
+				err = n.add(origin, "votes", v.Fingerprint, api.Answer{Votes: []api.Vote{v}})
+			}
+			if err != nil {
+				n.log("A vote could not be created. Error: %s", err)
+			}
+		case <-churn:
+			i := n.onlineNode()
+			// One node always stays up, so that there is somewhere to post.
+			if i == -1 || n.onlineCount() < 2 {
+				continue
+			}
+			node := n.nodes[i]
+			n.log("Node %d goes offline for %s.", i, time.Duration(s.ChurnDowntime))
+			node.Stop()
+			restarts.Add(1)
+			go func() {
+				defer restarts.Done()
+				time.Sleep(time.Duration(s.ChurnDowntime))
+				err := node.Start(n.opts.Binary, n.opts.MySQL)
+				if err != nil {
+					n.log("Node %d could not be restarted. Error: %s", node.Index, err)
+					return
+				}
+				node.Restarts++
+				n.log("Node %d is back online.", node.Index)
+			}()
+		case <-poll.C:
+			n.poll()
+		}
+	}
+}
+
+func (n *network) onlineCount() int {
+	count := 0
+	for _, node := range n.nodes {
+		if node.Online() {
+			count++
+		}
+	}
+	return count
+}
+
+// settle polls the nodes until everything has arrived everywhere, or the given time runs out.
+func (n *network) settle(d time.Duration) {
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		if n.poll() == 0 {
+			n.log("The network has converged.")
+			return
+		}
+		time.Sleep(time.Duration(n.scenario.PollInterval))
+	}
+}
+
+// poll asks every online node which of the entities it doesn't have yet have arrived, and gives how many are still missing.
+func (n *network) poll() int {
+	missing := 0
+	for i, node := range n.nodes {
+		for _, t := range entityTypes {
+			pending := n.collector.Pending(i, t)
+			if len(pending) == 0 {
+				continue
+			}
+			if !node.Online() {
+				missing += len(pending)
+				continue
+			}
+			arrivals, err := node.Arrivals(t, pending)
+			if err != nil {
+				n.log("The arrivals could not be asked of node %d. Error: %s", i, err)
+				missing += len(pending)
+				continue
+			}
+			for fp, ts := range arrivals {
+				n.collector.Arrived(i, fp, time.Unix(int64(ts), 0))
+			}
+			missing += len(pending) - len(arrivals)
+		}
+	}
+	return missing
+}
+
+// byzantineResults counts the requests the byzantine peers were sent, and asks the nodes whether they kept any of the forged posts.
+func (n *network) byzantineResults() *ByzantineResults {
+	br := &ByzantineResults{Behavior: n.scenario.ByzantineBehavior, Peers: len(n.byzantine)}
+	var forged []api.Fingerprint
+	for _, b := range n.byzantine {
+		br.Requests += b.Requests()
+		forged = append(forged, b.Forged()...)
+	}
+	br.ForgedServed = len(forged)
+	if len(forged) == 0 {
+		return br
+	}
+	for i, node := range n.nodes {
+		arrivals, err := node.Arrivals("posts", forged)
+		if err != nil {
+			n.log("The forged posts could not be asked of node %d. Error: %s", i, err)
+			continue
+		}
+		br.ForgedAccepted += len(arrivals)
+	}
+	return br
+}
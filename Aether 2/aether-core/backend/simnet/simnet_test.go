@@ -0,0 +1,185 @@
+package simnet_test
+
+import (
+	"aether-core/backend/simnet"
+	"aether-core/io/api"
+	"aether-core/services/globals"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Infrastructure, setup and teardown
+
+var tempDir string
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+	dir, err := ioutil.TempDir("", "simnet-test-")
+	if err != nil {
+		panic(err)
+	}
+	tempDir = dir
+}
+
+func teardown() {
+	os.RemoveAll(tempDir)
+}
+
+func get(t *testing.T, b *simnet.Byzantine, path string) []byte {
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/v0/%s", b.Port(), path))
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	return data
+}
+
+// Tests
+
+func TestLoadScenario_Success(t *testing.T) {
+	for _, name := range simnet.ScenarioNames() {
+		s, err := simnet.LoadScenario(name)
+		if err != nil {
+			t.Errorf("The built in scenario should load. Scenario: %s, Error: %s", name, err)
+		}
+		if err2 := s.Validate(); err2 != nil {
+			t.Errorf("The built in scenario should be valid. Scenario: %s, Error: %s", name, err2)
+		}
+	}
+	path := filepath.Join(tempDir, "custom.json")
+	ioutil.WriteFile(path, []byte(`{"nodes": 3, "duration": "2m", "settle": "1m", "threads": 1, "posts_per_minute": 2}`), 0644)
+	s, err := simnet.LoadScenario(path)
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	if s.Nodes != 3 || time.Duration(s.Duration) != 2*time.Minute || s.Name != path {
+		t.Errorf("The scenario file was not read right. Scenario: %#v", s)
+	}
+	if s.PollInterval == 0 {
+		t.Errorf("The poll interval should have a default.")
+	}
+}
+
+func TestLoadScenario_Fail(t *testing.T) {
+	if _, err := simnet.LoadScenario(filepath.Join(tempDir, "missing.json")); err == nil {
+		t.Errorf("A scenario that is neither built in nor a file should have been refused.")
+	}
+	path := filepath.Join(tempDir, "invalid.json")
+	ioutil.WriteFile(path, []byte(`{"nodes": 1, "duration": "2m"}`), 0644)
+	if _, err := simnet.LoadScenario(path); err == nil {
+		t.Errorf("A scenario with a single node should have been refused.")
+	}
+}
+
+func TestValidate_Fail(t *testing.T) {
+	base := simnet.Scenarios["steady"]
+	cases := map[string]func(s *simnet.Scenario){
+		"peers":     func(s *simnet.Scenario) { s.PeersPerNode = s.Nodes },
+		"threads":   func(s *simnet.Scenario) { s.Threads = 0 },
+		"churn":     func(s *simnet.Scenario) { s.ChurnInterval = simnet.Duration(time.Minute); s.ChurnDowntime = 0 },
+		"byzantine": func(s *simnet.Scenario) { s.ByzantinePeers = 1; s.ByzantineBehavior = "polite" },
+	}
+	for name, modify := range cases {
+		s := base
+		modify(&s)
+		if err := s.Validate(); err == nil {
+			t.Errorf("The scenario should have been refused. Case: %s", name)
+		}
+	}
+}
+
+func TestCollectorResults_Success(t *testing.T) {
+	c := simnet.NewCollector(3)
+	start := time.Unix(1000, 0)
+	c.Created(simnet.Entity{Fingerprint: "a", Type: "posts", Origin: 0, Created: start})
+	c.Created(simnet.Entity{Fingerprint: "b", Type: "posts", Origin: 1, Created: start})
+	c.Arrived(0, "a", start)
+	c.Arrived(1, "a", start.Add(10*time.Second))
+	c.Arrived(2, "a", start.Add(30*time.Second))
+	c.Arrived(2, "a", start.Add(90*time.Second)) // Only the first arrival counts.
+	c.Arrived(1, "b", start)
+	c.Arrived(0, "b", start.Add(20*time.Second))
+	if p := c.Pending(2, "posts"); len(p) != 1 || p[0] != "b" {
+		t.Errorf("Only b should be pending on node 2. Pending: %v", p)
+	}
+	r := c.Results()
+	if len(r.Types) != 1 {
+		t.Fatalf("There should be one entity type. Types: %#v", r.Types)
+	}
+	tr := r.Types[0]
+	if tr.Created != 2 || tr.FullyConverged != 1 {
+		t.Errorf("Two posts should have been created, one of them fully converged. Results: %#v", tr)
+	}
+	if tr.Convergence < 0.83 || tr.Convergence > 0.84 {
+		t.Errorf("Five of the six post and node pairs should have arrived. Convergence: %f", tr.Convergence)
+	}
+	// The latencies at the nodes other than the origin are 10s, 20s and 30s.
+	if time.Duration(tr.LatencyP50) != 20*time.Second || time.Duration(tr.LatencyMax) != 30*time.Second {
+		t.Errorf("The latencies are wrong. Results: %#v", tr)
+	}
+	if time.Duration(tr.ConvergenceMax) != 30*time.Second {
+		t.Errorf("The fully converged post reached its last node in 30s. Results: %#v", tr)
+	}
+	if r.PerNode[2].Received != 1 || r.PerNode[2].Missing != 1 {
+		t.Errorf("Node 2 should have one post and miss one. Results: %#v", r.PerNode[2])
+	}
+}
+
+func TestByzantine_Forged_Success(t *testing.T) {
+	b, err := simnet.NewByzantine(simnet.ByzantineForged, "testnet", 0)
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	defer b.Close()
+	b.Target("board", "thread")
+	var node api.ApiResponse
+	json.Unmarshal(get(t, b, "node"), &node)
+	if len(node.NodeId) != 64 || node.NetworkId != "testnet" {
+		t.Errorf("The byzantine peer should look like a node of the network. Response: %#v", node)
+	}
+	var index api.ApiResponse
+	json.Unmarshal(get(t, b, "posts/index.json"), &index)
+	if len(index.Results) != 1 {
+		t.Fatalf("The index should link to a single cache. Response: %#v", index)
+	}
+	var page api.ApiResponse
+	err2 := json.Unmarshal(get(t, b, "posts/"+index.Results[0].ResponseUrl+"/0.json"), &page)
+	if err2 != nil {
+		t.Fatalf("Test failed, err: '%s'", err2)
+	}
+	if len(page.ResponseBody.Posts) == 0 || len(b.Forged()) != len(page.ResponseBody.Posts) {
+		t.Fatalf("The page should have the forged posts. Response: %#v", page)
+	}
+	if page.ResponseBody.Posts[0].VerifyFingerprint() {
+		t.Errorf("The forged post should not verify.")
+	}
+	if b.Requests() != 2 {
+		t.Errorf("The requests for entities should be counted. Requests: %d", b.Requests())
+	}
+}
+
+func TestByzantine_Garbage_Success(t *testing.T) {
+	b, err := simnet.NewByzantine(simnet.ByzantineGarbage, "", 0)
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	defer b.Close()
+	var page api.ApiResponse
+	if err := json.Unmarshal(get(t, b, "posts/index.json"), &page); err == nil {
+		t.Errorf("The garbage peer should not serve valid JSON.")
+	}
+}
@@ -0,0 +1,221 @@
+// Backend > Simnet > Stats
+// This file collects where and when the content of the simulated network arrived, and computes the convergence and latency statistics of a run from that.
+
+package simnet
+
+import (
+	"aether-core/io/api"
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Entity is an entity created in the simulated network.
+type Entity struct {
+	Fingerprint api.Fingerprint
+	Type        string // The endpoint of the entity type, such as "posts".
+	Origin      int    // The index of the node it was given to.
+	Created     time.Time
+}
+
+// Collector keeps the entities created in the simulated network, and when each node received them.
+type Collector struct {
+	lock     sync.Mutex
+	nodes    int
+	entities []Entity
+	arrivals []map[api.Fingerprint]time.Time // Per node.
+}
+
+func NewCollector(nodes int) *Collector {
+	c := &Collector{nodes: nodes}
+	for i := 0; i < nodes; i++ {
+		c.arrivals = append(c.arrivals, make(map[api.Fingerprint]time.Time))
+	}
+	return c
+}
+
+// Created records an entity that was given to its origin node.
+func (c *Collector) Created(e Entity) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entities = append(c.entities, e)
+}
+
+// Arrived records that the entity arrived at the node at the given time. Only the first arrival is kept.
+func (c *Collector) Arrived(node int, fp api.Fingerprint, at time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.arrivals[node][fp]; !ok {
+		c.arrivals[node][fp] = at
+	}
+}
+
+// Pending gives the entities of the type that haven't arrived at the node yet.
+func (c *Collector) Pending(node int, entityType string) []api.Fingerprint {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var fps []api.Fingerprint
+	for i, _ := range c.entities {
+		if c.entities[i].Type != entityType {
+			continue
+		}
+		if _, ok := c.arrivals[node][c.entities[i].Fingerprint]; !ok {
+			fps = append(fps, c.entities[i].Fingerprint)
+		}
+	}
+	return fps
+}
+
+// TypeResults are the statistics of an entity type.
+type TypeResults struct {
+	Type           string   `json:"type"`
+	Created        int      `json:"created"`
+	Convergence    float64  `json:"convergence"`     // The fraction of the entity and node pairs where the entity arrived at the node.
+	FullyConverged int      `json:"fully_converged"` // The entities that arrived at every node.
+	LatencyP50     Duration `json:"latency_p50"`     // From creation to arrival at a node other than the origin.
+	LatencyP90     Duration `json:"latency_p90"`
+	LatencyP99     Duration `json:"latency_p99"`
+	LatencyMax     Duration `json:"latency_max"`
+	ConvergenceP50 Duration `json:"convergence_p50"` // From creation to arrival at the last node, for the fully converged entities.
+	ConvergenceMax Duration `json:"convergence_max"`
+}
+
+// NodeResults are the statistics of a node.
+type NodeResults struct {
+	Index    int `json:"index"`
+	Restarts int `json:"restarts"`
+	Received int `json:"received"`
+	Missing  int `json:"missing"`
+}
+
+// ByzantineResults are the statistics of the byzantine peers.
+type ByzantineResults struct {
+	Behavior       string `json:"behavior"`
+	Peers          int    `json:"peers"`
+	Requests       int    `json:"requests"`        // Requests for entities the honest nodes sent to the byzantine peers.
+	ForgedServed   int    `json:"forged_served"`   // Forged posts the byzantine peers served.
+	ForgedAccepted int    `json:"forged_accepted"` // Forged post and node pairs where an honest node kept the forged post. Anything other than 0 is a failure.
+}
+
+// Results are the statistics of a run of a scenario.
+type Results struct {
+	Scenario  string            `json:"scenario"`
+	Nodes     int               `json:"nodes"`
+	Elapsed   Duration          `json:"elapsed"`
+	Types     []TypeResults     `json:"types"`
+	PerNode   []NodeResults     `json:"per_node"`
+	Byzantine *ByzantineResults `json:"byzantine,omitempty"`
+}
+
+// percentile gives the pth percentile of the sorted durations, by the nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+func sortDurations(ds []time.Duration) {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+}
+
+// Results computes the statistics from what has been collected. The arrival times come from the nodes in seconds, so a latency of less than a second can show as 0.
+func (c *Collector) Results() Results {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var r Results
+	r.Nodes = c.nodes
+	byType := make(map[string][]Entity)
+	var types []string
+	for _, e := range c.entities {
+		if _, ok := byType[e.Type]; !ok {
+			types = append(types, e.Type)
+		}
+		byType[e.Type] = append(byType[e.Type], e)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		tr := TypeResults{Type: t, Created: len(byType[t])}
+		var latencies []time.Duration
+		var convergences []time.Duration
+		arrived := 0
+		for _, e := range byType[t] {
+			last := time.Duration(0)
+			count := 0
+			for n := 0; n < c.nodes; n++ {
+				at, ok := c.arrivals[n][e.Fingerprint]
+				if !ok {
+					continue
+				}
+				count++
+				d := at.Sub(e.Created)
+				if d < 0 {
+					d = 0
+				}
+				if n != e.Origin {
+					latencies = append(latencies, d)
+				}
+				if d > last {
+					last = d
+				}
+			}
+			arrived += count
+			if count == c.nodes {
+				tr.FullyConverged++
+				convergences = append(convergences, last)
+			}
+		}
+		if tr.Created > 0 {
+			tr.Convergence = float64(arrived) / float64(tr.Created*c.nodes)
+		}
+		sortDurations(latencies)
+		sortDurations(convergences)
+		tr.LatencyP50 = Duration(percentile(latencies, 0.5))
+		tr.LatencyP90 = Duration(percentile(latencies, 0.9))
+		tr.LatencyP99 = Duration(percentile(latencies, 0.99))
+		tr.LatencyMax = Duration(percentile(latencies, 1))
+		tr.ConvergenceP50 = Duration(percentile(convergences, 0.5))
+		tr.ConvergenceMax = Duration(percentile(convergences, 1))
+		r.Types = append(r.Types, tr)
+	}
+	for n := 0; n < c.nodes; n++ {
+		nr := NodeResults{Index: n}
+		for _, e := range c.entities {
+			if _, ok := c.arrivals[n][e.Fingerprint]; ok {
+				nr.Received++
+			} else {
+				nr.Missing++
+			}
+		}
+		r.PerNode = append(r.PerNode, nr)
+	}
+	return r
+}
+
+// String gives the results in a form to be read in the terminal.
+func (r Results) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Scenario: %s, Nodes: %d, Elapsed: %s\n\n", r.Scenario, r.Nodes, time.Duration(r.Elapsed))
+	fmt.Fprintf(&b, "%-12s %8s %12s %10s %10s %10s %10s %10s %12s %12s\n", "TYPE", "CREATED", "CONVERGENCE", "FULL", "P50", "P90", "P99", "MAX", "CONV P50", "CONV MAX")
+	for _, t := range r.Types {
+		fmt.Fprintf(&b, "%-12s %8d %11.1f%% %10d %10s %10s %10s %10s %12s %12s\n", t.Type, t.Created, t.Convergence*100, t.FullyConverged,
+			time.Duration(t.LatencyP50), time.Duration(t.LatencyP90), time.Duration(t.LatencyP99), time.Duration(t.LatencyMax),
+			time.Duration(t.ConvergenceP50), time.Duration(t.ConvergenceMax))
+	}
+	fmt.Fprintf(&b, "\n%-6s %10s %10s %10s\n", "NODE", "RESTARTS", "RECEIVED", "MISSING")
+	for _, n := range r.PerNode {
+		fmt.Fprintf(&b, "%-6d %10d %10d %10d\n", n.Index, n.Restarts, n.Received, n.Missing)
+	}
+	if r.Byzantine != nil {
+		fmt.Fprintf(&b, "\nByzantine peers: %d (%s), Requests: %d, Forged served: %d, Forged accepted: %d\n",
+			r.Byzantine.Peers, r.Byzantine.Behavior, r.Byzantine.Requests, r.Byzantine.ForgedServed, r.Byzantine.ForgedAccepted)
+	}
+	return b.String()
+}
@@ -0,0 +1,146 @@
+// Backend > StorageReport
+// This package reports how much the node stores, per entity type, in the database and in the caches, how fast it grew in the last week, and which boards and threads are the largest. It is meant for deciding on the retention before turning it on.
+
+package storagereport
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// growthWindow is how far back the growth is counted.
+const growthWindow = 7 * 24 * time.Hour
+
+// EntityStorage is what the node stores of an entity type.
+type EntityStorage struct {
+	EntityType      string  `json:"entity_type"`
+	Rows            int     `json:"rows"`
+	DatabaseBytes   int64   `json:"database_bytes"` // Data and indexes, as the database estimates them.
+	CacheFiles      int     `json:"cache_files"`
+	CacheBytes      int64   `json:"cache_bytes"`
+	ArrivedLastWeek int     `json:"arrived_last_week"`
+	GrowthPercent   float64 `json:"growth_percent"` // How much the rows grew in the last week, compared to what there was before it.
+}
+
+// Report is what the node stores.
+type Report struct {
+	Generated          int64                   `json:"generated"`
+	Entities           []EntityStorage         `json:"entities"`
+	TotalRows          int                     `json:"total_rows"`
+	TotalDatabaseBytes int64                   `json:"total_database_bytes"`
+	TotalCacheBytes    int64                   `json:"total_cache_bytes"`
+	LargestBoards      []persistence.PostCount `json:"largest_boards"`
+	LargestThreads     []persistence.PostCount `json:"largest_threads"`
+}
+
+// CacheUsage counts the files of the caches of an entity type and their bytes. An entity type without caches uses nothing.
+func CacheUsage(entityType string) (int, int64, error) {
+	files := 0
+	var bytes int64
+	dir := filepath.Join(globals.CachesLocation, entityType)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			files++
+			bytes += info.Size()
+		}
+		return nil
+	})
+	return files, bytes, err
+}
+
+// Generate creates the report. The largest boards and threads are listed up to the given number.
+func Generate(largest int) (Report, error) {
+	r := Report{Generated: clock.Unix()}
+	weekAgo := api.Timestamp(clock.Now().Add(-growthWindow).Unix())
+	stats, err := persistence.ReadTableStats(weekAgo)
+	if err != nil {
+		return r, err
+	}
+	for _, s := range stats {
+		e := EntityStorage{
+			EntityType:      s.EntityType,
+			Rows:            s.Rows,
+			DatabaseBytes:   s.DataBytes + s.IndexBytes,
+			ArrivedLastWeek: s.Arrived,
+		}
+		if before := s.Rows - s.Arrived; before > 0 {
+			e.GrowthPercent = float64(s.Arrived) * 100 / float64(before)
+		}
+		files, bytes, err2 := CacheUsage(s.EntityType)
+		if err2 != nil {
+			return r, err2
+		}
+		e.CacheFiles = files
+		e.CacheBytes = bytes
+		r.Entities = append(r.Entities, e)
+		r.TotalRows += e.Rows
+		r.TotalDatabaseBytes += e.DatabaseBytes
+		r.TotalCacheBytes += e.CacheBytes
+	}
+	r.LargestBoards, err = persistence.ReadLargestBoards(largest)
+	if err != nil {
+		return r, err
+	}
+	r.LargestThreads, err = persistence.ReadLargestThreads(largest)
+	if err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// humanBytes formats a byte count for reading.
+func humanBytes(b int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	v := float64(b)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", b)
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+func formatPostCounts(b *strings.Builder, title string, counts []persistence.PostCount) {
+	b.WriteString(fmt.Sprintf("%s:\n", title))
+	if len(counts) == 0 {
+		b.WriteString("  None.\n")
+		return
+	}
+	for _, c := range counts {
+		name := c.Name
+		if len(name) == 0 {
+			name = "(not arrived)"
+		}
+		b.WriteString(fmt.Sprintf("  %8d posts  %s  %s\n", c.Posts, c.Fingerprint, name))
+	}
+}
+
+// String formats the report to be printed to the terminal.
+func (r *Report) String() string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Storage report, %s:\n", time.Unix(r.Generated, 0).Format(time.RFC3339)))
+	b.WriteString(fmt.Sprintf("  %-12s %10s %12s %12s %8s %12s %9s\n", "Entity", "Rows", "Database", "Caches", "Files", "Last week", "Growth"))
+	for _, e := range r.Entities {
+		b.WriteString(fmt.Sprintf("  %-12s %10d %12s %12s %8d %12d %8.1f%%\n", e.EntityType, e.Rows, humanBytes(e.DatabaseBytes), humanBytes(e.CacheBytes), e.CacheFiles, e.ArrivedLastWeek, e.GrowthPercent))
+	}
+	b.WriteString(fmt.Sprintf("  %-12s %10d %12s %12s\n", "Total", r.TotalRows, humanBytes(r.TotalDatabaseBytes), humanBytes(r.TotalCacheBytes)))
+	formatPostCounts(&b, "Largest boards", r.LargestBoards)
+	formatPostCounts(&b, "Largest threads", r.LargestThreads)
+	return b.String()
+}
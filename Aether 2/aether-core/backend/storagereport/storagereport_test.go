@@ -0,0 +1,78 @@
+package storagereport_test
+
+import (
+	"aether-core/backend/storagereport"
+	"aether-core/io/persistence"
+	"aether-core/services/globals"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+var dir string
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+	var err error
+	dir, err = ioutil.TempDir("", "aether-storagereport")
+	if err != nil {
+		panic(err)
+	}
+	globals.CachesLocation = dir
+}
+
+func teardown() {
+	os.RemoveAll(dir)
+}
+
+// Tests
+
+func TestCacheUsage_Success(t *testing.T) {
+	cache := filepath.Join(dir, "boards", "cache_1")
+	os.MkdirAll(cache, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "boards", "index.json"), make([]byte, 100), 0644)
+	ioutil.WriteFile(filepath.Join(cache, "0.json"), make([]byte, 250), 0644)
+	files, bytes, err := storagereport.CacheUsage("boards")
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	if files != 2 || bytes != 350 {
+		t.Errorf("The files of the caches should have been counted. Files: %d, Bytes: %d", files, bytes)
+	}
+}
+
+func TestCacheUsage_Fail_NoCaches(t *testing.T) {
+	files, bytes, err := storagereport.CacheUsage("tombstones")
+	if err != nil || files != 0 || bytes != 0 {
+		t.Errorf("An entity type without caches should use nothing. Files: %d, Bytes: %d, Error: %v", files, bytes, err)
+	}
+}
+
+func TestString_Success(t *testing.T) {
+	r := storagereport.Report{
+		Entities: []storagereport.EntityStorage{
+			{EntityType: "posts", Rows: 1200, DatabaseBytes: 3 * 1024 * 1024, CacheBytes: 2048, CacheFiles: 4, ArrivedLastWeek: 200, GrowthPercent: 20},
+		},
+		TotalRows:          1200,
+		TotalDatabaseBytes: 3 * 1024 * 1024,
+		TotalCacheBytes:    2048,
+		LargestThreads:     []persistence.PostCount{{Fingerprint: "abc", Posts: 40}},
+	}
+	s := r.String()
+	for _, want := range []string{"posts", "3.0 MB", "2.0 KB", "20.0%", "40 posts  abc  (not arrived)", "Largest boards:\n  None."} {
+		if !strings.Contains(s, want) {
+			t.Errorf("The report should contain %q. Report:\n%s", want, s)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Backend > Sync policy
+// This package applies the vote sync policies: the rules that keep the votes of the boards the operator doesn't care about from being fetched from the remotes, and the old votes of a board from being served to them. The votes are most of what a sync moves, and in a board nobody on the node reads, they are only overhead.
+// A rule matches a board by its fingerprint, or every board with "*", and can be limited to the boards the user is not subscribed to. The votes of a board are fetched only if none of the rules that match it say otherwise, and the shortest of the ages they give is the one served.
+
+package syncpolicy
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+)
+
+// AllBoards is the board of a rule that matches every board.
+const AllBoards = "*"
+
+// subscribed checks whether the user is subscribed to the board.
+func subscribed(board api.Fingerprint) bool {
+	for _, b := range globals.SubscribedBoards {
+		if api.Fingerprint(b) == board {
+			return true
+		}
+	}
+	return false
+}
+
+// matches checks whether the rule applies to the board.
+func matches(rule globals.VoteSyncPolicy, board api.Fingerprint) bool {
+	if rule.Board != AllBoards && api.Fingerprint(rule.Board) != board {
+		return false
+	}
+	return !rule.Unsubscribed || !subscribed(board)
+}
+
+// Fetched checks whether the votes of the board are taken from the remotes.
+func Fetched(board api.Fingerprint) bool {
+	for _, rule := range globals.VoteSyncPolicies {
+		if rule.NoFetch && matches(rule, board) {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeMaxAge gives how old, in seconds, the votes of the board can be to be served to the remotes. 0 serves all of them.
+func ServeMaxAge(board api.Fingerprint) int64 {
+	var maxAge int64
+	for _, rule := range globals.VoteSyncPolicies {
+		if rule.ServeMaxAge > 0 && matches(rule, board) && (maxAge == 0 || rule.ServeMaxAge < maxAge) {
+			maxAge = rule.ServeMaxAge
+		}
+	}
+	return maxAge
+}
+
+// FetchedBoards gives the boards whose votes are asked for from the remotes, if the rules limit them to a known set: this is the case when a rule for every board stops the fetching, with or without the boards the user is subscribed to. all is true if the votes of every board are asked for, and the ones the rules don't want are dropped as they arrive instead. An empty list with all false means no votes are fetched at all.
+func FetchedBoards() ([]string, bool) {
+	limited := false
+	unsubscribedOnly := true
+	for _, rule := range globals.VoteSyncPolicies {
+		if rule.Board == AllBoards && rule.NoFetch {
+			limited = true
+			if !rule.Unsubscribed {
+				unsubscribedOnly = false
+			}
+		}
+	}
+	if !limited {
+		return nil, true
+	}
+	boards := []string{}
+	if !unsubscribedOnly {
+		return boards, false
+	}
+	for _, b := range globals.SubscribedBoards {
+		if Fetched(api.Fingerprint(b)) {
+			boards = append(boards, b)
+		}
+	}
+	return boards, false
+}
+
+// FilterFetched drops the votes of the boards whose votes are not fetched. This is applied on ingest, since the caches of the remotes and the remotes that don't know the board filter give the votes of every board.
+func FilterFetched(resp api.Response) api.Response {
+	if len(globals.VoteSyncPolicies) == 0 || len(resp.Votes) == 0 {
+		return resp
+	}
+	cleanedResp := resp
+	cleanedResp.Votes = nil
+	for i, _ := range resp.Votes {
+		if Fetched(resp.Votes[i].Board) {
+			cleanedResp.Votes = append(cleanedResp.Votes, resp.Votes[i])
+		}
+	}
+	return cleanedResp
+}
+
+// FilterServed drops the votes that are older than their boards allow to be served. A vote is as old as its last update, since a vote changed recently is still current.
+func FilterServed(resp api.Response) api.Response {
+	if len(globals.VoteSyncPolicies) == 0 || len(resp.Votes) == 0 {
+		return resp
+	}
+	now := clock.Unix()
+	cleanedResp := resp
+	cleanedResp.Votes = nil
+	for i, _ := range resp.Votes {
+		v := resp.Votes[i]
+		maxAge := ServeMaxAge(v.Board)
+		last := int64(v.Creation)
+		if int64(v.LastUpdate) > last {
+			last = int64(v.LastUpdate)
+		}
+		if maxAge > 0 && now-last > maxAge {
+			continue
+		}
+		cleanedResp.Votes = append(cleanedResp.Votes, v)
+	}
+	return cleanedResp
+}
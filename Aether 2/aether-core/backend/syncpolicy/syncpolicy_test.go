@@ -0,0 +1,92 @@
+package syncpolicy_test
+
+import (
+	"aether-core/backend/syncpolicy"
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"os"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+	globals.SubscribedBoards = []string{"subscribed"}
+}
+
+func teardown() {
+}
+
+func vote(fp string, board string, creation int64) api.Vote {
+	var v api.Vote
+	v.Fingerprint = api.Fingerprint(fp)
+	v.Board = api.Fingerprint(board)
+	v.Creation = api.Timestamp(creation)
+	return v
+}
+
+func fingerprints(votes []api.Vote) []string {
+	var fps []string
+	for i, _ := range votes {
+		fps = append(fps, string(votes[i].Fingerprint))
+	}
+	return fps
+}
+
+// Tests
+
+func TestFetched_Success(t *testing.T) {
+	globals.VoteSyncPolicies = []globals.VoteSyncPolicy{{Board: syncpolicy.AllBoards, Unsubscribed: true, NoFetch: true}}
+	defer func() { globals.VoteSyncPolicies = []globals.VoteSyncPolicy{} }()
+	if !syncpolicy.Fetched("subscribed") || syncpolicy.Fetched("other") {
+		t.Errorf("Only the votes of the subscribed board should be fetched.")
+	}
+	boards, all := syncpolicy.FetchedBoards()
+	if all || len(boards) != 1 || boards[0] != "subscribed" {
+		t.Errorf("The votes of the subscribed board should be asked for by the board. Boards: %v, All: %v", boards, all)
+	}
+	resp := api.Response{Votes: []api.Vote{vote("a", "subscribed", 1), vote("b", "other", 1)}}
+	kept := fingerprints(syncpolicy.FilterFetched(resp).Votes)
+	if len(kept) != 1 || kept[0] != "a" {
+		t.Errorf("The votes of the unsubscribed board should have been dropped. Kept: %v", kept)
+	}
+}
+
+func TestFetched_Fail_NoBoards(t *testing.T) {
+	if _, all := syncpolicy.FetchedBoards(); !all {
+		t.Errorf("Without policies, the votes of every board should be fetched.")
+	}
+	globals.VoteSyncPolicies = []globals.VoteSyncPolicy{{Board: syncpolicy.AllBoards, NoFetch: true}}
+	defer func() { globals.VoteSyncPolicies = []globals.VoteSyncPolicy{} }()
+	if boards, all := syncpolicy.FetchedBoards(); all || len(boards) != 0 {
+		t.Errorf("No votes should be fetched. Boards: %v, All: %v", boards, all)
+	}
+}
+
+func TestFilterServed_Success(t *testing.T) {
+	globals.VoteSyncPolicies = []globals.VoteSyncPolicy{{Board: "old", ServeMaxAge: 3600}, {Board: syncpolicy.AllBoards, ServeMaxAge: 86400}}
+	defer func() { globals.VoteSyncPolicies = []globals.VoteSyncPolicy{} }()
+	now := clock.Unix()
+	updated := vote("updated", "old", now-7200)
+	updated.LastUpdate = api.Timestamp(now - 60)
+	resp := api.Response{Votes: []api.Vote{
+		vote("recent", "old", now-60),
+		vote("stale", "old", now-7200),
+		updated,
+		vote("elsewhere", "other", now-7200),
+		vote("ancient", "other", now-172800),
+	}}
+	kept := fingerprints(syncpolicy.FilterServed(resp).Votes)
+	if len(kept) != 3 || kept[0] != "recent" || kept[1] != "updated" || kept[2] != "elsewhere" {
+		t.Errorf("The votes older than the shortest age of their board should have been dropped. Kept: %v", kept)
+	}
+}
@@ -0,0 +1,234 @@
+// Backend > Telemetry
+// This package reports anonymous aggregates of the node to a community stats collector, if the operator opts in, so that the community can size the network. It is off unless telemetry_enabled is given, and it sends nothing in privacy mode, or without a collector.
+// A report is a heartbeat, and, as the operator chooses, the version of the app and the totals of the entities, rounded. It has no node id, no address, no key, and no id of a private network; the heartbeat is counted by an instance id that is random, and new every period, so that the reports of a node can't be followed from one period to the next. The collector does see the IP address the report comes from, as any server does. The report as it would be sent is given at /admin/telemetry, for the operator to review before opting in.
+
+package telemetry
+
+import (
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// reportSchema is increased when the fields of the report change.
+const reportSchema = 1
+
+// stateFile keeps the instance id of the period and the last report in the user directory.
+const stateFile = "telemetry.json"
+
+// Report is what is sent to the collector.
+type Report struct {
+	Schema      int            `json:"schema"`
+	Instance    string         `json:"instance"` // Random, and new every period.
+	Period      int64          `json:"period"`   // The start of the period the report is for.
+	Network     string         `json:"network"`  // "public" or "private".
+	ServingMode string         `json:"serving_mode"`
+	Version     string         `json:"version,omitempty"`
+	Entities    map[string]int `json:"entities,omitempty"` // Rounded to two significant digits.
+}
+
+// Status is the state of the telemetry, for the operator.
+type Status struct {
+	Enabled     bool   `json:"enabled"`
+	Collector   string `json:"collector"`
+	Blocked     string `json:"blocked,omitempty"` // Why nothing is sent, if nothing is.
+	LastSent    int64  `json:"last_sent,omitempty"`
+	LastAttempt int64  `json:"last_attempt,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+	Report      Report `json:"report"` // What would be sent now.
+}
+
+// state is what is kept in the state file.
+type state struct {
+	Period      int64  `json:"period"`
+	Instance    string `json:"instance"`
+	LastSent    int64  `json:"last_sent"`
+	LastAttempt int64  `json:"last_attempt"`
+	LastError   string `json:"last_error"`
+}
+
+var lock sync.Mutex
+
+func statePath() string {
+	return fmt.Sprint(globals.UserDirectory, "/", stateFile)
+}
+
+func load() state {
+	var s state
+	data, err := ioutil.ReadFile(statePath())
+	if err != nil {
+		return s
+	}
+	if err2 := json.Unmarshal(data, &s); err2 != nil {
+		logging.Log(1, fmt.Sprintf("The telemetry state could not be read. It starts over. Error: %s", err2))
+		return state{}
+	}
+	return s
+}
+
+func save(s state) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	tmp := fmt.Sprint(statePath(), ".tmp")
+	if err2 := ioutil.WriteFile(tmp, data, 0600); err2 != nil {
+		logging.Log(1, fmt.Sprintf("The telemetry state could not be written. Error: %s", err2))
+		return
+	}
+	os.Rename(tmp, statePath())
+}
+
+// period gives the start of the period the given time is in.
+func period(now int64) int64 {
+	length := int64(globals.TelemetryInterval / time.Second)
+	if length < 1 {
+		length = 1
+	}
+	return now / length * length
+}
+
+// rotate gives the state a new instance id if the period changed since.
+func rotate(s *state, now int64) {
+	if p := period(now); s.Period != p || len(s.Instance) == 0 {
+		b := make([]byte, 16)
+		rand.Read(b)
+		s.Period, s.Instance = p, hex.EncodeToString(b)
+	}
+}
+
+// RoundCount rounds the count to two significant digits, so that the totals say how large the node is without telling it apart from the others of about its size.
+func RoundCount(n int) int {
+	if n < 100 {
+		return n
+	}
+	scale := math.Pow(10, math.Floor(math.Log10(float64(n)))-1)
+	return int(math.Round(float64(n)/scale) * scale)
+}
+
+// blocked gives why nothing is sent, or an empty string if the reports are sent.
+func blocked() string {
+	switch {
+	case !globals.TelemetryEnabled:
+		return "Telemetry is not enabled."
+	case globals.PrivacyMode:
+		return "Nothing is reported in privacy mode."
+	case len(globals.TelemetryCollector) == 0:
+		return "There is no collector."
+	}
+	if err := checkCollector(globals.TelemetryCollector); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// checkCollector checks that the reports to the collector are encrypted, unless it is on this machine.
+func checkCollector(collector string) error {
+	u, err := url.Parse(collector)
+	if err != nil {
+		return errors.New(fmt.Sprintf("The address of the collector is malformed. Collector: %s", collector))
+	}
+	if u.Scheme == "https" {
+		return nil
+	}
+	if ip := net.ParseIP(u.Hostname()); u.Scheme == "http" && (u.Hostname() == "localhost" || (ip != nil && ip.IsLoopback())) {
+		return nil
+	}
+	return errors.New(fmt.Sprintf("The collector has to be an https address, or an http one on this machine. Collector: %s", collector))
+}
+
+// build puts the report together.
+func build(s state) (Report, error) {
+	r := Report{Schema: reportSchema, Instance: s.Instance, Period: s.Period, Network: "public", ServingMode: globals.ServingMode}
+	if len(globals.NetworkId) > 0 {
+		r.Network = "private"
+	}
+	if globals.TelemetryIncludeVersion {
+		r.Version = fmt.Sprintf("%d.%d.%d", globals.ClientVersionMajor, globals.ClientVersionMinor, globals.ClientVersionPatch)
+	}
+	if globals.TelemetryIncludeEntityTotals {
+		stats, err := persistence.ReadTableStats(0)
+		if err != nil {
+			return r, err
+		}
+		r.Entities = make(map[string]int)
+		for _, st := range stats {
+			if st.EntityType == "addresses" {
+				// The addresses are of the other nodes, and are not this node's to report.
+				continue
+			}
+			r.Entities[st.EntityType] = RoundCount(st.Rows)
+		}
+	}
+	return r, nil
+}
+
+// Current gives the state of the telemetry, and the report that would be sent now.
+func Current() (Status, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	s := load()
+	rotate(&s, clock.Unix())
+	r, err := build(s)
+	return Status{Enabled: globals.TelemetryEnabled, Collector: globals.TelemetryCollector, Blocked: blocked(), LastSent: s.LastSent, LastAttempt: s.LastAttempt, LastError: s.LastError, Report: r}, err
+}
+
+// Send sends the report of the period to the collector, if the telemetry is enabled and it was not sent in this period yet. This is what the scheduler calls, every hour, so that changing the settings takes effect without a restart.
+func Send() {
+	lock.Lock()
+	defer lock.Unlock()
+	if len(blocked()) > 0 {
+		return
+	}
+	now := clock.Unix()
+	s := load()
+	rotate(&s, now)
+	if s.LastSent >= s.Period {
+		return
+	}
+	s.LastAttempt = now
+	r, err := build(s)
+	if err == nil {
+		err = post(globals.TelemetryCollector, r)
+	}
+	if err != nil {
+		s.LastError = err.Error()
+		logging.Log(2, fmt.Sprintf("The telemetry report could not be sent. It is tried again in an hour. Error: %s", err))
+	} else {
+		s.LastSent, s.LastError = now, ""
+		logging.Log(2, "The telemetry report is sent.")
+	}
+	save(s)
+}
+
+func post(collector string, r Report) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err2 := client.Post(collector, "application/json", bytes.NewReader(body))
+	if err2 != nil {
+		return err2
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(fmt.Sprintf("The collector responded with a non-2xx status. Status: %d", resp.StatusCode))
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package telemetry_test
+
+import (
+	"aether-core/backend/telemetry"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// Infrastructure, setup and teardown
+
+var dir string
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+	var err error
+	dir, err = ioutil.TempDir("", "aether-telemetry")
+	if err != nil {
+		panic(err)
+	}
+	globals.UserDirectory = dir
+	// The totals need the database.
+	globals.TelemetryIncludeEntityTotals = false
+}
+
+func teardown() {
+	clock.Reset()
+	os.RemoveAll(dir)
+}
+
+// collector starts a collector that keeps the reports it receives.
+func collector(t *testing.T) (*httptest.Server, *[]map[string]interface{}) {
+	reports := []map[string]interface{}{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Errorf("The report could not be read. Error: %s", err)
+		}
+		reports = append(reports, report)
+	}))
+	return srv, &reports
+}
+
+// Tests
+
+func TestRoundCount_Success(t *testing.T) {
+	cases := map[int]int{0: 0, 7: 7, 99: 99, 123: 120, 1250: 1300, 98765: 99000, 1000000: 1000000}
+	for n, expected := range cases {
+		if got := telemetry.RoundCount(n); got != expected {
+			t.Errorf("The count was not rounded as expected. Count: %d, Expected: %d, Got: %d", n, expected, got)
+		}
+	}
+}
+
+func TestSend_Success(t *testing.T) {
+	srv, reports := collector(t)
+	defer srv.Close()
+	clock.Set(clock.NewMockClock(time.Unix(1500000000, 0)))
+	globals.TelemetryEnabled, globals.TelemetryCollector, globals.PrivacyMode = true, srv.URL, false
+	defer func() { globals.TelemetryEnabled = false }()
+	telemetry.Send()
+	telemetry.Send()
+	if len(*reports) != 1 {
+		t.Fatalf("The report should be sent once in a period. Sent: %d", len(*reports))
+	}
+	for _, field := range []string{"schema", "instance", "period", "network", "serving_mode", "version"} {
+		if _, ok := (*reports)[0][field]; !ok {
+			t.Errorf("The report has no %s.", field)
+		}
+	}
+	if len((*reports)[0]) != 6 {
+		t.Errorf("The report has fields it should not have. Report: %v", (*reports)[0])
+	}
+	clock.Set(clock.NewMockClock(time.Unix(1500000000, 0).Add(globals.TelemetryInterval)))
+	telemetry.Send()
+	if len(*reports) != 2 {
+		t.Fatalf("The report of the next period was not sent. Sent: %d", len(*reports))
+	}
+	if (*reports)[0]["instance"] == (*reports)[1]["instance"] {
+		t.Errorf("The instance id was kept from one period to the next.")
+	}
+}
+
+func TestSend_Fail_PrivacyMode(t *testing.T) {
+	srv, reports := collector(t)
+	defer srv.Close()
+	clock.Set(clock.NewMockClock(time.Unix(1600000000, 0)))
+	globals.TelemetryEnabled, globals.TelemetryCollector, globals.PrivacyMode = true, srv.URL, true
+	defer func() { globals.TelemetryEnabled, globals.PrivacyMode = false, false }()
+	telemetry.Send()
+	if len(*reports) != 0 {
+		t.Errorf("A report was sent in privacy mode.")
+	}
+	status, err := telemetry.Current()
+	if err != nil {
+		t.Fatalf("The status could not be read. Error: %s", err)
+	}
+	if len(status.Blocked) == 0 {
+		t.Errorf("The status does not say why nothing is sent.")
+	}
+}
+
+func TestSend_Fail_PlainHttpCollector(t *testing.T) {
+	clock.Set(clock.NewMockClock(time.Unix(1700000000, 0)))
+	globals.TelemetryEnabled, globals.TelemetryCollector = true, "http://stats.example.com/report"
+	defer func() { globals.TelemetryEnabled, globals.TelemetryCollector = false, "" }()
+	status, err := telemetry.Current()
+	if err != nil {
+		t.Fatalf("The status could not be read. Error: %s", err)
+	}
+	if len(status.Blocked) == 0 {
+		t.Errorf("A collector over plain http on another machine is not blocked.")
+	}
+}
@@ -0,0 +1,166 @@
+// Backend > Watches
+// This package keeps the threads and boards the local user watches. The watches are kept in the database per profile, which is the key of the user, and are never given to the remotes.
+// What is new in a watched item is flagged by the notifications: a post in a watched thread, and a thread in a watched board, become notifications of the type "watch" as they arrive, unless they are a reply to the user or a mention of them already. The feed gives the same, newest first, in pages, for the frontend to show the watched items together.
+
+package watches
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Types of the watches.
+const (
+	TypeThread = "thread" // The posts in the thread are new activity.
+	TypeBoard  = "board"  // The threads in the board are new activity.
+)
+
+// Watch is the frontend-facing form of a watch.
+type Watch struct {
+	Type     string          `json:"type"`
+	Target   api.Fingerprint `json:"target"`
+	Creation api.Timestamp   `json:"creation"`
+}
+
+// FeedItem is a post in a watched thread, or a thread in a watched board.
+type FeedItem struct {
+	Type      string      `json:"type"` // "post" or "thread"
+	Post      *api.Post   `json:"post,omitempty"`
+	Thread    *api.Thread `json:"thread,omitempty"`
+	Collapsed bool        `json:"collapsed,omitempty"` // The item matched a content filter of the user that collapses rather than hides.
+}
+
+// profile is the user the watches belong to. Watches added before the user has a key belong to the empty profile, and flag nothing, since there are no notifications before the user has a key.
+func profile() api.Fingerprint {
+	return api.Fingerprint(globals.CurrentUserKeyFingerprint())
+}
+
+// Add watches a thread or a board.
+func Add(w Watch) error {
+	if w.Type != TypeThread && w.Type != TypeBoard {
+		return errors.New(fmt.Sprintf("The type of the watch is unknown. Type: %s", w.Type))
+	}
+	if len(w.Target) == 0 {
+		return errors.New("A watch needs the thread or the board it watches.")
+	}
+	count, err := persistence.CountWatches(profile())
+	if err != nil {
+		return err
+	}
+	if count >= globals.WatchesMaxPerProfile {
+		return errors.New(fmt.Sprintf("There are too many watches. Remove some of them first. Watches: %d, Limit: %d", count, globals.WatchesMaxPerProfile))
+	}
+	return persistence.InsertWatch(persistence.DbWatch{Profile: profile(), Type: w.Type, Target: w.Target, Creation: api.Timestamp(clock.Unix())})
+}
+
+// Remove stops watching a thread or a board, and returns how many watches were removed.
+func Remove(watchType string, target api.Fingerprint) (int64, error) {
+	return persistence.DeleteWatch(profile(), watchType, target)
+}
+
+// List gives the watches of the user, the newest first.
+func List() ([]Watch, error) {
+	result := []Watch{}
+	dbWs, err := persistence.ReadWatches(profile())
+	if err != nil {
+		return result, err
+	}
+	for _, dbW := range dbWs {
+		result = append(result, Watch{Type: dbW.Type, Target: dbW.Target, Creation: dbW.Creation})
+	}
+	return result, nil
+}
+
+// EncodeCursor creates the opaque cursor that points after the given item of the feed.
+func EncodeCursor(item persistence.DbWatchFeedItem) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprint(int64(item.Creation), ":", item.Fingerprint)))
+}
+
+// DecodeCursor reads a cursor created by EncodeCursor. An empty cursor is the first page, and gives a zero creation.
+func DecodeCursor(cursor string) (api.Timestamp, api.Fingerprint, error) {
+	if len(cursor) == 0 {
+		return 0, "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", errors.New(fmt.Sprintf("The cursor is malformed. Cursor: %s", cursor))
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return 0, "", errors.New(fmt.Sprintf("The cursor is malformed. Cursor: %s", cursor))
+	}
+	creation, err2 := strconv.ParseInt(parts[0], 10, 64)
+	if err2 != nil || creation < 1 {
+		return 0, "", errors.New(fmt.Sprintf("The cursor is malformed. Cursor: %s", cursor))
+	}
+	return api.Timestamp(creation), api.Fingerprint(parts[1]), nil
+}
+
+// Feed gives a page of the posts in the watched threads and the threads in the watched boards, newest first, and the cursor of the next page. The next cursor is empty on the last page.
+func Feed(cursor string, limit int) ([]FeedItem, string, error) {
+	result := []FeedItem{}
+	afterCreation, afterFp, err := DecodeCursor(cursor)
+	if err != nil {
+		return result, "", err
+	}
+	items, err2 := persistence.ReadWatchFeedAfterCursor(profile(), afterCreation, afterFp, limit)
+	if err2 != nil || len(items) == 0 {
+		return result, "", err2
+	}
+	var postFps, threadFps []api.Fingerprint
+	for i, _ := range items {
+		if items[i].Type == "post" {
+			postFps = append(postFps, items[i].Fingerprint)
+		} else {
+			threadFps = append(threadFps, items[i].Fingerprint)
+		}
+	}
+	// Without fingerprints, these would read by time instead.
+	var posts []api.Post
+	var threads []api.Thread
+	if len(postFps) > 0 {
+		posts, err = persistence.ReadPosts(postFps, 0, 0)
+		if err != nil {
+			return result, "", err
+		}
+	}
+	if len(threadFps) > 0 {
+		threads, err = persistence.ReadThreads(threadFps, 0, 0)
+		if err != nil {
+			return result, "", err
+		}
+	}
+	postsByFp := make(map[api.Fingerprint]*api.Post)
+	for i, _ := range posts {
+		postsByFp[posts[i].Fingerprint] = &posts[i]
+	}
+	threadsByFp := make(map[api.Fingerprint]*api.Thread)
+	for i, _ := range threads {
+		threadsByFp[threads[i].Fingerprint] = &threads[i]
+	}
+	for i, _ := range items {
+		item := FeedItem{Type: items[i].Type}
+		if items[i].Type == "post" {
+			item.Post = postsByFp[items[i].Fingerprint]
+		} else {
+			item.Thread = threadsByFp[items[i].Fingerprint]
+		}
+		if item.Post == nil && item.Thread == nil {
+			// Deleted between the two reads.
+			continue
+		}
+		result = append(result, item)
+	}
+	var next string
+	if len(items) == limit {
+		next = EncodeCursor(items[len(items)-1])
+	}
+	return result, next, nil
+}
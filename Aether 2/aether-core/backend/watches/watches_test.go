@@ -0,0 +1,31 @@
+package watches_test
+
+import (
+	"aether-core/backend/watches"
+	"aether-core/io/persistence"
+	"testing"
+)
+
+func TestCursor_Success(t *testing.T) {
+	cursor := watches.EncodeCursor(persistence.DbWatchFeedItem{Fingerprint: "abc", Creation: 1500000000})
+	creation, fp, err := watches.DecodeCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creation != 1500000000 || fp != "abc" {
+		t.Errorf("The cursor does not point after the item it was made of. Creation: %d, Fingerprint: %s", creation, fp)
+	}
+	first, _, err2 := watches.DecodeCursor("")
+	if err2 != nil || first != 0 {
+		t.Errorf("An empty cursor is not the first page. Creation: %d, Error: %v", first, err2)
+	}
+}
+
+func TestCursor_Fail_Malformed(t *testing.T) {
+	for _, cursor := range []string{"!!", "bm9jb2xvbg", "eHg6YWJj"} {
+		_, _, err := watches.DecodeCursor(cursor)
+		if err == nil {
+			t.Errorf("A malformed cursor was read. Cursor: %s", cursor)
+		}
+	}
+}
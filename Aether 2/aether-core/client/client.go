@@ -0,0 +1,266 @@
+// Client
+// This package is the client side of the protocol, for the programs that talk to the nodes without being one, such as bots, mirrors and research tools. It does what the dispatcher of a node does when it syncs, with the same checks: the handshake, with the node response and its key and with the handshake endpoint of the nodes that take it, the POST requests with filters and the multipart responses they link to, the pages of a cursor, and the caches of the endpoints with their manifests. Nothing is written to a database; what arrives is given to the caller, who can check the signatures of the entities in it with Verify.
+//
+// A program that is not a node calls Setup once before anything else, so that the timeouts and the limits have their defaults:
+//
+//	client.Setup("mybot", 1, 0, 0)
+//	c := client.New("127.0.0.1", 49999)
+//	if _, err := c.Handshake(); err != nil { ... }
+//	resp, err := c.Query("threads", client.Query{Boards: []api.Fingerprint{board}})
+//	verified, rejected := client.Verify(resp, nil)
+
+package client
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/membership"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Setup sets the globals the protocol code reads to their defaults, and names the client in the headers of its requests. The client has no node id, and asks the nodes not to save its address. It is for the programs that are not nodes; within a node, the globals are set already, and this must not be called.
+func Setup(name string, major int, minor int, patch int) {
+	globals.SetGlobals()
+	globals.NodeId = ""
+	globals.AddressPort = 0
+	globals.ClientName = name
+	globals.ClientVersionMajor = major
+	globals.ClientVersionMinor = minor
+	globals.ClientVersionPatch = patch
+	globals.ProtocolExtensions = []string{"aether", "cursor", api.UnlistedExtension}
+}
+
+// Client talks to a single node.
+type Client struct {
+	Host    string
+	Subhost string
+	Port    uint16
+	Node    api.ApiResponse // The node response of the last handshake.
+	// What the handshakes exchanged in the last handshake settled, if the node takes them.
+	Connection *api.Connection
+}
+
+// New creates the client of the node at the host and the port. Nothing is sent until the handshake.
+func New(host string, port uint16) *Client {
+	return &Client{Host: host, Port: port}
+}
+
+// Handshake gets the node response, and checks that the node is in the same network. If the node signs its responses, its key is pinned, and every page that comes from it afterwards has to be signed with it. It has to be called before the other requests, and again if the node might have restarted with a new key.
+func (c *Client) Handshake() (api.ApiResponse, error) {
+	api.PinNodeKey(c.Host, c.Port, "")
+	resp, err := api.GetPageRaw(c.Host, c.Subhost, c.Port, "node", "GET", []byte{})
+	if err != nil {
+		return resp, err
+	}
+	if hasExtension(resp, api.SignedResponsesExtension) {
+		if len(resp.ResponseSignature) == 0 {
+			return resp, errors.New(fmt.Sprintf("The node announces signed responses, but its node response is not signed. Node: %s", resp.NodeId))
+		}
+		api.PinNodeKey(c.Host, c.Port, resp.NodePublicKey)
+	}
+	err2 := membership.Admit(resp.NetworkId, string(resp.NodeId), resp.MembershipProof)
+	if err2 != nil {
+		return resp, err2
+	}
+	c.Node = resp
+	c.Connection = nil
+	if hasExtension(resp, api.HandshakeExtension) {
+		conn, err3 := c.exchangeHandshakes()
+		if err3 != nil {
+			return resp, err3
+		}
+		c.Connection = &conn
+	}
+	return resp, nil
+}
+
+// exchangeHandshakes sends the handshake of the client to the node, and checks the one it sends back against the key of its node response. The handshake of the client is not signed, since it has no key.
+func (c *Client) exchangeHandshakes() (api.Connection, error) {
+	nonce := api.NewNonce()
+	local, err := api.NewHandshake(append([]string{}, globals.ProtocolExtensions...), nonce)
+	if err != nil {
+		return api.Connection{}, err
+	}
+	req := request(nil)
+	req.Handshake = &local
+	sentAt := clock.Now()
+	resp, err2 := api.GetPageBound(c.Host, c.Subhost, c.Port, "handshake", req)
+	if err2 != nil {
+		return api.Connection{}, err2
+	}
+	if resp.Handshake == nil || resp.Handshake.NodeId != c.Node.NodeId {
+		return api.Connection{}, errors.New(fmt.Sprintf("The node announces the handshake, but did not send its own. Node: %s", c.Node.NodeId))
+	}
+	err3 := api.VerifyHandshake(*resp.Handshake, api.PinnedNodeKey(c.Host, c.Port), nonce)
+	if err3 != nil {
+		return api.Connection{}, err3
+	}
+	conn := api.NewConnection(local, *resp.Handshake, sentAt, true)
+	return conn, conn.CheckClock()
+}
+
+// HasExtension checks whether the protocol extension can be used with the node. If the node takes handshakes, both sides have to have announced it in them; if not, the node has to have announced it in its node response.
+func (c *Client) HasExtension(extension string) bool {
+	if c.Connection != nil {
+		return c.Connection.Has(extension)
+	}
+	return hasExtension(c.Node, extension)
+}
+
+func hasExtension(resp api.ApiResponse, extension string) bool {
+	for _, ext := range resp.Address.Protocol.Extensions {
+		if ext == extension {
+			return true
+		}
+	}
+	return false
+}
+
+// Query is what a POST request asks for. The fields left empty don't limit the results.
+type Query struct {
+	Fingerprints []api.Fingerprint
+	Start        api.Timestamp // With End, the time range of the last changes of the entities.
+	End          api.Timestamp
+	Window       string            // A time range by its name, such as "last24h", worked out by the node, over Start and End. See api.WindowFilter.
+	Boards       []api.Fingerprint // The threads, the posts and the votes of these boards only. The nodes that don't know the filter ignore it.
+	Languages    []string          // The boards and the threads in these languages only. The nodes that don't know the filter ignore it.
+	Embeds       []string          // Such as "threads" on a boards query, to get the threads of the boards too.
+	Filters      []api.Filter      // Any other filter, as it is sent.
+}
+
+// filters gives the filters of the request.
+func (q Query) filters() []api.Filter {
+	var filters []api.Filter
+	if len(q.Fingerprints) > 0 {
+		f := api.Filter{Type: "fingerprint"}
+		for _, fp := range q.Fingerprints {
+			f.Values = append(f.Values, string(fp))
+		}
+		filters = append(filters, f)
+	}
+	if q.Start > 0 || q.End > 0 {
+		filters = append(filters, api.Filter{Type: "timestamp", Values: []string{strconv.FormatInt(int64(q.Start), 10), strconv.FormatInt(int64(q.End), 10)}})
+	}
+	// After the timestamp filter, so that the nodes that know the window use it instead.
+	if len(q.Window) > 0 {
+		filters = append(filters, api.Filter{Type: api.WindowFilter, Values: []string{q.Window}})
+	}
+	if len(q.Boards) > 0 {
+		f := api.Filter{Type: "board"}
+		for _, fp := range q.Boards {
+			f.Values = append(f.Values, string(fp))
+		}
+		filters = append(filters, f)
+	}
+	if len(q.Languages) > 0 {
+		filters = append(filters, api.Filter{Type: "language", Values: q.Languages})
+	}
+	if len(q.Embeds) > 0 {
+		filters = append(filters, api.Filter{Type: "embed", Values: q.Embeds})
+	}
+	return append(filters, q.Filters...)
+}
+
+// request creates the body of a POST request with the filters. It says who the client is the same way a node does, without an address to connect back to.
+func request(filters []api.Filter) api.ApiResponse {
+	var req api.ApiResponse
+	req.NodeId = api.Fingerprint(globals.NodeId)
+	req.NetworkId = globals.NetworkId
+	req.MembershipProof = membership.CreateProof(globals.NodeId, clock.Now())
+	req.Address.Port = uint16(globals.AddressPort)
+	req.Address.Protocol.VersionMajor = uint8(globals.ProtocolVersionMajor)
+	req.Address.Protocol.VersionMinor = uint16(globals.ProtocolVersionMinor)
+	req.Address.Protocol.Extensions = append([]string{}, globals.ProtocolExtensions...)
+	req.Address.Client.VersionMajor = uint8(globals.ClientVersionMajor)
+	req.Address.Client.VersionMinor = uint16(globals.ClientVersionMinor)
+	req.Address.Client.VersionPatch = uint16(globals.ClientVersionPatch)
+	req.Address.Client.ClientName = globals.ClientName
+	req.Filters = filters
+	return req
+}
+
+// Post sends a POST request to the endpoint, such as "posts", and gives the response as it arrived. The response is bound to the request, and signed with the pinned key if there is one. A response whose results didn't fit into it has links to its pages instead; Query follows them.
+func (c *Client) Post(endpoint string, filters []api.Filter) (api.ApiResponse, error) {
+	return api.GetPageBound(c.Host, c.Subhost, c.Port, endpoint, request(filters))
+}
+
+// Query asks the endpoint for the entities the query matches, and gives all of them: from the response itself, or from the pages it links to. The entities are checked against the inbound limits and the validation policy, but their signatures are not checked; see Verify.
+func (c *Client) Query(endpoint string, q Query) (api.Response, error) {
+	var resp api.Response
+	apiResp, err := c.Post(endpoint, q.filters())
+	if err != nil {
+		return resp, err
+	}
+	resp = api.InsertApiResponseToResponse(resp, apiResp)
+	if len(resp.CacheLinks) > 0 {
+		resp, err = api.GetPostResponseCache(c.Host, c.Subhost, c.Port, resp.CacheLinks)
+		if err != nil {
+			return resp, err
+		}
+	}
+	return api.FilterByPolicy(resp), nil
+}
+
+// EachPage asks the endpoint for the entities the query matches one page at a time, with a cursor, and gives every page to fn before asking for the next. It stops at the first error of fn. A node that doesn't announce the cursor extension gives everything at once, as Query does, in a single call of fn.
+func (c *Client) EachPage(endpoint string, q Query, fn func(api.Response) error) error {
+	if !c.HasExtension("cursor") {
+		resp, err := c.Query(endpoint, q)
+		if err != nil {
+			return err
+		}
+		return fn(resp)
+	}
+	cursor := ""
+	for {
+		filters := append(q.filters(), api.Filter{Type: "cursor", Values: []string{cursor}})
+		apiResp, err := c.Post(endpoint, filters)
+		if err != nil {
+			return err
+		}
+		var resp api.Response
+		resp = api.InsertApiResponseToResponse(resp, apiResp)
+		err2 := fn(api.FilterByPolicy(resp))
+		if err2 != nil {
+			return err2
+		}
+		next := apiResp.Pagination.NextCursor
+		if len(next) == 0 {
+			return nil
+		}
+		if next == cursor {
+			return errors.New(fmt.Sprintf("The node returned the same cursor twice. Cursor: %s", cursor))
+		}
+		cursor = next
+	}
+}
+
+// CacheIndex gives the links to the caches of the endpoint, from its cache index.
+func (c *Client) CacheIndex(endpoint string) ([]api.ResultCache, error) {
+	return api.GetCacheIndex(c.Host, c.Subhost, c.Port, endpoint)
+}
+
+// EachCache gets the caches of the endpoint that end at or after since, oldest first as the index lists them, and gives each to fn with its link. The pages of a cache are verified against its manifest, if it has one. It stops at the first error, of a cache or of fn; a cache that can't be read at all is given to fn as an error of its own rather than stopping the walk, since the other caches are still there.
+func (c *Client) EachCache(endpoint string, since api.Timestamp, fn func(api.ResultCache, api.Response, error) error) error {
+	links, err := c.CacheIndex(endpoint)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if link.EndsAt < since {
+			continue
+		}
+		cache, err2 := api.GetLinkedCache(c.Host, c.Subhost, c.Port, endpoint, link)
+		if api.IsLimitError(err2) {
+			// A node going over the limits is not a missing cache. Nothing more from it is taken.
+			return err2
+		}
+		err3 := fn(link, api.FilterByPolicy(cache), err2)
+		if err3 != nil {
+			return err3
+		}
+	}
+	return nil
+}
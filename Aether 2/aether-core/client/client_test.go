@@ -0,0 +1,170 @@
+package client_test
+
+import (
+	"aether-core/client"
+	"aether-core/io/api"
+	"aether-core/services/create"
+	"aether-core/services/globals"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Infrastructure, setup and teardown
+
+var key api.Key
+var thread api.Thread
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	client.Setup("client-test", 1, 0, 0)
+	var err error
+	key, err = create.CreateKey("", globals.MarshaledPubKey, "", *new([]api.CurrencyAddress), "")
+	if err != nil {
+		panic(err)
+	}
+	thread, err = create.CreateThread("board fp", "thread name", "thread body", "", key.Fingerprint)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func teardown() {
+}
+
+// node is a node that serves its node response, signed if it is asked to, and answers the POST requests to the threads endpoint with the threads given, bound to the request.
+type node struct {
+	signed  bool
+	forged  bool // Announces signed responses without signing its node response.
+	threads []api.Thread
+	request api.ApiResponse // The last POST request.
+}
+
+func (n *node) serve(t *testing.T) (*httptest.Server, string, uint16) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v0/node", func(w http.ResponseWriter, r *http.Request) {
+		var resp api.ApiResponse
+		resp.NodeId = api.Fingerprint(globals.NodeId)
+		resp.Timestamp = api.Timestamp(time.Now().Unix())
+		resp.Address.Protocol.Extensions = []string{"aether"}
+		if n.signed || n.forged {
+			resp.Address.Protocol.Extensions = append(resp.Address.Protocol.Extensions, api.SignedResponsesExtension)
+		}
+		if n.signed {
+			api.SignResponse(&resp)
+		}
+		data, _ := json.Marshal(resp)
+		w.Write(data)
+	})
+	mux.HandleFunc("/v0/threads", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &n.request)
+		var resp api.ApiResponse
+		resp.NodeId = api.Fingerprint(globals.NodeId)
+		resp.Timestamp = api.Timestamp(time.Now().Unix())
+		resp.Endpoint = "threads"
+		resp.ResponseBody.Threads = n.threads
+		resp.ResponseBody.Keys = []api.Key{key}
+		api.BindResponse(&resp, n.request.Nonce)
+		data, _ := json.Marshal(resp)
+		w.Write(data)
+	})
+	srv := httptest.NewServer(mux)
+	host, portStr, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		t.Fatalf("The port of the test server could not be read. Error: %s", err)
+	}
+	return srv, host, uint16(port)
+}
+
+// Tests
+
+func TestHandshake_Success(t *testing.T) {
+	n := node{signed: true}
+	srv, host, port := n.serve(t)
+	defer srv.Close()
+	c := client.New(host, port)
+	resp, err := c.Handshake()
+	if err != nil {
+		t.Fatalf("The handshake should have worked. Error: %s", err)
+	}
+	if resp.NodeId != api.Fingerprint(globals.NodeId) || !c.HasExtension(api.SignedResponsesExtension) {
+		t.Errorf("The node response of the handshake is not the one the node sent. Response: %#v", resp)
+	}
+	if api.PinnedNodeKey(host, port) != globals.MarshaledPubKey {
+		t.Errorf("The key of the node should have been pinned after the handshake.")
+	}
+}
+
+func TestHandshake_Fail_Unsigned(t *testing.T) {
+	n := node{forged: true}
+	srv, host, port := n.serve(t)
+	defer srv.Close()
+	c := client.New(host, port)
+	_, err := c.Handshake()
+	if err == nil {
+		t.Errorf("A node that announces signed responses but doesn't sign its node response should be refused.")
+	}
+	if len(api.PinnedNodeKey(host, port)) > 0 {
+		t.Errorf("No key should be pinned for a node that was refused.")
+	}
+}
+
+func TestQuery_Success(t *testing.T) {
+	broken := thread
+	broken.Body = "not what was signed"
+	n := node{signed: true, threads: []api.Thread{thread, broken}}
+	srv, host, port := n.serve(t)
+	defer srv.Close()
+	c := client.New(host, port)
+	_, err := c.Handshake()
+	if err != nil {
+		t.Fatalf("The handshake should have worked. Error: %s", err)
+	}
+	resp, err2 := c.Query("threads", client.Query{Boards: []api.Fingerprint{"board fp"}})
+	if err2 != nil {
+		t.Fatalf("The query should have worked. Error: %s", err2)
+	}
+	if len(n.request.Filters) != 1 || n.request.Filters[0].Type != "board" || n.request.Filters[0].Values[0] != "board fp" {
+		t.Errorf("The request should have carried the board filter. Filters: %#v", n.request.Filters)
+	}
+	if len(n.request.NodeId) > 0 || n.request.Address.Port != 0 {
+		t.Errorf("A client should not give a node id or a port to connect back to. Request: %#v", n.request)
+	}
+	if len(resp.Threads) != 2 {
+		t.Fatalf("Both threads should have arrived. Got: %d", len(resp.Threads))
+	}
+	verified, errs := client.Verify(resp, nil)
+	if len(verified.Threads) != 1 || verified.Threads[0].Fingerprint != thread.Fingerprint || len(errs) != 1 {
+		t.Errorf("Only the intact thread should have passed the verification. Threads: %d, Errors: %v", len(verified.Threads), errs)
+	}
+	if len(verified.Keys) != 1 {
+		t.Errorf("The key should have passed the verification. Keys: %d", len(verified.Keys))
+	}
+}
+
+func TestVerify_Fail_UnknownKey(t *testing.T) {
+	resp := api.Response{Threads: []api.Thread{thread}}
+	verified, errs := client.Verify(resp, nil)
+	if len(verified.Threads) != 0 || len(errs) != 1 {
+		t.Errorf("A thread whose key is not known should not pass the verification. Threads: %d, Errors: %v", len(verified.Threads), errs)
+	}
+	lookup := func(fp api.Fingerprint) (api.Key, bool) { return key, fp == key.Fingerprint }
+	verified2, errs2 := client.Verify(resp, lookup)
+	if len(verified2.Threads) != 1 || len(errs2) != 0 {
+		t.Errorf("A thread whose key the lookup gives should pass the verification. Threads: %d, Errors: %v", len(verified2.Threads), errs2)
+	}
+}
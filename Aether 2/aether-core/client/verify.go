@@ -0,0 +1,169 @@
+// Client > Verify
+// This file checks the entities a client received, as the verify service does for a node, but without a database: the keys of the owners come from the response itself, or from the caller. The checks are the same ones: the fingerprint, the proof of work, and the signature with the key of the owner, which has to pass the same checks itself.
+
+package client
+
+import (
+	"aether-core/io/api"
+	"errors"
+	"fmt"
+)
+
+// KeyLookup gives the key with the fingerprint, if the caller has it from before, such as from an earlier response. It is asked only for the keys that are not in the response being verified.
+type KeyLookup func(fp api.Fingerprint) (api.Key, bool)
+
+// findKey finds the key of the owner, first in the response, then with the lookup, and checks it.
+func findKey(owner api.Fingerprint, resp api.Response, lookup KeyLookup) (api.Key, error) {
+	var key api.Key
+	if len(owner) == 0 {
+		// An anonymous entity has no key to sign it with.
+		return key, nil
+	}
+	found := false
+	for _, k := range resp.Keys {
+		if k.Fingerprint == owner {
+			key = k
+			found = true
+			break
+		}
+	}
+	if !found && lookup != nil {
+		key, found = lookup(owner)
+	}
+	if !found {
+		return key, errors.New(fmt.Sprintf("The key of the owner is neither in the response nor known. Key: %s", owner))
+	}
+	err := checkEntity(&key, key)
+	if err != nil {
+		return key, errors.New(fmt.Sprintf("The key of the owner failed the verification. Key: %s, Error: %s", owner, err))
+	}
+	return key, nil
+}
+
+// checkEntity checks the fingerprint, the proof of work and the signature of the entity with the key.
+func checkEntity(entity api.Provable, key api.Key) error {
+	if !entity.VerifyFingerprint() {
+		return errors.New(fmt.Sprintf("The fingerprint of the entity is invalid. Fingerprint: %s", entity.GetFingerprint()))
+	}
+	powOk, err := entity.VerifyPoW(key.Key)
+	if err != nil {
+		return err
+	}
+	if !powOk {
+		return errors.New(fmt.Sprintf("The proof of work of the entity is invalid. Fingerprint: %s", entity.GetFingerprint()))
+	}
+	if entity.GetOwner() != key.Fingerprint {
+		return errors.New(fmt.Sprintf("The entity is not signed by the key given for it. Fingerprint: %s, Owner: %s, Key: %s", entity.GetFingerprint(), entity.GetOwner(), key.Fingerprint))
+	}
+	sigOk, err2 := entity.VerifySignature(key.Key)
+	if err2 != nil {
+		return err2
+	}
+	if !sigOk {
+		return errors.New(fmt.Sprintf("The signature of the entity is invalid. Fingerprint: %s", entity.GetFingerprint()))
+	}
+	return nil
+}
+
+// verifyEntity checks the entity with the key of its owner.
+func verifyEntity(entity api.Provable, resp api.Response, lookup KeyLookup) error {
+	key, err := findKey(entity.GetOwner(), resp, lookup)
+	if err != nil {
+		return errors.New(fmt.Sprintf("The entity could not be verified. Fingerprint: %s, Error: %s", entity.GetFingerprint(), err))
+	}
+	return checkEntity(entity, key)
+}
+
+// checkTombstoneTarget checks that the target of the tombstone, if it is in the response, has the same owner as the tombstone. A target that is not in the response can't be checked here; the caller that has it should compare the owners itself.
+func checkTombstoneTarget(resp api.Response, tomb api.Tombstone) error {
+	if len(tomb.Owner) == 0 {
+		return errors.New(fmt.Sprintf("Tombstones cannot be anonymous. Tombstone: %s", tomb.Fingerprint))
+	}
+	var targetOwner api.Fingerprint
+	found := false
+	switch tomb.TargetType {
+	case "threads":
+		for _, t := range resp.Threads {
+			if t.Fingerprint == tomb.Target {
+				targetOwner, found = t.Owner, true
+				break
+			}
+		}
+	case "posts":
+		for _, p := range resp.Posts {
+			if p.Fingerprint == tomb.Target {
+				targetOwner, found = p.Owner, true
+				break
+			}
+		}
+	default:
+		return errors.New(fmt.Sprintf("This tombstone has an invalid target type. Tombstone: %s, Target type: %s", tomb.Fingerprint, tomb.TargetType))
+	}
+	if found && targetOwner != tomb.Owner {
+		return errors.New(fmt.Sprintf("This tombstone is not owned by the owner of its target. Tombstone: %s, Target owner: %s", tomb.Fingerprint, targetOwner))
+	}
+	return nil
+}
+
+// Verify gives the entities of the response that pass the verification, and an error for each that doesn't. The keys of the owners are looked for in the response first, then with the lookup, which can be nil. The indexes, the addresses and the cache links are not signed, and are given as they are.
+func Verify(resp api.Response, lookup KeyLookup) (api.Response, []error) {
+	var errs []error
+	verified := resp
+	verified.Boards, verified.Threads, verified.Posts, verified.Votes = nil, nil, nil, nil
+	verified.Keys, verified.Truststates, verified.Tombstones = nil, nil, nil
+	for i, _ := range resp.Boards {
+		if err := verifyEntity(&resp.Boards[i], resp, lookup); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		verified.Boards = append(verified.Boards, resp.Boards[i])
+	}
+	for i, _ := range resp.Threads {
+		if err := verifyEntity(&resp.Threads[i], resp, lookup); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		verified.Threads = append(verified.Threads, resp.Threads[i])
+	}
+	for i, _ := range resp.Posts {
+		if err := verifyEntity(&resp.Posts[i], resp, lookup); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		verified.Posts = append(verified.Posts, resp.Posts[i])
+	}
+	for i, _ := range resp.Votes {
+		if err := verifyEntity(&resp.Votes[i], resp, lookup); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		verified.Votes = append(verified.Votes, resp.Votes[i])
+	}
+	for i, _ := range resp.Keys {
+		// A key is its own owner.
+		if err := checkEntity(&resp.Keys[i], resp.Keys[i]); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		verified.Keys = append(verified.Keys, resp.Keys[i])
+	}
+	for i, _ := range resp.Truststates {
+		if err := verifyEntity(&resp.Truststates[i], resp, lookup); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		verified.Truststates = append(verified.Truststates, resp.Truststates[i])
+	}
+	for i, _ := range resp.Tombstones {
+		if err := verifyEntity(&resp.Tombstones[i], resp, lookup); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := checkTombstoneTarget(resp, resp.Tombstones[i]); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		verified.Tombstones = append(verified.Tombstones, resp.Tombstones[i])
+	}
+	return verified, errs
+}
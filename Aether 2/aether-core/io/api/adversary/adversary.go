@@ -0,0 +1,247 @@
+// API > Adversary
+// This package is a remote that misbehaves, for the tests of the network layer. It answers like a node, serving the index of the posts and a cache of them, and then lies in one of the ways a malicious remote could: malformed pages, wrong page counts, lying cache indexes, responses sent a byte at a time, and redirect chains. It is only used by tests.
+
+package adversary
+
+import (
+	"aether-core/io/api"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Behavior is how the remote misbehaves.
+type Behavior int
+
+const (
+	Honest            Behavior = iota // Serves the cache as a node would.
+	MalformedPage                     // Serves the pages of the cache after the first cut off in the middle.
+	InflatedPageCount                 // Claims the cache has more pages than any cache could, and serves every page asked for.
+	ShiftingPageCount                 // Gives a different page count on every page.
+	WrongPageNumbers                  // Serves the first page whichever page is asked for.
+	LyingIndex                        // Serves the index given in Index instead of the real one.
+	SlowLoris                         // Sends its responses a byte every SlowInterval.
+	RedirectLoop                      // Redirects every request back to itself.
+	OffsiteRedirect                   // Redirects every request to another host.
+	InflatedEntities                  // Claims the cache has more entities than its pages can hold.
+	MissingPage                       // Serves no page 1 of the cache.
+)
+
+// CacheName is the name of the cache the remote serves.
+const CacheName = "cache_0"
+
+// Remote is a remote that misbehaves.
+type Remote struct {
+	Behavior     Behavior
+	Pages        int               // How many pages the cache has. Every page has one post.
+	Index        []api.ResultCache // The index served by LyingIndex.
+	SlowInterval time.Duration     // How long SlowLoris waits between bytes.
+	Legacy       bool              // Serves the pagination of the older versions, without the totals.
+	Manifest     bool              // Gives the cache a manifest, and its hash in the index.
+	created      api.Timestamp
+	server       *httptest.Server
+	lock         sync.Mutex
+	requests     map[string]int
+	lastHeader   http.Header
+}
+
+// New starts a remote with the given behaviour, serving a cache of three pages.
+func New(b Behavior) *Remote {
+	r := &Remote{Behavior: b, Pages: 3, SlowInterval: 50 * time.Millisecond, created: api.Timestamp(time.Now().Unix()), requests: make(map[string]int)}
+	r.server = httptest.NewServer(r)
+	return r
+}
+
+// Close stops the remote.
+func (r *Remote) Close() {
+	r.server.Close()
+}
+
+// Host is the address the remote listens on.
+func (r *Remote) Host() string {
+	host, _, _ := net.SplitHostPort(r.server.Listener.Addr().String())
+	return host
+}
+
+// Port is the port the remote listens on.
+func (r *Remote) Port() uint16 {
+	return uint16(r.server.Listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Requests gives how many times the path was asked for, such as "posts/cache_0/1.json".
+func (r *Remote) Requests(path string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.requests[path]
+}
+
+// TotalRequests gives how many requests the remote was sent.
+func (r *Remote) TotalRequests() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	total := 0
+	for _, count := range r.requests {
+		total += count
+	}
+	return total
+}
+
+// LastHeader gives the headers of the last request the remote was sent.
+func (r *Remote) LastHeader() http.Header {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.lastHeader
+}
+
+// PostFingerprint is the fingerprint of the post on the given page of the cache.
+func PostFingerprint(page int) api.Fingerprint {
+	return api.Fingerprint(fmt.Sprintf("%064d", page))
+}
+
+func (r *Remote) index() api.ApiResponse {
+	var resp api.ApiResponse
+	resp.Timestamp = api.Timestamp(time.Now().Unix())
+	if r.Behavior == LyingIndex {
+		resp.Results = r.Index
+		return resp
+	}
+	resp.Results = []api.ResultCache{{ResponseUrl: CacheName, StartsFrom: 0, EndsAt: resp.Timestamp}}
+	if r.Manifest {
+		resp.Results[0].Manifest = api.HashBytes(r.manifest())
+	}
+	return resp
+}
+
+// manifest gives the manifest of the cache, which lists the pages as an honest remote would serve them.
+func (r *Remote) manifest() []byte {
+	m := api.CacheManifest{Cache: CacheName, Timestamp: r.created}
+	for i := 0; i < r.Pages; i++ {
+		data, _ := json.Marshal(r.page(i, false))
+		m.Pages = append(m.Pages, api.ManifestPage{Name: fmt.Sprint(i, ".json"), Size: int64(len(data)), Sha256: api.HashBytes(data)})
+	}
+	data, _ := json.Marshal(m)
+	return data
+}
+
+// page gives the page of the cache. The caches give the number of their last page as their page count, and the POST responses the count of their pages; both give the count in the total, unless the remote is Legacy.
+func (r *Remote) page(n int, postResponse bool) api.ApiResponse {
+	var resp api.ApiResponse
+	// The pages come out the same every time, so that they match the manifest.
+	resp.Timestamp = r.created
+	resp.Pagination.Pages = uint64(r.Pages - 1)
+	if postResponse {
+		resp.Pagination.Pages = uint64(r.Pages)
+	}
+	resp.Pagination.CurrentPage = uint64(n)
+	if !r.Legacy {
+		resp.Pagination.TotalPages = uint64(r.Pages)
+		resp.Pagination.TotalEntities = uint64(r.Pages)
+		resp.Pagination.PageSize = 1
+	}
+	switch r.Behavior {
+	case InflatedPageCount:
+		resp.Pagination.Pages = 1 << 40
+		if !r.Legacy {
+			resp.Pagination.TotalPages = 1 << 40
+		}
+	case ShiftingPageCount:
+		resp.Pagination.Pages += uint64(n)
+		if !r.Legacy {
+			resp.Pagination.TotalPages += uint64(n)
+		}
+	case InflatedEntities:
+		resp.Pagination.TotalEntities = 1 << 40
+	case WrongPageNumbers:
+		resp.Pagination.CurrentPage = 0
+		n = 0
+	}
+	var p api.Post
+	p.Fingerprint = PostFingerprint(n)
+	p.Body = fmt.Sprint("Page ", n)
+	resp.ResponseBody.Posts = []api.Post{p}
+	return resp
+}
+
+// pageNumber gives the number of the page at the path, such as 1 for "posts/cache_0/1.json", or -1 if the path is not a page of the cache. The same pages are served as the pages of a POST response, in the folder CacheName.
+func pageNumber(path string) int {
+	path = strings.TrimPrefix(path, "posts/")
+	prefix := fmt.Sprint(CacheName, "/")
+	if !strings.HasPrefix(path, prefix) || !strings.HasSuffix(path, ".json") {
+		return -1
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, prefix), ".json"))
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+func (r *Remote) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, "/v0/")
+	r.lock.Lock()
+	r.requests[path]++
+	r.lastHeader = req.Header
+	r.lock.Unlock()
+	switch r.Behavior {
+	case RedirectLoop:
+		http.Redirect(w, req, fmt.Sprint(req.URL.Path, "?r=", r.Requests(path)), http.StatusFound)
+		return
+	case OffsiteRedirect:
+		http.Redirect(w, req, fmt.Sprint("http://localhost:", r.Port(), req.URL.Path), http.StatusFound)
+		return
+	}
+	var resp api.ApiResponse
+	if path == "status" {
+		w.WriteHeader(http.StatusOK)
+		return
+	} else if path == "node" {
+		resp.NodeId = PostFingerprint(0)
+		resp.Timestamp = api.Timestamp(time.Now().Unix())
+	} else if path == "posts/index.json" {
+		resp = r.index()
+	} else if path == fmt.Sprint("posts/", CacheName, "/", api.ManifestFile) && r.Manifest {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(r.manifest())
+		return
+	} else if n := pageNumber(path); n != -1 && (n < r.Pages || r.Behavior == InflatedPageCount) && !(n == 1 && r.Behavior == MissingPage) {
+		resp = r.page(n, !strings.HasPrefix(path, "posts/"))
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	data, _ := json.Marshal(resp)
+	if r.Behavior == MalformedPage && pageNumber(path) > 0 {
+		data = data[:len(data)/2]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Behavior == SlowLoris {
+		r.trickle(w, req, data)
+		return
+	}
+	w.Write(data)
+}
+
+// trickle sends the data a byte at a time, until the client gives up.
+func (r *Remote) trickle(w http.ResponseWriter, req *http.Request, data []byte) {
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for i, _ := range data {
+		if _, err := w.Write(data[i : i+1]); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-time.After(r.SlowInterval):
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
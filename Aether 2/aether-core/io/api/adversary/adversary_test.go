@@ -0,0 +1,298 @@
+package adversary_test
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/api/adversary"
+	"aether-core/services/globals"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+}
+
+func teardown() {
+}
+
+func getCache(r *adversary.Remote) (api.Response, error) {
+	return api.GetCache(r.Host(), "", r.Port(), "posts/"+adversary.CacheName)
+}
+
+// Tests
+
+func TestGetCache_Success(t *testing.T) {
+	r := adversary.New(adversary.Honest)
+	defer r.Close()
+	resp, err := getCache(r)
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	if len(resp.Posts) != 3 {
+		t.Errorf("Every page of the cache should have been downloaded. Posts: %d", len(resp.Posts))
+	}
+}
+
+func TestGetCache_Legacy_Success(t *testing.T) {
+	r := adversary.New(adversary.Honest)
+	r.Legacy = true
+	defer r.Close()
+	resp, err := getCache(r)
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	if len(resp.Posts) != 3 {
+		t.Errorf("The cache of an older version should still be downloaded in full. Posts: %d", len(resp.Posts))
+	}
+}
+
+func TestGetCache_TotalPages_Success(t *testing.T) {
+	r := adversary.New(adversary.Honest)
+	defer r.Close()
+	// The first page of the POST responses gives the count of the pages, and without the total, the page after it would be asked for too.
+	_, err := api.GetCache(r.Host(), "", r.Port(), adversary.CacheName)
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	if r.Requests(adversary.CacheName+"/3.json") != 0 {
+		t.Errorf("With the total page count, no page after the last one should be asked for.")
+	}
+	r.Legacy = true
+	api.GetCache(r.Host(), "", r.Port(), adversary.CacheName)
+	if r.Requests(adversary.CacheName+"/3.json") != 1 {
+		t.Errorf("Without the total page count, the page after the count should be asked for.")
+	}
+}
+
+func TestGetEndpoint_Success(t *testing.T) {
+	r := adversary.New(adversary.Honest)
+	defer r.Close()
+	resp, err := api.GetEndpoint(r.Host(), "", r.Port(), "posts", 0)
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	if len(resp.Posts) != 3 {
+		t.Errorf("Every page of the cache should have been downloaded. Posts: %d", len(resp.Posts))
+	}
+}
+
+func TestGetEndpoint_Manifest_Success(t *testing.T) {
+	r := adversary.New(adversary.Honest)
+	r.Manifest = true
+	defer r.Close()
+	resp, err := api.GetEndpoint(r.Host(), "", r.Port(), "posts", 0)
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	if len(resp.Posts) != 3 || r.Requests("posts/cache_0/"+api.ManifestFile) != 1 {
+		t.Errorf("The manifest and every page it lists should have been downloaded. Posts: %d", len(resp.Posts))
+	}
+}
+
+func TestGetEndpoint_Fail_MissingPage(t *testing.T) {
+	r := adversary.New(adversary.MissingPage)
+	defer r.Close()
+	resp, err := api.GetEndpoint(r.Host(), "", r.Port(), "posts", 0)
+	if err != nil || len(resp.Posts) != 2 {
+		t.Errorf("Without a manifest, a missing page is skipped. Posts: %d, Error: %v", len(resp.Posts), err)
+	}
+	r.Manifest = true
+	_, err2 := api.GetCache(r.Host(), "", r.Port(), "posts/"+adversary.CacheName)
+	if err2 != nil {
+		t.Errorf("A cache fetched without its index has no manifest, so a missing page is skipped. Error: %v", err2)
+	}
+	resp2, _ := api.GetEndpoint(r.Host(), "", r.Port(), "posts", 0)
+	if len(resp2.Posts) != 1 || r.Requests("posts/cache_0/2.json") != 2 {
+		t.Errorf("A page missing from the manifest should stop the download of the cache. Posts: %d", len(resp2.Posts))
+	}
+}
+
+func TestGetEndpoint_Fail_CorruptPage(t *testing.T) {
+	r := adversary.New(adversary.MalformedPage)
+	r.Manifest = true
+	defer r.Close()
+	resp, _ := api.GetEndpoint(r.Host(), "", r.Port(), "posts", 0)
+	if len(resp.Posts) != 1 {
+		t.Errorf("Only the pages before the one that doesn't match the manifest should be kept. Posts: %d", len(resp.Posts))
+	}
+	if _, err := api.ParseManifest([]byte(`{"cache": "cache_0", "pages": [{"name": "1.json", "size": 10, "sha256": "00"}]}`), ""); !api.IsLimitError(err) {
+		t.Errorf("A manifest that doesn't list the pages of a cache should be refused. Error: %v", err)
+	}
+}
+
+func TestGetCache_Fail_MalformedPage(t *testing.T) {
+	r := adversary.New(adversary.MalformedPage)
+	defer r.Close()
+	resp, err := getCache(r)
+	if err == nil || !strings.Contains(err.Error(), "malformed") {
+		t.Errorf("A malformed page should fail the download. Error: %v", err)
+	}
+	if len(resp.Posts) != 1 {
+		t.Errorf("What arrived before the malformed page should be kept. Posts: %d", len(resp.Posts))
+	}
+}
+
+func TestGetCache_Fail_InflatedPageCount(t *testing.T) {
+	r := adversary.New(adversary.InflatedPageCount)
+	defer r.Close()
+	_, err := getCache(r)
+	if !api.IsLimitError(err) {
+		t.Errorf("A cache claiming too many pages should be refused as over the limits. Error: %v", err)
+	}
+	if r.TotalRequests() != 1 {
+		t.Errorf("Nothing after the first page should have been asked for. Requests: %d", r.TotalRequests())
+	}
+}
+
+func TestGetCache_Fail_InflatedEntities(t *testing.T) {
+	r := adversary.New(adversary.InflatedEntities)
+	defer r.Close()
+	_, err := getCache(r)
+	if !api.IsLimitError(err) {
+		t.Errorf("A cache claiming more entities than its pages hold should be refused. Error: %v", err)
+	}
+	if r.TotalRequests() != 1 {
+		t.Errorf("Nothing after the first page should have been asked for. Requests: %d", r.TotalRequests())
+	}
+}
+
+func TestGetCache_Fail_ShiftingPageCount(t *testing.T) {
+	r := adversary.New(adversary.ShiftingPageCount)
+	defer r.Close()
+	resp, err := getCache(r)
+	if !api.IsLimitError(err) {
+		t.Errorf("A page count changing within the cache should be refused. Error: %v", err)
+	}
+	if len(resp.Posts) != 1 || r.Requests("posts/cache_0/2.json") != 0 {
+		t.Errorf("Nothing after the first inconsistent page should be taken or asked for. Posts: %d", len(resp.Posts))
+	}
+}
+
+func TestGetCache_Fail_WrongPageNumbers(t *testing.T) {
+	r := adversary.New(adversary.WrongPageNumbers)
+	defer r.Close()
+	_, err := getCache(r)
+	if !api.IsLimitError(err) {
+		t.Errorf("A page other than the one asked for should be refused. Error: %v", err)
+	}
+}
+
+func TestGetPostResponseCache_Success(t *testing.T) {
+	r := adversary.New(adversary.Honest)
+	defer r.Close()
+	links := []api.ResultCache{{ResponseUrl: adversary.CacheName}, {ResponseUrl: adversary.CacheName}, {ResponseUrl: adversary.CacheName}}
+	resp, err := api.GetPostResponseCache(r.Host(), "", r.Port(), links)
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	if len(resp.Posts) != 3 || r.Requests(adversary.CacheName+"/3.json") != 0 {
+		t.Errorf("Exactly the linked pages should have been downloaded. Posts: %d", len(resp.Posts))
+	}
+}
+
+func TestGetPostResponseCache_Fail_LinksDisagree(t *testing.T) {
+	r := adversary.New(adversary.Honest)
+	defer r.Close()
+	// The remote links to five pages, but the pages say there are three.
+	var links []api.ResultCache
+	for i := 0; i < 5; i++ {
+		links = append(links, api.ResultCache{ResponseUrl: adversary.CacheName})
+	}
+	_, err := api.GetPostResponseCache(r.Host(), "", r.Port(), links)
+	if !api.IsLimitError(err) {
+		t.Errorf("A page count that doesn't match the links should be refused. Error: %v", err)
+	}
+	links[1].ResponseUrl = "../admin"
+	if err := api.CheckPostResponseLinks(links); !api.IsLimitError(err) {
+		t.Errorf("Links to more than one folder, or out of the folder, should be refused. Error: %v", err)
+	}
+}
+
+func TestGetEndpoint_Fail_LyingIndex(t *testing.T) {
+	now := api.Timestamp(time.Now().Unix())
+	indexes := map[string][]api.ResultCache{
+		"traversal": {{ResponseUrl: "../../admin", StartsFrom: 0, EndsAt: now}},
+		"future":    {{ResponseUrl: adversary.CacheName, StartsFrom: 0, EndsAt: now + 30*24*3600}},
+		"backwards": {{ResponseUrl: adversary.CacheName, StartsFrom: now, EndsAt: now - 10}},
+		"duplicate": {{ResponseUrl: adversary.CacheName, EndsAt: now}, {ResponseUrl: adversary.CacheName, EndsAt: now}},
+	}
+	for name, index := range indexes {
+		r := adversary.New(adversary.LyingIndex)
+		r.Index = index
+		_, err := api.GetEndpoint(r.Host(), "", r.Port(), "posts", 0)
+		if err == nil {
+			t.Errorf("A lying index should be refused. Case: %s", name)
+		}
+		if r.TotalRequests() != 1 {
+			t.Errorf("No cache of a lying index should be asked for. Case: %s, Requests: %d", name, r.TotalRequests())
+		}
+		r.Close()
+	}
+}
+
+func TestFetch_Fail_SlowLoris(t *testing.T) {
+	defer func(v time.Duration) { globals.ConnectionTimeout = v }(globals.ConnectionTimeout)
+	globals.ConnectionTimeout = 300 * time.Millisecond
+	r := adversary.New(adversary.SlowLoris)
+	defer r.Close()
+	start := time.Now()
+	_, err := getCache(r)
+	if err == nil || !strings.Contains(err.Error(), "too slowly") {
+		t.Errorf("A page sent a byte at a time should time out. Error: %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("The download should have been cut off at the timeout. Took: %s", time.Since(start))
+	}
+}
+
+func TestFetch_Fail_RedirectLoop(t *testing.T) {
+	r := adversary.New(adversary.RedirectLoop)
+	defer r.Close()
+	_, err := api.Fetch(r.Host(), "", r.Port(), "posts/index.json", "GET", []byte{})
+	if err == nil || !strings.Contains(err.Error(), "redirected more times") {
+		t.Errorf("A redirect loop should be cut off. Error: %v", err)
+	}
+	if requests := r.TotalRequests(); requests != globals.InboundMaxRedirects+1 {
+		t.Errorf("Only InboundMaxRedirects redirects should be followed. Requests: %d", requests)
+	}
+}
+
+func TestFetch_Fail_OffsiteRedirect(t *testing.T) {
+	r := adversary.New(adversary.OffsiteRedirect)
+	defer r.Close()
+	_, err := api.Fetch(r.Host(), "", r.Port(), "posts/index.json", "GET", []byte{})
+	if err == nil || !strings.Contains(err.Error(), "another host") {
+		t.Errorf("A redirect to another host should not be followed. Error: %v", err)
+	}
+}
+
+func TestGetCache_ClientHeaders_Success(t *testing.T) {
+	r := adversary.New(adversary.Honest)
+	defer r.Close()
+	if _, err := getCache(r); err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	c, found := api.ParseClientHeaders(r.LastHeader())
+	if !found || c.ClientName != globals.ClientName || !c.HasExtension("aether") {
+		t.Errorf("The requests to the remote should say which client sent them. Client: %#v", c)
+	}
+	globals.SendClientHeaders = false
+	defer func() { globals.SendClientHeaders = true }()
+	if _, err := getCache(r); err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	if c, found := api.ParseClientHeaders(r.LastHeader()); found {
+		t.Errorf("The requests should not say which client sent them when the headers are turned off. Client: %#v", c)
+	}
+}
@@ -1162,6 +1162,44 @@ func TestTruststateCreateUpdateSignature_Success(t *testing.T) {
 	}
 }
 
+// Inbound limits tests
+
+func setInboundLimits() {
+	globals.InboundMaxPageEntities = 2
+	globals.InboundMaxFieldBytes = 16
+}
+
+func TestCheckPageLimits_Success(t *testing.T) {
+	setInboundLimits()
+	var resp api.ApiResponse
+	resp.ResponseBody.Posts = []api.Post{api.Post{Body: "short body"}}
+	err := api.CheckPageLimits(&resp)
+	if err != nil {
+		t.Errorf("Test failed, err: '%s'", err)
+	}
+}
+
+func TestCheckPageLimits_Fail_TooManyEntities(t *testing.T) {
+	setInboundLimits()
+	var resp api.ApiResponse
+	resp.ResponseBody.Posts = []api.Post{api.Post{}, api.Post{}}
+	resp.ResponseBody.VoteIndexes = []api.VoteIndex{api.VoteIndex{}}
+	err := api.CheckPageLimits(&resp)
+	if !api.IsLimitError(err) {
+		t.Errorf("Test failed, a page with too many entities was accepted. Err: '%s'", err)
+	}
+}
+
+func TestCheckPageLimits_Fail_FieldTooLarge(t *testing.T) {
+	setInboundLimits()
+	var resp api.ApiResponse
+	resp.ResponseBody.Boards = []api.Board{api.Board{Description: strings.Repeat("a", 17)}}
+	err := api.CheckPageLimits(&resp)
+	if !api.IsLimitError(err) {
+		t.Errorf("Test failed, an entity with a field that is too large was accepted. Err: '%s'", err)
+	}
+}
+
 // Dispatch tests
 
 // TODO
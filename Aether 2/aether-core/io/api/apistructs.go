@@ -6,6 +6,7 @@ package api
 import (
 	"database/sql/driver"
 	// "fmt"
+	"aether-core/services/canonical"
 	"aether-core/services/fingerprinting"
 	"aether-core/services/globals"
 	"aether-core/services/proofofwork"
@@ -16,7 +17,7 @@ import (
 	"fmt"
 )
 
-// Structs for the entity types. There are 7 types. Board, Thread, Post, Vote, Key, Address, Truststate.
+// Structs for the entity types. There are 8 types. Board, Thread, Post, Vote, Key, Address, Truststate, Tombstone.
 
 // Low-level types
 
@@ -129,16 +130,20 @@ type Board struct {
 	BoardOwners []BoardOwner `json:"board_owners"` // max 100 owners
 	Description string       `json:"description"`  // Max 65535 char unicode
 	Owner       Fingerprint  `json:"owner"`
+	Language    string       `json:"language,omitempty"` // Language tag declared by the author, such as "en" or "pt-BR". Max 16 char.
+	Meta        Meta         `json:"meta,omitempty"`     // Metadata attached by the client. Mutable, like the description. See meta.go.
 	UpdateableFieldSet
 }
 
 type Thread struct {
 	ProvableFieldSet
-	Board Fingerprint `json:"board"`
-	Name  string      `json:"name"`
-	Body  string      `json:"body"`
-	Link  string      `json:"link"`
-	Owner Fingerprint `json:"owner"`
+	Board    Fingerprint `json:"board"`
+	Name     string      `json:"name"`
+	Body     string      `json:"body"`
+	Link     string      `json:"link"`
+	Owner    Fingerprint `json:"owner"`
+	Language string      `json:"language,omitempty"` // Language tag declared by the author, such as "en" or "pt-BR". Max 16 char.
+	Meta     Meta        `json:"meta,omitempty"`     // Metadata attached by the client. See meta.go.
 }
 
 type Post struct {
@@ -148,6 +153,7 @@ type Post struct {
 	Parent Fingerprint `json:"parent"`
 	Body   string      `json:"body"`
 	Owner  Fingerprint `json:"owner"`
+	Meta   Meta        `json:"meta,omitempty"` // Metadata attached by the client. See meta.go.
 }
 
 type Vote struct {
@@ -160,15 +166,40 @@ type Vote struct {
 	UpdateableFieldSet
 }
 
+// Types of the votes. Other types are kept and served like these, but they don't count towards the rankings.
+const (
+	VoteUp   = 1
+	VoteDown = 2
+)
+
 type Address struct {
-	Location     Location  `json:"location"`
-	Sublocation  Location  `json:"sublocation"`
-	LocationType uint8     `json:"location_type"`
-	Port         uint16    `json:"port"`
-	Type         uint8     `json:"type"`
-	LastOnline   Timestamp `json:"last_online"`
-	Protocol     Protocol  `json:"protocol"`
-	Client       Client    `json:"client"`
+	Location     Location          `json:"location"`
+	Sublocation  Location          `json:"sublocation"`
+	LocationType uint8             `json:"location_type"`
+	Port         uint16            `json:"port"`
+	Type         uint8             `json:"type"`
+	LastOnline   Timestamp         `json:"last_online"`
+	Protocol     Protocol          `json:"protocol"`
+	Client       Client            `json:"client"`
+	Endpoints    []AddressEndpoint `json:"endpoints,omitempty"` // max 10. Alternative ways to reach the same node.
+	Local        bool              `json:"-"`                   // Found on the local network. Local only, never sent to the remotes.
+}
+
+// Location types of address endpoints.
+const (
+	LocationTypeIPv4  = 4
+	LocationTypeIPv6  = 6
+	LocationTypeOnion = 3
+)
+
+// AddressEndpoint is an alternative way to reach a node, such as its IPv6 address, its onion address or its TLS port. Endpoints with lower priority numbers are tried first.
+type AddressEndpoint struct {
+	Location     Location `json:"location"`
+	Sublocation  Location `json:"sublocation"`
+	LocationType uint8    `json:"location_type"`
+	Port         uint16   `json:"port"`
+	TLS          bool     `json:"tls"`
+	Priority     uint8    `json:"priority"`
 }
 
 type Key struct {
@@ -191,10 +222,37 @@ type Truststate struct {
 	UpdateableFieldSet
 }
 
+// Tombstone is a deletion marker. It is published by the owner of a thread or a post, and it tells the nodes that receive it to hide the body of the target. The tombstone itself is kept and served, so that the deletion can propagate through the network.
+type Tombstone struct {
+	ProvableFieldSet
+	Target     Fingerprint `json:"target"`
+	TargetType string      `json:"target_type"` // "threads" or "posts"
+	Owner      Fingerprint `json:"owner"`
+}
+
+// PoWPolicy is the set of minimum proof of work strengths a node accepts, per entity type. Nodes advertise their policy in their responses so that the remotes can know in advance what will be rejected.
+type PoWPolicy struct {
+	Board            int64 `json:"board"`
+	BoardUpdate      int64 `json:"board_update"`
+	Thread           int64 `json:"thread"`
+	Post             int64 `json:"post"`
+	Vote             int64 `json:"vote"`
+	VoteUpdate       int64 `json:"vote_update"`
+	Key              int64 `json:"key"`
+	KeyUpdate        int64 `json:"key_update"`
+	Truststate       int64 `json:"truststate"`
+	TruststateUpdate int64 `json:"truststate_update"`
+	Tombstone        int64 `json:"tombstone"`
+}
+
 type ResultCache struct { // These are caches shown in the index endpoint of a particular entity.
-	ResponseUrl string    `json:"response_url"`
-	StartsFrom  Timestamp `json:"starts_from"`
-	EndsAt      Timestamp `json:"ends_at"`
+	ResponseUrl string             `json:"response_url"`
+	StartsFrom  Timestamp          `json:"starts_from"`
+	EndsAt      Timestamp          `json:"ends_at"`
+	MirrorUrl   string             `json:"mirror_url,omitempty"`  // Full URL of a copy of this cache on a CDN. The pages there are untrusted, they have to match the page hashes.
+	PageHashes  map[string]string  `json:"page_hashes,omitempty"` // Page file name ("0.json") -> SHA256 hex of its contents. These come from the origin, so they are as trustworthy as the origin.
+	Manifest    string             `json:"manifest,omitempty"`    // SHA256 hex of the manifest.json in the cache folder. Not given by the older versions.
+	Witnesses   []WitnessSignature `json:"witnesses,omitempty"`   // The signatures of the witnesses that saw the manifest. See witness.go.
 }
 
 // Index Form Entities: These are index forms of the entities above.
@@ -248,11 +306,22 @@ type TruststateIndex struct {
 	PageNumber  int         `json:"page_number"`
 }
 
+type TombstoneIndex struct {
+	Fingerprint Fingerprint `json:"fingerprint"`
+	Target      Fingerprint `json:"target"`
+	Creation    Timestamp   `json:"creation"`
+	PageNumber  int         `json:"page_number"`
+}
+
 // Response types
 
 type Pagination struct {
-	Pages       uint64 `json:"pages"`
-	CurrentPage uint64 `json:"current_page"`
+	Pages         uint64 `json:"pages"` // What the older versions read. The caches give the number of their last page here, the POST responses the count of their pages, unless the node has pagination_legacy_pages off.
+	CurrentPage   uint64 `json:"current_page"`
+	TotalPages    uint64 `json:"total_pages,omitempty"`    // The count of the pages. Not in cursor mode, and not from the older versions.
+	TotalEntities uint64 `json:"total_entities,omitempty"` // How many entities and indexes all the pages have together.
+	PageSize      int    `json:"page_size,omitempty"`      // The most entities a page can have.
+	NextCursor    string `json:"next_cursor,omitempty"`    // Only in cursor mode. Empty on the last page.
 }
 
 type Caching struct {
@@ -282,6 +351,56 @@ type Answer struct { // Bodies of API Endpoint responses from remote. This will
 	AddressIndexes    []AddressIndex    `json:"addresses_index,omitempty"`
 	Truststates       []Truststate      `json:"truststates,omitempty"`
 	TruststateIndexes []TruststateIndex `json:"truststates_index,omitempty"`
+	Tombstones        []Tombstone       `json:"tombstones,omitempty"`
+	TombstoneIndexes  []TombstoneIndex  `json:"tombstones_index,omitempty"`
+	VoteSummaries     []VoteSummary     `json:"vote_summaries,omitempty"`
+	fields            []string          // The fields of the entities it is written with, if not all of them. See fields.go.
+}
+
+// VoteSummary is the count of the votes of one type on one target, created before Until. A node that compacts its old votes serves these instead of the votes themselves. The summary is signed by the node that compacted the votes, not by the voters, so it is only as trustworthy as that node.
+type VoteSummary struct {
+	Target    Fingerprint `json:"target"`
+	Board     Fingerprint `json:"board"`
+	Thread    Fingerprint `json:"thread"`
+	Type      uint8       `json:"type"`
+	Count     int64       `json:"count"`
+	Until     Timestamp   `json:"until"`
+	Signer    string      `json:"signer"` // Public key of the node that compacted the votes.
+	Signature Signature   `json:"signature"`
+}
+
+// SigningInput is what the signature of the summary covers: its canonical JSON, without the signature.
+func (v *VoteSummary) SigningInput() string {
+	cpI := *v
+	cpI.Signature = ""
+	return canonical.String(cpI)
+}
+
+// VerifySignature checks that the summary is signed by its signer.
+func (v *VoteSummary) VerifySignature() bool {
+	return signaturing.Verify(v.SigningInput(), string(v.Signature), v.Signer)
+}
+
+// Statuses of the entities a remote submits to us.
+const (
+	EntityAccepted = "accepted"
+	EntityRejected = "rejected"
+)
+
+// Reasons of rejection. The submitter should not send a rejected entity again unless the reason is one it can fix, such as a storage failure.
+const (
+	RejectedOverLimits       = "over_limits"
+	RejectedInsufficientPoW  = "insufficient_proof_of_work"
+	RejectedUnverifiable     = "unverifiable"
+	RejectedNotOwnerOfTarget = "not_owner_of_target"
+	RejectedStorageFailure   = "storage_failure"
+)
+
+// EntityStatus tells the submitter of an entity whether it was accepted, and if not, why.
+type EntityStatus struct {
+	Fingerprint Fingerprint `json:"fingerprint"`
+	Status      string      `json:"status"`
+	Reason      string      `json:"reason,omitempty"`
 }
 
 // Response styles.
@@ -303,23 +422,37 @@ type Response struct {
 	AddressIndexes    []AddressIndex
 	Truststates       []Truststate
 	TruststateIndexes []TruststateIndex
+	Tombstones        []Tombstone
+	TombstoneIndexes  []TombstoneIndex
 	CacheLinks        []ResultCache
 }
 
 // ApiResponse is the blueprint of all requests and responses. This is the 'external' communication structure backend uses to talk to other backends.
 type ApiResponse struct {
-	NodeId       Fingerprint   `json:"node_id,omitempty"`
-	Address      Address       `json:"address,omitempty"`
-	Entity       string        `json:"entity,omitempty"`
-	Endpoint     string        `json:"endpoint,omitempty"`
-	Filters      []Filter      `json:"filters,omitempty"`
-	Timestamp    Timestamp     `json:"timestamp,omitempty"`
-	StartsFrom   Timestamp     `json:"starts_from,omitempty"`
-	EndsAt       Timestamp     `json:"ends_at,omitempty"`
-	Pagination   Pagination    `json:"pagination,omitempty"`
-	Caching      Caching       `json:"caching,omitempty"`
-	Results      []ResultCache `json:"results,omitempty"`  // Pages
-	ResponseBody Answer        `json:"response,omitempty"` // Entities, Full size or Index versions.
+	NodeId            Fingerprint     `json:"node_id,omitempty"`
+	NetworkId         string          `json:"network_id,omitempty"`       // Empty for the public network.
+	MembershipProof   string          `json:"membership_proof,omitempty"` // Proof of holding the membership key of a private network.
+	Address           Address         `json:"address,omitempty"`
+	Entity            string          `json:"entity,omitempty"`
+	Endpoint          string          `json:"endpoint,omitempty"`
+	Filters           []Filter        `json:"filters,omitempty"`
+	Timestamp         Timestamp       `json:"timestamp,omitempty"`
+	StartsFrom        Timestamp       `json:"starts_from,omitempty"`
+	EndsAt            Timestamp       `json:"ends_at,omitempty"`
+	Pagination        Pagination      `json:"pagination,omitempty"`
+	Caching           Caching         `json:"caching,omitempty"`
+	PoWPolicy         PoWPolicy       `json:"pow_policy"`
+	Results           []ResultCache   `json:"results,omitempty"`         // Pages
+	ResponseBody      Answer          `json:"response,omitempty"`        // Entities, Full size or Index versions.
+	TraceId           string          `json:"trace_id,omitempty"`        // Diagnostic. Identifies the request in the logs of both sides.
+	Statuses          []EntityStatus  `json:"statuses,omitempty"`        // Only when the request submitted entities. One per submitted entity.
+	SessionDigests    []SessionDigest `json:"session_digests,omitempty"` // Only in the response of the session endpoint. See digest.go.
+	Fingerprints      []FpManifest    `json:"fingerprints,omitempty"`    // Only in the response of the fingerprints endpoint. See fingerprints.go.
+	Handshake         *Handshake      `json:"handshake,omitempty"`       // Only in the requests and the responses of the handshake endpoint. See handshake.go.
+	Fields            []string        `json:"fields,omitempty"`          // The fields the entities of the response have, if the requester asked for only some. See fields.go.
+	Nonce             string          `json:"nonce,omitempty"`           // Chosen by the requester, echoed in the response. See binding.go.
+	NodePublicKey     string          `json:"node_public_key,omitempty"`
+	ResponseSignature Signature       `json:"response_signature,omitempty"` // Signature of the response by NodePublicKey, over everything else in it.
 }
 
 // // Interfaces
@@ -366,6 +499,7 @@ func (entity *Post) GetFingerprint() Fingerprint       { return entity.Fingerpri
 func (entity *Vote) GetFingerprint() Fingerprint       { return entity.Fingerprint }
 func (entity *Key) GetFingerprint() Fingerprint        { return entity.Fingerprint }
 func (entity *Truststate) GetFingerprint() Fingerprint { return entity.Fingerprint }
+func (entity *Tombstone) GetFingerprint() Fingerprint  { return entity.Fingerprint }
 
 // Signature accessors
 
@@ -375,6 +509,7 @@ func (entity *Post) GetSignature() Signature       { return entity.Signature }
 func (entity *Vote) GetSignature() Signature       { return entity.Signature }
 func (entity *Key) GetSignature() Signature        { return entity.Signature }
 func (entity *Truststate) GetSignature() Signature { return entity.Signature }
+func (entity *Tombstone) GetSignature() Signature  { return entity.Signature }
 
 // UpdateSignature accessors
 
@@ -391,6 +526,7 @@ func (entity *Post) GetProofOfWork() ProofOfWork       { return entity.ProofOfWo
 func (entity *Vote) GetProofOfWork() ProofOfWork       { return entity.ProofOfWork }
 func (entity *Key) GetProofOfWork() ProofOfWork        { return entity.ProofOfWork }
 func (entity *Truststate) GetProofOfWork() ProofOfWork { return entity.ProofOfWork }
+func (entity *Tombstone) GetProofOfWork() ProofOfWork  { return entity.ProofOfWork }
 
 // UpdateProofOfWork accessors
 
@@ -409,10 +545,60 @@ func (entity *Vote) GetOwner() Fingerprint   { return entity.Owner }
 // Owner of the key is itself.
 func (entity *Key) GetOwner() Fingerprint        { return entity.Fingerprint }
 func (entity *Truststate) GetOwner() Fingerprint { return entity.Owner }
+func (entity *Tombstone) GetOwner() Fingerprint  { return entity.Owner }
+
+// // Entity forms
+
+// entityForms gives the JSON forms of an entity that its fingerprint, proof of work and signatures can have been made over. They are made over the JSON of the struct, in the order of its fields, which every node on the network checks them against, so that the nodes that know no other form take what this node makes. The canonical form, with the keys sorted (see the canonical package), is accepted too, for the entities that were made over it.
+func entityForms(cpI interface{}) []string {
+	legacy, _ := json.Marshal(cpI)
+	forms := []string{string(legacy)}
+	canon, err := canonical.Encode(cpI)
+	if err == nil && string(canon) != string(legacy) {
+		forms = append(forms, string(canon))
+	}
+	return forms
+}
+
+// verifyPoWForms verifies the proof of work against each form of the entity, and gives the first that is valid. If none is, the error of the first form is given.
+func verifyPoWForms(forms []string, pow string, pubKey string) (bool, int64, error) {
+	var firstErr error
+	for i, _ := range forms {
+		verifyResult, strength, err := proofofwork.Verify(forms[i], pow, pubKey)
+		if verifyResult {
+			return true, strength, nil
+		}
+		if i == 0 {
+			firstErr = err
+		}
+	}
+	return false, 0, firstErr
+}
+
+// verifyFingerprintForms tells whether the fingerprint is that of any form of the entity.
+func verifyFingerprintForms(forms []string, fp string) bool {
+	for i, _ := range forms {
+		if fingerprinting.Verify(forms[i], fp) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySignatureForms tells whether the signature is over any form of the entity.
+func verifySignatureForms(forms []string, signature string, pubKey string) bool {
+	for i, _ := range forms {
+		if signaturing.Verify(forms[i], signature, pubKey) {
+			return true
+		}
+	}
+	return false
+}
 
 // // Create ProofOfWork
 
-func (b *Board) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
+// PoWInput gives the JSON the proof of work of the board is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (b *Board) PoWInput() string {
 	cpI := *b
 	// Updateable
 	cpI.Fingerprint = ""
@@ -423,8 +609,12 @@ func (b *Board) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	cpI.ProofOfWork = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (b *Board) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	// Create PoW
-	pow, err := proofofwork.Create(string(res), difficulty, keyPair)
+	pow, err := proofofwork.Create(b.PoWInput(), difficulty, keyPair)
 	if err != nil {
 		return err
 	}
@@ -432,7 +622,8 @@ func (b *Board) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	return nil
 }
 
-func (t *Thread) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
+// PoWInput gives the JSON the proof of work of the thread is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (t *Thread) PoWInput() string {
 	cpI := *t
 	// Non-updateable
 	cpI.Fingerprint = ""
@@ -440,8 +631,12 @@ func (t *Thread) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	cpI.ProofOfWork = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (t *Thread) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	// Create PoW
-	pow, err := proofofwork.Create(string(res), difficulty, keyPair)
+	pow, err := proofofwork.Create(t.PoWInput(), difficulty, keyPair)
 	if err != nil {
 		return err
 	}
@@ -449,7 +644,8 @@ func (t *Thread) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	return nil
 }
 
-func (p *Post) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
+// PoWInput gives the JSON the proof of work of the post is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (p *Post) PoWInput() string {
 	cpI := *p
 	// Non-updateable
 	cpI.Fingerprint = ""
@@ -457,8 +653,12 @@ func (p *Post) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	cpI.ProofOfWork = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (p *Post) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	// Create PoW
-	pow, err := proofofwork.Create(string(res), difficulty, keyPair)
+	pow, err := proofofwork.Create(p.PoWInput(), difficulty, keyPair)
 	if err != nil {
 		return err
 	}
@@ -466,7 +666,8 @@ func (p *Post) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	return nil
 }
 
-func (v *Vote) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
+// PoWInput gives the JSON the proof of work of the vote is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (v *Vote) PoWInput() string {
 	cpI := *v
 	// Updateable
 	cpI.Fingerprint = ""
@@ -477,8 +678,12 @@ func (v *Vote) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	cpI.ProofOfWork = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (v *Vote) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	// Create PoW
-	pow, err := proofofwork.Create(string(res), difficulty, keyPair)
+	pow, err := proofofwork.Create(v.PoWInput(), difficulty, keyPair)
 	if err != nil {
 		return err
 	}
@@ -486,7 +691,8 @@ func (v *Vote) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	return nil
 }
 
-func (k *Key) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
+// PoWInput gives the JSON the proof of work of the key is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (k *Key) PoWInput() string {
 	cpI := *k
 	// Updateable
 	cpI.Fingerprint = ""
@@ -497,8 +703,12 @@ func (k *Key) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	cpI.ProofOfWork = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (k *Key) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	// Create PoW
-	pow, err := proofofwork.Create(string(res), difficulty, keyPair)
+	pow, err := proofofwork.Create(k.PoWInput(), difficulty, keyPair)
 	if err != nil {
 		return err
 	}
@@ -506,7 +716,8 @@ func (k *Key) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	return nil
 }
 
-func (ts *Truststate) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
+// PoWInput gives the JSON the proof of work of the truststate is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (ts *Truststate) PoWInput() string {
 	cpI := *ts
 	// Updateable
 	cpI.Fingerprint = ""
@@ -517,8 +728,12 @@ func (ts *Truststate) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) err
 	cpI.ProofOfWork = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (ts *Truststate) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
 	// Create PoW
-	pow, err := proofofwork.Create(string(res), difficulty, keyPair)
+	pow, err := proofofwork.Create(ts.PoWInput(), difficulty, keyPair)
 	if err != nil {
 		return err
 	}
@@ -526,6 +741,28 @@ func (ts *Truststate) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) err
 	return nil
 }
 
+// PoWInput gives the JSON the proof of work of the tombstone is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (tb *Tombstone) PoWInput() string {
+	cpI := *tb
+	// Non-updateable
+	cpI.Fingerprint = ""
+	// Remove the existing proof of work if any exists so as to not accidentally take it as an input to the new proof of work about to be calculated.
+	cpI.ProofOfWork = ""
+	// Convert to JSON
+	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (tb *Tombstone) CreatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
+	// Create PoW
+	pow, err := proofofwork.Create(tb.PoWInput(), difficulty, keyPair)
+	if err != nil {
+		return err
+	}
+	tb.ProofOfWork = ProofOfWork(pow)
+	return nil
+}
+
 // Create UpdateProofOfWork
 
 func (b *Board) CreateUpdatePoW(keyPair *ecdsa.PrivateKey, difficulty int64) error {
@@ -600,7 +837,7 @@ func (b *Board) VerifyPoW(pubKey string) (bool, error) {
 		// Updateable
 		// Save PoW to be verified
 		pow = string(cpI.UpdateProofOfWork)
-		neededStrength = globals.MinPoWStrengths.Board
+		neededStrength = globals.MinPoWStrengths.BoardUpdate
 		// Delete PoW so that the PoW will match
 		cpI.UpdateProofOfWork = ""
 	} else {
@@ -616,10 +853,10 @@ func (b *Board) VerifyPoW(pubKey string) (bool, error) {
 		// Delete PoW so that the PoW will match
 		cpI.ProofOfWork = ""
 	}
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify PoW
-	verifyResult, strength, err := proofofwork.Verify(string(res), pow, pubKey)
+	verifyResult, strength, err := verifyPoWForms(forms, pow, pubKey)
 	if err != nil {
 		return false, err
 	}
@@ -646,10 +883,10 @@ func (t *Thread) VerifyPoW(pubKey string) (bool, error) {
 	pow := string(cpI.ProofOfWork)
 	// Delete PoW so that the PoW will match
 	cpI.ProofOfWork = ""
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify PoW
-	verifyResult, strength, err := proofofwork.Verify(string(res), pow, pubKey)
+	verifyResult, strength, err := verifyPoWForms(forms, pow, pubKey)
 	if err != nil {
 		return false, err
 	}
@@ -676,10 +913,10 @@ func (p *Post) VerifyPoW(pubKey string) (bool, error) {
 	pow := string(cpI.ProofOfWork)
 	// Delete PoW so that the PoW will match
 	cpI.ProofOfWork = ""
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify PoW
-	verifyResult, strength, err := proofofwork.Verify(string(res), pow, pubKey)
+	verifyResult, strength, err := verifyPoWForms(forms, pow, pubKey)
 	if err != nil {
 		return false, err
 	}
@@ -708,7 +945,7 @@ func (v *Vote) VerifyPoW(pubKey string) (bool, error) {
 		// Updateable
 		// Save PoW to be verified
 		pow = string(cpI.UpdateProofOfWork)
-		neededStrength = globals.MinPoWStrengths.Board
+		neededStrength = globals.MinPoWStrengths.VoteUpdate
 		// Delete PoW so that the PoW will match
 		cpI.UpdateProofOfWork = ""
 	} else {
@@ -720,14 +957,14 @@ func (v *Vote) VerifyPoW(pubKey string) (bool, error) {
 		cpI.UpdateSignature = ""
 		// Save PoW to be verified
 		pow = string(cpI.ProofOfWork)
-		neededStrength = globals.MinPoWStrengths.Board
+		neededStrength = globals.MinPoWStrengths.Vote
 		// Delete PoW so that the PoW will match
 		cpI.ProofOfWork = ""
 	}
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify PoW
-	verifyResult, strength, err := proofofwork.Verify(string(res), pow, pubKey)
+	verifyResult, strength, err := verifyPoWForms(forms, pow, pubKey)
 	if err != nil {
 		return false, err
 	}
@@ -756,7 +993,7 @@ func (k *Key) VerifyPoW(pubKey string) (bool, error) {
 		// Updateable
 		// Save PoW to be verified
 		pow = string(cpI.UpdateProofOfWork)
-		neededStrength = globals.MinPoWStrengths.Board
+		neededStrength = globals.MinPoWStrengths.KeyUpdate
 		// Delete PoW so that the PoW will match
 		cpI.UpdateProofOfWork = ""
 	} else {
@@ -768,14 +1005,14 @@ func (k *Key) VerifyPoW(pubKey string) (bool, error) {
 		cpI.UpdateSignature = ""
 		// Save PoW to be verified
 		pow = string(cpI.ProofOfWork)
-		neededStrength = globals.MinPoWStrengths.Board
+		neededStrength = globals.MinPoWStrengths.Key
 		// Delete PoW so that the PoW will match
 		cpI.ProofOfWork = ""
 	}
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify PoW
-	verifyResult, strength, err := proofofwork.Verify(string(res), pow, pubKey)
+	verifyResult, strength, err := verifyPoWForms(forms, pow, pubKey)
 	if err != nil {
 		return false, err
 	}
@@ -804,7 +1041,7 @@ func (ts *Truststate) VerifyPoW(pubKey string) (bool, error) {
 		// Updateable
 		// Save PoW to be verified
 		pow = string(cpI.UpdateProofOfWork)
-		neededStrength = globals.MinPoWStrengths.Board
+		neededStrength = globals.MinPoWStrengths.TruststateUpdate
 		// Delete PoW so that the PoW will match
 		cpI.UpdateProofOfWork = ""
 	} else {
@@ -816,14 +1053,14 @@ func (ts *Truststate) VerifyPoW(pubKey string) (bool, error) {
 		cpI.UpdateSignature = ""
 		// Save PoW to be verified
 		pow = string(cpI.ProofOfWork)
-		neededStrength = globals.MinPoWStrengths.Board
+		neededStrength = globals.MinPoWStrengths.Truststate
 		// Delete PoW so that the PoW will match
 		cpI.ProofOfWork = ""
 	}
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify PoW
-	verifyResult, strength, err := proofofwork.Verify(string(res), pow, pubKey)
+	verifyResult, strength, err := verifyPoWForms(forms, pow, pubKey)
 	if err != nil {
 		return false, err
 	}
@@ -842,9 +1079,40 @@ func (ts *Truststate) VerifyPoW(pubKey string) (bool, error) {
 	}
 }
 
+func (tb *Tombstone) VerifyPoW(pubKey string) (bool, error) {
+	cpI := *tb
+	// Non-updateable
+	cpI.Fingerprint = ""
+	// Save PoW to be verified
+	pow := string(cpI.ProofOfWork)
+	// Delete PoW so that the PoW will match
+	cpI.ProofOfWork = ""
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
+	// Verify PoW
+	verifyResult, strength, err := verifyPoWForms(forms, pow, pubKey)
+	if err != nil {
+		return false, err
+	}
+	// If the PoW is valid
+	if verifyResult {
+		// Check if satisfies required minimum
+		if strength >= globals.MinPoWStrengths.Tombstone {
+			return true, nil
+		} else {
+			return false, errors.New(fmt.Sprint(
+				"This proof of work is not strong enough. PoW: ", pow))
+		}
+	} else {
+		return false, errors.New(fmt.Sprint(
+			"This proof of work is invalid, but no reason given as to why. PoW: ", pow))
+	}
+}
+
 // Create Fingerprint
 
-func (b *Board) CreateFingerprint() {
+// FingerprintInput gives the JSON the fingerprint of the board is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (b *Board) FingerprintInput() string {
 	cpI := *b
 	// Remove ALL mutable fields
 	cpI.LastUpdate = 0
@@ -853,16 +1121,22 @@ func (b *Board) CreateFingerprint() {
 	var emptyBOList []BoardOwner
 	cpI.BoardOwners = emptyBOList
 	cpI.Description = ""
+	cpI.Meta = nil
 	// Remove the existing fingerprint if any exists so as to not accidentally take it as an input to the new fingerprint about to be calculated.
 	cpI.Fingerprint = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (b *Board) CreateFingerprint() {
 	// Create Fingerprint
-	fp := fingerprinting.Create(string(res))
+	fp := fingerprinting.Create(b.FingerprintInput())
 	b.Fingerprint = Fingerprint(fp)
 }
 
-func (t *Thread) CreateFingerprint() {
+// FingerprintInput gives the JSON the fingerprint of the thread is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (t *Thread) FingerprintInput() string {
 	cpI := *t
 	// Remove ALL mutable fields
 	// (Thread does not have any mutable fields)
@@ -870,12 +1144,17 @@ func (t *Thread) CreateFingerprint() {
 	cpI.Fingerprint = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (t *Thread) CreateFingerprint() {
 	// Create Fingerprint
-	fp := fingerprinting.Create(string(res))
+	fp := fingerprinting.Create(t.FingerprintInput())
 	t.Fingerprint = Fingerprint(fp)
 }
 
-func (p *Post) CreateFingerprint() {
+// FingerprintInput gives the JSON the fingerprint of the post is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (p *Post) FingerprintInput() string {
 	cpI := *p
 	// Remove ALL mutable fields
 	// (Post does not have any mutable fields)
@@ -883,12 +1162,17 @@ func (p *Post) CreateFingerprint() {
 	cpI.Fingerprint = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (p *Post) CreateFingerprint() {
 	// Create Fingerprint
-	fp := fingerprinting.Create(string(res))
+	fp := fingerprinting.Create(p.FingerprintInput())
 	p.Fingerprint = Fingerprint(fp)
 }
 
-func (v *Vote) CreateFingerprint() {
+// FingerprintInput gives the JSON the fingerprint of the vote is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (v *Vote) FingerprintInput() string {
 	cpI := *v
 	// Remove ALL mutable fields
 	cpI.LastUpdate = 0
@@ -899,12 +1183,17 @@ func (v *Vote) CreateFingerprint() {
 	cpI.Fingerprint = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (v *Vote) CreateFingerprint() {
 	// Create Fingerprint
-	fp := fingerprinting.Create(string(res))
+	fp := fingerprinting.Create(v.FingerprintInput())
 	v.Fingerprint = Fingerprint(fp)
 }
 
-func (k *Key) CreateFingerprint() {
+// FingerprintInput gives the JSON the fingerprint of the key is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (k *Key) FingerprintInput() string {
 	cpI := *k
 	// Remove ALL mutable fields
 	cpI.LastUpdate = 0
@@ -914,19 +1203,24 @@ func (k *Key) CreateFingerprint() {
 	cpI.CurrencyAddresses = emptyCAList
 	cpI.Info = ""
 	// Remove the existing fingerprint if any exists so as to not accidentally take it as an input to the new fingerprint about to be calculated.
-	fmt.Println("old fingerprint")
-	fmt.Println(cpI.Fingerprint)
 	cpI.Fingerprint = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (k *Key) CreateFingerprint() {
+	fmt.Println("old fingerprint")
+	fmt.Println(k.Fingerprint)
 	// Create Fingerprint
-	fp := fingerprinting.Create(string(res))
+	fp := fingerprinting.Create(k.FingerprintInput())
 	k.Fingerprint = Fingerprint(fp)
 	fmt.Println("new fingerprint")
 	fmt.Println(k.Fingerprint)
 }
 
-func (ts *Truststate) CreateFingerprint() {
+// FingerprintInput gives the JSON the fingerprint of the truststate is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (ts *Truststate) FingerprintInput() string {
 	cpI := *ts
 	// Remove ALL mutable fields
 	cpI.LastUpdate = 0
@@ -940,11 +1234,33 @@ func (ts *Truststate) CreateFingerprint() {
 	cpI.Fingerprint = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (ts *Truststate) CreateFingerprint() {
 	// Create Fingerprint
-	fp := fingerprinting.Create(string(res))
+	fp := fingerprinting.Create(ts.FingerprintInput())
 	ts.Fingerprint = Fingerprint(fp)
 }
 
+// FingerprintInput gives the JSON the fingerprint of the tombstone is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (tb *Tombstone) FingerprintInput() string {
+	cpI := *tb
+	// Remove ALL mutable fields
+	// (Tombstone does not have any mutable fields)
+	// Remove the existing fingerprint if any exists so as to not accidentally take it as an input to the new fingerprint about to be calculated.
+	cpI.Fingerprint = ""
+	// Convert to JSON
+	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (tb *Tombstone) CreateFingerprint() {
+	// Create Fingerprint
+	fp := fingerprinting.Create(tb.FingerprintInput())
+	tb.Fingerprint = Fingerprint(fp)
+}
+
 // Verify Fingerprint
 
 func (b *Board) VerifyFingerprint() bool {
@@ -958,12 +1274,13 @@ func (b *Board) VerifyFingerprint() bool {
 	var emptyBOList []BoardOwner
 	cpI.BoardOwners = emptyBOList
 	cpI.Description = ""
+	cpI.Meta = nil
 	// Remove the existing fingerprint so that it won't be included as part of the input to be verified.
 	cpI.Fingerprint = ""
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify Fingerprint
-	verifyResult := fingerprinting.Verify(string(res), fp)
+	verifyResult := verifyFingerprintForms(forms, fp)
 	return verifyResult
 }
 
@@ -975,10 +1292,10 @@ func (t *Thread) VerifyFingerprint() bool {
 	// (Thread does not have any mutable fields)
 	// Remove the existing fingerprint so that it won't be included as part of the input to be verified.
 	cpI.Fingerprint = ""
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify Fingerprint
-	verifyResult := fingerprinting.Verify(string(res), fp)
+	verifyResult := verifyFingerprintForms(forms, fp)
 	return verifyResult
 }
 
@@ -990,10 +1307,10 @@ func (p *Post) VerifyFingerprint() bool {
 	// (Post does not have any mutable fields)
 	// Remove the existing fingerprint so that it won't be included as part of the input to be verified.
 	cpI.Fingerprint = ""
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify Fingerprint
-	verifyResult := fingerprinting.Verify(string(res), fp)
+	verifyResult := verifyFingerprintForms(forms, fp)
 	return verifyResult
 }
 
@@ -1008,10 +1325,10 @@ func (v *Vote) VerifyFingerprint() bool {
 	cpI.Type = uint8(0)
 	// Remove the existing fingerprint so that it won't be included as part of the input to be verified.
 	cpI.Fingerprint = ""
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify Fingerprint
-	verifyResult := fingerprinting.Verify(string(res), fp)
+	verifyResult := verifyFingerprintForms(forms, fp)
 	return verifyResult
 }
 
@@ -1028,10 +1345,10 @@ func (k *Key) VerifyFingerprint() bool {
 	cpI.Info = ""
 	// Remove the existing fingerprint so that it won't be included as part of the input to be verified.
 	cpI.Fingerprint = ""
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify Fingerprint
-	verifyResult := fingerprinting.Verify(string(res), fp)
+	verifyResult := verifyFingerprintForms(forms, fp)
 	return verifyResult
 }
 
@@ -1049,16 +1366,32 @@ func (ts *Truststate) VerifyFingerprint() bool {
 	cpI.Expiry = 0
 	// Remove the existing fingerprint so that it won't be included as part of the input to be verified.
 	cpI.Fingerprint = ""
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify Fingerprint
-	verifyResult := fingerprinting.Verify(string(res), fp)
+	verifyResult := verifyFingerprintForms(forms, fp)
+	return verifyResult
+}
+
+func (tb *Tombstone) VerifyFingerprint() bool {
+	cpI := *tb
+	var fp string
+	fp = string(cpI.Fingerprint)
+	// Remove ALL mutable fields
+	// (Tombstone does not have any mutable fields)
+	// Remove the existing fingerprint so that it won't be included as part of the input to be verified.
+	cpI.Fingerprint = ""
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
+	// Verify Fingerprint
+	verifyResult := verifyFingerprintForms(forms, fp)
 	return verifyResult
 }
 
 // Signature
 
-func (b *Board) CreateSignature(keyPair *ecdsa.PrivateKey) error {
+// SignatureInput gives the JSON the signature of the board is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (b *Board) SignatureInput() string {
 	cpI := *b
 	// Updateable
 	cpI.Fingerprint = ""
@@ -1070,8 +1403,12 @@ func (b *Board) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	cpI.Signature = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (b *Board) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	// Create signature
-	signature, err := signaturing.Sign(string(res), keyPair)
+	signature, err := signaturing.Sign(b.SignatureInput(), keyPair)
 	if err != nil {
 		return err
 	}
@@ -1079,7 +1416,8 @@ func (b *Board) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	return nil
 }
 
-func (t *Thread) CreateSignature(keyPair *ecdsa.PrivateKey) error {
+// SignatureInput gives the JSON the signature of the thread is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (t *Thread) SignatureInput() string {
 	cpI := *t
 	// Non-updateable
 	cpI.Fingerprint = ""
@@ -1088,8 +1426,12 @@ func (t *Thread) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	cpI.Signature = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (t *Thread) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	// Create signature
-	signature, err := signaturing.Sign(string(res), keyPair)
+	signature, err := signaturing.Sign(t.SignatureInput(), keyPair)
 	if err != nil {
 		return err
 	}
@@ -1097,7 +1439,8 @@ func (t *Thread) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	return nil
 }
 
-func (p *Post) CreateSignature(keyPair *ecdsa.PrivateKey) error {
+// SignatureInput gives the JSON the signature of the post is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (p *Post) SignatureInput() string {
 	cpI := *p
 	// Non-updateable
 	cpI.Fingerprint = ""
@@ -1106,8 +1449,12 @@ func (p *Post) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	cpI.Signature = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (p *Post) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	// Create signature
-	signature, err := signaturing.Sign(string(res), keyPair)
+	signature, err := signaturing.Sign(p.SignatureInput(), keyPair)
 	if err != nil {
 		return err
 	}
@@ -1115,7 +1462,8 @@ func (p *Post) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	return nil
 }
 
-func (v *Vote) CreateSignature(keyPair *ecdsa.PrivateKey) error {
+// SignatureInput gives the JSON the signature of the vote is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (v *Vote) SignatureInput() string {
 	cpI := *v
 	// Updateable
 	cpI.Fingerprint = ""
@@ -1127,8 +1475,12 @@ func (v *Vote) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	cpI.Signature = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (v *Vote) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	// Create signature
-	signature, err := signaturing.Sign(string(res), keyPair)
+	signature, err := signaturing.Sign(v.SignatureInput(), keyPair)
 	if err != nil {
 		return err
 	}
@@ -1136,7 +1488,8 @@ func (v *Vote) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	return nil
 }
 
-func (k *Key) CreateSignature(keyPair *ecdsa.PrivateKey) error {
+// SignatureInput gives the JSON the signature of the key is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (k *Key) SignatureInput() string {
 	cpI := *k
 	// Updateable
 	cpI.Fingerprint = ""
@@ -1148,8 +1501,12 @@ func (k *Key) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	cpI.Signature = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (k *Key) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	// Create signature
-	signature, err := signaturing.Sign(string(res), keyPair)
+	signature, err := signaturing.Sign(k.SignatureInput(), keyPair)
 	if err != nil {
 		return err
 	}
@@ -1157,7 +1514,8 @@ func (k *Key) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	return nil
 }
 
-func (ts *Truststate) CreateSignature(keyPair *ecdsa.PrivateKey) error {
+// SignatureInput gives the JSON the signature of the truststate is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (ts *Truststate) SignatureInput() string {
 	cpI := *ts
 	// Updateable
 	cpI.Fingerprint = ""
@@ -1169,8 +1527,12 @@ func (ts *Truststate) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	cpI.Signature = ""
 	// Convert to JSON
 	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (ts *Truststate) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	// Create signature
-	signature, err := signaturing.Sign(string(res), keyPair)
+	signature, err := signaturing.Sign(ts.SignatureInput(), keyPair)
 	if err != nil {
 		return err
 	}
@@ -1178,6 +1540,29 @@ func (ts *Truststate) CreateSignature(keyPair *ecdsa.PrivateKey) error {
 	return nil
 }
 
+// SignatureInput gives the JSON the signature of the tombstone is made over: the JSON of the struct, in the order of its fields, with the fields it doesn't cover emptied.
+func (tb *Tombstone) SignatureInput() string {
+	cpI := *tb
+	// Non-updateable
+	cpI.Fingerprint = ""
+	cpI.ProofOfWork = ""
+	// Remove existing signature if any so it won't end up in the mix accidentally.
+	cpI.Signature = ""
+	// Convert to JSON
+	res, _ := json.Marshal(cpI)
+	return string(res)
+}
+
+func (tb *Tombstone) CreateSignature(keyPair *ecdsa.PrivateKey) error {
+	// Create signature
+	signature, err := signaturing.Sign(tb.SignatureInput(), keyPair)
+	if err != nil {
+		return err
+	}
+	tb.Signature = Signature(signature)
+	return nil
+}
+
 // Create UpdateSignature
 
 func (b *Board) CreateUpdateSignature(keyPair *ecdsa.PrivateKey) error {
@@ -1271,10 +1656,10 @@ func (b *Board) VerifySignature(pubKey string) (bool, error) {
 		cpI.ProofOfWork = ""
 		cpI.Signature = ""
 	}
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify Signature
-	verifyResult := signaturing.Verify(string(res), signature, pubKey)
+	verifyResult := verifySignatureForms(forms, signature, pubKey)
 	// If the Signature is valid
 	if verifyResult {
 		return true, nil
@@ -1295,10 +1680,10 @@ func (t *Thread) VerifySignature(pubKey string) (bool, error) {
 	cpI.Signature = ""
 	// Delete signature so that the signature will match
 	cpI.Signature = ""
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify Signature
-	verifyResult := signaturing.Verify(string(res), signature, pubKey)
+	verifyResult := verifySignatureForms(forms, signature, pubKey)
 	// If the Signature is valid
 	if verifyResult {
 		return true, nil
@@ -1319,10 +1704,10 @@ func (p *Post) VerifySignature(pubKey string) (bool, error) {
 	cpI.Signature = ""
 	// Delete signature so that the signature will match
 	cpI.Signature = ""
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify Signature
-	verifyResult := signaturing.Verify(string(res), signature, pubKey)
+	verifyResult := verifySignatureForms(forms, signature, pubKey)
 	// If the Signature is valid
 	if verifyResult {
 		return true, nil
@@ -1357,11 +1742,11 @@ func (v *Vote) VerifySignature(pubKey string) (bool, error) {
 		cpI.ProofOfWork = ""
 		cpI.Signature = ""
 	}
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify Signature
 	// Verify Signature
-	verifyResult := signaturing.Verify(string(res), signature, pubKey)
+	verifyResult := verifySignatureForms(forms, signature, pubKey)
 	// If the Signature is valid
 	if verifyResult {
 		return true, nil
@@ -1396,10 +1781,10 @@ func (k *Key) VerifySignature(pubKey string) (bool, error) {
 		cpI.ProofOfWork = ""
 		cpI.Signature = ""
 	}
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify Signature
-	verifyResult := signaturing.Verify(string(res), signature, pubKey)
+	verifyResult := verifySignatureForms(forms, signature, pubKey)
 	// If the Signature is valid
 	if verifyResult {
 		return true, nil
@@ -1434,10 +1819,32 @@ func (ts *Truststate) VerifySignature(pubKey string) (bool, error) {
 		cpI.ProofOfWork = ""
 		cpI.Signature = ""
 	}
-	// Convert to JSON
-	res, _ := json.Marshal(cpI)
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
+	// Verify Signature
+	verifyResult := verifySignatureForms(forms, signature, pubKey)
+	// If the Signature is valid
+	if verifyResult {
+		return true, nil
+	} else {
+		return false, errors.New(fmt.Sprint(
+			"This signature is invalid, but no reason given as to why. Signature: ", signature))
+	}
+}
+
+func (tb *Tombstone) VerifySignature(pubKey string) (bool, error) {
+	cpI := *tb
+	// Save signature to be verified
+	signature := string(cpI.Signature)
+	// Non-updateable
+	cpI.Fingerprint = ""
+	cpI.ProofOfWork = ""
+	// Remove existing signature if any so it won't end up in the mix accidentally.
+	cpI.Signature = ""
+	// Convert to JSON, in every form it can have been made over
+	forms := entityForms(cpI)
 	// Verify Signature
-	verifyResult := signaturing.Verify(string(res), signature, pubKey)
+	verifyResult := verifySignatureForms(forms, signature, pubKey)
 	// If the Signature is valid
 	if verifyResult {
 		return true, nil
@@ -0,0 +1,73 @@
+// API > Binding
+// This file binds a POST response to the request it answers. The requester puts a fresh nonce into its request, and the remote echoes it in its response and signs the response with its node key. A response recorded from another request, or replayed later, does not have the nonce the requester just chose, and its timestamp is out of the window.
+
+package api
+
+import (
+	"aether-core/services/globals"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	nonceBytes    = 16
+	maxNonceChars = 64
+)
+
+// NewNonce creates a random nonce for a request.
+func NewNonce() string {
+	b := make([]byte, nonceBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		// Without randomness the nonces could be guessed. The clock is at least fresh for every request.
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// ValidNonce checks whether a nonce given by a remote is safe to keep and to echo: short, and only hex digits.
+func ValidNonce(nonce string) bool {
+	if len(nonce) == 0 || len(nonce) > maxNonceChars {
+		return false
+	}
+	_, err := hex.DecodeString(nonce)
+	return err == nil && len(nonce)%2 == 0
+}
+
+// InBindingWindow checks whether a timestamp is close enough to the local clock for a request or response carrying it to be fresh.
+func InBindingWindow(ts Timestamp, now time.Time) bool {
+	diff := now.Sub(time.Unix(int64(ts), 0))
+	return diff <= globals.ResponseBindingWindow && diff >= -globals.ResponseBindingWindow
+}
+
+// BindResponse echoes the nonce of the request in the response and signs the response with the node key. It has to be called after everything else in the response is set. Requests without a nonce come from remotes that don't check the binding, and their responses are left as is.
+func BindResponse(resp *ApiResponse, nonce string) error {
+	if len(nonce) == 0 {
+		return nil
+	}
+	resp.Nonce = nonce
+	return SignResponse(resp)
+}
+
+// VerifyBinding checks that a response answers the request that was sent with the given nonce. raw is the JSON of the response as it arrived, and resp is what it was parsed into.
+func VerifyBinding(raw []byte, resp *ApiResponse, nonce string, now time.Time) error {
+	if len(resp.Nonce) == 0 && len(resp.ResponseSignature) == 0 {
+		if globals.RequireResponseBinding {
+			return errors.New(fmt.Sprintf("The remote did not bind its response to the request, and unbound responses are not accepted. Node: %s", resp.NodeId))
+		}
+		return nil
+	}
+	if resp.Nonce != nonce {
+		return errors.New(fmt.Sprintf("The response was made for another request. It might have been replayed. Node: %s, Sent nonce: %s, Received nonce: %s", resp.NodeId, nonce, resp.Nonce))
+	}
+	if !InBindingWindow(resp.Timestamp, now) {
+		return errors.New(fmt.Sprintf("The timestamp of the response is out of the binding window. Node: %s, Timestamp: %d, Window: %s", resp.NodeId, resp.Timestamp, globals.ResponseBindingWindow))
+	}
+	if len(resp.NodePublicKey) == 0 || len(resp.ResponseSignature) == 0 {
+		return errors.New(fmt.Sprintf("The response echoes the nonce, but it is not signed. Node: %s", resp.NodeId))
+	}
+	return verifySignature(raw, resp)
+}
@@ -0,0 +1,123 @@
+// API > Client Headers
+// This file has the headers that tell a remote who is asking: the node id, the name and the version of the client, and the protocol extensions it supports. They are set on every request to the remotes, including the GETs of the caches, which have no body to carry them, so a node can keep statistics of the clients that sync from it, and treat the versions that need it differently. They are what the remote claims, and nothing is proven by them.
+
+package api
+
+import (
+	"aether-core/services/globals"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	NodeIdHeader     = "X-Aether-Node-Id"
+	ClientHeader     = "X-Aether-Client" // Name/major.minor.patch, such as "Aether/2.0.0".
+	ExtensionsHeader = "X-Aether-Extensions"
+)
+
+// The most of the headers that is read from a remote. Anything longer is ignored.
+const (
+	maxClientNameLength = 64
+	maxExtensions       = 32
+	maxExtensionLength  = 32
+)
+
+// ClientInfo is what the headers of a request say about the remote that sent it.
+type ClientInfo struct {
+	NodeId       string   `json:"node_id,omitempty"`
+	ClientName   string   `json:"client_name,omitempty"`
+	VersionMajor int      `json:"version_major"`
+	VersionMinor int      `json:"version_minor"`
+	VersionPatch int      `json:"version_patch"`
+	Extensions   []string `json:"extensions,omitempty"`
+}
+
+// Version gives the client as it is given in the client header.
+func (c ClientInfo) Version() string {
+	return fmt.Sprint(c.ClientName, "/", c.VersionMajor, ".", c.VersionMinor, ".", c.VersionPatch)
+}
+
+// AtLeast checks whether the client is the given version or newer.
+func (c ClientInfo) AtLeast(major int, minor int, patch int) bool {
+	if c.VersionMajor != major {
+		return c.VersionMajor > major
+	}
+	if c.VersionMinor != minor {
+		return c.VersionMinor > minor
+	}
+	return c.VersionPatch >= patch
+}
+
+// HasExtension checks whether the remote said it supports the protocol extension.
+func (c ClientInfo) HasExtension(ext string) bool {
+	for _, e := range c.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// SetClientHeaders sets the headers on a request to a remote, unless SendClientHeaders is off, or the node is in privacy mode.
+func SetClientHeaders(h http.Header) {
+	if !globals.SendClientHeaders || globals.PrivacyMode {
+		return
+	}
+	if len(globals.NodeId) > 0 {
+		h.Set(NodeIdHeader, globals.NodeId)
+	}
+	h.Set(ClientHeader, fmt.Sprint(globals.ClientName, "/", globals.ClientVersionMajor, ".", globals.ClientVersionMinor, ".", globals.ClientVersionPatch))
+	h.Set(ExtensionsHeader, strings.Join(globals.ProtocolExtensions, ","))
+}
+
+// parseClientVersion reads the client header. It gives false if the header is not a name and a version of three numbers.
+func parseClientVersion(value string, c *ClientInfo) bool {
+	slash := strings.LastIndex(value, "/")
+	if slash < 1 || slash > maxClientNameLength {
+		return false
+	}
+	parts := strings.Split(value[slash+1:], ".")
+	if len(parts) != 3 {
+		return false
+	}
+	var version [3]int
+	for i, _ := range parts {
+		v, err := strconv.Atoi(parts[i])
+		if err != nil || v < 0 {
+			return false
+		}
+		version[i] = v
+	}
+	c.ClientName = value[:slash]
+	c.VersionMajor, c.VersionMinor, c.VersionPatch = version[0], version[1], version[2]
+	return true
+}
+
+// ParseClientHeaders reads the headers of a request from a remote. The ones that are not valid are left out, and it gives false if none of them is there.
+func ParseClientHeaders(h http.Header) (ClientInfo, bool) {
+	var c ClientInfo
+	found := false
+	if id := h.Get(NodeIdHeader); len(id) == 64 {
+		c.NodeId = id
+		found = true
+	}
+	if parseClientVersion(h.Get(ClientHeader), &c) {
+		found = true
+	}
+	if exts := h.Get(ExtensionsHeader); len(exts) > 0 {
+		for _, ext := range strings.Split(exts, ",") {
+			ext = strings.TrimSpace(ext)
+			if len(ext) == 0 || len(ext) > maxExtensionLength {
+				continue
+			}
+			if len(c.Extensions) == maxExtensions {
+				break
+			}
+			c.Extensions = append(c.Extensions, ext)
+		}
+		found = found || len(c.Extensions) > 0
+	}
+	return c, found
+}
@@ -0,0 +1,81 @@
+// API > Conditional
+// This file keeps the last copy of the cache indexes fetched from remotes, with their ETags, so that the next poll can ask for them conditionally. An index that has not changed since the last sync comes back as a 304 with no body, and the kept copy is used instead.
+// The copies are kept in memory only. After a restart, the ETag of an index can be given back from a sync bookmark without its copy; a 304 for it then means that the caller has all the index links to, and nothing is read.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// maxConditionalCopies bounds how many indexes are kept. An index is a few kilobytes, and there is one per entity type per remote.
+const maxConditionalCopies = 1024
+
+type conditionalEntry struct {
+	etag string
+	body []byte
+}
+
+// errNotModified is what Fetch gives for an index that has not changed since the ETag given back from a bookmark.
+var errNotModified = errors.New("The index has not changed since the bookmark.")
+
+var conditionalLock sync.Mutex
+var conditionalCopies = make(map[string]conditionalEntry)
+
+// conditionalCopy gives the kept copy of the page at the link, if there is one.
+func conditionalCopy(link string) (conditionalEntry, bool) {
+	conditionalLock.Lock()
+	defer conditionalLock.Unlock()
+	e, ok := conditionalCopies[link]
+	return e, ok
+}
+
+// keepConditionalCopy keeps the page at the link, if it is an index and the remote gave an ETag for it. The cache pages themselves are not kept: their names are unique, and a sync doesn't fetch the same one twice.
+func keepConditionalCopy(link string, etag string, body []byte) {
+	if !strings.HasSuffix(link, "/index.json") {
+		return
+	}
+	conditionalLock.Lock()
+	defer conditionalLock.Unlock()
+	if len(etag) == 0 {
+		delete(conditionalCopies, link)
+		return
+	}
+	if _, exists := conditionalCopies[link]; !exists && len(conditionalCopies) >= maxConditionalCopies {
+		// Full. Drop any one; it is only a missed 304 on its next poll.
+		for k := range conditionalCopies {
+			delete(conditionalCopies, k)
+			break
+		}
+	}
+	conditionalCopies[link] = conditionalEntry{etag: etag, body: body}
+}
+
+func dropConditionalCopy(link string) {
+	conditionalLock.Lock()
+	defer conditionalLock.Unlock()
+	delete(conditionalCopies, link)
+}
+
+// indexETag gives the ETag of the kept copy of the index of the endpoint at the remote, if there is one.
+func indexETag(host string, subhost string, port uint16, endpoint string) string {
+	e, _ := conditionalCopy(pageLink(host, subhost, port, fmt.Sprint(endpoint, "/index.json")))
+	return e.etag
+}
+
+// rememberIndexETag gives back the ETag of the index of the endpoint at the remote from a bookmark, if no copy of it is kept, so that the next poll of it is conditional. It is used once: a 304 drops it, and a 200 replaces it with the copy.
+func rememberIndexETag(host string, subhost string, port uint16, endpoint string, etag string) {
+	if len(etag) == 0 {
+		return
+	}
+	link := pageLink(host, subhost, port, fmt.Sprint(endpoint, "/index.json"))
+	conditionalLock.Lock()
+	defer conditionalLock.Unlock()
+	if _, exists := conditionalCopies[link]; exists || len(conditionalCopies) >= maxConditionalCopies {
+		return
+	}
+	conditionalCopies[link] = conditionalEntry{etag: etag}
+}
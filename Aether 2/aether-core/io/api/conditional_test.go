@@ -0,0 +1,94 @@
+// This test is in the package itself rather than in api_test, since the kept copies of the indexes and the bookmark checks are not exported.
+
+package api
+
+import (
+	"aether-core/services/globals"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestEndpointBookmarkAfter_Success(t *testing.T) {
+	fresh := EndpointBookmark{Since: 100}
+	if !fresh.after(ResultCache{ResponseUrl: "a", EndsAt: 100}) || fresh.after(ResultCache{ResponseUrl: "a", EndsAt: 99}) {
+		t.Errorf("Without a last cache, the caches that end at or after the checkin should be read.")
+	}
+	bm := EndpointBookmark{LastCache: "a", LastCacheEnd: 200, Since: 100}
+	if bm.after(ResultCache{ResponseUrl: "a", EndsAt: 200}) {
+		t.Errorf("The last cache read should not be read again.")
+	}
+	if !bm.after(ResultCache{ResponseUrl: "b", EndsAt: 200}) {
+		t.Errorf("A cache generated again over the same range should be read again.")
+	}
+	if !bm.after(ResultCache{ResponseUrl: "c", EndsAt: 300}) {
+		t.Errorf("A cache after the last one read should be read.")
+	}
+	if bm.after(ResultCache{ResponseUrl: "d", EndsAt: 150}) {
+		t.Errorf("A cache before the last one read should not be read, even if it ends after the checkin.")
+	}
+}
+
+func TestKeepConditionalCopy_Success(t *testing.T) {
+	index := pageLink("keep.example", "", 1, "boards/index.json")
+	page := pageLink("keep.example", "", 1, "boards/cache_1/0.json")
+	keepConditionalCopy(index, "etag1", []byte("body"))
+	keepConditionalCopy(page, "etag2", []byte("body"))
+	defer dropConditionalCopy(index)
+	if e, ok := conditionalCopy(index); !ok || e.etag != "etag1" || string(e.body) != "body" {
+		t.Errorf("The index should be kept with its ETag. Entry: %#v", e)
+	}
+	if _, ok := conditionalCopy(page); ok {
+		t.Errorf("A cache page should not be kept.")
+	}
+	keepConditionalCopy(index, "", []byte("body"))
+	if _, ok := conditionalCopy(index); ok {
+		t.Errorf("An index that comes without an ETag should drop the copy kept before.")
+	}
+}
+
+func TestRememberIndexETag_Success(t *testing.T) {
+	rememberIndexETag("remember.example", "", 1, "boards", "etag1")
+	defer dropConditionalCopy(pageLink("remember.example", "", 1, "boards/index.json"))
+	if e := indexETag("remember.example", "", 1, "boards"); e != "etag1" {
+		t.Errorf("The ETag from the bookmark should be given back. ETag: %s", e)
+	}
+	keepConditionalCopy(pageLink("remember.example", "", 1, "threads/index.json"), "fresh", []byte("body"))
+	defer dropConditionalCopy(pageLink("remember.example", "", 1, "threads/index.json"))
+	rememberIndexETag("remember.example", "", 1, "threads", "stale")
+	if e := indexETag("remember.example", "", 1, "threads"); e != "fresh" {
+		t.Errorf("The ETag from the bookmark should not replace a kept copy. ETag: %s", e)
+	}
+}
+
+func TestGetEndpointFrom_Success_NotModified(t *testing.T) {
+	globals.SetGlobals()
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/v0/boards/index.json" && r.Header.Get("If-None-Match") == "etag1" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	host, portStr, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	bm := EndpointBookmark{LastCache: "cache_1", LastCacheEnd: 200, IndexETag: "etag1", Since: 100}
+	resp, next, err := GetEndpointFrom(host, "", uint16(port), "boards", bm)
+	if err != nil {
+		t.Fatalf("An index that has not changed should not be an error. Error: %s", err)
+	}
+	if requests != 1 {
+		t.Errorf("Only the index should be asked for. Requests: %d", requests)
+	}
+	if len(resp.Boards) != 0 || next != bm {
+		t.Errorf("Nothing should be read, and the bookmark should stay where it was. Bookmark: %#v", next)
+	}
+	if e := indexETag(host, "", uint16(port), "boards"); len(e) != 0 {
+		t.Errorf("The ETag from the bookmark should be used once. ETag: %s", e)
+	}
+}
@@ -0,0 +1,142 @@
+// API > Defenses
+// This file checks what a remote says about its own pages: how many pages a cache has, which caches it has, and where it redirects to. A remote that lies about these could otherwise keep a node downloading for ever, have it fetch the same caches at every sync, or send it to another host. These are treated like going over the inbound limits, since an honest remote never does them.
+
+package api
+
+import (
+	"aether-core/services/globals"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// maxCacheIndexSkew is how far after the time of its index a cache can end. A cache that ends in the future is fetched at every sync, so a remote could use it to make the node download the same thing over and over.
+const maxCacheIndexSkew = time.Hour
+
+// maxCacheNameBytes is the longest name a cache can have.
+const maxCacheNameBytes = 256
+
+// checkRedirect is the redirect policy of the requests to the remotes. A remote can redirect to another location of its own, but not to another host, and not more than InboundMaxRedirects times.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > globals.InboundMaxRedirects {
+		return errors.New(fmt.Sprintf("The remote redirected more times than allowed. Maximum: %d, Last location: %s", globals.InboundMaxRedirects, req.URL))
+	}
+	if len(via) > 0 && req.URL.Host != via[0].URL.Host {
+		return errors.New(fmt.Sprintf("The remote redirected to another host. From: %s, To: %s", via[0].URL.Host, req.URL.Host))
+	}
+	return nil
+}
+
+// checkMirrorRedirect is the redirect policy of the requests to the cache mirrors. The CDNs redirect to other hosts, and the pages are checked against their hashes anyway, so only the length of the chain is capped.
+func checkMirrorRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > globals.InboundMaxRedirects {
+		return errors.New(fmt.Sprintf("The mirror redirected more times than allowed. Maximum: %d, Last location: %s", globals.InboundMaxRedirects, req.URL))
+	}
+	return nil
+}
+
+// validCacheName checks that the name of a cache is a plain name, which can't point anywhere but a folder of the endpoint.
+func validCacheName(name string) bool {
+	if len(name) == 0 || len(name) > maxCacheNameBytes || name == "." || name == ".." {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckCacheIndex checks the cache links in the index of an endpoint. Every cache has to have a plain name, given once, and a time range that starts before it ends and doesn't end too far after the time of the index.
+func CheckCacheIndex(links []ResultCache, indexTime Timestamp) error {
+	if indexTime == 0 {
+		indexTime = Timestamp(time.Now().Unix())
+	}
+	latestEnd := indexTime + Timestamp(maxCacheIndexSkew/time.Second)
+	seen := make(map[string]bool)
+	for i, _ := range links {
+		l := &links[i]
+		if !validCacheName(l.ResponseUrl) {
+			return limitError(fmt.Sprintf("The index links to a cache whose name is not valid. Cache: %q", l.ResponseUrl))
+		}
+		if seen[l.ResponseUrl] {
+			return limitError(fmt.Sprintf("The index links to a cache more than once. Cache: %s", l.ResponseUrl))
+		}
+		seen[l.ResponseUrl] = true
+		if l.StartsFrom > l.EndsAt {
+			return limitError(fmt.Sprintf("The index links to a cache that ends before it starts. Cache: %s, Starts from: %d, Ends at: %d", l.ResponseUrl, l.StartsFrom, l.EndsAt))
+		}
+		if l.EndsAt > latestEnd {
+			return limitError(fmt.Sprintf("The index links to a cache that ends in the future. Cache: %s, Ends at: %d, Index time: %d", l.ResponseUrl, l.EndsAt, indexTime))
+		}
+	}
+	return nil
+}
+
+// CheckPostResponseLinks checks the links of a POST response whose results didn't fit in one page. There is a link for every page, and they all point to the same folder.
+func CheckPostResponseLinks(links []ResultCache) error {
+	if len(links) == 0 {
+		return errors.New("The response has no links to its pages.")
+	}
+	if len(links) > globals.InboundMaxCachePages {
+		return limitError(fmt.Sprintf("The response links to more pages than allowed. Count: %d, Maximum: %d", len(links), globals.InboundMaxCachePages))
+	}
+	for i, _ := range links {
+		if !validCacheName(links[i].ResponseUrl) || links[i].ResponseUrl != links[0].ResponseUrl {
+			return limitError(fmt.Sprintf("The pages of the response are not in a single folder with a valid name. Link: %q", links[i].ResponseUrl))
+		}
+	}
+	return nil
+}
+
+// lastPageOf gives the number of the last page of a cache from the pagination of its first page. The newer versions give the count of the pages in total_pages, and it is the one that counts; the pages field has to agree with it, and the entities can't be more than the pages can hold. The older versions only give the pages field, where the caches give the number of the last page and the POST responses the count of the pages, so without knowing which this is, the page after the count is asked for too, and a missing one is tolerated. When the number of pages is known from the links of the cache, the page count has to agree with it.
+func lastPageOf(p Pagination, knownPages int) (uint64, error) {
+	if p.Pages > uint64(globals.InboundMaxCachePages) || p.TotalPages > uint64(globals.InboundMaxCachePages) {
+		return 0, limitError(fmt.Sprintf("The cache claims to have more pages than allowed. Pages: %d, Total pages: %d, Maximum: %d", p.Pages, p.TotalPages, globals.InboundMaxCachePages))
+	}
+	if p.TotalPages > 0 {
+		if p.Pages != p.TotalPages && p.Pages+1 != p.TotalPages {
+			return 0, limitError(fmt.Sprintf("The page counts of the cache do not agree. Pages: %d, Total pages: %d", p.Pages, p.TotalPages))
+		}
+		if p.PageSize > 0 && pagesToHold(p.TotalEntities, p.PageSize) > p.TotalPages {
+			return 0, limitError(fmt.Sprintf("The cache claims to have more entities than its pages can hold. Entities: %d, Total pages: %d, Page size: %d", p.TotalEntities, p.TotalPages, p.PageSize))
+		}
+		if knownPages > 0 && p.TotalPages != uint64(knownPages) {
+			return 0, limitError(fmt.Sprintf("The page count of the cache does not match its links. Total pages: %d, Links: %d", p.TotalPages, knownPages))
+		}
+		return p.TotalPages - 1, nil
+	}
+	if knownPages <= 0 {
+		return p.Pages, nil
+	}
+	if p.Pages != uint64(knownPages) && p.Pages+1 != uint64(knownPages) {
+		return 0, limitError(fmt.Sprintf("The page count of the cache does not match its links. Pages: %d, Links: %d", p.Pages, knownPages))
+	}
+	return uint64(knownPages - 1), nil
+}
+
+// pagesToHold gives how many pages the entities need at the page size.
+func pagesToHold(entities uint64, pageSize int) uint64 {
+	pages := entities / uint64(pageSize)
+	if entities%uint64(pageSize) != 0 {
+		pages++
+	}
+	return pages
+}
+
+// CheckPagination checks that a page of a cache is the one that was asked for, and that it gives the same page counts and totals as the first page of the cache.
+func CheckPagination(page *ApiResponse, pageNum uint64, first Pagination) error {
+	p := page.Pagination
+	if p.CurrentPage != pageNum {
+		return limitError(fmt.Sprintf("The remote served another page than the one asked for. Asked for: %d, Served: %d", pageNum, p.CurrentPage))
+	}
+	if p.Pages != first.Pages || p.TotalPages != first.TotalPages {
+		return limitError(fmt.Sprintf("The page count changed within the cache. First page: %d (total %d), Page %d: %d (total %d)", first.Pages, first.TotalPages, pageNum, p.Pages, p.TotalPages))
+	}
+	if p.TotalEntities != first.TotalEntities || p.PageSize != first.PageSize {
+		return limitError(fmt.Sprintf("The totals changed within the cache. First page: %d entities, page size %d, Page %d: %d entities, page size %d", first.TotalEntities, first.PageSize, pageNum, p.TotalEntities, p.PageSize))
+	}
+	return nil
+}
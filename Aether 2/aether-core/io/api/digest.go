@@ -0,0 +1,127 @@
+// API > Digest
+// This file makes the digests of the sync sessions. A remote that announces the session digest extension keeps a digest of the entities it sent in its POST responses to each sync, by the trace id of the sync, and gives them back at the end of it. The node that synced keeps its own over what it received, and a difference between the two means that something was lost or changed on the way without either side seeing an error: a page cut short, an entity that didn't parse.
+// A digest is a count and a sum of the hashes of the entities, modulo 2^256, so the order the entities arrive in doesn't change it. The caches are not in the digests, since their pages are checked against their manifests already.
+
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+// SessionDigestExtension is the protocol extension of the nodes that keep the digests of the sync sessions.
+const SessionDigestExtension = "session_digest"
+
+// SessionDigest is the digest of the entities of an entity type in a sync session.
+type SessionDigest struct {
+	Entity   string `json:"entity"`
+	Entities uint64 `json:"entities"`
+	Sum      string `json:"sum"` // Hex.
+}
+
+var digestModulus = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// DigestAccumulator adds the entities of a sync session into its digests, one per entity type. It is safe to use from more than one goroutine.
+type DigestAccumulator struct {
+	lock   sync.Mutex
+	counts map[string]uint64
+	sums   map[string]*big.Int
+}
+
+func NewDigestAccumulator() *DigestAccumulator {
+	return &DigestAccumulator{counts: make(map[string]uint64), sums: make(map[string]*big.Int)}
+}
+
+// digestKey is what of an entity goes into the digest: its fingerprint and its signatures, which change with anything else in it.
+func digestKey(entity Provable) string {
+	key := fmt.Sprint(entity.GetFingerprint(), "|", entity.GetSignature())
+	if u, ok := entity.(Updateable); ok {
+		key = fmt.Sprint(key, "|", u.GetUpdateSignature())
+	}
+	return key
+}
+
+func (d *DigestAccumulator) add(entityType string, key string) {
+	hash := sha256.Sum256([]byte(fmt.Sprint(entityType, "|", key)))
+	sum, ok := d.sums[entityType]
+	if !ok {
+		sum = new(big.Int)
+		d.sums[entityType] = sum
+	}
+	sum.Add(sum, new(big.Int).SetBytes(hash[:]))
+	sum.Mod(sum, digestModulus)
+	d.counts[entityType]++
+}
+
+// Add adds the entities of the response into the digests of their entity types.
+func (d *DigestAccumulator) Add(resp Response) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for i, _ := range resp.Boards {
+		d.add("boards", digestKey(&resp.Boards[i]))
+	}
+	for i, _ := range resp.Threads {
+		d.add("threads", digestKey(&resp.Threads[i]))
+	}
+	for i, _ := range resp.Posts {
+		d.add("posts", digestKey(&resp.Posts[i]))
+	}
+	for i, _ := range resp.Votes {
+		d.add("votes", digestKey(&resp.Votes[i]))
+	}
+	for i, _ := range resp.Addresses {
+		// Addresses have no fingerprints.
+		a := resp.Addresses[i]
+		d.add("addresses", fmt.Sprint(a.Location, "|", a.Sublocation, "|", a.Port))
+	}
+	for i, _ := range resp.Keys {
+		d.add("keys", digestKey(&resp.Keys[i]))
+	}
+	for i, _ := range resp.Truststates {
+		d.add("truststates", digestKey(&resp.Truststates[i]))
+	}
+	for i, _ := range resp.Tombstones {
+		d.add("tombstones", digestKey(&resp.Tombstones[i]))
+	}
+}
+
+// Digests gives the digests of the entity types that had entities, ordered by entity type.
+func (d *DigestAccumulator) Digests() []SessionDigest {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var digests []SessionDigest
+	for entityType, count := range d.counts {
+		sum := make([]byte, 32)
+		d.sums[entityType].FillBytes(sum)
+		digests = append(digests, SessionDigest{Entity: entityType, Entities: count, Sum: hex.EncodeToString(sum)})
+	}
+	sort.Slice(digests, func(i, j int) bool { return digests[i].Entity < digests[j].Entity })
+	return digests
+}
+
+// CompareDigests gives the entity types whose digests differ between the two lists. An entity type that is in only one of them differs too.
+func CompareDigests(local []SessionDigest, remote []SessionDigest) []string {
+	byType := make(map[string]SessionDigest)
+	for _, d := range remote {
+		byType[d.Entity] = d
+	}
+	var differ []string
+	seen := make(map[string]bool)
+	for _, d := range local {
+		seen[d.Entity] = true
+		if r, ok := byType[d.Entity]; !ok || r != d {
+			differ = append(differ, d.Entity)
+		}
+	}
+	for _, d := range remote {
+		if !seen[d.Entity] {
+			differ = append(differ, d.Entity)
+		}
+	}
+	sort.Strings(differ)
+	return differ
+}
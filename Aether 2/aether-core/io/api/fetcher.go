@@ -5,12 +5,18 @@ package api
 
 import (
 	// "../services"
+	"aether-core/services/clock"
 	"aether-core/services/globals"
 	"aether-core/services/logging"
+	"aether-core/services/membership"
+	"aether-core/services/syncprogress"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -51,6 +57,12 @@ func getResponseTypes(r Response) []string {
 	if len(r.Threads) > 0 {
 		result = append(result, "Threads")
 	}
+	if len(r.TombstoneIndexes) > 0 {
+		result = append(result, "TombstoneIndexes")
+	}
+	if len(r.Tombstones) > 0 {
+		result = append(result, "Tombstones")
+	}
 	if len(r.TruststateIndexes) > 0 {
 		result = append(result, "TruststateIndexes")
 	}
@@ -80,6 +92,8 @@ func InsertApiResponseToResponse(response Response, apiresp ApiResponse) Respons
 	response.Posts = apiresp.ResponseBody.Posts
 	response.ThreadIndexes = apiresp.ResponseBody.ThreadIndexes
 	response.Threads = apiresp.ResponseBody.Threads
+	response.TombstoneIndexes = apiresp.ResponseBody.TombstoneIndexes
+	response.Tombstones = apiresp.ResponseBody.Tombstones
 	response.TruststateIndexes = apiresp.ResponseBody.TruststateIndexes
 	response.Truststates = apiresp.ResponseBody.Truststates
 	response.VoteIndexes = apiresp.ResponseBody.VoteIndexes
@@ -109,6 +123,10 @@ func concatResponses(response Response, response2 Response) Response {
 		response.ThreadIndexes, response2.ThreadIndexes...)
 	response.Threads = append(
 		response.Threads, response2.Threads...)
+	response.TombstoneIndexes = append(
+		response.TombstoneIndexes, response2.TombstoneIndexes...)
+	response.Tombstones = append(
+		response.Tombstones, response2.Tombstones...)
 	response.TruststateIndexes = append(
 		response.TruststateIndexes, response2.TruststateIndexes...)
 	response.Truststates = append(
@@ -129,8 +147,24 @@ var d net.Dialer
 var t http.Transport
 var c http.Client
 
+// pageLink gives the URL of the location at the remote.
+func pageLink(host string, subhost string, port uint16, location string) string {
+	// TODO: When we have the local profile, the v0 should be coming from the appropriate version number. Constant for the time being.
+	if len(subhost) > 0 {
+		return fmt.Sprint(
+			"http://", host, ":", strconv.Itoa(int(port)), "/", subhost, "/v0/", location) // TODO: Move to HTTPS after that portion goes live.
+	}
+	return fmt.Sprint(
+		"http://", host, ":", strconv.Itoa(int(port)), "/v0/", location) // TODO: Move to HTTPS after that portion goes live.
+}
+
 // Fetch is the most basic access method. It returns bytes. This should almost never be called directly outside this package.
 func Fetch(host string, subhost string, port uint16, location string, method string, postBody []byte) ([]byte, error) {
+	return fetchLimited(host, subhost, port, location, method, postBody, globals.InboundMaxPageBytes)
+}
+
+// fetchLimited is Fetch for a body that can be up to maxBytes, rather than up to a page.
+func fetchLimited(host string, subhost string, port uint16, location string, method string, postBody []byte, maxBytes int64) ([]byte, error) {
 	// Gotcha of setting these here, these will be repeated every time this is called. Maybe we can run this somehow one time...
 	dialer := &d
 	dialer.Timeout = globals.TCPConnectTimeout
@@ -141,28 +175,46 @@ func Fetch(host string, subhost string, port uint16, location string, method str
 	// Transport configuration settings inserted here.
 	c.Transport = transport
 	c.Timeout = globals.ConnectionTimeout
+	c.CheckRedirect = checkRedirect
 	client := &c
 
 	// fmt.Println(client.Timeout)
 	// fmt.Println(globals.ConnectionTimeout)
-	var fullLink string
-	if len(subhost) > 0 {
-		fullLink = fmt.Sprint(
-			"http://", host, ":", strconv.Itoa(int(port)), "/", subhost, "/v0/", location) // TODO: Move to HTTPS after that portion goes live.
-	} else {
-		fullLink = fmt.Sprint(
-			"http://", host, ":", strconv.Itoa(int(port)), "/v0/", location) // TODO: Move to HTTPS after that portion goes live.
-	}
-	// TODO: When we have the local profile, the v0 should be coming from the appropriate version number. Constant for the time being.
+	fullLink := pageLink(host, subhost, port, location)
 	var err error
 	var resp *http.Response
 	if method == "GET" {
-		resp, err = client.Get(fullLink)
+		req, errReq := http.NewRequest("GET", fullLink, nil)
+		if errReq != nil {
+			return []byte{}, errReq
+		}
+		SetClientHeaders(req.Header)
+		// If we have the page from an earlier poll, the remote only sends it again if it has changed.
+		cached, isCached := conditionalCopy(fullLink)
+		if isCached {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		resp, err = client.Do(req)
 		if err != nil {
 			return []byte{}, err
 		}
+		if resp.StatusCode == http.StatusNotModified && isCached {
+			resp.Body.Close()
+			if cached.body == nil {
+				// Only the ETag was kept, from a bookmark. The caller has everything the index links to already.
+				dropConditionalCopy(fullLink)
+				return []byte{}, errNotModified
+			}
+			return cached.body, nil
+		}
 	} else if method == "POST" {
-		resp, err = client.Post(fullLink, "application/json", bytes.NewReader(postBody))
+		req, errReq := http.NewRequest("POST", fullLink, bytes.NewReader(postBody))
+		if errReq != nil {
+			return []byte{}, errReq
+		}
+		req.Header.Set("Content-Type", "application/json")
+		SetClientHeaders(req.Header)
+		resp, err = client.Do(req)
 		if err != nil {
 			return []byte{}, err
 		}
@@ -191,11 +243,30 @@ func Fetch(host string, subhost string, port uint16, location string, method str
 		}
 	}
 	if resp.StatusCode == 200 {
-		body, err := ioutil.ReadAll(resp.Body)
+		// Read one byte more than the limit, so that a body of exactly the limit is still accepted.
+		// The timeout of the client covers reading the body too, so a remote that sends the page a byte at a time is cut off here. What arrived until then is not a page.
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
 		if err != nil {
-			// logging.LogCrash(err)
-			fmt.Sprint(err.Error())
+			return []byte{}, errors.New(
+				fmt.Sprint(
+					"The page could not be read in full. The remote might be sending it too slowly. Error: ", err,
+					", Host: ", host,
+					", Subhost: ", subhost,
+					", Port: ", port,
+					", Location: ", location))
 		}
+		if int64(len(body)) > maxBytes {
+			return []byte{}, limitError(fmt.Sprint(
+				"The page is larger than allowed. Maximum: ", maxBytes,
+				", Host: ", host,
+				", Subhost: ", subhost,
+				", Port: ", port,
+				", Location: ", location))
+		}
+		if method == "GET" {
+			keepConditionalCopy(fullLink, resp.Header.Get("ETag"), body)
+		}
+		syncprogress.AddBytes(syncprogress.PeerKey(host, port), locationEntity(location), len(body))
 		return body, nil
 	} else {
 		return []byte{}, errors.New(
@@ -211,12 +282,92 @@ func Fetch(host string, subhost string, port uint16, location string, method str
 
 // GetPageRaw returns a raw page from the cache. This returns the entire page, not just the data. This is useful for functions that need to be aware of the page's metadata.
 func GetPageRaw(host string, subhost string, port uint16, location string, method string, postBody []byte) (ApiResponse, error) {
-	// TODO: Kill the connection if the file size is too large, or if it takes too long to download. A page above 5mb is probably malicious, also is one that takes more than 10 minutes to download.
-	var apiresp ApiResponse
+	// TODO: Kill the connection if it takes too long to download. A page that takes more than 10 minutes to download is probably malicious. (Pages that are too large are cut off in Fetch.)
 	result, err := Fetch(host, subhost, port, location, method, postBody)
+	if err != nil {
+		return ApiResponse{}, err
+	}
+	return readPage(result, host, subhost, port, location)
+}
+
+// readPage parses a page that arrived from a remote, and verifies its signature.
+func readPage(result []byte, host string, subhost string, port uint16, location string) (ApiResponse, error) {
+	apiresp, err := parsePage(result, host, subhost, port, location)
 	if err != nil {
 		return apiresp, err
 	}
+	err2 := VerifyResponse(result, &apiresp, PinnedNodeKey(host, port))
+	if err2 != nil {
+		return ApiResponse{}, fetchError(err2, host, subhost, port, location)
+	}
+	return apiresp, nil
+}
+
+// getCachePage gets the page with the given name, such as "0.json", of the cache at the location. If the cache has a manifest, the page has to be listed in it, and match it, before it is parsed.
+func getCachePage(host string, subhost string, port uint16, location string, name string, m *CacheManifest) (ApiResponse, error) {
+	path := fmt.Sprint(location, "/", name)
+	if m == nil {
+		return GetPageRaw(host, subhost, port, path, "GET", []byte{})
+	}
+	listed, ok := m.Page(name)
+	if !ok {
+		return ApiResponse{}, fetchError(errors.New("The page is not listed in the manifest of the cache."), host, subhost, port, path)
+	}
+	result, err := Fetch(host, subhost, port, path, "GET", []byte{})
+	if err != nil {
+		return ApiResponse{}, err
+	}
+	err2 := listed.Verify(result)
+	if err2 != nil {
+		return ApiResponse{}, fetchError(err2, host, subhost, port, path)
+	}
+	return readPage(result, host, subhost, port, path)
+}
+
+// GetPageBound makes a POST request with a fresh nonce, and returns the response only if it is bound to this request. See binding.go.
+func GetPageBound(host string, subhost string, port uint16, location string, req ApiResponse) (ApiResponse, error) {
+	req.Nonce = NewNonce()
+	req.Timestamp = Timestamp(clock.Unix())
+	postBody, err := json.Marshal(req)
+	if err != nil {
+		return ApiResponse{}, err
+	}
+	result, err2 := Fetch(host, subhost, port, location, "POST", postBody)
+	if err2 != nil {
+		return ApiResponse{}, err2
+	}
+	apiresp, err3 := parsePage(result, host, subhost, port, location)
+	if err3 != nil {
+		return apiresp, err3
+	}
+	err4 := VerifyBinding(result, &apiresp, req.Nonce, clock.Now())
+	if err4 != nil {
+		return ApiResponse{}, fetchError(err4, host, subhost, port, location)
+	}
+	// A remote whose node response was signed signs its POST responses to the requests with a nonce too. One that doesn't has been tampered with on the way.
+	pinned := PinnedNodeKey(host, port)
+	if len(pinned) > 0 && len(apiresp.ResponseSignature) == 0 {
+		return ApiResponse{}, fetchError(errors.New(fmt.Sprintf("The remote signed its node response, but not this response. Node: %s", apiresp.NodeId)), host, subhost, port, location)
+	}
+	if len(pinned) > 0 && apiresp.NodePublicKey != pinned {
+		return ApiResponse{}, fetchError(errors.New(fmt.Sprintf("The response is signed by another key than the one the remote gave at the start of the sync. Node: %s", apiresp.NodeId)), host, subhost, port, location)
+	}
+	return apiresp, nil
+}
+
+func fetchError(err error, host string, subhost string, port uint16, location string) error {
+	return errors.New(
+		fmt.Sprint(
+			err,
+			", Host: ", host,
+			", Subhost: ", subhost,
+			", Port: ", port,
+			", Location: ", location))
+}
+
+// parsePage parses a page that arrived from a remote, and checks it against the inbound limits.
+func parsePage(result []byte, host string, subhost string, port uint16, location string) (ApiResponse, error) {
+	var apiresp ApiResponse
 	err2 := json.Unmarshal(result, &apiresp)
 	if err2 != nil {
 		return apiresp, errors.New(
@@ -227,6 +378,16 @@ func GetPageRaw(host string, subhost string, port uint16, location string, metho
 				", Port: ", port,
 				", Location: ", location))
 	}
+	err3 := CheckPageLimits(&apiresp)
+	if err3 != nil {
+		return ApiResponse{}, errors.New(
+			fmt.Sprint(
+				err3,
+				", Host: ", host,
+				", Subhost: ", subhost,
+				", Port: ", port,
+				", Location: ", location))
+	}
 	// Map over everything you have.
 	return apiresp, nil
 }
@@ -246,9 +407,29 @@ func GetPage(host string, subhost string, port uint16, location string, method s
 
 // GetCache returns an entire cache. This is useful to pull a cache from the remote. This is a single thread process, it does go through the pages in order.  We could bombard the remote with goroutines, but on a larger scale, that would be called a DDoS of the remote node, so we shouldn't do that.
 func GetCache(host string, subhost string, port uint16, location string) (Response, error) {
+	return getCache(host, subhost, port, location, 0, nil)
+}
+
+// GetPostResponseCache returns the pages of a POST response whose results didn't fit in one page, given the links to them in the response.
+func GetPostResponseCache(host string, subhost string, port uint16, links []ResultCache) (Response, error) {
+	err := CheckPostResponseLinks(links)
+	if err != nil {
+		return Response{}, fetchError(err, host, subhost, port, "")
+	}
+	return getCache(host, subhost, port, links[0].ResponseUrl, len(links), nil)
+}
+
+// getCache is GetCache for a cache whose number of pages might be known from its links (0 is not known), and which might have a manifest (nil if not). The pages of a cache with a manifest are verified against it, and the manifest gives the number of pages.
+func getCache(host string, subhost string, port uint16, location string, knownPages int, m *CacheManifest) (Response, error) {
 	var response Response
+	if m != nil {
+		if knownPages > 0 && knownPages != m.EntityPageCount() {
+			return response, fetchError(limitError(fmt.Sprintf("The manifest of the cache does not match its links. Manifest: %d, Links: %d", m.EntityPageCount(), knownPages)), host, subhost, port, location)
+		}
+		knownPages = m.EntityPageCount()
+	}
 	// Get the first raw page (because we need to access pagination),
-	pageResp, err := GetPageRaw(host, subhost, port, fmt.Sprint(location, "/0.json"), "GET", []byte{})
+	pageResp, err := getCachePage(host, subhost, port, location, "0.json", m)
 	if err != nil && strings.Contains(err.Error(), "Received status code: 404") {
 		return response, errors.New(
 			fmt.Sprint(
@@ -261,20 +442,47 @@ func GetCache(host string, subhost string, port uint16, location string) (Respon
 		// If the first page is faulty, bail.
 		return response, err
 	}
-	// And look at the page count, so we know how many times to iterate.
-	pageCount := pageResp.Pagination.Pages
+	// Caches of other networks are not mixed in.
+	errNet := membership.CheckNetwork(pageResp.NetworkId)
+	if errNet != nil {
+		return response, errNet
+	}
+	// And look at the page count, so we know how many times to iterate. A remote could claim any number here, so it is checked before anything else is asked for.
+	lastPage, errPages := lastPageOf(pageResp.Pagination, knownPages)
+	if errPages != nil {
+		return response, fetchError(errPages, host, subhost, port, location)
+	}
+	errPage := CheckPagination(&pageResp, 0, pageResp.Pagination)
+	if errPage != nil {
+		return response, fetchError(errPage, host, subhost, port, location)
+	}
+	// The endpoint planned one page for this cache. Now that the page count is known, plan the rest.
+	peer := syncprogress.PeerKey(host, port)
+	syncprogress.Plan(peer, locationEntity(location), int(lastPage))
+	syncprogress.PagesDone(peer, locationEntity(location), 1)
 	// Convert this raw page response to page response data for merge.
 	response = InsertApiResponseToResponse(response, pageResp)
 	// Create a counter for missing pages. If 3 of them come one after another, bail.
 	missingPageCounter := 0
 	// Iterate over all of the pages, starting from 1 (we already cleared the 0)
-	for i := uint64(1); i <= pageCount; i++ { // Pagination starts from 0
-		pageResp2, err := GetPage(host, subhost, port,
-			fmt.Sprint(location, "/", i, ".json"), "GET", []byte{})
+	for i := uint64(1); i <= lastPage; i++ { // Pagination starts from 0
+		pageRaw, err := getCachePage(host, subhost, port, location, fmt.Sprint(i, ".json"), m)
+		if err == nil {
+			err = CheckPagination(&pageRaw, i, pageResp.Pagination)
+			if err != nil {
+				// The remote is lying about its pages. Nothing more from this cache is taken.
+				response.AvailableTypes = getResponseTypes(response)
+				return response, fetchError(err, host, subhost, port, location)
+			}
+		}
+		var pageResp2 Response
+		pageResp2 = InsertApiResponseToResponse(pageResp2, pageRaw)
 		if err == nil {
 			// If we have the page, zero out the missing page counter.
 			missingPageCounter = 0
-		} else if strings.Contains(err.Error(), "Received status code: 404") {
+			syncprogress.PagesDone(peer, locationEntity(location), 1)
+		} else if strings.Contains(err.Error(), "Received status code: 404") && m == nil {
+			// A page missing from a cache with a manifest is an error, since the manifest says it should be there.
 			missingPageCounter++ // We have a missing page.
 			if missingPageCounter > 2 {
 				// If we have 3 missing pages following each other stop processing and return with what we have.
@@ -300,14 +508,147 @@ func GetCache(host string, subhost string, port uint16, location string) (Respon
 	return response, nil
 }
 
+// fetchUrl gets the contents of an absolute URL. This is used for the cache mirrors, which live outside the node.
+func fetchUrl(url string) ([]byte, error) {
+	client := &http.Client{Timeout: globals.ConnectionTimeout, CheckRedirect: checkMirrorRedirect}
+	resp, err := client.Get(url)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return []byte{}, errors.New(fmt.Sprint("Non-200 status code returned from the mirror. Received status code: ", resp.StatusCode, ", URL: ", url))
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, globals.InboundMaxPageBytes+1))
+	if err != nil {
+		return []byte{}, err
+	}
+	if int64(len(data)) > globals.InboundMaxPageBytes {
+		return []byte{}, limitError(fmt.Sprint("The mirrored page is larger than allowed. Maximum: ", globals.InboundMaxPageBytes, ", URL: ", url))
+	}
+	return data, nil
+}
+
+// GetMirroredCache downloads the entity pages of a cache from its mirror, and verifies every page against the page hashes given by the origin. A single mismatch fails the whole cache, since the mirror cannot be trusted after that.
+func GetMirroredCache(mirrorUrl string, pageHashes map[string]string) (Response, error) {
+	var response Response
+	for i := 0; i < len(pageHashes); i++ {
+		filename := fmt.Sprint(i, ".json")
+		expected, ok := pageHashes[filename]
+		if !ok {
+			return Response{}, errors.New(fmt.Sprint("The page hashes of the mirrored cache are not contiguous. Missing page: ", filename, ", Mirror: ", mirrorUrl))
+		}
+		data, err := fetchUrl(fmt.Sprint(strings.TrimRight(mirrorUrl, "/"), "/", filename))
+		if err != nil {
+			return Response{}, err
+		}
+		hash := sha256.Sum256(data)
+		if hex.EncodeToString(hash[:]) != expected {
+			return Response{}, errors.New(fmt.Sprint("The mirrored page does not match the hash given by the origin. Page: ", filename, ", Mirror: ", mirrorUrl))
+		}
+		var apiresp ApiResponse
+		err2 := json.Unmarshal(data, &apiresp)
+		if err2 != nil {
+			return Response{}, errors.New(fmt.Sprint("The JSON that arrived from the mirror is malformed. Page: ", filename, ", Mirror: ", mirrorUrl))
+		}
+		err3 := CheckPageLimits(&apiresp)
+		if err3 != nil {
+			return Response{}, errors.New(fmt.Sprint(err3, ", Page: ", filename, ", Mirror: ", mirrorUrl))
+		}
+		var pageResp Response
+		pageResp = InsertApiResponseToResponse(pageResp, apiresp)
+		response = concatResponses(response, pageResp)
+	}
+	response.AvailableTypes = getResponseTypes(response)
+	return response, nil
+}
+
+// locationEntity gives the entity type of a location on a remote, such as "boards" for "boards/cache_1/0.json".
+func locationEntity(location string) string {
+	return strings.SplitN(location, "/", 2)[0]
+}
+
+// mirroredPageCount gives how many entity pages a mirrored cache has. The hashes of the index pages are under "index/".
+func mirroredPageCount(pageHashes map[string]string) int {
+	count := 0
+	for name, _ := range pageHashes {
+		if !strings.Contains(name, "/") {
+			count++
+		}
+	}
+	return count
+}
+
+// GetCacheIndex gives the links in the cache index of an endpoint of the remote, once the index is checked against the inbound limits and the trusted witnesses.
+func GetCacheIndex(host string, subhost string, port uint16, endpoint string) ([]ResultCache, error) {
+	result, err := getIndexOfEndpoint(host, subhost, port, endpoint)
+	return result.CacheLinks, err
+}
+
+// GetLinkedCache gets the cache a link in the cache index of an endpoint points to. A cache that is mirrored on a CDN is tried there first, and taken from the origin if the mirror fails or serves anything that does not match the hashes. From the origin, the manifest comes first, if the index says there is one, so that every page is verified as it arrives.
+func GetLinkedCache(host string, subhost string, port uint16, endpoint string, link ResultCache) (Response, error) {
+	var cache Response
+	var err error
+	if len(link.MirrorUrl) > 0 && len(link.PageHashes) > 0 {
+		cache, err = GetMirroredCache(link.MirrorUrl, link.PageHashes)
+		if err == nil {
+			pages := mirroredPageCount(link.PageHashes)
+			peer := syncprogress.PeerKey(host, port)
+			syncprogress.Plan(peer, endpoint, pages-1)
+			syncprogress.PagesDone(peer, endpoint, pages)
+			return cache, nil
+		}
+		logging.Log(1, fmt.Sprintf("Mirrored cache could not be used, falling back to the origin. Mirror: %s, Error: %s", link.MirrorUrl, err))
+	}
+	location := fmt.Sprint(endpoint, "/", link.ResponseUrl)
+	var m *CacheManifest
+	if len(link.Manifest) > 0 {
+		m, err = getManifest(host, subhost, port, location, link.Manifest)
+		if err != nil {
+			return cache, err
+		}
+	}
+	return getCache(host, subhost, port, location, mirroredPageCount(link.PageHashes), m)
+}
+
+// EndpointBookmark is where the last sync of an endpoint of a remote ended: the last cache it read, where that cache ended, and the ETag of the index it was listed in. With an empty LastCache, the caches that end at or after Since are read, as before there were bookmarks.
+type EndpointBookmark struct {
+	LastCache    string
+	LastCacheEnd Timestamp
+	IndexETag    string
+	Since        Timestamp
+}
+
+// after tells whether the cache comes after the bookmark. A cache that ends where the last one read ended, but is not that one, was generated again over the same range, and is read again.
+func (b EndpointBookmark) after(link ResultCache) bool {
+	if len(b.LastCache) == 0 {
+		return link.EndsAt >= b.Since
+	}
+	if link.EndsAt == b.LastCacheEnd {
+		return link.ResponseUrl != b.LastCache
+	}
+	return link.EndsAt > b.LastCacheEnd
+}
+
 // GetEndpoint returns an entire endpoint from the remote node.
 func GetEndpoint(host string, subhost string, port uint16, endpoint string, lastCheckin Timestamp) (Response, error) {
+	response, _, err := GetEndpointFrom(host, subhost, port, endpoint, EndpointBookmark{Since: lastCheckin})
+	return response, err
+}
+
+// GetEndpointFrom returns the caches of the endpoint that come after the bookmark, and the bookmark to continue from next time. The bookmark moves past the caches that were read, up to the first one that couldn't be. If the bookmark has the ETag of the index, and the index hasn't changed since, nothing is read.
+func GetEndpointFrom(host string, subhost string, port uint16, endpoint string, bookmark EndpointBookmark) (Response, EndpointBookmark, error) {
 	var response Response
+	next := bookmark
+	rememberIndexETag(host, subhost, port, endpoint, bookmark.IndexETag)
 	// Get raw page, because we need to access index links.
 	result, err := getIndexOfEndpoint(host, subhost, port, endpoint)
+	if err == errNotModified {
+		return response, next, nil
+	}
 	indexes := result.CacheLinks
 	if err != nil {
-		return response, errors.New(
+		return response, next, errors.New(
 			fmt.Sprint(
 				"Get Endpoint failed because it couldn't get the index of the endpoint.",
 				", Error: ", err,
@@ -316,7 +657,16 @@ func GetEndpoint(host string, subhost string, port uint16, endpoint string, last
 				", Port: ", port,
 				", Endpoint: ", endpoint))
 	}
+	// Every cache that will be fetched counts as a page until its first page gives its page count.
+	peer := syncprogress.PeerKey(host, port)
+	for _, val := range indexes {
+		if bookmark.after(val) {
+			syncprogress.Plan(peer, endpoint, 1)
+		}
+	}
 	missingCacheCounter := 0
+	// The bookmark stops at the first cache that couldn't be read, so that the next sync tries it again.
+	missed := false
 	for _, val := range indexes {
 		// If the cache does end after our last checkin timestamp, we want to read that cache.
 		// ----------------- Why? -------------------------
@@ -327,18 +677,27 @@ func GetEndpoint(host string, subhost string, port uint16, endpoint string, last
 		// 5 6 7 (ends)
 		// 5,6,7 > lastcheckin = true.
 		// ------------------------------------------------
-		if val.EndsAt >= lastCheckin {
-			// Get the first page of the cache.
-			cache, err := GetCache(host, subhost, port,
-				fmt.Sprint(endpoint, "/", val.ResponseUrl))
+		// With a bookmark, the overlap is not needed: we know which cache we read last, and want the ones after it.
+		if bookmark.after(val) {
+			cache, err := GetLinkedCache(host, subhost, port, endpoint, val)
+			if IsLimitError(err) {
+				// A remote going over the limits is not a missing cache. Nothing more from it is taken.
+				response.AvailableTypes = getResponseTypes(response)
+				return response, next, err
+			}
 			response = concatResponses(response, cache)
 			if err == nil {
 				missingCacheCounter = 0 // Zero out the missing cache counter.
+				if !missed && (len(next.LastCache) == 0 || val.EndsAt >= next.LastCacheEnd) {
+					next.LastCache = val.ResponseUrl
+					next.LastCacheEnd = val.EndsAt
+				}
 			} else {
+				missed = true
 				missingCacheCounter++
 				if missingCacheCounter > 2 {
 					response.AvailableTypes = getResponseTypes(response)
-					return response, errors.New(
+					return response, next, errors.New(
 						fmt.Sprint(
 							"3 consequent cache misses. Stopping the download of this endpoint.",
 							", Error: ", err,
@@ -352,14 +711,20 @@ func GetEndpoint(host string, subhost string, port uint16, endpoint string, last
 		}
 
 	}
+	if !missed {
+		// Everything the index links to has been read, so the next poll can be conditional on this index.
+		next.IndexETag = indexETag(host, subhost, port, endpoint)
+	} else {
+		next.IndexETag = ""
+	}
 	response.AvailableTypes = getResponseTypes(response)
-	return response, nil
+	return response, next, nil
 }
 
 // GetRemoteNode downloads the entire remote node data by hitting all endpoints and all caches and all pages within them. This is the bootstrap function. This should be used when the local database is empty and the remote node is new. Never call this when the local database is not empty as that is fairly wasteful.
 func GetRemoteNode(host string, subhost string, port uint16) (Response, error) {
 	endpoints := []string{
-		"boards", "threads", "posts", "votes", "addresses", "keys", "truststates"}
+		"boards", "threads", "posts", "votes", "addresses", "keys", "truststates", "tombstones"}
 	var response Response
 	for _, endpoint := range endpoints {
 		resp, err := GetEndpoint(host, subhost, port, endpoint, 0)
@@ -383,8 +748,10 @@ func getIndexOfCache(
 	}
 	var resp Response
 	resp = InsertApiResponseToResponse(resp, firstIndexPage)
-	if firstIndexPage.Pagination.Pages > 0 {
-		for i := uint64(1); i < firstIndexPage.Pagination.Pages; i++ {
+	// The index pages of the older versions give the number of their last page, like the caches.
+	lastPage, errPages := lastPageOf(firstIndexPage.Pagination, 0)
+	if errPages == nil {
+		for i := uint64(1); i <= lastPage; i++ {
 			page, err := GetPageRaw(host, subhost, port,
 				fmt.Sprint(location, "/index/", i, ".json"), "GET", []byte{})
 			if err != nil {
@@ -404,10 +771,22 @@ func getIndexOfEndpoint(
 	EndpointIndexResponse, err := GetPageRaw(
 		host, subhost, port, fmt.Sprint(endpoint, "/index.json"), "GET", []byte{})
 	var resp Response
-	resp = InsertApiResponseToResponse(resp, EndpointIndexResponse)
 	if err != nil {
 		return resp, err
 	}
+	errNet := membership.CheckNetwork(EndpointIndexResponse.NetworkId)
+	if errNet != nil {
+		return resp, errNet
+	}
+	errIndex := CheckCacheIndex(EndpointIndexResponse.Results, EndpointIndexResponse.Timestamp)
+	if errIndex != nil {
+		return resp, fetchError(errIndex, host, subhost, port, fmt.Sprint(endpoint, "/index.json"))
+	}
+	errWitness := CheckWitnesses(EndpointIndexResponse.NodeId, endpoint, EndpointIndexResponse.Results)
+	if errWitness != nil {
+		return resp, fetchError(errWitness, host, subhost, port, fmt.Sprint(endpoint, "/index.json"))
+	}
+	resp = InsertApiResponseToResponse(resp, EndpointIndexResponse)
 	return resp, nil
 }
 
@@ -464,6 +843,14 @@ func checkForEntityInAnswer(a Answer, fp Fingerprint, t string) interface{} {
 				return entity
 			}
 		}
+	case "tombstones":
+		var entities []Tombstone
+		entities = append(entities, a.Tombstones...)
+		for _, entity := range entities {
+			if entity.Fingerprint == fp {
+				return entity
+			}
+		}
 	}
 	return nil
 }
@@ -494,7 +881,10 @@ func pullFullEntityFromCache(cacheUrl string, cachePage int, fingerprint Fingerp
 					", Fingerprint: ", fingerprint))
 		}
 		// And look at the page count, so we know how many times to iterate.
-		pageCount := pageResp.Pagination.Pages
+		pageCount, errPages := lastPageOf(pageResp.Pagination, 0)
+		if errPages != nil {
+			return nil, errPages
+		}
 		// Check the Answer type object to see whether we have it or not.
 		entity := checkForEntityInAnswer(pageResp.ResponseBody, fingerprint, t)
 		if entity == nil {
@@ -559,9 +949,9 @@ type QueryData struct {
 func Query(host string, subhost string, port uint16, q QueryData) (Response, error) {
 	// TODO: Look at the timestamps (update if present, if not, creation, if not, go linear starting from most recent)
 	var r Response
-	// Before doing anything else, if the type is thread or post, disable LastUpdate. Those items are not updateable.
+	// Before doing anything else, if the type is thread, post or tombstone, disable LastUpdate. Those items are not updateable.
 	updateFieldEnabled := true
-	if q.EntityType == "posts" || q.EntityType == "threads" {
+	if q.EntityType == "posts" || q.EntityType == "threads" || q.EntityType == "tombstones" {
 		updateFieldEnabled = false
 	}
 	result, err := getIndexOfEndpoint(host, subhost, port, q.EntityType)
@@ -755,6 +1145,31 @@ CacheIterator: // Naming the for loop CacheIterator.
 					break CacheIterator
 				}
 			}
+		case "tombstones":
+			entities := cIndex.TombstoneIndexes
+			// For each of those entities,
+			for _, entityIndex := range entities {
+				// Check if this is what we want.
+				if entityIndex.Fingerprint == q.Fingerprint {
+					// If so, pull the result from cache.
+					obj, err := pullFullEntityFromCache(cacheLocation, entityIndex.PageNumber, q.Fingerprint, q.EntityType, host, subhost, port)
+					if err != nil {
+						r.AvailableTypes = getResponseTypes(r)
+						return r, errors.New(
+							fmt.Sprint(
+								"Could not pull entity from cache. The item is indexed as available in the remote node, but the actual body of the item is not available.",
+								", Error: ", err,
+								", Host: ", host,
+								", Subhost: ", subhost,
+								", Port: ", port,
+								", QueryData: ", q))
+					}
+					// And put into the proper part of the response.
+					r.Tombstones = append(r.Tombstones, obj.(Tombstone))
+					// And finally, break the for loop, so it won't look at other caches when it's done.
+					break CacheIterator
+				}
+			}
 		}
 	}
 	r.AvailableTypes = getResponseTypes(r)
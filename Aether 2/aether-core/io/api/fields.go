@@ -0,0 +1,87 @@
+// API > Fields
+// This file has the fields filter, which asks for only some of the fields of the entities in a POST response, such as the posts without their bodies for building an index. The entities of such a response are partial, so they can't be verified or ingested: their fingerprints and their signatures are over all of their fields. The response says which fields it has, and its answer is written with only those fields of the entities, so the fields that weren't asked for are not sent at all.
+
+package api
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// FieldsFilter is the type of the filter that lists the fields of the entities the requester wants, by their names in the JSON.
+const FieldsFilter = "fields"
+
+// maxFields is how many fields a fields filter can list. No entity has more.
+const maxFields = 32
+
+// NormaliseFields gives the fields of a fields filter in lowercase, without the empty ones and the repeated ones, and with the fingerprint, which every entity is given with. It gives nothing if no field was asked for.
+func NormaliseFields(values []string) []string {
+	var fields []string
+	seen := map[string]bool{"fingerprint": true}
+	for _, v := range values {
+		f := strings.ToLower(strings.TrimSpace(v))
+		if len(f) == 0 || seen[f] {
+			continue
+		}
+		if len(fields) >= maxFields {
+			break
+		}
+		seen[f] = true
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return append([]string{"fingerprint"}, fields...)
+}
+
+// SelectFields makes the answer be written with only the given fields of its entities. No fields is all of them.
+func (a *Answer) SelectFields(fields []string) {
+	a.fields = fields
+}
+
+// entityLists are the keys of the lists of the entities in the JSON of an answer. The indexes and the vote summaries are written whole.
+var entityLists = []string{"boards", "threads", "posts", "votes", "keys", "addresses", "truststates", "tombstones"}
+
+// MarshalJSON writes the answer, with only the selected fields of its entities if there are any.
+func (a Answer) MarshalJSON() ([]byte, error) {
+	// plainAnswer has the fields of Answer but not this method, so that it is written the default way.
+	type plainAnswer Answer
+	data, err := json.Marshal(plainAnswer(a))
+	if err != nil || len(a.fields) == 0 {
+		return data, err
+	}
+	wanted := make(map[string]bool)
+	for _, f := range a.fields {
+		wanted[f] = true
+	}
+	var lists map[string]json.RawMessage
+	err2 := json.Unmarshal(data, &lists)
+	if err2 != nil {
+		return data, err2
+	}
+	for _, key := range entityLists {
+		raw, ok := lists[key]
+		if !ok {
+			continue
+		}
+		var entities []map[string]json.RawMessage
+		err3 := json.Unmarshal(raw, &entities)
+		if err3 != nil {
+			return data, err3
+		}
+		for i, _ := range entities {
+			for field, _ := range entities[i] {
+				if !wanted[field] {
+					delete(entities[i], field)
+				}
+			}
+		}
+		trimmed, err4 := json.Marshal(entities)
+		if err4 != nil {
+			return data, err4
+		}
+		lists[key] = trimmed
+	}
+	return json.Marshal(lists)
+}
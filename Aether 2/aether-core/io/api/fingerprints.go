@@ -0,0 +1,25 @@
+// API > Fingerprints
+// This file has the manifests of the recent fingerprints. A node that announces the fingerprints extension gives, for a time range, the fingerprint and the last update of every entity that arrived in it, without the bodies and without the indexes, so that a remote can see what it doesn't have, or has an older update of, before it commits to downloading the range. What it needs it then asks for by its fingerprints.
+
+package api
+
+// FingerprintsExtension is the protocol extension of the nodes that serve the manifests of the recent fingerprints.
+const FingerprintsExtension = "fingerprints"
+
+// EntityTypeFilter is the filter of a fingerprints request that gives the entity types to list. Without it, all the entity types that are signed by their owners are listed.
+const EntityTypeFilter = "entity_type"
+
+// FpEntry is an entity in a manifest. The entities that can't be updated give their creation as their last update.
+type FpEntry struct {
+	Fingerprint Fingerprint `json:"fingerprint"`
+	LastUpdate  Timestamp   `json:"last_update"`
+}
+
+// FpManifest is the list of the entities of an entity type that arrived in a time range. A manifest that is Truncated has all the entities that arrived up to EndsAt, and the remote asks for the rest with a time range that starts at EndsAt.
+type FpManifest struct {
+	EntityType string    `json:"entity_type"`
+	StartsFrom Timestamp `json:"starts_from"`
+	EndsAt     Timestamp `json:"ends_at"`
+	Truncated  bool      `json:"truncated,omitempty"`
+	Entries    []FpEntry `json:"entries"`
+}
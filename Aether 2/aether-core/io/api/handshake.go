@@ -0,0 +1,188 @@
+// API > Handshake
+// This file has the handshake the syncs start with. Both sides send who they are, the protocol version and the extensions they support, their time and their serving mode, signed with their node keys, before any of the data endpoints are used. The requester sends a nonce in its handshake, and the remote signs it back in its own, so a handshake can't be replayed from another sync.
+// What the handshake settles is kept as the connection to the remote, for the rest of the sync: an extension is used only if both sides announced it, and how far the clock of the remote is off is known. The remotes that don't know the handshake are synced with as before, by what they announce in their node responses.
+
+package api
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/signaturing"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HandshakeExtension is the protocol extension of the nodes that respond to the handshake.
+const HandshakeExtension = "handshake"
+
+// Handshake is what each side of a connection sends about itself at its start.
+type Handshake struct {
+	NodeId       Fingerprint `json:"node_id"`
+	VersionMajor uint8       `json:"version_major"`
+	VersionMinor uint16      `json:"version_minor"`
+	Extensions   []string    `json:"extensions"`
+	Time         Timestamp   `json:"time"`
+	Serving      string      `json:"serving"` // The serving mode of the node, "full" or "light".
+	Nonce        string      `json:"nonce"`   // Chosen by the requester. The remote signs it back in its own handshake.
+	PublicKey    string      `json:"public_key"`
+	Signature    Signature   `json:"signature"`
+}
+
+// maxHandshakeExtensions is how many extensions a handshake can announce, as many as a node response can.
+const maxHandshakeExtensions = 100
+
+// handshakeInput is what a handshake is signed over.
+func handshakeInput(h Handshake) string {
+	return fmt.Sprint("handshake:", h.NodeId, ":", h.VersionMajor, ":", h.VersionMinor, ":", strings.Join(h.Extensions, ","), ":", h.Time, ":", h.Serving, ":", h.Nonce)
+}
+
+// NewHandshake makes the handshake of this node with the extensions it supports, and signs it with the node key. A program that is not a node has no key, and its handshake is not signed.
+func NewHandshake(extensions []string, nonce string) (Handshake, error) {
+	h := Handshake{
+		NodeId:       Fingerprint(globals.NodeId),
+		VersionMajor: uint8(globals.ProtocolVersionMajor),
+		VersionMinor: uint16(globals.ProtocolVersionMinor),
+		Extensions:   extensions,
+		Time:         Timestamp(clock.Unix()),
+		Serving:      globals.ServingMode,
+		Nonce:        nonce,
+	}
+	if globals.KeyPair == nil {
+		return h, nil
+	}
+	sig, err := signaturing.Sign(handshakeInput(h), globals.KeyPair)
+	if err != nil {
+		return h, err
+	}
+	h.PublicKey = globals.MarshaledPubKey
+	h.Signature = Signature(sig)
+	return h, nil
+}
+
+// VerifyHandshake checks the handshake of a remote. It has to be signed, and if expectedKey is given, by that key, which is the one of the node response of the remote. nonce is the one sent in the handshake it answers, if any.
+func VerifyHandshake(h Handshake, expectedKey string, nonce string) error {
+	if len(h.Extensions) > maxHandshakeExtensions {
+		return errors.New(fmt.Sprintf("The handshake announces too many extensions. Node: %s, Extensions: %d", h.NodeId, len(h.Extensions)))
+	}
+	if len(h.Signature) == 0 || len(h.PublicKey) == 0 {
+		return errors.New(fmt.Sprintf("The handshake is not signed. Node: %s", h.NodeId))
+	}
+	if len(expectedKey) > 0 && h.PublicKey != expectedKey {
+		return errors.New(fmt.Sprintf("The handshake is signed by another key than the one of the node response. Node: %s", h.NodeId))
+	}
+	if h.Nonce != nonce {
+		return errors.New(fmt.Sprintf("The handshake does not answer the one that was sent. Node: %s", h.NodeId))
+	}
+	if !signaturing.Verify(handshakeInput(h), string(h.Signature), h.PublicKey) {
+		return errors.New(fmt.Sprintf("The signature of the handshake is not valid. Node: %s", h.NodeId))
+	}
+	return nil
+}
+
+// Connection is what a handshake settled between this node and a remote.
+type Connection struct {
+	Remote      Handshake `json:"remote"`
+	Extensions  []string  `json:"extensions"`   // The extensions both sides announced.
+	ClockOffset int64     `json:"clock_offset"` // How far the clock of the remote is ahead of this one, in seconds.
+	Verified    bool      `json:"verified"`     // Whether the handshake of the remote was signed. The programs that are not nodes have no keys.
+	Established Timestamp `json:"established"`
+}
+
+// NewConnection settles the connection from the two handshakes. sentAt is when the local one was sent; the time of the remote is taken to be halfway through the round trip.
+func NewConnection(local Handshake, remote Handshake, sentAt time.Time, verified bool) Connection {
+	now := clock.Now()
+	midpoint := sentAt.Add(now.Sub(sentAt) / 2)
+	c := Connection{Remote: remote, ClockOffset: int64(remote.Time) - midpoint.Unix(), Verified: verified, Established: Timestamp(now.Unix())}
+	announced := make(map[string]bool)
+	for _, ext := range remote.Extensions {
+		announced[ext] = true
+	}
+	for _, ext := range local.Extensions {
+		if announced[ext] {
+			c.Extensions = append(c.Extensions, ext)
+		}
+	}
+	return c
+}
+
+// Has checks whether both sides announced the extension.
+func (c Connection) Has(extension string) bool {
+	for _, ext := range c.Extensions {
+		if ext == extension {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckClock gives an error if the clock of the remote is further off than the node allows. The entities the remote creates, and the ranges of its caches, go by its clock.
+func (c Connection) CheckClock() error {
+	skew := time.Duration(c.ClockOffset) * time.Second
+	if skew < 0 {
+		skew = -skew
+	}
+	if globals.HandshakeMaxClockSkew > 0 && skew > globals.HandshakeMaxClockSkew {
+		return errors.New(fmt.Sprintf("The clock of the remote is too far off. Node: %s, Offset: %ds, Allowed: %s", c.Remote.NodeId, c.ClockOffset, globals.HandshakeMaxClockSkew))
+	}
+	return nil
+}
+
+// maxConnections bounds how many connections are kept on each side. The oldest one goes first.
+const maxConnections = 1024
+
+// The connections this node made, by the host and the port of the remote, and the ones the remotes made to this node, by their node ids. A connection is set again at the start of every sync, like the pinned keys.
+var connectionsLock sync.Mutex
+var outbound = make(map[string]Connection)
+var inbound = make(map[string]Connection)
+
+func keepConnection(connections map[string]Connection, key string, c Connection) {
+	connectionsLock.Lock()
+	defer connectionsLock.Unlock()
+	if _, ok := connections[key]; !ok && len(connections) >= maxConnections {
+		var oldest string
+		for k, existing := range connections {
+			if len(oldest) == 0 || existing.Established < connections[oldest].Established {
+				oldest = k
+			}
+		}
+		delete(connections, oldest)
+	}
+	connections[key] = c
+}
+
+func findConnection(connections map[string]Connection, key string) (Connection, bool) {
+	connectionsLock.Lock()
+	defer connectionsLock.Unlock()
+	c, ok := connections[key]
+	return c, ok
+}
+
+// SetConnection keeps the connection to the remote at the host and the port.
+func SetConnection(host string, port uint16, c Connection) {
+	keepConnection(outbound, pinKey(host, port), c)
+}
+
+// ForgetConnection removes the connection to the remote, before a handshake, so that a remote that no longer knows the handshake is not taken to have settled anything.
+func ForgetConnection(host string, port uint16) {
+	connectionsLock.Lock()
+	defer connectionsLock.Unlock()
+	delete(outbound, pinKey(host, port))
+}
+
+// ConnectionTo gives the connection to the remote at the host and the port, if there was a handshake with it.
+func ConnectionTo(host string, port uint16) (Connection, bool) {
+	return findConnection(outbound, pinKey(host, port))
+}
+
+// SetInboundConnection keeps the connection a remote made to this node.
+func SetInboundConnection(node Fingerprint, c Connection) {
+	keepConnection(inbound, string(node), c)
+}
+
+// InboundConnection gives the connection the remote with the node id made to this node, if it made one with a handshake.
+func InboundConnection(node Fingerprint) (Connection, bool) {
+	return findConnection(inbound, string(node))
+}
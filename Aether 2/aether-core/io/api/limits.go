@@ -0,0 +1,158 @@
+// API > Limits
+// This file enforces the maximum sizes of what arrives from remotes. A remote that sends a page with millions of entities, or a post the size of a book, is either broken or malicious; either way, the page is rejected instead of being committed.
+
+package api
+
+import (
+	"aether-core/services/globals"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// limitErrorPrefix starts the message of every error that is caused by a remote going over a limit, so that the callers can tell these apart from network errors.
+const limitErrorPrefix = "Inbound limit exceeded."
+
+// Maximum lengths of the lists within entities. These are the maxima given in the entity definitions.
+const (
+	maxBoardOwners       = 100
+	maxCurrencyAddresses = 10
+	maxTrustDomains      = 100
+	maxAddressEndpoints  = 10
+	maxLanguageBytes     = 16
+)
+
+func limitError(detail string) error {
+	return errors.New(fmt.Sprint(limitErrorPrefix, " ", detail))
+}
+
+// IsLimitError checks whether the error was caused by a remote sending more than the limits allow.
+func IsLimitError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), limitErrorPrefix)
+}
+
+// countAnswerItems counts every entity and index in a page.
+func countAnswerItems(a *Answer) int {
+	return len(a.Boards) + len(a.BoardIndexes) +
+		len(a.Threads) + len(a.ThreadIndexes) +
+		len(a.Posts) + len(a.PostIndexes) +
+		len(a.Votes) + len(a.VoteIndexes) +
+		len(a.Keys) + len(a.KeyIndexes) +
+		len(a.Addresses) + len(a.AddressIndexes) +
+		len(a.Truststates) + len(a.TruststateIndexes) +
+		len(a.Tombstones) + len(a.TombstoneIndexes)
+}
+
+func checkField(entityType string, fp Fingerprint, field string, value string) error {
+	if len(value) > globals.InboundMaxFieldBytes {
+		return limitError(fmt.Sprintf("A field is larger than allowed. Entity type: %s, Fingerprint: %s, Field: %s, Size: %d, Maximum: %d", entityType, fp, field, len(value), globals.InboundMaxFieldBytes))
+	}
+	return nil
+}
+
+func checkLanguage(entityType string, fp Fingerprint, value string) error {
+	if len(value) > maxLanguageBytes {
+		return limitError(fmt.Sprintf("The language tag is longer than allowed. Entity type: %s, Fingerprint: %s, Size: %d, Maximum: %d", entityType, fp, len(value), maxLanguageBytes))
+	}
+	return nil
+}
+
+func checkList(entityType string, fp Fingerprint, field string, length int, max int) error {
+	if length > max {
+		return limitError(fmt.Sprintf("A list is longer than allowed. Entity type: %s, Fingerprint: %s, Field: %s, Length: %d, Maximum: %d", entityType, fp, field, length, max))
+	}
+	return nil
+}
+
+// CheckPageLimits checks a page that arrived from a remote against the inbound limits. It returns an error on the first violation.
+func CheckPageLimits(apiresp *ApiResponse) error {
+	a := &apiresp.ResponseBody
+	if count := countAnswerItems(a); count > globals.InboundMaxPageEntities {
+		return limitError(fmt.Sprintf("The page has more entities than allowed. Count: %d, Maximum: %d", count, globals.InboundMaxPageEntities))
+	}
+	if count := len(apiresp.Results); count > globals.InboundMaxPageEntities {
+		return limitError(fmt.Sprintf("The page has more cache links than allowed. Count: %d, Maximum: %d", count, globals.InboundMaxPageEntities))
+	}
+	if count := len(apiresp.Results); count > globals.ValidationMaxResultCaches {
+		return limitError(fmt.Sprintf("The page has more cache links than the validation policy allows. Count: %d, Maximum: %d", count, globals.ValidationMaxResultCaches))
+	}
+	for i, _ := range a.Boards {
+		e := &a.Boards[i]
+		if err := checkField("boards", e.Fingerprint, "name", e.Name); err != nil {
+			return err
+		}
+		if err := checkField("boards", e.Fingerprint, "description", e.Description); err != nil {
+			return err
+		}
+		if err := checkList("boards", e.Fingerprint, "board_owners", len(e.BoardOwners), maxBoardOwners); err != nil {
+			return err
+		}
+		if err := checkLanguage("boards", e.Fingerprint, e.Language); err != nil {
+			return err
+		}
+		if err := CheckMeta("boards", e.Fingerprint, e.Meta); err != nil {
+			return err
+		}
+	}
+	for i, _ := range a.Threads {
+		e := &a.Threads[i]
+		if err := checkField("threads", e.Fingerprint, "name", e.Name); err != nil {
+			return err
+		}
+		if err := checkField("threads", e.Fingerprint, "body", e.Body); err != nil {
+			return err
+		}
+		if err := checkField("threads", e.Fingerprint, "link", e.Link); err != nil {
+			return err
+		}
+		if err := checkLanguage("threads", e.Fingerprint, e.Language); err != nil {
+			return err
+		}
+		if err := CheckMeta("threads", e.Fingerprint, e.Meta); err != nil {
+			return err
+		}
+	}
+	for i, _ := range a.Posts {
+		e := &a.Posts[i]
+		if err := checkField("posts", e.Fingerprint, "body", e.Body); err != nil {
+			return err
+		}
+		if err := CheckMeta("posts", e.Fingerprint, e.Meta); err != nil {
+			return err
+		}
+	}
+	for i, _ := range a.Keys {
+		e := &a.Keys[i]
+		if err := checkField("keys", e.Fingerprint, "key", e.Key); err != nil {
+			return err
+		}
+		if err := checkField("keys", e.Fingerprint, "name", e.Name); err != nil {
+			return err
+		}
+		if err := checkField("keys", e.Fingerprint, "info", e.Info); err != nil {
+			return err
+		}
+		if err := checkList("keys", e.Fingerprint, "currency_addresses", len(e.CurrencyAddresses), maxCurrencyAddresses); err != nil {
+			return err
+		}
+	}
+	for i, _ := range a.Truststates {
+		e := &a.Truststates[i]
+		if err := checkList("truststates", e.Fingerprint, "domain", len(e.Domains), maxTrustDomains); err != nil {
+			return err
+		}
+	}
+	for i, _ := range a.Addresses {
+		e := &a.Addresses[i]
+		if err := checkField("addresses", "", "location", string(e.Location)); err != nil {
+			return err
+		}
+		if err := checkField("addresses", "", "sublocation", string(e.Sublocation)); err != nil {
+			return err
+		}
+		if err := checkList("addresses", "", "endpoints", len(e.Endpoints), maxAddressEndpoints); err != nil {
+			return err
+		}
+	}
+	return nil
+}
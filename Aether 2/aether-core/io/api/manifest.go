@@ -0,0 +1,149 @@
+// API > Manifest
+// This file has the checksum manifest of a cache: the list of its pages, with the size and the SHA256 of every one of them. It is written into the cache folder when the cache is baked, and its own hash is in the index of the endpoint, so a remote that downloads the cache can verify every page as it arrives, and knows which pages there should be without parsing any of them.
+
+package api
+
+import (
+	"aether-core/services/globals"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ManifestFile is the name of the manifest in the cache folder.
+const ManifestFile = "manifest.json"
+
+type ManifestPage struct {
+	Name   string `json:"name"` // "0.json" for the entity pages, "index/0.json" for the index pages.
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+type CacheManifest struct {
+	Cache     string         `json:"cache"`
+	Timestamp Timestamp      `json:"timestamp"`
+	Pages     []ManifestPage `json:"pages"`
+}
+
+// HashBytes gives the SHA256 of the data in hex, as it is given in the manifests and the page hashes.
+func HashBytes(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// Page gives the page with the given name in the manifest.
+func (m *CacheManifest) Page(name string) (ManifestPage, bool) {
+	for i, _ := range m.Pages {
+		if m.Pages[i].Name == name {
+			return m.Pages[i], true
+		}
+	}
+	return ManifestPage{}, false
+}
+
+// EntityPageCount gives how many entity pages the cache has.
+func (m *CacheManifest) EntityPageCount() int {
+	count := 0
+	for i, _ := range m.Pages {
+		if !strings.HasPrefix(m.Pages[i].Name, "index/") {
+			count++
+		}
+	}
+	return count
+}
+
+// Verify checks that the data is the page, first by its size, then by its hash.
+func (p ManifestPage) Verify(data []byte) error {
+	if int64(len(data)) != p.Size {
+		return errors.New(fmt.Sprintf("The page is not the size the manifest gives. Page: %s, Size: %d, Manifest: %d", p.Name, len(data), p.Size))
+	}
+	if HashBytes(data) != p.Sha256 {
+		return errors.New(fmt.Sprintf("The page does not match the hash the manifest gives. Page: %s", p.Name))
+	}
+	return nil
+}
+
+// manifestPageNumber gives the number of the page from its name in the manifest, such as 3 for "index/3.json", or -1 if the name is not that of a page.
+func manifestPageNumber(name string) int {
+	name = strings.TrimPrefix(name, "index/")
+	if !strings.HasSuffix(name, ".json") {
+		return -1
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(name, ".json"))
+	if err != nil || n < 0 || fmt.Sprint(n, ".json") != name {
+		return -1
+	}
+	return n
+}
+
+// CheckManifest checks that a manifest lists the pages of a cache, and nothing else: the entity pages and the index pages are each numbered from 0 without gaps, no page is over the inbound page limit, and there aren't more of them than a cache can have.
+func CheckManifest(m *CacheManifest) error {
+	if len(m.Pages) > 2*globals.InboundMaxCachePages {
+		return limitError(fmt.Sprintf("The manifest lists more pages than a cache can have. Pages: %d, Maximum: %d", len(m.Pages), 2*globals.InboundMaxCachePages))
+	}
+	seen := make(map[string]bool)
+	var entityPages, indexPages int
+	for i, _ := range m.Pages {
+		p := &m.Pages[i]
+		if manifestPageNumber(p.Name) == -1 || seen[p.Name] {
+			return limitError(fmt.Sprintf("The manifest lists a page whose name is not valid, or lists it twice. Page: %q", p.Name))
+		}
+		seen[p.Name] = true
+		if p.Size < 0 || p.Size > globals.InboundMaxPageBytes || len(p.Sha256) != 64 {
+			return limitError(fmt.Sprintf("The manifest gives a page a size over the limits, or a hash that is not a SHA256. Page: %s, Size: %d", p.Name, p.Size))
+		}
+		if strings.HasPrefix(p.Name, "index/") {
+			indexPages++
+		} else {
+			entityPages++
+		}
+	}
+	for i := 0; i < entityPages; i++ {
+		if !seen[fmt.Sprint(i, ".json")] {
+			return limitError(fmt.Sprintf("The entity pages in the manifest are not numbered without gaps. Missing page: %d.json", i))
+		}
+	}
+	for i := 0; i < indexPages; i++ {
+		if !seen[fmt.Sprint("index/", i, ".json")] {
+			return limitError(fmt.Sprintf("The index pages in the manifest are not numbered without gaps. Missing page: index/%d.json", i))
+		}
+	}
+	if entityPages == 0 {
+		return limitError("The manifest lists no entity pages.")
+	}
+	return nil
+}
+
+// ParseManifest reads a manifest, and checks that it is the one with the given hash, if there is one, and that it lists the pages of a cache.
+func ParseManifest(data []byte, expectedHash string) (*CacheManifest, error) {
+	if len(expectedHash) > 0 && HashBytes(data) != expectedHash {
+		return nil, errors.New("The manifest does not match the hash given in the index.")
+	}
+	var m CacheManifest
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("The manifest is malformed. Error: %s", err))
+	}
+	err2 := CheckManifest(&m)
+	if err2 != nil {
+		return nil, err2
+	}
+	return &m, nil
+}
+
+// getManifest gets the manifest of the cache at the location, and checks it against the hash given in the index.
+func getManifest(host string, subhost string, port uint16, location string, expectedHash string) (*CacheManifest, error) {
+	data, err := Fetch(host, subhost, port, fmt.Sprint(location, "/", ManifestFile), "GET", []byte{})
+	if err != nil {
+		return nil, err
+	}
+	m, err2 := ParseManifest(data, expectedHash)
+	if err2 != nil {
+		return nil, fetchError(err2, host, subhost, port, location)
+	}
+	return m, nil
+}
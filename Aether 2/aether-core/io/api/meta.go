@@ -0,0 +1,55 @@
+// API > Meta
+// This file defines the metadata that clients can attach to boards, threads and posts, such as the flair of a thread or the version of the rules of a board, without a change to the protocol. The metadata is signed with the entity, and it is small: a few string keys and values, within a strict total size.
+
+package api
+
+import (
+	"fmt"
+)
+
+// Meta is the metadata of an entity. The keys are chosen by the clients; the nodes only carry them.
+type Meta map[string]string
+
+const (
+	maxMetaKeys     = 16
+	maxMetaKeyBytes = 64
+	maxMetaBytes    = 1024 // Of all the keys and values together.
+)
+
+// validMetaKey checks that the key is made of lowercase letters, digits, '.', '_' and '-'. This keeps the keys of different clients comparable, and the JSON of the metadata free of escapes.
+func validMetaKey(key string) bool {
+	if len(key) == 0 || len(key) > maxMetaKeyBytes {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '.' && c != '_' && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// Size is the total length of the keys and values of the metadata, in bytes.
+func (m Meta) Size() int {
+	size := 0
+	for k, v := range m {
+		size += len(k) + len(v)
+	}
+	return size
+}
+
+// CheckMeta checks that the metadata is within its limits. It is a limit error if it is not, so that the entities of a remote that sends it are rejected like the other oversized ones.
+func CheckMeta(entityType string, fp Fingerprint, m Meta) error {
+	if len(m) > maxMetaKeys {
+		return limitError(fmt.Sprintf("The metadata has more keys than allowed. Entity type: %s, Fingerprint: %s, Keys: %d, Maximum: %d", entityType, fp, len(m), maxMetaKeys))
+	}
+	if size := m.Size(); size > maxMetaBytes {
+		return limitError(fmt.Sprintf("The metadata is larger than allowed. Entity type: %s, Fingerprint: %s, Size: %d, Maximum: %d", entityType, fp, size, maxMetaBytes))
+	}
+	for k, _ := range m {
+		if !validMetaKey(k) {
+			return limitError(fmt.Sprintf("A key of the metadata is not valid. Keys are up to %d bytes of lowercase letters, digits, '.', '_' and '-'. Entity type: %s, Fingerprint: %s, Key: %q", maxMetaKeyBytes, entityType, fp, k))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// API > Normalize
+// This file normalizes the text fields of the entities: to Unicode NFC, without the control characters and the bidirectional overrides, and with the raw HTML in them handled as the markup policy says. The entities this node creates are normalized before they are signed and fingerprinted. The ones that arrive can't be changed without breaking their signatures, so the inbound text policy decides what happens to the ones that are not normalized already when they are ingested: "drop" drops them, "flag" logs them and takes them, and "accept" takes them. The policy is not applied again when the entities are served, so that a change of it doesn't take the entities already taken out of the caches.
+
+package api
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// htmlTag matches a raw HTML tag, comment or doctype in the markdown of a text field.
+var htmlTag = regexp.MustCompile(`<(/?[a-zA-Z][a-zA-Z0-9-]*(\s[^<>]*)?/?|!--[\s\S]*?--|![a-zA-Z][^<>]*)>`)
+
+// disallowedRune checks whether a character is removed from the text fields: the control characters other than the tab and the line breaks, and the embeddings, overrides and isolates (U+202A to U+202E, U+2066 to U+2069), which can make a text render as something else, such as a link that reads as another one. The direction marks (U+200E, U+200F, U+061C) are kept, since the right-to-left texts need them to render correctly, and they can't reorder a text on their own.
+func disallowedRune(r rune) bool {
+	switch {
+	case r == '\t' || r == '\n' || r == '\r':
+		return false
+	case r >= '\u202a' && r <= '\u202e', r >= '\u2066' && r <= '\u2069':
+		return true
+	}
+	return unicode.IsControl(r)
+}
+
+// NormalizeText normalizes a text to NFC, and removes the disallowed characters from it. The markup policy is not applied; see NormalizeEntity.
+func NormalizeText(text string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if disallowedRune(r) {
+			return -1
+		}
+		return r
+	}, text)
+	return norm.NFC.String(cleaned)
+}
+
+// applyMarkupPolicy applies the markup policy to a text: "allow" leaves the raw HTML in it, "strip" removes the tags, and "reject" refuses a text with any.
+func applyMarkupPolicy(field string, text string) (string, error) {
+	switch globals.TextMarkupPolicy {
+	case "strip":
+		return htmlTag.ReplaceAllString(text, ""), nil
+	case "reject":
+		if htmlTag.MatchString(text) {
+			return text, errors.New(fmt.Sprintf("A field has raw HTML in it, and the markup policy rejects it. Field: %s", field))
+		}
+	}
+	return text, nil
+}
+
+// normalizeField normalizes a text field, and applies the markup policy to it if it is one that is rendered as markdown.
+func normalizeField(field string, text string) (string, error) {
+	normalized := NormalizeText(text)
+	if !markupFields[field] {
+		return normalized, nil
+	}
+	return applyMarkupPolicy(field, normalized)
+}
+
+// markupFields are the text fields that the clients render as markdown.
+var markupFields = map[string]bool{
+	"boards.description": true,
+	"threads.body":       true,
+	"posts.body":         true,
+	"keys.info":          true,
+}
+
+// textFields gives the text fields of an entity, by their names in the validation policy, as pointers so that they can be normalized in place. The entity types that have no text fields give none.
+func textFields(entity interface{}) map[string]*string {
+	switch e := entity.(type) {
+	case *Board:
+		return map[string]*string{"boards.name": &e.Name, "boards.description": &e.Description}
+	case *Thread:
+		return map[string]*string{"threads.name": &e.Name, "threads.body": &e.Body, "threads.link": &e.Link}
+	case *Post:
+		return map[string]*string{"posts.body": &e.Body}
+	case *Key:
+		return map[string]*string{"keys.name": &e.Name, "keys.info": &e.Info}
+	}
+	return nil
+}
+
+// NormalizeEntity normalizes the text fields of an entity this node creates, in place. It is called before the entity is signed and fingerprinted.
+func NormalizeEntity(entity interface{}) error {
+	for field, ptr := range textFields(entity) {
+		normalized, err := normalizeField(field, *ptr)
+		if err != nil {
+			return err
+		}
+		*ptr = normalized
+	}
+	return nil
+}
+
+// CheckNormalized checks that the text fields of an entity that arrived are normalized already.
+func CheckNormalized(entity interface{}) error {
+	for field, ptr := range textFields(entity) {
+		normalized, err := normalizeField(field, *ptr)
+		if err != nil {
+			return limitError(err.Error())
+		}
+		if normalized != *ptr {
+			return limitError(fmt.Sprintf("A field is not normalized: it is not in NFC, or it has control characters, bidirectional overrides or raw HTML the markup policy strips. Field: %s", field))
+		}
+	}
+	return nil
+}
+
+// admitText applies the inbound text policy to an entity that is being ingested, and tells whether it is kept.
+func admitText(entityType string, fp Fingerprint, entity interface{}) bool {
+	if globals.TextInboundPolicy == "accept" {
+		return true
+	}
+	err := CheckNormalized(entity)
+	if err == nil {
+		return true
+	}
+	if globals.TextInboundPolicy == "flag" {
+		logging.LogSampled("api", "text-policy-flag", 2, fmt.Sprintf("This entity is not normalized. It is kept, since the inbound text policy is flag. Entity type: %s, Fingerprint: %s, Error: %s", entityType, fp, err))
+		return true
+	}
+	logging.LogSampled("api", "text-policy-drop", 2, fmt.Sprintf("This entity is not normalized, so it is dropped. Entity type: %s, Fingerprint: %s, Error: %s", entityType, fp, err))
+	return false
+}
+
+// FilterByTextPolicy applies the inbound text policy to the entities of a response. This is applied on ingest only, after FilterByPolicy.
+func FilterByTextPolicy(resp Response) Response {
+	cleanedResp := resp
+	cleanedResp.Boards = nil
+	cleanedResp.Threads = nil
+	cleanedResp.Posts = nil
+	cleanedResp.Keys = nil
+	for i, _ := range resp.Boards {
+		if admitText("boards", resp.Boards[i].Fingerprint, &resp.Boards[i]) {
+			cleanedResp.Boards = append(cleanedResp.Boards, resp.Boards[i])
+		}
+	}
+	for i, _ := range resp.Threads {
+		if admitText("threads", resp.Threads[i].Fingerprint, &resp.Threads[i]) {
+			cleanedResp.Threads = append(cleanedResp.Threads, resp.Threads[i])
+		}
+	}
+	for i, _ := range resp.Posts {
+		if admitText("posts", resp.Posts[i].Fingerprint, &resp.Posts[i]) {
+			cleanedResp.Posts = append(cleanedResp.Posts, resp.Posts[i])
+		}
+	}
+	for i, _ := range resp.Keys {
+		if admitText("keys", resp.Keys[i].Fingerprint, &resp.Keys[i]) {
+			cleanedResp.Keys = append(cleanedResp.Keys, resp.Keys[i])
+		}
+	}
+	return cleanedResp
+}
@@ -0,0 +1,129 @@
+// API > Page Ranges
+// This file gets a range of the pages of a cache in one request, such as the pages 12 to 20 of a cache whose download stopped partway, instead of asking for every page by itself. The remote sends the pages one after another, as they are, and they are split by the sizes in the manifest of the cache, and checked against its hashes, before any of them is parsed. So only the caches with a manifest can be read in ranges.
+
+package api
+
+import (
+	"aether-core/services/logging"
+	"aether-core/services/membership"
+	"aether-core/services/syncprogress"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PageRangesExtension is the protocol extension of the nodes that serve a range of the pages of a cache in one request.
+const PageRangesExtension = "page_ranges"
+
+// PageRangeLocation gives the location of the entity pages from first to last, both included, of the cache at the location, such as "posts/cache_x".
+func PageRangeLocation(location string, first int, last int) string {
+	return fmt.Sprint(location, "?range=", first, "-", last)
+}
+
+// ParsePageRange reads the value of a range parameter, such as "12-20".
+func ParsePageRange(value string) (int, int, error) {
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.New(fmt.Sprintf("The page range is malformed. Range: %q", value))
+	}
+	first, err := strconv.Atoi(parts[0])
+	last, err2 := strconv.Atoi(parts[1])
+	if err != nil || err2 != nil || first < 0 || last < first {
+		return 0, 0, errors.New(fmt.Sprintf("The page range is malformed. Range: %q", value))
+	}
+	return first, last, nil
+}
+
+// SplitPageRange splits the entity pages from first to last that arrived one after another by the sizes the manifest gives them, and checks every one against its hash.
+func SplitPageRange(data []byte, m *CacheManifest, first int, last int) ([][]byte, error) {
+	var pages [][]byte
+	offset := int64(0)
+	for i := first; i <= last; i++ {
+		listed, ok := m.Page(fmt.Sprint(i, ".json"))
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("The page is not listed in the manifest of the cache. Page: %d.json", i))
+		}
+		if offset+listed.Size > int64(len(data)) {
+			return nil, errors.New(fmt.Sprintf("The page range is shorter than the manifest gives. Page: %s", listed.Name))
+		}
+		page := data[offset : offset+listed.Size]
+		if err := listed.Verify(page); err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+		offset += listed.Size
+	}
+	if offset != int64(len(data)) {
+		return nil, errors.New(fmt.Sprintf("The page range is longer than the manifest gives. Expected: %d, Received: %d", offset, len(data)))
+	}
+	return pages, nil
+}
+
+// GetCachePages gets the entity pages from first to last, both included, of the cache the link in the cache index of the endpoint points to. The manifest of the cache comes first, and the pages are asked for in one request. If the remote doesn't serve the range, the pages are asked for one by one; a range that arrives and doesn't match the manifest is not, since the remote is lying about its cache.
+func GetCachePages(host string, subhost string, port uint16, endpoint string, link ResultCache, first int, last int) (Response, error) {
+	var response Response
+	location := fmt.Sprint(endpoint, "/", link.ResponseUrl)
+	if len(link.Manifest) == 0 {
+		return response, errors.New(fmt.Sprintf("Only the caches with a manifest can be read in ranges. Cache: %s", location))
+	}
+	m, err := getManifest(host, subhost, port, location, link.Manifest)
+	if err != nil {
+		return response, err
+	}
+	if first < 0 || last < first || last >= m.EntityPageCount() {
+		return response, errors.New(fmt.Sprintf("The page range is not in the cache. Cache: %s, First: %d, Last: %d, Pages: %d", location, first, last, m.EntityPageCount()))
+	}
+	var size int64
+	for i := first; i <= last; i++ {
+		listed, _ := m.Page(fmt.Sprint(i, ".json"))
+		size += listed.Size
+	}
+	var pages []ApiResponse
+	data, err2 := fetchLimited(host, subhost, port, PageRangeLocation(location, first, last), "GET", []byte{}, size)
+	if err2 == nil {
+		raws, err3 := SplitPageRange(data, m, first, last)
+		if err3 != nil {
+			return response, fetchError(err3, host, subhost, port, location)
+		}
+		for j, _ := range raws {
+			page, err4 := readPage(raws[j], host, subhost, port, fmt.Sprint(location, "/", first+j, ".json"))
+			if err4 != nil {
+				return response, err4
+			}
+			pages = append(pages, page)
+		}
+	} else {
+		if IsLimitError(err2) {
+			return response, err2
+		}
+		logging.Log(2, fmt.Sprintf("The remote did not serve the page range, the pages will be asked for one by one. Cache: %s, Error: %s", location, err2))
+		for i := first; i <= last; i++ {
+			page, err5 := getCachePage(host, subhost, port, location, fmt.Sprint(i, ".json"), m)
+			if err5 != nil {
+				return response, err5
+			}
+			pages = append(pages, page)
+		}
+	}
+	// The pages are checked against each other, and against the manifest, as getCache checks them against the first page.
+	if _, err6 := lastPageOf(pages[0].Pagination, m.EntityPageCount()); err6 != nil {
+		return response, fetchError(err6, host, subhost, port, location)
+	}
+	for j, _ := range pages {
+		if err7 := membership.CheckNetwork(pages[j].NetworkId); err7 != nil {
+			return response, err7
+		}
+		if err8 := CheckPagination(&pages[j], uint64(first+j), pages[0].Pagination); err8 != nil {
+			return response, fetchError(err8, host, subhost, port, location)
+		}
+		var pageResp Response
+		pageResp = InsertApiResponseToResponse(pageResp, pages[j])
+		response = concatResponses(response, pageResp)
+	}
+	peer := syncprogress.PeerKey(host, port)
+	syncprogress.Plan(peer, endpoint, len(pages))
+	syncprogress.PagesDone(peer, endpoint, len(pages))
+	response.AvailableTypes = getResponseTypes(response)
+	return response, nil
+}
@@ -0,0 +1,139 @@
+// API > Policy
+// This file is the validation policy: the most characters the text fields of each entity type can have, and the most filters a request and cache links an index can have. The inbound limits in limits.go are there to stop the remotes that are broken or malicious, and a page over them is rejected whole; the policy is the shape the content of the network has to have, and it holds in both directions. An entity over it is dropped when it arrives, and again before it is served, so that content this node would not take in doesn't go out of it either, even if it was taken in before the policy changed.
+
+package api
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"fmt"
+	"unicode/utf8"
+)
+
+// defaultFieldMaxima are the most characters the text fields can have, by entity type and field, as the protocol gives them. They can be overridden with ValidationFieldMaxima.
+var defaultFieldMaxima = map[string]int{
+	"boards.name":           255,
+	"boards.description":    65535,
+	"threads.name":          255,
+	"threads.body":          65535,
+	"threads.link":          5000,
+	"posts.body":            65535,
+	"keys.name":             64,
+	"keys.info":             1024,
+	"addresses.location":    256,
+	"addresses.sublocation": 256,
+}
+
+// FieldMaximum gives the most characters the field of the entity type can have, such as "posts.body". 0 is no maximum.
+func FieldMaximum(field string) int {
+	if max, ok := globals.ValidationFieldMaxima[field]; ok {
+		return max
+	}
+	return defaultFieldMaxima[field]
+}
+
+func checkPolicyField(field string, value string) error {
+	max := FieldMaximum(field)
+	if max == 0 || len(value) <= max {
+		// A string can't have more characters than bytes, so most of them don't have to be counted.
+		return nil
+	}
+	if count := utf8.RuneCountInString(value); count > max {
+		return limitError(fmt.Sprintf("A field is longer than the validation policy allows. Field: %s, Characters: %d, Maximum: %d", field, count, max))
+	}
+	return nil
+}
+
+// CheckPolicy checks an entity against the validation policy. The entity types that have no text fields always pass.
+func CheckPolicy(entity interface{}) error {
+	var fields map[string]string
+	switch e := entity.(type) {
+	case *Board:
+		fields = map[string]string{"boards.name": e.Name, "boards.description": e.Description}
+	case *Thread:
+		fields = map[string]string{"threads.name": e.Name, "threads.body": e.Body, "threads.link": e.Link}
+	case *Post:
+		fields = map[string]string{"posts.body": e.Body}
+	case *Key:
+		fields = map[string]string{"keys.name": e.Name, "keys.info": e.Info}
+	case *Address:
+		fields = map[string]string{"addresses.location": string(e.Location), "addresses.sublocation": string(e.Sublocation)}
+	}
+	for field, value := range fields {
+		if err := checkPolicyField(field, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func logPolicyDrop(entityType string, fp Fingerprint, err error) {
+	logging.LogSampled("api", "validation-policy", 2, fmt.Sprintf("This entity is over the validation policy of this node, so it is dropped. Entity type: %s, Fingerprint: %s, Error: %s", entityType, fp, err))
+}
+
+// FilterByPolicy removes the entities that are over the validation policy. This is applied both on ingest, and when deciding what to serve to the remotes.
+func FilterByPolicy(resp Response) Response {
+	cleanedResp := resp
+	cleanedResp.Boards = nil
+	cleanedResp.Threads = nil
+	cleanedResp.Posts = nil
+	cleanedResp.Keys = nil
+	cleanedResp.Addresses = nil
+	for i, _ := range resp.Boards {
+		if err := CheckPolicy(&resp.Boards[i]); err != nil {
+			logPolicyDrop("boards", resp.Boards[i].Fingerprint, err)
+			continue
+		}
+		cleanedResp.Boards = append(cleanedResp.Boards, resp.Boards[i])
+	}
+	for i, _ := range resp.Threads {
+		if err := CheckPolicy(&resp.Threads[i]); err != nil {
+			logPolicyDrop("threads", resp.Threads[i].Fingerprint, err)
+			continue
+		}
+		cleanedResp.Threads = append(cleanedResp.Threads, resp.Threads[i])
+	}
+	for i, _ := range resp.Posts {
+		if err := CheckPolicy(&resp.Posts[i]); err != nil {
+			logPolicyDrop("posts", resp.Posts[i].Fingerprint, err)
+			continue
+		}
+		cleanedResp.Posts = append(cleanedResp.Posts, resp.Posts[i])
+	}
+	for i, _ := range resp.Keys {
+		if err := CheckPolicy(&resp.Keys[i]); err != nil {
+			logPolicyDrop("keys", resp.Keys[i].Fingerprint, err)
+			continue
+		}
+		cleanedResp.Keys = append(cleanedResp.Keys, resp.Keys[i])
+	}
+	for i, _ := range resp.Addresses {
+		if err := CheckPolicy(&resp.Addresses[i]); err != nil {
+			logPolicyDrop("addresses", Fingerprint(resp.Addresses[i].Location), err)
+			continue
+		}
+		cleanedResp.Addresses = append(cleanedResp.Addresses, resp.Addresses[i])
+	}
+	return cleanedResp
+}
+
+// CheckRequestPolicy checks the filters of a request from a remote against the validation policy.
+func CheckRequestPolicy(req *ApiResponse) error {
+	if len(req.Filters) > globals.ValidationMaxFilters {
+		return limitError(fmt.Sprintf("The request has more filters than the validation policy allows. Count: %d, Maximum: %d", len(req.Filters), globals.ValidationMaxFilters))
+	}
+	for _, f := range req.Filters {
+		if len(f.Values) > globals.ValidationMaxFilterValues {
+			return limitError(fmt.Sprintf("A filter of the request has more values than the validation policy allows. Filter: %s, Count: %d, Maximum: %d", f.Type, len(f.Values), globals.ValidationMaxFilterValues))
+		}
+	}
+	return nil
+}
+
+// TrimCacheLinks leaves out the oldest of the cache links of an index over the validation policy, so that the index this node serves is one the remotes take.
+func TrimCacheLinks(links []ResultCache) []ResultCache {
+	if len(links) <= globals.ValidationMaxResultCaches {
+		return links
+	}
+	return links[len(links)-globals.ValidationMaxResultCaches:]
+}
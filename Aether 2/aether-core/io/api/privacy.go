@@ -0,0 +1,48 @@
+// API > Privacy
+// This file has the parts of the privacy mode that are on the wire: the extension with which a node asks the remotes not to save its address, and the padding of the responses to a size bucket.
+
+package api
+
+import (
+	"aether-core/services/globals"
+	"bytes"
+)
+
+// UnlistedExtension is the protocol extension of the nodes in privacy mode. A remote that gets a request from a node with it doesn't save the address of the node, so it doesn't pass it on to others.
+const UnlistedExtension = "unlisted"
+
+// The responses are padded to the next power of two up to maxPaddingBucket, and to the next multiple of it above that.
+const (
+	minPaddingBucket = 1024
+	maxPaddingBucket = 1024 * 1024
+)
+
+// IsUnlisted checks whether the address asks not to be saved.
+func IsUnlisted(a Address) bool {
+	for _, ext := range a.Protocol.Extensions {
+		if ext == UnlistedExtension {
+			return true
+		}
+	}
+	return false
+}
+
+// paddedSize gives the size of the bucket the data of the given size is padded to.
+func paddedSize(size int) int {
+	if size > maxPaddingBucket {
+		return (size + maxPaddingBucket - 1) / maxPaddingBucket * maxPaddingBucket
+	}
+	bucket := minPaddingBucket
+	for bucket < size {
+		bucket *= 2
+	}
+	return bucket
+}
+
+// PadResponse pads the JSON of a response with whitespace after its end, up to the size of its bucket, if the node is in privacy mode. Whitespace is not part of the JSON, so the response reads, and its signature checks, the same.
+func PadResponse(data []byte) []byte {
+	if !globals.PrivacyMode || len(data) == 0 {
+		return data
+	}
+	return append(data, bytes.Repeat([]byte(" "), paddedSize(len(data))-len(data))...)
+}
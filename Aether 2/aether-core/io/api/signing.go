@@ -0,0 +1,113 @@
+// API > Signing
+// This file signs the responses of this node with its node key, and checks the signatures on the responses of remotes. The caches, their indexes and the node response are signed for everyone; a POST response is signed for the remotes that send a nonce, since those are the ones that check it (see binding.go).
+
+package api
+
+import (
+	"aether-core/services/canonical"
+	"aether-core/services/globals"
+	"aether-core/services/signaturing"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// SignedResponsesExtension is the protocol extension of the nodes that sign their responses. A remote that announces it and then sends a POST response that is not signed is refused.
+const SignedResponsesExtension = "signed_responses"
+
+// bindingInput is what the response signature is created over: the canonical JSON of the response without the signature itself. It is made from the JSON as it arrived rather than from the parsed response, so that the fields this version doesn't know of are signed too.
+func bindingInput(raw []byte) (string, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(raw, &fields)
+	if err != nil {
+		return "", err
+	}
+	delete(fields, "response_signature")
+	unsigned, err2 := json.Marshal(fields)
+	if err2 != nil {
+		return "", err2
+	}
+	input, err3 := canonical.Canonicalise(unsigned)
+	if err3 != nil {
+		return "", err3
+	}
+	return string(input), nil
+}
+
+// SignResponse signs the response with the node key. It has to be called after everything else in the response is set.
+func SignResponse(resp *ApiResponse) error {
+	resp.NodePublicKey = globals.MarshaledPubKey
+	resp.ResponseSignature = ""
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	input, err2 := bindingInput(raw)
+	if err2 != nil {
+		return err2
+	}
+	sig, err3 := signaturing.Sign(input, globals.KeyPair)
+	if err3 != nil {
+		return err3
+	}
+	resp.ResponseSignature = Signature(sig)
+	return nil
+}
+
+// UnsignResponse removes the signature of a response. This is for the responses that are read back from disk and changed, such as the cache indexes, when signing is turned off.
+func UnsignResponse(resp *ApiResponse) {
+	resp.NodePublicKey = ""
+	resp.ResponseSignature = ""
+}
+
+func verifySignature(raw []byte, resp *ApiResponse) error {
+	input, err := bindingInput(raw)
+	if err != nil {
+		return errors.New(fmt.Sprintf("The signature of the response could not be checked. Node: %s, Error: %s", resp.NodeId, err))
+	}
+	if !signaturing.Verify(input, string(resp.ResponseSignature), resp.NodePublicKey) {
+		return errors.New(fmt.Sprintf("The signature of the response is not valid. Node: %s", resp.NodeId))
+	}
+	return nil
+}
+
+// VerifyResponse checks the signature of a response that arrived from a remote. raw is the JSON of the response as it arrived, and resp is what it was parsed into. If expectedKey is given, the response has to be signed by that key. A response that is not signed is accepted, unless signatures are required, since the remotes that don't sign yet, and the caches they made before they did, are still around.
+func VerifyResponse(raw []byte, resp *ApiResponse, expectedKey string) error {
+	if len(resp.ResponseSignature) == 0 {
+		if globals.RequireResponseSignatures {
+			return errors.New(fmt.Sprintf("The response is not signed, and unsigned responses are not accepted. Node: %s", resp.NodeId))
+		}
+		return nil
+	}
+	if len(expectedKey) > 0 && resp.NodePublicKey != expectedKey {
+		return errors.New(fmt.Sprintf("The response is signed by another key than the one the remote gave at the start of the sync. Node: %s", resp.NodeId))
+	}
+	return verifySignature(raw, resp)
+}
+
+// The node keys of the remotes, as given by their node responses at the start of the sync. Every signed page that comes from the same remote afterwards has to be signed by the same key. The keys are kept by host and port, because the pages of caches do not always say which node they are from.
+var pinsLock sync.Mutex
+var pinnedKeys = make(map[string]string)
+
+func pinKey(host string, port uint16) string {
+	return fmt.Sprint(host, ":", port)
+}
+
+// PinNodeKey sets the key the pages of the remote have to be signed by. An empty key removes the pin. The nodes generate their keys when they start, unless they restore a saved identity, so the pins are set again at the start of every sync rather than kept.
+func PinNodeKey(host string, port uint16, key string) {
+	pinsLock.Lock()
+	defer pinsLock.Unlock()
+	if len(key) == 0 {
+		delete(pinnedKeys, pinKey(host, port))
+		return
+	}
+	pinnedKeys[pinKey(host, port)] = key
+}
+
+// PinnedNodeKey gives the key pinned for the remote, if any.
+func PinnedNodeKey(host string, port uint16) string {
+	pinsLock.Lock()
+	defer pinsLock.Unlock()
+	return pinnedKeys[pinKey(host, port)]
+}
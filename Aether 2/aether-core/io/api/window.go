@@ -0,0 +1,14 @@
+// API > Window
+// This file has the window filter, which asks for a time range by its name instead of its timestamps, such as {"type": "window", "values": ["last24h"]}. The node works the range out with its own clock and its own caches, so the requester doesn't have to get the timestamps right across time zones and clocks that are off. The response gives the range it was worked out to in its starts_from and ends_at.
+// The windows are "today", from the start of the day, in UTC or in the time zone given as the second value, such as "+02:00"; "sincelastcache", from the end of the last cache of the node; and "last" with a number and a unit, m, h or d, such as "last30m" or "last7d". All of them end now.
+
+package api
+
+// WindowFilter is the type of the filter that gives the time range by its name.
+const WindowFilter = "window"
+
+// The windows that are not of the "last" kind.
+const (
+	WindowToday          = "today"
+	WindowSinceLastCache = "sincelastcache"
+)
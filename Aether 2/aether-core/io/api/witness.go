@@ -0,0 +1,105 @@
+// API > Witness
+// This file has the witness signatures of the caches. A node can send the manifest hashes of its caches to the witnesses it is configured with, other nodes that sign what they were given with the time they saw it, and it serves those signatures in its cache index next to the caches. A node that later rewrote a cache could not get a signature for the new manifest from before the rewrite, so the remotes that trust a witness can tell a cache that was there all along from one that was made up afterwards.
+// The statement a witness signs is the node, the entity type, the name, the time range and the manifest hash of the cache, and the time it signed them. It doesn't vouch for what is in the cache, only that the cache was what the hash says at that time.
+
+package api
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"aether-core/services/signaturing"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// WitnessExtension is the protocol extension of the nodes that sign the cache manifests of others.
+const WitnessExtension = "witness"
+
+// WitnessSignature is the signature of a witness over a cache of another node.
+type WitnessSignature struct {
+	Witness   Fingerprint `json:"witness"`    // The node id of the witness.
+	PublicKey string      `json:"public_key"` // The node key of the witness.
+	Timestamp Timestamp   `json:"timestamp"`  // When the witness signed.
+	Signature Signature   `json:"signature"`
+}
+
+// witnessInput is what a witness signs.
+func witnessInput(node Fingerprint, entityType string, c ResultCache, ts Timestamp) string {
+	return fmt.Sprint("witness:", node, ":", entityType, ":", c.ResponseUrl, ":", c.StartsFrom, ":", c.EndsAt, ":", c.Manifest, ":", ts)
+}
+
+// SignWitness signs a cache of another node with the node key of this node, as its witness.
+func SignWitness(node Fingerprint, entityType string, c ResultCache, ts Timestamp) (WitnessSignature, error) {
+	if len(c.Manifest) == 0 {
+		return WitnessSignature{}, errors.New(fmt.Sprintf("A cache without a manifest can't be witnessed. Cache: %s", c.ResponseUrl))
+	}
+	sig, err := signaturing.Sign(witnessInput(node, entityType, c, ts), globals.KeyPair)
+	if err != nil {
+		return WitnessSignature{}, err
+	}
+	return WitnessSignature{Witness: Fingerprint(globals.NodeId), PublicKey: globals.MarshaledPubKey, Timestamp: ts, Signature: Signature(sig)}, nil
+}
+
+// VerifyWitness checks the signature of a witness over a cache of the node.
+func VerifyWitness(node Fingerprint, entityType string, c ResultCache, w WitnessSignature) error {
+	if !signaturing.Verify(witnessInput(node, entityType, c, w.Timestamp), string(w.Signature), w.PublicKey) {
+		return errors.New(fmt.Sprintf("The witness signature of the cache is not valid. Cache: %s/%s, Witness: %s", entityType, c.ResponseUrl, w.Witness))
+	}
+	return nil
+}
+
+// WitnessFilter is the filter of a request that asks a witness to sign a cache of the node.
+func WitnessFilter(node Fingerprint, entityType string, c ResultCache) Filter {
+	return Filter{Type: "witness", Values: []string{string(node), entityType, c.ResponseUrl, strconv.FormatInt(int64(c.StartsFrom), 10), strconv.FormatInt(int64(c.EndsAt), 10), c.Manifest}}
+}
+
+// ParseWitnessFilter reads the values of a witness filter.
+func ParseWitnessFilter(values []string) (Fingerprint, string, ResultCache, error) {
+	var c ResultCache
+	if len(values) != 6 {
+		return "", "", c, errors.New(fmt.Sprintf("A witness request has to give the node, the entity type, the cache, its time range and its manifest. Values: %d", len(values)))
+	}
+	start, err := strconv.ParseInt(values[3], 10, 64)
+	end, err2 := strconv.ParseInt(values[4], 10, 64)
+	if err != nil || err2 != nil || start > end {
+		return "", "", c, errors.New(fmt.Sprintf("The time range of the cache to witness is not valid. Start: %s, End: %s", values[3], values[4]))
+	}
+	if len(values[0]) == 0 || !validCacheName(values[2]) || len(values[5]) != 64 {
+		return "", "", c, errors.New(fmt.Sprintf("The cache to witness is not valid. Node: %s, Cache: %s, Manifest: %s", values[0], values[2], values[5]))
+	}
+	c.ResponseUrl = values[2]
+	c.StartsFrom = Timestamp(start)
+	c.EndsAt = Timestamp(end)
+	c.Manifest = values[5]
+	return Fingerprint(values[0]), values[1], c, nil
+}
+
+// CheckWitnesses checks the signatures of the trusted witnesses on the caches in the index of an endpoint of the node. A signature of a trusted witness that is not valid, or that is not made with its key, means the cache is not the one the witness saw, and the index is refused. A cache whose trusted witnesses all signed it long after it ended may have been rewritten since; it is taken, but the remote is logged. The signatures of the other witnesses can't be trusted, and are ignored.
+func CheckWitnesses(node Fingerprint, entityType string, links []ResultCache) error {
+	if len(globals.TrustedWitnesses) == 0 {
+		return nil
+	}
+	for i, _ := range links {
+		earliest := Timestamp(0)
+		for _, w := range links[i].Witnesses {
+			key, trusted := globals.TrustedWitnesses[string(w.Witness)]
+			if !trusted {
+				continue
+			}
+			if w.PublicKey != key {
+				return limitError(fmt.Sprintf("A witness signature of the cache is not made with the key of the trusted witness. Cache: %s/%s, Witness: %s", entityType, links[i].ResponseUrl, w.Witness))
+			}
+			if err := VerifyWitness(node, entityType, links[i], w); err != nil {
+				return limitError(err.Error())
+			}
+			if earliest == 0 || w.Timestamp < earliest {
+				earliest = w.Timestamp
+			}
+		}
+		if earliest > 0 && int64(earliest-links[i].EndsAt) > int64(globals.CacheWitnessMaxDelay.Seconds()) {
+			logging.LogSampled("api", "witness-late", 1, fmt.Sprintf("The cache of the remote was witnessed long after it ended. It may have been rewritten. Node: %s, Cache: %s/%s, Ended: %d, Witnessed: %d", node, entityType, links[i].ResponseUrl, links[i].EndsAt, earliest))
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package persistence_test
 import (
 	"aether-core/io/api"
 	"aether-core/io/persistence"
+	"aether-core/services/globals"
 	"fmt"
 	"log"
 	"os"
@@ -1433,3 +1434,425 @@ func TestInsert_DuplicateCurrencyAddress(t *testing.T) {
 		t.Errorf("This should have returned 3 currency addresses. Error: '%#v\n' Current Key: '%#v\n', Currency addresses: '%#v\n'", err3, resp[0], resp[0].CurrencyAddresses)
 	}
 }
+
+func TestQueryTimings_Success(t *testing.T) {
+	persistence.ResetQueryTimings()
+	fps := []api.Fingerprint{"aa", "bb", "cc"}
+	_, err := persistence.ReadBoards(fps, 0, 0)
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	_, err2 := persistence.ReadBoards(fps[:2], 0, 0)
+	if err2 != nil {
+		t.Fatalf("Test failed, err: '%s'", err2)
+	}
+	for _, qt := range persistence.QueryTimings() {
+		if strings.Contains(qt.Query, "FROM Boards WHERE Fingerprint IN (?...)") {
+			if qt.Count != 2 {
+				t.Errorf("The two reads should have been timed as the same query. Timing: %#v", qt)
+			}
+			return
+		}
+	}
+	t.Errorf("The read of the boards was not timed. Timings: %#v", persistence.QueryTimings())
+}
+
+func TestCheckSchema_Success(t *testing.T) {
+	problems, err := persistence.CheckSchema()
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	if len(problems) > 0 {
+		t.Errorf("A database created by this version should have no schema problems. Problems: %v", problems)
+	}
+}
+
+func TestRepairSchema_Success_MissingColumn(t *testing.T) {
+	_, err := persistence.DbInstance.Exec("ALTER TABLE Posts DROP COLUMN Meta")
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	problems, err2 := persistence.CheckSchema()
+	if err2 != nil {
+		t.Fatalf("Test failed, err: '%s'", err2)
+	}
+	if len(problems) != 1 || problems[0] != (persistence.SchemaProblem{Table: "Posts", Column: "Meta"}) {
+		t.Fatalf("The dropped column should have been found missing. Problems: %v", problems)
+	}
+	err3 := persistence.RepairSchema(problems)
+	if err3 != nil {
+		t.Fatalf("Test failed, err: '%s'", err3)
+	}
+	problems2, err4 := persistence.CheckSchema()
+	if err4 != nil {
+		t.Fatalf("Test failed, err: '%s'", err4)
+	}
+	if len(problems2) > 0 {
+		t.Errorf("The repair should have added the column back. Problems: %v", problems2)
+	}
+}
+
+func TestRepairSchema_Fail_UnknownColumn(t *testing.T) {
+	err := persistence.RepairSchema([]persistence.SchemaProblem{{Table: "Posts", Column: "NoSuchColumn"}})
+	if err == nil {
+		t.Errorf("A column that is not in the creation schema should not be added.")
+	}
+}
+
+func TestEnsureIndexes_Success(t *testing.T) {
+	persistence.EnsureIndexes()
+	missing, err := persistence.MissingIndexes()
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	if len(missing) > 0 {
+		t.Errorf("The required indexes should have been created. Missing: %#v", missing)
+	}
+	for _, s := range persistence.IndexStates() {
+		if s.State != "present" {
+			t.Errorf("Every required index should be present after the check. Index: %#v", s)
+		}
+	}
+}
+
+func TestInsert_PostMeta_Success(t *testing.T) {
+	fp := api.Fingerprint("my post with meta fingerprint")
+	var post api.Post
+	post.Fingerprint = fp
+	post.Board = "board fingerprint"
+	post.Thread = "thread fingerprint"
+	post.Parent = "thread fingerprint"
+	post.Body = "body"
+	post.Owner = "owner fingerprint"
+	post.Creation = 1
+	post.Signature = "sig"
+	post.ProofOfWork = "pow"
+	post.Meta = api.Meta{"flair": "question", "client.version": "2.0"}
+	err := persistence.BatchInsert([]interface{}{post})
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	resp, err2 := persistence.ReadPosts([]api.Fingerprint{fp}, 0, 0)
+	if err2 != nil {
+		t.Fatalf("Test failed, err: '%s'", err2)
+	} else if len(resp) == 0 {
+		t.Fatalf("Test failed, the response is empty.")
+	}
+	if len(resp[0].Meta) != 2 || resp[0].Meta["flair"] != "question" || resp[0].Meta["client.version"] != "2.0" {
+		t.Errorf("The metadata did not survive the database. Meta: %#v", resp[0].Meta)
+	}
+}
+
+func TestInsert_PostWithoutMeta_Success(t *testing.T) {
+	resp, err := persistence.ReadPosts([]api.Fingerprint{"my post fingerprint"}, 0, 0)
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	} else if len(resp) == 0 {
+		t.Fatalf("Test failed, the response is empty.")
+	}
+	if resp[0].Meta != nil {
+		t.Errorf("A post without metadata should be read without it. Meta: %#v", resp[0].Meta)
+	}
+}
+
+func TestReplica_Fail_Unreachable(t *testing.T) {
+	os.Setenv(globals.DatabaseReplicaEnv, "nobody:@tcp(127.0.0.1:1)/none")
+	defer os.Unsetenv(globals.DatabaseReplicaEnv)
+	persistence.CheckReplica()
+	st := persistence.GetReplicaStatus()
+	if !st.Configured || st.InUse || len(st.Reason) == 0 {
+		t.Errorf("A replica that can't be reached should not be used. Status: %#v", st)
+	}
+	// The reads of the ranges fall back to the primary.
+	_, err := persistence.ReadInRange("posts", 0, 0)
+	if err != nil {
+		t.Errorf("The read should have gone to the primary. Error: %s", err)
+	}
+}
+
+func TestReadPageAfterCursor_Success_ShortPageIsLast(t *testing.T) {
+	time.Sleep(1000 * time.Millisecond) // So that the boards of the setup are inside the range.
+	resp, _, fp, err := persistence.ReadPageAfterCursor("boards", 0, 0, 0, "", 1)
+	if err != nil || len(resp.Boards) != 1 || len(fp) == 0 {
+		t.Errorf("A full page should give the cursor of its last entity. Boards: %d, Fingerprint: %s, Error: %v", len(resp.Boards), fp, err)
+	}
+	resp2, _, fp2, err2 := persistence.ReadPageAfterCursor("boards", 0, 0, 0, "", 100000)
+	if err2 != nil || len(resp2.Boards) == 0 || len(fp2) != 0 {
+		t.Errorf("A page that is not full should be the last one, with no cursor. Boards: %d, Fingerprint: %s, Error: %v", len(resp2.Boards), fp2, err2)
+	}
+}
+
+func TestReadPage_Success_StableBoundaries(t *testing.T) {
+	var batch []interface{}
+	// Inserted together, so that most of them share their arrival, and only the fingerprint orders them.
+	for _, fp := range []string{"paged board e", "paged board a", "paged board d", "paged board b", "paged board c"} {
+		var b api.Board
+		b.Fingerprint = api.Fingerprint(fp)
+		b.Name = "alice"
+		b.Creation = 1
+		b.ProofOfWork = "pow"
+		b.Owner = "board owner"
+		batch = append(batch, b)
+	}
+	err := persistence.BatchInsert(batch)
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	time.Sleep(1000 * time.Millisecond) // So that the boards are inside the range.
+	plan, err2 := persistence.PlanPages("boards", 0, 0, 2)
+	if err2 != nil {
+		t.Fatalf("Test failed, err: '%s'", err2)
+	}
+	readAll := func() []api.Fingerprint {
+		var fps []api.Fingerprint
+		for i := 0; i < plan.Pages; i++ {
+			page, err3 := persistence.ReadPage(plan, i)
+			if err3 != nil {
+				t.Fatalf("Test failed, err: '%s'", err3)
+			}
+			if len(page.Boards) > plan.PageSize {
+				t.Errorf("A page is larger than the page size. Page: %d, Boards: %d", i, len(page.Boards))
+			}
+			for _, b := range page.Boards {
+				fps = append(fps, b.Fingerprint)
+			}
+		}
+		return fps
+	}
+	first := readAll()
+	second := readAll()
+	if len(first) != plan.Count || len(second) != plan.Count {
+		t.Fatalf("The pages don't hold every entity of the plan. Count: %d, First: %d, Second: %d", plan.Count, len(first), len(second))
+	}
+	seen := make(map[api.Fingerprint]bool)
+	for i, _ := range first {
+		if seen[first[i]] {
+			t.Errorf("An entity is on more than one page. Fingerprint: %s", first[i])
+		}
+		seen[first[i]] = true
+		if first[i] != second[i] {
+			t.Errorf("The page boundaries moved between two reads. Position: %d, First: %s, Second: %s", i, first[i], second[i])
+		}
+	}
+	// The cursor pages are cut in the same order.
+	var cursorFps []api.Fingerprint
+	var afterArrival api.Timestamp
+	var afterFp api.Fingerprint
+	for {
+		page, lastArrival, lastFp, err4 := persistence.ReadPageAfterCursor("boards", 0, plan.End, afterArrival, afterFp, 2)
+		if err4 != nil {
+			t.Fatalf("Test failed, err: '%s'", err4)
+		}
+		for _, b := range page.Boards {
+			cursorFps = append(cursorFps, b.Fingerprint)
+		}
+		if len(lastFp) == 0 {
+			break
+		}
+		afterArrival, afterFp = lastArrival, lastFp
+	}
+	if strings.Join(fingerprintStrings(cursorFps), ",") != strings.Join(fingerprintStrings(first), ",") {
+		t.Errorf("The numbered pages and the cursor pages are not in the same order. Numbered: %v, Cursor: %v", first, cursorFps)
+	}
+}
+
+func fingerprintStrings(fps []api.Fingerprint) []string {
+	var s []string
+	for _, fp := range fps {
+		s = append(s, string(fp))
+	}
+	return s
+}
+
+func TestResponsePages_Success_Expiry(t *testing.T) {
+	now := time.Now().Unix()
+	persistence.InsertResponsePage("response expired", 0, []byte("abc"), now-10)
+	persistence.InsertResponsePage("response live", 0, []byte("abcd"), now+600)
+	persistence.InsertResponsePage("response live", 1, []byte("ef"), now+600)
+	if _, found, _ := persistence.ReadResponsePage("response live", 0, now); found {
+		t.Errorf("A page should not be served before its response is published.")
+	}
+	persistence.PublishResponsePages("response expired")
+	persistence.PublishResponsePages("response live")
+	if data, found, err := persistence.ReadResponsePage("response live", 1, now); !found || err != nil || string(data) != "ef" {
+		t.Errorf("A published page should be served. Found: %t, Data: %s, Error: %v", found, data, err)
+	}
+	if _, found, _ := persistence.ReadResponsePage("response expired", 0, now); found {
+		t.Errorf("A page of an expired response should not be served.")
+	}
+	usage, err := persistence.ReadResponsePagesUsage()
+	if err != nil || usage.Bytes < 9 || usage.Pages < 3 {
+		t.Errorf("The usage should count every page, the expired ones included. Usage: %#v, Error: %v", usage, err)
+	}
+	deleted, err2 := persistence.DeleteExpiredResponsePages(now)
+	if err2 != nil || deleted != 1 {
+		t.Errorf("Only the page of the expired response should have been deleted. Deleted: %d, Error: %v", deleted, err2)
+	}
+	usage2, _ := persistence.ReadResponsePagesUsage()
+	if usage.Bytes-usage2.Bytes != 3 {
+		t.Errorf("The usage should go down by the bytes of the deleted pages, for the quota. Before: %#v, After: %#v", usage, usage2)
+	}
+	persistence.DeleteResponsePages("response live")
+}
+
+func TestReadPageRange_Success_MatchesIndexes(t *testing.T) {
+	var batch []interface{}
+	for _, fp := range []string{"ranged board c", "ranged board a", "ranged board e", "ranged board b", "ranged board d"} {
+		var b api.Board
+		b.Fingerprint = api.Fingerprint(fp)
+		b.Name = "alice"
+		b.Creation = 1
+		b.ProofOfWork = "pow"
+		b.Owner = "board owner"
+		batch = append(batch, b)
+	}
+	err := persistence.BatchInsert(batch)
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	time.Sleep(1000 * time.Millisecond) // So that the boards are inside the range.
+	end := api.Timestamp(time.Now().Unix())
+	indexes, err2 := persistence.ReadIndexes("boards", 0, end)
+	if err2 != nil {
+		t.Fatalf("Test failed, err: '%s'", err2)
+	}
+	plan, err3 := persistence.PlanRange("boards", 0, end, 100)
+	if err3 != nil {
+		t.Fatalf("Test failed, err: '%s'", err3)
+	}
+	if plan.Count != len(indexes.BoardIndexes) {
+		t.Fatalf("The plan and the indexes count different entities. Plan: %d, Indexes: %d", plan.Count, len(indexes.BoardIndexes))
+	}
+	// Pages cut short of the page size, as a page byte budget cuts them, still hold the entities at the positions of their indexes.
+	for beg := 0; beg < plan.Count; beg += 3 {
+		page, err4 := persistence.ReadPageRange(plan, beg, 3)
+		if err4 != nil {
+			t.Fatalf("Test failed, err: '%s'", err4)
+		}
+		for i, _ := range page.Boards {
+			if page.Boards[i].Fingerprint != indexes.BoardIndexes[beg+i].Fingerprint {
+				t.Errorf("The entity is not at the position of its index. Position: %d, Entity: %s, Index: %s", beg+i, page.Boards[i].Fingerprint, indexes.BoardIndexes[beg+i].Fingerprint)
+			}
+		}
+	}
+}
+
+// coalescedTruststate is a truststate of the owner on the target, which only differs from the other ones of the pair by its fingerprint and its type.
+func coalescedTruststate(fp api.Fingerprint, trustType uint8) api.Truststate {
+	var ts api.Truststate
+	ts.Fingerprint = fp
+	ts.Owner = "coalesced truststate owner"
+	ts.Target = "coalesced truststate target"
+	ts.Type = trustType
+	ts.Creation = 1000
+	ts.ProofOfWork = "pow"
+	return ts
+}
+
+func TestBatchInsert_Coalesce_Success_PairInOneBatch(t *testing.T) {
+	globals.IngestCoalescingEnabled = true
+	a := coalescedTruststate("coalesced truststate one batch a", 1)
+	b := coalescedTruststate("coalesced truststate one batch b", 2)
+	err := persistence.BatchInsert([]interface{}{a, b})
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	resp, err2 := persistence.ReadTruststates([]api.Fingerprint{a.Fingerprint, b.Fingerprint}, 0, 0)
+	if err2 != nil {
+		t.Fatalf("Test failed, err: '%s'", err2)
+	}
+	if len(resp) != 2 {
+		t.Errorf("Both truststates of the same owner and target should have been stored. Stored: %d", len(resp))
+	}
+}
+
+func TestBatchInsert_Coalesce_Success_PairInTwoBatches(t *testing.T) {
+	globals.IngestCoalescingEnabled = true
+	a := coalescedTruststate("coalesced truststate two batches a", 1)
+	b := coalescedTruststate("coalesced truststate two batches b", 2)
+	for _, ts := range []api.Truststate{a, b} {
+		err := persistence.BatchInsert([]interface{}{ts})
+		if err != nil {
+			t.Fatalf("Test failed, err: '%s'", err)
+		}
+	}
+	resp, err2 := persistence.ReadTruststates([]api.Fingerprint{a.Fingerprint, b.Fingerprint}, 0, 0)
+	if err2 != nil {
+		t.Fatalf("Test failed, err: '%s'", err2)
+	}
+	if len(resp) != 2 {
+		t.Errorf("Both truststates of the same owner and target should have been stored, as in one batch. Stored: %d", len(resp))
+	}
+}
+
+// compactionVote is a vote on the compaction target, created at the given time.
+func compactionVote(fp api.Fingerprint, creation api.Timestamp) api.Vote {
+	var v api.Vote
+	v.Fingerprint = fp
+	v.Board = "compaction board"
+	v.Thread = "compaction thread"
+	v.Target = "compaction target"
+	v.Owner = "compaction owner"
+	v.Type = 1
+	v.Creation = creation
+	v.Signature = "sig"
+	v.ProofOfWork = "pow"
+	return v
+}
+
+func TestCompactVotes_Success(t *testing.T) {
+	err := persistence.BatchInsert([]interface{}{compactionVote("compaction vote 1", 1000), compactionVote("compaction vote 2", 1100)})
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	signed := 0
+	compacted, err2 := persistence.CompactVotes(2000, func(s *persistence.DbVoteSummary) error {
+		signed++
+		s.Signer = "signer"
+		s.Signature = "summary sig"
+		return nil
+	})
+	if err2 != nil {
+		t.Fatalf("Test failed, err: '%s'", err2)
+	}
+	if compacted < 2 || signed == 0 {
+		t.Errorf("The votes before the cutoff should have been compacted, and their summaries signed. Compacted: %d, Signed: %d", compacted, signed)
+	}
+	summaries, err3 := persistence.ReadVoteSummaries([]api.Fingerprint{"compaction target"})
+	if err3 != nil {
+		t.Fatalf("Test failed, err: '%s'", err3)
+	}
+	if len(summaries) != 1 || summaries[0].Count != 2 || summaries[0].Until != 2000 || summaries[0].Signature != "summary sig" {
+		t.Fatalf("The summary should count the compacted votes until the cutoff. Summaries: %#v", summaries)
+	}
+	votes, _ := persistence.ReadVotes([]api.Fingerprint{"compaction vote 1", "compaction vote 2"}, 0, 0)
+	if len(votes) != 0 {
+		t.Errorf("The compacted votes should have been removed. Votes: %d", len(votes))
+	}
+}
+
+func TestBatchInsert_Success_LateVoteAfterCompaction(t *testing.T) {
+	// The summary of the target from TestCompactVotes_Success counts the votes created before 2000.
+	late := compactionVote("compaction vote late", 2500)
+	counted := compactionVote("compaction vote counted", 1500)
+	err := persistence.BatchInsert([]interface{}{late, counted})
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	votes, err2 := persistence.ReadVotes([]api.Fingerprint{late.Fingerprint, counted.Fingerprint}, 0, 0)
+	if err2 != nil {
+		t.Fatalf("Test failed, err: '%s'", err2)
+	}
+	if len(votes) != 1 || votes[0].Fingerprint != late.Fingerprint {
+		t.Errorf("Only the vote created after the Until of the summary should have been kept, however old it is. Votes: %#v", votes)
+	}
+	// The next compaction counts the late vote into the same summary.
+	_, err3 := persistence.CompactVotes(3000, func(s *persistence.DbVoteSummary) error { return nil })
+	if err3 != nil {
+		t.Fatalf("Test failed, err: '%s'", err3)
+	}
+	summaries, _ := persistence.ReadVoteSummaries([]api.Fingerprint{"compaction target"})
+	if len(summaries) != 1 || summaries[0].Count != 3 || summaries[0].Until != 3000 {
+		t.Errorf("The late vote should have been counted by the next compaction. Summaries: %#v", summaries)
+	}
+}
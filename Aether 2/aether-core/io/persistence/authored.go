@@ -0,0 +1,85 @@
+// Persistence > Authored
+// This file reads what a key authored, and the posts that answer it, so that a device the user pairs with can be given the history of the user, and can find the replies and the mentions the user would have been notified of.
+
+package persistence
+
+import (
+	"aether-core/io/api"
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// authorColumns are the columns that hold the author of the entities of each type. A key is its own author.
+var authorColumns = map[string]string{
+	"boards":      "Owner",
+	"threads":     "Owner",
+	"posts":       "Owner",
+	"votes":       "Owner",
+	"keys":        "Fingerprint",
+	"truststates": "Owner",
+	"tombstones":  "Owner",
+}
+
+// ReadAuthored reads all the entities the key authored, of every type, including the key itself. The tombstoned ones are read too, with their tombstones, so that they stay deleted.
+func ReadAuthored(owner api.Fingerprint) (api.Response, error) {
+	var result api.Response
+	if len(owner) == 0 {
+		return result, nil
+	}
+	for _, entityType := range []string{"keys", "boards", "threads", "posts", "votes", "truststates", "tombstones"} {
+		rows, err := DbInstance.Queryx(fmt.Sprintf("SELECT * FROM %s WHERE %s = ? ORDER BY LocalArrival ASC, Fingerprint ASC;", entityTables[entityType], authorColumns[entityType]), owner)
+		if err != nil {
+			return result, err
+		}
+		resp, _, _, _, err2 := scanPagedRows(context.Background(), rows, entityType)
+		rows.Close()
+		if err2 != nil {
+			return result, err2
+		}
+		result.Keys = append(result.Keys, resp.Keys...)
+		result.Boards = append(result.Boards, resp.Boards...)
+		result.Threads = append(result.Threads, resp.Threads...)
+		result.Posts = append(result.Posts, resp.Posts...)
+		result.Votes = append(result.Votes, resp.Votes...)
+		result.Truststates = append(result.Truststates, resp.Truststates...)
+		result.Tombstones = append(result.Tombstones, resp.Tombstones...)
+	}
+	return result, nil
+}
+
+// ReadReplies reads the posts whose parents are the given threads or posts.
+func ReadReplies(parents []api.Fingerprint) ([]api.Post, error) {
+	var arr []api.Post
+	if len(parents) == 0 {
+		return arr, nil
+	}
+	query, args, err := sqlx.In("SELECT * FROM Posts WHERE Parent IN (?);", parents)
+	if err != nil {
+		return arr, err
+	}
+	rows, err2 := DbInstance.Queryx(query, args...)
+	if err2 != nil {
+		return arr, err2
+	}
+	defer rows.Close()
+	resp, _, _, _, err3 := scanPagedRows(context.Background(), rows, "posts")
+	return resp.Posts, err3
+}
+
+// ReadMentions reads the posts that mention the key, in the form of @fingerprint.
+func ReadMentions(key api.Fingerprint) ([]api.Post, error) {
+	var arr []api.Post
+	if len(key) == 0 {
+		return arr, nil
+	}
+	// The fingerprints are made of hex digits only, so they need no escaping in the LIKE.
+	rows, err := DbInstance.Queryx("SELECT * FROM Posts WHERE Body LIKE ?;", fmt.Sprint("%@", key, "%"))
+	if err != nil {
+		return arr, err
+	}
+	defer rows.Close()
+	resp, _, _, _, err2 := scanPagedRows(context.Background(), rows, "posts")
+	return resp.Posts, err2
+}
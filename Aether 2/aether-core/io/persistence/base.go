@@ -4,19 +4,30 @@
 package persistence
 
 import (
-	"github.com/jmoiron/sqlx"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
 	// _ "github.com/mattn/go-sqlite3"
 	_ "github.com/go-sql-driver/mysql"
-	// "fmt"
 	// _ "github.com/lib/pq"
-	// "os"
 )
 
 // Global Objects
 
-// Creates the database connection to be used from this point on.
+// databaseSource gives the data source name of the database. It is read before anything else runs, so it can't be in the config file; see globals.DatabaseEnv.
+func databaseSource() string {
+	if dsn := os.Getenv(globals.DatabaseEnv); len(dsn) > 0 {
+		return dsn
+	}
+	return "root:@/aether_test"
+}
+
+// Creates the database connection to be used from this point on. The queries going through it are timed, see instrumentation.go.
 // var DbInstance = sqlx.MustConnect("sqlite3", "./test.db")
-var DbInstance = sqlx.MustConnect("mysql", "root:@/aether_test")
+var DbInstance = mustConnectTimed("mysql", databaseSource())
 
 // var DbInstance = sqlx.MustConnect("postgres", "user=burak password=12345 dbname=aether_test sslmode=disable")
 
@@ -27,11 +38,11 @@ var DbInstance = sqlx.MustConnect("mysql", "root:@/aether_test")
 // DeleteDatabase removes the existing database in the default location.
 func DeleteDatabase() {
 	// os.Remove("./test.db")
-	DbInstance.MustExec("DROP TABLE `aether_test`.`Addresses`, `aether_test`.`BoardOwners`, `aether_test`.`Boards`, `aether_test`.`CurrencyAddresses`, `aether_test`.`Posts`, `aether_test`.`PublicKeys`, `aether_test`.`Threads`, `aether_test`.`Truststates`, `aether_test`.`Votes`;")
+	DbInstance.MustExec("DROP TABLE `aether_test`.`Addresses`, `aether_test`.`BoardOwners`, `aether_test`.`Boards`, `aether_test`.`CurrencyAddresses`, `aether_test`.`Posts`, `aether_test`.`PublicKeys`, `aether_test`.`Threads`, `aether_test`.`Truststates`, `aether_test`.`Votes`, `aether_test`.`Tombstones`, `aether_test`.`Notifications`, `aether_test`.`ImportedItems`, `aether_test`.`VoteSummaries`, `aether_test`.`ThreadScores`, `aether_test`.`ContentFilters`, `aether_test`.`ReplicaHeartbeat`, `aether_test`.`ReplyPaths`, `aether_test`.`SyncBookmarks`, `aether_test`.`ResponsePages`, `aether_test`.`Provenance`, `aether_test`.`Drafts`, `aether_test`.`ReadMarkers`, `aether_test`.`Watches`;")
 }
 
-// CreateDatabase creates a new database in the default location and places into it the database schema.
-func CreateDatabase() {
+// creationSchemas are the CREATE TABLE statements of the database schema, in the order they have to be run.
+func creationSchemas() []string {
 	schema1 := `
     CREATE TABLE IF NOT EXISTS BoardOwners (
       BoardFingerprint VARCHAR(64) NOT NULL,
@@ -61,7 +72,11 @@ func CreateDatabase() {
       LastUpdate BIGINT NOT NULL,
       UpdateProofOfWork VARCHAR(1024) NOT NULL,
       UpdateSignature VARCHAR(512) NOT NULL,
-      LocalArrival BIGINT NOT NULL
+      LocalArrival BIGINT NOT NULL,
+      Language VARCHAR(16) NOT NULL DEFAULT '', -- Normalised, declared or detected. This is what the language filter matches.
+      DeclaredLanguage VARCHAR(16) NOT NULL DEFAULT '', -- As the author declared it. This is what is given to remotes.
+      Meta TEXT NOT NULL, -- The metadata of the entity as JSON, or empty if it has none.
+      INDEX (Language)
     );`
 	schema4 := `
     CREATE TABLE IF NOT EXISTS Threads (
@@ -75,7 +90,11 @@ func CreateDatabase() {
       ProofOfWork VARCHAR(1024) NOT NULL,
       Signature VARCHAR(512) NOT NULL,
       LocalArrival BIGINT NOT NULL,
-      INDEX (Board)
+      Language VARCHAR(16) NOT NULL DEFAULT '', -- Normalised, declared or detected. This is what the language filter matches.
+      DeclaredLanguage VARCHAR(16) NOT NULL DEFAULT '', -- As the author declared it. This is what is given to remotes.
+      Meta TEXT NOT NULL, -- The metadata of the entity as JSON, or empty if it has none.
+      INDEX (Board),
+      INDEX (Language)
     );`
 	schema5 := `
     CREATE TABLE IF NOT EXISTS Posts (
@@ -89,6 +108,7 @@ func CreateDatabase() {
       ProofOfWork VARCHAR(1024) NOT NULL,
       Signature VARCHAR(512) NOT NULL,
       LocalArrival BIGINT NOT NULL,
+      Meta TEXT NOT NULL, -- The metadata of the entity as JSON, or empty if it has none.
       INDEX (Thread)
     );`
 	schema6 := `
@@ -123,6 +143,8 @@ func CreateDatabase() {
       ClientVersionMinor INTEGER NOT NULL,
       ClientVersionPatch INTEGER NOT NULL,
       ClientName VARCHAR(255) NOT NULL,
+      Endpoints VARCHAR(5000) NOT NULL,
+      Local BOOLEAN NOT NULL,
       LocalArrival BIGINT NOT NULL,
       PRIMARY KEY(Location, Sublocation, Port)
     );`
@@ -168,9 +190,173 @@ func CreateDatabase() {
         VotesLastCheckin BIGINT NOT NULL,
         AddressesLastCheckin BIGINT NOT NULL,
         KeysLastCheckin BIGINT NOT NULL,
-        TruststatesLastCheckin BIGINT NOT NULL
+        TruststatesLastCheckin BIGINT NOT NULL,
+        TombstonesLastCheckin BIGINT NOT NULL
       );
     `
+	schema11 := `
+    CREATE TABLE IF NOT EXISTS Tombstones (
+      Fingerprint VARCHAR(64) PRIMARY KEY NOT NULL,
+      Target VARCHAR(64) NOT NULL,
+      TargetType VARCHAR(64) NOT NULL,
+      Owner VARCHAR(64) NOT NULL,
+      Creation BIGINT NOT NULL,
+      ProofOfWork VARCHAR(1024) NOT NULL,
+      Signature VARCHAR(512) NOT NULL,
+      LocalArrival BIGINT NOT NULL,
+      INDEX (Target)
+    );`
+	schema12 := `
+    CREATE TABLE IF NOT EXISTS Notifications (
+      Post VARCHAR(64) NOT NULL,
+      Type VARCHAR(16) NOT NULL,
+      Target VARCHAR(64) NOT NULL,
+      Thread VARCHAR(64) NOT NULL,
+      Owner VARCHAR(64) NOT NULL,
+      Creation BIGINT NOT NULL,
+      Seen BOOLEAN NOT NULL,
+      LocalArrival BIGINT NOT NULL,
+      PRIMARY KEY(Post, Type),
+      INDEX (Seen)
+    );`
+	schema13 := `
+    CREATE TABLE IF NOT EXISTS ImportedItems (
+      ItemKey VARCHAR(64) PRIMARY KEY NOT NULL,
+      FeedUrl VARCHAR(2048) NOT NULL,
+      Thread VARCHAR(64) NOT NULL,
+      LocalArrival BIGINT NOT NULL
+    );`
+	schema14 := `
+    CREATE TABLE IF NOT EXISTS VoteSummaries (
+      Target VARCHAR(64) NOT NULL,
+      Type SMALLINT NOT NULL,
+      Board VARCHAR(64) NOT NULL,
+      Thread VARCHAR(64) NOT NULL,
+      Count BIGINT NOT NULL,
+      Until BIGINT NOT NULL,
+      Signer VARCHAR(512) NOT NULL,
+      Signature VARCHAR(512) NOT NULL,
+      LocalArrival BIGINT NOT NULL,
+      PRIMARY KEY(Target, Type)
+    );`
+	schema15 := `
+    CREATE TABLE IF NOT EXISTS ThreadScores (
+      Thread VARCHAR(64) PRIMARY KEY NOT NULL,
+      Board VARCHAR(64) NOT NULL,
+      Creation BIGINT NOT NULL,
+      Upvotes BIGINT NOT NULL,
+      Downvotes BIGINT NOT NULL,
+      Replies BIGINT NOT NULL,
+      LastReply BIGINT NOT NULL,
+      Hot DOUBLE NOT NULL,
+      Top BIGINT NOT NULL,
+      INDEX (Board, Hot),
+      INDEX (Board, Top),
+      INDEX (Board, Creation)
+    );`
+	schema16 := `
+    CREATE TABLE IF NOT EXISTS ContentFilters (
+      Profile VARCHAR(64) NOT NULL,
+      Type VARCHAR(16) NOT NULL,
+      Value VARCHAR(512) NOT NULL,
+      Action VARCHAR(16) NOT NULL,
+      Creation BIGINT NOT NULL,
+      PRIMARY KEY(Profile, Type, Value)
+    );`
+	// The heartbeat the lag of the read replica is measured with, see replica.go. It has a single row.
+	schema17 := `
+    CREATE TABLE IF NOT EXISTS ReplicaHeartbeat (
+      Id TINYINT PRIMARY KEY NOT NULL,
+      Beat BIGINT NOT NULL
+    );`
+	// The reply trees of the threads, materialized as paths, see backend/replytree. The path of a post is the sort keys of its ancestors and of itself, so that the rows of a subtree are the ones whose paths start with the path of its root, and ordering them by the path gives them depth first.
+	schema18 := `
+    CREATE TABLE IF NOT EXISTS ReplyPaths (
+      Post VARCHAR(64) PRIMARY KEY NOT NULL,
+      Thread VARCHAR(64) NOT NULL,
+      Parent VARCHAR(64) NOT NULL,
+      Path VARBINARY(2048) NOT NULL,
+      Depth INT NOT NULL, -- 1 for the replies to the thread.
+      Detached BOOLEAN NOT NULL, -- An ancestor of the post hasn't arrived yet.
+      INDEX (Thread, Path(700)),
+      INDEX (Parent)
+    );`
+	// Where the last sync with each remote ended, per entity type, see backend/dispatch. It is written as each entity type is done, so that an interrupted sync doesn't start over.
+	schema19 := `
+    CREATE TABLE IF NOT EXISTS SyncBookmarks (
+      Node VARCHAR(64) NOT NULL,
+      EntityType VARCHAR(16) NOT NULL,
+      LastCache VARCHAR(256) NOT NULL,
+      LastCacheEnd BIGINT NOT NULL,
+      IndexETag VARCHAR(128) NOT NULL,
+      Covered BIGINT NOT NULL,
+      LastUpdate BIGINT NOT NULL,
+      PRIMARY KEY(Node, EntityType)
+    );`
+	// The pages of the multipart POST responses, if they are kept in the database instead of the statics directory, see backend/responsegenerator. A response is served only once all of its pages are in and it is published.
+	schema20 := `
+    CREATE TABLE IF NOT EXISTS ResponsePages (
+      Response VARCHAR(128) NOT NULL,
+      Page INT NOT NULL,
+      Data LONGBLOB NOT NULL,
+      Bytes BIGINT NOT NULL,
+      Expiry BIGINT NOT NULL,
+      Published BOOLEAN NOT NULL,
+      PRIMARY KEY(Response, Page),
+      INDEX (Expiry)
+    );`
+	schema21 := `
+    CREATE TABLE IF NOT EXISTS Provenance (
+      Fingerprint VARCHAR(64) PRIMARY KEY NOT NULL,
+      EntityType VARCHAR(64) NOT NULL,
+      Node VARCHAR(64) NOT NULL,
+      FirstSeen BIGINT NOT NULL,
+      Via VARCHAR(64) NOT NULL,
+      INDEX (Node),
+      INDEX (FirstSeen)
+    );`
+	// The drafts of the local users, see backend/drafts. They are not entities: they are not signed until they are published, and are never given to the remotes.
+	schema22 := `
+    CREATE TABLE IF NOT EXISTS Drafts (
+      Id VARCHAR(64) NOT NULL,
+      Profile VARCHAR(64) NOT NULL,
+      Kind VARCHAR(16) NOT NULL,
+      Board VARCHAR(64) NOT NULL,
+      Thread VARCHAR(64) NOT NULL,
+      Parent VARCHAR(64) NOT NULL,
+      Name TEXT NOT NULL,
+      Body LONGTEXT NOT NULL,
+      Link TEXT NOT NULL,
+      Creation BIGINT NOT NULL,
+      LastUpdate BIGINT NOT NULL,
+      PublishAt BIGINT NOT NULL,
+      State VARCHAR(16) NOT NULL,
+      Job VARCHAR(64) NOT NULL,
+      Published VARCHAR(64) NOT NULL,
+      Error TEXT NOT NULL,
+      PRIMARY KEY(Profile, Id)
+    );`
+	// How far the local users read each thread, see backend/readmarkers. The posts after the marker, in the order of their creation, are unread.
+	schema23 := `
+    CREATE TABLE IF NOT EXISTS ReadMarkers (
+      Profile VARCHAR(64) NOT NULL,
+      Thread VARCHAR(64) NOT NULL,
+      Board VARCHAR(64) NOT NULL,
+      LastRead BIGINT NOT NULL,
+      LastReadPost VARCHAR(64) NOT NULL,
+      LastUpdate BIGINT NOT NULL,
+      PRIMARY KEY(Profile, Thread),
+      INDEX (Profile, Board)
+    );`
+	// The threads and boards the local users watch, see backend/watches.
+	schema24 := `
+    CREATE TABLE IF NOT EXISTS Watches (
+      Profile VARCHAR(64) NOT NULL,
+      Type VARCHAR(16) NOT NULL,
+      Target VARCHAR(64) NOT NULL,
+      Creation BIGINT NOT NULL,
+      PRIMARY KEY(Profile, Type, Target)
+    );`
 	var creationSchemas []string
 	creationSchemas = append(creationSchemas, schema1)
 	creationSchemas = append(creationSchemas, schema2)
@@ -182,11 +368,163 @@ func CreateDatabase() {
 	creationSchemas = append(creationSchemas, schema8)
 	creationSchemas = append(creationSchemas, schema9)
 	creationSchemas = append(creationSchemas, schema10)
+	creationSchemas = append(creationSchemas, schema11)
+	creationSchemas = append(creationSchemas, schema12)
+	creationSchemas = append(creationSchemas, schema13)
+	creationSchemas = append(creationSchemas, schema14)
+	creationSchemas = append(creationSchemas, schema15)
+	creationSchemas = append(creationSchemas, schema16)
+	creationSchemas = append(creationSchemas, schema17)
+	creationSchemas = append(creationSchemas, schema18)
+	creationSchemas = append(creationSchemas, schema19)
+	creationSchemas = append(creationSchemas, schema20)
+	creationSchemas = append(creationSchemas, schema21)
+	creationSchemas = append(creationSchemas, schema22)
+	creationSchemas = append(creationSchemas, schema23)
+	creationSchemas = append(creationSchemas, schema24)
+	return creationSchemas
+}
 
-	for _, schema := range creationSchemas {
+// CreateDatabase creates a new database in the default location and places into it the database schema.
+func CreateDatabase() {
+	for _, schema := range creationSchemas() {
 		// fmt.Println(schema)
 		DbInstance.MustExec(schema)
 	}
+	// A database created by an older version is missing the columns added since, and the inserts would fail without them.
+	problems, err := CheckSchema()
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The schema of the database could not be checked. Error: %s", err))
+		return
+	}
+	if len(problems) > 0 {
+		err2 := RepairSchema(problems)
+		if err2 != nil {
+			logging.LogCrash(err2)
+		}
+		logging.Log(1, fmt.Sprintf("The database was upgraded to the schema of this version. Changes: %v", problems))
+	}
+}
+
+// tableSchema is a table as the creation schema describes it: its name, the definitions of its columns by column name, and the indexes on single columns by column name.
+type tableSchema struct {
+	Name        string
+	Columns     []string
+	Definitions map[string]string
+	Indexes     map[string]string
+}
+
+// parseSchema reads the table name and the column definitions out of a CREATE TABLE statement. Comments, keys and indexes are skipped.
+func parseSchema(schema string) tableSchema {
+	var t tableSchema
+	t.Definitions = make(map[string]string)
+	t.Indexes = make(map[string]string)
+	lines := strings.Split(schema, "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if i := strings.Index(line, "--"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if strings.HasPrefix(line, "CREATE TABLE IF NOT EXISTS ") {
+			t.Name = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "CREATE TABLE IF NOT EXISTS "), "("))
+			continue
+		}
+		if strings.HasPrefix(line, "INDEX (") {
+			col := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(line, "INDEX ("), ","), ")")
+			if !strings.Contains(col, ",") {
+				t.Indexes[col] = strings.TrimSuffix(line, ",")
+			}
+			continue
+		}
+		if len(line) == 0 || strings.HasPrefix(line, ")") || strings.HasPrefix(line, "PRIMARY KEY") || strings.HasPrefix(line, "INDEX") {
+			continue
+		}
+		fields := strings.Fields(line)
+		t.Columns = append(t.Columns, fields[0])
+		t.Definitions[fields[0]] = strings.TrimSuffix(line, ",")
+	}
+	return t
+}
+
+// SchemaProblem is a difference between the database and the creation schema. A database created by an older version can be missing the columns added since.
+type SchemaProblem struct {
+	Table  string `json:"table"`
+	Column string `json:"column,omitempty"` // Empty if the whole table is missing.
+}
+
+func (p SchemaProblem) String() string {
+	if len(p.Column) == 0 {
+		return fmt.Sprintf("The table %s is missing.", p.Table)
+	}
+	return fmt.Sprintf("The column %s.%s is missing.", p.Table, p.Column)
+}
+
+// CheckSchema compares the tables and columns in the database with the creation schema, and returns what is missing.
+func CheckSchema() ([]SchemaProblem, error) {
+	var problems []SchemaProblem
+	for _, schema := range creationSchemas() {
+		t := parseSchema(schema)
+		rows, err := DbInstance.Queryx(fmt.Sprintf("SELECT * FROM %s LIMIT 0", t.Name))
+		if err != nil {
+			// MySQL error 1146: Table doesn't exist.
+			if strings.Contains(err.Error(), "1146") {
+				problems = append(problems, SchemaProblem{Table: t.Name})
+				continue
+			}
+			return problems, err
+		}
+		cols, err2 := rows.Columns()
+		rows.Close()
+		if err2 != nil {
+			return problems, err2
+		}
+		existing := make(map[string]bool)
+		for _, c := range cols {
+			existing[strings.ToLower(c)] = true
+		}
+		for _, c := range t.Columns {
+			if !existing[strings.ToLower(c)] {
+				problems = append(problems, SchemaProblem{Table: t.Name, Column: c})
+			}
+		}
+	}
+	return problems, nil
+}
+
+// RepairSchema creates the missing tables, and adds the missing columns with the definitions in the creation schema. The rows already in the table get the default value of the column type.
+func RepairSchema(problems []SchemaProblem) error {
+	definitions := make(map[string]tableSchema)
+	for _, schema := range creationSchemas() {
+		t := parseSchema(schema)
+		definitions[t.Name] = t
+	}
+	for _, p := range problems {
+		if len(p.Column) == 0 {
+			continue
+		}
+		def, ok := definitions[p.Table].Definitions[p.Column]
+		if !ok {
+			return errors.New(fmt.Sprintf("The column is not in the creation schema. Table: %s, Column: %s", p.Table, p.Column))
+		}
+		_, err := DbInstance.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", p.Table, def))
+		if err != nil {
+			return errors.New(fmt.Sprintf("The column could not be added. Table: %s, Column: %s, Error: %s", p.Table, p.Column, err))
+		}
+		if idx, ok := definitions[p.Table].Indexes[p.Column]; ok {
+			_, err2 := DbInstance.Exec(fmt.Sprintf("ALTER TABLE %s ADD %s", p.Table, idx))
+			if err2 != nil {
+				return errors.New(fmt.Sprintf("The index of the column could not be added. Table: %s, Column: %s, Error: %s", p.Table, p.Column, err2))
+			}
+		}
+	}
+	// Missing tables are created as if this was a new database.
+	for _, schema := range creationSchemas() {
+		_, err := DbInstance.Exec(schema)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Insertion SQL code used by the writer.
@@ -196,11 +534,11 @@ var nodeInsert = `REPLACE INTO Nodes
 (
   Fingerprint, BoardsLastCheckin, ThreadsLastCheckin, PostsLastCheckin,
   VotesLastCheckin, AddressesLastCheckin, KeysLastCheckin,
-  TruststatesLastCheckin
+  TruststatesLastCheckin, TombstonesLastCheckin
 ) VALUES (
   :Fingerprint, :BoardsLastCheckin, :ThreadsLastCheckin, :PostsLastCheckin,
   :VotesLastCheckin, :AddressesLastCheckin, :KeysLastCheckin,
-  :TruststatesLastCheckin
+  :TruststatesLastCheckin, :TombstonesLastCheckin
 )`
 
 // Board insert does insert or replace without checking because we're handling the logic that decides whether we should update or not in the database layer.
@@ -208,11 +546,13 @@ var boardInsert = `REPLACE INTO Boards
   (
     Fingerprint, Name, Owner, Description, LocalArrival,
     Creation, ProofOfWork, Signature,
-    LastUpdate, UpdateProofOfWork, UpdateSignature
+    LastUpdate, UpdateProofOfWork, UpdateSignature,
+    Language, DeclaredLanguage, Meta
   ) VALUES (
     :Fingerprint, :Name, :Owner, :Description, :LocalArrival,
     :Creation, :ProofOfWork, :Signature,
-    :LastUpdate, :UpdateProofOfWork, :UpdateSignature
+    :LastUpdate, :UpdateProofOfWork, :UpdateSignature,
+    :Language, :DeclaredLanguage, :Meta
   )`
 
 // BoardOwners are mutable, but the condition of mutation is handled in the application layer. The only place the REPLACE could trigger is change of Expiry and level. The BoardFingerprint and KeyFingerprint are identity columns, so anything with different data on those will be committed as a new item.
@@ -230,20 +570,22 @@ var boardOwnerDelete = `DELETE FROM BoardOwners WHERE BoardFingerprint = :BoardF
 var threadInsert = `INSERT IGNORE INTO Threads
 (
   Fingerprint, Board, Name, Body, Link, Owner, LocalArrival,
-  Creation, ProofOfWork, Signature
+  Creation, ProofOfWork, Signature,
+  Language, DeclaredLanguage, Meta
 ) VALUES (
   :Fingerprint, :Board, :Name, :Body, :Link, :Owner, :LocalArrival,
-  :Creation, :ProofOfWork, :Signature
+  :Creation, :ProofOfWork, :Signature,
+  :Language, :DeclaredLanguage, :Meta
 )`
 
 // Immutable
 var postInsert = `INSERT IGNORE INTO Posts
 (
   Fingerprint, Board, Thread, Parent, Body, Owner, LocalArrival,
-  Creation, ProofOfWork, Signature
+  Creation, ProofOfWork, Signature, Meta
 ) VALUES (
   :Fingerprint, :Board, :Thread, :Parent, :Body, :Owner, :LocalArrival,
-  :Creation, :ProofOfWork, :Signature
+  :Creation, :ProofOfWork, :Signature, :Meta
 )`
 
 var voteInsert = `REPLACE INTO Votes
@@ -266,18 +608,88 @@ var voteInsert = `REPLACE INTO Votes
   WHERE (Candidate.LastUpdate > Votes.LastUpdate AND Candidate.LastUpdate > Votes.Creation)
   OR Votes.Fingerprint IS NULL`
 
+// Vote summary insert is mutable. A summary is replaced by the next compaction with the new total and a new signature.
+var voteSummaryInsert = `REPLACE INTO VoteSummaries
+(
+  Target, Type, Board, Thread, Count, Until, Signer, Signature, LocalArrival
+) VALUES (
+  :Target, :Type, :Board, :Thread, :Count, :Until, :Signer, :Signature, :LocalArrival
+)`
+
+// Thread scores are local. They are computed from the votes and the replies of the thread, and replaced every time those change.
+var threadScoreInsert = `REPLACE INTO ThreadScores
+(
+  Thread, Board, Creation, Upvotes, Downvotes, Replies, LastReply, Hot, Top
+) VALUES (
+  :Thread, :Board, :Creation, :Upvotes, :Downvotes, :Replies, :LastReply, :Hot, :Top
+)`
+
+var replyPathInsert = `REPLACE INTO ReplyPaths
+(
+  Post, Thread, Parent, Path, Depth, Detached
+) VALUES (
+  :Post, :Thread, :Parent, :Path, :Depth, :Detached
+)`
+
+// Content filters are local. Adding a filter that already exists changes its action.
+var contentFilterInsert = `REPLACE INTO ContentFilters
+(
+  Profile, Type, Value, Action, Creation
+) VALUES (
+  :Profile, :Type, :Value, :Action, :Creation
+)`
+
+var syncBookmarkInsert = `REPLACE INTO SyncBookmarks
+(
+  Node, EntityType, LastCache, LastCacheEnd, IndexETag, Covered, LastUpdate
+) VALUES (
+  :Node, :EntityType, :LastCache, :LastCacheEnd, :IndexETag, :Covered, :LastUpdate
+)`
+
+// Drafts are local, and a draft that is saved again replaces the one before.
+var draftInsert = `REPLACE INTO Drafts
+(
+  Id, Profile, Kind, Board, Thread, Parent, Name, Body, Link, Creation, LastUpdate, PublishAt, State, Job, Published, Error
+) VALUES (
+  :Id, :Profile, :Kind, :Board, :Thread, :Parent, :Name, :Body, :Link, :Creation, :LastUpdate, :PublishAt, :State, :Job, :Published, :Error
+)`
+
+// Read markers are local, and a marker that is moved replaces the one before.
+var readMarkerInsert = `REPLACE INTO ReadMarkers
+(
+  Profile, Thread, Board, LastRead, LastReadPost, LastUpdate
+) VALUES (
+  :Profile, :Thread, :Board, :LastRead, :LastReadPost, :LastUpdate
+)`
+
+// Watches are local. Watching what is watched already keeps it as it was.
+var watchInsert = `INSERT IGNORE INTO Watches
+(
+  Profile, Type, Target, Creation
+) VALUES (
+  :Profile, :Type, :Target, :Creation
+)`
+
+// Provenance insert is immutable. Only the first delivery of an entity is kept.
+var provenanceInsert = `INSERT IGNORE INTO Provenance
+(
+  Fingerprint, EntityType, Node, FirstSeen, Via
+) VALUES (
+  :Fingerprint, :EntityType, :Node, :FirstSeen, :Via
+)`
+
 // Address insert is immutable. This is used for when a node receives data from an address from a node that is not at the aforementioned address. In other words, an address object coming from a third party node not at that address cannot change an existing address saved in the database.
 var addressInsert = `INSERT IGNORE INTO Addresses
 (
   Location, Sublocation, Port, IPType, AddressType, LastOnline,
   ProtocolVersionMajor, ProtocolVersionMinor, ProtocolExtensions,
   ClientVersionMajor, ClientVersionMinor, ClientVersionPatch, ClientName,
-  LocalArrival
+  Endpoints, Local, LocalArrival
 ) VALUES (
   :Location, :Sublocation, :Port,:IPType, :AddressType, :LastOnline,
   :ProtocolVersionMajor, :ProtocolVersionMinor, :ProtocolExtensions,
   :ClientVersionMajor, :ClientVersionMinor, :ClientVersionPatch, :ClientName,
-  :LocalArrival
+  :Endpoints, :Local, :LocalArrival
 )`
 
 // Address update insert is mutable. This is used when the node connects to the address itself. Example: When a node connects to 256.253.231.123:8080, it will update the entry for that address with the data coming from the remote node. This is the only way to mutate an address object.
@@ -286,12 +698,12 @@ var addressUpdateInsert = `REPLACE INTO Addresses
   Location, Sublocation, Port, IPType, AddressType, LastOnline,
   ProtocolVersionMajor, ProtocolVersionMinor, ProtocolExtensions,
   ClientVersionMajor, ClientVersionMinor, ClientVersionPatch, ClientName,
-  LocalArrival
+  Endpoints, Local, LocalArrival
 ) VALUES (
   :Location, :Sublocation, :Port,:IPType, :AddressType, :LastOnline,
   :ProtocolVersionMajor, :ProtocolVersionMinor, :ProtocolExtensions,
   :ClientVersionMajor, :ClientVersionMinor, :ClientVersionPatch, :ClientName,
-  :LocalArrival
+  :Endpoints, :Local, :LocalArrival
 )`
 
 // Key insert does insert or replace without checking because we're handling the logic that decides whether we should update or not in the database layer.
@@ -336,3 +748,42 @@ var truststateInsert = `REPLACE INTO Truststates
   LEFT JOIN Truststates ON Candidate.Fingerprint = Truststates.Fingerprint
   WHERE (Candidate.LastUpdate > Truststates.LastUpdate AND Candidate.LastUpdate > Truststates.Creation)
   OR Truststates.Fingerprint IS NULL`
+
+// Immutable
+var tombstoneInsert = `INSERT IGNORE INTO Tombstones
+(
+  Fingerprint, Target, TargetType, Owner, LocalArrival,
+  Creation, ProofOfWork, Signature
+) VALUES (
+  :Fingerprint, :Target, :TargetType, :Owner, :LocalArrival,
+  :Creation, :ProofOfWork, :Signature
+)`
+
+// These hide the bodies of the threads and posts that have a tombstone from their owner. The entity rows themselves are kept so that an incoming copy of the same entity is ignored by INSERT IGNORE, instead of bringing the body back. They run after every batch insert, which means it does not matter whether the tombstone or its target arrives first.
+var threadTombstoneApply = `UPDATE Threads
+  INNER JOIN Tombstones ON Threads.Fingerprint = Tombstones.Target
+  AND Threads.Owner = Tombstones.Owner
+  SET Threads.Name = '', Threads.Body = '', Threads.Link = ''
+  WHERE Tombstones.TargetType = 'threads' AND Tombstones.Owner != ''`
+
+var postTombstoneApply = `UPDATE Posts
+  INNER JOIN Tombstones ON Posts.Fingerprint = Tombstones.Target
+  AND Posts.Owner = Tombstones.Owner
+  SET Posts.Body = ''
+  WHERE Tombstones.TargetType = 'posts' AND Tombstones.Owner != ''`
+
+// Notifications are local, they are never sent over the wire. A notification is created once per post and type, and the only mutation is marking it as seen.
+var notificationInsert = `INSERT IGNORE INTO Notifications
+(
+  Post, Type, Target, Thread, Owner, Creation, Seen, LocalArrival
+) VALUES (
+  :Post, :Type, :Target, :Thread, :Owner, :Creation, :Seen, :LocalArrival
+)`
+
+// Imported items are local, they record which feed items the importer has already converted into threads.
+var importedItemInsert = `INSERT IGNORE INTO ImportedItems
+(
+  ItemKey, FeedUrl, Thread, LocalArrival
+) VALUES (
+  :ItemKey, :FeedUrl, :Thread, :LocalArrival
+)`
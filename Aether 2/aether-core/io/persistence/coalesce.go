@@ -0,0 +1,76 @@
+// Persistence > Coalesce
+// This file coalesces the votes and the truststates of a batch before the batch is written. These are the entities that are updated the most, since a user changes the direction of a vote or a trust as often as they like, and a sync that catches up on a remote often brings several writes of the same vote. Without this, each of them is a separate replace of the same row, within the same transaction.
+// A vote or a truststate is keyed by its fingerprint, as its row in the database is, and the last write of each key wins: the one updated last, or if they were updated at the same time, the one with the greater update signature. Every node picks the same one no matter in which order the writes arrive, so the nodes don't end up with different winners. Two votes or truststates with the same owner and target but different fingerprints are different entities, and both are written, whether they come in the same batch or not. The replace statements still check the write against what the database has, so a write older than the one already in it is not written either.
+
+package persistence
+
+import (
+	"aether-core/io/api"
+)
+
+// updateKey is what the last write of a vote or a truststate is kept by.
+type updateKey struct {
+	entityType  string
+	fingerprint api.Fingerprint
+}
+
+// updateWrite is a write of a vote or a truststate, with what it is compared with the other writes of its key by.
+type updateWrite struct {
+	signature api.Signature // The update signature, which breaks the ties.
+	written   api.Timestamp // When it was last written: its last update, or its creation if it was never updated.
+}
+
+// coalescable gives the key and the write of the vote or the truststate. It gives false for the other entities, which are written as they are.
+func coalescable(apiObject interface{}) (updateKey, updateWrite, bool) {
+	switch obj := apiObject.(type) {
+	case api.Vote:
+		return updateKey{"votes", obj.Fingerprint}, newUpdateWrite(obj.UpdateSignature, obj.Creation, obj.LastUpdate), true
+	case api.Truststate:
+		return updateKey{"truststates", obj.Fingerprint}, newUpdateWrite(obj.UpdateSignature, obj.Creation, obj.LastUpdate), true
+	}
+	return updateKey{}, updateWrite{}, false
+}
+
+func newUpdateWrite(signature api.Signature, creation api.Timestamp, lastUpdate api.Timestamp) updateWrite {
+	written := creation
+	if lastUpdate > written {
+		written = lastUpdate
+	}
+	return updateWrite{signature: signature, written: written}
+}
+
+// supersedes checks whether the write wins over the other one of the same key.
+func (w updateWrite) supersedes(other updateWrite) bool {
+	if w.written != other.written {
+		return w.written > other.written
+	}
+	return w.signature > other.signature
+}
+
+// coalesceUpdates gives the objects of the batch with only the last write of each vote and truststate, each in the place the first write of its key was in, and how many writes were dropped.
+func coalesceUpdates(apiObjects []interface{}) ([]interface{}, int) {
+	var result []interface{}
+	winners := make(map[updateKey]int) // The place of the winner of the key in the result.
+	writes := make(map[updateKey]updateWrite)
+	dropped := 0
+	for i, _ := range apiObjects {
+		key, write, ok := coalescable(apiObjects[i])
+		if !ok {
+			result = append(result, apiObjects[i])
+			continue
+		}
+		place, seen := winners[key]
+		if !seen {
+			winners[key] = len(result)
+			writes[key] = write
+			result = append(result, apiObjects[i])
+			continue
+		}
+		dropped++
+		if write.supersedes(writes[key]) {
+			writes[key] = write
+			result[place] = apiObjects[i]
+		}
+	}
+	return result, dropped
+}
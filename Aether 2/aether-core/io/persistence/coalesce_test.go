@@ -0,0 +1,83 @@
+// This test is in the package itself rather than in persistence_test, since the batch is coalesced by a function that is not exported, before anything is written.
+
+package persistence
+
+import (
+	"aether-core/io/api"
+	"testing"
+)
+
+func coalesceVote(fp api.Fingerprint, owner api.Fingerprint, target api.Fingerprint, voteType uint8, lastUpdate api.Timestamp) api.Vote {
+	var v api.Vote
+	v.Fingerprint = fp
+	v.Owner = owner
+	v.Target = target
+	v.Type = voteType
+	v.Creation = 1000
+	v.LastUpdate = lastUpdate
+	return v
+}
+
+func TestCoalesceUpdates_Success(t *testing.T) {
+	var thread api.Thread
+	thread.Fingerprint = "thread"
+	var trust api.Truststate
+	trust.Fingerprint = "trust"
+	trust.Owner = "owner"
+	trust.Target = "target"
+	batch := []interface{}{
+		coalesceVote("vote", "owner", "target", api.VoteUp, 1100),
+		thread,
+		coalesceVote("vote", "owner", "target", api.VoteDown, 1300),
+		trust,
+		coalesceVote("vote", "owner", "target", api.VoteUp, 1200),
+		coalesceVote("vote2", "owner2", "target", api.VoteUp, 0),
+	}
+	result, dropped := coalesceUpdates(batch)
+	if len(result) != 4 || dropped != 2 {
+		t.Fatalf("Only the last write of each vote should be left. Objects: %d, Dropped: %d", len(result), dropped)
+	}
+	v, ok := result[0].(api.Vote)
+	if !ok || v.Type != api.VoteDown || v.LastUpdate != 1300 {
+		t.Errorf("The vote updated last should win, in the place of the first write. Object: %#v", result[0])
+	}
+	if _, ok := result[1].(api.Thread); !ok {
+		t.Errorf("The other entities should be left as they are. Object: %#v", result[1])
+	}
+	if _, ok := result[2].(api.Truststate); !ok {
+		t.Errorf("A truststate with the same owner and target as a vote should not be coalesced with it. Object: %#v", result[2])
+	}
+}
+
+func TestCoalesceUpdates_Success_SameOwnerAndTarget(t *testing.T) {
+	// Different fingerprints are different rows in the database, so both are written, even with the same owner and target.
+	a := coalesceVote("aaaa", "owner", "target", api.VoteUp, 1100)
+	b := coalesceVote("bbbb", "owner", "target", api.VoteDown, 1200)
+	result, dropped := coalesceUpdates([]interface{}{a, b})
+	if len(result) != 2 || dropped != 0 {
+		t.Errorf("The votes of different fingerprints should not be coalesced. Objects: %d, Dropped: %d", len(result), dropped)
+	}
+}
+
+func TestCoalesceUpdates_Fail_Tie(t *testing.T) {
+	// Two writes of the same vote, at the same time, in both orders. The same one should win either way.
+	a := coalesceVote("vote", "owner", "target", api.VoteUp, 1100)
+	a.UpdateSignature = "aaaa"
+	b := coalesceVote("vote", "owner", "target", api.VoteDown, 1100)
+	b.UpdateSignature = "bbbb"
+	first, _ := coalesceUpdates([]interface{}{a, b})
+	second, _ := coalesceUpdates([]interface{}{b, a})
+	if len(first) != 1 || len(second) != 1 {
+		t.Fatalf("The writes of the same vote should be coalesced. Objects: %d, %d", len(first), len(second))
+	}
+	if first[0].(api.Vote).UpdateSignature != "bbbb" || second[0].(api.Vote).UpdateSignature != "bbbb" {
+		t.Errorf("A tie should be broken by the update signature, not by the order. Winners: %s, %s", first[0].(api.Vote).UpdateSignature, second[0].(api.Vote).UpdateSignature)
+	}
+	// A creation later than the update of the other counts as the later write.
+	c := coalesceVote("vote", "owner", "target", api.VoteUp, 0)
+	c.Creation = 1200
+	third, _ := coalesceUpdates([]interface{}{a, c})
+	if third[0].(api.Vote).Creation != 1200 {
+		t.Errorf("A write created after the other was updated should win. Winner: %#v", third[0])
+	}
+}
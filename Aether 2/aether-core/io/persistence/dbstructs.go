@@ -5,12 +5,14 @@ package persistence
 
 import (
 	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/language"
 	"aether-core/services/logging"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
-	"time"
 )
 
 // Basic properties
@@ -50,6 +52,10 @@ type DbBoard struct {
 	Owner        api.Fingerprint `db:"Owner"`
 	Description  string          `db:"Description"`
 	LocalArrival api.Timestamp   `db:"LocalArrival"`
+	// Language is what the language filter matches: the declared language, normalised, or the detected one if none was declared.
+	Language         string `db:"Language"`
+	DeclaredLanguage string `db:"DeclaredLanguage"`
+	Meta             string `db:"Meta"` // JSON, see encodeMeta.
 	DbProvable
 	DbUpdateable
 }
@@ -62,6 +68,10 @@ type DbThread struct {
 	Link         string          `db:"Link"`
 	Owner        api.Fingerprint `db:"Owner"`
 	LocalArrival api.Timestamp   `db:"LocalArrival"`
+	// Language is what the language filter matches: the declared language, normalised, or the detected one if none was declared.
+	Language         string `db:"Language"`
+	DeclaredLanguage string `db:"DeclaredLanguage"`
+	Meta             string `db:"Meta"` // JSON, see encodeMeta.
 	DbProvable
 }
 
@@ -73,6 +83,7 @@ type DbPost struct {
 	Body         string          `db:"Body"`
 	Owner        api.Fingerprint `db:"Owner"`
 	LocalArrival api.Timestamp   `db:"LocalArrival"`
+	Meta         string          `db:"Meta"` // JSON, see encodeMeta.
 	DbProvable
 }
 
@@ -102,6 +113,8 @@ type DbAddress struct {
 	ClientVersionMinor   uint16        `db:"ClientVersionMinor"`
 	ClientVersionPatch   uint16        `db:"ClientVersionPatch"`
 	ClientName           string        `db:"ClientName"`
+	Endpoints            string        `db:"Endpoints"` // JSON list of api.AddressEndpoint
+	Local                bool          `db:"Local"`
 	LocalArrival         api.Timestamp `db:"LocalArrival"`
 }
 
@@ -128,7 +141,93 @@ type DbTruststate struct {
 	DbUpdateable
 }
 
+type DbTombstone struct {
+	Fingerprint  api.Fingerprint `db:"Fingerprint"`
+	Target       api.Fingerprint `db:"Target"`
+	TargetType   string          `db:"TargetType"`
+	Owner        api.Fingerprint `db:"Owner"`
+	LocalArrival api.Timestamp   `db:"LocalArrival"`
+	DbProvable
+}
+
 // Non-communicating entities
+
+// DbNotification is a reply to, or a mention of the local user, found in the incoming posts.
+type DbNotification struct {
+	Post         api.Fingerprint `db:"Post"`
+	Type         string          `db:"Type"`   // "reply" or "mention"
+	Target       api.Fingerprint `db:"Target"` // The local user's entity that was replied to, or the local user's key for mentions.
+	Thread       api.Fingerprint `db:"Thread"`
+	Owner        api.Fingerprint `db:"Owner"`
+	Creation     api.Timestamp   `db:"Creation"`
+	Seen         bool            `db:"Seen"`
+	LocalArrival api.Timestamp   `db:"LocalArrival"`
+}
+
+// DbVoteSummary is the count of the compacted votes of one type on one target.
+type DbVoteSummary struct {
+	Target       api.Fingerprint `db:"Target"`
+	Type         uint8           `db:"Type"`
+	Board        api.Fingerprint `db:"Board"`
+	Thread       api.Fingerprint `db:"Thread"`
+	Count        int64           `db:"Count"`
+	Until        api.Timestamp   `db:"Until"`
+	Signer       string          `db:"Signer"`
+	Signature    api.Signature   `db:"Signature"`
+	LocalArrival api.Timestamp   `db:"LocalArrival"`
+}
+
+// DbThreadScore is the activity of a thread, and the scores the threads of a board are ranked by.
+type DbThreadScore struct {
+	Thread    api.Fingerprint `db:"Thread"`
+	Board     api.Fingerprint `db:"Board"`
+	Creation  api.Timestamp   `db:"Creation"`
+	Upvotes   int64           `db:"Upvotes"` // Votes on the thread itself, including the compacted ones.
+	Downvotes int64           `db:"Downvotes"`
+	Replies   int64           `db:"Replies"` // Posts anywhere in the thread.
+	LastReply api.Timestamp   `db:"LastReply"`
+	Hot       float64         `db:"Hot"`
+	Top       int64           `db:"Top"`
+}
+
+// DbReplyPath is the place of a post in the reply tree of its thread.
+type DbReplyPath struct {
+	Post     api.Fingerprint `db:"Post"`
+	Thread   api.Fingerprint `db:"Thread"`
+	Parent   api.Fingerprint `db:"Parent"` // The thread for the replies to the thread.
+	Path     string          `db:"Path"`
+	Depth    int             `db:"Depth"`
+	Detached bool            `db:"Detached"` // The parent, or one of the ancestors, hasn't arrived yet.
+}
+
+// DbContentFilter is a keyword, pattern, author or board the local user muted.
+type DbContentFilter struct {
+	Profile  api.Fingerprint `db:"Profile"` // Key fingerprint of the local user the filter belongs to.
+	Type     string          `db:"Type"`    // "keyword", "regex", "author" or "board"
+	Value    string          `db:"Value"`
+	Action   string          `db:"Action"` // "hide" or "collapse"
+	Creation api.Timestamp   `db:"Creation"`
+}
+
+// DbSyncBookmark is where the last sync with a remote ended for an entity type: the last cache read from it, and the time up to which the remote has given everything it has.
+type DbSyncBookmark struct {
+	Node         api.Fingerprint `db:"Node"`
+	EntityType   string          `db:"EntityType"`
+	LastCache    string          `db:"LastCache"` // The name of the cache, as in the cache index of the remote.
+	LastCacheEnd api.Timestamp   `db:"LastCacheEnd"`
+	IndexETag    string          `db:"IndexETag"` // The ETag of the cache index the last cache was listed in.
+	Covered      api.Timestamp   `db:"Covered"`
+	LastUpdate   api.Timestamp   `db:"LastUpdate"`
+}
+
+// DbImportedItem is a feed item that the importer has already converted into a thread. ItemKey is the hash of the feed URL and the item's unique id.
+type DbImportedItem struct {
+	ItemKey      string          `db:"ItemKey"`
+	FeedUrl      string          `db:"FeedUrl"`
+	Thread       api.Fingerprint `db:"Thread"`
+	LocalArrival api.Timestamp   `db:"LocalArrival"`
+}
+
 type DbNode struct {
 	Fingerprint            api.Fingerprint `db:"Fingerprint"`
 	BoardsLastCheckin      api.Timestamp   `db:"BoardsLastCheckin"`
@@ -138,6 +237,7 @@ type DbNode struct {
 	AddressesLastCheckin   api.Timestamp   `db:"AddressesLastCheckin"`
 	KeysLastCheckin        api.Timestamp   `db:"KeysLastCheckin"`
 	TruststatesLastCheckin api.Timestamp   `db:"TruststatesLastCheckin"`
+	TombstonesLastCheckin  api.Timestamp   `db:"TombstonesLastCheckin"`
 }
 
 // Return types of APIToDB. This is necessary because some API objects, when converted to their DB form, return more than one DB object.
@@ -152,6 +252,39 @@ type KeyPack struct {
 	CurrencyAddresses []DbCurrencyAddress
 }
 
+// tagLanguage gives the language an entity is filtered by. The language the author declared wins; the text is looked at only if there is none.
+func tagLanguage(declared string, texts ...string) string {
+	if lang := language.Normalise(declared); len(lang) > 0 {
+		return lang
+	}
+	return language.Detect(strings.Join(texts, "\n"))
+}
+
+// encodeMeta gives the metadata of an entity as it is kept in the database. An entity without metadata keeps an empty string, not "{}", so that the rows of the entities that have none stay as they were.
+func encodeMeta(m api.Meta) (string, error) {
+	if len(m) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("The metadata could not be encoded. Error: %s", err))
+	}
+	return string(b), nil
+}
+
+// decodeMeta reads the metadata of an entity from the database.
+func decodeMeta(s string) (api.Meta, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	var m api.Meta
+	err := json.Unmarshal([]byte(s), &m)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("The metadata could not be decoded. Error: %s", err))
+	}
+	return m, nil
+}
+
 // APItoDB translates structs of API objects into structs of DB objects.
 func APItoDB(object interface{}) (interface{}, error) {
 	switch obj := object.(type) {
@@ -163,7 +296,14 @@ func APItoDB(object interface{}) (interface{}, error) {
 		dbObj.Name = obj.Name
 		dbObj.Owner = obj.Owner
 		dbObj.Description = obj.Description
-		now := time.Now().Unix()
+		dbObj.DeclaredLanguage = obj.Language
+		dbObj.Language = tagLanguage(obj.Language, obj.Name, obj.Description)
+		meta, err := encodeMeta(obj.Meta)
+		if err != nil {
+			return nil, err
+		}
+		dbObj.Meta = meta
+		now := clock.Unix()
 		dbObj.LocalArrival = api.Timestamp(now)
 		// Provable set
 		dbObj.Creation = obj.Creation
@@ -195,7 +335,14 @@ func APItoDB(object interface{}) (interface{}, error) {
 		dbObj.Body = obj.Body
 		dbObj.Link = obj.Link
 		dbObj.Owner = obj.Owner
-		now := time.Now().Unix()
+		dbObj.DeclaredLanguage = obj.Language
+		dbObj.Language = tagLanguage(obj.Language, obj.Name, obj.Body)
+		meta, err := encodeMeta(obj.Meta)
+		if err != nil {
+			return nil, err
+		}
+		dbObj.Meta = meta
+		now := clock.Unix()
 		dbObj.LocalArrival = api.Timestamp(now)
 		// Provable set
 		dbObj.Creation = obj.Creation
@@ -211,7 +358,12 @@ func APItoDB(object interface{}) (interface{}, error) {
 		dbObj.Parent = obj.Parent
 		dbObj.Body = obj.Body
 		dbObj.Owner = obj.Owner
-		now := time.Now().Unix()
+		meta, err := encodeMeta(obj.Meta)
+		if err != nil {
+			return nil, err
+		}
+		dbObj.Meta = meta
+		now := clock.Unix()
 		dbObj.LocalArrival = api.Timestamp(now)
 		// Provable set
 		dbObj.Creation = obj.Creation
@@ -227,7 +379,7 @@ func APItoDB(object interface{}) (interface{}, error) {
 		dbObj.Target = obj.Target
 		dbObj.Owner = obj.Owner
 		dbObj.Type = obj.Type
-		now := time.Now().Unix()
+		now := clock.Unix()
 		dbObj.LocalArrival = api.Timestamp(now)
 		// Provable set
 		dbObj.Creation = obj.Creation
@@ -263,13 +415,24 @@ func APItoDB(object interface{}) (interface{}, error) {
 		dbObj.ClientVersionMinor = obj.Client.VersionMinor
 		dbObj.ClientVersionPatch = obj.Client.VersionPatch
 		dbObj.ClientName = obj.Client.ClientName
-		now := time.Now().Unix()
+		now := clock.Unix()
 		dbObj.LocalArrival = api.Timestamp(now)
 		parsedStr, err := parseStringSliceToCommaSeparatedString(obj.Protocol.Extensions, 64, 100)
 		if err != nil {
 			return dbObj, err
 		}
 		dbObj.ProtocolExtensions = parsedStr
+		if len(obj.Endpoints) > 10 {
+			return dbObj, errors.New(fmt.Sprintf("This address has more than 10 endpoints. Address: %#v", obj))
+		}
+		if len(obj.Endpoints) > 0 {
+			endpointsJson, err := json.Marshal(obj.Endpoints)
+			if err != nil {
+				return dbObj, err
+			}
+			dbObj.Endpoints = string(endpointsJson)
+		}
+		dbObj.Local = obj.Local
 		return dbObj, nil
 
 	case api.Key:
@@ -280,7 +443,7 @@ func APItoDB(object interface{}) (interface{}, error) {
 		dbObj.PublicKey = obj.Key
 		dbObj.Name = obj.Name
 		dbObj.Info = obj.Info
-		now := time.Now().Unix()
+		now := clock.Unix()
 		dbObj.LocalArrival = api.Timestamp(now)
 		// Provable set
 		dbObj.Creation = obj.Creation
@@ -312,7 +475,7 @@ func APItoDB(object interface{}) (interface{}, error) {
 		dbObj.Owner = obj.Owner
 		dbObj.Type = obj.Type
 		dbObj.Expiry = obj.Expiry
-		now := time.Now().Unix()
+		now := clock.Unix()
 		dbObj.LocalArrival = api.Timestamp(now)
 		// Provable set
 		dbObj.Creation = obj.Creation
@@ -330,6 +493,20 @@ func APItoDB(object interface{}) (interface{}, error) {
 		}
 		dbObj.Domains = parsedStr
 		return dbObj, nil
+
+	case api.Tombstone:
+		var dbObj DbTombstone
+		dbObj.Fingerprint = obj.Fingerprint
+		dbObj.Target = obj.Target
+		dbObj.TargetType = obj.TargetType
+		dbObj.Owner = obj.Owner
+		now := clock.Unix()
+		dbObj.LocalArrival = api.Timestamp(now)
+		// Provable set
+		dbObj.Creation = obj.Creation
+		dbObj.ProofOfWork = obj.ProofOfWork
+		dbObj.Signature = obj.Signature
+		return dbObj, nil
 	default:
 		return nil, errors.New(
 			fmt.Sprintf(
@@ -348,6 +525,12 @@ func DBtoAPI(object interface{}) (interface{}, error) {
 		apiObj.Name = obj.Name
 		apiObj.Owner = obj.Owner
 		apiObj.Description = obj.Description
+		apiObj.Language = obj.DeclaredLanguage
+		meta, err := decodeMeta(obj.Meta)
+		if err != nil {
+			return nil, err
+		}
+		apiObj.Meta = meta
 		// Provable set
 		apiObj.Creation = obj.Creation
 		apiObj.ProofOfWork = obj.ProofOfWork
@@ -378,6 +561,12 @@ func DBtoAPI(object interface{}) (interface{}, error) {
 		apiObj.Body = obj.Body
 		apiObj.Link = obj.Link
 		apiObj.Owner = obj.Owner
+		apiObj.Language = obj.DeclaredLanguage
+		meta, err := decodeMeta(obj.Meta)
+		if err != nil {
+			return nil, err
+		}
+		apiObj.Meta = meta
 		// Provable set
 		apiObj.Creation = obj.Creation
 		apiObj.ProofOfWork = obj.ProofOfWork
@@ -392,6 +581,11 @@ func DBtoAPI(object interface{}) (interface{}, error) {
 		apiObj.Parent = obj.Parent
 		apiObj.Body = obj.Body
 		apiObj.Owner = obj.Owner
+		meta, err := decodeMeta(obj.Meta)
+		if err != nil {
+			return nil, err
+		}
+		apiObj.Meta = meta
 		// Provable set
 		apiObj.Creation = obj.Creation
 		apiObj.ProofOfWork = obj.ProofOfWork
@@ -436,6 +630,13 @@ func DBtoAPI(object interface{}) (interface{}, error) {
 			return apiObj, err
 		}
 		apiObj.Protocol.Extensions = parsedStrSlice
+		if len(obj.Endpoints) > 0 {
+			err2 := json.Unmarshal([]byte(obj.Endpoints), &apiObj.Endpoints)
+			if err2 != nil {
+				return apiObj, err2
+			}
+		}
+		apiObj.Local = obj.Local
 		return apiObj, nil
 
 	case DbKey:
@@ -490,6 +691,18 @@ func DBtoAPI(object interface{}) (interface{}, error) {
 		apiObj.Domains = convertStringSliceToFingerprintSlice(parsedStrSlice)
 		return apiObj, nil
 
+	case DbTombstone:
+		var apiObj api.Tombstone
+		apiObj.Fingerprint = obj.Fingerprint
+		apiObj.Target = obj.Target
+		apiObj.TargetType = obj.TargetType
+		apiObj.Owner = obj.Owner
+		// Provable set
+		apiObj.Creation = obj.Creation
+		apiObj.ProofOfWork = obj.ProofOfWork
+		apiObj.Signature = obj.Signature
+		return apiObj, nil
+
 	case DbBoardOwner:
 		return nil, errors.New(
 			fmt.Sprintf(
@@ -0,0 +1,72 @@
+// Persistence > Drafts
+// This file keeps the drafts of the local users: the threads and posts they started writing and haven't published, and the ones they scheduled to be published later. The drafts are kept per profile, which is the fingerprint of the key of the user, and are not signed until they are published.
+
+package persistence
+
+import (
+	"aether-core/io/api"
+	"errors"
+	"fmt"
+)
+
+// DbDraft is a thread or a post of the local user that isn't published yet, or that was scheduled and published.
+type DbDraft struct {
+	Id         string          `db:"Id"`
+	Profile    api.Fingerprint `db:"Profile"` // Key fingerprint of the local user the draft belongs to.
+	Kind       string          `db:"Kind"`    // "thread" or "post"
+	Board      api.Fingerprint `db:"Board"`
+	Thread     api.Fingerprint `db:"Thread"` // Posts only.
+	Parent     api.Fingerprint `db:"Parent"` // Posts only.
+	Name       string          `db:"Name"`   // Threads only.
+	Body       string          `db:"Body"`
+	Link       string          `db:"Link"` // Threads only.
+	Creation   api.Timestamp   `db:"Creation"`
+	LastUpdate api.Timestamp   `db:"LastUpdate"`
+	PublishAt  api.Timestamp   `db:"PublishAt"` // When a scheduled draft is published. 0 if it isn't scheduled.
+	State      string          `db:"State"`     // "draft", "scheduled", "published" or "failed"
+	Job        string          `db:"Job"`       // The job that publishes a scheduled draft.
+	Published  api.Fingerprint `db:"Published"` // The fingerprint of the entity the draft was published as.
+	Error      string          `db:"Error"`     // Why the publishing failed.
+}
+
+// InsertDraft saves a draft, replacing the one with the same id.
+func InsertDraft(d DbDraft) error {
+	if d.Id == "" {
+		return errors.New(fmt.Sprintf("This draft has one or more empty primary key(s). Draft: %#v\n", d))
+	}
+	_, err := DbInstance.NamedExec(draftInsert, d)
+	return err
+}
+
+// ReadDrafts reads the drafts of the given local user, the ones updated most recently first.
+func ReadDrafts(profile api.Fingerprint) ([]DbDraft, error) {
+	arr := []DbDraft{}
+	err := DbInstance.Select(&arr, "SELECT * FROM Drafts WHERE Profile = ? ORDER BY LastUpdate DESC, Id ASC;", profile)
+	return arr, err
+}
+
+// ReadDraft reads a draft of the given local user. It gives false if there is no such draft.
+func ReadDraft(profile api.Fingerprint, id string) (DbDraft, bool, error) {
+	arr := []DbDraft{}
+	err := DbInstance.Select(&arr, "SELECT * FROM Drafts WHERE Profile = ? AND Id = ?;", profile, id)
+	if err != nil || len(arr) == 0 {
+		return DbDraft{}, false, err
+	}
+	return arr[0], true, nil
+}
+
+// CountDrafts counts the drafts of the given local user.
+func CountDrafts(profile api.Fingerprint) (int, error) {
+	var count int
+	err := DbInstance.Get(&count, "SELECT count(1) FROM Drafts WHERE Profile = ?;", profile)
+	return count, err
+}
+
+// DeleteDraft deletes a draft of the given local user, and returns how many were deleted.
+func DeleteDraft(profile api.Fingerprint, id string) (int64, error) {
+	res, err := DbInstance.Exec("DELETE FROM Drafts WHERE Profile = ? AND Id = ?;", profile, id)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
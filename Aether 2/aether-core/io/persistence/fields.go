@@ -0,0 +1,57 @@
+// Persistence > Fields
+// This file picks the columns a read selects for the requests that ask for only some of the fields of the entities (see the fields filter in the api package). Only the boards, the threads and the posts are read this way, since their names, bodies and descriptions are most of what a read of them takes; the other entities are small, and are read whole.
+
+package persistence
+
+import (
+	"sort"
+	"strings"
+)
+
+// fieldColumns are the columns of the fields of the entities, by their names in the JSON. The board owners are in a table of their own, and are read with every board.
+var fieldColumns = map[string]map[string]string{
+	"boards": {
+		"fingerprint": "Fingerprint", "creation": "Creation", "proof_of_work": "ProofOfWork", "signature": "Signature",
+		"name": "Name", "description": "Description", "owner": "Owner", "language": "DeclaredLanguage", "meta": "Meta",
+		"last_update": "LastUpdate", "update_proof_of_work": "UpdateProofOfWork", "update_signature": "UpdateSignature",
+	},
+	"threads": {
+		"fingerprint": "Fingerprint", "creation": "Creation", "proof_of_work": "ProofOfWork", "signature": "Signature",
+		"board": "Board", "name": "Name", "body": "Body", "link": "Link", "owner": "Owner", "language": "DeclaredLanguage", "meta": "Meta",
+	},
+	"posts": {
+		"fingerprint": "Fingerprint", "creation": "Creation", "proof_of_work": "ProofOfWork", "signature": "Signature",
+		"board": "Board", "thread": "Thread", "parent": "Parent", "body": "Body", "owner": "Owner", "meta": "Meta",
+	},
+}
+
+// requiredColumns are read whatever the fields are, since the reads and the filters of the responses need them: the owners for the tombstones, the proofs of work for the minimum proof of work, and the boards for the board filter.
+var requiredColumns = map[string][]string{
+	"boards":  []string{"Fingerprint", "Owner", "Creation", "ProofOfWork", "LastUpdate", "UpdateProofOfWork"},
+	"threads": []string{"Fingerprint", "Owner", "Creation", "ProofOfWork", "Board"},
+	"posts":   []string{"Fingerprint", "Owner", "Creation", "ProofOfWork", "Board"},
+}
+
+// selectColumns gives what a read of the entity type selects for the fields. It is all of the columns if no fields are given, or if the entity type is read whole.
+func selectColumns(entityType string, fields []string) string {
+	columns, ok := fieldColumns[entityType]
+	if len(fields) == 0 || !ok {
+		return "*"
+	}
+	selected := make(map[string]bool)
+	for _, c := range requiredColumns[entityType] {
+		selected[c] = true
+	}
+	for _, f := range fields {
+		// The fields the entities don't have select nothing.
+		if c, known := columns[f]; known {
+			selected[c] = true
+		}
+	}
+	var list []string
+	for c, _ := range selected {
+		list = append(list, c)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
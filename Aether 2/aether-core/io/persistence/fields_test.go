@@ -0,0 +1,26 @@
+// This test is in the package itself rather than in persistence_test, since the columns of a read are picked by a function that is not exported, before the read runs.
+
+package persistence
+
+import (
+	"testing"
+)
+
+func TestSelectColumns_Success(t *testing.T) {
+	columns := selectColumns("posts", []string{"fingerprint", "thread", "language"})
+	if columns != "Board, Creation, Fingerprint, Owner, ProofOfWork, Thread" {
+		t.Errorf("The posts should be read with the columns of the fields and the ones the filters need. Columns: %s", columns)
+	}
+	if c := selectColumns("boards", []string{"language"}); c != "Creation, DeclaredLanguage, Fingerprint, LastUpdate, Owner, ProofOfWork, UpdateProofOfWork" {
+		t.Errorf("The language of a board should be read from the language its author declared. Columns: %s", c)
+	}
+}
+
+func TestSelectColumns_Fail_ReadWhole(t *testing.T) {
+	if c := selectColumns("posts", nil); c != "*" {
+		t.Errorf("A read without fields should read all of the columns. Columns: %s", c)
+	}
+	if c := selectColumns("votes", []string{"type"}); c != "*" {
+		t.Errorf("The votes should be read whole. Columns: %s", c)
+	}
+}
@@ -0,0 +1,33 @@
+// This test is in the package itself rather than in persistence_test, since the manifest is cut by a function that is not exported, after the database is read.
+
+package persistence
+
+import (
+	"aether-core/io/api"
+	"testing"
+)
+
+func TestCutFpManifest_Success(t *testing.T) {
+	cases := []struct {
+		arrivals []api.Timestamp
+		limit    int
+		expected int
+	}{
+		// The first one left out arrived in a second of its own.
+		{[]api.Timestamp{100, 101, 102, 103}, 3, 3},
+		// The first one left out arrived in the same second as the last two, which are left out with it.
+		{[]api.Timestamp{100, 101, 101, 101}, 3, 1},
+		{[]api.Timestamp{100, 100, 101, 102, 102}, 4, 3},
+	}
+	for i, c := range cases {
+		if kept := cutFpManifest(c.arrivals, c.limit); kept != c.expected {
+			t.Errorf("The manifest was not cut after the last whole second. Case: %d, Expected: %d, Got: %d", i, c.expected, kept)
+		}
+	}
+}
+
+func TestCutFpManifest_Fail_SameSecond(t *testing.T) {
+	if kept := cutFpManifest([]api.Timestamp{100, 100, 100}, 2); kept != 0 {
+		t.Errorf("A manifest that can't be cut within the second should keep nothing, for the second to be read whole. Got: %d", kept)
+	}
+}
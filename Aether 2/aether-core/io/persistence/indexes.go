@@ -0,0 +1,226 @@
+// Persistence > Indexes
+// This file keeps the indexes that the filters of the reads need. The filters by parent, author, language and time are run in the database, and without an index each of them reads the whole table. The indexes are listed here instead of in the creation schema, so that the databases created by older versions get them too: at the start, the indexes that are missing are created in the background, one at a time, since creating one on a large table can take minutes.
+
+package persistence
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequiredIndex is an index that a filter needs.
+type RequiredIndex struct {
+	Table   string   `json:"table"`
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Reason  string   `json:"reason"` // The reads that need it.
+}
+
+func requiredIndex(table string, reason string, columns ...string) RequiredIndex {
+	return RequiredIndex{Table: table, Name: fmt.Sprint("idx_", strings.ToLower(table), "_", strings.ToLower(strings.Join(columns, "_"))), Columns: columns, Reason: reason}
+}
+
+// requiredIndexes are the indexes the reads need. New ones are appended as new filters are run in the database; an index is never removed from here, since that would leave it on the databases that already have it.
+var requiredIndexes = []RequiredIndex{
+	requiredIndex("Boards", "time filter", "LocalArrival"),
+	requiredIndex("Boards", "author filter", "Owner"),
+	requiredIndex("Boards", "cursor reads", "Creation", "Fingerprint"),
+	requiredIndex("Threads", "time filter", "LocalArrival"),
+	requiredIndex("Threads", "parent filter", "Board"),
+	requiredIndex("Threads", "author filter", "Owner"),
+	requiredIndex("Threads", "language filter", "Language"),
+	requiredIndex("Threads", "cursor reads", "Creation", "Fingerprint"),
+	requiredIndex("Posts", "time filter", "LocalArrival"),
+	requiredIndex("Posts", "parent filter", "Thread"),
+	requiredIndex("Posts", "parent filter", "Parent"),
+	requiredIndex("Posts", "author filter", "Owner"),
+	requiredIndex("Posts", "cursor reads", "Creation", "Fingerprint"),
+	requiredIndex("Votes", "time filter", "LocalArrival"),
+	requiredIndex("Votes", "parent filter", "Target"),
+	requiredIndex("Votes", "author filter", "Owner"),
+	requiredIndex("PublicKeys", "time filter", "LocalArrival"),
+	requiredIndex("Truststates", "time filter", "LocalArrival"),
+	requiredIndex("Truststates", "parent filter", "Target"),
+	requiredIndex("Truststates", "author filter", "Owner"),
+	requiredIndex("Tombstones", "time filter", "LocalArrival"),
+	requiredIndex("Tombstones", "parent filter", "Target"),
+	requiredIndex("Addresses", "time filter", "LocalArrival"),
+}
+
+// IndexState is where a required index is.
+type IndexState struct {
+	RequiredIndex
+	State string `json:"state"` // "present", "missing", "creating", "created" or "failed".
+	Rows  int    `json:"rows,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+var indexStatesLock sync.Mutex
+var indexStates = make(map[string]*IndexState)
+
+func setIndexState(idx RequiredIndex, state string, rows int, err error) {
+	indexStatesLock.Lock()
+	defer indexStatesLock.Unlock()
+	s := &IndexState{RequiredIndex: idx, State: state, Rows: rows}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	indexStates[idx.Name] = s
+}
+
+func indexBeingCreated(idx RequiredIndex) bool {
+	indexStatesLock.Lock()
+	defer indexStatesLock.Unlock()
+	s, ok := indexStates[idx.Name]
+	return ok && s.State == "creating"
+}
+
+// IndexStates gives the state of every required index as of the last check.
+func IndexStates() []IndexState {
+	indexStatesLock.Lock()
+	defer indexStatesLock.Unlock()
+	result := []IndexState{}
+	for _, s := range indexStates {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// existingIndexes gives the columns of every index of the table, in their order in the index.
+func existingIndexes(table string) ([][]string, error) {
+	var rows []struct {
+		Name   string `db:"INDEX_NAME"`
+		Column string `db:"COLUMN_NAME"`
+	}
+	err := DbInstance.Select(&rows, "SELECT INDEX_NAME, COLUMN_NAME FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY INDEX_NAME, SEQ_IN_INDEX;", table)
+	if err != nil {
+		return nil, err
+	}
+	var indexes [][]string
+	last := ""
+	for _, r := range rows {
+		if r.Name != last || len(indexes) == 0 {
+			indexes = append(indexes, []string{})
+			last = r.Name
+		}
+		indexes[len(indexes)-1] = append(indexes[len(indexes)-1], r.Column)
+	}
+	return indexes, nil
+}
+
+// covers checks whether an index starting with the given columns can be used in place of the required one. The index created by an older schema for the same columns counts, whatever its name.
+func covers(existing []string, required []string) bool {
+	if len(existing) < len(required) {
+		return false
+	}
+	for i, _ := range required {
+		if !strings.EqualFold(existing[i], required[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MissingIndexes checks the database for the required indexes, and returns the ones it doesn't have.
+func MissingIndexes() ([]RequiredIndex, error) {
+	var missing []RequiredIndex
+	byTable := make(map[string][][]string)
+	for _, idx := range requiredIndexes {
+		existing, ok := byTable[idx.Table]
+		if !ok {
+			var err error
+			existing, err = existingIndexes(idx.Table)
+			if err != nil {
+				return missing, errors.New(fmt.Sprintf("The indexes of the table could not be read. Table: %s, Error: %s", idx.Table, err))
+			}
+			byTable[idx.Table] = existing
+		}
+		found := false
+		for _, cols := range existing {
+			if covers(cols, idx.Columns) {
+				found = true
+				break
+			}
+		}
+		if found {
+			setIndexState(idx, "present", 0, nil)
+			continue
+		}
+		// One that is being created is still missing, but it is not to be created again.
+		if !indexBeingCreated(idx) {
+			setIndexState(idx, "missing", 0, nil)
+			missing = append(missing, idx)
+		}
+	}
+	return missing, nil
+}
+
+// createIndex creates an index, and logs how it goes while it runs, since on a large table it can take long enough to look stuck.
+func createIndex(idx RequiredIndex) error {
+	var rows int
+	err := DbInstance.Get(&rows, fmt.Sprintf("SELECT count(1) FROM %s;", idx.Table))
+	if err != nil {
+		return err
+	}
+	setIndexState(idx, "creating", rows, nil)
+	start := time.Now()
+	done := make(chan bool)
+	if rows >= globals.IndexLargeTableRows {
+		logging.Log(1, fmt.Sprintf("Creating the index %s on %s, which has %d rows. This can take a while, and the reads that need it are slow until it is done.", idx.Name, idx.Table, rows))
+		go func() {
+			ticker := time.NewTicker(globals.IndexProgressInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					logging.Log(1, fmt.Sprintf("Still creating the index %s on %s. Rows: %d, Elapsed: %s", idx.Name, idx.Table, rows, time.Since(start).Round(time.Second)))
+				}
+			}
+		}()
+	}
+	_, err2 := DbInstance.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (%s);", idx.Name, idx.Table, strings.Join(idx.Columns, ", ")))
+	close(done)
+	if err2 != nil {
+		setIndexState(idx, "failed", rows, err2)
+		return err2
+	}
+	setIndexState(idx, "created", rows, nil)
+	logging.Log(1, fmt.Sprintf("The index %s on %s is created. Rows: %d, Took: %s", idx.Name, idx.Table, rows, time.Since(start).Round(time.Millisecond)))
+	return nil
+}
+
+// EnsureIndexes creates the required indexes that the database doesn't have. It is meant to be run in the background at the start. If creating the indexes is turned off, it only logs which ones are missing, so that the operator can create them when it suits them.
+func EnsureIndexes() {
+	missing, err := MissingIndexes()
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The indexes of the database could not be checked. Error: %s", err))
+		return
+	}
+	if len(missing) == 0 {
+		return
+	}
+	if !globals.CreateMissingIndexes {
+		for _, idx := range missing {
+			logging.Log(1, fmt.Sprintf("An index the %s needs is missing. The reads that need it will be slow. Create it with: CREATE INDEX %s ON %s (%s);", idx.Reason, idx.Name, idx.Table, strings.Join(idx.Columns, ", ")))
+		}
+		return
+	}
+	logging.Log(1, fmt.Sprintf("%d indexes are missing from the database. They will be created in the background.", len(missing)))
+	for i, idx := range missing {
+		logging.Log(2, fmt.Sprintf("Creating index %d of %d: %s on %s (%s).", i+1, len(missing), idx.Name, idx.Table, strings.Join(idx.Columns, ", ")))
+		err := createIndex(idx)
+		if err != nil {
+			// The others can still be created.
+			logging.Log(1, fmt.Sprintf("The index could not be created. Index: %s, Table: %s, Error: %s", idx.Name, idx.Table, err))
+		}
+	}
+}
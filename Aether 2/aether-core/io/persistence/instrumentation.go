@@ -0,0 +1,306 @@
+// Persistence > Instrumentation
+// This file times every query that goes to the database. The database driver is wrapped, so the queries are timed no matter which function of this package runs them. The timings are added up per query, so that the operator can see which queries take the most time on their data and which indexes are missing, and the queries slower than SlowQueryThreshold are logged with their parameters.
+
+package persistence
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLoggedParameterChars is how much of a parameter is logged when the parameters of slow queries are logged. The rest of a long one, such as the body of a post, is left out.
+const maxLoggedParameterChars = 64
+
+// maxTimedQueries is how many different queries the timings are kept for. The queries with fingerprints spelled out in them would otherwise fill the memory.
+const maxTimedQueries = 1000
+
+// QueryTiming is the sum of the timings of a query.
+type QueryTiming struct {
+	Query     string  `json:"query"`
+	Count     int64   `json:"count"`
+	TotalMs   float64 `json:"total_ms"`
+	MeanMs    float64 `json:"mean_ms"`
+	MaxMs     float64 `json:"max_ms"`
+	SlowCount int64   `json:"slow_count"`
+}
+
+var timingsLock sync.Mutex
+var timings = make(map[string]*QueryTiming)
+
+var whitespace = regexp.MustCompile(`\s+`)
+var placeholderList = regexp.MustCompile(`\?(\s*,\s*\?)+`)
+
+// normaliseQuery makes the queries that differ only in their layout or in how many values an IN list has the same, so that they are timed together.
+func normaliseQuery(query string) string {
+	q := strings.TrimSpace(whitespace.ReplaceAllString(query, " "))
+	return placeholderList.ReplaceAllString(q, "?...")
+}
+
+// describeParameters gives the parameters of a query in a form that can be logged. Unless SlowQueryLogParameters is on, only their types and lengths are given, since they can have the content of the users in them.
+func describeParameters(args []driver.NamedValue) string {
+	var descs []string
+	for _, a := range args {
+		var desc string
+		switch v := a.Value.(type) {
+		case string:
+			if !globals.SlowQueryLogParameters {
+				desc = fmt.Sprintf("<string, %d chars>", len(v))
+			} else if len(v) > maxLoggedParameterChars {
+				desc = fmt.Sprintf("%q...", v[:maxLoggedParameterChars])
+			} else {
+				desc = fmt.Sprintf("%q", v)
+			}
+		case []byte:
+			desc = fmt.Sprintf("<bytes, %d>", len(v))
+		default:
+			if globals.SlowQueryLogParameters {
+				desc = fmt.Sprint(v)
+			} else {
+				desc = fmt.Sprintf("<%T>", v)
+			}
+		}
+		descs = append(descs, desc)
+	}
+	return strings.Join(descs, ", ")
+}
+
+// recordQuery adds the duration of a query to its timings, and logs it if it was slow. A slow query run for a request is logged with the trace id of the request, if its context carries one.
+func recordQuery(ctx context.Context, query string, args []driver.NamedValue, d time.Duration) {
+	q := normaliseQuery(query)
+	slow := globals.SlowQueryThreshold > 0 && d >= globals.SlowQueryThreshold
+	ms := float64(d) / float64(time.Millisecond)
+	timingsLock.Lock()
+	t, ok := timings[q]
+	if !ok && len(timings) < maxTimedQueries {
+		t = &QueryTiming{Query: q}
+		timings[q] = t
+		ok = true
+	}
+	if ok {
+		t.Count++
+		t.TotalMs += ms
+		if ms > t.MaxMs {
+			t.MaxMs = ms
+		}
+		if slow {
+			t.SlowCount++
+		}
+	}
+	timingsLock.Unlock()
+	if slow {
+		logging.LogSampledTrace(logging.TraceOf(ctx), "persistence", "slow-query", 1, fmt.Sprintf("Slow query. Duration: %s, Threshold: %s, Query: %s, Parameters: [%s]", d, globals.SlowQueryThreshold, q, describeParameters(args)))
+	}
+}
+
+// QueryTimings gives the timings of the queries run since the start, or since they were reset, the ones that took the most time in total first.
+func QueryTimings() []QueryTiming {
+	timingsLock.Lock()
+	defer timingsLock.Unlock()
+	result := []QueryTiming{}
+	for _, t := range timings {
+		cp := *t
+		cp.MeanMs = cp.TotalMs / float64(cp.Count)
+		result = append(result, cp)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TotalMs != result[j].TotalMs {
+			return result[i].TotalMs > result[j].TotalMs
+		}
+		return result[i].Query < result[j].Query
+	})
+	return result
+}
+
+// ResetQueryTimings forgets the timings, so that the next ones show the effect of a change, such as a new index.
+func ResetQueryTimings() {
+	timingsLock.Lock()
+	defer timingsLock.Unlock()
+	timings = make(map[string]*QueryTiming)
+}
+
+// The wrappers below pass everything to the driver of the database, and time the queries on the way.
+
+type timedDriver struct {
+	driver.Driver
+}
+
+func (d timedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &timedConn{c}, nil
+}
+
+type timedConn struct {
+	driver.Conn
+}
+
+func (c *timedConn) Prepare(query string) (driver.Stmt, error) {
+	s, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &timedStmt{s, query}, nil
+}
+
+func (c *timedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	pc, ok := c.Conn.(driver.ConnPrepareContext)
+	if !ok {
+		return c.Prepare(query)
+	}
+	s, err := pc.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &timedStmt{s, query}, nil
+}
+
+func (c *timedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return bc.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		// The query is prepared and run as a statement instead, and timed there.
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	res, err := ec.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		recordQuery(ctx, query, args, time.Since(start))
+	}
+	return res, err
+}
+
+// QueryContext times a query until its rows are ready to be read. Reading them is not timed.
+func (c *timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := qc.QueryContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		recordQuery(ctx, query, args, time.Since(start))
+	}
+	return rows, err
+}
+
+func (c *timedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *timedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *timedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type timedStmt struct {
+	driver.Stmt
+	query string
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	vals := make([]driver.Value, len(args))
+	for i, _ := range args {
+		vals[i] = args[i].Value
+	}
+	return vals
+}
+
+func (s *timedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var res driver.Result
+	var err error
+	if ec, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err = ec.ExecContext(ctx, args)
+	} else {
+		res, err = s.Stmt.Exec(values(args))
+	}
+	recordQuery(ctx, s.query, args, time.Since(start))
+	return res, err
+}
+
+func (s *timedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	if qc, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = qc.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(values(args))
+	}
+	recordQuery(ctx, s.query, args, time.Since(start))
+	return rows, err
+}
+
+var registerTimedLock sync.Mutex
+
+// mustConnectTimed connects to the database like sqlx.MustConnect does, but through the timed wrapper of the driver. The database keeps the name of the original driver, so that sqlx still writes the queries for it.
+func mustConnectTimed(driverName string, dsn string) *sqlx.DB {
+	dbx, err := connectTimed(driverName, dsn)
+	if err != nil {
+		panic(err)
+	}
+	return dbx
+}
+
+// connectTimed is mustConnectTimed for a database that can be missing, such as the read replica: it gives the error instead of panicking.
+func connectTimed(driverName string, dsn string) (*sqlx.DB, error) {
+	timedName := driverName + "-timed"
+	registerTimedLock.Lock()
+	registered := false
+	for _, name := range sql.Drivers() {
+		if name == timedName {
+			registered = true
+		}
+	}
+	if !registered {
+		plain, err := sql.Open(driverName, dsn)
+		if err != nil {
+			registerTimedLock.Unlock()
+			return nil, err
+		}
+		sql.Register(timedName, timedDriver{plain.Driver()})
+		plain.Close()
+	}
+	registerTimedLock.Unlock()
+	db, err := sql.Open(timedName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	dbx := sqlx.NewDb(db, driverName)
+	err2 := dbx.Ping()
+	if err2 != nil {
+		dbx.Close()
+		return nil, err2
+	}
+	return dbx, nil
+}
@@ -0,0 +1,35 @@
+// This test is in the package itself rather than in persistence_test, since the queries are recorded by a function that is not exported, inside the driver.
+
+package persistence
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordQuery_Success_Traced(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	threshold, level, limit := globals.SlowQueryThreshold, globals.LoggingLevel, globals.LogSampleLimit
+	globals.SlowQueryThreshold, globals.LoggingLevel, globals.LogSampleLimit = time.Millisecond, 1, 0
+	defer func() {
+		globals.SlowQueryThreshold, globals.LoggingLevel, globals.LogSampleLimit = threshold, level, limit
+	}()
+	recordQuery(logging.WithTrace(context.Background(), "trace-slow"), "SELECT * FROM Posts WHERE Fingerprint = ?;", nil, time.Second)
+	if !strings.Contains(out.String(), "[trace:trace-slow] Slow query.") {
+		t.Errorf("A slow query run for a request should be logged with its trace id. Log: %s", out.String())
+	}
+	out.Reset()
+	recordQuery(context.Background(), "SELECT * FROM Threads WHERE Fingerprint = ?;", nil, time.Second)
+	if strings.Contains(out.String(), "[trace:") || !strings.Contains(out.String(), "Slow query.") {
+		t.Errorf("A slow query run for no request should be logged without a trace id. Log: %s", out.String())
+	}
+}
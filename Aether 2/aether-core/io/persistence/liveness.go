@@ -0,0 +1,76 @@
+// Persistence > Liveness
+// This file decays the addresses that are no longer reached. An address is alive as of the last time this node reached it, or, if it never did, as of when it first heard of it. An address that hasn't been alive within the liveness window is demoted: it is left out of what is given to the remotes, so that the dead addresses stop being passed around, but it is kept, and dispatch still tries it after the others. An address that is reached again is alive again. After the purge window it is deleted, and if a remote gives it to us after that, it arrives as a new address.
+
+package persistence
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+)
+
+// addressLivenessClock is when an address was last alive, in SQL. The addresses the remotes gave us have no last online; theirs is zeroed as it can't be trusted.
+const addressLivenessClock = "(CASE WHEN LastOnline > 0 THEN LastOnline ELSE LocalArrival END)"
+
+// daysAgo gives the timestamp the given number of days ago, or 0 if the days are 0, which turns off what it is the cutoff of.
+func daysAgo(days int) api.Timestamp {
+	if days <= 0 {
+		return 0
+	}
+	return api.Timestamp(clock.Now().AddDate(0, 0, -days).Unix())
+}
+
+// AddressLivenessCutoff is the timestamp before which an address that wasn't alive since is demoted. It is 0 if the addresses are not demoted.
+func AddressLivenessCutoff() api.Timestamp {
+	return daysAgo(globals.AddressLivenessDays)
+}
+
+// AddressPurgeCutoff is the timestamp before which an address that wasn't alive since is deleted. It is 0 if the addresses are not deleted.
+func AddressPurgeCutoff() api.Timestamp {
+	return daysAgo(globals.AddressPurgeDays)
+}
+
+// ReadLiveAddresses reads the addresses that arrived within the time range, leaving out the demoted ones. This is what is given to the remotes.
+func ReadLiveAddresses(beginTimestamp api.Timestamp, endTimestamp api.Timestamp) ([]api.Address, error) {
+	var arr []api.Address
+	if endTimestamp == 0 {
+		endTimestamp = api.Timestamp(clock.Unix())
+	}
+	rows, err := rangeQueryx(endTimestamp, "SELECT DISTINCT * from Addresses WHERE (LocalArrival > ? AND LocalArrival < ?) AND "+addressLivenessClock+" >= ?", beginTimestamp, endTimestamp, AddressLivenessCutoff())
+	if err != nil {
+		return arr, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entity DbAddress
+		err = rows.StructScan(&entity)
+		if err != nil {
+			return arr, err
+		}
+		apiEntity, err := DBtoAPI(entity)
+		if err != nil {
+			// Log the problem and go to the next iteration without saving this one.
+			logging.Log(1, err)
+			continue
+		}
+		arr = append(arr, apiEntity.(api.Address))
+	}
+	return arr, nil
+}
+
+// CountDemotedAddresses counts the addresses that weren't alive since the cutoff.
+func CountDemotedAddresses(cutoff api.Timestamp) (int, error) {
+	var count int
+	err := DbInstance.Get(&count, "SELECT count(1) FROM Addresses WHERE "+addressLivenessClock+" < ?;", cutoff)
+	return count, err
+}
+
+// DeleteDeadAddresses deletes the addresses that weren't alive since the cutoff, and returns how many it deleted.
+func DeleteDeadAddresses(cutoff api.Timestamp) (int64, error) {
+	res, err := DbInstance.Exec("DELETE FROM Addresses WHERE "+addressLivenessClock+" < ?;", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
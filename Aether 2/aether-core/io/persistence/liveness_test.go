@@ -0,0 +1,34 @@
+package persistence_test
+
+import (
+	"aether-core/io/api"
+	"aether-core/io/persistence"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"testing"
+	"time"
+)
+
+func TestAddressLivenessCutoff_Success(t *testing.T) {
+	defer func(l int, p int) { globals.AddressLivenessDays, globals.AddressPurgeDays = l, p }(globals.AddressLivenessDays, globals.AddressPurgeDays)
+	now := time.Unix(1600000000, 0)
+	clock.Set(clock.NewMockClock(now))
+	defer clock.Reset()
+	globals.AddressLivenessDays = 14
+	globals.AddressPurgeDays = 90
+	if c := persistence.AddressLivenessCutoff(); c != api.Timestamp(now.AddDate(0, 0, -14).Unix()) {
+		t.Errorf("The addresses should be demoted after the liveness window. Cutoff: %d", c)
+	}
+	if c := persistence.AddressPurgeCutoff(); c != api.Timestamp(now.AddDate(0, 0, -90).Unix()) {
+		t.Errorf("The addresses should be deleted after the purge window. Cutoff: %d", c)
+	}
+}
+
+func TestAddressLivenessCutoff_Fail_Disabled(t *testing.T) {
+	defer func(l int, p int) { globals.AddressLivenessDays, globals.AddressPurgeDays = l, p }(globals.AddressLivenessDays, globals.AddressPurgeDays)
+	globals.AddressLivenessDays = 0
+	globals.AddressPurgeDays = 0
+	if persistence.AddressLivenessCutoff() != 0 || persistence.AddressPurgeCutoff() != 0 {
+		t.Errorf("A window of 0 should demote and delete nothing. Cutoffs: %d, %d", persistence.AddressLivenessCutoff(), persistence.AddressPurgeCutoff())
+	}
+}
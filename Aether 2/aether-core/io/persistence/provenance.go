@@ -0,0 +1,107 @@
+// Persistence > Provenance
+// This file keeps where each entity came from the first time it arrived: the node that delivered it, when, and how, such as in a cache, in a POST response, or submitted by a remote. Only the first delivery is kept; the entities arrive again from other nodes all the time, and those are not recorded. This is for finding the nodes the spam comes from, for scoring the peers, and for following how an entity spread.
+// The provenance is kept in a table of its own rather than in the tables of the entities, since their rows are replaced whole as the entities are updated, and the first delivery would go with them.
+
+package persistence
+
+import (
+	"aether-core/io/api"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Source is where the entities of a batch came from.
+type Source struct {
+	Node api.Fingerprint // The node id the delivery said it was from. Empty for this node.
+	Via  string          // How they arrived, such as "cache", "post", "cursor", "parents", "submission", "bundle" or "local".
+	// The trace id of the sync or the request they arrived with, which the logs of the batch are tagged with. Empty if there is none. It is not recorded.
+	TraceId string
+}
+
+// LocalSource is the source of the entities that didn't come from a remote.
+var LocalSource = Source{Node: "local", Via: "local"}
+
+// Provenance is where an entity came from the first time it arrived.
+type Provenance struct {
+	Fingerprint api.Fingerprint `db:"Fingerprint" json:"fingerprint"`
+	EntityType  string          `db:"EntityType" json:"entity_type"`
+	Node        api.Fingerprint `db:"Node" json:"node"`
+	FirstSeen   api.Timestamp   `db:"FirstSeen" json:"first_seen"`
+	Via         string          `db:"Via" json:"via"`
+}
+
+// provenanceOf gives the provenance of the entity from the source. Addresses have no fingerprints, and have none.
+func provenanceOf(apiObject interface{}, source Source, now api.Timestamp) (Provenance, bool) {
+	p := Provenance{Node: source.Node, FirstSeen: now, Via: source.Via}
+	if len(p.Node) == 0 {
+		p.Node = LocalSource.Node
+	}
+	switch obj := apiObject.(type) {
+	case api.Board:
+		p.Fingerprint, p.EntityType = obj.Fingerprint, "boards"
+	case api.Thread:
+		p.Fingerprint, p.EntityType = obj.Fingerprint, "threads"
+	case api.Post:
+		p.Fingerprint, p.EntityType = obj.Fingerprint, "posts"
+	case api.Vote:
+		p.Fingerprint, p.EntityType = obj.Fingerprint, "votes"
+	case api.Key:
+		p.Fingerprint, p.EntityType = obj.Fingerprint, "keys"
+	case api.Truststate:
+		p.Fingerprint, p.EntityType = obj.Fingerprint, "truststates"
+	case api.Tombstone:
+		p.Fingerprint, p.EntityType = obj.Fingerprint, "tombstones"
+	default:
+		return p, false
+	}
+	return p, len(p.Fingerprint) > 0
+}
+
+// ReadProvenance reads where the given entities came from. The ones with no provenance, such as the ones that arrived before it was kept, are left out.
+func ReadProvenance(fingerprints []api.Fingerprint) ([]Provenance, error) {
+	arr := []Provenance{}
+	if len(fingerprints) == 0 {
+		return arr, nil
+	}
+	query, args, err := sqlx.In("SELECT * FROM Provenance WHERE Fingerprint IN (?);", fingerprints)
+	if err != nil {
+		return arr, err
+	}
+	err2 := DbInstance.Select(&arr, DbInstance.Rebind(query), args...)
+	return arr, err2
+}
+
+// NodeProvenance is how many entities of a type a node was the first to deliver.
+type NodeProvenance struct {
+	Node       api.Fingerprint `db:"Node" json:"node"`
+	EntityType string          `db:"EntityType" json:"entity_type"`
+	Entities   int64           `db:"Entities" json:"entities"`
+}
+
+// ReadProvenanceByNode counts the entities each node was the first to deliver since the given time, per entity type, the nodes that delivered the most first. limit bounds how many counts are given.
+func ReadProvenanceByNode(since api.Timestamp, limit int) ([]NodeProvenance, error) {
+	arr := []NodeProvenance{}
+	if limit <= 0 {
+		return arr, errors.New(fmt.Sprintf("The limit of the provenance counts has to be positive. Limit: %d", limit))
+	}
+	err := DbInstance.Select(&arr, "SELECT Node, EntityType, COUNT(*) AS Entities FROM Provenance WHERE FirstSeen >= ? GROUP BY Node, EntityType ORDER BY Entities DESC, Node ASC, EntityType ASC LIMIT ?;", since, limit)
+	return arr, err
+}
+
+// ReadProvenanceOfNode reads the provenance of the entities the node was the first to deliver since the given time, the latest first.
+func ReadProvenanceOfNode(node api.Fingerprint, since api.Timestamp, limit int) ([]Provenance, error) {
+	arr := []Provenance{}
+	err := DbInstance.Select(&arr, "SELECT * FROM Provenance WHERE Node = ? AND FirstSeen >= ? ORDER BY FirstSeen DESC, Fingerprint ASC LIMIT ?;", node, since, limit)
+	return arr, err
+}
+
+// DeleteProvenanceBefore removes the provenance of the entities first seen before the given time, and gives how many it removed. The entities themselves are kept.
+func DeleteProvenanceBefore(cutoff api.Timestamp) (int64, error) {
+	res, err := DbInstance.Exec("DELETE FROM Provenance WHERE FirstSeen < ?;", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
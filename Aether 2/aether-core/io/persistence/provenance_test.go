@@ -0,0 +1,33 @@
+// This test is in the package itself rather than in persistence_test, since the provenance of an entity is made by a function that is not exported, as the batch is written.
+
+package persistence
+
+import (
+	"aether-core/io/api"
+	"testing"
+)
+
+func TestProvenanceOf_Success(t *testing.T) {
+	var post api.Post
+	post.Fingerprint = "post"
+	p, ok := provenanceOf(post, Source{Node: "remote", Via: "cursor"}, 1600000000)
+	if !ok || p.Fingerprint != "post" || p.EntityType != "posts" || p.Node != "remote" || p.Via != "cursor" || p.FirstSeen != 1600000000 {
+		t.Errorf("The provenance should have the entity and its source. Provenance: %#v", p)
+	}
+	var vote api.Vote
+	vote.Fingerprint = "vote"
+	if p2, ok2 := provenanceOf(vote, Source{Via: "local"}, 1); !ok2 || p2.Node != LocalSource.Node {
+		t.Errorf("The entities with no node in their source should be of this node. Provenance: %#v", p2)
+	}
+}
+
+func TestProvenanceOf_Fail_NoFingerprint(t *testing.T) {
+	var addr api.Address
+	addr.Location = "192.0.2.1"
+	if _, ok := provenanceOf(addr, LocalSource, 1); ok {
+		t.Errorf("The addresses should have no provenance.")
+	}
+	if _, ok := provenanceOf(api.Thread{}, LocalSource, 1); ok {
+		t.Errorf("An entity without a fingerprint should have no provenance.")
+	}
+}
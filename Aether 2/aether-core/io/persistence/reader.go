@@ -5,16 +5,29 @@ package persistence
 
 import (
 	"aether-core/io/api"
+	"aether-core/services/clock"
 	"aether-core/services/globals"
 	"aether-core/services/logging"
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
-	"time"
+	"strings"
 )
 
 // These are utility methods that need to read from the database for miscelleaneous purposes.
 
+// CheckConnection makes sure the database can be reached, and returns its clock, so that the caller can compare it with the local one.
+func CheckConnection() (int64, error) {
+	var dbNow int64
+	err := DbInstance.Get(&dbNow, "SELECT UNIX_TIMESTAMP();")
+	if err != nil {
+		return 0, err
+	}
+	return dbNow, nil
+}
+
 func LocalNodeIsMature() (bool, error) {
 	var nrOfRows int
 	err := DbInstance.Get(&nrOfRows, "SELECT count(1) FROM Nodes;")
@@ -30,6 +43,16 @@ func LocalNodeIsMature() (bool, error) {
 }
 
 // ReadNode provides the ability to seek a specific node.
+// ReadAllNodes reads the sync state of every remote node the local node has synced with.
+func ReadAllNodes() ([]DbNode, error) {
+	var nodes []DbNode
+	err := DbInstance.Select(&nodes, "SELECT * FROM Nodes;")
+	if err != nil {
+		return nodes, err
+	}
+	return nodes, nil
+}
+
 func ReadNode(fingerprint api.Fingerprint) (DbNode, error) {
 	var n DbNode
 	if len(fingerprint) > 0 {
@@ -107,14 +130,38 @@ func sanitiseTimeRange(
 
 // Read is the high level API for DB reads. It provides filtering support. It can return multiple types if requested by the embeds.
 func Read(
-	entityType string, // boards, threads, posts, votes, addresses, keys, truststates
+	entityType string, // boards, threads, posts, votes, addresses, keys, truststates, tombstones
 	fingerprints []api.Fingerprint,
 	embeds []string,
 	beginTimestamp api.Timestamp,
 	endTimestamp api.Timestamp) (api.Response, error) {
+	return ReadFields(entityType, fingerprints, embeds, beginTimestamp, endTimestamp, nil)
+}
+
+// ReadFields is Read, for the requests that ask for only some of the fields of the entities. The boards, the threads and the posts are read with only the columns of those fields, and the ones the filters of the responses need; the other entities are read whole. No fields is all of them. See fields.go.
+func ReadFields(
+	entityType string,
+	fingerprints []api.Fingerprint,
+	embeds []string,
+	beginTimestamp api.Timestamp,
+	endTimestamp api.Timestamp,
+	fields []string) (api.Response, error) {
+	return ReadFieldsContext(context.Background(), entityType, fingerprints, embeds, beginTimestamp, endTimestamp, fields)
+}
+
+// ReadFieldsContext is ReadFields, for the reads run while serving a request. What the reads log, and the slow queries among them, carry the trace id of the context. See logging.WithTrace.
+func ReadFieldsContext(
+	ctx context.Context,
+	entityType string,
+	fingerprints []api.Fingerprint,
+	embeds []string,
+	beginTimestamp api.Timestamp,
+	endTimestamp api.Timestamp,
+	fields []string) (api.Response, error) {
 
 	var result api.Response
-	now := api.Timestamp(time.Now().Unix())
+	columns := selectColumns(entityType, fields)
+	now := api.Timestamp(clock.Unix())
 	// Fingerprints search and start/end timestamp search are mutually exclusive. Make sure that is enforced.
 	err := enforceReadValidity(fingerprints, beginTimestamp, endTimestamp)
 	if err != nil {
@@ -136,7 +183,7 @@ func Read(
 	// Now we switch based on the entity type.
 	switch entityType {
 	case "boards":
-		entities, err := ReadBoards(fingerprints, sanitisedBeginTimestamp, sanitisedEndTimestamp)
+		entities, err := readBoards(ctx, columns, fingerprints, sanitisedBeginTimestamp, sanitisedEndTimestamp)
 		if err != nil {
 			return result, err
 		}
@@ -149,7 +196,11 @@ func Read(
 		}
 
 	case "threads":
-		entities, err := ReadThreads(fingerprints, sanitisedBeginTimestamp, sanitisedEndTimestamp)
+		entities, err := readThreads(ctx, columns, fingerprints, sanitisedBeginTimestamp, sanitisedEndTimestamp)
+		if err != nil {
+			return result, err
+		}
+		entities, err = removeTombstonedThreads(ctx, entities)
 		if err != nil {
 			return result, err
 		}
@@ -160,7 +211,11 @@ func Read(
 			provableArr = append(provableArr, &entities[i])
 		}
 	case "posts":
-		entities, err := ReadPosts(fingerprints, sanitisedBeginTimestamp, sanitisedEndTimestamp)
+		entities, err := readPosts(ctx, columns, fingerprints, sanitisedBeginTimestamp, sanitisedEndTimestamp)
+		if err != nil {
+			return result, err
+		}
+		entities, err = removeTombstonedPosts(ctx, entities)
 		if err != nil {
 			return result, err
 		}
@@ -172,7 +227,7 @@ func Read(
 		}
 
 	case "votes":
-		entities, err := ReadVotes(fingerprints, sanitisedBeginTimestamp, sanitisedEndTimestamp)
+		entities, err := readVotes(ctx, fingerprints, sanitisedBeginTimestamp, sanitisedEndTimestamp)
 		if err != nil {
 			return result, err
 		}
@@ -185,7 +240,7 @@ func Read(
 	case "addresses":
 		return result, errors.New(fmt.Sprint("You tried to supply an address into the high level Read API. This API only provides reads for entities that fulfil the api.Provable interface. Please use ReadAddress directly."))
 	case "keys":
-		entities, err := ReadKeys(fingerprints, sanitisedBeginTimestamp, sanitisedEndTimestamp)
+		entities, err := readKeys(ctx, fingerprints, sanitisedBeginTimestamp, sanitisedEndTimestamp)
 		if err != nil {
 			return result, err
 		}
@@ -196,7 +251,7 @@ func Read(
 			provableArr = append(provableArr, &entities[i])
 		}
 	case "truststates":
-		entities, err := ReadTruststates(fingerprints, sanitisedBeginTimestamp, sanitisedEndTimestamp)
+		entities, err := readTruststates(ctx, fingerprints, sanitisedBeginTimestamp, sanitisedEndTimestamp)
 		if err != nil {
 			return result, err
 		}
@@ -206,9 +261,20 @@ func Read(
 		for i, _ := range entities {
 			provableArr = append(provableArr, &entities[i])
 		}
+	case "tombstones":
+		entities, err := readTombstones(ctx, fingerprints, sanitisedBeginTimestamp, sanitisedEndTimestamp)
+		if err != nil {
+			return result, err
+		}
+		result.Tombstones = entities
+		result.AvailableTypes = append(result.AvailableTypes, "Tombstones")
+		// Convert the result to []api.Provable
+		for i, _ := range entities {
+			provableArr = append(provableArr, &entities[i])
+		}
 	}
 	// We deal with filling the embedded fields. Embed handler has all the code for the different types of embeds.
-	embedErr := handleEmbeds(provableArr, &result, embeds)
+	embedErr := handleEmbeds(ctx, provableArr, &result, embeds)
 	if embedErr != nil {
 		return result, embedErr
 	}
@@ -230,11 +296,15 @@ func existsInEmbed(asked string, embeds []string) bool {
 	return false
 }
 
-func handleEmbeds(entities []api.Provable, result *api.Response, embeds []string) error {
+func handleEmbeds(ctx context.Context, entities []api.Provable, result *api.Response, embeds []string) error {
 	// This holds the results of the first embed so we can add it to the main results before sending it into the keys. See the comment below for context.
 	var firstEmbedCache []api.Provable
 	if existsInEmbed("threads", embeds) {
-		thr, err := ReadThreadEmbed(entities)
+		thr, err := readThreadEmbed(ctx, entities)
+		if err != nil {
+			return err
+		}
+		thr, err = removeTombstonedThreads(ctx, thr)
 		if err != nil {
 			return err
 		}
@@ -247,7 +317,11 @@ func handleEmbeds(entities []api.Provable, result *api.Response, embeds []string
 	}
 
 	if existsInEmbed("posts", embeds) {
-		posts, err := ReadPostEmbed(entities)
+		posts, err := readPostEmbed(ctx, entities)
+		if err != nil {
+			return err
+		}
+		posts, err = removeTombstonedPosts(ctx, posts)
 		if err != nil {
 			return err
 		}
@@ -258,7 +332,7 @@ func handleEmbeds(entities []api.Provable, result *api.Response, embeds []string
 		}
 	}
 	if existsInEmbed("votes", embeds) {
-		votes, err := ReadVoteEmbed(entities)
+		votes, err := readVoteEmbed(ctx, entities)
 		if err != nil {
 			return err
 		}
@@ -274,7 +348,7 @@ func handleEmbeds(entities []api.Provable, result *api.Response, embeds []string
 
 	// But for the time being, let's treat the key as a special case, as if the key are not fully provided, the embeds with more than one layer don't work at all. The embedded objects will not be able to be validated otherwise. The five layer embed thing could be constructed from a series of queries but the absence of keys for the first embed is a serious problem.
 	if existsInEmbed("keys", embeds) {
-		keys, err := ReadKeyEmbed(entities, firstEmbedCache) // <- firstEmbedCache
+		keys, err := readKeyEmbed(ctx, entities, firstEmbedCache) // <- firstEmbedCache
 		if err != nil {
 			return err
 		}
@@ -289,10 +363,15 @@ func handleEmbeds(entities []api.Provable, result *api.Response, embeds []string
 // ReadThreadEmbed gets the threads linked from the entities provided.
 // Only available for: Boards
 func ReadThreadEmbed(entities []api.Provable) ([]api.Thread, error) {
+	return readThreadEmbed(context.Background(), entities)
+}
+
+// readThreadEmbed is ReadThreadEmbed, with the context of the request it reads for.
+func readThreadEmbed(ctx context.Context, entities []api.Provable) ([]api.Thread, error) {
 	var arr []api.Thread
 	var entityFingerprints []api.Fingerprint
 	if len(entities) == 0 {
-		logging.Log(1, fmt.Sprintf("The entities list given to the thread embed is empty."))
+		logging.LogContext(ctx, 1, fmt.Sprintf("The entities list given to the thread embed is empty."))
 		return arr, nil
 	}
 	switch entity := entities[0].(type) {
@@ -307,7 +386,7 @@ func ReadThreadEmbed(entities []api.Provable) ([]api.Thread, error) {
 		if err != nil {
 			return arr, err
 		}
-		rows, err := DbInstance.Queryx(query, args...)
+		rows, err := DbInstance.QueryxContext(ctx, query, args...)
 		if err != nil {
 			return arr, err
 		}
@@ -320,7 +399,7 @@ func ReadThreadEmbed(entities []api.Provable) ([]api.Thread, error) {
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Thread))
@@ -332,10 +411,15 @@ func ReadThreadEmbed(entities []api.Provable) ([]api.Thread, error) {
 // ReadPostEmbed gets the posts linked from the existing entities provided.
 // Only available for: Threads
 func ReadPostEmbed(entities []api.Provable) ([]api.Post, error) {
+	return readPostEmbed(context.Background(), entities)
+}
+
+// readPostEmbed is ReadPostEmbed, with the context of the request it reads for.
+func readPostEmbed(ctx context.Context, entities []api.Provable) ([]api.Post, error) {
 	var arr []api.Post
 	var entityFingerprints []api.Fingerprint
 	if len(entities) == 0 {
-		logging.Log(1, fmt.Sprintf("The entities list given to the post embed is empty."))
+		logging.LogContext(ctx, 1, fmt.Sprintf("The entities list given to the post embed is empty."))
 		return arr, nil
 	}
 	switch entity := entities[0].(type) {
@@ -350,7 +434,7 @@ func ReadPostEmbed(entities []api.Provable) ([]api.Post, error) {
 		if err != nil {
 			return arr, err
 		}
-		rows, err := DbInstance.Queryx(query, args...)
+		rows, err := DbInstance.QueryxContext(ctx, query, args...)
 		if err != nil {
 			return arr, err
 		}
@@ -363,7 +447,7 @@ func ReadPostEmbed(entities []api.Provable) ([]api.Post, error) {
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Post))
@@ -375,10 +459,15 @@ func ReadPostEmbed(entities []api.Provable) ([]api.Post, error) {
 // ReadVoteEmbed gets the votes linking to the entities provided.
 // Only available for: Posts
 func ReadVoteEmbed(entities []api.Provable) ([]api.Vote, error) {
+	return readVoteEmbed(context.Background(), entities)
+}
+
+// readVoteEmbed is ReadVoteEmbed, with the context of the request it reads for.
+func readVoteEmbed(ctx context.Context, entities []api.Provable) ([]api.Vote, error) {
 	var arr []api.Vote
 	var entityFingerprints []api.Fingerprint
 	if len(entities) == 0 {
-		logging.Log(1, fmt.Sprintf("The entities list given to the vote embed is empty."))
+		logging.LogContext(ctx, 1, fmt.Sprintf("The entities list given to the vote embed is empty."))
 		return arr, nil
 	}
 	switch entity := entities[0].(type) {
@@ -393,7 +482,7 @@ func ReadVoteEmbed(entities []api.Provable) ([]api.Vote, error) {
 		if err != nil {
 			return arr, err
 		}
-		rows, err := DbInstance.Queryx(query, args...)
+		rows, err := DbInstance.QueryxContext(ctx, query, args...)
 		if err != nil {
 			return arr, err
 		}
@@ -406,7 +495,7 @@ func ReadVoteEmbed(entities []api.Provable) ([]api.Vote, error) {
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Vote))
@@ -416,12 +505,17 @@ func ReadVoteEmbed(entities []api.Provable) ([]api.Vote, error) {
 }
 
 // ReadKeyEmbed gets the keys linked from the existing entities provided.
-// Only available for: Boards, Threads, Posts, Truststates
+// Only available for: Boards, Threads, Posts, Truststates, Tombstones
 func ReadKeyEmbed(entities []api.Provable, firstEmbedCache []api.Provable) ([]api.Key, error) {
+	return readKeyEmbed(context.Background(), entities, firstEmbedCache)
+}
+
+// readKeyEmbed is ReadKeyEmbed, with the context of the request it reads for.
+func readKeyEmbed(ctx context.Context, entities []api.Provable, firstEmbedCache []api.Provable) ([]api.Key, error) {
 	var arr []api.Key
 	var entityOwners []api.Fingerprint
 	if len(entities) == 0 {
-		logging.Log(1, fmt.Sprintf("The entities list given to the key embed is empty."))
+		logging.LogContext(ctx, 1, fmt.Sprintf("The entities list given to the key embed is empty."))
 		return arr, nil
 	}
 	entities = append(entities, firstEmbedCache...)
@@ -433,7 +527,7 @@ func ReadKeyEmbed(entities []api.Provable, firstEmbedCache []api.Provable) ([]ap
 			for j, _ := range entity.BoardOwners {
 				entityOwners = append(entityOwners, entity.BoardOwners[j].KeyFingerprint)
 			}
-		case *api.Thread, *api.Post, *api.Truststate:
+		case *api.Thread, *api.Post, *api.Truststate, *api.Tombstone:
 			entityOwners = append(entityOwners, entity.GetOwner())
 		}
 	}
@@ -459,7 +553,7 @@ func ReadKeyEmbed(entities []api.Provable, firstEmbedCache []api.Provable) ([]ap
 	if err != nil {
 		return arr, err
 	}
-	rows, err := DbInstance.Queryx(query, args...)
+	rows, err := DbInstance.QueryxContext(ctx, query, args...)
 	if err != nil {
 		return arr, err
 	}
@@ -472,7 +566,7 @@ func ReadKeyEmbed(entities []api.Provable, firstEmbedCache []api.Provable) ([]ap
 		apiEntity, err := DBtoAPI(entity)
 		if err != nil {
 			// Log the problem and go to the next iteration without saving this one.
-			logging.Log(1, err)
+			logging.LogContext(ctx, 1, err)
 			continue
 		}
 		arr = append(arr, apiEntity.(api.Key))
@@ -484,16 +578,26 @@ func ReadKeyEmbed(entities []api.Provable, firstEmbedCache []api.Provable) ([]ap
 
 // ReadBoards reads threads from the database. Even when there is a single result, it will still be arriving in an array to provide a consistent API.
 func ReadBoards(
+	fingerprints []api.Fingerprint,
+	beginTimestamp api.Timestamp,
+	endTimestamp api.Timestamp) ([]api.Board, error) {
+	return readBoards(context.Background(), "*", fingerprints, beginTimestamp, endTimestamp)
+}
+
+// readBoards is ReadBoards with only the given columns, and the context of the request it reads for.
+func readBoards(
+	ctx context.Context,
+	columns string,
 	fingerprints []api.Fingerprint,
 	beginTimestamp api.Timestamp,
 	endTimestamp api.Timestamp) ([]api.Board, error) {
 	var arr []api.Board
 	if len(fingerprints) > 0 { // Fingerprints array search.
-		query, args, err := sqlx.In("SELECT * FROM Boards WHERE Fingerprint IN (?);", fingerprints)
+		query, args, err := sqlx.In(fmt.Sprint("SELECT ", columns, " FROM Boards WHERE Fingerprint IN (?);"), fingerprints)
 		if err != nil {
 			return arr, err
 		}
-		rows, err := DbInstance.Queryx(query, args...)
+		rows, err := DbInstance.QueryxContext(ctx, query, args...)
 		if err != nil {
 			return arr, err
 		}
@@ -506,7 +610,7 @@ func ReadBoards(
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Board))
@@ -514,7 +618,7 @@ func ReadBoards(
 	} else { // Time range search
 		// This should result in:
 		// - Entities that has landed to local after the beginning and before the end
-		rows, err := DbInstance.Queryx("SELECT DISTINCT * from Boards WHERE (LocalArrival > ? AND LocalArrival < ?) ", beginTimestamp, endTimestamp)
+		rows, err := rangeQueryxContext(ctx, endTimestamp, fmt.Sprint("SELECT DISTINCT ", columns, " from Boards WHERE (LocalArrival > ? AND LocalArrival < ?) "), beginTimestamp, endTimestamp)
 		if err != nil {
 			return arr, err
 		}
@@ -527,7 +631,7 @@ func ReadBoards(
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Board))
@@ -538,16 +642,26 @@ func ReadBoards(
 
 // ReadThreads reads threads from the database. Even when there is a single result, it will still be arriving in an array to provide a consistent API.
 func ReadThreads(
+	fingerprints []api.Fingerprint,
+	beginTimestamp api.Timestamp,
+	endTimestamp api.Timestamp) ([]api.Thread, error) {
+	return readThreads(context.Background(), "*", fingerprints, beginTimestamp, endTimestamp)
+}
+
+// readThreads is ReadThreads with only the given columns, and the context of the request it reads for.
+func readThreads(
+	ctx context.Context,
+	columns string,
 	fingerprints []api.Fingerprint,
 	beginTimestamp api.Timestamp,
 	endTimestamp api.Timestamp) ([]api.Thread, error) {
 	var arr []api.Thread
 	if len(fingerprints) > 0 { // Fingerprints array search.
-		query, args, err := sqlx.In("SELECT * FROM Threads WHERE Fingerprint IN (?);", fingerprints)
+		query, args, err := sqlx.In(fmt.Sprint("SELECT ", columns, " FROM Threads WHERE Fingerprint IN (?);"), fingerprints)
 		if err != nil {
 			return arr, err
 		}
-		rows, err := DbInstance.Queryx(query, args...)
+		rows, err := DbInstance.QueryxContext(ctx, query, args...)
 		if err != nil {
 			return arr, err
 		}
@@ -560,7 +674,7 @@ func ReadThreads(
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Thread))
@@ -568,7 +682,7 @@ func ReadThreads(
 	} else { // Time range search
 		// This should result in:
 		// - Entities that has landed to local after the beginning and before the end
-		rows, err := DbInstance.Queryx("SELECT DISTINCT * from Threads WHERE (LocalArrival > ? AND LocalArrival < ?) ", beginTimestamp, endTimestamp)
+		rows, err := rangeQueryxContext(ctx, endTimestamp, fmt.Sprint("SELECT DISTINCT ", columns, " from Threads WHERE (LocalArrival > ? AND LocalArrival < ?) "), beginTimestamp, endTimestamp)
 		if err != nil {
 			return arr, err
 		}
@@ -581,7 +695,7 @@ func ReadThreads(
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Thread))
@@ -616,16 +730,26 @@ func ReadThreads(
 
 // ReadPosts reads posts from the database. Even when there is a single result, it will still be arriving in an array to provide a consistent API.
 func ReadPosts(
+	fingerprints []api.Fingerprint,
+	beginTimestamp api.Timestamp,
+	endTimestamp api.Timestamp) ([]api.Post, error) {
+	return readPosts(context.Background(), "*", fingerprints, beginTimestamp, endTimestamp)
+}
+
+// readPosts is ReadPosts with only the given columns, and the context of the request it reads for.
+func readPosts(
+	ctx context.Context,
+	columns string,
 	fingerprints []api.Fingerprint,
 	beginTimestamp api.Timestamp,
 	endTimestamp api.Timestamp) ([]api.Post, error) {
 	var arr []api.Post
 	if len(fingerprints) > 0 { // Fingerprints array search.
-		query, args, err := sqlx.In("SELECT * FROM Posts WHERE Fingerprint IN (?);", fingerprints)
+		query, args, err := sqlx.In(fmt.Sprint("SELECT ", columns, " FROM Posts WHERE Fingerprint IN (?);"), fingerprints)
 		if err != nil {
 			return arr, err
 		}
-		rows, err := DbInstance.Queryx(query, args...)
+		rows, err := DbInstance.QueryxContext(ctx, query, args...)
 		if err != nil {
 			return arr, err
 		}
@@ -638,7 +762,7 @@ func ReadPosts(
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Post))
@@ -646,7 +770,7 @@ func ReadPosts(
 	} else { // Time range search
 		// This should result in:
 		// - Entities that has landed to local after the beginning and before the end
-		rows, err := DbInstance.Queryx("SELECT DISTINCT * from Posts WHERE (LocalArrival > ? AND LocalArrival < ?) ", beginTimestamp, endTimestamp)
+		rows, err := rangeQueryxContext(ctx, endTimestamp, fmt.Sprint("SELECT DISTINCT ", columns, " from Posts WHERE (LocalArrival > ? AND LocalArrival < ?) "), beginTimestamp, endTimestamp)
 		if err != nil {
 			return arr, err
 		}
@@ -659,7 +783,7 @@ func ReadPosts(
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Post))
@@ -670,6 +794,15 @@ func ReadPosts(
 
 // ReadVotes reads votes from the database. Even when there is a single result, it will still be arriving in an array to provide a consistent API.
 func ReadVotes(
+	fingerprints []api.Fingerprint,
+	beginTimestamp api.Timestamp,
+	endTimestamp api.Timestamp) ([]api.Vote, error) {
+	return readVotes(context.Background(), fingerprints, beginTimestamp, endTimestamp)
+}
+
+// readVotes is ReadVotes, with the context of the request it reads for.
+func readVotes(
+	ctx context.Context,
 	fingerprints []api.Fingerprint,
 	beginTimestamp api.Timestamp,
 	endTimestamp api.Timestamp) ([]api.Vote, error) {
@@ -679,7 +812,7 @@ func ReadVotes(
 		if err != nil {
 			return arr, err
 		}
-		rows, err := DbInstance.Queryx(query, args...)
+		rows, err := DbInstance.QueryxContext(ctx, query, args...)
 		if err != nil {
 			return arr, err
 		}
@@ -692,7 +825,7 @@ func ReadVotes(
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Vote))
@@ -700,7 +833,7 @@ func ReadVotes(
 	} else { // Time range search
 		// This should result in:
 		// - Entities that has landed to local after the beginning and before the end
-		rows, err := DbInstance.Queryx("SELECT DISTINCT * from Votes WHERE (LocalArrival > ? AND LocalArrival < ?) ", beginTimestamp, endTimestamp)
+		rows, err := rangeQueryxContext(ctx, endTimestamp, "SELECT DISTINCT * from Votes WHERE (LocalArrival > ? AND LocalArrival < ?) ", beginTimestamp, endTimestamp)
 		if err != nil {
 			return arr, err
 		}
@@ -713,7 +846,7 @@ func ReadVotes(
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Vote))
@@ -812,9 +945,9 @@ func ReadAddresses(
 		// If the end timestamp is 0, it's assumed that endTs is right now.
 		var endTs api.Timestamp
 		if endTimestamp == 0 {
-			endTs = api.Timestamp(time.Now().Unix())
+			endTs = api.Timestamp(clock.Unix())
 		}
-		rows, err := DbInstance.Queryx("SELECT DISTINCT * from Addresses WHERE (LocalArrival > ? AND LocalArrival < ?) ", beginTimestamp, endTs)
+		rows, err := rangeQueryx(endTs, "SELECT DISTINCT * from Addresses WHERE (LocalArrival > ? AND LocalArrival < ?) ", beginTimestamp, endTs)
 		if err != nil {
 			return arr, err
 		}
@@ -839,6 +972,31 @@ func ReadAddresses(
 	return arr, nil
 }
 
+// ReadPeerCandidates reads the addresses that are eligible to be shared in a peer exchange: the ones this node has connected to (live or static), which were online after the given timestamp, most recently online first. Addresses found on the local network are not shared.
+func ReadPeerCandidates(onlineAfter api.Timestamp, maxResults int) ([]api.Address, error) {
+	var arr []api.Address
+	rows, err := DbInstance.Queryx("SELECT * from Addresses WHERE AddressType IN (2, 255) AND Local = FALSE AND LastOnline > ? ORDER BY LastOnline DESC LIMIT ?", onlineAfter, maxResults)
+	if err != nil {
+		return arr, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entity DbAddress
+		err = rows.StructScan(&entity)
+		if err != nil {
+			return arr, err
+		}
+		apiEntity, err := DBtoAPI(entity)
+		if err != nil {
+			// Log the problem and go to the next iteration without saving this one.
+			logging.Log(1, err)
+			continue
+		}
+		arr = append(arr, apiEntity.(api.Address))
+	}
+	return arr, nil
+}
+
 // func ReadAddresses(Location api.Location,
 // 	Sublocation api.Location, Port uint16) ([]api.Address, error) {
 // 	var arr []api.Address
@@ -865,6 +1023,15 @@ func ReadAddresses(
 
 // ReadKeys reads keys from the database. Even when there is a single result, it will still be arriving in an array to provide a consistent API.
 func ReadKeys(
+	fingerprints []api.Fingerprint,
+	beginTimestamp api.Timestamp,
+	endTimestamp api.Timestamp) ([]api.Key, error) {
+	return readKeys(context.Background(), fingerprints, beginTimestamp, endTimestamp)
+}
+
+// readKeys is ReadKeys, with the context of the request it reads for.
+func readKeys(
+	ctx context.Context,
 	fingerprints []api.Fingerprint,
 	beginTimestamp api.Timestamp,
 	endTimestamp api.Timestamp) ([]api.Key, error) {
@@ -874,7 +1041,7 @@ func ReadKeys(
 		if err != nil {
 			return arr, err
 		}
-		rows, err := DbInstance.Queryx(query, args...)
+		rows, err := DbInstance.QueryxContext(ctx, query, args...)
 		if err != nil {
 			return arr, err
 		}
@@ -887,7 +1054,7 @@ func ReadKeys(
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Key))
@@ -895,7 +1062,7 @@ func ReadKeys(
 	} else { // Time range search
 		// This should result in:
 		// - Entities that has landed to local after the beginning and before the end
-		rows, err := DbInstance.Queryx("SELECT DISTINCT * from PublicKeys WHERE (LocalArrival > ? AND LocalArrival < ?) ", beginTimestamp, endTimestamp)
+		rows, err := rangeQueryxContext(ctx, endTimestamp, "SELECT DISTINCT * from PublicKeys WHERE (LocalArrival > ? AND LocalArrival < ?) ", beginTimestamp, endTimestamp)
 		if err != nil {
 			return arr, err
 		}
@@ -908,7 +1075,7 @@ func ReadKeys(
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Key))
@@ -942,6 +1109,15 @@ func ReadKeys(
 
 // ReadTrustStates reads trust states from the database. Even when there is a single result, it will still be arriving in an array to provide a consistent API.
 func ReadTruststates(
+	fingerprints []api.Fingerprint,
+	beginTimestamp api.Timestamp,
+	endTimestamp api.Timestamp) ([]api.Truststate, error) {
+	return readTruststates(context.Background(), fingerprints, beginTimestamp, endTimestamp)
+}
+
+// readTruststates is ReadTruststates, with the context of the request it reads for.
+func readTruststates(
+	ctx context.Context,
 	fingerprints []api.Fingerprint,
 	beginTimestamp api.Timestamp,
 	endTimestamp api.Timestamp) ([]api.Truststate, error) {
@@ -951,7 +1127,7 @@ func ReadTruststates(
 		if err != nil {
 			return arr, err
 		}
-		rows, err := DbInstance.Queryx(query, args...)
+		rows, err := DbInstance.QueryxContext(ctx, query, args...)
 		if err != nil {
 			return arr, err
 		}
@@ -964,7 +1140,7 @@ func ReadTruststates(
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Truststate))
@@ -972,7 +1148,7 @@ func ReadTruststates(
 	} else { // Time range search
 		// This should result in:
 		// - Entities that has landed to local after the beginning and before the end
-		rows, err := DbInstance.Queryx("SELECT DISTINCT * from Truststates WHERE (LocalArrival > ? AND LocalArrival < ?) ", beginTimestamp, endTimestamp)
+		rows, err := rangeQueryxContext(ctx, endTimestamp, "SELECT DISTINCT * from Truststates WHERE (LocalArrival > ? AND LocalArrival < ?) ", beginTimestamp, endTimestamp)
 		if err != nil {
 			return arr, err
 		}
@@ -985,7 +1161,7 @@ func ReadTruststates(
 			apiEntity, err := DBtoAPI(entity)
 			if err != nil {
 				// Log the problem and go to the next iteration without saving this one.
-				logging.Log(1, err)
+				logging.LogContext(ctx, 1, err)
 				continue
 			}
 			arr = append(arr, apiEntity.(api.Truststate))
@@ -1018,8 +1194,961 @@ func ReadTruststates(
 // 	return arr, nil
 // }
 
+// ReadTombstones reads tombstones from the database. Even when there is a single result, it will still be arriving in an array to provide a consistent API.
+func ReadTombstones(
+	fingerprints []api.Fingerprint,
+	beginTimestamp api.Timestamp,
+	endTimestamp api.Timestamp) ([]api.Tombstone, error) {
+	return readTombstones(context.Background(), fingerprints, beginTimestamp, endTimestamp)
+}
+
+// readTombstones is ReadTombstones, with the context of the request it reads for.
+func readTombstones(
+	ctx context.Context,
+	fingerprints []api.Fingerprint,
+	beginTimestamp api.Timestamp,
+	endTimestamp api.Timestamp) ([]api.Tombstone, error) {
+	var arr []api.Tombstone
+	if len(fingerprints) > 0 { // Fingerprints array search.
+		query, args, err := sqlx.In("SELECT * FROM Tombstones WHERE Fingerprint IN (?);", fingerprints)
+		if err != nil {
+			return arr, err
+		}
+		rows, err := DbInstance.QueryxContext(ctx, query, args...)
+		if err != nil {
+			return arr, err
+		}
+		for rows.Next() {
+			var entity DbTombstone
+			err = rows.StructScan(&entity)
+			if err != nil {
+				return arr, err
+			}
+			apiEntity, err := DBtoAPI(entity)
+			if err != nil {
+				// Log the problem and go to the next iteration without saving this one.
+				logging.LogContext(ctx, 1, err)
+				continue
+			}
+			arr = append(arr, apiEntity.(api.Tombstone))
+		}
+	} else { // Time range search
+		// This should result in:
+		// - Entities that has landed to local after the beginning and before the end
+		rows, err := rangeQueryxContext(ctx, endTimestamp, "SELECT DISTINCT * from Tombstones WHERE (LocalArrival > ? AND LocalArrival < ?) ", beginTimestamp, endTimestamp)
+		if err != nil {
+			return arr, err
+		}
+		for rows.Next() {
+			var entity DbTombstone
+			err = rows.StructScan(&entity)
+			if err != nil {
+				return arr, err
+			}
+			apiEntity, err := DBtoAPI(entity)
+			if err != nil {
+				// Log the problem and go to the next iteration without saving this one.
+				logging.LogContext(ctx, 1, err)
+				continue
+			}
+			arr = append(arr, apiEntity.(api.Tombstone))
+		}
+	}
+	return arr, nil
+}
+
+// tombstonedTargets returns the set of fingerprints among the given ones that have a tombstone from their owner. The owners are given in the same order as the fingerprints.
+func tombstonedTargets(ctx context.Context, targetType string, fingerprints []api.Fingerprint, owners []api.Fingerprint) (map[api.Fingerprint]bool, error) {
+	result := make(map[api.Fingerprint]bool)
+	if len(fingerprints) == 0 {
+		return result, nil
+	}
+	owned := make(map[api.Fingerprint]api.Fingerprint)
+	for i, _ := range fingerprints {
+		owned[fingerprints[i]] = owners[i]
+	}
+	query, args, err := sqlx.In("SELECT * FROM Tombstones WHERE TargetType = ? AND Target IN (?);", targetType, fingerprints)
+	if err != nil {
+		return result, err
+	}
+	rows, err := DbInstance.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entity DbTombstone
+		err = rows.StructScan(&entity)
+		if err != nil {
+			return result, err
+		}
+		if len(entity.Owner) > 0 && owned[entity.Target] == entity.Owner {
+			result[entity.Target] = true
+		}
+	}
+	return result, nil
+}
+
+// removeTombstonedThreads removes the threads that were deleted by their owners. Their bodies are already hidden in the database, so serving them would only provide entities that fail verification at the remote.
+func removeTombstonedThreads(ctx context.Context, threads []api.Thread) ([]api.Thread, error) {
+	var fps []api.Fingerprint
+	var owners []api.Fingerprint
+	for i, _ := range threads {
+		fps = append(fps, threads[i].Fingerprint)
+		owners = append(owners, threads[i].Owner)
+	}
+	tombstoned, err := tombstonedTargets(ctx, "threads", fps, owners)
+	if err != nil {
+		return threads, err
+	}
+	var cleaned []api.Thread
+	for i, _ := range threads {
+		if !tombstoned[threads[i].Fingerprint] {
+			cleaned = append(cleaned, threads[i])
+		}
+	}
+	return cleaned, nil
+}
+
+// removeTombstonedPosts removes the posts that were deleted by their owners.
+func removeTombstonedPosts(ctx context.Context, posts []api.Post) ([]api.Post, error) {
+	var fps []api.Fingerprint
+	var owners []api.Fingerprint
+	for i, _ := range posts {
+		fps = append(fps, posts[i].Fingerprint)
+		owners = append(owners, posts[i].Owner)
+	}
+	tombstoned, err := tombstonedTargets(ctx, "posts", fps, owners)
+	if err != nil {
+		return posts, err
+	}
+	var cleaned []api.Post
+	for i, _ := range posts {
+		if !tombstoned[posts[i].Fingerprint] {
+			cleaned = append(cleaned, posts[i])
+		}
+	}
+	return cleaned, nil
+}
+
+// Paged reads. These push the pagination down to the database, so that a large time range can be served page by page without reading all of it into memory first.
+
+// PagePlan is the result of planning a paged read. The time range in it is already sanitised, and it has to be given as is to every ReadPage call, so that all pages are cut from the same range.
+type PagePlan struct {
+	EntityType string
+	Begin      api.Timestamp
+	End        api.Timestamp
+	Count      int
+	PageSize   int
+	Pages      int
+}
+
+// entityTables maps the entity types to their tables.
+var entityTables = map[string]string{
+	"boards":      "Boards",
+	"threads":     "Threads",
+	"posts":       "Posts",
+	"votes":       "Votes",
+	"keys":        "PublicKeys",
+	"truststates": "Truststates",
+	"tombstones":  "Tombstones",
+}
+
+// CountAddresses counts the addresses that arrived within the time range, after sanitising the range the same way Read does, leaving out the demoted ones as ReadLiveAddresses does. Addresses are not in entityTables as they can't be read in pages, but the cache generation plan still needs to know how many there are.
+func CountAddresses(beginTimestamp api.Timestamp, endTimestamp api.Timestamp) (int, error) {
+	begin, end, err := sanitiseTimeRange(beginTimestamp, endTimestamp, api.Timestamp(clock.Unix()))
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err2 := rangeGet(end, &count, "SELECT count(1) FROM Addresses WHERE (LocalArrival > ? AND LocalArrival < ?) AND "+addressLivenessClock+" >= ?;", begin, end, AddressLivenessCutoff())
+	if err2 != nil {
+		return 0, err2
+	}
+	return count, nil
+}
+
+// ReadArrivalsInRange gives when the entities of the type that arrived within the time range arrived, by their fingerprints. The range is taken as it is given, without the sanitising Read does, and the entities are not read, only when they arrived; the live cache reads them with Read over the same range.
+func ReadArrivalsInRange(entityType string, beginTimestamp api.Timestamp, endTimestamp api.Timestamp) (map[api.Fingerprint]api.Timestamp, error) {
+	arrivals := make(map[api.Fingerprint]api.Timestamp)
+	table, ok := entityTables[entityType]
+	if !ok {
+		return arrivals, errors.New(fmt.Sprintf("Arrivals are not available for this entity type. Entity type: %s", entityType))
+	}
+	rows, err := DbInstance.Query(fmt.Sprintf("SELECT Fingerprint, LocalArrival FROM %s WHERE (LocalArrival > ? AND LocalArrival < ?);", table), beginTimestamp, endTimestamp)
+	if err != nil {
+		return arrivals, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var fp api.Fingerprint
+		var arrival api.Timestamp
+		err2 := rows.Scan(&fp, &arrival)
+		if err2 != nil {
+			return arrivals, err2
+		}
+		arrivals[fp] = arrival
+	}
+	return arrivals, rows.Err()
+}
+
+// PlanPages sanitises the time range the same way Read does, and counts how many entities and pages it holds.
+func PlanPages(entityType string, beginTimestamp api.Timestamp, endTimestamp api.Timestamp, pageSize int) (PagePlan, error) {
+	return PlanPagesContext(context.Background(), entityType, beginTimestamp, endTimestamp, pageSize)
+}
+
+// PlanPagesContext is PlanPages, for the plans made while serving a request, as ReadFieldsContext.
+func PlanPagesContext(ctx context.Context, entityType string, beginTimestamp api.Timestamp, endTimestamp api.Timestamp, pageSize int) (PagePlan, error) {
+	now := api.Timestamp(clock.Unix())
+	begin, end, err := sanitiseTimeRange(beginTimestamp, endTimestamp, now)
+	if err != nil {
+		return PagePlan{}, err
+	}
+	return planRange(ctx, entityType, begin, end, pageSize)
+}
+
+// PlanRange is PlanPages for a time range that is taken as-is, as ReadInRange takes it, such as the range of a cache. An end of 0 means now.
+func PlanRange(entityType string, beginTimestamp api.Timestamp, endTimestamp api.Timestamp, pageSize int) (PagePlan, error) {
+	return planRange(context.Background(), entityType, beginTimestamp, endTimestamp, pageSize)
+}
+
+func planRange(ctx context.Context, entityType string, beginTimestamp api.Timestamp, endTimestamp api.Timestamp, pageSize int) (PagePlan, error) {
+	var plan PagePlan
+	table, ok := entityTables[entityType]
+	if !ok {
+		return plan, errors.New(fmt.Sprintf("Paged reads are not available for this entity type. Entity type: %s", entityType))
+	}
+	if pageSize <= 0 {
+		return plan, errors.New(fmt.Sprintf("The page size has to be positive. Page size: %d", pageSize))
+	}
+	if endTimestamp == 0 {
+		endTimestamp = api.Timestamp(clock.Unix())
+	}
+	var count int
+	// The tombstoned entities are left out in the query, as in ReadPageRange, so that the count is the count of what the pages hold.
+	err := rangeGetContext(ctx, endTimestamp, &count, fmt.Sprintf("SELECT count(1) FROM %s WHERE (LocalArrival > ? AND LocalArrival < ?)%s;", table, tombstoneExclusions[entityType]), beginTimestamp, endTimestamp)
+	if err != nil {
+		return plan, err
+	}
+	plan.EntityType = entityType
+	plan.Begin = beginTimestamp
+	plan.End = endTimestamp
+	plan.Count = count
+	plan.PageSize = pageSize
+	plan.Pages = (count + pageSize - 1) / pageSize
+	if plan.Pages == 0 {
+		// An empty result is still one (empty) page.
+		plan.Pages = 1
+	}
+	return plan, nil
+}
+
+// ReadPage reads a single page of a plan. Pages are cut in (LocalArrival, Fingerprint) order, so that the same page number gives the same entities as long as nothing new arrives into the range.
+func ReadPage(plan PagePlan, page int) (api.Response, error) {
+	if page < 0 || page >= plan.Pages {
+		return api.Response{}, errors.New(fmt.Sprintf("The page is out of the range of this plan. Page: %d, Pages: %d", page, plan.Pages))
+	}
+	return ReadPageRange(plan, page*plan.PageSize, plan.PageSize)
+}
+
+// ReadPageRange reads limit entities of a plan, starting from the given position. This is how the pages that are not cut at the page size, such as the ones cut by a page byte budget, are read. The positions are the ones of ReadIndexes and ReadInRange, as the tombstoned entities are left out in the query.
+func ReadPageRange(plan PagePlan, offset int, limit int) (api.Response, error) {
+	return ReadPageRangeContext(context.Background(), plan, offset, limit)
+}
+
+// ReadPageRangeContext is ReadPageRange, for the pages read while serving a request, as ReadFieldsContext.
+func ReadPageRangeContext(ctx context.Context, plan PagePlan, offset int, limit int) (api.Response, error) {
+	var result api.Response
+	table, ok := entityTables[plan.EntityType]
+	if !ok {
+		return result, errors.New(fmt.Sprintf("Paged reads are not available for this entity type. Entity type: %s", plan.EntityType))
+	}
+	if offset < 0 || limit < 0 {
+		return result, errors.New(fmt.Sprintf("The range of the page is invalid. Offset: %d, Limit: %d", offset, limit))
+	}
+	query := fmt.Sprintf("SELECT * FROM %s WHERE (LocalArrival > ? AND LocalArrival < ?)%s ORDER BY LocalArrival ASC, Fingerprint ASC LIMIT ? OFFSET ?;", table, tombstoneExclusions[plan.EntityType])
+	rows, err := rangeQueryxContext(ctx, plan.End, query, plan.Begin, plan.End, limit, offset)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	result, _, _, _, err = scanPagedRows(ctx, rows, plan.EntityType)
+	return result, err
+}
+
+// ReadArrival gives when the entity arrived at this node, which is where a cursor that points right after it starts.
+func ReadArrival(entityType string, fp api.Fingerprint) (api.Timestamp, error) {
+	table, ok := entityTables[entityType]
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("Arrivals are not available for this entity type. Entity type: %s", entityType))
+	}
+	var arrival api.Timestamp
+	err := DbInstance.Get(&arrival, fmt.Sprintf("SELECT LocalArrival FROM %s WHERE Fingerprint = ?;", table), fp)
+	return arrival, err
+}
+
+// ReadInRange reads all entities of a type that arrived within the time range. Unlike Read, the range is taken as-is instead of being moved to after the last cache, which is what regenerating an already cached range needs.
+func ReadInRange(entityType string, beginTimestamp api.Timestamp, endTimestamp api.Timestamp) (api.Response, error) {
+	var result api.Response
+	table, ok := entityTables[entityType]
+	if !ok {
+		return result, errors.New(fmt.Sprintf("Range reads are not available for this entity type. Entity type: %s", entityType))
+	}
+	if endTimestamp == 0 {
+		endTimestamp = api.Timestamp(clock.Unix())
+	}
+	// Tombstoned entities are left out in the query rather than after it, so that the positions here match the ones in ReadIndexes.
+	query := fmt.Sprintf("SELECT * FROM %s WHERE (LocalArrival > ? AND LocalArrival < ?)%s ORDER BY LocalArrival ASC, Fingerprint ASC;", table, tombstoneExclusions[entityType])
+	rows, err := rangeQueryx(endTimestamp, query, beginTimestamp, endTimestamp)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	result, _, _, _, err = scanPagedRows(context.Background(), rows, entityType)
+	return result, err
+}
+
+// tombstoneExclusions are the conditions that leave the tombstoned entities out of a query, for the entity types that can be tombstoned.
+var tombstoneExclusions = map[string]string{
+	"threads": " AND NOT EXISTS (SELECT 1 FROM Tombstones WHERE Tombstones.Target = Threads.Fingerprint AND Tombstones.Owner = Threads.Owner AND Tombstones.TargetType = 'threads' AND Tombstones.Owner != '')",
+	"posts":   " AND NOT EXISTS (SELECT 1 FROM Tombstones WHERE Tombstones.Target = Posts.Fingerprint AND Tombstones.Owner = Posts.Owner AND Tombstones.TargetType = 'posts' AND Tombstones.Owner != '')",
+}
+
+// indexColumns are the only columns the index of each entity type needs.
+var indexColumns = map[string]string{
+	"boards":      "Fingerprint, Creation, LastUpdate",
+	"threads":     "Fingerprint, Board, Creation",
+	"posts":       "Fingerprint, Board, Thread, Creation",
+	"votes":       "Fingerprint, Board, Thread, Target, Creation, LastUpdate",
+	"keys":        "Fingerprint, Creation, LastUpdate",
+	"truststates": "Fingerprint, Target, Creation, LastUpdate",
+	"tombstones":  "Fingerprint, Target, Creation",
+}
+
+// ReadIndexes creates the indexes of the entities that arrived within the time range, reading only the columns the indexes need instead of the full entities. The indexes are in the same order and have the same tombstone exclusions as ReadInRange and ReadPageRange, so the index at every position is the one of the entity at that position there. The page numbers are left to the caller, since only it knows where the pages are cut.
+func ReadIndexes(entityType string, beginTimestamp api.Timestamp, endTimestamp api.Timestamp) (api.Response, error) {
+	var result api.Response
+	table, ok := entityTables[entityType]
+	if !ok {
+		return result, errors.New(fmt.Sprintf("Index reads are not available for this entity type. Entity type: %s", entityType))
+	}
+	if endTimestamp == 0 {
+		endTimestamp = api.Timestamp(clock.Unix())
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE (LocalArrival > ? AND LocalArrival < ?)%s ORDER BY LocalArrival ASC, Fingerprint ASC;", indexColumns[entityType], table, tombstoneExclusions[entityType])
+	rows, err := rangeQuery(endTimestamp, query, beginTimestamp, endTimestamp)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		switch entityType {
+		case "boards":
+			var idx api.BoardIndex
+			err = rows.Scan(&idx.Fingerprint, &idx.Creation, &idx.LastUpdate)
+			result.BoardIndexes = append(result.BoardIndexes, idx)
+		case "threads":
+			var idx api.ThreadIndex
+			err = rows.Scan(&idx.Fingerprint, &idx.Board, &idx.Creation)
+			result.ThreadIndexes = append(result.ThreadIndexes, idx)
+		case "posts":
+			var idx api.PostIndex
+			err = rows.Scan(&idx.Fingerprint, &idx.Board, &idx.Thread, &idx.Creation)
+			result.PostIndexes = append(result.PostIndexes, idx)
+		case "votes":
+			var idx api.VoteIndex
+			err = rows.Scan(&idx.Fingerprint, &idx.Board, &idx.Thread, &idx.Target, &idx.Creation, &idx.LastUpdate)
+			result.VoteIndexes = append(result.VoteIndexes, idx)
+		case "keys":
+			var idx api.KeyIndex
+			err = rows.Scan(&idx.Fingerprint, &idx.Creation, &idx.LastUpdate)
+			result.KeyIndexes = append(result.KeyIndexes, idx)
+		case "truststates":
+			var idx api.TruststateIndex
+			err = rows.Scan(&idx.Fingerprint, &idx.Target, &idx.Creation, &idx.LastUpdate)
+			result.TruststateIndexes = append(result.TruststateIndexes, idx)
+		case "tombstones":
+			var idx api.TombstoneIndex
+			err = rows.Scan(&idx.Fingerprint, &idx.Target, &idx.Creation)
+			result.TombstoneIndexes = append(result.TombstoneIndexes, idx)
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, rows.Err()
+}
+
+// lastUpdateColumns are the columns that give when the entities of each entity type were last updated. The entities that can't be updated give their creation.
+var lastUpdateColumns = map[string]string{
+	"boards":      "LastUpdate",
+	"threads":     "Creation",
+	"posts":       "Creation",
+	"votes":       "LastUpdate",
+	"keys":        "LastUpdate",
+	"truststates": "LastUpdate",
+	"tombstones":  "Creation",
+}
+
+// ReadFpManifest reads the fingerprints and the last updates of the entities that arrived within the time range, in the order and with the tombstone exclusions of ReadIndexes, and like it, only the columns it needs. At most limit entities are read. If more arrived, the manifest is cut after the last second of arrivals it has whole, so that the rest can be asked for from where it ends without missing any.
+func ReadFpManifest(entityType string, beginTimestamp api.Timestamp, endTimestamp api.Timestamp, limit int) (api.FpManifest, error) {
+	result := api.FpManifest{EntityType: entityType, StartsFrom: beginTimestamp, Entries: []api.FpEntry{}}
+	table, ok := entityTables[entityType]
+	if !ok {
+		return result, errors.New(fmt.Sprintf("Fingerprint manifests are not available for this entity type. Entity type: %s", entityType))
+	}
+	if limit <= 0 {
+		return result, errors.New(fmt.Sprintf("The limit has to be positive. Limit: %d", limit))
+	}
+	if endTimestamp == 0 {
+		endTimestamp = api.Timestamp(clock.Unix())
+	}
+	result.EndsAt = endTimestamp
+	query := fmt.Sprintf("SELECT Fingerprint, %s, LocalArrival FROM %s WHERE (LocalArrival > ? AND LocalArrival < ?)%s ORDER BY LocalArrival ASC, Fingerprint ASC LIMIT ?;", lastUpdateColumns[entityType], table, tombstoneExclusions[entityType])
+	// One more than the limit is read, to know whether there are more.
+	entries, arrivals, err := readFpEntries(endTimestamp, query, beginTimestamp, endTimestamp, limit+1)
+	if err != nil || len(entries) <= limit {
+		result.Entries = entries
+		return result, err
+	}
+	kept := cutFpManifest(arrivals, limit)
+	if kept == 0 {
+		// More than the limit arrived in the same second. That second is given whole, since the manifest can't be cut within it.
+		query2 := fmt.Sprintf("SELECT Fingerprint, %s, LocalArrival FROM %s WHERE LocalArrival = ?%s ORDER BY Fingerprint ASC;", lastUpdateColumns[entityType], table, tombstoneExclusions[entityType])
+		entries, arrivals, err = readFpEntries(endTimestamp, query2, arrivals[0])
+		if err != nil {
+			return result, err
+		}
+		kept = len(entries)
+	}
+	result.Entries = entries[:kept]
+	result.EndsAt = arrivals[kept-1]
+	result.Truncated = true
+	return result, nil
+}
+
+// cutFpManifest gives how many of the entries, read in the order of their arrivals, go into a manifest of at most limit entries that has every second of arrivals in it whole. There is one more arrival than the limit, the first one left out. It gives 0 if they all arrived in the same second.
+func cutFpManifest(arrivals []api.Timestamp, limit int) int {
+	kept := limit
+	for kept > 0 && arrivals[kept-1] == arrivals[limit] {
+		kept--
+	}
+	return kept
+}
+
+func readFpEntries(end api.Timestamp, query string, args ...interface{}) ([]api.FpEntry, []api.Timestamp, error) {
+	entries := []api.FpEntry{}
+	var arrivals []api.Timestamp
+	rows, err := rangeQuery(end, query, args...)
+	if err != nil {
+		return entries, arrivals, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e api.FpEntry
+		var arrival api.Timestamp
+		err2 := rows.Scan(&e.Fingerprint, &e.LastUpdate, &arrival)
+		if err2 != nil {
+			return entries, arrivals, err2
+		}
+		entries = append(entries, e)
+		arrivals = append(arrivals, arrival)
+	}
+	return entries, arrivals, rows.Err()
+}
+
+// ReadPageAfterCursor reads the page that comes after the given cursor, in (LocalArrival, Fingerprint) order. Unlike the numbered pages of ReadPage, a cursor keeps pointing at the same place when new entities arrive, so an iteration can be resumed at any time. It returns the cursor of the last entity read, which is where the next page starts. When the page is not full, nothing is left after it, and the returned fingerprint is empty.
+func ReadPageAfterCursor(entityType string, beginTimestamp api.Timestamp, endTimestamp api.Timestamp, afterArrival api.Timestamp, afterFp api.Fingerprint, pageSize int) (api.Response, api.Timestamp, api.Fingerprint, error) {
+	return ReadPageAfterCursorContext(context.Background(), entityType, beginTimestamp, endTimestamp, afterArrival, afterFp, pageSize)
+}
+
+// ReadPageAfterCursorContext is ReadPageAfterCursor, for the pages read while serving a request, as ReadFieldsContext.
+func ReadPageAfterCursorContext(ctx context.Context, entityType string, beginTimestamp api.Timestamp, endTimestamp api.Timestamp, afterArrival api.Timestamp, afterFp api.Fingerprint, pageSize int) (api.Response, api.Timestamp, api.Fingerprint, error) {
+	var result api.Response
+	table, ok := entityTables[entityType]
+	if !ok {
+		return result, 0, "", errors.New(fmt.Sprintf("Paged reads are not available for this entity type. Entity type: %s", entityType))
+	}
+	if pageSize <= 0 {
+		return result, 0, "", errors.New(fmt.Sprintf("The page size has to be positive. Page size: %d", pageSize))
+	}
+	begin, end, err := sanitiseTimeRange(beginTimestamp, endTimestamp, api.Timestamp(clock.Unix()))
+	if err != nil {
+		return result, 0, "", err
+	}
+	query := fmt.Sprintf("SELECT * FROM %s WHERE (LocalArrival > ? AND LocalArrival < ?) AND (LocalArrival > ? OR (LocalArrival = ? AND Fingerprint > ?)) ORDER BY LocalArrival ASC, Fingerprint ASC LIMIT ?;", table)
+	rows, err := rangeQueryxContext(ctx, end, query, begin, end, afterArrival, afterArrival, afterFp, pageSize)
+	if err != nil {
+		return result, 0, "", err
+	}
+	defer rows.Close()
+	result, lastArrival, lastFp, count, err2 := scanPagedRows(ctx, rows, entityType)
+	if err2 != nil || count < pageSize {
+		// A short page is the last one, so that the remote doesn't ask for an empty page after it.
+		return result, 0, "", err2
+	}
+	return result, lastArrival, lastFp, nil
+}
+
+// scanPagedRows converts the rows of a paged read into a response. It also returns the LocalArrival and the Fingerprint of the last row, even if that row is later dropped, so that a cursor can move past it, and the number of rows read. Tombstoned threads and posts are removed after the page is cut, so those pages can come out shorter than the page size.
+func scanPagedRows(ctx context.Context, rows *sqlx.Rows, entityType string) (api.Response, api.Timestamp, api.Fingerprint, int, error) {
+	var result api.Response
+	var lastArrival api.Timestamp
+	var lastFp api.Fingerprint
+	var count int
+	var err error
+	for rows.Next() {
+		count++
+		var dbEntity interface{}
+		var scanErr error
+		switch entityType {
+		case "boards":
+			var entity DbBoard
+			scanErr = rows.StructScan(&entity)
+			dbEntity, lastArrival, lastFp = entity, entity.LocalArrival, entity.Fingerprint
+		case "threads":
+			var entity DbThread
+			scanErr = rows.StructScan(&entity)
+			dbEntity, lastArrival, lastFp = entity, entity.LocalArrival, entity.Fingerprint
+		case "posts":
+			var entity DbPost
+			scanErr = rows.StructScan(&entity)
+			dbEntity, lastArrival, lastFp = entity, entity.LocalArrival, entity.Fingerprint
+		case "votes":
+			var entity DbVote
+			scanErr = rows.StructScan(&entity)
+			dbEntity, lastArrival, lastFp = entity, entity.LocalArrival, entity.Fingerprint
+		case "keys":
+			var entity DbKey
+			scanErr = rows.StructScan(&entity)
+			dbEntity, lastArrival, lastFp = entity, entity.LocalArrival, entity.Fingerprint
+		case "truststates":
+			var entity DbTruststate
+			scanErr = rows.StructScan(&entity)
+			dbEntity, lastArrival, lastFp = entity, entity.LocalArrival, entity.Fingerprint
+		case "tombstones":
+			var entity DbTombstone
+			scanErr = rows.StructScan(&entity)
+			dbEntity, lastArrival, lastFp = entity, entity.LocalArrival, entity.Fingerprint
+		}
+		if scanErr != nil {
+			return result, lastArrival, lastFp, count, scanErr
+		}
+		apiEntity, err := DBtoAPI(dbEntity)
+		if err != nil {
+			// Log the problem and go to the next iteration without saving this one.
+			logging.LogContext(ctx, 1, err)
+			continue
+		}
+		switch e := apiEntity.(type) {
+		case api.Board:
+			result.Boards = append(result.Boards, e)
+		case api.Thread:
+			result.Threads = append(result.Threads, e)
+		case api.Post:
+			result.Posts = append(result.Posts, e)
+		case api.Vote:
+			result.Votes = append(result.Votes, e)
+		case api.Key:
+			result.Keys = append(result.Keys, e)
+		case api.Truststate:
+			result.Truststates = append(result.Truststates, e)
+		case api.Tombstone:
+			result.Tombstones = append(result.Tombstones, e)
+		}
+	}
+	if len(result.Threads) > 0 {
+		result.Threads, err = removeTombstonedThreads(ctx, result.Threads)
+		if err != nil {
+			return result, lastArrival, lastFp, count, err
+		}
+	}
+	if len(result.Posts) > 0 {
+		result.Posts, err = removeTombstonedPosts(ctx, result.Posts)
+		if err != nil {
+			return result, lastArrival, lastFp, count, err
+		}
+	}
+	return result, lastArrival, lastFp, count, nil
+}
+
+// Cursor reads. These are used by the public API, which pages through the entities newest first. A cursor is the creation timestamp and the fingerprint of the last entity of the prior page; a zero creation means the first page. Tombstoned entities are excluded in the query, so that the pages stay full.
+
+// cursorClause provides the WHERE fragment that continues after the given cursor, in (Creation DESC, Fingerprint DESC) order.
+func cursorClause(table string, afterCreation api.Timestamp, afterFp api.Fingerprint) (string, []interface{}) {
+	if afterCreation == 0 {
+		return "", []interface{}{}
+	}
+	return fmt.Sprintf(" AND (%s.Creation < ? OR (%s.Creation = ? AND %s.Fingerprint < ?))", table, table, table),
+		[]interface{}{afterCreation, afterCreation, afterFp}
+}
+
+// containsPattern gives the LIKE pattern that matches the text containing the search as it is. The wildcards and the escape character in the search are escaped, so the queries using it need ESCAPE '\\'.
+func containsPattern(search string) string {
+	escaped := strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(search)
+	return fmt.Sprint("%", escaped, "%")
+}
+
+// ReadBoardsAfterCursor reads a page of boards.
+func ReadBoardsAfterCursor(afterCreation api.Timestamp, afterFp api.Fingerprint, limit int) ([]api.Board, error) {
+	var arr []api.Board
+	clause, args := cursorClause("Boards", afterCreation, afterFp)
+	args = append(args, limit)
+	rows, err := DbInstance.Queryx(fmt.Sprint("SELECT * FROM Boards WHERE 1=1", clause, " ORDER BY Creation DESC, Fingerprint DESC LIMIT ?;"), args...)
+	if err != nil {
+		return arr, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entity DbBoard
+		err = rows.StructScan(&entity)
+		if err != nil {
+			return arr, err
+		}
+		apiEntity, err := DBtoAPI(entity)
+		if err != nil {
+			// Log the problem and go to the next iteration without saving this one.
+			logging.Log(1, err)
+			continue
+		}
+		arr = append(arr, apiEntity.(api.Board))
+	}
+	return arr, nil
+}
+
+// ReadThreadsAfterCursor reads a page of threads. If board is given, only the threads of that board are read. If search is given, only the threads whose name or body contain it are read.
+func ReadThreadsAfterCursor(board api.Fingerprint, search string, afterCreation api.Timestamp, afterFp api.Fingerprint, limit int) ([]api.Thread, error) {
+	var arr []api.Thread
+	query := "SELECT * FROM Threads WHERE NOT EXISTS (SELECT 1 FROM Tombstones WHERE Tombstones.Target = Threads.Fingerprint AND Tombstones.Owner = Threads.Owner AND Tombstones.TargetType = 'threads' AND Tombstones.Owner != '')"
+	var args []interface{}
+	if len(board) > 0 {
+		query = fmt.Sprint(query, " AND Threads.Board = ?")
+		args = append(args, board)
+	}
+	if len(search) > 0 {
+		query = fmt.Sprint(query, ` AND (Threads.Name LIKE ? ESCAPE '\\' OR Threads.Body LIKE ? ESCAPE '\\')`)
+		args = append(args, containsPattern(search), containsPattern(search))
+	}
+	clause, cursorArgs := cursorClause("Threads", afterCreation, afterFp)
+	args = append(args, cursorArgs...)
+	args = append(args, limit)
+	rows, err := DbInstance.Queryx(fmt.Sprint(query, clause, " ORDER BY Threads.Creation DESC, Threads.Fingerprint DESC LIMIT ?;"), args...)
+	if err != nil {
+		return arr, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entity DbThread
+		err = rows.StructScan(&entity)
+		if err != nil {
+			return arr, err
+		}
+		apiEntity, err := DBtoAPI(entity)
+		if err != nil {
+			// Log the problem and go to the next iteration without saving this one.
+			logging.Log(1, err)
+			continue
+		}
+		arr = append(arr, apiEntity.(api.Thread))
+	}
+	return arr, nil
+}
+
+// ReadPostsAfterCursor reads a page of posts. If thread is given, only the posts of that thread are read. If search is given, only the posts whose body contain it are read.
+func ReadPostsAfterCursor(thread api.Fingerprint, search string, afterCreation api.Timestamp, afterFp api.Fingerprint, limit int) ([]api.Post, error) {
+	var arr []api.Post
+	query := "SELECT * FROM Posts WHERE NOT EXISTS (SELECT 1 FROM Tombstones WHERE Tombstones.Target = Posts.Fingerprint AND Tombstones.Owner = Posts.Owner AND Tombstones.TargetType = 'posts' AND Tombstones.Owner != '')"
+	var args []interface{}
+	if len(thread) > 0 {
+		query = fmt.Sprint(query, " AND Posts.Thread = ?")
+		args = append(args, thread)
+	}
+	if len(search) > 0 {
+		query = fmt.Sprint(query, ` AND Posts.Body LIKE ? ESCAPE '\\'`)
+		args = append(args, containsPattern(search))
+	}
+	clause, cursorArgs := cursorClause("Posts", afterCreation, afterFp)
+	args = append(args, cursorArgs...)
+	args = append(args, limit)
+	rows, err := DbInstance.Queryx(fmt.Sprint(query, clause, " ORDER BY Posts.Creation DESC, Posts.Fingerprint DESC LIMIT ?;"), args...)
+	if err != nil {
+		return arr, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entity DbPost
+		err = rows.StructScan(&entity)
+		if err != nil {
+			return arr, err
+		}
+		apiEntity, err := DBtoAPI(entity)
+		if err != nil {
+			// Log the problem and go to the next iteration without saving this one.
+			logging.Log(1, err)
+			continue
+		}
+		arr = append(arr, apiEntity.(api.Post))
+	}
+	return arr, nil
+}
+
 // The Reader functions that return DB instances, rather than API ones.
 
+// ReadNotifications reads the notifications of the local user, newest first. If onlyUnseen is set, only the notifications that were not marked as seen are returned.
+func ReadNotifications(onlyUnseen bool) ([]DbNotification, error) {
+	var arr []DbNotification
+	query := "SELECT * FROM Notifications ORDER BY Creation DESC;"
+	if onlyUnseen {
+		query = "SELECT * FROM Notifications WHERE Seen = FALSE ORDER BY Creation DESC;"
+	}
+	rows, err := DbInstance.Queryx(query)
+	if err != nil {
+		return arr, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n DbNotification
+		err = rows.StructScan(&n)
+		if err != nil {
+			return arr, err
+		}
+		arr = append(arr, n)
+	}
+	return arr, nil
+}
+
+// ReadVoteSummaries reads the summaries of the compacted votes. If targets are given, only the summaries of those are read.
+func ReadVoteSummaries(targets []api.Fingerprint) ([]DbVoteSummary, error) {
+	var arr []DbVoteSummary
+	var err error
+	if len(targets) == 0 {
+		err = DbInstance.Select(&arr, "SELECT * FROM VoteSummaries;")
+		return arr, err
+	}
+	query, args, err2 := sqlx.In("SELECT * FROM VoteSummaries WHERE Target IN (?);", targets)
+	if err2 != nil {
+		return arr, err2
+	}
+	err = DbInstance.Select(&arr, DbInstance.Rebind(query), args...)
+	return arr, err
+}
+
+// ReadThreadActivity counts the votes of the given types on the thread, and the replies in it. The scores themselves are left empty. If the thread hasn't arrived yet, the second return value is false.
+func ReadThreadActivity(thread api.Fingerprint, upvoteType uint8, downvoteType uint8) (DbThreadScore, bool, error) {
+	var ts DbThreadScore
+	err := DbInstance.Get(&ts, "SELECT Fingerprint AS Thread, Board, Creation, 0 AS Upvotes, 0 AS Downvotes, 0 AS Replies, 0 AS LastReply, 0 AS Hot, 0 AS Top FROM Threads WHERE Fingerprint = ?;", thread)
+	if err == sql.ErrNoRows {
+		return ts, false, nil
+	}
+	if err != nil {
+		return ts, false, err
+	}
+	err2 := DbInstance.QueryRowx("SELECT COUNT(*), COALESCE(MAX(Creation), 0) FROM Posts WHERE Thread = ?;", thread).Scan(&ts.Replies, &ts.LastReply)
+	if err2 != nil {
+		return ts, false, err2
+	}
+	votes := `SELECT
+    (SELECT COUNT(*) FROM Votes WHERE Target = ? AND Type = ?) +
+    (SELECT COALESCE(SUM(Count), 0) FROM VoteSummaries WHERE Target = ? AND Type = ?);`
+	err3 := DbInstance.Get(&ts.Upvotes, votes, thread, upvoteType, thread, upvoteType)
+	if err3 != nil {
+		return ts, false, err3
+	}
+	err4 := DbInstance.Get(&ts.Downvotes, votes, thread, downvoteType, thread, downvoteType)
+	if err4 != nil {
+		return ts, false, err4
+	}
+	return ts, true, nil
+}
+
+// ReadThreadFingerprints reads the fingerprints of all the threads.
+func ReadThreadFingerprints() ([]api.Fingerprint, error) {
+	var arr []api.Fingerprint
+	err := DbInstance.Select(&arr, "SELECT Fingerprint FROM Threads;")
+	return arr, err
+}
+
+// CountThreadScores counts the threads that have a score.
+func CountThreadScores() (int, error) {
+	var count int
+	err := DbInstance.Get(&count, "SELECT count(1) FROM ThreadScores;")
+	return count, err
+}
+
+// ReadThreadScoresAfterCursor reads a page of the scores of the threads in a board, from the highest to the lowest of the given column: "Hot", "Top" or "Creation". The page starts after the thread with the given fingerprint and value of that column; if afterValue is nil, it starts from the beginning. Threads deleted by their owners are left out.
+func ReadThreadScoresAfterCursor(board api.Fingerprint, column string, afterValue interface{}, afterFp api.Fingerprint, limit int) ([]DbThreadScore, error) {
+	var arr []DbThreadScore
+	if column != "Hot" && column != "Top" && column != "Creation" {
+		return arr, errors.New(fmt.Sprintf("Thread scores can't be ordered by this column. Column: %s", column))
+	}
+	query := "SELECT ThreadScores.* FROM ThreadScores INNER JOIN Threads ON Threads.Fingerprint = ThreadScores.Thread WHERE ThreadScores.Board = ? AND NOT EXISTS (SELECT 1 FROM Tombstones WHERE Tombstones.Target = Threads.Fingerprint AND Tombstones.Owner = Threads.Owner AND Tombstones.TargetType = 'threads' AND Tombstones.Owner != '')"
+	args := []interface{}{board}
+	if afterValue != nil {
+		query = fmt.Sprintf("%s AND (ThreadScores.%s < ? OR (ThreadScores.%s = ? AND ThreadScores.Thread < ?))", query, column, column)
+		args = append(args, afterValue, afterValue, afterFp)
+	}
+	args = append(args, limit)
+	err := DbInstance.Select(&arr, fmt.Sprintf("%s ORDER BY ThreadScores.%s DESC, ThreadScores.Thread DESC LIMIT ?;", query, column), args...)
+	return arr, err
+}
+
+// ReadReplyPaths reads the places of the given posts in the reply trees.
+func ReadReplyPaths(posts []api.Fingerprint) (map[api.Fingerprint]DbReplyPath, error) {
+	result := make(map[api.Fingerprint]DbReplyPath)
+	if len(posts) == 0 {
+		return result, nil
+	}
+	var arr []DbReplyPath
+	query, args, err := sqlx.In("SELECT * FROM ReplyPaths WHERE Post IN (?);", posts)
+	if err != nil {
+		return result, err
+	}
+	err2 := DbInstance.Select(&arr, query, args...)
+	for i, _ := range arr {
+		result[arr[i].Post] = arr[i]
+	}
+	return result, err2
+}
+
+// ReadDetachedReplies reads the posts that arrived before their parents, the given posts, and were put at the top of detached subtrees of their own until they did.
+func ReadDetachedReplies(parents []api.Fingerprint) ([]DbReplyPath, error) {
+	var arr []DbReplyPath
+	if len(parents) == 0 {
+		return arr, nil
+	}
+	query, args, err := sqlx.In("SELECT * FROM ReplyPaths WHERE Parent IN (?) AND Detached = TRUE AND Depth = 1;", parents)
+	if err != nil {
+		return arr, err
+	}
+	err2 := DbInstance.Select(&arr, query, args...)
+	return arr, err2
+}
+
+// ReadReplySubtree reads a page of a subtree of the reply tree of a thread, depth first: the posts whose paths start with the given prefix and come after afterPath, down to maxDepth. The detached posts are left out.
+func ReadReplySubtree(thread api.Fingerprint, prefix string, afterPath string, maxDepth int, limit int) ([]DbReplyPath, error) {
+	var arr []DbReplyPath
+	err := DbInstance.Select(&arr, "SELECT * FROM ReplyPaths WHERE Thread = ? AND Detached = FALSE AND Path LIKE ? AND Path > ? AND Depth <= ? ORDER BY Path ASC LIMIT ?;", thread, fmt.Sprint(prefix, "%"), afterPath, maxDepth, limit)
+	return arr, err
+}
+
+// CountReplies counts the direct replies to each of the given posts.
+func CountReplies(parents []api.Fingerprint) (map[api.Fingerprint]int, error) {
+	result := make(map[api.Fingerprint]int)
+	if len(parents) == 0 {
+		return result, nil
+	}
+	query, args, err := sqlx.In("SELECT Parent, count(1) FROM ReplyPaths WHERE Parent IN (?) GROUP BY Parent;", parents)
+	if err != nil {
+		return result, err
+	}
+	rows, err2 := DbInstance.Query(query, args...)
+	if err2 != nil {
+		return result, err2
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var parent api.Fingerprint
+		var count int
+		err3 := rows.Scan(&parent, &count)
+		if err3 != nil {
+			return result, err3
+		}
+		result[parent] = count
+	}
+	return result, rows.Err()
+}
+
+// CountReplyPaths counts the posts that have a place in a reply tree.
+func CountReplyPaths() (int, error) {
+	var count int
+	err := DbInstance.Get(&count, "SELECT count(1) FROM ReplyPaths;")
+	return count, err
+}
+
+// ReadPostLinksAfterCursor reads a page of the posts, with only what their place in the reply tree needs, oldest first, after the post with the given creation and fingerprint.
+func ReadPostLinksAfterCursor(afterCreation api.Timestamp, afterFp api.Fingerprint, limit int) ([]api.Post, error) {
+	var arr []api.Post
+	rows, err := DbInstance.Queryx("SELECT Fingerprint, Thread, Parent, Creation FROM Posts WHERE Creation > ? OR (Creation = ? AND Fingerprint > ?) ORDER BY Creation ASC, Fingerprint ASC LIMIT ?;", afterCreation, afterCreation, afterFp, limit)
+	if err != nil {
+		return arr, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p api.Post
+		err2 := rows.Scan(&p.Fingerprint, &p.Thread, &p.Parent, &p.Creation)
+		if err2 != nil {
+			return arr, err2
+		}
+		arr = append(arr, p)
+	}
+	return arr, rows.Err()
+}
+
+// ReadContentFilters reads the content filters of the given local user, oldest first.
+func ReadContentFilters(profile api.Fingerprint) ([]DbContentFilter, error) {
+	var arr []DbContentFilter
+	err := DbInstance.Select(&arr, "SELECT * FROM ContentFilters WHERE Profile = ? ORDER BY Creation ASC;", profile)
+	return arr, err
+}
+
+// ReadSyncBookmarks reads the bookmarks of a remote, by entity type.
+func ReadSyncBookmarks(node api.Fingerprint) (map[string]DbSyncBookmark, error) {
+	var arr []DbSyncBookmark
+	err := DbInstance.Select(&arr, "SELECT * FROM SyncBookmarks WHERE Node = ?;", node)
+	bookmarks := make(map[string]DbSyncBookmark)
+	for i, _ := range arr {
+		bookmarks[arr[i].EntityType] = arr[i]
+	}
+	return bookmarks, err
+}
+
+// ReadResponsePage reads a page of a published multipart POST response. It gives false if there is no such page, or its response isn't published or has expired.
+func ReadResponsePage(response string, page int, now int64) ([]byte, bool, error) {
+	var data []byte
+	err := DbInstance.Get(&data, "SELECT Data FROM ResponsePages WHERE Response = ? AND Page = ? AND Published = TRUE AND Expiry >= ?;", response, page, now)
+	if err == sql.ErrNoRows {
+		return data, false, nil
+	}
+	return data, err == nil, err
+}
+
+// ResponsePagesUsage is how much the multipart POST responses in the database take.
+type ResponsePagesUsage struct {
+	Responses int   `db:"Responses" json:"responses"`
+	Pages     int   `db:"Pages" json:"pages"`
+	Bytes     int64 `db:"Bytes" json:"bytes"`
+}
+
+// ReadResponsePagesUsage gives how many responses and pages are in the database, and their bytes, the ones still being written and the expired ones not yet removed included.
+func ReadResponsePagesUsage() (ResponsePagesUsage, error) {
+	var u ResponsePagesUsage
+	err := DbInstance.Get(&u, "SELECT count(DISTINCT Response) AS Responses, count(1) AS Pages, COALESCE(SUM(Bytes), 0) AS Bytes FROM ResponsePages;")
+	return u, err
+}
+
+// ImportedItemExists checks whether the feed item with the given key was already imported.
+func ImportedItemExists(itemKey string) (bool, error) {
+	var count int
+	err := DbInstance.Get(&count, "SELECT count(1) FROM ImportedItems WHERE ItemKey = ?;", itemKey)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// ReadKeyFingerprint gives the fingerprint of the key entity of the public key, the earliest one if there are several, and false if there is none.
+func ReadKeyFingerprint(publicKey string) (api.Fingerprint, bool, error) {
+	var fp api.Fingerprint
+	err := DbInstance.Get(&fp, "SELECT Fingerprint FROM PublicKeys WHERE PublicKey = ? ORDER BY Creation ASC, Fingerprint ASC LIMIT 1;", publicKey)
+	if err == sql.ErrNoRows {
+		return fp, false, nil
+	}
+	if err != nil {
+		return fp, false, err
+	}
+	return fp, true, nil
+}
+
 // ReadDBCurrencyAddresses reads currency addresses from the database. Even when there is a single result, it will still be arriving in an array to provide a consistent API.
 
 // This is left as a single-select, not multiple, because it already supports returning multiple entities, and there is no demand for these to be fetched in bulk.
@@ -1093,3 +2222,36 @@ func ReadDBBoardOwners(BoardFingerprint api.Fingerprint,
 	}
 	return arr, nil
 }
+
+// ReadLanguages reads the languages the boards or the threads with the given fingerprints are filtered by. The entities with no known language are left out of the result.
+func ReadLanguages(entityType string, fingerprints []api.Fingerprint) (map[api.Fingerprint]string, error) {
+	result := make(map[api.Fingerprint]string)
+	if len(fingerprints) == 0 {
+		return result, nil
+	}
+	var table string
+	switch entityType {
+	case "boards":
+		table = "Boards"
+	case "threads":
+		table = "Threads"
+	default:
+		return result, errors.New(fmt.Sprintf("This entity type has no language. Entity type: %s", entityType))
+	}
+	query, args, err := sqlx.In(fmt.Sprintf("SELECT Fingerprint, Language FROM %s WHERE Fingerprint IN (?) AND Language != '';", table), fingerprints)
+	if err != nil {
+		return result, err
+	}
+	var arr []struct {
+		Fingerprint api.Fingerprint `db:"Fingerprint"`
+		Language    string          `db:"Language"`
+	}
+	err2 := DbInstance.Select(&arr, query, args...)
+	if err2 != nil {
+		return result, err2
+	}
+	for i, _ := range arr {
+		result[arr[i].Fingerprint] = arr[i].Language
+	}
+	return result, nil
+}
@@ -0,0 +1,113 @@
+// Persistence > Read Markers
+// This file keeps how far the local users read each thread, and counts what is unread after it. A marker is the creation and the fingerprint of the last post read in the thread; the posts after it, in the order of their creation, and then of their fingerprints, are unread. The posts of the user are never unread, and every post of a thread without a marker is.
+
+package persistence
+
+import (
+	"aether-core/io/api"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DbReadMarker is how far a local user read a thread.
+type DbReadMarker struct {
+	Profile      api.Fingerprint `db:"Profile"` // Key fingerprint of the local user the marker belongs to.
+	Thread       api.Fingerprint `db:"Thread"`
+	Board        api.Fingerprint `db:"Board"`
+	LastRead     api.Timestamp   `db:"LastRead"`     // The creation of the last post read.
+	LastReadPost api.Fingerprint `db:"LastReadPost"` // The last post read. Empty if the thread was read up to a time rather than to a post.
+	LastUpdate   api.Timestamp   `db:"LastUpdate"`
+}
+
+// DbUnreadCount is how many posts are unread in a thread, or in a board.
+type DbUnreadCount struct {
+	Board  api.Fingerprint `db:"Board"`
+	Thread api.Fingerprint `db:"Thread"` // Empty for the count of a board.
+	Unread int             `db:"Unread"`
+}
+
+// unreadCondition is what makes a post p unread against the marker m, which is joined with a LEFT JOIN, so that it is null when the thread has no marker. Its argument is the profile, for the posts of the user.
+const unreadCondition = `p.Owner <> ? AND (m.Thread IS NULL OR p.Creation > m.LastRead OR (p.Creation = m.LastRead AND p.Fingerprint > m.LastReadPost))`
+
+// InsertReadMarker saves a read marker, replacing the one of the same thread.
+func InsertReadMarker(m DbReadMarker) error {
+	if m.Thread == "" {
+		return errors.New(fmt.Sprintf("This read marker has one or more empty primary key(s). Read marker: %#v\n", m))
+	}
+	_, err := DbInstance.NamedExec(readMarkerInsert, m)
+	return err
+}
+
+// ReadReadMarker reads the marker of a thread of the given local user. It gives false if the user has not read the thread.
+func ReadReadMarker(profile api.Fingerprint, thread api.Fingerprint) (DbReadMarker, bool, error) {
+	arr := []DbReadMarker{}
+	err := DbInstance.Select(&arr, "SELECT * FROM ReadMarkers WHERE Profile = ? AND Thread = ?;", profile, thread)
+	if err != nil || len(arr) == 0 {
+		return DbReadMarker{}, false, err
+	}
+	return arr[0], true, nil
+}
+
+// ReadReadMarkers reads all the markers of the given local user, the ones updated most recently first.
+func ReadReadMarkers(profile api.Fingerprint) ([]DbReadMarker, error) {
+	arr := []DbReadMarker{}
+	err := DbInstance.Select(&arr, "SELECT * FROM ReadMarkers WHERE Profile = ? ORDER BY LastUpdate DESC, Thread ASC;", profile)
+	return arr, err
+}
+
+// DeleteReadMarker deletes the marker of a thread of the given local user, which makes all of the thread unread.
+func DeleteReadMarker(profile api.Fingerprint, thread api.Fingerprint) error {
+	_, err := DbInstance.Exec("DELETE FROM ReadMarkers WHERE Profile = ? AND Thread = ?;", profile, thread)
+	return err
+}
+
+// CountUnreadInThreads counts the unread posts of the given local user in each thread of the board. The threads with nothing unread are left out.
+func CountUnreadInThreads(profile api.Fingerprint, board api.Fingerprint) ([]DbUnreadCount, error) {
+	arr := []DbUnreadCount{}
+	err := DbInstance.Select(&arr, fmt.Sprintf(`SELECT p.Board AS Board, p.Thread AS Thread, COUNT(1) AS Unread
+    FROM Posts p LEFT JOIN ReadMarkers m ON m.Profile = ? AND m.Thread = p.Thread
+    WHERE p.Thread IN (SELECT Fingerprint FROM Threads WHERE Board = ?) AND %s
+    GROUP BY p.Board, p.Thread;`, unreadCondition), profile, board, profile)
+	return arr, err
+}
+
+// CountUnreadInThread counts the unread posts of the given local user in the thread.
+func CountUnreadInThread(profile api.Fingerprint, thread api.Fingerprint) (int, error) {
+	var count int
+	err := DbInstance.Get(&count, fmt.Sprintf(`SELECT COUNT(1)
+    FROM Posts p LEFT JOIN ReadMarkers m ON m.Profile = ? AND m.Thread = p.Thread
+    WHERE p.Thread = ? AND %s;`, unreadCondition), profile, thread, profile)
+	return count, err
+}
+
+// CountUnreadInBoards counts the unread posts of the given local user in each of the boards. The boards with nothing unread are left out.
+func CountUnreadInBoards(profile api.Fingerprint, boards []api.Fingerprint) ([]DbUnreadCount, error) {
+	arr := []DbUnreadCount{}
+	if len(boards) == 0 {
+		return arr, nil
+	}
+	query, args, err := sqlx.In(fmt.Sprintf(`SELECT t.Board AS Board, COUNT(1) AS Unread
+    FROM Posts p JOIN Threads t ON t.Fingerprint = p.Thread LEFT JOIN ReadMarkers m ON m.Profile = ? AND m.Thread = p.Thread
+    WHERE t.Board IN (?) AND %s
+    GROUP BY t.Board;`, unreadCondition), profile, boards, profile)
+	if err != nil {
+		return arr, err
+	}
+	err2 := DbInstance.Select(&arr, query, args...)
+	return arr, err2
+}
+
+// ReadFirstUnread reads the fingerprint of the first unread post of the given local user in the thread. It gives false if nothing is unread.
+func ReadFirstUnread(profile api.Fingerprint, thread api.Fingerprint) (api.Fingerprint, bool, error) {
+	arr := []api.Fingerprint{}
+	err := DbInstance.Select(&arr, fmt.Sprintf(`SELECT p.Fingerprint
+    FROM Posts p LEFT JOIN ReadMarkers m ON m.Profile = ? AND m.Thread = p.Thread
+    WHERE p.Thread = ? AND %s
+    ORDER BY p.Creation ASC, p.Fingerprint ASC LIMIT 1;`, unreadCondition), profile, thread, profile)
+	if err != nil || len(arr) == 0 {
+		return "", false, err
+	}
+	return arr[0], true, nil
+}
@@ -0,0 +1,182 @@
+// Persistence > Replica
+// This file sends the reads of time ranges, the ones the remotes ask for in their syncs and the cache generation makes, to a read-only replica of the database if one is given, so that a hub node under a heavy query load doesn't make its ingest transactions wait. The writes, and the reads of entities by their fingerprints, which the writes depend on, always go to the primary.
+// How far behind the replica is, is measured with a heartbeat: the primary writes the time into the ReplicaHeartbeat table at every check, and the replica is read back to see which of those writes it has. The replica is used only while it is reachable and no more than ReplicaMaxLag behind. Even then, a read whose range reaches past the last heartbeat the replica has goes to the primary: the remotes take the end of the range as where to continue from, and an entity the replica didn't have yet would never be asked for again.
+
+package persistence
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"os"
+	"sync"
+	"time"
+)
+
+// replicaHorizonMargin is how long before its last heartbeat the replica is trusted to have all the entities from. An entity gets its arrival time before its transaction commits, so one that arrived just before a heartbeat can commit just after it.
+const replicaHorizonMargin = 60
+
+// ReplicaStatus is the state of the read replica as of the last check.
+type ReplicaStatus struct {
+	Configured bool   `json:"configured"`
+	InUse      bool   `json:"in_use"`
+	Lag        int64  `json:"lag"`     // In seconds. -1 if not known.
+	Horizon    int64  `json:"horizon"` // The reads of the ranges that end before this go to the replica.
+	LastCheck  int64  `json:"last_check"`
+	Reason     string `json:"reason,omitempty"` // Why the reads don't go to the replica.
+}
+
+var replicaLock sync.Mutex
+var replicaDb *sqlx.DB
+var replicaStatus = ReplicaStatus{Lag: -1}
+var lastHeartbeat int64 // The last heartbeat written into the primary.
+
+// ReplicaConfigured checks whether a read replica is given.
+func ReplicaConfigured() bool {
+	return len(os.Getenv(globals.DatabaseReplicaEnv)) > 0
+}
+
+// GetReplicaStatus gives the state of the read replica as of the last check.
+func GetReplicaStatus() ReplicaStatus {
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+	st := replicaStatus
+	st.Configured = ReplicaConfigured()
+	return st
+}
+
+// setReplicaInUse records whether the replica is used, and logs when that changes.
+func setReplicaInUse(inUse bool, reason string) {
+	if replicaStatus.InUse != inUse {
+		if inUse {
+			logging.Log(1, "The read replica of the database is caught up, so the reads of the ranges go to it.")
+		} else {
+			logging.Log(1, fmt.Sprintf("The reads of the ranges fall back to the primary database: %s.", reason))
+		}
+	}
+	replicaStatus.InUse = inUse
+	replicaStatus.Reason = reason
+}
+
+// CheckReplica measures how far behind the read replica is, and decides whether the reads go to it. It connects to the replica the first time, and again after it failed.
+func CheckReplica() {
+	if !ReplicaConfigured() {
+		return
+	}
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+	now := clock.Unix()
+	replicaStatus.LastCheck = now
+	if replicaDb == nil {
+		db, err := connectTimed("mysql", os.Getenv(globals.DatabaseReplicaEnv))
+		if err != nil {
+			setReplicaInUse(false, fmt.Sprintf("the replica could not be connected to. Error: %s", err))
+			return
+		}
+		replicaDb = db
+	}
+	// The replica is read before the next heartbeat is written, so that the heartbeat it is compared against is one it had time to get.
+	var beat int64
+	err := replicaDb.Get(&beat, "SELECT Beat FROM ReplicaHeartbeat WHERE Id = 1;")
+	if err != nil && err != sql.ErrNoRows {
+		replicaDb.Close()
+		replicaDb = nil
+		replicaStatus.Lag = -1
+		setReplicaInUse(false, fmt.Sprintf("the replica could not be read. Error: %s", err))
+		return
+	}
+	_, err2 := DbInstance.Exec("REPLACE INTO ReplicaHeartbeat (Id, Beat) VALUES (1, ?);", now)
+	if err2 != nil {
+		setReplicaInUse(false, fmt.Sprintf("the heartbeat could not be written into the primary. Error: %s", err2))
+		return
+	}
+	previous := lastHeartbeat
+	lastHeartbeat = now
+	if previous == 0 {
+		// The first check only writes the heartbeat; the replica is used once it has it.
+		replicaStatus.Lag = -1
+		setReplicaInUse(false, "the replica is not measured yet")
+		return
+	}
+	if beat >= previous {
+		// It has every heartbeat written so far.
+		replicaStatus.Lag = 0
+	} else {
+		// It is missing a write the primary made this long ago, at least.
+		replicaStatus.Lag = now - previous
+	}
+	replicaStatus.Horizon = beat - replicaHorizonMargin
+	if time.Duration(replicaStatus.Lag)*time.Second > globals.ReplicaMaxLag {
+		setReplicaInUse(false, fmt.Sprintf("the replica is %ds behind", replicaStatus.Lag))
+		return
+	}
+	setReplicaInUse(true, "")
+}
+
+// replicaFor gives the replica if a read of a range that ends at the given time can go to it, and nil if it has to go to the primary.
+func replicaFor(end api.Timestamp) *sqlx.DB {
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+	if replicaDb == nil || !replicaStatus.InUse || end == 0 || int64(end) > replicaStatus.Horizon {
+		return nil
+	}
+	return replicaDb
+}
+
+// replicaFailed stops the reads from going to the replica after one of them failed on it, until the next check finds it working.
+func replicaFailed(err error) {
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+	setReplicaInUse(false, fmt.Sprintf("a read failed on the replica. Error: %s", err))
+}
+
+// rangeQueryx runs a read of a range that ends at the given time, on the replica if it can, and on the primary otherwise, or if it fails on the replica.
+func rangeQueryx(end api.Timestamp, query string, args ...interface{}) (*sqlx.Rows, error) {
+	return rangeQueryxContext(context.Background(), end, query, args...)
+}
+
+// rangeQueryxContext is rangeQueryx, for the reads run for a request, whose context carries its trace id.
+func rangeQueryxContext(ctx context.Context, end api.Timestamp, query string, args ...interface{}) (*sqlx.Rows, error) {
+	if db := replicaFor(end); db != nil {
+		rows, err := db.QueryxContext(ctx, query, args...)
+		if err == nil {
+			return rows, nil
+		}
+		replicaFailed(err)
+	}
+	return DbInstance.QueryxContext(ctx, query, args...)
+}
+
+// rangeQuery is rangeQueryx, for the reads that scan the rows themselves.
+func rangeQuery(end api.Timestamp, query string, args ...interface{}) (*sql.Rows, error) {
+	if db := replicaFor(end); db != nil {
+		rows, err := db.Query(query, args...)
+		if err == nil {
+			return rows, nil
+		}
+		replicaFailed(err)
+	}
+	return DbInstance.Query(query, args...)
+}
+
+// rangeGet is rangeQueryx, for the reads of a single value, such as a count.
+func rangeGet(end api.Timestamp, dest interface{}, query string, args ...interface{}) error {
+	return rangeGetContext(context.Background(), end, dest, query, args...)
+}
+
+// rangeGetContext is rangeGet, for the reads run for a request.
+func rangeGetContext(ctx context.Context, end api.Timestamp, dest interface{}, query string, args ...interface{}) error {
+	if db := replicaFor(end); db != nil {
+		err := db.GetContext(ctx, dest, query, args...)
+		if err == nil || err == sql.ErrNoRows {
+			return err
+		}
+		replicaFailed(err)
+	}
+	return DbInstance.GetContext(ctx, dest, query, args...)
+}
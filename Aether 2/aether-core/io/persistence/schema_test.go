@@ -0,0 +1,44 @@
+// This test is in the package itself rather than in persistence_test, since the creation schemas are read by a function that is not exported, before the database is checked against them.
+
+package persistence
+
+import (
+	"testing"
+)
+
+func TestParseSchema_Success(t *testing.T) {
+	schema := `
+CREATE TABLE IF NOT EXISTS Examples (
+  Fingerprint VARCHAR(64) PRIMARY KEY NOT NULL, -- The key.
+  Board VARCHAR(64) NOT NULL,
+  Body TEXT NOT NULL,
+  LocalArrival BIGINT NOT NULL,
+  INDEX (Board),
+  INDEX (Board, LocalArrival)
+)`
+	ts := parseSchema(schema)
+	if ts.Name != "Examples" {
+		t.Errorf("The table name is wrong. Name: %s", ts.Name)
+	}
+	if len(ts.Columns) != 4 || ts.Columns[0] != "Fingerprint" || ts.Columns[3] != "LocalArrival" {
+		t.Errorf("The columns are wrong. Columns: %v", ts.Columns)
+	}
+	if def := ts.Definitions["Fingerprint"]; def != "Fingerprint VARCHAR(64) PRIMARY KEY NOT NULL" {
+		t.Errorf("The definition should be without its comment and its comma. Definition: %s", def)
+	}
+	if idx := ts.Indexes["Board"]; idx != "INDEX (Board)" {
+		t.Errorf("The index on the single column should have been kept. Index: %s", idx)
+	}
+	if len(ts.Indexes) != 1 {
+		t.Errorf("The index on more than one column should have been skipped. Indexes: %v", ts.Indexes)
+	}
+}
+
+func TestParseSchema_Success_CreationSchemas(t *testing.T) {
+	for _, schema := range creationSchemas() {
+		ts := parseSchema(schema)
+		if len(ts.Name) == 0 || len(ts.Columns) == 0 {
+			t.Errorf("Every creation schema should have a table and columns. Schema: %s", schema)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+// This test is in the package itself rather than in persistence_test, since the search patterns are made by a function that is not exported, before the read runs.
+
+package persistence
+
+import (
+	"testing"
+)
+
+func TestContainsPattern_Success(t *testing.T) {
+	cases := []struct {
+		search  string
+		pattern string
+	}{
+		{"hello", `%hello%`},
+		{"100%", `%100\%%`},
+		{"snake_case", `%snake\_case%`},
+		{`C:\dir`, `%C:\\dir%`},
+		{`\%_`, `%\\\%\_%`},
+	}
+	for _, c := range cases {
+		if p := containsPattern(c.search); p != c.pattern {
+			t.Errorf("The search is not escaped as it should be. Search: %s, Expected: %s, Got: %s", c.search, c.pattern, p)
+		}
+	}
+}
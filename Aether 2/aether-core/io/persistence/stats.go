@@ -0,0 +1,108 @@
+// Persistence > Stats
+// This file reads how much the database holds: the rows and the bytes of the tables of the entities, how many of them arrived recently, and which boards and threads have the most posts.
+
+package persistence
+
+import (
+	"aether-core/io/api"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+)
+
+// statTables are the tables of the entities, by entity type, in the order they are reported. Unlike entityTables, this has the addresses too.
+var statTables = []struct {
+	EntityType string
+	Table      string
+}{
+	{"boards", "Boards"},
+	{"threads", "Threads"},
+	{"posts", "Posts"},
+	{"votes", "Votes"},
+	{"addresses", "Addresses"},
+	{"keys", "PublicKeys"},
+	{"truststates", "Truststates"},
+	{"tombstones", "Tombstones"},
+}
+
+// TableStat is how much a table of entities holds.
+type TableStat struct {
+	EntityType string `json:"entity_type"`
+	Table      string `json:"table"`
+	Rows       int    `json:"rows"`
+	Arrived    int    `json:"arrived"` // Rows that arrived after the given time.
+	DataBytes  int64  `json:"data_bytes"`
+	IndexBytes int64  `json:"index_bytes"`
+}
+
+// ReadTableStats reads the stats of the tables of the entities. The rows are counted exactly, but the bytes are the estimates of the database.
+func ReadTableStats(arrivedAfter api.Timestamp) ([]TableStat, error) {
+	var stats []TableStat
+	for _, et := range statTables {
+		s := TableStat{EntityType: et.EntityType, Table: et.Table}
+		err := DbInstance.Get(&s.Rows, fmt.Sprintf("SELECT count(1) FROM %s;", et.Table))
+		if err != nil {
+			return stats, errors.New(fmt.Sprintf("The rows of the table could not be counted. Table: %s, Error: %s", et.Table, err))
+		}
+		err2 := DbInstance.Get(&s.Arrived, fmt.Sprintf("SELECT count(1) FROM %s WHERE LocalArrival > ?;", et.Table), arrivedAfter)
+		if err2 != nil {
+			return stats, errors.New(fmt.Sprintf("The recent rows of the table could not be counted. Table: %s, Error: %s", et.Table, err2))
+		}
+		err3 := DbInstance.QueryRowx("SELECT COALESCE(DATA_LENGTH, 0), COALESCE(INDEX_LENGTH, 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?;", et.Table).Scan(&s.DataBytes, &s.IndexBytes)
+		if err3 != nil {
+			return stats, errors.New(fmt.Sprintf("The size of the table could not be read. Table: %s, Error: %s", et.Table, err3))
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// PostCount is a board or a thread, and how many posts it has.
+type PostCount struct {
+	Fingerprint api.Fingerprint `db:"Fingerprint" json:"fingerprint"`
+	Name        string          `db:"Name" json:"name"` // Empty if the board or the thread hasn't arrived.
+	Posts       int             `db:"PostCount" json:"posts"`
+}
+
+// ReadLargestBoards reads the boards with the most posts, the largest first.
+func ReadLargestBoards(limit int) ([]PostCount, error) {
+	var arr []PostCount
+	err := DbInstance.Select(&arr, "SELECT Posts.Board AS Fingerprint, COALESCE(MAX(Boards.Name), '') AS Name, COUNT(*) AS PostCount FROM Posts LEFT JOIN Boards ON Boards.Fingerprint = Posts.Board GROUP BY Posts.Board ORDER BY PostCount DESC, Posts.Board ASC LIMIT ?;", limit)
+	return arr, err
+}
+
+// ReadLargestThreads reads the threads with the most posts, the largest first.
+func ReadLargestThreads(limit int) ([]PostCount, error) {
+	var arr []PostCount
+	err := DbInstance.Select(&arr, "SELECT Posts.Thread AS Fingerprint, COALESCE(MAX(Threads.Name), '') AS Name, COUNT(*) AS PostCount FROM Posts LEFT JOIN Threads ON Threads.Fingerprint = Posts.Thread GROUP BY Posts.Thread ORDER BY PostCount DESC, Posts.Thread ASC LIMIT ?;", limit)
+	return arr, err
+}
+
+// Arrival is when an entity arrived at this node.
+type Arrival struct {
+	Fingerprint  api.Fingerprint `db:"Fingerprint" json:"fingerprint"`
+	LocalArrival api.Timestamp   `db:"LocalArrival" json:"local_arrival"`
+}
+
+// ReadArrivals reads when the given entities arrived at this node. The ones that haven't arrived are left out. Addresses have no fingerprints, so they can't be asked for.
+func ReadArrivals(entityType string, fingerprints []api.Fingerprint) ([]Arrival, error) {
+	arr := []Arrival{}
+	table := ""
+	for _, et := range statTables {
+		if et.EntityType == entityType && entityType != "addresses" {
+			table = et.Table
+		}
+	}
+	if len(table) == 0 {
+		return arr, errors.New(fmt.Sprintf("The arrivals of this entity type can't be read. Entity type: %s", entityType))
+	}
+	if len(fingerprints) == 0 {
+		return arr, nil
+	}
+	query, args, err := sqlx.In(fmt.Sprintf("SELECT Fingerprint, LocalArrival FROM %s WHERE Fingerprint IN (?);", table), fingerprints)
+	if err != nil {
+		return arr, err
+	}
+	err2 := DbInstance.Select(&arr, DbInstance.Rebind(query), args...)
+	return arr, err2
+}
@@ -0,0 +1,78 @@
+// Persistence > Watches
+// This file keeps the threads and boards the local users watch, and reads the feed of what is new in them: the posts in the watched threads, and the threads in the watched boards, newest first. The feed leaves out what the user wrote.
+
+package persistence
+
+import (
+	"aether-core/io/api"
+	"errors"
+	"fmt"
+)
+
+// DbWatch is a thread or a board a local user watches.
+type DbWatch struct {
+	Profile  api.Fingerprint `db:"Profile"` // Key fingerprint of the local user the watch belongs to.
+	Type     string          `db:"Type"`    // "thread" or "board"
+	Target   api.Fingerprint `db:"Target"`
+	Creation api.Timestamp   `db:"Creation"`
+}
+
+// DbWatchFeedItem is a post in a watched thread, or a thread in a watched board.
+type DbWatchFeedItem struct {
+	Type        string          `db:"Type"` // "post" or "thread"
+	Fingerprint api.Fingerprint `db:"Fingerprint"`
+	Board       api.Fingerprint `db:"Board"`
+	Thread      api.Fingerprint `db:"Thread"` // The thread itself, for a thread.
+	Owner       api.Fingerprint `db:"Owner"`
+	Creation    api.Timestamp   `db:"Creation"`
+}
+
+// InsertWatch saves a watch of a local user. A watch that exists is kept as it was.
+func InsertWatch(w DbWatch) error {
+	if w.Type == "" || w.Target == "" {
+		return errors.New(fmt.Sprintf("This watch has one or more empty primary key(s). Watch: %#v\n", w))
+	}
+	_, err := DbInstance.NamedExec(watchInsert, w)
+	return err
+}
+
+// DeleteWatch deletes a watch of the given local user, and returns how many were deleted.
+func DeleteWatch(profile api.Fingerprint, watchType string, target api.Fingerprint) (int64, error) {
+	res, err := DbInstance.Exec("DELETE FROM Watches WHERE Profile = ? AND Type = ? AND Target = ?;", profile, watchType, target)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ReadWatches reads the watches of the given local user, the newest first.
+func ReadWatches(profile api.Fingerprint) ([]DbWatch, error) {
+	arr := []DbWatch{}
+	err := DbInstance.Select(&arr, "SELECT * FROM Watches WHERE Profile = ? ORDER BY Creation DESC, Target ASC;", profile)
+	return arr, err
+}
+
+// CountWatches counts the watches of the given local user.
+func CountWatches(profile api.Fingerprint) (int, error) {
+	var count int
+	err := DbInstance.Get(&count, "SELECT count(1) FROM Watches WHERE Profile = ?;", profile)
+	return count, err
+}
+
+// ReadWatchFeedAfterCursor reads a page of the feed of the watches of the given local user, newest first, after the item of the given creation and fingerprint. A zero creation is the first page. Tombstoned items are excluded, as in the other cursor reads.
+func ReadWatchFeedAfterCursor(profile api.Fingerprint, afterCreation api.Timestamp, afterFp api.Fingerprint, limit int) ([]DbWatchFeedItem, error) {
+	arr := []DbWatchFeedItem{}
+	query := `SELECT * FROM (
+      SELECT 'thread' AS Type, Fingerprint, Board, Fingerprint AS Thread, Owner, Creation FROM Threads WHERE Board IN (SELECT Target FROM Watches WHERE Profile = ? AND Type = 'board')
+        AND NOT EXISTS (SELECT 1 FROM Tombstones WHERE Tombstones.Target = Threads.Fingerprint AND Tombstones.Owner = Threads.Owner AND Tombstones.TargetType = 'threads' AND Tombstones.Owner != '')
+      UNION ALL
+      SELECT 'post' AS Type, Fingerprint, Board, Thread, Owner, Creation FROM Posts WHERE Thread IN (SELECT Target FROM Watches WHERE Profile = ? AND Type = 'thread')
+        AND NOT EXISTS (SELECT 1 FROM Tombstones WHERE Tombstones.Target = Posts.Fingerprint AND Tombstones.Owner = Posts.Owner AND Tombstones.TargetType = 'posts' AND Tombstones.Owner != '')
+    ) AS Feed WHERE Feed.Owner <> ?`
+	args := []interface{}{profile, profile, profile}
+	clause, cursorArgs := cursorClause("Feed", afterCreation, afterFp)
+	args = append(args, cursorArgs...)
+	args = append(args, limit)
+	err := DbInstance.Select(&arr, fmt.Sprint(query, clause, " ORDER BY Feed.Creation DESC, Feed.Fingerprint DESC LIMIT ?;"), args...)
+	return arr, err
+}
@@ -5,12 +5,15 @@ package persistence
 
 import (
 	"aether-core/io/api"
+	"aether-core/services/clock"
 	"fmt"
 	// _ "github.com/mattn/go-sqlite3"
 	"aether-core/services/globals"
 	"aether-core/services/logging"
+	"context"
+	"database/sql"
 	"errors"
-	"time"
+	"github.com/jmoiron/sqlx"
 )
 
 // Node is a non-communicating entity that holds the LastCheckin timestamps of each of the entities provided in the remote node. There is no way to send this data over to somebody, this is entirely local. There is also no batch processing because there is no situation in which you would need to insert multiple nodes at the same time (since you won't be connecting to multiple nodes simultaneously)
@@ -79,40 +82,270 @@ func InsertOrUpdateAddress(a api.Address) error {
 	return nil
 }
 
+// InsertNotifications saves the notifications generated from the incoming posts. Notifications that already exist are ignored, so the seen state of an existing notification is never reset.
+func InsertNotifications(ns []DbNotification) error {
+	if len(ns) == 0 {
+		return nil
+	}
+	tx, err := DbInstance.Beginx()
+	if err != nil {
+		return err
+	}
+	for i, _ := range ns {
+		if ns[i].Post == "" || ns[i].Type == "" {
+			logging.Log(1, fmt.Sprintf("This notification has one or more empty primary key(s). Notification: %#v\n", ns[i]))
+			continue
+		}
+		_, err2 := tx.NamedExec(notificationInsert, ns[i])
+		if err2 != nil {
+			tx.Rollback()
+			return err2
+		}
+	}
+	err3 := tx.Commit()
+	if err3 != nil {
+		return err3
+	}
+	return nil
+}
+
+// MarkNotificationsSeen marks the notifications of the given posts as seen. If no posts are given, it marks all notifications as seen.
+func MarkNotificationsSeen(posts []api.Fingerprint) error {
+	if len(posts) == 0 {
+		_, err := DbInstance.Exec("UPDATE Notifications SET Seen = TRUE WHERE Seen = FALSE;")
+		return err
+	}
+	query, args, err := sqlx.In("UPDATE Notifications SET Seen = TRUE WHERE Post IN (?);", posts)
+	if err != nil {
+		return err
+	}
+	_, err2 := DbInstance.Exec(query, args...)
+	return err2
+}
+
+// CompactVotes replaces the votes created before the given timestamp with per-target summaries. The counts of the votes are added to the existing summaries, and every summary that changes is signed again by the given signer. All of it happens in one transaction, so that no vote is both counted and kept.
+func CompactVotes(before api.Timestamp, sign func(*DbVoteSummary) error) (int64, error) {
+	tx, err := DbInstance.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	var tallies []DbVoteSummary
+	err2 := tx.Select(&tallies, `
+    SELECT Votes.Target AS Target, Votes.Type AS Type,
+      MIN(Votes.Board) AS Board, MIN(Votes.Thread) AS Thread,
+      COUNT(*) + COALESCE(MAX(VoteSummaries.Count), 0) AS Count,
+      '' AS Signer, '' AS Signature, 0 AS Until, 0 AS LocalArrival
+    FROM Votes
+    LEFT JOIN VoteSummaries ON Votes.Target = VoteSummaries.Target AND Votes.Type = VoteSummaries.Type
+    WHERE Votes.Creation < ?
+    GROUP BY Votes.Target, Votes.Type;`, before)
+	if err2 != nil {
+		tx.Rollback()
+		return 0, err2
+	}
+	now := api.Timestamp(clock.Unix())
+	for i, _ := range tallies {
+		tallies[i].Until = before
+		tallies[i].LocalArrival = now
+		err3 := sign(&tallies[i])
+		if err3 != nil {
+			tx.Rollback()
+			return 0, err3
+		}
+		_, err4 := tx.NamedExec(voteSummaryInsert, tallies[i])
+		if err4 != nil {
+			tx.Rollback()
+			return 0, err4
+		}
+	}
+	res, err5 := tx.Exec("DELETE FROM Votes WHERE Creation < ?;", before)
+	if err5 != nil {
+		tx.Rollback()
+		return 0, err5
+	}
+	compacted, _ := res.RowsAffected()
+	err6 := tx.Commit()
+	if err6 != nil {
+		return 0, err6
+	}
+	return compacted, nil
+}
+
+// InsertThreadScores saves the scores of the threads, replacing the ones they had.
+func InsertThreadScores(scores []DbThreadScore) error {
+	if len(scores) == 0 {
+		return nil
+	}
+	tx, err := DbInstance.Beginx()
+	if err != nil {
+		return err
+	}
+	for i, _ := range scores {
+		if scores[i].Thread == "" {
+			logging.Log(1, fmt.Sprintf("This thread score has an empty primary key. Thread score: %#v\n", scores[i]))
+			continue
+		}
+		_, err2 := tx.NamedExec(threadScoreInsert, scores[i])
+		if err2 != nil {
+			tx.Rollback()
+			return err2
+		}
+	}
+	err3 := tx.Commit()
+	if err3 != nil {
+		return err3
+	}
+	return nil
+}
+
+// InsertReplyPaths saves the places of the posts in the reply trees, replacing the ones they had.
+func InsertReplyPaths(paths []DbReplyPath) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	tx, err := DbInstance.Beginx()
+	if err != nil {
+		return err
+	}
+	for i, _ := range paths {
+		_, err2 := tx.NamedExec(replyPathInsert, paths[i])
+		if err2 != nil {
+			tx.Rollback()
+			return err2
+		}
+	}
+	return tx.Commit()
+}
+
+// MoveReplySubtree moves the detached subtree whose root has the given path under the parent that arrived for it: the new prefix goes before the paths of all of it, and the depth of all of it grows by the given amount.
+func MoveReplySubtree(thread api.Fingerprint, rootPath string, newPrefix string, depthDelta int, detached bool) error {
+	// The paths are made of hex digits only, so they need no escaping in the LIKE.
+	_, err := DbInstance.Exec("UPDATE ReplyPaths SET Path = CONCAT(?, Path), Depth = Depth + ?, Detached = ? WHERE Thread = ? AND Detached = TRUE AND Path LIKE ?;", newPrefix, depthDelta, detached, thread, fmt.Sprint(rootPath, "%"))
+	return err
+}
+
+// InsertContentFilter saves a content filter of the local user.
+func InsertContentFilter(f DbContentFilter) error {
+	if f.Type == "" || f.Value == "" {
+		return errors.New(fmt.Sprintf("This content filter has one or more empty primary key(s). Content filter: %#v\n", f))
+	}
+	_, err := DbInstance.NamedExec(contentFilterInsert, f)
+	return err
+}
+
+// DeleteContentFilter removes a content filter of the local user, and returns how many were removed.
+func DeleteContentFilter(profile api.Fingerprint, filterType string, value string) (int64, error) {
+	res, err := DbInstance.Exec("DELETE FROM ContentFilters WHERE Profile = ? AND Type = ? AND Value = ?;", profile, filterType, value)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// InsertSyncBookmark saves where the last sync with a remote ended for an entity type, replacing the bookmark from before.
+func InsertSyncBookmark(b DbSyncBookmark) error {
+	if b.Node == "" || b.EntityType == "" {
+		return errors.New(fmt.Sprintf("This sync bookmark has one or more empty primary key(s). Sync bookmark: %#v\n", b))
+	}
+	_, err := DbInstance.NamedExec(syncBookmarkInsert, b)
+	return err
+}
+
+// InsertResponsePage saves a page of a multipart POST response. It is not served until the response is published.
+func InsertResponsePage(response string, page int, data []byte, expiry int64) error {
+	_, err := DbInstance.Exec("REPLACE INTO ResponsePages (Response, Page, Data, Bytes, Expiry, Published) VALUES (?, ?, ?, ?, ?, FALSE);", response, page, data, len(data), expiry)
+	return err
+}
+
+// PublishResponsePages makes all pages of the response servable at once.
+func PublishResponsePages(response string) error {
+	_, err := DbInstance.Exec("UPDATE ResponsePages SET Published = TRUE WHERE Response = ?;", response)
+	return err
+}
+
+// DeleteResponsePages removes the pages of the response.
+func DeleteResponsePages(response string) error {
+	_, err := DbInstance.Exec("DELETE FROM ResponsePages WHERE Response = ?;", response)
+	return err
+}
+
+// DeleteExpiredResponsePages removes the pages of the responses that expired before the given time, published or not, and gives how many pages it removed.
+func DeleteExpiredResponsePages(now int64) (int64, error) {
+	res, err := DbInstance.Exec("DELETE FROM ResponsePages WHERE Expiry < ?;", now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// InsertImportedItem records a feed item that was converted into a thread, so that it won't be imported again.
+func InsertImportedItem(item DbImportedItem) error {
+	if item.ItemKey == "" {
+		return errors.New(fmt.Sprintf("This imported item has an empty primary key. Item: %#v\n", item))
+	}
+	_, err := DbInstance.NamedExec(importedItemInsert, item)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 // TODO: Mind that any errors happening within the transaction, if they need to bail from the transaction, they need to close it! otherwise you get database is locked.
 // TODO: Should this take a pointer instead? It's dealing with some big amounts of data.
 // BatchInsert insert a set of objects in a batch as a transaction.
 func BatchInsert(apiObjects []interface{}) error {
-	logging.Log(2, "Batch insert starting.")
-	defer logging.Log(2, "Batch insert is complete.")
+	return BatchInsertFrom(apiObjects, LocalSource)
+}
+
+// BatchInsertFrom is BatchInsert for the entities that came from the given source. The source is recorded as the provenance of the ones that arrive for the first time. See provenance.go.
+func BatchInsertFrom(apiObjects []interface{}, source Source) error {
+	_, err := BatchInsertWritten(apiObjects, source)
+	return err
+}
+
+// BatchInsertWritten is BatchInsertFrom that also gives the fingerprints of the entities that were written. The ones the database already had, which INSERT IGNORE skips, and the ones older than what it has, which the conditional REPLACEs and the update checks skip, are not in it. Addresses are never in it. What it logs, and its slow queries, are tagged with the trace id of the source.
+func BatchInsertWritten(apiObjects []interface{}, source Source) (map[api.Fingerprint]bool, error) {
+	written := make(map[api.Fingerprint]bool)
+	ctx := logging.WithTrace(context.Background(), source.TraceId)
+	logging.LogContext(ctx, 2, "Batch insert starting.")
+	defer logging.LogContext(ctx, 2, "Batch insert is complete.")
 	numberOfObjectsCommitted := len(apiObjects)
-	logging.Log(2, fmt.Sprintf("%v objects are being committed.", numberOfObjectsCommitted))
+	logging.LogContext(ctx, 2, fmt.Sprintf("%v objects are being committed.", numberOfObjectsCommitted))
+	if globals.IngestCoalescingEnabled {
+		coalesced, dropped := coalesceUpdates(apiObjects)
+		if dropped > 0 {
+			logging.LogContext(ctx, 2, fmt.Sprintf("%v writes of votes and truststates were superseded within the batch. They are not committed.", dropped))
+		}
+		apiObjects = coalesced
+	}
 
-	start := time.Now()
+	start := clock.Now()
+	now := api.Timestamp(start.Unix())
 	// fmt.Printf("%#v\n", apiObjects)
 	// Begin transaction.
-	tx, err := DbInstance.Beginx()
+	tx, err := DbInstance.BeginTxx(ctx, nil)
 	if err != nil {
 		logging.LogCrash(err)
 	}
+	summarisedUntil := make(map[voteSummaryKey]api.Timestamp) // The Until of the summary of each target and type the batch has votes on.
 	// For each API object, convert to DB object and add to transaction.
 	for _, apiObject := range apiObjects {
 		// apiObject: API type, dbObj: DB type.
 		dbo, err := APItoDB(apiObject)
 		if err != nil {
-			return errors.New(fmt.Sprint(
+			return written, errors.New(fmt.Sprint(
 				"Error raised from APItoDB function used in Batch insert. Error: ", err))
 		}
 		err2 := enforceNoEmptyIdentityFields(dbo)
 		if err2 != nil {
 			// If this unit does have empty identity fields, we pass on adding it to the database.
-			logging.Log(1, err2)
+			logging.LogContext(ctx, 1, err2)
 			continue
 		}
 		err3 := enforceNoEmptyRequiredFields(dbo)
 		if err3 != nil {
 			// If this unit does have empty identity fields, we pass on adding it to the database.
-			logging.Log(1, err3)
+			logging.LogContext(ctx, 1, err3)
 			continue
 		}
 		switch dbObject := dbo.(type) {
@@ -126,10 +359,11 @@ func BatchInsert(apiObjects []interface{}) error {
 
 		case BoardPack:
 			if packShouldBeCommitted(dbObject) {
-				_, err := tx.NamedExec(boardInsert, dbObject.Board)
+				res, err := tx.NamedExecContext(ctx, boardInsert, dbObject.Board)
 				if err != nil {
 					logging.LogCrash(err)
 				}
+				noteWritten(written, res, dbObject.Board.Fingerprint)
 				// Get the list of board owners before the transaction.
 				boardBoardOwnersBeforeTx, err := getBoardOwnersBeforeTx(dbObject.Board.Fingerprint)
 				if err != nil {
@@ -141,14 +375,14 @@ func BatchInsert(apiObjects []interface{}) error {
 				for boardOwner, keepBoardOwner := range changelist {
 					if keepBoardOwner == true {
 						// We keep the owner's existence. This can either be a creation or an update, SQL deals with that.
-						_, err := tx.NamedExec(boardOwnerInsert, boardOwner)
+						_, err := tx.NamedExecContext(ctx, boardOwnerInsert, boardOwner)
 						if err != nil {
 							// fmt.Printf("%#v\n", err)
 							logging.LogCrash(err)
 						}
 					} else {
 						// The owner is deleted. So we remove it from the database.
-						_, err := tx.NamedExec(boardOwnerDelete, boardOwner)
+						_, err := tx.NamedExecContext(ctx, boardOwnerDelete, boardOwner)
 						if err != nil {
 							// fmt.Printf("%#v\n", err)
 							logging.LogCrash(err)
@@ -157,20 +391,32 @@ func BatchInsert(apiObjects []interface{}) error {
 				}
 			}
 		case DbThread:
-			_, err := tx.NamedExec(threadInsert, dbObject)
+			res, err := tx.NamedExecContext(ctx, threadInsert, dbObject)
 			if err != nil {
 				logging.LogCrash(err)
 			}
+			noteWritten(written, res, dbObject.Fingerprint)
 		case DbPost:
-			_, err := tx.NamedExec(postInsert, dbObject)
+			res, err := tx.NamedExecContext(ctx, postInsert, dbObject)
 			if err != nil {
 				logging.LogCrash(err)
 			}
+			noteWritten(written, res, dbObject.Fingerprint)
 		case DbVote:
-			_, err := tx.NamedExec(voteInsert, dbObject)
+			until, err3 := voteSummarisedUntil(tx, summarisedUntil, dbObject.Target, dbObject.Type)
+			if err3 != nil {
+				logging.LogCrash(err3)
+			}
+			if dbObject.Creation < until {
+				// The summary of its target already counts the votes created before its Until. Taking it in would count it twice. A vote that isn't covered by a summary is taken in, however old it is, and the next compaction counts it.
+				logging.LogSampledTrace(source.TraceId, "persistence", "compacted-vote", 2, fmt.Sprintf("This vote is older than the summary of its target. It is not committed. Vote: %s", dbObject.Fingerprint))
+				continue
+			}
+			res, err := tx.NamedExecContext(ctx, voteInsert, dbObject)
 			if err != nil {
 				logging.LogCrash(err)
 			}
+			noteWritten(written, res, dbObject.Fingerprint)
 		case DbAddress:
 			// In case of address, we strip out everything except the primary keys. This is because we cannot trust the data that is coming from the network. We just add the primary key set, and the local node will take care of directly connecting to these nodes and getting the details.
 			// The other types of address inputs are not affected by this because they use InsertOrUpdateAddress, not this batch insert. If you're batch inserting addresses, it's by definition third party data.
@@ -184,16 +430,19 @@ func BatchInsert(apiObjects []interface{}) error {
 			dbObject.ClientVersionMinor = 0
 			dbObject.ClientVersionPatch = 0
 			dbObject.ClientName = ""
-			_, err := tx.NamedExec(addressInsert, dbObject)
+			dbObject.Endpoints = ""
+			dbObject.Local = false // Only this node can find an address on its own network.
+			_, err := tx.NamedExecContext(ctx, addressInsert, dbObject)
 			if err != nil {
 				logging.LogCrash(err)
 			}
 		case KeyPack:
 			if packShouldBeCommitted(dbObject) {
-				_, err := tx.NamedExec(keyInsert, dbObject.Key)
+				res, err := tx.NamedExecContext(ctx, keyInsert, dbObject.Key)
 				if err != nil {
 					logging.LogCrash(err)
 				}
+				noteWritten(written, res, dbObject.Key.Fingerprint)
 				// Get the list of currency addresses before the transaction.
 				currencyAddressesBeforeTx, err := getCurrencyAddressesBeforeTx(dbObject.Key.Fingerprint)
 				// Get the changelist.
@@ -202,14 +451,14 @@ func BatchInsert(apiObjects []interface{}) error {
 				for currencyAddress, keepcurrencyAddress := range changelist {
 					if keepcurrencyAddress == true {
 						// We keep the owner's existence. This can either be a creation or an update, SQL deals with that.
-						_, err := tx.NamedExec(currencyAddressInsert, currencyAddress)
+						_, err := tx.NamedExecContext(ctx, currencyAddressInsert, currencyAddress)
 						if err != nil {
 							// fmt.Printf("%#v\n", err)
 							logging.LogCrash(err)
 						}
 					} else {
 						// The owner is deleted. So we remove it from the database.
-						_, err := tx.NamedExec(currencyAddressDelete, currencyAddress)
+						_, err := tx.NamedExecContext(ctx, currencyAddressDelete, currencyAddress)
 						if err != nil {
 							// fmt.Printf("%#v\n", err)
 							logging.LogCrash(err)
@@ -218,24 +467,80 @@ func BatchInsert(apiObjects []interface{}) error {
 				}
 			}
 		case DbTruststate:
-			_, err := tx.NamedExec(truststateInsert, dbObject)
+			res, err := tx.NamedExecContext(ctx, truststateInsert, dbObject)
 			if err != nil {
 				logging.LogCrash(err)
 			}
+			noteWritten(written, res, dbObject.Fingerprint)
+		case DbTombstone:
+			res, err := tx.NamedExecContext(ctx, tombstoneInsert, dbObject)
+			if err != nil {
+				logging.LogCrash(err)
+			}
+			noteWritten(written, res, dbObject.Fingerprint)
 		default:
-			return errors.New(
+			return written, errors.New(
 				fmt.Sprintf(
 					"This object type is something batch insert does not understand. Your object: %#v\n", dbObject))
 		}
 		// TODO: Create a prepared statement for each of those that allows for insertion.
+		if p, ok := provenanceOf(apiObject, source, now); ok && globals.ProvenanceEnabled {
+			_, err := tx.NamedExecContext(ctx, provenanceInsert, p)
+			if err != nil {
+				logging.LogCrash(err)
+			}
+		}
+	}
+	// Hide the bodies of the threads and posts deleted by their owners. This runs for every batch, so that it catches both the tombstones arriving after their targets, and the targets arriving after their tombstones.
+	_, err = tx.ExecContext(ctx, threadTombstoneApply)
+	if err != nil {
+		logging.LogCrash(err)
+	}
+	_, err = tx.ExecContext(ctx, postTombstoneApply)
+	if err != nil {
+		logging.LogCrash(err)
 	}
 	err = tx.Commit()
 	if err != nil {
-		return err
+		return make(map[api.Fingerprint]bool), err
+	}
+	elapsed := clock.Since(start)
+	logging.LogContext(ctx, 2, fmt.Sprintf("It took %v to insert %v objects.", elapsed, numberOfObjectsCommitted))
+	return written, nil
+}
+
+// noteWritten adds the fingerprint to the written ones if the statement changed a row.
+func noteWritten(written map[api.Fingerprint]bool, res sql.Result, fp api.Fingerprint) {
+	n, err := res.RowsAffected()
+	if err == nil && n > 0 {
+		written[fp] = true
 	}
-	elapsed := time.Since(start)
-	logging.Log(2, fmt.Sprintf("It took %v to insert %v objects.", elapsed, numberOfObjectsCommitted))
-	return nil
+}
+
+// voteSummaryKey is what a vote summary is kept by.
+type voteSummaryKey struct {
+	target   api.Fingerprint
+	voteType uint8
+}
+
+// voteSummarisedUntil gives the Until of the summary of the votes of the type on the target, or 0 if there is none. The ones already read in the batch are kept in known.
+func voteSummarisedUntil(tx *sqlx.Tx, known map[voteSummaryKey]api.Timestamp, target api.Fingerprint, voteType uint8) (api.Timestamp, error) {
+	key := voteSummaryKey{target, voteType}
+	if until, ok := known[key]; ok {
+		return until, nil
+	}
+	var until api.Timestamp
+	err := tx.Get(&until, "SELECT Until FROM VoteSummaries WHERE Target = ? AND Type = ?;", target, voteType)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	known[key] = until
+	return until, nil
+}
+
+// VoteCompactionCutoff is the creation timestamp before which the votes are compacted into summaries.
+func VoteCompactionCutoff() api.Timestamp {
+	return api.Timestamp(clock.Now().AddDate(0, 0, -globals.VoteCompactionAgeDays).Unix())
 }
 
 func packShouldBeCommitted(pack interface{}) bool {
@@ -424,6 +729,12 @@ func enforceNoEmptyIdentityFields(object interface{}) error {
 				fmt.Sprintf(
 					"This trust state has an empty primary key. Truststate: %#v\n", obj))
 		}
+	case DbTombstone:
+		if obj.Fingerprint == "" {
+			return errors.New(
+				fmt.Sprintf(
+					"This tombstone has an empty primary key. Tombstone: %#v\n", obj))
+		}
 	}
 	return nil
 }
@@ -489,6 +800,12 @@ func enforceNoEmptyRequiredFields(object interface{}) error {
 				fmt.Sprintf(
 					"This trust state has some required fields empty (One or more of: Target, Owner, Type, Creation, PoW, Signature). Truststate: %#v\n", obj))
 		}
+	case DbTombstone:
+		if obj.Target == "" || (obj.TargetType != "threads" && obj.TargetType != "posts") || obj.Owner == "" || obj.Creation == 0 || obj.ProofOfWork == "" || obj.Signature == "" {
+			return errors.New(
+				fmt.Sprintf(
+					"This tombstone has some required fields empty (One or more of: Target, TargetType, Owner, Creation, PoW, Signature). Tombstone: %#v\n", obj))
+		}
 	}
 	return nil
 }
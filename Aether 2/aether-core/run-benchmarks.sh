@@ -0,0 +1,13 @@
+#!/usr/bin/env bash
+
+set -e
+cd $GOPATH/src/aether-core
+echo "Running the benchmarks of the hot paths. The results are saved to benchmarks/, and compared with the previous run if benchstat is installed."
+mkdir -p benchmarks
+if [ -f benchmarks/latest.txt ]; then
+    mv benchmarks/latest.txt benchmarks/previous.txt
+fi
+go test -run='^$' -bench=. -benchmem -count=5 ./backend/responsegenerator/ $@ | tee benchmarks/latest.txt
+if [ -f benchmarks/previous.txt ] && command -v benchstat > /dev/null; then
+    benchstat benchmarks/previous.txt benchmarks/latest.txt
+fi
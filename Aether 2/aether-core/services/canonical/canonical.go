@@ -0,0 +1,186 @@
+// Services > Canonical
+// This module provides the canonical JSON form of the entities, which their fingerprints, proofs of work and signatures can be made over. They are still made over the JSON of the struct, in the order of its fields, which is what every node on the network checks them against; the canonical form is accepted along with it.
+
+package canonical
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Fingerprints, proofs of work and signatures are all calculated over the JSON of the entity. Two implementations that serialise the same entity differently will not agree on any of them, so the form has to be fixed, and it has to be possible to write it without Go's encoding/json. The canonical form is:
+
+1) The JSON keys of the entity, as in its API form. The fields that are cleared for an operation (e.g. the fingerprint, when creating the fingerprint) are present, with their empty value: "" for strings, 0 for numbers, null for lists.
+2) The keys in ExcludedFields are left out, at every depth.
+3) The keys of every object sorted by their UTF-8 bytes.
+4) No whitespace outside of strings.
+5) Integers in decimal, without a leading +, leading zeros or an exponent. Numbers with a fraction, which the entities don't have, in the shortest decimal form that reads back as the same 64-bit float, without an exponent.
+6) Strings in UTF-8, with only ", \ and the control characters escaped. \b, \f, \n, \r and \t are written as such, the rest of the control characters, U+2028 and U+2029 as \u00XX in lowercase hex. Invalid UTF-8 is replaced with U+FFFD.
+7) true, false and null as is.
+*/
+
+// ExcludedFields are the JSON keys that are never part of the canonical form. Fields added to the entities later that are not part of what is signed go here, so that adding them does not change the fingerprints and signatures of the existing entities.
+var ExcludedFields = []string{}
+
+// Encode returns the canonical JSON of the value. The value is first converted to JSON with encoding/json, so the keys are the ones in its JSON tags.
+func Encode(v interface{}) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return []byte{}, err
+	}
+	return Canonicalise(raw)
+}
+
+// Canonicalise converts any JSON into its canonical form.
+func Canonicalise(raw []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var tree interface{}
+	err := dec.Decode(&tree)
+	if err != nil {
+		return []byte{}, errors.New(fmt.Sprintf("The JSON could not be read. Error: %s", err))
+	}
+	var buf bytes.Buffer
+	err2 := write(&buf, tree)
+	if err2 != nil {
+		return []byte{}, err2
+	}
+	return buf.Bytes(), nil
+}
+
+// String is Encode for the callers that need a string, such as the fingerprinting, proof of work and signing functions. An entity that can't be encoded gives an empty string, which won't match any fingerprint or signature.
+func String(v interface{}) string {
+	res, err := Encode(v)
+	if err != nil {
+		return ""
+	}
+	return string(res)
+}
+
+func isExcluded(key string) bool {
+	for i, _ := range ExcludedFields {
+		if ExcludedFields[i] == key {
+			return true
+		}
+	}
+	return false
+}
+
+func write(buf *bytes.Buffer, node interface{}) error {
+	switch n := node.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		if n {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case json.Number:
+		num, err := formatNumber(n)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(num)
+	case string:
+		writeString(buf, n)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, _ := range n {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			err := write(buf, n[i])
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		var keys []string
+		for key, _ := range n {
+			if !isExcluded(key) {
+				keys = append(keys, key)
+			}
+		}
+		// Go compares strings by their bytes, which is the UTF-8 order.
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeString(buf, key)
+			buf.WriteByte(':')
+			err := write(buf, n[key])
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return errors.New(fmt.Sprintf("This JSON value can't be made canonical. Type: %T", node))
+	}
+	return nil
+}
+
+func formatNumber(n json.Number) (string, error) {
+	str := string(n)
+	if !strings.ContainsAny(str, ".eE") {
+		i, err := strconv.ParseInt(str, 10, 64)
+		if err == nil {
+			return strconv.FormatInt(i, 10), nil
+		}
+		// Larger than int64. Unsigned 64-bit values still fit here.
+		u, err2 := strconv.ParseUint(str, 10, 64)
+		if err2 == nil {
+			return strconv.FormatUint(u, 10), nil
+		}
+	}
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("This number can't be made canonical. Number: %s", str))
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+func writeString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range strings.ToValidUTF8(s, "\uFFFD") {
+		switch r {
+		case '"':
+			buf.WriteString("\\\"")
+		case '\\':
+			buf.WriteString("\\\\")
+		case '\b':
+			buf.WriteString("\\b")
+		case '\f':
+			buf.WriteString("\\f")
+		case '\n':
+			buf.WriteString("\\n")
+		case '\r':
+			buf.WriteString("\\r")
+		case '\t':
+			buf.WriteString("\\t")
+		case '\u2028', '\u2029':
+			buf.WriteString(fmt.Sprintf("\\u%04x", r))
+		default:
+			if r < 0x20 {
+				buf.WriteString("\\u00")
+				buf.WriteByte(hexDigits[r>>4])
+				buf.WriteByte(hexDigits[r&0xF])
+				continue
+			}
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+}
@@ -0,0 +1,108 @@
+package canonical_test
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/canonical"
+	"aether-core/services/fingerprinting"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// USAGE
+// The canonical form of every testdata/*.json is compared with the testdata/*.golden next to it. These files are meant to be shared with other implementations. If the canonical form changes on purpose, regenerate them with:
+// go test -update
+
+var update = flag.Bool("update", false, "Rewrites the golden files with the current output.")
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	canonical.ExcludedFields = []string{}
+}
+
+func teardown() {
+}
+
+// Tests
+
+func TestCanonicalise_Success_Golden(t *testing.T) {
+	inputs, _ := filepath.Glob("testdata/*.json")
+	if len(inputs) == 0 {
+		t.Fatalf("There are no golden inputs.")
+	}
+	for _, input := range inputs {
+		raw, _ := ioutil.ReadFile(input)
+		result, err := canonical.Canonicalise(raw)
+		if err != nil {
+			t.Errorf("The input could not be made canonical. Input: %s, Error: %s", input, err)
+			continue
+		}
+		goldenPath := strings.TrimSuffix(input, ".json") + ".golden"
+		if *update {
+			ioutil.WriteFile(goldenPath, result, 0644)
+			continue
+		}
+		golden, err2 := ioutil.ReadFile(goldenPath)
+		if err2 != nil {
+			t.Errorf("The golden file could not be read. Path: %s, Error: %s", goldenPath, err2)
+			continue
+		}
+		if !bytes.Equal(result, golden) {
+			t.Errorf("The canonical form does not match the golden file. Input: %s\nExpected: %s\nGot:      %s", input, golden, result)
+		}
+	}
+}
+
+func TestEncode_Success_FingerprintAcceptsCanonicalForm(t *testing.T) {
+	raw, _ := ioutil.ReadFile("testdata/board.json")
+	golden, _ := ioutil.ReadFile("testdata/board.golden")
+	var board api.Board
+	json.Unmarshal(raw, &board)
+	board.Fingerprint = api.Fingerprint(fingerprinting.Create(string(golden)))
+	if !board.VerifyFingerprint() {
+		t.Errorf("A fingerprint calculated over the canonical form of the board should be accepted.")
+	}
+	// The fingerprints are still created over the JSON of the struct, which the nodes that know no other form check them against.
+	board.CreateFingerprint()
+	if string(board.Fingerprint) == fingerprinting.Create(string(golden)) || !board.VerifyFingerprint() {
+		t.Errorf("The fingerprint of the board should be created over the JSON of the struct.")
+	}
+}
+
+func TestCanonicalise_Success_KeyOrderIrrelevant(t *testing.T) {
+	a, _ := canonical.Canonicalise([]byte(`{"b": 1, "a": {"d": 2, "c": 3}}`))
+	b, _ := canonical.Canonicalise([]byte(`{"a":{"c":3,"d":2},"b":1}`))
+	if !bytes.Equal(a, b) || string(a) != `{"a":{"c":3,"d":2},"b":1}` {
+		t.Errorf("The same object in a different order gave a different canonical form. A: %s, B: %s", a, b)
+	}
+}
+
+func TestCanonicalise_Success_ExcludedFields(t *testing.T) {
+	canonical.ExcludedFields = []string{"meta"}
+	defer func() { canonical.ExcludedFields = []string{} }()
+	result, _ := canonical.Canonicalise([]byte(`{"name": "x", "meta": "local", "inner": {"meta": 1, "a": 2}}`))
+	if string(result) != `{"inner":{"a":2},"name":"x"}` {
+		t.Errorf("The excluded fields were not left out. Got: %s", result)
+	}
+}
+
+func TestCanonicalise_Fail_InvalidJson(t *testing.T) {
+	_, err := canonical.Canonicalise([]byte(`{"name": `))
+	if err == nil {
+		t.Errorf("Invalid JSON was made canonical.")
+	}
+}
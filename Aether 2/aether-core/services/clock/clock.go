@@ -0,0 +1,93 @@
+// Services > Clock
+// This module is the single source of time for the application. Everything that timestamps, computes an expiry or measures a duration asks the clock here, so that tests can replace it with a fixed one.
+
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a source of time. The system clock is used unless a test sets another one.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+// Now returns the current time. The value carries the monotonic reading of the system, so the durations computed from it are not affected by adjustments of the wall clock.
+func (c systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var current Clock = systemClock{}
+var currentLock sync.RWMutex
+
+// Set replaces the clock used by the application. This is meant for tests.
+func Set(c Clock) {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+	current = c
+}
+
+// Reset goes back to the system clock.
+func Reset() {
+	Set(systemClock{})
+}
+
+// Now is the current time.
+func Now() time.Time {
+	currentLock.RLock()
+	defer currentLock.RUnlock()
+	return current.Now()
+}
+
+// Unix is the current time as a Unix timestamp, which is what goes into the entities and the responses. Timestamps are wall clock time, so do not use the difference of two of them as a duration; use Since on a time.Time from Now for that.
+func Unix() int64 {
+	return Now().Unix()
+}
+
+// Since is the time that has passed since t. If t is from Now, this uses the monotonic reading, and it can't be negative even if the wall clock was moved backwards in between.
+func Since(t time.Time) time.Duration {
+	d := Now().Sub(t)
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// ExpiryUnix is the Unix timestamp of the moment that is d from now.
+func ExpiryUnix(d time.Duration) int64 {
+	return Now().Add(d).Unix()
+}
+
+// MockClock is a clock that only moves when told to. It is safe to use from multiple goroutines.
+type MockClock struct {
+	lock sync.Mutex
+	t    time.Time
+}
+
+// NewMockClock creates a mock clock stopped at t.
+func NewMockClock(t time.Time) *MockClock {
+	return &MockClock{t: t}
+}
+
+func (m *MockClock) Now() time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.t
+}
+
+// Advance moves the mock clock forward by d.
+func (m *MockClock) Advance(d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.t = m.t.Add(d)
+}
+
+// SetTime moves the mock clock to t, which can be in the past.
+func (m *MockClock) SetTime(t time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.t = t
+}
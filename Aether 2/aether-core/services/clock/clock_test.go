@@ -0,0 +1,76 @@
+package clock_test
+
+import (
+	"aether-core/services/clock"
+	"os"
+	"testing"
+	"time"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+}
+
+func teardown() {
+	clock.Reset()
+}
+
+// Tests
+
+func TestUnix_Success(t *testing.T) {
+	mc := clock.NewMockClock(time.Unix(1500000000, 0))
+	clock.Set(mc)
+	defer clock.Reset()
+	if clock.Unix() != 1500000000 {
+		t.Errorf("The mock time was not returned. Got: %d", clock.Unix())
+	}
+	mc.Advance(90 * time.Second)
+	if clock.Unix() != 1500000090 {
+		t.Errorf("The mock clock did not advance. Got: %d", clock.Unix())
+	}
+}
+
+func TestExpiryUnix_Success(t *testing.T) {
+	clock.Set(clock.NewMockClock(time.Unix(1500000000, 0)))
+	defer clock.Reset()
+	if clock.ExpiryUnix(30*time.Minute) != 1500001800 {
+		t.Errorf("The expiry is wrong. Got: %d", clock.ExpiryUnix(30*time.Minute))
+	}
+}
+
+func TestSince_Success(t *testing.T) {
+	mc := clock.NewMockClock(time.Unix(1500000000, 0))
+	clock.Set(mc)
+	defer clock.Reset()
+	start := clock.Now()
+	mc.Advance(5 * time.Second)
+	if clock.Since(start) != 5*time.Second {
+		t.Errorf("The duration is wrong. Got: %s", clock.Since(start))
+	}
+}
+
+func TestSince_ClockMovedBack(t *testing.T) {
+	mc := clock.NewMockClock(time.Unix(1500000000, 0))
+	clock.Set(mc)
+	defer clock.Reset()
+	start := clock.Now()
+	mc.SetTime(time.Unix(1400000000, 0))
+	if clock.Since(start) != 0 {
+		t.Errorf("A negative duration was returned. Got: %s", clock.Since(start))
+	}
+}
+
+func TestSince_SystemClock(t *testing.T) {
+	start := clock.Now()
+	if clock.Since(start) < 0 {
+		t.Errorf("A negative duration was returned from the system clock.")
+	}
+}
@@ -0,0 +1,128 @@
+// Services > Composition
+// This package limits how fast the local user creates threads, posts and votes, to the composition policy of the network the node is in. The remotes drop the content of the keys that write faster than their spam filters allow, so it is better for the user to be told to wait here than to have their content silently not spread.
+// The limits are counted over the last minute, per kind, for the content signed with the key of the user only: the entities the operator adds, and the ones of the importer, are not limited. The operator can lift the limits with the composition_limits_override setting.
+
+package composition
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Kinds of the content that is limited.
+const (
+	KindThread = "threads"
+	KindPost   = "posts"
+	KindVote   = "votes"
+)
+
+// window is how long the content created is counted for.
+const window = time.Minute
+
+// LimitError is the error of content that is over the limit of its kind. The content can be created again after RetryAfter.
+type LimitError struct {
+	Kind       string
+	Limit      int
+	Network    string
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("You can create %d %s a minute in this network, so that the other nodes don't take you for a spammer. Try again in %d seconds. Network: %s", e.Limit, e.Kind, int64((e.RetryAfter+time.Second-1)/time.Second), e.Network)
+}
+
+// Usage is how much of its limit each kind used in the last minute.
+type Usage struct {
+	Network  string         `json:"network"`
+	Override bool           `json:"override"`         // The operator lifted the limits.
+	Limits   map[string]int `json:"limits"`           // 0 is no limit.
+	Used     map[string]int `json:"used"`             // In the last minute.
+	Policy   string         `json:"policy,omitempty"` // The network of the policy that applies. Empty if none does.
+}
+
+var lock sync.Mutex
+var created = make(map[string][]time.Time)
+
+// network is the name of the network the node is in, as the policies name it.
+func network() string {
+	if len(globals.NetworkId) == 0 {
+		return "public"
+	}
+	return globals.NetworkId
+}
+
+// Policy gives the policy that applies to the network the node is in, and false if none does.
+func Policy() (globals.CompositionPolicy, bool) {
+	var fallback globals.CompositionPolicy
+	found := false
+	for _, p := range globals.CompositionPolicies {
+		if p.Network == network() {
+			return p, true
+		}
+		if p.Network == "*" {
+			fallback, found = p, true
+		}
+	}
+	return fallback, found
+}
+
+func limitOf(p globals.CompositionPolicy, kind string) int {
+	switch kind {
+	case KindThread:
+		return p.ThreadsPerMinute
+	case KindPost:
+		return p.PostsPerMinute
+	case KindVote:
+		return p.VotesPerMinute
+	}
+	return 0
+}
+
+// recent drops the content of the kind created before the window, and gives what is left. The caller holds the lock.
+func recent(kind string, now time.Time) []time.Time {
+	times := created[kind]
+	i := 0
+	for i < len(times) && now.Sub(times[i]) >= window {
+		i++
+	}
+	created[kind] = times[i:]
+	return created[kind]
+}
+
+// Allow counts a piece of content of the kind against its limit, and gives a LimitError if the user has to wait before creating it.
+func Allow(kind string) error {
+	lock.Lock()
+	defer lock.Unlock()
+	now := clock.Now()
+	times := recent(kind, now)
+	p, found := Policy()
+	if found && !globals.CompositionLimitsOverride {
+		if limit := limitOf(p, kind); limit > 0 && len(times) >= limit {
+			return &LimitError{Kind: kind, Limit: limit, Network: network(), RetryAfter: window - now.Sub(times[len(times)-limit])}
+		}
+	}
+	created[kind] = append(times, now)
+	return nil
+}
+
+// Current gives the limits that apply, and how much of them the user used in the last minute.
+func Current() Usage {
+	lock.Lock()
+	defer lock.Unlock()
+	now := clock.Now()
+	u := Usage{Network: network(), Override: globals.CompositionLimitsOverride, Limits: make(map[string]int), Used: make(map[string]int)}
+	p, found := Policy()
+	if found {
+		u.Policy = p.Network
+	}
+	for _, kind := range []string{KindThread, KindPost, KindVote} {
+		u.Used[kind] = len(recent(kind, now))
+		if found {
+			u.Limits[kind] = limitOf(p, kind)
+		}
+	}
+	return u
+}
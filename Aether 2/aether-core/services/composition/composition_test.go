@@ -0,0 +1,90 @@
+package composition_test
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/composition"
+	"aether-core/services/globals"
+	"errors"
+	"testing"
+	"time"
+)
+
+// start is where the clock of the next test starts. Each test starts an hour after the one before, so that what the ones before created has expired.
+var start = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func setup(t *testing.T, policies []globals.CompositionPolicy) *clock.MockClock {
+	start = start.Add(time.Hour)
+	c := clock.NewMockClock(start)
+	clock.Set(c)
+	globals.NetworkId = ""
+	globals.CompositionPolicies = policies
+	globals.CompositionLimitsOverride = false
+	t.Cleanup(func() {
+		clock.Reset()
+		globals.CompositionPolicies = []globals.CompositionPolicy{}
+	})
+	return c
+}
+
+func TestAllow_Success(t *testing.T) {
+	c := setup(t, []globals.CompositionPolicy{{Network: "*", PostsPerMinute: 2}})
+	for i := 0; i < 2; i++ {
+		if err := composition.Allow(composition.KindPost); err != nil {
+			t.Fatal(err)
+		}
+		c.Advance(10 * time.Second)
+	}
+	err := composition.Allow(composition.KindPost)
+	var limitErr *composition.LimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("The post over the limit was allowed. Error: %v", err)
+	}
+	if limitErr.RetryAfter != 40*time.Second {
+		t.Errorf("The post over the limit is not told to wait until the first one expires. Retry after: %s", limitErr.RetryAfter)
+	}
+	if err2 := composition.Allow(composition.KindVote); err2 != nil {
+		t.Errorf("A vote was limited by the limit of the posts. Error: %s", err2)
+	}
+	c.Advance(40 * time.Second)
+	if err3 := composition.Allow(composition.KindPost); err3 != nil {
+		t.Errorf("A post was not allowed after the first one expired. Error: %s", err3)
+	}
+}
+
+func TestAllow_Success_NetworkPolicy(t *testing.T) {
+	setup(t, []globals.CompositionPolicy{{Network: "*", ThreadsPerMinute: 1}, {Network: "my network", ThreadsPerMinute: 3}})
+	globals.NetworkId = "my network"
+	defer func() { globals.NetworkId = "" }()
+	for i := 0; i < 3; i++ {
+		if err := composition.Allow(composition.KindThread); err != nil {
+			t.Fatalf("The policy of the network did not win over the one of every network. Error: %s", err)
+		}
+	}
+	if composition.Allow(composition.KindThread) == nil {
+		t.Errorf("The thread over the limit of the network was allowed.")
+	}
+}
+
+func TestAllow_Success_Override(t *testing.T) {
+	setup(t, []globals.CompositionPolicy{{Network: "public", VotesPerMinute: 1}})
+	globals.CompositionLimitsOverride = true
+	for i := 0; i < 5; i++ {
+		if err := composition.Allow(composition.KindVote); err != nil {
+			t.Fatalf("A vote was limited while the limits were lifted. Error: %s", err)
+		}
+	}
+	if u := composition.Current(); u.Used[composition.KindVote] != 5 || !u.Override {
+		t.Errorf("The usage is not counted while the limits are lifted. Usage: %#v", u)
+	}
+}
+
+func TestAllow_Fail_Limited(t *testing.T) {
+	setup(t, []globals.CompositionPolicy{{Network: "public", VotesPerMinute: 1}, {Network: "*", VotesPerMinute: 100}})
+	composition.Allow(composition.KindVote)
+	if composition.Allow(composition.KindVote) == nil {
+		t.Errorf("The vote over the limit of the public network was allowed.")
+	}
+	if u := composition.Current(); u.Used[composition.KindVote] != 1 || u.Limits[composition.KindVote] != 1 || u.Policy != "public" {
+		t.Errorf("The refused vote was counted, or the usage is wrong. Usage: %#v", u)
+	}
+}
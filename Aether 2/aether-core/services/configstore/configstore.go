@@ -4,7 +4,19 @@
 package configstore
 
 import (
-// "github.com/spf13/viper"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"aether-core/services/scheduling"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	// "github.com/spf13/viper"
 )
 
 /*
@@ -14,3 +26,766 @@ This package handles any data that gets saved to the user profile. This is impor
 func CreateUser() {
 
 }
+
+/*
+The config file is a JSON object in the user directory, mapping the names of the settings below to their values. Whatever is not in the file keeps its default from globals.
+
+The file is read at start, and then watched. When it changes, the settings that can safely change at runtime are applied right away. The ones that can't (the ones that are read once at start, like the listeners) are reported as requiring a restart, and they are left alone until then. If any of the changed values is invalid, none of the changes are applied.
+*/
+
+// setting is a single entry of the config file. Set validates the value and applies it; get and restore are used to roll back a change that has to be undone.
+type setting struct {
+	live    bool // Whether the setting can change at runtime.
+	set     func(raw json.RawMessage) error
+	get     func() interface{}
+	restore func(v interface{})
+}
+
+func intSetting(ptr *int, min int, max int, live bool) setting {
+	return setting{
+		live: live,
+		set: func(raw json.RawMessage) error {
+			var v int
+			err := json.Unmarshal(raw, &v)
+			if err != nil {
+				return err
+			}
+			if v < min || v > max {
+				return errors.New(fmt.Sprintf("The value is out of range. Value: %d, Range: %d-%d", v, min, max))
+			}
+			*ptr = v
+			return nil
+		},
+		get:     func() interface{} { return *ptr },
+		restore: func(v interface{}) { *ptr = v.(int) },
+	}
+}
+
+func int64Setting(ptr *int64, min int64, live bool) setting {
+	return setting{
+		live: live,
+		set: func(raw json.RawMessage) error {
+			var v int64
+			err := json.Unmarshal(raw, &v)
+			if err != nil {
+				return err
+			}
+			if v < min {
+				return errors.New(fmt.Sprintf("The value is too small. Value: %d, Minimum: %d", v, min))
+			}
+			*ptr = v
+			return nil
+		},
+		get:     func() interface{} { return *ptr },
+		restore: func(v interface{}) { *ptr = v.(int64) },
+	}
+}
+
+func boolSetting(ptr *bool, live bool) setting {
+	return setting{
+		live: live,
+		set: func(raw json.RawMessage) error {
+			return json.Unmarshal(raw, ptr)
+		},
+		get:     func() interface{} { return *ptr },
+		restore: func(v interface{}) { *ptr = v.(bool) },
+	}
+}
+
+func stringSetting(ptr *string, live bool) setting {
+	return setting{
+		live: live,
+		set: func(raw json.RawMessage) error {
+			return json.Unmarshal(raw, ptr)
+		},
+		get:     func() interface{} { return *ptr },
+		restore: func(v interface{}) { *ptr = v.(string) },
+	}
+}
+
+// durationSetting reads durations in the form Go writes them, such as "90s" or "6h".
+func durationSetting(ptr *time.Duration, min time.Duration, live bool) setting {
+	return setting{
+		live: live,
+		set: func(raw json.RawMessage) error {
+			var str string
+			err := json.Unmarshal(raw, &str)
+			if err != nil {
+				return err
+			}
+			v, err2 := time.ParseDuration(str)
+			if err2 != nil {
+				return err2
+			}
+			if v < min {
+				return errors.New(fmt.Sprintf("The duration is too short. Value: %s, Minimum: %s", v, min))
+			}
+			*ptr = v
+			return nil
+		},
+		get:     func() interface{} { return *ptr },
+		restore: func(v interface{}) { *ptr = v.(time.Duration) },
+	}
+}
+
+func entityPageSizesSetting() setting {
+	return setting{
+		live: true,
+		set: func(raw json.RawMessage) error {
+			// Start from the current sizes, so that the file only needs to have the ones that change.
+			sizes := globals.EntityPageSizesObj
+			err := json.Unmarshal(raw, &sizes)
+			if err != nil {
+				return err
+			}
+			s := sizes
+			for _, size := range []int{s.Boards, s.BoardIndexes, s.Threads, s.ThreadIndexes, s.Posts, s.PostIndexes, s.Votes, s.VoteIndexes, s.Addresses, s.AddressIndexes, s.Keys, s.KeyIndexes, s.Truststates, s.TruststateIndexes, s.Tombstones, s.TombstoneIndexes} {
+				if size <= 0 {
+					return errors.New("Page sizes have to be larger than 0.")
+				}
+			}
+			globals.EntityPageSizesObj = sizes
+			return nil
+		},
+		get:     func() interface{} { return globals.EntityPageSizesObj },
+		restore: func(v interface{}) { globals.EntityPageSizesObj = v.(globals.EntityPageSizes) },
+	}
+}
+
+// intMapSetting reads an object of integers, such as {"verify": 100}. The file gives the whole map, not the changes to it.
+func intMapSetting(ptr *map[string]int, min int, live bool) setting {
+	return setting{
+		live: live,
+		set: func(raw json.RawMessage) error {
+			var v map[string]int
+			err := json.Unmarshal(raw, &v)
+			if err != nil {
+				return err
+			}
+			for key, value := range v {
+				if value < min {
+					return errors.New(fmt.Sprintf("The value is too small. Key: %s, Value: %d, Minimum: %d", key, value, min))
+				}
+			}
+			if v == nil {
+				v = make(map[string]int)
+			}
+			*ptr = v
+			return nil
+		},
+		get:     func() interface{} { return *ptr },
+		restore: func(v interface{}) { *ptr = v.(map[string]int) },
+	}
+}
+
+// stringListSetting reads a list of strings. The file gives the whole list, not the changes to it.
+func stringListSetting(ptr *[]string, live bool) setting {
+	return setting{
+		live: live,
+		set: func(raw json.RawMessage) error {
+			var v []string
+			err := json.Unmarshal(raw, &v)
+			if err != nil {
+				return err
+			}
+			if v == nil {
+				v = []string{}
+			}
+			*ptr = v
+			return nil
+		},
+		get:     func() interface{} { return *ptr },
+		restore: func(v interface{}) { *ptr = v.([]string) },
+	}
+}
+
+// stringMapSetting reads an object of strings. The file gives the whole map, not the changes to it.
+func stringMapSetting(ptr *map[string]string, live bool) setting {
+	return setting{
+		live: live,
+		set: func(raw json.RawMessage) error {
+			var v map[string]string
+			err := json.Unmarshal(raw, &v)
+			if err != nil {
+				return err
+			}
+			if v == nil {
+				v = make(map[string]string)
+			}
+			*ptr = v
+			return nil
+		},
+		get:     func() interface{} { return *ptr },
+		restore: func(v interface{}) { *ptr = v.(map[string]string) },
+	}
+}
+
+func listenersSetting() setting {
+	return setting{
+		live: false,
+		set: func(raw json.RawMessage) error {
+			var listeners []globals.Listener
+			err := json.Unmarshal(raw, &listeners)
+			if err != nil {
+				return err
+			}
+			if len(listeners) == 0 {
+				return errors.New("There has to be at least one listener.")
+			}
+			globals.Listeners = listeners
+			return nil
+		},
+		get:     func() interface{} { return globals.Listeners },
+		restore: func(v interface{}) { globals.Listeners = v.([]globals.Listener) },
+	}
+}
+
+// advertisedEndpointsSetting reads the endpoints advertised to the remotes. Each has to have a location, a port, and a location type of 4 (IPv4), 6 (IPv6) or 3 (onion).
+func advertisedEndpointsSetting() setting {
+	return setting{
+		live: true,
+		set: func(raw json.RawMessage) error {
+			var endpoints []globals.AdvertisedEndpoint
+			err := json.Unmarshal(raw, &endpoints)
+			if err != nil {
+				return err
+			}
+			for _, e := range endpoints {
+				if len(e.Location) == 0 || e.Port == 0 {
+					return errors.New(fmt.Sprintf("An advertised endpoint has to have a location and a port. Endpoint: %#v", e))
+				}
+				if e.LocationType != 3 && e.LocationType != 4 && e.LocationType != 6 {
+					return errors.New(fmt.Sprintf("The location type of an advertised endpoint has to be 4 (IPv4), 6 (IPv6) or 3 (onion). Endpoint: %#v", e))
+				}
+			}
+			if endpoints == nil {
+				endpoints = []globals.AdvertisedEndpoint{}
+			}
+			globals.AdvertisedEndpoints = endpoints
+			return nil
+		},
+		get:     func() interface{} { return globals.AdvertisedEndpoints },
+		restore: func(v interface{}) { globals.AdvertisedEndpoints = v.([]globals.AdvertisedEndpoint) },
+	}
+}
+
+// maintenanceWindowsSetting reads the maintenance windows, a list of cron expressions. They are parsed here, so that an invalid one is refused with the rest of the changes instead of being skipped later.
+func maintenanceWindowsSetting() setting {
+	return setting{
+		live: true,
+		set: func(raw json.RawMessage) error {
+			var windows []string
+			err := json.Unmarshal(raw, &windows)
+			if err != nil {
+				return err
+			}
+			for _, w := range windows {
+				_, err2 := scheduling.ParseWindow(w)
+				if err2 != nil {
+					return err2
+				}
+			}
+			if windows == nil {
+				windows = []string{}
+			}
+			globals.MaintenanceWindows = windows
+			return nil
+		},
+		get:     func() interface{} { return globals.MaintenanceWindows },
+		restore: func(v interface{}) { globals.MaintenanceWindows = v.([]string) },
+	}
+}
+
+// servingModeSetting reads the serving mode, which is either "full" or "light".
+func servingModeSetting() setting {
+	return setting{
+		live: true,
+		set: func(raw json.RawMessage) error {
+			var mode string
+			err := json.Unmarshal(raw, &mode)
+			if err != nil {
+				return err
+			}
+			if mode != "full" && mode != "light" {
+				return errors.New(fmt.Sprintf("The serving mode has to be full or light. Serving mode: %s", mode))
+			}
+			globals.ServingMode = mode
+			return nil
+		},
+		get:     func() interface{} { return globals.ServingMode },
+		restore: func(v interface{}) { globals.ServingMode = v.(string) },
+	}
+}
+
+// choiceSetting is a string setting that has to be one of the given choices.
+func choiceSetting(ptr *string, choices []string, live bool) setting {
+	return setting{
+		live: live,
+		set: func(raw json.RawMessage) error {
+			var v string
+			err := json.Unmarshal(raw, &v)
+			if err != nil {
+				return err
+			}
+			for _, c := range choices {
+				if v == c {
+					*ptr = v
+					return nil
+				}
+			}
+			return errors.New(fmt.Sprintf("The value has to be one of %s. Value: %s", strings.Join(choices, ", "), v))
+		},
+		get:     func() interface{} { return *ptr },
+		restore: func(v interface{}) { *ptr = v.(string) },
+	}
+}
+
+// voteSyncPoliciesSetting reads the vote sync policies. A rule has to name a board by its fingerprint, or every board with "*".
+func voteSyncPoliciesSetting() setting {
+	return setting{
+		live: true,
+		set: func(raw json.RawMessage) error {
+			var policies []globals.VoteSyncPolicy
+			err := json.Unmarshal(raw, &policies)
+			if err != nil {
+				return err
+			}
+			for _, p := range policies {
+				if p.Board != "*" && len(p.Board) != 64 {
+					return errors.New(fmt.Sprintf("A vote sync policy has to be for a board fingerprint, or for \"*\". Board: %s", p.Board))
+				}
+				if p.ServeMaxAge < 0 {
+					return errors.New(fmt.Sprintf("The age of the votes served can't be negative. Board: %s, Age: %d", p.Board, p.ServeMaxAge))
+				}
+			}
+			if policies == nil {
+				policies = []globals.VoteSyncPolicy{}
+			}
+			globals.VoteSyncPolicies = policies
+			return nil
+		},
+		get:     func() interface{} { return globals.VoteSyncPolicies },
+		restore: func(v interface{}) { globals.VoteSyncPolicies = v.([]globals.VoteSyncPolicy) },
+	}
+}
+
+// compositionPoliciesSetting reads the composition policies. A policy has to name a network, and a network can have only one.
+func compositionPoliciesSetting() setting {
+	return setting{
+		live: true,
+		set: func(raw json.RawMessage) error {
+			var policies []globals.CompositionPolicy
+			err := json.Unmarshal(raw, &policies)
+			if err != nil {
+				return err
+			}
+			seen := make(map[string]bool)
+			for _, p := range policies {
+				if len(p.Network) == 0 {
+					return errors.New("A composition policy has to be for \"public\", for a private network by its id, or for \"*\".")
+				}
+				if seen[p.Network] {
+					return errors.New(fmt.Sprintf("There is more than one composition policy for this network. Network: %s", p.Network))
+				}
+				seen[p.Network] = true
+				if p.ThreadsPerMinute < 0 || p.PostsPerMinute < 0 || p.VotesPerMinute < 0 {
+					return errors.New(fmt.Sprintf("The limits of a composition policy can't be negative. Network: %s", p.Network))
+				}
+			}
+			if policies == nil {
+				policies = []globals.CompositionPolicy{}
+			}
+			globals.CompositionPolicies = policies
+			return nil
+		},
+		get:     func() interface{} { return globals.CompositionPolicies },
+		restore: func(v interface{}) { globals.CompositionPolicies = v.([]globals.CompositionPolicy) },
+	}
+}
+
+// minPoWStrengthsSetting reads the minimum PoW strengths by entity type, such as {"Post": 24}. The entity types are the field names of globals.MinPoWStrengthsStruct, and the types not given keep their strengths.
+func minPoWStrengthsSetting() setting {
+	return setting{
+		live: true,
+		set: func(raw json.RawMessage) error {
+			var strengths map[string]int64
+			err := json.Unmarshal(raw, &strengths)
+			if err != nil {
+				return err
+			}
+			for entityType, strength := range strengths {
+				err2 := globals.SetMinPoWStrength(entityType, strength)
+				if err2 != nil {
+					return err2
+				}
+			}
+			return nil
+		},
+		get:     func() interface{} { return globals.MinPoWStrengths },
+		restore: func(v interface{}) { globals.MinPoWStrengths = v.(globals.MinPoWStrengthsStruct) },
+	}
+}
+
+// outputEncodersSetting reads the encoders of the destinations. The names of the encoders are checked when they are used, since the encoders are registered by the backend; an unknown one falls back to compact JSON.
+func outputEncodersSetting() setting {
+	return setting{
+		live: true,
+		set: func(raw json.RawMessage) error {
+			var v map[string]string
+			err := json.Unmarshal(raw, &v)
+			if err != nil {
+				return err
+			}
+			for destination, name := range v {
+				if destination != "responses" && destination != "caches" {
+					return errors.New(fmt.Sprintf("The destinations of the output encoders are \"responses\" and \"caches\". Destination: %s", destination))
+				}
+				if len(name) == 0 {
+					return errors.New(fmt.Sprintf("The encoder of a destination can't be empty. Destination: %s", destination))
+				}
+			}
+			if v == nil {
+				v = make(map[string]string)
+			}
+			globals.OutputEncoders = v
+			return nil
+		},
+		get:     func() interface{} { return globals.OutputEncoders },
+		restore: func(v interface{}) { globals.OutputEncoders = v.(map[string]string) },
+	}
+}
+
+// settings are all the settings that can be given in the config file.
+func settings() map[string]setting {
+	return map[string]setting{
+		// Applied at runtime.
+		"logging_level":                    intSetting(&globals.LoggingLevel, 0, 2, true),
+		"cache_generation_verbose":         boolSetting(&globals.CacheGenerationVerbose, true),
+		"cache_encoding_workers":           intSetting(&globals.CacheEncodingWorkers, 1, 64, true),
+		"output_encoders":                  outputEncodersSetting(),
+		"entity_page_sizes":                entityPageSizesSetting(),
+		"page_byte_budget":                 intSetting(&globals.PageByteBudget, 0, 1<<30, true),
+		"post_response_expiry_minutes":     intSetting(&globals.PostResponseExpiryMinutes, 1, 24*60, true),
+		"post_paged_read_threshold":        intSetting(&globals.POSTPagedReadThreshold, 1, 1<<30, true),
+		"post_inline_max_bytes":            int64Setting(&globals.POSTInlineMaxBytes, 0, true),
+		"post_inline_preferred_bytes":      int64Setting(&globals.POSTInlinePreferredBytes, 0, true),
+		"send_client_headers":              boolSetting(&globals.SendClientHeaders, true),
+		"peer_clients_kept":                intSetting(&globals.PeerClientsKept, 1, 1<<20, true),
+		"privacy_mode":                     boolSetting(&globals.PrivacyMode, true),
+		"serving_mode":                     servingModeSetting(),
+		"backup_destination":               stringSetting(&globals.BackupDestination, true),
+		"backup_full_every":                intSetting(&globals.BackupFullEvery, 1, 1000, true),
+		"backups_kept":                     intSetting(&globals.BackupsKept, 1, 1000, true),
+		"validation_field_maxima":          intMapSetting(&globals.ValidationFieldMaxima, 0, true),
+		"validation_max_filters":           intSetting(&globals.ValidationMaxFilters, 1, 1000, true),
+		"validation_max_filter_values":     intSetting(&globals.ValidationMaxFilterValues, 1, 1<<20, true),
+		"validation_max_result_caches":     intSetting(&globals.ValidationMaxResultCaches, 1, 1<<20, true),
+		"job_workers":                      intSetting(&globals.JobWorkers, 1, 64, true),
+		"job_history_kept":                 intSetting(&globals.JobHistoryKept, 0, 1<<20, true),
+		"job_retry_backoff":                durationSetting(&globals.JobRetryBackoff, 0, true),
+		"replica_max_lag":                  durationSetting(&globals.ReplicaMaxLag, time.Second, true),
+		"text_inbound_policy":              choiceSetting(&globals.TextInboundPolicy, []string{"drop", "flag", "accept"}, true),
+		"text_markup_policy":               choiceSetting(&globals.TextMarkupPolicy, []string{"allow", "strip", "reject"}, true),
+		"reply_tree_depth":                 intSetting(&globals.ReplyTreeDepth, 1, 128, true),
+		"reply_tree_page_size":             intSetting(&globals.ReplyTreePageSize, 1, 1<<20, true),
+		"reply_tree_max_page_size":         intSetting(&globals.ReplyTreeMaxPageSize, 1, 1<<20, true),
+		"vote_sync_policies":               voteSyncPoliciesSetting(),
+		"witness_enabled":                  boolSetting(&globals.WitnessEnabled, true),
+		"cache_witnesses":                  stringListSetting(&globals.CacheWitnesses, true),
+		"trusted_witnesses":                stringMapSetting(&globals.TrustedWitnesses, true),
+		"cache_witness_max_delay":          durationSetting(&globals.CacheWitnessMaxDelay, time.Minute, true),
+		"public_api_requests_per_minute":   intSetting(&globals.PublicApiRequestsPerMinute, 1, 1<<20, true),
+		"public_api_default_page_size":     intSetting(&globals.PublicApiDefaultPageSize, 1, 1<<20, true),
+		"public_api_max_page_size":         intSetting(&globals.PublicApiMaxPageSize, 1, 1<<20, true),
+		"pex_sample_size":                  intSetting(&globals.PexSampleSize, 0, 1000, true),
+		"inbound_max_page_bytes":           int64Setting(&globals.InboundMaxPageBytes, 1024, true),
+		"inbound_max_page_entities":        intSetting(&globals.InboundMaxPageEntities, 1, 1<<30, true),
+		"inbound_max_field_bytes":          intSetting(&globals.InboundMaxFieldBytes, 1, 1<<30, true),
+		"inbound_max_cache_pages":          intSetting(&globals.InboundMaxCachePages, 1, 1<<30, true),
+		"inbound_max_redirects":            intSetting(&globals.InboundMaxRedirects, 0, 20, true),
+		"vote_compaction_age_days":         intSetting(&globals.VoteCompactionAgeDays, 1, 100000, true),
+		"log_sample_limit":                 intSetting(&globals.LogSampleLimit, 0, 1<<20, true),
+		"log_sample_component_limits":      intMapSetting(&globals.LogSampleComponentLimits, 0, true),
+		"log_sample_window":                durationSetting(&globals.LogSampleWindow, time.Second, true),
+		"response_binding_window":          durationSetting(&globals.ResponseBindingWindow, time.Minute, true),
+		"require_response_binding":         boolSetting(&globals.RequireResponseBinding, true),
+		"sign_responses":                   boolSetting(&globals.SignResponses, true),
+		"require_response_signatures":      boolSetting(&globals.RequireResponseSignatures, true),
+		"sync_priorities":                  intMapSetting(&globals.SyncPriorities, 0, true),
+		"orphan_max_wanted":                intSetting(&globals.OrphanMaxWanted, 0, 1<<20, true),
+		"orphan_max_attempts":              intSetting(&globals.OrphanMaxAttempts, 1, 1000, true),
+		"orphan_fetch_batch_size":          intSetting(&globals.OrphanFetchBatchSize, 1, 1000, true),
+		"fingerprints_per_request":         intSetting(&globals.FingerprintQueryMaxPerRequest, 0, 1<<20, true),
+		"fingerprints_per_hour":            intSetting(&globals.FingerprintQueryMaxPerHour, 0, 1<<30, true),
+		"fingerprint_scan_run_length":      intSetting(&globals.FingerprintScanRunLength, 0, 1<<20, true),
+		"slow_query_threshold":             durationSetting(&globals.SlowQueryThreshold, 0, true),
+		"slow_query_log_parameters":        boolSetting(&globals.SlowQueryLogParameters, true),
+		"storage_report_largest":           intSetting(&globals.StorageReportLargest, 0, 1000, true),
+		"maintenance_windows":              maintenanceWindowsSetting(),
+		"advertised_endpoints":             advertisedEndpointsSetting(),
+		"endpoint_failure_threshold":       intSetting(&globals.EndpointFailureThreshold, 1, 1000, true),
+		"endpoint_failure_backoff":         durationSetting(&globals.EndpointFailureBackoff, 0, true),
+		"maintenance_stagger":              durationSetting(&globals.MaintenanceStagger, 0, true),
+		"pagination_legacy_pages":          boolSetting(&globals.PaginationLegacyPages, true),
+		"lazy_cache_repair":                boolSetting(&globals.LazyCacheRepair, true),
+		"cache_repair_cooldown":            durationSetting(&globals.CacheRepairCooldown, 0, true),
+		"cache_retired_grace":              durationSetting(&globals.CacheRetiredGrace, 0, true),
+		"statics_orphan_grace":             durationSetting(&globals.StaticsOrphanGrace, 0, true),
+		"cache_dedup_enabled":              boolSetting(&globals.CacheDedupEnabled, true),
+		"adaptive_sync_enabled":            boolSetting(&globals.AdaptiveSyncEnabled, true),
+		"sync_interval":                    durationSetting(&globals.SyncInterval, time.Second, true),
+		"sync_interval_min":                durationSetting(&globals.SyncIntervalMin, time.Second, true),
+		"sync_interval_max":                durationSetting(&globals.SyncIntervalMax, time.Second, true),
+		"sync_busy_entities":               intSetting(&globals.SyncBusyEntities, 1, 1<<30, true),
+		"session_digests_enabled":          boolSetting(&globals.SessionDigestsEnabled, true),
+		"response_store":                   choiceSetting(&globals.ResponseStore, []string{"files", "db"}, true),
+		"response_store_quota_bytes":       int64Setting(&globals.ResponseStoreQuotaBytes, 0, true),
+		"handshake_enabled":                boolSetting(&globals.HandshakeEnabled, true),
+		"handshake_max_clock_skew":         durationSetting(&globals.HandshakeMaxClockSkew, 0, true),
+		"live_cache_enabled":               boolSetting(&globals.LiveCacheEnabled, true),
+		"live_cache_window":                durationSetting(&globals.LiveCacheWindow, time.Minute, true),
+		"live_cache_max_entities":          intSetting(&globals.LiveCacheMaxEntities, 0, 10000000, true),
+		"ingest_coalescing_enabled":        boolSetting(&globals.IngestCoalescingEnabled, true),
+		"provenance_enabled":               boolSetting(&globals.ProvenanceEnabled, true),
+		"provenance_retention_days":        intSetting(&globals.ProvenanceRetentionDays, 0, 36500, true),
+		"load_shedding_enabled":            boolSetting(&globals.LoadSheddingEnabled, true),
+		"serving_max_concurrent":           intSetting(&globals.ServingMaxConcurrent, 1, 100000, true),
+		"serving_queue_max":                intSetting(&globals.ServingQueueMax, 0, 1000000, true),
+		"serving_queue_timeout":            durationSetting(&globals.ServingQueueTimeout, time.Second, true),
+		"load_shedding_retry_after":        durationSetting(&globals.LoadSheddingRetryAfter, time.Second, true),
+		"address_liveness_days":            intSetting(&globals.AddressLivenessDays, 0, 36500, true),
+		"address_purge_days":               intSetting(&globals.AddressPurgeDays, 0, 36500, true),
+		"response_hook_timeout":            durationSetting(&globals.ResponseHookTimeout, 10*time.Millisecond, true),
+		"response_hook_max_failures":       intSetting(&globals.ResponseHookMaxFailures, 0, 1000000, true),
+		"cache_archive_after_months":       intSetting(&globals.CacheArchiveAfterMonths, 0, 1200, true),
+		"cache_archive_location":           stringSetting(&globals.CacheArchiveLocation, true),
+		"cache_archive_cached_pages":       intSetting(&globals.CacheArchiveCachedPages, 0, 1000000, true),
+		"drafts_max_per_profile":           intSetting(&globals.DraftsMaxPerProfile, 0, 1000000, true),
+		"watches_max_per_profile":          intSetting(&globals.WatchesMaxPerProfile, 0, 1000000, true),
+		"watch_feed_page_size":             intSetting(&globals.WatchFeedPageSize, 1, 100000, true),
+		"watch_feed_max_page_size":         intSetting(&globals.WatchFeedMaxPageSize, 1, 100000, true),
+		"composition_policies":             compositionPoliciesSetting(),
+		"min_pow_strengths":                minPoWStrengthsSetting(),
+		"composition_limits_override":      boolSetting(&globals.CompositionLimitsOverride, true),
+		"telemetry_enabled":                boolSetting(&globals.TelemetryEnabled, true),
+		"telemetry_collector":              stringSetting(&globals.TelemetryCollector, true),
+		"telemetry_interval":               durationSetting(&globals.TelemetryInterval, time.Hour, true),
+		"telemetry_include_version":        boolSetting(&globals.TelemetryIncludeVersion, true),
+		"telemetry_include_entity_totals":  boolSetting(&globals.TelemetryIncludeEntityTotals, true),
+		"fingerprint_manifests_enabled":    boolSetting(&globals.FingerprintManifestsEnabled, true),
+		"fingerprint_manifest_max_entries": intSetting(&globals.FingerprintManifestMaxEntries, 1, 1000000, true),
+		"cache_page_range_max_pages":       intSetting(&globals.CachePageRangeMaxPages, 0, 10000, true),
+		"cache_resume_free_disk_bytes":     int64Setting(&globals.CacheResumeFreeDiskBytes, 0, true),
+		"cache_retention_days":             intSetting(&globals.CacheRetentionDays, 0, 100000, true),
+		"importer_max_items_per_minute":    intSetting(&globals.ImporterMaxItemsPerMinute, 1, 10000, true),
+		"profile_snapshots_kept":           intSetting(&globals.ProfileSnapshotsKept, 1, 10000, true),
+		"peer_whitelist_only":              boolSetting(&globals.PeerWhitelistOnly, true),
+		"connection_timeout":               durationSetting(&globals.ConnectionTimeout, 100*time.Millisecond, true),
+		"dispatcher_exclusion_live_expiry": durationSetting(&globals.DispatcherExclusionsExpiryLiveAddress, 0, true),
+		// Read once at start. These need a restart.
+		"listeners":                 listenersSetting(),
+		"network_id":                stringSetting(&globals.NetworkId, false),
+		"network_membership_key":    stringSetting(&globals.NetworkMembershipKey, false),
+		"public_api_enabled":        boolSetting(&globals.PublicApiEnabled, false),
+		"importer_enabled":          boolSetting(&globals.ImporterEnabled, false),
+		"importer_bridge_key_file":  stringSetting(&globals.ImporterBridgeKeyFile, false),
+		"events_enabled":            boolSetting(&globals.EventsEnabled, false),
+		"cdn_enabled":               boolSetting(&globals.CdnEnabled, false),
+		"lan_discovery_enabled":     boolSetting(&globals.LanDiscoveryEnabled, false),
+		"vote_compaction_enabled":   boolSetting(&globals.VoteCompactionEnabled, false),
+		"profile_snapshots_enabled": boolSetting(&globals.ProfileSnapshotsEnabled, false),
+		"profile_snapshot_interval": durationSetting(&globals.ProfileSnapshotInterval, time.Minute, false),
+		"orphan_fetch_interval":     durationSetting(&globals.OrphanFetchInterval, time.Second, false),
+		"create_missing_indexes":    boolSetting(&globals.CreateMissingIndexes, false),
+		"index_large_table_rows":    intSetting(&globals.IndexLargeTableRows, 0, 1<<30, false),
+		"index_progress_interval":   durationSetting(&globals.IndexProgressInterval, time.Second, false),
+		"backup_enabled":            boolSetting(&globals.BackupEnabled, false),
+		"backup_interval":           durationSetting(&globals.BackupInterval, time.Minute, false),
+		"replica_check_interval":    durationSetting(&globals.ReplicaCheckInterval, time.Second, false),
+		"cache_witness_interval":    durationSetting(&globals.CacheWitnessInterval, time.Minute, false),
+	}
+}
+
+// Report is the outcome of reading the config file.
+type Report struct {
+	Applied         []string `json:"applied"`
+	RequiresRestart []string `json:"requires_restart"` // Changed in the file, but not applied until the next start.
+	Unknown         []string `json:"unknown"`
+	Error           string   `json:"error,omitempty"` // If set, nothing was applied.
+	Time            int64    `json:"time"`
+}
+
+var configLock sync.Mutex
+
+// applied are the raw values of the file as of the last time it was applied, so that only the settings that changed are looked at.
+var applied = make(map[string]string)
+var lastModified time.Time
+var lastReport Report
+
+func configPath() string {
+	return fmt.Sprint(globals.UserDirectory, "/config.json")
+}
+
+// apply applies the values. At start, every setting is applied; after that, only the ones that can change at runtime. If one fails, the ones already applied are rolled back.
+func apply(values map[string]json.RawMessage, atStart bool) Report {
+	var report Report
+	report.Time = time.Now().Unix()
+	all := settings()
+	var names []string
+	for name, _ := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	previous := make(map[string]interface{})
+	var order []string
+	for _, name := range names {
+		raw := values[name]
+		if old, ok := applied[name]; ok && old == string(raw) {
+			continue
+		}
+		s, ok := all[name]
+		if !ok {
+			report.Unknown = append(report.Unknown, name)
+			continue
+		}
+		if !s.live && !atStart {
+			report.RequiresRestart = append(report.RequiresRestart, name)
+			continue
+		}
+		previous[name] = s.get()
+		order = append(order, name)
+		err := s.set(raw)
+		if err != nil {
+			// Roll back everything this round has changed, in reverse.
+			for i := len(order) - 1; i >= 0; i-- {
+				all[order[i]].restore(previous[order[i]])
+			}
+			report.Applied = nil
+			report.Error = fmt.Sprintf("The setting %s is invalid, so none of the changes are applied. Error: %s", name, err)
+			return report
+		}
+		report.Applied = append(report.Applied, name)
+	}
+	for _, name := range report.Applied {
+		applied[name] = string(values[name])
+	}
+	return report
+}
+
+// readConfig reads the config file. A missing file is the same as an empty one.
+func readConfig() (map[string]json.RawMessage, time.Time, error) {
+	values := make(map[string]json.RawMessage)
+	info, err := os.Stat(configPath())
+	if err != nil && os.IsNotExist(err) {
+		return values, time.Time{}, nil
+	} else if err != nil {
+		return values, time.Time{}, err
+	}
+	data, err2 := ioutil.ReadFile(configPath())
+	if err2 != nil {
+		return values, time.Time{}, err2
+	}
+	err3 := json.Unmarshal(data, &values)
+	if err3 != nil {
+		return values, time.Time{}, errors.New(fmt.Sprintf("The config file is not a valid JSON object. Error: %s", err3))
+	}
+	return values, info.ModTime(), nil
+}
+
+// Load applies the config file at start. This has to run after SetGlobals, and before anything reads the settings.
+func Load() error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	values, modTime, err := readConfig()
+	if err != nil {
+		return err
+	}
+	lastModified = modTime
+	applied = make(map[string]string)
+	lastReport = apply(values, true)
+	if len(lastReport.Error) > 0 {
+		return errors.New(lastReport.Error)
+	}
+	for _, name := range lastReport.Unknown {
+		logging.Log(1, fmt.Sprintf("The config file has a setting this version does not know. It is ignored. Setting: %s", name))
+	}
+	return nil
+}
+
+// Reload applies the changes in the config file, if it has changed since it was last read. It is run on a schedule.
+func Reload() {
+	configLock.Lock()
+	defer configLock.Unlock()
+	info, err := os.Stat(configPath())
+	if err != nil || !info.ModTime().After(lastModified) {
+		return
+	}
+	values, modTime, err2 := readConfig()
+	if err2 != nil {
+		logging.Log(1, fmt.Sprintf("The config file could not be read. The current settings are kept. Error: %s", err2))
+		lastReport = Report{Error: err2.Error(), Time: time.Now().Unix()}
+		return
+	}
+	lastModified = modTime
+	lastReport = apply(values, false)
+	if len(lastReport.Error) > 0 {
+		logging.Log(1, lastReport.Error)
+		return
+	}
+	logging.Log(1, fmt.Sprintf("The config file has changed. Applied: %v, Requires restart: %v, Unknown: %v", lastReport.Applied, lastReport.RequiresRestart, lastReport.Unknown))
+}
+
+// LastReport returns the outcome of the last time the config file was read.
+func LastReport() Report {
+	configLock.Lock()
+	defer configLock.Unlock()
+	return lastReport
+}
+
+// Update writes the changes into the config file, and applies them as the file would be applied if it was edited: the settings that can change at runtime right away, and the others at the next start. Every change is checked before the file is written, so that an invalid one never gets into it, and if one is invalid, none of them are written.
+func Update(changes map[string]json.RawMessage) (Report, error) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	all := settings()
+	for name, raw := range changes {
+		s, ok := all[name]
+		if !ok {
+			return Report{}, errors.New(fmt.Sprintf("There is no such setting. Setting: %s", name))
+		}
+		previous := s.get()
+		err := s.set(raw)
+		s.restore(previous)
+		if err != nil {
+			return Report{}, errors.New(fmt.Sprintf("The setting %s is invalid, so none of the changes are written. Error: %s", name, err))
+		}
+	}
+	values, _, err2 := readConfig()
+	if err2 != nil {
+		return Report{}, err2
+	}
+	for name, raw := range changes {
+		values[name] = raw
+	}
+	data, err3 := json.MarshalIndent(values, "", "  ")
+	if err3 != nil {
+		return Report{}, err3
+	}
+	os.MkdirAll(globals.UserDirectory, 0755)
+	tmp := fmt.Sprint(configPath(), ".tmp")
+	err4 := ioutil.WriteFile(tmp, data, 0644)
+	if err4 != nil {
+		return Report{}, errors.New(fmt.Sprintf("The config file could not be written. Error: %s", err4))
+	}
+	err5 := os.Rename(tmp, configPath())
+	if err5 != nil {
+		return Report{}, errors.New(fmt.Sprintf("The config file could not be written. Error: %s", err5))
+	}
+	if info, err6 := os.Stat(configPath()); err6 == nil {
+		lastModified = info.ModTime()
+	}
+	lastReport = apply(values, false)
+	if len(lastReport.Error) > 0 {
+		return lastReport, errors.New(lastReport.Error)
+	}
+	return lastReport, nil
+}
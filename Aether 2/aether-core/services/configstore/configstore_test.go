@@ -1,3 +1,217 @@
 package configstore_test
 
-import ()
+import (
+	"aether-core/services/configstore"
+	"aether-core/services/globals"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Infrastructure, setup and teardown
+
+var tempDir string
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	tempDir, _ = ioutil.TempDir("", "configstore")
+}
+
+func teardown() {
+	os.RemoveAll(tempDir)
+}
+
+func reset(t *testing.T, config string) {
+	globals.SetGlobals()
+	globals.UserDirectory = tempDir
+	writeConfig(config, time.Now().Add(-1*time.Minute))
+	err := configstore.Load()
+	if err != nil {
+		t.Fatalf("The config file could not be loaded. Error: %s", err)
+	}
+}
+
+// writeConfig writes the config file with the given modification time, so that the change is seen regardless of the resolution of the file system clock.
+func writeConfig(config string, modTime time.Time) {
+	path := tempDir + "/config.json"
+	ioutil.WriteFile(path, []byte(config), 0644)
+	os.Chtimes(path, modTime, modTime)
+}
+
+// Tests
+
+func TestLoad_Success(t *testing.T) {
+	reset(t, `{"logging_level": 1, "public_api_enabled": true}`)
+	if globals.LoggingLevel != 1 || !globals.PublicApiEnabled {
+		t.Errorf("The config file was not applied at start.")
+	}
+}
+
+func TestLoad_Fail_InvalidValue(t *testing.T) {
+	globals.SetGlobals()
+	globals.UserDirectory = tempDir
+	writeConfig(`{"logging_level": 1, "public_api_max_page_size": -5}`, time.Now())
+	err := configstore.Load()
+	if err == nil {
+		t.Errorf("An invalid config file was loaded.")
+	}
+	if globals.LoggingLevel != 0 {
+		t.Errorf("A setting was left applied after the config file was rejected.")
+	}
+}
+
+func TestReload_Success(t *testing.T) {
+	reset(t, `{"logging_level": 0}`)
+	writeConfig(`{"logging_level": 2, "entity_page_sizes": {"Posts": 50}}`, time.Now())
+	configstore.Reload()
+	if globals.LoggingLevel != 2 || globals.EntityPageSizesObj.Posts != 50 {
+		t.Errorf("The changed settings were not applied. Report: %#v", configstore.LastReport())
+	}
+	if globals.EntityPageSizesObj.Threads == 0 {
+		t.Errorf("A page size not given in the config file was lost.")
+	}
+}
+
+func TestReload_Fail_RollsBack(t *testing.T) {
+	reset(t, `{}`)
+	previousSizes := globals.EntityPageSizesObj
+	writeConfig(`{"entity_page_sizes": {"Posts": 50}, "logging_level": 1, "public_api_max_page_size": 0}`, time.Now())
+	configstore.Reload()
+	report := configstore.LastReport()
+	if len(report.Error) == 0 {
+		t.Errorf("An invalid setting was accepted.")
+	}
+	if globals.LoggingLevel != 0 || globals.EntityPageSizesObj != previousSizes || len(report.Applied) != 0 {
+		t.Errorf("The valid settings of a rejected change were left applied.")
+	}
+}
+
+func TestReload_Success_ReportsRestart(t *testing.T) {
+	reset(t, `{"public_api_enabled": false}`)
+	writeConfig(`{"public_api_enabled": true, "logging_level": 1, "some_setting": 5}`, time.Now())
+	configstore.Reload()
+	report := configstore.LastReport()
+	if globals.PublicApiEnabled {
+		t.Errorf("A setting that requires a restart was applied at runtime.")
+	}
+	if len(report.RequiresRestart) != 1 || report.RequiresRestart[0] != "public_api_enabled" {
+		t.Errorf("The setting that requires a restart was not reported. Report: %#v", report)
+	}
+	if len(report.Unknown) != 1 || report.Unknown[0] != "some_setting" {
+		t.Errorf("The unknown setting was not reported. Report: %#v", report)
+	}
+	if globals.LoggingLevel != 1 {
+		t.Errorf("The live setting was not applied along with the one that requires a restart.")
+	}
+}
+
+func TestReload_Success_MaintenanceWindows(t *testing.T) {
+	reset(t, `{}`)
+	writeConfig(`{"maintenance_windows": ["* 2-5 * * *", "0 12 * * 6,7"], "maintenance_stagger": "5m"}`, time.Now())
+	configstore.Reload()
+	if len(globals.MaintenanceWindows) != 2 || globals.MaintenanceStagger != 5*time.Minute {
+		t.Errorf("The maintenance windows were not applied. Report: %#v", configstore.LastReport())
+	}
+}
+
+func TestReload_Fail_InvalidMaintenanceWindow(t *testing.T) {
+	reset(t, `{}`)
+	writeConfig(`{"maintenance_windows": ["* 25 * * *"], "logging_level": 1}`, time.Now())
+	configstore.Reload()
+	if len(configstore.LastReport().Error) == 0 || len(globals.MaintenanceWindows) != 0 || globals.LoggingLevel != 0 {
+		t.Errorf("An invalid maintenance window was accepted. Report: %#v", configstore.LastReport())
+	}
+}
+
+func TestReload_Success_AdvertisedEndpoints(t *testing.T) {
+	reset(t, `{}`)
+	writeConfig(`{"advertised_endpoints": [{"Location": "node.example.com", "LocationType": 4, "Port": 443, "TLS": true}, {"Location": "abc.onion", "LocationType": 3, "Port": 80, "Priority": 1}]}`, time.Now())
+	configstore.Reload()
+	if len(globals.AdvertisedEndpoints) != 2 || globals.AdvertisedEndpoints[1].Location != "abc.onion" || !globals.AdvertisedEndpoints[0].TLS {
+		t.Errorf("The advertised endpoints were not applied. Report: %#v", configstore.LastReport())
+	}
+}
+
+func TestReload_Fail_InvalidAdvertisedEndpoint(t *testing.T) {
+	reset(t, `{}`)
+	writeConfig(`{"advertised_endpoints": [{"Location": "node.example.com", "LocationType": 5, "Port": 443}], "logging_level": 1}`, time.Now())
+	configstore.Reload()
+	if len(configstore.LastReport().Error) == 0 || len(globals.AdvertisedEndpoints) != 0 || globals.LoggingLevel != 0 {
+		t.Errorf("An invalid advertised endpoint was accepted. Report: %#v", configstore.LastReport())
+	}
+}
+
+func TestUpdate_Success(t *testing.T) {
+	reset(t, `{"logging_level": 1}`)
+	report, err := configstore.Update(map[string]json.RawMessage{
+		"serving_mode": json.RawMessage(`"light"`),
+		"network_id":   json.RawMessage(`"friends"`),
+	})
+	if err != nil {
+		t.Fatalf("The changes should have been written. Error: %s", err)
+	}
+	if globals.ServingMode != "light" || len(globals.NetworkId) > 0 {
+		t.Errorf("The setting that can change at runtime should be applied, and the other left for the next start.")
+	}
+	if len(report.RequiresRestart) != 1 || report.RequiresRestart[0] != "network_id" {
+		t.Errorf("The setting read at start should be reported as requiring a restart. Report: %v", report)
+	}
+	// The file keeps what was in it, and a reload finds nothing new.
+	globals.LoggingLevel = 0
+	configstore.Reload()
+	if globals.LoggingLevel != 0 {
+		t.Errorf("The config file written should not be read again as a change.")
+	}
+	err2 := configstore.Load()
+	if err2 != nil || globals.LoggingLevel != 1 || globals.NetworkId != "friends" {
+		t.Errorf("The config file should have kept its settings, and taken the changes. Error: %v", err2)
+	}
+}
+
+func TestUpdate_Fail(t *testing.T) {
+	reset(t, `{"logging_level": 1}`)
+	_, err := configstore.Update(map[string]json.RawMessage{
+		"serving_mode": json.RawMessage(`"light"`),
+		"listeners":    json.RawMessage(`[]`),
+	})
+	if err == nil || globals.ServingMode != "full" {
+		t.Errorf("An invalid change should refuse all of them, including the ones read at start.")
+	}
+	if _, err2 := configstore.Update(map[string]json.RawMessage{"no_such_setting": json.RawMessage(`1`)}); err2 == nil {
+		t.Errorf("A setting that doesn't exist should be refused.")
+	}
+	data, _ := ioutil.ReadFile(tempDir + "/config.json")
+	if string(data) != `{"logging_level": 1}` {
+		t.Errorf("Nothing should have been written. Config: %s", data)
+	}
+}
+
+func TestLoad_Success_MinPoWStrengths(t *testing.T) {
+	reset(t, `{"min_pow_strengths": {"Post": 24, "Vote": 22}}`)
+	if globals.MinPoWStrengths.Post != 24 || globals.MinPoWStrengths.Vote != 22 || globals.MinPoWStrengths.Thread != 4 {
+		t.Errorf("The minimum PoW strengths were not applied at start, or the types not given lost theirs. Strengths: %#v", globals.MinPoWStrengths)
+	}
+}
+
+func TestReload_Fail_InvalidMinPoWStrengths(t *testing.T) {
+	for _, config := range []string{
+		`{"min_pow_strengths": {"Post": 24, "Posts": 24}}`,
+		`{"min_pow_strengths": {"Post": 24, "Vote": -1}}`,
+	} {
+		reset(t, `{}`)
+		previous := globals.MinPoWStrengths
+		writeConfig(config, time.Now())
+		configstore.Reload()
+		if len(configstore.LastReport().Error) == 0 || globals.MinPoWStrengths != previous {
+			t.Errorf("An invalid minimum PoW strength was accepted. Config: %s, Strengths: %#v", config, globals.MinPoWStrengths)
+		}
+	}
+}
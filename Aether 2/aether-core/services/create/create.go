@@ -5,19 +5,40 @@ package create
 
 import (
 	"aether-core/io/api"
+	"aether-core/services/composition"
 	"aether-core/services/globals"
 	// "aether-core/services/verify"
+	"crypto/ecdsa"
 	"errors"
 	"fmt"
 	"time"
 )
 
+// allowComposition counts the content of the local user against the composition limits of the network, see services/composition. The content of the other keys is not limited, such as the threads of the importer, which are signed with its bridge key and have limits of their own. The error is the composition.LimitError itself, so that the callers can tell when to try again.
+func allowComposition(kind string, ownerFp api.Fingerprint) error {
+	if len(ownerFp) == 0 || ownerFp != api.Fingerprint(globals.CurrentUserKeyFingerprint()) {
+		return nil
+	}
+	return composition.Allow(kind)
+}
+
 // Bake is the function that handles the core signature / pow / fingerprint trio.
 func Bake(entity api.Provable) error {
+	return BakeSigned(entity, globals.UserSigningKey())
+}
+
+// BakeSigned is Bake with the given key instead of the key of the user.
+func BakeSigned(entity api.Provable, keyPair *ecdsa.PrivateKey) error {
+	// 0) Normalization of the text, which the signature covers
 	// 1) Signature
 	// 2) PoW
 	// 3) Fingerprint
-	err := entity.CreateSignature(globals.KeyPair)
+	err0 := api.NormalizeEntity(entity)
+	if err0 != nil {
+		return errors.New(fmt.Sprintf(
+			"Entity creation failed. Error: %s, Entity: %#v\n", err0, entity))
+	}
+	err := entity.CreateSignature(keyPair)
 	if err != nil {
 		return errors.New(fmt.Sprintf(
 			"Entity creation failed. Error: %s, Entity: %#v\n", err, entity))
@@ -25,17 +46,19 @@ func Bake(entity api.Provable) error {
 	err2 := *new(error)
 	switch ent := entity.(type) {
 	case *api.Board:
-		err2 = ent.CreatePoW(globals.KeyPair, globals.MinPoWStrengths.Board)
+		err2 = ent.CreatePoW(keyPair, globals.MinPoWStrengths.Board)
 	case *api.Thread:
-		err2 = ent.CreatePoW(globals.KeyPair, globals.MinPoWStrengths.Thread)
+		err2 = ent.CreatePoW(keyPair, globals.MinPoWStrengths.Thread)
 	case *api.Post:
-		err2 = ent.CreatePoW(globals.KeyPair, globals.MinPoWStrengths.Post)
+		err2 = ent.CreatePoW(keyPair, globals.MinPoWStrengths.Post)
 	case *api.Vote:
-		err2 = ent.CreatePoW(globals.KeyPair, globals.MinPoWStrengths.Vote)
+		err2 = ent.CreatePoW(keyPair, globals.MinPoWStrengths.Vote)
 	case *api.Key:
-		err2 = ent.CreatePoW(globals.KeyPair, globals.MinPoWStrengths.Key)
+		err2 = ent.CreatePoW(keyPair, globals.MinPoWStrengths.Key)
 	case *api.Truststate:
-		err2 = ent.CreatePoW(globals.KeyPair, globals.MinPoWStrengths.Truststate)
+		err2 = ent.CreatePoW(keyPair, globals.MinPoWStrengths.Truststate)
+	case *api.Tombstone:
+		err2 = ent.CreatePoW(keyPair, globals.MinPoWStrengths.Tombstone)
 	}
 	if err2 != nil {
 		return errors.New(fmt.Sprintf(
@@ -48,7 +71,12 @@ func Bake(entity api.Provable) error {
 // Rebake saves the updates to the entity and updates the signature and pow accordingly based on given fields.
 
 func Rebake(entity api.Updateable) error {
-	err := entity.CreateUpdateSignature(globals.KeyPair)
+	err0 := api.NormalizeEntity(entity)
+	if err0 != nil {
+		return errors.New(fmt.Sprintf(
+			"Update signature creation failed. Error: %s, Entity: %#v\n", err0, entity))
+	}
+	err := entity.CreateUpdateSignature(globals.UserSigningKey())
 	if err != nil {
 		return errors.New(fmt.Sprintf(
 			"Update signature creation failed. Error: %s, Entity: %#v\n", err, entity))
@@ -56,13 +84,13 @@ func Rebake(entity api.Updateable) error {
 	err2 := *new(error)
 	switch ent := entity.(type) {
 	case *api.Board:
-		err2 = ent.CreateUpdatePoW(globals.KeyPair, globals.MinPoWStrengths.BoardUpdate)
+		err2 = ent.CreateUpdatePoW(globals.UserSigningKey(), globals.MinPoWStrengths.BoardUpdate)
 	case *api.Vote:
-		err2 = ent.CreateUpdatePoW(globals.KeyPair, globals.MinPoWStrengths.VoteUpdate)
+		err2 = ent.CreateUpdatePoW(globals.UserSigningKey(), globals.MinPoWStrengths.VoteUpdate)
 	case *api.Key:
-		err2 = ent.CreateUpdatePoW(globals.KeyPair, globals.MinPoWStrengths.KeyUpdate)
+		err2 = ent.CreateUpdatePoW(globals.UserSigningKey(), globals.MinPoWStrengths.KeyUpdate)
 	case *api.Truststate:
-		err2 = ent.CreateUpdatePoW(globals.KeyPair, globals.MinPoWStrengths.TruststateUpdate)
+		err2 = ent.CreateUpdatePoW(globals.UserSigningKey(), globals.MinPoWStrengths.TruststateUpdate)
 	}
 	if err2 != nil {
 		return errors.New(fmt.Sprintf(
@@ -128,6 +156,23 @@ func CreateThread(
 	ownerFp api.Fingerprint,
 ) (api.Thread, error) {
 
+	if err := allowComposition(composition.KindThread, ownerFp); err != nil {
+		var blankEntity api.Thread
+		return blankEntity, err
+	}
+	return CreateThreadSigned(boardFp, name, body, link, ownerFp, globals.UserSigningKey())
+}
+
+// CreateThreadSigned creates a thread signed with the given key, which has to be the key of the owner. It is not counted against the composition limits of the user.
+func CreateThreadSigned(
+	boardFp api.Fingerprint,
+	name string,
+	body string,
+	link string,
+	ownerFp api.Fingerprint,
+	keyPair *ecdsa.PrivateKey,
+) (api.Thread, error) {
+
 	var entity api.Thread
 	entity.Creation = api.Timestamp(time.Now().Unix())
 	entity.Board = boardFp
@@ -135,7 +180,7 @@ func CreateThread(
 	entity.Body = body
 	entity.Link = link
 	entity.Owner = ownerFp
-	err := Bake(&entity)
+	err := BakeSigned(&entity, keyPair)
 	if err != nil {
 		var blankEntity api.Thread
 		return blankEntity, err
@@ -151,6 +196,10 @@ func CreatePost(
 	ownerFp api.Fingerprint,
 ) (api.Post, error) {
 
+	if err := allowComposition(composition.KindPost, ownerFp); err != nil {
+		var blankEntity api.Post
+		return blankEntity, err
+	}
 	var entity api.Post
 	entity.Creation = api.Timestamp(time.Now().Unix())
 	entity.Board = boardFp
@@ -174,6 +223,10 @@ func CreateVote(
 	voteType uint8,
 ) (api.Vote, error) {
 
+	if err := allowComposition(composition.KindVote, ownerFp); err != nil {
+		var blankEntity api.Vote
+		return blankEntity, err
+	}
 	var entity api.Vote
 	entity.Creation = api.Timestamp(time.Now().Unix())
 	entity.Board = boardFp
@@ -233,6 +286,18 @@ func CreateKey(
 	currAddrs []api.CurrencyAddress,
 	info string,
 ) (api.Key, error) {
+	return CreateKeySigned(keyType, key, name, currAddrs, info, globals.UserSigningKey())
+}
+
+// CreateKeySigned creates the key entity of the given key pair, signed with it.
+func CreateKeySigned(
+	keyType string,
+	key string,
+	name string,
+	currAddrs []api.CurrencyAddress,
+	info string,
+	keyPair *ecdsa.PrivateKey,
+) (api.Key, error) {
 
 	var entity api.Key
 	entity.Creation = api.Timestamp(time.Now().Unix())
@@ -241,7 +306,7 @@ func CreateKey(
 	entity.Name = name
 	entity.CurrencyAddresses = currAddrs
 	entity.Info = info
-	err := Bake(&entity)
+	err := BakeSigned(&entity, keyPair)
 	if err != nil {
 		var blankEntity api.Key
 		return blankEntity, err
@@ -272,6 +337,30 @@ func CreateTruststate(
 	return entity, nil
 }
 
+// CreateTombstone creates a deletion marker for a thread or a post. The owner of the tombstone has to be the owner of the target, otherwise the verification on the receiving end will reject it.
+func CreateTombstone(
+	targetFp api.Fingerprint,
+	targetType string,
+	ownerFp api.Fingerprint,
+) (api.Tombstone, error) {
+
+	var entity api.Tombstone
+	if targetType != "threads" && targetType != "posts" {
+		return entity, errors.New(fmt.Sprintf(
+			"Tombstones can only target threads or posts. Given target type: %s", targetType))
+	}
+	entity.Creation = api.Timestamp(time.Now().Unix())
+	entity.Target = targetFp
+	entity.TargetType = targetType
+	entity.Owner = ownerFp
+	err := Bake(&entity)
+	if err != nil {
+		var blankEntity api.Tombstone
+		return blankEntity, err
+	}
+	return entity, nil
+}
+
 // The functions below cannot be methods on the api types because they are defined in the api package, not here. If I try to extend that here, I get an error. If I try to import the create from api, it won't compile because of circular imports.
 
 type BoardUpdateRequest struct {
@@ -121,6 +121,32 @@ func TestCreatePost_Success(t *testing.T) {
 	}
 }
 
+func TestCreatePost_Success_Normalized(t *testing.T) {
+	globals.TextMarkupPolicy = "strip"
+	defer func() { globals.TextMarkupPolicy = "allow" }()
+	// A decomposed e with an acute accent, a right-to-left override, a bell, and a tag.
+	entity, err := create.CreatePost("board", "thread", "thread", "cafe\u0301 \u202edesrever\a <b>bold</b>", "owner")
+	if err != nil {
+		t.Fatalf("Object creation failed. Err: '%s'", err)
+	}
+	if entity.Body != "caf\u00e9 desrever bold" {
+		t.Errorf("The body should have been normalized before it was signed. Body: %q", entity.Body)
+	}
+}
+
+func TestCreatePost_Fail_MarkupRejected(t *testing.T) {
+	globals.TextMarkupPolicy = "reject"
+	defer func() { globals.TextMarkupPolicy = "allow" }()
+	_, err := create.CreatePost("board", "thread", "thread", "<script>alert(1)</script>", "owner")
+	if err == nil {
+		t.Errorf("A body with raw HTML should have been refused.")
+	}
+	// The names are not markdown, so a < in them is just text.
+	if _, err2 := create.CreateThread("board", "1 <b> 2", "", "", "owner"); err2 != nil {
+		t.Errorf("A thread name with a tag in it should not be refused. Err: '%s'", err2)
+	}
+}
+
 func TestCreateVote_Success(t *testing.T) {
 	entity, err :=
 		create.CreateVote(
@@ -203,6 +229,35 @@ func TestCreateTruststate_Success(t *testing.T) {
 	}
 }
 
+func TestCreateTombstone_Success(t *testing.T) {
+	entity, err :=
+		create.CreateTombstone(
+			"target fp",
+			"posts",
+			"owner fp")
+	if err != nil {
+		t.Errorf("Object creation failed. Err: '%s'", err)
+	}
+	result, err2 := verify.Verify(&entity, UserKeyEntity)
+	if err2 != nil {
+		t.Errorf("Object verification process failed. Err: '%s'", err2)
+	}
+	if result != true {
+		t.Errorf("This object should be valid, but it is invalid. Entity: '%#v\n'", entity)
+	}
+}
+
+func TestCreateTombstone_InvalidTargetType(t *testing.T) {
+	_, err :=
+		create.CreateTombstone(
+			"target fp",
+			"boards",
+			"owner fp")
+	if err == nil {
+		t.Errorf("Expected an error to be raised from this test.")
+	}
+}
+
 // Entity updates
 
 func TestUpdateBoard_Success(t *testing.T) {
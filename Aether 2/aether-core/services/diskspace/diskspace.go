@@ -0,0 +1,21 @@
+// Services > DiskSpace
+// This package reads the free disk space, and tells the write errors that mean the disk is full apart from the others. The startup checks and the cache writes use it, on the platforms that have statfs; on the others, the free space is not known, and only the errors are told apart.
+
+package diskspace
+
+import (
+	"errors"
+)
+
+// ErrUnsupported is given by Free on the platforms the free disk space can't be read on.
+var ErrUnsupported = errors.New("Reading the free disk space is not supported on this platform.")
+
+// IsFull tells whether the error is one of a write that failed because the disk, or the quota of the user on it, is full.
+func IsFull(err error) bool {
+	for _, errno := range fullErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package diskspace_test
+
+import (
+	"aether-core/services/diskspace"
+	"errors"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestIsFull_Success(t *testing.T) {
+	err := &os.PathError{Op: "write", Path: "/tmp/caches/0.json", Err: syscall.ENOSPC}
+	if !diskspace.IsFull(err) {
+		t.Errorf("A write that ran out of space should be told apart. Error: %s", err)
+	}
+}
+
+func TestIsFull_Fail(t *testing.T) {
+	for _, err := range []error{nil, errors.New("No space left on device"), &os.PathError{Op: "open", Path: "/tmp/caches/0.json", Err: syscall.EACCES}} {
+		if diskspace.IsFull(err) {
+			t.Errorf("Only the errors of a full disk should be told apart. Error: %v", err)
+		}
+	}
+}
+
+func TestFree_Success(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	free, err2 := diskspace.Free(dir)
+	if err2 == diskspace.ErrUnsupported {
+		t.Skip(err2)
+	}
+	if err2 != nil || free == 0 {
+		t.Errorf("The free space of the temporary directory should be read. Free: %d, Error: %v", free, err2)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+// Services > DiskSpace > Unix
+// This file reads the free disk space on the platforms that have statfs.
+
+package diskspace
+
+import (
+	"syscall"
+)
+
+// fullErrnos are the errors of a write on a full disk, or over the quota of the user.
+var fullErrnos = []syscall.Errno{syscall.ENOSPC, syscall.EDQUOT}
+
+// Free gives the bytes free on the disk the path is on, for the user the node runs as.
+func Free(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
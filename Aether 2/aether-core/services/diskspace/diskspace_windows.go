@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+// Services > DiskSpace > Windows
+// This file stands in for reading the free disk space on Windows, which does not have statfs.
+
+package diskspace
+
+import (
+	"syscall"
+)
+
+// fullErrnos are ERROR_HANDLE_DISK_FULL and ERROR_DISK_FULL.
+var fullErrnos = []syscall.Errno{39, 112}
+
+// Free gives ErrUnsupported on Windows.
+func Free(path string) (uint64, error) {
+	return 0, ErrUnsupported
+}
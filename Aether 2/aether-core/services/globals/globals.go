@@ -10,21 +10,63 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"os"
+	"sync"
 	"time"
 )
 
 var KeyPair *ecdsa.PrivateKey
 var MarshaledPubKey string
+var UserKeyFingerprint string // Fingerprint of the key entity of the local user. Empty until the user creates a key. Guarded by userIdentityLock once the node is running; see SetUserIdentity.
 var LastCacheGenerationTimestamp int64
 var VerificationEnabled bool
 
+// UserKeyPair is the key the entities of the local user are signed with, if it is not the key of the node, as on a device paired with the one the user started on. Guarded by userIdentityLock, as UserKeyFingerprint is.
+var UserKeyPair *ecdsa.PrivateKey
+
+// userIdentityLock guards the key of the user and its fingerprint, which a pairing changes while the node is running.
+var userIdentityLock sync.RWMutex
+
+// SetUserIdentity sets the fingerprint of the key entity of the local user and the key its entities are signed with, together.
+func SetUserIdentity(fp string, key *ecdsa.PrivateKey) {
+	userIdentityLock.Lock()
+	defer userIdentityLock.Unlock()
+	UserKeyFingerprint = fp
+	UserKeyPair = key
+}
+
+// UserIdentity gives the fingerprint of the key entity of the local user and the key its entities are signed with, if it is not the key of the node, as they were set together.
+func UserIdentity() (string, *ecdsa.PrivateKey) {
+	userIdentityLock.RLock()
+	defer userIdentityLock.RUnlock()
+	return UserKeyFingerprint, UserKeyPair
+}
+
+// CurrentUserKeyFingerprint gives the fingerprint of the key entity of the local user.
+func CurrentUserKeyFingerprint() string {
+	userIdentityLock.RLock()
+	defer userIdentityLock.RUnlock()
+	return UserKeyFingerprint
+}
+
 func SetVerificationEnabled(enabled bool) {
 	if enabled {
 		VerificationEnabled = true
 	}
 }
 
+// UserSigningKey is the key the entities of the local user are signed with: the one the device was paired with, or the key of the node.
+func UserSigningKey() *ecdsa.PrivateKey {
+	userIdentityLock.RLock()
+	defer userIdentityLock.RUnlock()
+	if UserKeyPair != nil {
+		return UserKeyPair
+	}
+	return KeyPair
+}
+
 func GenerateUserKeyPair() {
 	privKey, _ := signaturing.CreateKeyPair()
 	KeyPair = privKey
@@ -46,10 +88,15 @@ type EntityPageSizes struct {
 	KeyIndexes        int
 	Truststates       int
 	TruststateIndexes int
+	Tombstones        int
+	TombstoneIndexes  int
 }
 
 var EntityPageSizesObj EntityPageSizes
 
+// PageByteBudget is the most a page can hold, in bytes of the JSON of its entities. A page is closed when the next entity would take it over the budget, even if it has fewer entities than its page size; the page sizes still cap the entity count. The envelope of the response is not counted, so keep this a little under the InboundMaxPageBytes of the remotes. 0 cuts the pages by entity count only.
+var PageByteBudget int
+
 // The default base size is 1x (The thread size). At the base size, a page gets 100 entries.
 func setEntityPageAndIndexSizes() {
 	EntityPageSizesObj.Boards = 500              // 0.2x
@@ -66,8 +113,11 @@ func setEntityPageAndIndexSizes() {
 	EntityPageSizesObj.KeyIndexes = 5000         // 0.02x
 	EntityPageSizesObj.Truststates = 4000        // 0.025x
 	EntityPageSizesObj.TruststateIndexes = 10000 // 0.01x
+	EntityPageSizesObj.Tombstones = 4000         // 0.025x
+	EntityPageSizesObj.TombstoneIndexes = 10000  // 0.01x
 	// Every regular page is about 500kb that way.
 	// Every index page is about 1mb.
+	PageByteBudget = 0
 }
 
 type MinPoWStrengthsStruct struct {
@@ -81,6 +131,7 @@ type MinPoWStrengthsStruct struct {
 	KeyUpdate        int64
 	Truststate       int64
 	TruststateUpdate int64
+	Tombstone        int64
 }
 
 var MinPoWStrengths MinPoWStrengthsStruct
@@ -99,6 +150,41 @@ func SetMinPoWStrengths(minstr int64) {
 	MinPoWStrengths.KeyUpdate = minstr
 	MinPoWStrengths.Truststate = minstr
 	MinPoWStrengths.TruststateUpdate = minstr
+	MinPoWStrengths.Tombstone = minstr
+}
+
+// SetMinPoWStrength sets the minimum PoW strength for a single entity type. This is how an operator can raise the bar for a specific entity type (i.e. a high-traffic node receiving a lot of posts), without changing the others. The entity types are the field names of MinPoWStrengthsStruct.
+func SetMinPoWStrength(entityType string, minstr int64) error {
+	if minstr < 0 {
+		return errors.New(fmt.Sprintf("Minimum PoW strength cannot be negative. Entity type: %s, Strength: %d", entityType, minstr))
+	}
+	switch entityType {
+	case "Board":
+		MinPoWStrengths.Board = minstr
+	case "BoardUpdate":
+		MinPoWStrengths.BoardUpdate = minstr
+	case "Thread":
+		MinPoWStrengths.Thread = minstr
+	case "Post":
+		MinPoWStrengths.Post = minstr
+	case "Vote":
+		MinPoWStrengths.Vote = minstr
+	case "VoteUpdate":
+		MinPoWStrengths.VoteUpdate = minstr
+	case "Key":
+		MinPoWStrengths.Key = minstr
+	case "KeyUpdate":
+		MinPoWStrengths.KeyUpdate = minstr
+	case "Truststate":
+		MinPoWStrengths.Truststate = minstr
+	case "TruststateUpdate":
+		MinPoWStrengths.TruststateUpdate = minstr
+	case "Tombstone":
+		MinPoWStrengths.Tombstone = minstr
+	default:
+		return errors.New(fmt.Sprintf("This entity type does not have a minimum PoW strength. Entity type: %s", entityType))
+	}
+	return nil
 }
 
 type PoWBailoutTimeStruct struct {
@@ -111,6 +197,720 @@ func SetBailoutTime() {
 	PoWBailoutTime.BailoutTimeSeconds = 30
 }
 
+// ImporterFeed is an RSS or Atom feed whose items the importer converts into threads in the given board.
+type ImporterFeed struct {
+	Url   string
+	Board string // Fingerprint of the board the items are posted into.
+}
+
+var ImporterEnabled bool
+var ImporterFeeds []ImporterFeed
+var ImporterBridgeKeyFile string            // The file with the private key of the bridge, as the hex of the DER encoded EC private key. The imported threads are signed with it, not with the key of the user.
+var ImporterBridgeKeyPair *ecdsa.PrivateKey // Loaded from ImporterBridgeKeyFile when the importer starts.
+var ImporterBridgeKeyFingerprint string     // Fingerprint of the key entity of the bridge key, which owns the imported threads. Found, or published, when the importer starts.
+var ImporterPollInterval time.Duration
+var ImporterMaxItemsPerPoll int   // Per feed, per poll. This keeps a chatty feed from flooding a board.
+var ImporterMaxItemsPerMinute int // For all feeds together. The bridge key has its own limit, instead of the composition limits of the user.
+
+func setImporterSettings() {
+	ImporterEnabled = false
+	ImporterFeeds = []ImporterFeed{}
+	ImporterBridgeKeyFile = ""
+	ImporterBridgeKeyPair = nil
+	ImporterBridgeKeyFingerprint = ""
+	ImporterPollInterval = 30 * time.Minute
+	ImporterMaxItemsPerPoll = 10
+	ImporterMaxItemsPerMinute = 20
+}
+
+// EventSubscription is an outbound webhook that receives the entity-ingested events. Empty filters match everything.
+type EventSubscription struct {
+	Url    string
+	Boards []string // Board fingerprints.
+	Types  []string // Entity types, such as "threads" or "posts".
+}
+
+var EventsEnabled bool
+var EventWebhooks []EventSubscription
+var EventSocketPath string // Unix socket that streams the events as JSON lines. Empty means no socket.
+var EventWebhookTimeout time.Duration
+
+func setEventSettings() {
+	EventsEnabled = false
+	EventWebhooks = []EventSubscription{}
+	EventSocketPath = ""
+	EventWebhookTimeout = 5 * time.Second
+}
+
+var PublicApiEnabled bool
+var PublicApiPort uint16
+var PublicApiRequestsPerMinute int // Per remote IP.
+var PublicApiDefaultPageSize int
+var PublicApiMaxPageSize int
+
+func setPublicApiSettings() {
+	PublicApiEnabled = false
+	PublicApiPort = 8090
+	PublicApiRequestsPerMinute = 60
+	PublicApiDefaultPageSize = 25
+	PublicApiMaxPageSize = 100
+}
+
+// The CDN settings are for an S3-compatible object store that mirrors the caches to offload the bandwidth.
+var CdnEnabled bool
+var CdnEndpoint string // Such as "https://s3.us-east-1.amazonaws.com". The bucket is addressed path-style.
+var CdnRegion string
+var CdnBucket string
+var CdnAccessKey string
+var CdnSecretKey string
+var CdnPublicBaseUrl string // The URL the remotes download the mirrored caches from, such as "https://cdn.example.com". Object keys are appended to it.
+
+func setCdnSettings() {
+	CdnEnabled = false
+	CdnEndpoint = ""
+	CdnRegion = "us-east-1"
+	CdnBucket = ""
+	CdnAccessKey = ""
+	CdnSecretKey = ""
+	CdnPublicBaseUrl = ""
+}
+
+// Peer exchange settings. The peers response is a small sample of good addresses, instead of the full address table.
+var PexSampleSize int       // Max addresses in a peers response.
+var PexCandidatePool int    // The sample is drawn randomly from this many of the most recently online addresses.
+var PexMaxExcludes int      // Max addresses a requester can list as already known.
+var PexMaxAge time.Duration // Addresses that were not online within this duration are not shared.
+
+func setPexSettings() {
+	PexSampleSize = 30
+	PexCandidatePool = 200
+	PexMaxExcludes = 500
+	PexMaxAge = 72 * time.Hour
+}
+
+// Private network settings. Nodes only sync with the nodes that have the same network id. Empty is the public network.
+var NetworkId string
+var NetworkMembershipKey string // Pre-shared key of a private network. If set, remotes have to prove they hold it.
+
+func setNetworkSettings() {
+	NetworkId = ""
+	NetworkMembershipKey = ""
+}
+
+// AdvertisedEndpoint is an alternative way to reach this node that is advertised to the remotes in addition to the address they connected to. Location types: 4 = IPv4, 6 = IPv6, 3 = onion.
+type AdvertisedEndpoint struct {
+	Location     string
+	Sublocation  string
+	LocationType uint8
+	Port         uint16
+	TLS          bool
+	Priority     uint8 // Lower is tried first.
+}
+
+var AdvertisedEndpoints []AdvertisedEndpoint
+var EndpointFailureThreshold int         // After this many consecutive failures, an endpoint is tried last.
+var EndpointFailureBackoff time.Duration // How long a failing endpoint stays at the back of the line.
+
+func setEndpointSettings() {
+	AdvertisedEndpoints = []AdvertisedEndpoint{}
+	EndpointFailureThreshold = 3
+	EndpointFailureBackoff = 1 * time.Hour
+}
+
+// Listener is an interface and port range the server listens on. The server takes the first free port in the range. If Advertise is set, the port it gets is the one given to the remotes and mapped on the router; only one listener should advertise. A listener that only serves an onion service through a local Tor daemon should bind to the loopback interface, and not advertise.
+type Listener struct {
+	Name      string
+	Interface string // IP address of the interface. Empty means all interfaces.
+	PortStart uint16
+	PortEnd   uint16 // Same as PortStart for a fixed port.
+	Advertise bool
+}
+
+var Listeners []Listener
+
+func setListenerSettings() {
+	Listeners = []Listener{
+		Listener{Name: "clearnet", Interface: "127.0.0.1", PortStart: 8089, PortEnd: 8089, Advertise: true},
+	}
+}
+
+var InboundMaxPageBytes int64     // Pages from remotes larger than this are cut off and rejected.
+var InboundMaxPageEntities int    // Pages from remotes with more entities and indexes than this are rejected.
+var InboundMaxFieldBytes int      // Entities from remotes with a text field larger than this are rejected, with the whole page.
+var InboundMaxCachePages int      // Caches from remotes that claim to have more pages than this are rejected without downloading the rest.
+var InboundMaxRedirects int       // Redirect chains longer than this are not followed.
+var InboundViolationThreshold int // A remote that goes over the inbound limits this many times is not synced with for a while.
+var InboundViolationBackoff time.Duration
+
+func setInboundLimitSettings() {
+	InboundMaxPageBytes = 16 * 1024 * 1024
+	InboundMaxPageEntities = 10000
+	InboundMaxFieldBytes = 256 * 1024 // Descriptions can be 65535 characters, of up to 4 bytes each.
+	InboundMaxCachePages = 10000
+	InboundMaxRedirects = 3
+	InboundViolationThreshold = 3
+	InboundViolationBackoff = 24 * time.Hour
+}
+
+var VoteCompactionEnabled bool // If enabled, the votes older than VoteCompactionAgeDays are replaced with per-target summaries signed by this node.
+var VoteCompactionAgeDays int
+var VoteCompactionInterval time.Duration
+
+// Log sampling. The messages that can come once per entity or request are logged only LogSampleLimit times per kind in every LogSampleWindow, and the rest are counted. The limit can be set per component, i.e. {"verify": 100}. A limit of 0 logs everything.
+var LogSampleLimit int
+var LogSampleWindow time.Duration
+var LogSampleComponentLimits map[string]int
+
+func setLogSamplingSettings() {
+	LogSampleLimit = 10
+	LogSampleWindow = time.Minute
+	LogSampleComponentLimits = make(map[string]int)
+}
+
+// Sync priorities. The entity types are synced from a remote in the order of their priorities, highest first, so that the types others depend on arrive first. The types not listed come last.
+var SyncPriorities map[string]int
+
+func setSyncPrioritySettings() {
+	SyncPriorities = map[string]int{
+		"keys":        60,
+		"boards":      50,
+		"threads":     40,
+		"posts":       30,
+		"votes":       20,
+		"truststates": 10,
+		"tombstones":  5,
+	}
+}
+
+// Missing parents. The boards and threads the frontend got placeholders for are asked from a remote by fingerprint every OrphanFetchInterval, OrphanFetchBatchSize at a time. A parent that doesn't arrive after OrphanMaxAttempts tries is given up on, until the frontend asks for it again.
+var OrphanMaxWanted int
+var OrphanMaxAttempts int
+var OrphanFetchBatchSize int
+var OrphanFetchInterval time.Duration
+
+func setOrphanSettings() {
+	OrphanMaxWanted = 1000
+	OrphanMaxAttempts = 5
+	OrphanFetchBatchSize = 50
+	OrphanFetchInterval = time.Minute
+}
+
+// Query instrumentation. The queries that take longer than SlowQueryThreshold are logged; 0 turns it off. Their parameters are only logged if SlowQueryLogParameters is on, since they can have the content of the users in them. Otherwise only their types and lengths are.
+var SlowQueryThreshold time.Duration
+var SlowQueryLogParameters bool
+
+func setQueryInstrumentationSettings() {
+	SlowQueryThreshold = 500 * time.Millisecond
+	SlowQueryLogParameters = false
+}
+
+// Indexes. The indexes the filters need are created at the start if the database doesn't have them, unless CreateMissingIndexes is off, in which case the missing ones are only logged. The progress of creating one on a table with at least IndexLargeTableRows rows is logged every IndexProgressInterval.
+var CreateMissingIndexes bool
+var IndexLargeTableRows int
+var IndexProgressInterval time.Duration
+
+func setIndexSettings() {
+	CreateMissingIndexes = true
+	IndexLargeTableRows = 100000
+	IndexProgressInterval = 30 * time.Second
+}
+
+// Storage report. How many of the largest boards and threads it lists.
+var StorageReportLargest int
+
+func setStorageReportSettings() {
+	StorageReportLargest = 10
+}
+
+// Maintenance windows. The heavy jobs (cache generation, vote compaction and cache pruning) run only when the local time matches one of MaintenanceWindows, which are cron expressions such as "* 2-5 * * *" for every minute from 2:00 to 5:59. An empty list lets them run whenever their timers fire. The heavy jobs never run at the same time, and one starts at least MaintenanceStagger after the previous one ended.
+var MaintenanceWindows []string
+var MaintenanceStagger time.Duration
+
+func setMaintenanceSettings() {
+	MaintenanceWindows = []string{}
+	MaintenanceStagger = 10 * time.Minute
+}
+
+// Pagination. Every page gives the count of the pages of its response or cache in total_pages. The pages field is what the older versions read, and they don't agree on what it is: the caches give the number of their last page there, the POST responses the count of their pages. PaginationLegacyPages keeps writing it that way; without it, it is the count of the pages everywhere, like total_pages.
+var PaginationLegacyPages bool
+
+func setPaginationSettings() {
+	PaginationLegacyPages = true
+}
+
+// Lazy cache repair. With LazyCacheRepair, a page of a cache that is about to be served is checked against the manifest of its cache first, and if the page is missing or doesn't match, the cache is regenerated from the database before it is served. A cache is regenerated this way at most once in CacheRepairCooldown, so that a cache that comes out broken again is not regenerated at every request.
+var LazyCacheRepair bool
+var CacheRepairCooldown time.Duration
+
+func setCacheRepairSettings() {
+	LazyCacheRepair = true
+	CacheRepairCooldown = 10 * time.Minute
+}
+
+// Versioned caches. A regenerated cache is written into a new folder, and the folder of the cache it replaces is kept until nobody has asked for it for CacheRetiredGrace, so that the remotes that were downloading it can finish.
+var CacheRetiredGrace time.Duration
+
+func setCacheVersionSettings() {
+	CacheRetiredGrace = 10 * time.Minute
+}
+
+// Fingerprint query limits. A remote can ask for FingerprintQueryMaxPerRequest fingerprints in a request, and FingerprintQueryMaxPerHour in an hour. A remote whose requests walk the fingerprints in order FingerprintScanRunLength times in a row is taken to be enumerating the database, and is refused for an hour. 0 turns a limit off.
+var FingerprintQueryMaxPerRequest int
+var FingerprintQueryMaxPerHour int
+var FingerprintScanRunLength int
+
+func setFingerprintQuerySettings() {
+	FingerprintQueryMaxPerRequest = 100
+	FingerprintQueryMaxPerHour = 2000
+	// The missing parent fetch asks for up to OrphanMaxWanted / OrphanFetchBatchSize batches in order. This has to stay above that, so that other nodes doing it are not taken for scanners.
+	FingerprintScanRunLength = 30
+}
+
+// Replay protection. Every POST request carries a fresh nonce and its timestamp, and the remote echoes the nonce in a response signed with its node key. A response is taken only if its nonce is the one sent and its timestamp is within ResponseBindingWindow of the local clock; a request whose nonce was already seen in the window is refused.
+var ResponseBindingWindow time.Duration
+var RequireResponseBinding bool // If enabled, the responses of remotes that don't echo and sign the nonce yet are refused. Otherwise they are taken as before.
+
+func setReplayProtectionSettings() {
+	ResponseBindingWindow = 10 * time.Minute
+	RequireResponseBinding = false
+}
+
+// Response signing. The caches, their indexes, the node response and the POST responses to the remotes that send a nonce are signed with the node key, so that a remote can tell they come from this node.
+var SignResponses bool             // If disabled, nothing is signed that is not bound to a request, and the signed_responses extension is not announced.
+var RequireResponseSignatures bool // If enabled, the pages of remotes that are not signed are refused. Otherwise they are taken as before.
+
+func setResponseSigningSettings() {
+	SignResponses = true
+	RequireResponseSignatures = false
+}
+
+// Cache retention is separate from what the database keeps: the caches older than CacheRetentionDays are deleted, while the entities in them stay in the database. The only thing that removes entities from the database is the vote compaction above.
+var CacheRetentionDays int // 0 keeps the caches forever.
+var CacheJanitorInterval time.Duration
+
+func setCacheRetentionSettings() {
+	CacheRetentionDays = 0
+	CacheJanitorInterval = 6 * time.Hour
+}
+
+// Profile snapshots are for investigating problems in the field. They are off by default; the profiles can always be taken on demand from the admin endpoints.
+var ProfileSnapshotsEnabled bool // If enabled, heap and goroutine profiles are written into the profiles folder of the user directory every ProfileSnapshotInterval.
+var ProfileSnapshotInterval time.Duration
+var ProfileSnapshotsKept int // The older snapshots are deleted.
+
+func setProfilingSettings() {
+	ProfileSnapshotsEnabled = false
+	ProfileSnapshotInterval = 10 * time.Minute
+	ProfileSnapshotsKept = 24
+}
+
+func setVoteCompactionSettings() {
+	VoteCompactionEnabled = false
+	VoteCompactionAgeDays = 90
+	VoteCompactionInterval = 24 * time.Hour
+}
+
+// PeerRule blocks or allows the remotes that match it. A rule matches by node id, by IP range, or by both if both are given. An expiry of 0 means the rule does not expire.
+type PeerRule struct {
+	NodeId string `json:"node_id,omitempty"`
+	CIDR   string `json:"cidr,omitempty"`
+	Allow  bool   `json:"allow"` // Whitelist rule if true, blacklist rule if false.
+	Reason string `json:"reason,omitempty"`
+	Expiry int64  `json:"expiry,omitempty"`
+}
+
+var PeerRules []PeerRule   // Rules from the settings. The ones added by the operator at runtime are kept by the peerrules service.
+var PeerWhitelistOnly bool // If enabled, only the remotes matching a whitelist rule are talked to.
+
+func setPeerRuleSettings() {
+	PeerRules = []PeerRule{}
+	PeerWhitelistOnly = false
+}
+
+var LanDiscoveryEnabled bool // If enabled, the node announces itself on the local network with mDNS, and looks for other nodes there.
+var LanDiscoveryInterval time.Duration
+
+func setLanDiscoverySettings() {
+	LanDiscoveryEnabled = true
+	LanDiscoveryInterval = 1 * time.Minute
+}
+
+var DiagnosticsMinFreeDiskBytes uint64 // The -check mode reports the user directory as running out of space below this.
+
+func setDiagnosticsSettings() {
+	DiagnosticsMinFreeDiskBytes = 1024 * 1024 * 1024
+}
+
+var RankingReplyWeight float64     // How much a reply counts towards the hot score of a thread, compared to an upvote.
+var RankingHotDecaySeconds float64 // A thread this many seconds newer needs ten times less votes and replies to be as hot.
+var RankingPageSize int            // Threads in a page of the ranking, unless the frontend asks for a different count.
+var RankingMaxPageSize int
+
+func setRankingSettings() {
+	RankingReplyWeight = 0.5
+	RankingHotDecaySeconds = 45000
+	RankingPageSize = 25
+	RankingMaxPageSize = 100
+}
+
+var ConfigReloadInterval time.Duration // How often the config file in the user directory is checked for changes.
+
+func setConfigSettings() {
+	ConfigReloadInterval = 5 * time.Second
+}
+
+var MigrationAnnounceCount int // After a node is imported on a new machine, it syncs with this many remotes right away so they learn its new location.
+
+func setMigrationSettings() {
+	MigrationAnnounceCount = 5
+}
+
+// UserDirectoryEnv is the environment variable that, if set, gives the user directory to use instead of the default one. The config file is in the user directory, so this can't be set there. This is how the developer tools run more than one node on a machine.
+const UserDirectoryEnv = "AETHER_USER_DIRECTORY"
+
+// DatabaseEnv is the environment variable that, if set, gives the data source name of the database to use instead of the default one, such as "root:@/aether_node2". Like the user directory, this lets each node on a machine have its own.
+const DatabaseEnv = "AETHER_DATABASE"
+
+// DatabaseReplicaEnv is the environment variable that, if set, gives the data source name of a read-only replica of the database, such as "reader:@tcp(10.0.0.2)/aether_test". The reads that serve the ranges the remotes ask for go to it while it is caught up, so that they don't contend with the ingest on the primary.
+const DatabaseReplicaEnv = "AETHER_DATABASE_REPLICA"
+
+// SendClientHeaders sets the X-Aether headers, with the node id, the client version and the protocol extensions of this node, on the requests to the remotes.
+var SendClientHeaders bool
+
+// PeerClientsKept is how many remotes the statistics of the clients that reach this node are kept for. The ones seen least recently are dropped first.
+var PeerClientsKept int
+
+func setClientHeaderSettings() {
+	SendClientHeaders = true
+	PeerClientsKept = 1000
+}
+
+// Privacy mode, for the users in hostile network environments. It leaves the client name and version out of the requests and the responses of this node, and the X-Aether headers out of the requests; it doesn't advertise the endpoints of this node, doesn't announce it on the local network, and asks the remotes not to save its address and pass it on; the syncs run at random times around their intervals; and the responses are padded to a size bucket, so that their size says less about what is in them.
+var PrivacyMode bool
+
+func setPrivacySettings() {
+	PrivacyMode = false
+}
+
+// ServingMode is how much the node does for the others. "full" serves the network: it generates caches for the remotes to sync from, and asks the router to forward its port. "light" only syncs what the user needs, for the machines that can't spare the disk or the bandwidth, or that can't be reached anyway.
+var ServingMode string
+
+func setServingModeSettings() {
+	ServingMode = "full"
+}
+
+// Backups. When enabled, the database and the state of the node are backed up every BackupInterval into BackupDestination (the backups folder in the user directory if empty), as archives like the ones -export-node writes. Every BackupFullEvery-th backup is full, and the ones between are incremental, with only the entities that arrived since the one before. The last BackupsKept full backups are kept, with the incremental ones that follow them.
+var BackupEnabled bool
+var BackupDestination string
+var BackupInterval time.Duration
+var BackupFullEvery int
+var BackupsKept int
+
+// Validation policy. The most characters the text fields of the entities can have are the ones the protocol gives, unless overridden here by the entity type and the field, such as "posts.body"; 0 turns the maximum of a field off. A request can have ValidationMaxFilters filters, of ValidationMaxFilterValues values each, and an index ValidationMaxResultCaches cache links. The entities over the policy are dropped when they arrive and before they are served; a request or an index over it is refused whole.
+var ValidationFieldMaxima map[string]int
+var ValidationMaxFilters int
+var ValidationMaxFilterValues int
+var ValidationMaxResultCaches int
+
+func setValidationSettings() {
+	ValidationFieldMaxima = make(map[string]int)
+	ValidationMaxFilters = 16
+	ValidationMaxFilterValues = 1000
+	ValidationMaxResultCaches = 5000
+}
+
+// Job queue. The long-running jobs of the backend run from a queue, at most JobWorkers at a time, the ones of the higher priority first. A job that fails is tried again after JobRetryBackoff times the number of its attempts, if its kind allows more than one. The last JobHistoryKept jobs that ended are kept in the list, with how they ended.
+var JobWorkers int
+var JobHistoryKept int
+var JobRetryBackoff time.Duration
+
+// Read replica. The replica given with DatabaseReplicaEnv is checked every ReplicaCheckInterval, and the reads go to it only while it is no more than ReplicaMaxLag behind the primary.
+var ReplicaMaxLag time.Duration
+var ReplicaCheckInterval time.Duration
+
+// Text normalization. The text fields of the entities this node creates are normalized to NFC, without the control characters and the bidirectional overrides, before they are signed. TextMarkupPolicy is what happens to the raw HTML in the fields rendered as markdown: "allow" keeps it, "strip" removes the tags, and "reject" refuses the entity. TextInboundPolicy is what happens on ingest to the entities that arrive without being normalized already, or with raw HTML the markup policy doesn't allow: "drop" drops them, "flag" logs them and takes them, and "accept" takes them.
+var TextInboundPolicy string
+var TextMarkupPolicy string
+
+// Reply trees. The frontend gets the replies of a thread ReplyTreePageSize at a time, and ReplyTreeDepth levels deep, unless it asks for other amounts.
+var ReplyTreeDepth int
+var ReplyTreePageSize int
+var ReplyTreeMaxPageSize int
+
+// VoteSyncPolicy is a rule for the votes of a board, or of every board with "*". With Unsubscribed, it applies only to the boards the user is not subscribed to. With NoFetch, the votes of the board are not taken from the remotes, and with ServeMaxAge, the ones older than that are not served to them.
+type VoteSyncPolicy struct {
+	Board        string `json:"board"`
+	Unsubscribed bool   `json:"unsubscribed"`
+	NoFetch      bool   `json:"no_fetch"`
+	ServeMaxAge  int64  `json:"serve_max_age"` // In seconds. 0 serves all of them.
+}
+
+var VoteSyncPolicies []VoteSyncPolicy
+var SubscribedBoards []string // The fingerprints of the boards the user is subscribed to. These come from the setup, not from the config file.
+
+// Cache witnesses. With WitnessEnabled, this node signs the cache manifests other nodes send it. CacheWitnesses are the addresses ("host:port") of the witnesses this node sends the manifests of its own caches to, every CacheWitnessInterval. TrustedWitnesses are the witnesses whose signatures are checked on the caches of the remotes, by their node ids, with their public keys: a cache with a signature of one of them that doesn't verify is refused, and one they all signed more than CacheWitnessMaxDelay after it ended is logged as possibly rewritten.
+var WitnessEnabled bool
+var CacheWitnesses []string
+var TrustedWitnesses map[string]string
+var CacheWitnessInterval time.Duration
+var CacheWitnessMaxDelay time.Duration
+
+func setWitnessSettings() {
+	WitnessEnabled = false
+	CacheWitnesses = []string{}
+	TrustedWitnesses = map[string]string{}
+	CacheWitnessInterval = 1 * time.Hour
+	CacheWitnessMaxDelay = 24 * time.Hour
+}
+
+// Statics collection. What is in the statics directory that nothing points to, such as a cache folder a crash left out of its index, is flagged when the janitor finds it, and deleted if it is still there StaticsOrphanGrace later.
+var StaticsOrphanGrace time.Duration
+
+// With CacheDedupEnabled, the janitor also makes the pages that are the same in different caches links to a single file.
+var CacheDedupEnabled bool
+
+func setStaticsSettings() {
+	StaticsOrphanGrace = 24 * time.Hour
+	CacheDedupEnabled = true
+}
+
+// Output encoders. OutputEncoders gives the encoder of each destination, "responses" or "caches", by the name of the encoder, such as "json" or "json-pretty". The destinations that are not in it are written as compact JSON.
+var OutputEncoders map[string]string
+
+func setEncoderSettings() {
+	OutputEncoders = map[string]string{}
+}
+
+// Adaptive sync interval. The live dispatcher waits SyncInterval between its syncs to begin with. If adaptive sync is enabled, the wait is halved after a sync that brought SyncBusyEntities or more, doubled after one that brought nothing, and kept between SyncIntervalMin and SyncIntervalMax.
+var AdaptiveSyncEnabled bool
+var SyncInterval time.Duration
+var SyncIntervalMin time.Duration
+var SyncIntervalMax time.Duration
+var SyncBusyEntities int
+
+func setSyncIntervalSettings() {
+	AdaptiveSyncEnabled = true
+	SyncInterval = 1 * time.Minute
+	SyncIntervalMin = 15 * time.Second
+	SyncIntervalMax = 15 * time.Minute
+	SyncBusyEntities = 100
+}
+
+// Session digests. With SessionDigestsEnabled, this node keeps the digests of what it sends to each sync for the remotes to check against, and checks its own syncs against the digests of the remotes that keep them.
+var SessionDigestsEnabled bool
+
+func setSessionDigestSettings() {
+	SessionDigestsEnabled = true
+}
+
+// Handshake. With HandshakeEnabled, the syncs with the remotes that know the handshake start with one, and the extensions both sides announce in it decide what is used for the rest of the sync. A remote whose clock is further off than HandshakeMaxClockSkew is not synced with; 0 is no bound.
+var HandshakeEnabled bool
+var HandshakeMaxClockSkew time.Duration
+
+func setHandshakeSettings() {
+	HandshakeEnabled = true
+	HandshakeMaxClockSkew = 10 * time.Minute
+}
+
+// Live cache. With LiveCacheEnabled, the entities that arrived within the last LiveCacheWindow are kept in memory, per entity type, and the POST requests for what is new within that window are answered from there instead of the database. An entity type with more than LiveCacheMaxEntities in the window is read from the database as before.
+var LiveCacheEnabled bool
+var LiveCacheWindow time.Duration
+var LiveCacheMaxEntities int
+
+func setLiveCacheSettings() {
+	LiveCacheEnabled = true
+	LiveCacheWindow = 1 * time.Hour
+	LiveCacheMaxEntities = 20000
+}
+
+// Ingest coalescing. With IngestCoalescingEnabled, the votes and the truststates of a batch are coalesced by their owners and targets before the batch is written, and only the last write of each is written.
+var IngestCoalescingEnabled bool
+
+func setIngestCoalescingSettings() {
+	IngestCoalescingEnabled = true
+}
+
+// Provenance. With ProvenanceEnabled, the node that first delivered each entity, when and how, is recorded as it is ingested. The records older than ProvenanceRetentionDays are deleted with the cache pruning; 0 keeps them.
+var ProvenanceEnabled bool
+var ProvenanceRetentionDays int
+
+func setProvenanceSettings() {
+	ProvenanceEnabled = true
+	ProvenanceRetentionDays = 90
+}
+
+// Load shedding. With LoadSheddingEnabled, the server serves at most ServingMaxConcurrent requests of the remotes at once, and the others wait, the remotes with the best standing first. At most ServingQueueMax requests wait, for at most ServingQueueTimeout; past that, the remotes with the worst standing are refused, and told to come back after LoadSheddingRetryAfter.
+var LoadSheddingEnabled bool
+var ServingMaxConcurrent int
+var ServingQueueMax int
+var ServingQueueTimeout time.Duration
+var LoadSheddingRetryAfter time.Duration
+
+func setLoadSheddingSettings() {
+	LoadSheddingEnabled = true
+	ServingMaxConcurrent = 64
+	ServingQueueMax = 256
+	ServingQueueTimeout = 15 * time.Second
+	LoadSheddingRetryAfter = 30 * time.Second
+}
+
+// Address liveness. An address that this node hasn't reached for AddressLivenessDays, or that it has heard of that long ago and never reached, is demoted: it is no longer given to the remotes, and it is dialed after the others. After AddressPurgeDays it is deleted. 0 turns off either.
+var AddressLivenessDays int
+var AddressPurgeDays int
+
+func setAddressLivenessSettings() {
+	AddressLivenessDays = 14
+	AddressPurgeDays = 90
+}
+
+// Response hooks. A hook that doesn't return within ResponseHookTimeout has failed, and one that fails ResponseHookMaxFailures times in a row is disabled; 0 never disables them.
+var ResponseHookTimeout time.Duration
+var ResponseHookMaxFailures int
+
+func setResponseHookSettings() {
+	ResponseHookTimeout = 5 * time.Second
+	ResponseHookMaxFailures = 10
+}
+
+// Cold storage. The caches that ended more than CacheArchiveAfterMonths ago are moved out of the statics directory into an archive per entity type and month, compressed, under CacheArchiveLocation, and their pages are served from there; 0 keeps all of them in the statics directory. An empty CacheArchiveLocation is the cold-caches folder in the user directory. CacheArchiveCachedPages is how many of the pages read from the archives are kept in memory.
+var CacheArchiveAfterMonths int
+var CacheArchiveLocation string
+var CacheArchiveCachedPages int
+
+func setCacheArchiveSettings() {
+	CacheArchiveAfterMonths = 0
+	CacheArchiveLocation = ""
+	CacheArchiveCachedPages = 256
+}
+
+// Drafts. A local user can keep up to DraftsMaxPerProfile drafts, counting the scheduled ones and the ones that were published and not deleted since.
+var DraftsMaxPerProfile int
+
+func setDraftSettings() {
+	DraftsMaxPerProfile = 1000
+}
+
+// Watches. A local user can watch up to WatchesMaxPerProfile threads and boards. The feed of what is new in them is given in pages of WatchFeedPageSize, unless the frontend asks for a different count, up to WatchFeedMaxPageSize.
+var WatchesMaxPerProfile int
+var WatchFeedPageSize int
+var WatchFeedMaxPageSize int
+
+func setWatchSettings() {
+	WatchesMaxPerProfile = 1000
+	WatchFeedPageSize = 50
+	WatchFeedMaxPageSize = 500
+}
+
+// CompositionPolicy is how many threads, posts and votes the local user can create in a minute in a network: "public", a private network by its id, or every network with "*". 0 is no limit.
+type CompositionPolicy struct {
+	Network          string `json:"network"`
+	ThreadsPerMinute int    `json:"threads_per_minute"`
+	PostsPerMinute   int    `json:"posts_per_minute"`
+	VotesPerMinute   int    `json:"votes_per_minute"`
+}
+
+// Composition limits. The content the local user creates is limited to the policy of the network the node is in, so that the user doesn't write faster than the remotes' spam filters accept. The policy of the network wins over the one for "*". With CompositionLimitsOverride, the operator lifts the limits.
+var CompositionPolicies []CompositionPolicy
+var CompositionLimitsOverride bool
+
+func setCompositionSettings() {
+	CompositionPolicies = []CompositionPolicy{{Network: "*", ThreadsPerMinute: 2, PostsPerMinute: 10, VotesPerMinute: 60}}
+	CompositionLimitsOverride = false
+}
+
+// Telemetry. With TelemetryEnabled, the node reports anonymous aggregates to the stats collector at TelemetryCollector once every TelemetryInterval, so that the community can size the network: a heartbeat, and, as chosen, the version of the app and the totals of the entities. It is off unless given, and nothing is reported in privacy mode.
+var TelemetryEnabled bool
+var TelemetryCollector string
+var TelemetryInterval time.Duration
+var TelemetryIncludeVersion bool
+var TelemetryIncludeEntityTotals bool
+
+func setTelemetrySettings() {
+	TelemetryEnabled = false
+	TelemetryCollector = ""
+	TelemetryInterval = 24 * time.Hour
+	TelemetryIncludeVersion = true
+	TelemetryIncludeEntityTotals = true
+}
+
+// Fingerprint manifests. With FingerprintManifestsEnabled, this node gives the remotes the fingerprints and the last updates of the entities that arrived in a time range, without their bodies, so that they can see what they need of it before they download it. A manifest has at most FingerprintManifestMaxEntries entries per entity type; the remotes ask for the rest in another request.
+var FingerprintManifestsEnabled bool
+var FingerprintManifestMaxEntries int
+
+func setFingerprintManifestSettings() {
+	FingerprintManifestsEnabled = true
+	FingerprintManifestMaxEntries = 10000
+}
+
+// Page ranges. The remotes can ask for up to CachePageRangeMaxPages pages of a cache in a single request, instead of one request per page. 0 turns the ranges off.
+var CachePageRangeMaxPages int
+
+func setPageRangeSettings() {
+	CachePageRangeMaxPages = 32
+}
+
+// Disk full. A cache write that fails because the disk is full pauses the cache generation, until there are CacheResumeFreeDiskBytes free where the caches are kept. On the platforms the free space can't be read on, the next run tries again.
+var CacheResumeFreeDiskBytes int64
+
+func setDiskFullSettings() {
+	CacheResumeFreeDiskBytes = 512 * 1024 * 1024
+}
+
+// Response store. The pages of the multipart POST responses are written into the statics directory ("files"), or into the database ("db"), where they are published at once and expire with a single delete. ResponseStoreQuotaBytes bounds how much the responses in the database can take; 0 is no bound.
+var ResponseStore string
+var ResponseStoreQuotaBytes int64
+
+func setResponseStoreSettings() {
+	ResponseStore = "files"
+	ResponseStoreQuotaBytes = 0
+}
+
+func setVoteSyncSettings() {
+	VoteSyncPolicies = []VoteSyncPolicy{}
+	SubscribedBoards = []string{}
+}
+
+func setReplyTreeSettings() {
+	ReplyTreeDepth = 8
+	ReplyTreePageSize = 100
+	ReplyTreeMaxPageSize = 500
+}
+
+func setTextSettings() {
+	TextInboundPolicy = "flag"
+	TextMarkupPolicy = "allow"
+}
+
+func setReplicaSettings() {
+	ReplicaMaxLag = 30 * time.Second
+	ReplicaCheckInterval = 10 * time.Second
+}
+
+func setJobSettings() {
+	JobWorkers = 2
+	JobHistoryKept = 100
+	JobRetryBackoff = 5 * time.Minute
+}
+
+func setBackupSettings() {
+	BackupEnabled = false
+	BackupDestination = ""
+	BackupInterval = 24 * time.Hour
+	BackupFullEvery = 7
+	BackupsKept = 4
+}
+
+var POSTPagedReadThreshold int // POST responses for time ranges with more entities than this are read from the database page by page.
+
+// Inline POST responses. A POST response whose pages come to no more than POSTInlineMaxBytes together, in bytes of the JSON of their entities, is sent in the response itself, as a single page, instead of being saved as a multipart response the remote has to download page by page. A remote can ask for less with the max_inline filter, and this node asks the remotes for POSTInlinePreferredBytes. 0 sends only the responses of a single page inline, as the older versions do, and 0 as the preference doesn't ask.
+var POSTInlineMaxBytes int64
+var POSTInlinePreferredBytes int64
+
+func setPOSTInlineSettings() {
+	POSTInlineMaxBytes = 2 * 1024 * 1024
+	POSTInlinePreferredBytes = 2 * 1024 * 1024
+}
+
 var NodeId string
 var AddressPort uint16
 var AddressType int
@@ -132,6 +932,8 @@ var OnlineAddressFinderPageSize int
 var DispatcherExclusionsExpiryLiveAddress time.Duration
 var DispatcherExclusionsExpiryStaticAddress time.Duration
 var LoggingLevel int
+var CacheGenerationVerbose bool // Log the plan of every cache generation run before running it.
+var CacheEncodingWorkers int    // How many pages of a cache are encoded and written at the same time. 1 writes them one at a time.
 var ExternalIp string
 
 /*
@@ -150,6 +952,19 @@ var StopImmatureCacheGenerationCycle chan bool
 var StopAddressScannerCycle chan bool
 var StopUPNPCycle chan bool
 var AddressesScannerActive bool
+var StopImporterCycle chan bool
+var StopVoteCompactionCycle chan bool
+var StopCacheJanitorCycle chan bool
+var StopProfileSnapshotCycle chan bool
+var StopBackupCycle chan bool
+var StopReplicaCheckCycle chan bool
+var StopCacheWitnessCycle chan bool
+var StopLogSamplingCycle chan bool
+var StopOrphanFetchCycle chan bool
+var StopNonceSweepCycle chan bool
+var StopLanDiscoveryCycle chan bool
+var StopConfigReloadCycle chan bool
+var StopTelemetryCycle chan bool
 
 func SetApplicationState() {
 	TooManyConnections = false
@@ -168,7 +983,7 @@ func SetGlobals() {
 	AddressType = 2
 	ProtocolVersionMajor = 0
 	ProtocolVersionMinor = 1
-	ProtocolExtensions = []string{"aether"}
+	ProtocolExtensions = []string{"aether", "cursor"}
 	ClientVersionMajor = 2
 	ClientVersionMinor = 0
 	ClientVersionPatch = 0
@@ -176,6 +991,9 @@ func SetGlobals() {
 	LastCacheGenerationTimestamp = 0
 	setEntityPageAndIndexSizes()
 	UserDirectory = "/Users/Helios/Dropbox/Aether_Catchall/Aether_Main_Repo/Aether_2/aether-core/userdir"
+	if dir := os.Getenv(UserDirectoryEnv); len(dir) > 0 {
+		UserDirectory = dir
+	}
 	PostResponseExpiryMinutes = 30
 	CachesLocation = fmt.Sprint(UserDirectory, "/statics/caches/v0")
 	ConnectionTimeout = 2 * time.Second
@@ -186,6 +1004,72 @@ func SetGlobals() {
 	DispatcherExclusionsExpiryLiveAddress = 5 * time.Minute
 	DispatcherExclusionsExpiryStaticAddress = 72 * time.Hour
 	LoggingLevel = 0
+	CacheGenerationVerbose = false
+	CacheEncodingWorkers = 4
+	setImporterSettings()
+	setEventSettings()
+	setPublicApiSettings()
+	setCdnSettings()
+	setPexSettings()
+	setNetworkSettings()
+	setEndpointSettings()
+	setListenerSettings()
+	setMigrationSettings()
+	setInboundLimitSettings()
+	setVoteCompactionSettings()
+	setCacheRetentionSettings()
+	setPeerRuleSettings()
+	setLanDiscoverySettings()
+	setConfigSettings()
+	setDiagnosticsSettings()
+	setRankingSettings()
+	setProfilingSettings()
+	setLogSamplingSettings()
+	setReplayProtectionSettings()
+	setResponseSigningSettings()
+	setSyncPrioritySettings()
+	setOrphanSettings()
+	setFingerprintQuerySettings()
+	setQueryInstrumentationSettings()
+	setIndexSettings()
+	setStorageReportSettings()
+	setMaintenanceSettings()
+	setPaginationSettings()
+	setCacheRepairSettings()
+	setCacheVersionSettings()
+	POSTPagedReadThreshold = 10000
+	setPOSTInlineSettings()
+	setClientHeaderSettings()
+	setPrivacySettings()
+	setServingModeSettings()
+	setBackupSettings()
+	setValidationSettings()
+	setJobSettings()
+	setReplicaSettings()
+	setTextSettings()
+	setReplyTreeSettings()
+	setVoteSyncSettings()
+	setWitnessSettings()
+	setStaticsSettings()
+	setEncoderSettings()
+	setSyncIntervalSettings()
+	setResponseStoreSettings()
+	setSessionDigestSettings()
+	setHandshakeSettings()
+	setLiveCacheSettings()
+	setIngestCoalescingSettings()
+	setProvenanceSettings()
+	setLoadSheddingSettings()
+	setAddressLivenessSettings()
+	setResponseHookSettings()
+	setCacheArchiveSettings()
+	setDraftSettings()
+	setWatchSettings()
+	setCompositionSettings()
+	setTelemetrySettings()
+	setFingerprintManifestSettings()
+	setPageRangeSettings()
+	setDiskFullSettings()
 	SetApplicationState()
 
 }
@@ -0,0 +1,503 @@
+// Services > Jobs
+// This package is the queue of the long-running jobs of the backend, such as generating the caches, pruning them, compacting the votes and taking backups. A job is queued with a priority, and runs when a worker is free, the higher priorities first. The heavy jobs also wait for the maintenance windows and for each other, as the heavy jobs of the scheduler do. The state of the jobs is saved in the user directory, so that the jobs queued, and the ones a shutdown cut short, run after the next start.
+
+package jobs
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"aether-core/services/scheduling"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateDone      = "done"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+const (
+	PriorityLow    = 0
+	PriorityNormal = 10
+	PriorityHigh   = 20
+)
+
+// stateFile keeps the jobs in the user directory.
+const stateFile = "jobs.json"
+
+// pollInterval is how often the queue checks for the jobs that were waiting for a maintenance window or for their retry, when nothing else has woken it.
+const pollInterval = time.Minute
+
+// Job is a single run of a kind of job.
+type Job struct {
+	Id        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	Args      json.RawMessage `json:"args,omitempty"`
+	Priority  int             `json:"priority"`
+	State     string          `json:"state"`
+	Attempts  int             `json:"attempts"`
+	Error     string          `json:"error,omitempty"`
+	Deferred  string          `json:"deferred,omitempty"` // Why a queued heavy job is not running yet.
+	Created   int64           `json:"created"`
+	NotBefore int64           `json:"not_before,omitempty"` // A job that failed is not tried again before this, and a job queued for later doesn't run before it.
+	Started   int64           `json:"started,omitempty"`
+	Ended     int64           `json:"ended,omitempty"`
+	Progress  json.RawMessage `json:"progress,omitempty"` // How far the job has got, as it last reported. Kept after it ends, so that a cancelled job shows where it stopped.
+	cancel    chan struct{}
+	cancelled bool
+	done      chan struct{}
+}
+
+// Kind is a kind of job the queue can run.
+type Kind struct {
+	// Run runs the job. The cancel channel is closed if the job is cancelled while it runs; a job that can stop part way through should watch it, and the others finish first.
+	Run         func(args json.RawMessage, cancel <-chan struct{}) error
+	Heavy       bool
+	Concurrency int // The most jobs of the kind that run at the same time. 0 is 1.
+	MaxAttempts int // How many times a job of the kind is tried before it fails. 0 is 1.
+}
+
+var lock sync.Mutex
+var kinds = make(map[string]Kind)
+var jobs []*Job
+var started bool
+var generation int // Counts the starts, so that a job that ends after a stop doesn't write into the state of the next start.
+var stopChan chan bool
+var wake = make(chan struct{}, 1)
+
+func terminal(state string) bool {
+	return state == StateDone || state == StateFailed || state == StateCancelled
+}
+
+func newId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func signal() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// Register adds a kind of job to the ones the queue can run. The kinds are registered before Start, so that the jobs saved at the last shutdown can be run.
+func Register(name string, k Kind) {
+	lock.Lock()
+	defer lock.Unlock()
+	kinds[name] = k
+	signal()
+}
+
+func load() ([]*Job, error) {
+	var loaded []*Job
+	data, err := ioutil.ReadFile(filepath.Join(globals.UserDirectory, stateFile))
+	if err != nil && os.IsNotExist(err) {
+		return loaded, nil
+	} else if err != nil {
+		return loaded, err
+	}
+	err2 := json.Unmarshal(data, &loaded)
+	if err2 != nil {
+		return loaded, errors.New(fmt.Sprintf("The state of the jobs could not be read. Error: %s", err2))
+	}
+	return loaded, nil
+}
+
+// save writes the state of the jobs. The caller holds the lock.
+func save() {
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The state of the jobs could not be encoded. Error: %s", err))
+		return
+	}
+	path := filepath.Join(globals.UserDirectory, stateFile)
+	tmp := fmt.Sprint(path, ".tmp")
+	err2 := ioutil.WriteFile(tmp, data, 0600)
+	if err2 == nil {
+		err2 = os.Rename(tmp, path)
+	}
+	if err2 != nil {
+		logging.Log(1, fmt.Sprintf("The state of the jobs could not be written. Error: %s", err2))
+	}
+}
+
+// trimHistory drops the oldest of the jobs that ended, past JobHistoryKept. The caller holds the lock.
+func trimHistory() {
+	ended := 0
+	for _, j := range jobs {
+		if terminal(j.State) {
+			ended++
+		}
+	}
+	kept := jobs[:0]
+	for _, j := range jobs {
+		if terminal(j.State) && ended > globals.JobHistoryKept {
+			ended--
+			continue
+		}
+		kept = append(kept, j)
+	}
+	jobs = kept
+}
+
+// Start reads the saved state of the jobs, and starts running them. The jobs that were running when the app stopped are queued again.
+func Start() error {
+	loaded, err := load()
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if started {
+		return nil
+	}
+	for _, j := range loaded {
+		if j.State == StateRunning {
+			logging.Log(1, fmt.Sprintf("The job was cut short by a restart, so it is queued again. Job: %s, Kind: %s", j.Id, j.Kind))
+			j.State = StateQueued
+			j.Error = "Cut short by a restart."
+		}
+		j.done = make(chan struct{})
+		if terminal(j.State) {
+			close(j.done)
+		}
+	}
+	jobs = loaded
+	started = true
+	generation++
+	stopChan = make(chan bool)
+	go dispatch(generation, stopChan)
+	return nil
+}
+
+// Stop stops starting jobs. The ones running are not waited for; they are queued again at the next start.
+func Stop() {
+	lock.Lock()
+	defer lock.Unlock()
+	if !started {
+		return
+	}
+	started = false
+	close(stopChan)
+}
+
+func dispatch(gen int, stop chan bool) {
+	for {
+		startRunnable(gen)
+		select {
+		case <-wake:
+		case <-time.After(pollInterval):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// startRunnable starts the queued jobs that can run now, the higher priorities first, as long as there are workers free.
+func startRunnable(gen int) {
+	lock.Lock()
+	defer lock.Unlock()
+	if !started || gen != generation {
+		return
+	}
+	running := 0
+	runningByKind := make(map[string]int)
+	var queued []*Job
+	for _, j := range jobs {
+		if j.State == StateRunning {
+			running++
+			runningByKind[j.Kind]++
+		} else if j.State == StateQueued {
+			queued = append(queued, j)
+		}
+	}
+	sort.SliceStable(queued, func(a, b int) bool {
+		return queued[a].Priority > queued[b].Priority
+	})
+	now := clock.Unix()
+	changed := false
+	for _, j := range queued {
+		if running >= globals.JobWorkers {
+			break
+		}
+		k, ok := kinds[j.Kind]
+		if !ok || j.NotBefore > now {
+			continue
+		}
+		concurrency := k.Concurrency
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		if runningByKind[j.Kind] >= concurrency {
+			continue
+		}
+		if k.Heavy {
+			ok, reason := scheduling.TryHeavy(j.Kind)
+			if !ok {
+				if j.Deferred != reason {
+					logging.Log(2, fmt.Sprintf("The heavy job %s is deferred: %s.", j.Kind, reason))
+					j.Deferred = reason
+					changed = true
+				}
+				continue
+			}
+		}
+		j.State = StateRunning
+		j.Deferred = ""
+		j.Attempts++
+		j.Started = now
+		j.Progress = nil
+		j.cancel = make(chan struct{})
+		j.cancelled = false
+		running++
+		runningByKind[j.Kind]++
+		changed = true
+		go run(j, k, j.cancel, gen)
+	}
+	if changed {
+		save()
+	}
+}
+
+func run(j *Job, k Kind, cancel chan struct{}, gen int) {
+	logging.Log(2, fmt.Sprintf("The job is starting. Job: %s, Kind: %s, Attempt: %d", j.Id, j.Kind, j.Attempts))
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = errors.New(fmt.Sprintf("The job panicked. Panic: %v", r))
+			}
+		}()
+		err = k.Run(j.Args, cancel)
+	}()
+	if k.Heavy {
+		scheduling.ReleaseHeavy()
+	}
+	finish(j, k, err, gen)
+}
+
+func finish(j *Job, k Kind, err error, gen int) {
+	lock.Lock()
+	defer lock.Unlock()
+	j.Ended = clock.Unix()
+	maxAttempts := k.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	switch {
+	case j.cancelled:
+		j.State = StateCancelled
+	case err == nil:
+		j.State = StateDone
+		j.Error = ""
+	case j.Attempts < maxAttempts:
+		j.State = StateQueued
+		j.Error = err.Error()
+		j.NotBefore = j.Ended + int64(globals.JobRetryBackoff/time.Second)*int64(j.Attempts)
+	default:
+		j.State = StateFailed
+		j.Error = err.Error()
+	}
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The job failed. Job: %s, Kind: %s, Attempt: %d, State: %s, Error: %s", j.Id, j.Kind, j.Attempts, j.State, err))
+	} else {
+		logging.Log(2, fmt.Sprintf("The job has ended. Job: %s, Kind: %s, State: %s", j.Id, j.Kind, j.State))
+	}
+	if terminal(j.State) {
+		close(j.done)
+	}
+	if gen == generation {
+		trimHistory()
+		save()
+	}
+	signal()
+}
+
+func find(id string) *Job {
+	for _, j := range jobs {
+		if j.Id == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// Enqueue queues a job of the given kind. The args are given to the job as JSON, so that a job saved at a shutdown can be run after the next start with the same ones.
+func Enqueue(kind string, args interface{}, priority int) (Job, error) {
+	return EnqueueAt(kind, args, priority, 0)
+}
+
+// EnqueueAt queues a job of the given kind that doesn't run before the given time, as a Unix timestamp. It is saved with the others, so it runs at its time even if the app was restarted meanwhile, or soon after the next start if the app was not running then.
+func EnqueueAt(kind string, args interface{}, priority int, notBefore int64) (Job, error) {
+	var raw json.RawMessage
+	if args != nil {
+		data, err := json.Marshal(args)
+		if err != nil {
+			return Job{}, errors.New(fmt.Sprintf("The args of the job could not be encoded. Kind: %s, Error: %s", kind, err))
+		}
+		raw = data
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if _, ok := kinds[kind]; !ok {
+		return Job{}, errors.New(fmt.Sprintf("There is no such kind of job. Kind: %s", kind))
+	}
+	j := &Job{Id: newId(), Kind: kind, Args: raw, Priority: priority, State: StateQueued, Created: clock.Unix(), NotBefore: notBefore, done: make(chan struct{})}
+	jobs = append(jobs, j)
+	save()
+	signal()
+	return *j, nil
+}
+
+// EnqueueOnce queues a job of the given kind, unless one is already queued or running, in which case it gives that one.
+func EnqueueOnce(kind string, priority int) (Job, error) {
+	lock.Lock()
+	for _, j := range jobs {
+		if j.Kind == kind && !terminal(j.State) {
+			lock.Unlock()
+			return *j, nil
+		}
+	}
+	lock.Unlock()
+	return Enqueue(kind, nil, priority)
+}
+
+// List gives the jobs, oldest first.
+func List() []Job {
+	lock.Lock()
+	defer lock.Unlock()
+	result := []Job{}
+	for _, j := range jobs {
+		result = append(result, *j)
+	}
+	return result
+}
+
+// Get gives the job with the given id.
+func Get(id string) (Job, bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	j := find(id)
+	if j == nil {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// Wait gives a channel that is closed when the job has ended, and is not going to be tried again. It is closed already for a job that doesn't exist.
+func Wait(id string) <-chan struct{} {
+	lock.Lock()
+	defer lock.Unlock()
+	j := find(id)
+	if j == nil {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	return j.done
+}
+
+// Cancel cancels a job. A queued job is cancelled right away; a running one is asked to stop, and is cancelled when it does.
+func Cancel(id string) (Job, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	j := find(id)
+	if j == nil {
+		return Job{}, errors.New(fmt.Sprintf("There is no such job. Job: %s", id))
+	}
+	switch j.State {
+	case StateQueued:
+		j.State = StateCancelled
+		j.Ended = clock.Unix()
+		close(j.done)
+		save()
+	case StateRunning:
+		if !j.cancelled {
+			j.cancelled = true
+			close(j.cancel)
+		}
+	default:
+		return *j, errors.New(fmt.Sprintf("The job has already ended. Job: %s, State: %s", id, j.State))
+	}
+	return *j, nil
+}
+
+// ReportProgress records how far the running job with the given cancel channel has got. A job is given a cancel channel of its own for every run, so the channel it was given is enough to find it. The progress is kept in memory while the job runs, and saved with the rest of the state of the job when it ends. It is ignored if the job is not running.
+func ReportProgress(cancel <-chan struct{}, progress interface{}) {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		logging.Log(1, fmt.Sprintf("The progress of the job could not be encoded. Error: %s", err))
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	for _, j := range jobs {
+		if j.State == StateRunning && j.cancel == cancel {
+			j.Progress = data
+			return
+		}
+	}
+}
+
+// Retry queues a job that failed or was cancelled again, with its attempts counted from the start.
+func Retry(id string) (Job, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	j := find(id)
+	if j == nil {
+		return Job{}, errors.New(fmt.Sprintf("There is no such job. Job: %s", id))
+	}
+	if j.State != StateFailed && j.State != StateCancelled {
+		return *j, errors.New(fmt.Sprintf("Only a job that failed or was cancelled can be retried. Job: %s, State: %s", id, j.State))
+	}
+	j.State = StateQueued
+	j.Attempts = 0
+	j.Error = ""
+	j.NotBefore = 0
+	j.Started = 0
+	j.Ended = 0
+	j.done = make(chan struct{})
+	save()
+	signal()
+	return *j, nil
+}
+
+// Schedule queues a job of the given kind repeatedly until it's asked to stop. As in scheduling.Schedule, the interval is counted from the end of the previous job, and a job already queued or running, such as one saved at the last shutdown, is waited for instead of queueing another.
+func Schedule(kind string, priority int, interval time.Duration) chan bool {
+	stop := make(chan bool)
+	go func() {
+		for {
+			j, err := EnqueueOnce(kind, priority)
+			if err != nil {
+				logging.Log(1, err)
+			} else {
+				select {
+				case <-Wait(j.Id):
+				case <-stop:
+					return
+				}
+			}
+			select {
+			case <-time.After(interval):
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
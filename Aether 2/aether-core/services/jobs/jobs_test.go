@@ -0,0 +1,223 @@
+package jobs_test
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/jobs"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+	globals.UserDirectory, _ = ioutil.TempDir("", "jobs")
+	globals.JobRetryBackoff = 0
+	globals.MaintenanceStagger = 0
+	jobs.Start()
+}
+
+func teardown() {
+	jobs.Stop()
+	os.RemoveAll(globals.UserDirectory)
+}
+
+func enqueue(t *testing.T, kind string, priority int) jobs.Job {
+	j, err := jobs.Enqueue(kind, nil, priority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+// waitFor waits until the job is in the given state, and gives it.
+func waitFor(t *testing.T, id string, state string) jobs.Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		j, _ := jobs.Get(id)
+		if j.State == state {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The job did not get to the state. Job: %v, Expected: %s", j, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Tests
+
+func TestQueue_Success(t *testing.T) {
+	globals.JobWorkers = 1
+	defer func() { globals.JobWorkers = 2 }()
+	release := make(chan struct{})
+	jobs.Register("test-block", jobs.Kind{Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		<-release
+		return nil
+	}})
+	var lock sync.Mutex
+	var order []string
+	jobs.Register("test-record", jobs.Kind{Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		var name string
+		json.Unmarshal(args, &name)
+		lock.Lock()
+		order = append(order, name)
+		lock.Unlock()
+		return nil
+	}})
+	blocker := enqueue(t, "test-block", jobs.PriorityNormal)
+	waitFor(t, blocker.Id, jobs.StateRunning)
+	// With the only worker busy, these wait, and run the higher priority first.
+	low, _ := jobs.Enqueue("test-record", "low", jobs.PriorityLow)
+	high, _ := jobs.Enqueue("test-record", "high", jobs.PriorityHigh)
+	close(release)
+	<-jobs.Wait(low.Id)
+	<-jobs.Wait(high.Id)
+	if len(order) != 2 || order[0] != "high" || order[1] != "low" {
+		t.Errorf("The job of the higher priority should have run first. Order: %v", order)
+	}
+	j, _ := jobs.Get(low.Id)
+	if j.State != jobs.StateDone || j.Attempts != 1 || j.Ended == 0 {
+		t.Errorf("The job should have ended. Job: %v", j)
+	}
+	// A kind already queued or running is not queued twice.
+	once, _ := jobs.EnqueueOnce("test-block", jobs.PriorityLow)
+	again, _ := jobs.EnqueueOnce("test-block", jobs.PriorityLow)
+	if once.Id != again.Id {
+		t.Errorf("The job of the kind that is already queued should have been given.")
+	}
+	<-jobs.Wait(once.Id)
+}
+
+func TestQueue_Success_Restart(t *testing.T) {
+	release := make(chan struct{})
+	jobs.Register("test-restart", jobs.Kind{Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		<-release
+		return nil
+	}})
+	j := enqueue(t, "test-restart", jobs.PriorityNormal)
+	waitFor(t, j.Id, jobs.StateRunning)
+	jobs.Stop()
+	jobs.Start()
+	// The job cut short by the restart runs again, and is waiting for the release again.
+	restarted := waitFor(t, j.Id, jobs.StateRunning)
+	if restarted.Attempts != 2 || len(restarted.Error) == 0 {
+		t.Errorf("The job should have been queued again after the restart. Job: %v", restarted)
+	}
+	close(release)
+	waitFor(t, j.Id, jobs.StateDone)
+}
+
+func TestQueue_Fail_Retries(t *testing.T) {
+	runs := 0
+	jobs.Register("test-fail", jobs.Kind{MaxAttempts: 2, Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		runs++
+		return errors.New("Broken.")
+	}})
+	j := enqueue(t, "test-fail", jobs.PriorityNormal)
+	<-jobs.Wait(j.Id)
+	failed, _ := jobs.Get(j.Id)
+	if failed.State != jobs.StateFailed || failed.Attempts != 2 || failed.Error != "Broken." || runs != 2 {
+		t.Errorf("The job should have been tried twice, and failed. Job: %v, Runs: %d", failed, runs)
+	}
+	if _, err := jobs.Retry(j.Id); err != nil {
+		t.Fatalf("The failed job should have been retried. Error: %s", err)
+	}
+	<-jobs.Wait(j.Id)
+	if runs != 4 {
+		t.Errorf("The retried job should have been tried twice more. Runs: %d", runs)
+	}
+	if _, err := jobs.Enqueue("test-nonexistent", nil, jobs.PriorityNormal); err == nil {
+		t.Errorf("A job of a kind that is not registered should not be queued.")
+	}
+}
+
+func TestQueue_Fail_Cancel(t *testing.T) {
+	jobs.Register("test-cancellable", jobs.Kind{Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		<-cancel
+		return nil
+	}})
+	running := enqueue(t, "test-cancellable", jobs.PriorityNormal)
+	waitFor(t, running.Id, jobs.StateRunning)
+	if _, err := jobs.Cancel(running.Id); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, running.Id, jobs.StateCancelled)
+	if _, err := jobs.Cancel(running.Id); err == nil {
+		t.Errorf("A job that has ended should not be cancelled again.")
+	}
+	// A heavy job outside the maintenance windows waits, and can be cancelled before it starts.
+	globals.MaintenanceWindows = []string{"0 0 31 2 *"}
+	defer func() { globals.MaintenanceWindows = []string{} }()
+	jobs.Register("test-heavy", jobs.Kind{Heavy: true, Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		return nil
+	}})
+	heavy := enqueue(t, "test-heavy", jobs.PriorityHigh)
+	time.Sleep(50 * time.Millisecond)
+	deferred, _ := jobs.Get(heavy.Id)
+	if deferred.State != jobs.StateQueued || len(deferred.Deferred) == 0 {
+		t.Errorf("The heavy job should be waiting for a maintenance window. Job: %v", deferred)
+	}
+	jobs.Cancel(heavy.Id)
+	if cancelled, _ := jobs.Get(heavy.Id); cancelled.State != jobs.StateCancelled || cancelled.Attempts != 0 {
+		t.Errorf("The queued job should have been cancelled without running. Job: %v", cancelled)
+	}
+	if _, err := jobs.Retry(running.Id); err != nil {
+		t.Errorf("A cancelled job should be retried. Error: %v", err)
+	}
+	jobs.Cancel(running.Id)
+	<-jobs.Wait(running.Id)
+}
+
+func TestQueue_Success_Progress(t *testing.T) {
+	reported := make(chan struct{})
+	jobs.Register("test-progress", jobs.Kind{Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		jobs.ReportProgress(cancel, map[string]int{"pages_written": 12})
+		close(reported)
+		<-cancel
+		return errors.New("cancelled")
+	}})
+	j := enqueue(t, "test-progress", jobs.PriorityNormal)
+	<-reported
+	if running, _ := jobs.Get(j.Id); string(running.Progress) != `{"pages_written":12}` {
+		t.Errorf("The progress the job reported should be given while it runs. Progress: %s", running.Progress)
+	}
+	jobs.Cancel(j.Id)
+	<-jobs.Wait(j.Id)
+	if ended, _ := jobs.Get(j.Id); ended.State != jobs.StateCancelled || len(ended.Progress) == 0 {
+		t.Errorf("A cancelled job should keep the progress it got to. Job: %v", ended)
+	}
+	// A channel that is not the one of a running job is ignored.
+	jobs.ReportProgress(make(chan struct{}), map[string]int{"pages_written": 1})
+}
+
+func TestQueue_Success_Later(t *testing.T) {
+	jobs.Register("test-later", jobs.Kind{Run: func(args json.RawMessage, cancel <-chan struct{}) error {
+		return nil
+	}})
+	later, err := jobs.EnqueueAt("test-later", nil, jobs.PriorityNormal, time.Now().Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A job queued after it wakes the queue, and runs, while the one for later waits.
+	now := enqueue(t, "test-later", jobs.PriorityLow)
+	<-jobs.Wait(now.Id)
+	if j, _ := jobs.Get(later.Id); j.State != jobs.StateQueued || j.NotBefore != later.NotBefore {
+		t.Errorf("The job queued for later should not run before its time. Job: %v", j)
+	}
+	if _, err2 := jobs.Cancel(later.Id); err2 != nil {
+		t.Errorf("The job queued for later should be cancellable. Error: %s", err2)
+	}
+}
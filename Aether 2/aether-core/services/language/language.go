@@ -0,0 +1,119 @@
+// Services > Language
+// This module gives the language of boards and threads. The author can declare the language of what they create; if they don't, it is guessed from the text. Either way the result is a short lowercase tag such as "en", so that a filter for "en" also matches content declared as "en-GB".
+
+package language
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Normalise turns a declared language tag into the form languages are filtered by: the lowercased primary subtag, such as "pt" for "pt-BR". It returns empty if the tag doesn't start with a two or three letter language code.
+func Normalise(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[0:i]
+	}
+	if len(tag) < 2 || len(tag) > 3 {
+		return ""
+	}
+	for _, r := range tag {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+	}
+	return tag
+}
+
+// scripts are the writing systems that give away the language on their own. Han is checked after the Japanese kana, because Japanese also uses it.
+var scripts = []struct {
+	table    *unicode.RangeTable
+	language string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Greek, "el"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// stopwords are the most frequent short words of the languages written in the Latin script. The words shared by more than one of these languages are left out.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "is", "are", "was", "of", "to", "that", "this", "with", "for", "it", "you", "have", "not"},
+	"es": {"el", "los", "las", "del", "es", "por", "con", "que", "pero", "muy", "como", "y", "lo", "su"},
+	"fr": {"le", "les", "des", "est", "une", "et", "du", "pour", "pas", "avec", "dans", "qui", "sur", "mais"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "mit", "auf", "ich", "sie", "auch", "den"},
+	"pt": {"os", "um", "uma", "não", "com", "mas", "você", "muito", "isso", "são", "também", "ao", "em"},
+	"it": {"il", "gli", "della", "che", "è", "per", "non", "sono", "anche", "questo", "ma", "di"},
+	"nl": {"het", "een", "en", "van", "niet", "dat", "zijn", "ook", "voor", "met", "maar", "wat", "ik"},
+	"tr": {"bir", "ve", "bu", "için", "çok", "ama", "gibi", "daha", "ile", "değil", "ne", "var"},
+}
+
+// minimumStopwords is how many stopwords of a language a Latin text needs to have before it is taken to be in that language.
+const minimumStopwords = 2
+
+// Detect guesses the language of a text. It returns empty if the text doesn't give enough away, which is the case for most short texts. This is a guess to make filtering useful for content whose author didn't declare a language, not a classifier; the declared language always wins.
+func Detect(text string) string {
+	counts := make(map[string]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, s := range scripts {
+			if unicode.Is(s.table, r) {
+				counts[s.language]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// Japanese is written mostly in Han with some kana, so any kana at all settles it.
+	if counts["ja"] > 0 && counts["ja"]+counts["zh"] > letters/2 {
+		return "ja"
+	}
+	for _, s := range scripts {
+		if counts[s.language] > letters/2 {
+			return s.language
+		}
+	}
+	return detectLatin(text)
+}
+
+func detectLatin(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	seen := make(map[string]bool)
+	for _, w := range words {
+		seen[w] = true
+	}
+	best := ""
+	bestCount := 0
+	tied := false
+	for lang, list := range stopwords {
+		count := 0
+		for _, w := range list {
+			if seen[w] {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount, tied = lang, count, false
+		} else if count == bestCount && count > 0 {
+			tied = true
+		}
+	}
+	if bestCount < minimumStopwords || tied {
+		return ""
+	}
+	return best
+}
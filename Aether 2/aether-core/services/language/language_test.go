@@ -0,0 +1,72 @@
+package language_test
+
+import (
+	"aether-core/services/language"
+	"os"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+}
+
+func teardown() {
+}
+
+// Tests
+
+func TestNormalise_Success(t *testing.T) {
+	cases := map[string]string{
+		"en":     "en",
+		"pt-BR":  "pt",
+		"EN_gb":  "en",
+		" de ":   "de",
+		"fil-PH": "fil",
+	}
+	for tag, expected := range cases {
+		if got := language.Normalise(tag); got != expected {
+			t.Errorf("Expected %s for %s, got %s.", expected, tag, got)
+		}
+	}
+}
+
+func TestNormalise_Fail_NotATag(t *testing.T) {
+	for _, tag := range []string{"", "e", "english", "e1", "-en"} {
+		if got := language.Normalise(tag); got != "" {
+			t.Errorf("Expected nothing for %s, got %s.", tag, got)
+		}
+	}
+}
+
+func TestDetect_Success(t *testing.T) {
+	cases := map[string]string{
+		"This is the board for all things related to the weather.": "en",
+		"Der Hund ist nicht auf dem Sofa, und die Katze auch.":     "de",
+		"Les chats sont dans le jardin avec des chiens.":           "fr",
+		"Это доска для обсуждения погоды.":                         "ru",
+		"今日はいい天気ですね":                                               "ja",
+		"今天天气很好":                                                   "zh",
+		"오늘 날씨가 좋네요":                                               "ko",
+	}
+	for text, expected := range cases {
+		if got := language.Detect(text); got != expected {
+			t.Errorf("Expected %s for %q, got %s.", expected, text, got)
+		}
+	}
+}
+
+func TestDetect_Fail_Unsure(t *testing.T) {
+	for _, text := range []string{"", "12345", "Aether", "lorem ipsum dolor sit amet"} {
+		if got := language.Detect(text); got != "" {
+			t.Errorf("Expected nothing for %q, got %s.", text, got)
+		}
+	}
+}
@@ -5,6 +5,10 @@ package logging
 
 import (
 	"aether-core/services/globals"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"log"
 )
 
@@ -18,3 +22,52 @@ func Log(level int, input interface{}) {
 func LogCrash(input interface{}) {
 	log.Fatal(input)
 }
+
+// LogTrace prints to the standard logger, tagged with the trace id of the request it belongs to. Searching the logs of two nodes for the same trace id shows both sides of a sync.
+func LogTrace(traceId string, level int, input interface{}) {
+	if globals.LoggingLevel >= level {
+		log.Println(fmt.Sprintf("[trace:%s]", traceId), input)
+	}
+}
+
+type traceKey struct{}
+
+// WithTrace gives a context that carries the trace id, for the parts of the backend that log while serving a request without being given the request itself, such as the reads of the database.
+func WithTrace(ctx context.Context, traceId string) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceId)
+}
+
+// TraceOf gives the trace id the context carries, or an empty string if it carries none.
+func TraceOf(ctx context.Context) string {
+	traceId, _ := ctx.Value(traceKey{}).(string)
+	return traceId
+}
+
+// LogContext logs like LogTrace if the context carries a trace id, and like Log if it does not.
+func LogContext(ctx context.Context, level int, input interface{}) {
+	if traceId := TraceOf(ctx); len(traceId) > 0 {
+		LogTrace(traceId, level, input)
+		return
+	}
+	Log(level, input)
+}
+
+// NewTraceId creates a random trace id.
+func NewTraceId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidTraceId checks whether a trace id given by a remote is safe to put into the logs: short, and only letters, digits and dashes.
+func ValidTraceId(traceId string) bool {
+	if len(traceId) == 0 || len(traceId) > 64 {
+		return false
+	}
+	for _, c := range traceId {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-') {
+			return false
+		}
+	}
+	return true
+}
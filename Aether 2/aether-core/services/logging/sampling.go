@@ -0,0 +1,108 @@
+// Services > Logging > Sampling
+// This file keeps the logs readable during floods. The messages that can come once per entity or once per request are logged through LogSampled with a key that names the kind of message; only the first few of each key are logged in every window, and the rest are counted and summarised in a single line when the window is over.
+
+package logging
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+type sampleKey struct {
+	component string
+	key       string
+}
+
+type sampleWindow struct {
+	start      time.Time
+	logged     int
+	suppressed int
+}
+
+var samplingLock sync.Mutex
+var sampleWindows = make(map[sampleKey]*sampleWindow)
+
+// sampleLimit gives how many messages of a key of the component are logged in a window. 0 means all of them are.
+func sampleLimit(component string) int {
+	if limit, ok := globals.LogSampleComponentLimits[component]; ok {
+		return limit
+	}
+	return globals.LogSampleLimit
+}
+
+// LogSampled logs like Log, but only the first messages of each key in every window of LogSampleWindow. The key names the kind of message, such as "pow-policy", not the message itself, which usually has an entity in it. The component is the part of the application the message comes from, and the limits can be set per component.
+func LogSampled(component string, key string, level int, input interface{}) {
+	if globals.LoggingLevel < level {
+		return
+	}
+	limit := sampleLimit(component)
+	if limit <= 0 {
+		log.Println(input)
+		return
+	}
+	now := clock.Now()
+	k := sampleKey{component, key}
+	samplingLock.Lock()
+	w, ok := sampleWindows[k]
+	if ok && now.Sub(w.start) >= globals.LogSampleWindow {
+		summarise(k, w)
+		ok = false
+	}
+	if !ok {
+		w = &sampleWindow{start: now}
+		sampleWindows[k] = w
+	}
+	if w.logged < limit {
+		w.logged++
+		samplingLock.Unlock()
+		log.Println(input)
+		return
+	}
+	w.suppressed++
+	samplingLock.Unlock()
+}
+
+// LogSampledTrace is LogSampled, tagged with the trace id as LogTrace does. An empty trace id is not tagged. The messages of a key are sampled together, whichever trace they belong to.
+func LogSampledTrace(traceId string, component string, key string, level int, input interface{}) {
+	if len(traceId) > 0 {
+		input = fmt.Sprint(fmt.Sprintf("[trace:%s] ", traceId), input)
+	}
+	LogSampled(component, key, level, input)
+}
+
+// summarise logs how many messages of the key were left out in the window. It has to be called with the lock held.
+func summarise(k sampleKey, w *sampleWindow) {
+	if w.suppressed == 0 {
+		return
+	}
+	log.Println(fmt.Sprintf("[%s] %d more messages of the kind %q were not logged in the %s starting at %s.", k.component, w.suppressed, k.key, globals.LogSampleWindow, w.start.Format(time.RFC3339)))
+}
+
+// FlushSampled summarises the windows that are over, and forgets them. Without this, the count of a key that stops coming would only be logged when it comes again.
+func FlushSampled() {
+	now := clock.Now()
+	samplingLock.Lock()
+	defer samplingLock.Unlock()
+	var keys []sampleKey
+	for k, w := range sampleWindows {
+		if now.Sub(w.start) >= globals.LogSampleWindow {
+			keys = append(keys, k)
+		}
+	}
+	// Sorted, so that the summaries of a flush come out in the same order every time.
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].component != keys[j].component {
+			return keys[i].component < keys[j].component
+		}
+		return keys[i].key < keys[j].key
+	})
+	for _, k := range keys {
+		summarise(k, sampleWindows[k])
+		delete(sampleWindows, k)
+	}
+}
@@ -0,0 +1,85 @@
+package logging_test
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Infrastructure, setup and teardown
+
+var out bytes.Buffer
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+	globals.LoggingLevel = 2
+	log.SetOutput(&out)
+}
+
+func teardown() {
+	log.SetOutput(os.Stderr)
+	clock.Reset()
+}
+
+// Tests
+
+func TestLogSampled_Success(t *testing.T) {
+	out.Reset()
+	mc := clock.NewMockClock(time.Unix(1500000000, 0))
+	clock.Set(mc)
+	globals.LogSampleLimit = 3
+	for i := 0; i < 10; i++ {
+		logging.LogSampled("test", "flood", 1, "A flooding message.")
+	}
+	if n := strings.Count(out.String(), "A flooding message."); n != 3 {
+		t.Errorf("Expected 3 messages in the window, got %d.", n)
+	}
+	mc.Advance(2 * globals.LogSampleWindow)
+	logging.FlushSampled()
+	if !strings.Contains(out.String(), `7 more messages of the kind "flood"`) {
+		t.Errorf("The suppressed messages should have been summarised. Log: %s", out.String())
+	}
+	out.Reset()
+	logging.LogSampled("test", "flood", 1, "A flooding message.")
+	if n := strings.Count(out.String(), "A flooding message."); n != 1 {
+		t.Errorf("A new window should start logging again. Got %d messages.", n)
+	}
+}
+
+func TestLogSampled_Fail_OverComponentLimit(t *testing.T) {
+	out.Reset()
+	clock.Set(clock.NewMockClock(time.Unix(1600000000, 0)))
+	globals.LogSampleLimit = 100
+	globals.LogSampleComponentLimits = map[string]int{"noisy": 1, "unlimited": 0}
+	defer func() { globals.LogSampleComponentLimits = make(map[string]int) }()
+	for i := 0; i < 5; i++ {
+		logging.LogSampled("noisy", "flood", 1, "A noisy message.")
+		logging.LogSampled("unlimited", "flood", 1, "An unlimited message.")
+		logging.LogSampled("quiet", "flood", 2, "A quiet message.")
+	}
+	if n := strings.Count(out.String(), "A noisy message."); n != 1 {
+		t.Errorf("Expected the component limit of 1 to apply, got %d messages.", n)
+	}
+	if n := strings.Count(out.String(), "An unlimited message."); n != 5 {
+		t.Errorf("Expected a limit of 0 to log everything, got %d messages.", n)
+	}
+	globals.LoggingLevel = 1
+	defer func() { globals.LoggingLevel = 2 }()
+	logging.LogSampled("quiet", "other", 2, "A message above the logging level.")
+	if strings.Contains(out.String(), "above the logging level") {
+		t.Errorf("A message above the logging level should not be logged.")
+	}
+}
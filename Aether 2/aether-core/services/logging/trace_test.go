@@ -0,0 +1,47 @@
+package logging_test
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTraceOf_Success(t *testing.T) {
+	ctx := logging.WithTrace(context.Background(), "trace-1")
+	if id := logging.TraceOf(ctx); id != "trace-1" {
+		t.Errorf("The context should carry the trace id. Got: %s", id)
+	}
+	if id := logging.TraceOf(context.Background()); len(id) > 0 {
+		t.Errorf("A context without a trace id should give an empty one. Got: %s", id)
+	}
+}
+
+func TestLogContext_Success(t *testing.T) {
+	out.Reset()
+	logging.LogContext(logging.WithTrace(context.Background(), "trace-2"), 1, "A read failed.")
+	if !strings.Contains(out.String(), "[trace:trace-2] A read failed.") {
+		t.Errorf("The message should be tagged with the trace id. Log: %s", out.String())
+	}
+	out.Reset()
+	logging.LogContext(context.Background(), 1, "A read failed.")
+	if strings.Contains(out.String(), "[trace:") || !strings.Contains(out.String(), "A read failed.") {
+		t.Errorf("The message should be logged untagged without a trace id. Log: %s", out.String())
+	}
+}
+
+func TestLogSampledTrace_Success(t *testing.T) {
+	out.Reset()
+	limit := globals.LogSampleLimit
+	globals.LogSampleLimit = 0
+	defer func() { globals.LogSampleLimit = limit }()
+	logging.LogSampledTrace("trace-3", "test", "traced", 1, "A slow query.")
+	logging.LogSampledTrace("", "test", "traced", 1, "Another slow query.")
+	if !strings.Contains(out.String(), "[trace:trace-3] A slow query.") {
+		t.Errorf("The message should be tagged with the trace id. Log: %s", out.String())
+	}
+	if strings.Contains(out.String(), "[trace:] ") {
+		t.Errorf("A message without a trace id should not be tagged. Log: %s", out.String())
+	}
+}
@@ -0,0 +1,72 @@
+// Services > Membership
+// This module keeps private networks apart from the public one, and from each other. A node only talks to remotes with the same network id and, if a membership key is set, with a valid proof that they hold the same key.
+
+package membership
+
+import (
+	"aether-core/services/globals"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proofValidity is how far the timestamp of a proof can be from the local clock. This bounds how long a captured proof can be replayed.
+const proofValidity = 10 * time.Minute
+
+func mac(key string, nodeId string, networkId string, ts int64) string {
+	m := hmac.New(sha256.New, []byte(key))
+	m.Write([]byte(fmt.Sprint(nodeId, "|", networkId, "|", ts)))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// CreateProof creates the membership proof of the local node, in the form of "timestamp.hmac". If there is no membership key, the proof is empty.
+func CreateProof(nodeId string, now time.Time) string {
+	if len(globals.NetworkMembershipKey) == 0 {
+		return ""
+	}
+	ts := now.Unix()
+	return fmt.Sprint(ts, ".", mac(globals.NetworkMembershipKey, nodeId, globals.NetworkId, ts))
+}
+
+// VerifyProof checks a proof created by a remote against the local membership key.
+func VerifyProof(nodeId string, networkId string, proof string, now time.Time) bool {
+	parts := strings.SplitN(proof, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return false
+	}
+	diff := now.Sub(time.Unix(ts, 0))
+	if diff > proofValidity || diff < -proofValidity {
+		return false
+	}
+	expected := mac(globals.NetworkMembershipKey, nodeId, networkId, ts)
+	return hmac.Equal([]byte(expected), []byte(parts[1]))
+}
+
+// CheckNetwork checks only the network id. This is for the data that is baked ahead of time, like caches, where a proof would have long expired.
+func CheckNetwork(networkId string) error {
+	if networkId != globals.NetworkId {
+		return errors.New(fmt.Sprintf("The remote is in a different network. Local network: '%s', Remote network: '%s'", globals.NetworkId, networkId))
+	}
+	return nil
+}
+
+// Admit checks whether a remote can be talked to. The remote has to be in the same network, and if the network has a membership key, it has to provide a valid proof.
+func Admit(networkId string, nodeId string, proof string) error {
+	err := CheckNetwork(networkId)
+	if err != nil {
+		return err
+	}
+	if len(globals.NetworkMembershipKey) > 0 && !VerifyProof(nodeId, networkId, proof, time.Now()) {
+		return errors.New(fmt.Sprintf("The remote did not provide a valid membership proof for this network. Network: '%s', Node: %s", networkId, nodeId))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package membership_test
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/membership"
+	"os"
+	"testing"
+	"time"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.NetworkId = "team-network"
+	globals.NetworkMembershipKey = "shared secret"
+}
+
+func teardown() {
+}
+
+// Tests
+
+func TestAdmit_Success(t *testing.T) {
+	proof := membership.CreateProof("node1", time.Now())
+	err := membership.Admit("team-network", "node1", proof)
+	if err != nil {
+		t.Errorf("A valid member was refused. Err: '%s'", err)
+	}
+}
+
+func TestAdmit_Fail_OtherNetwork(t *testing.T) {
+	proof := membership.CreateProof("node1", time.Now())
+	err := membership.Admit("", "node1", proof)
+	if err == nil {
+		t.Errorf("A remote from the public network was admitted into a private one.")
+	}
+}
+
+func TestAdmit_Fail_WrongNode(t *testing.T) {
+	proof := membership.CreateProof("node1", time.Now())
+	err := membership.Admit("team-network", "node2", proof)
+	if err == nil {
+		t.Errorf("A proof created for another node was accepted.")
+	}
+}
+
+func TestAdmit_Fail_ExpiredProof(t *testing.T) {
+	proof := membership.CreateProof("node1", time.Now().Add(-1*time.Hour))
+	err := membership.Admit("team-network", "node1", proof)
+	if err == nil {
+		t.Errorf("An expired proof was accepted.")
+	}
+}
+
+func TestAdmit_Fail_WrongKey(t *testing.T) {
+	proof := membership.CreateProof("node1", time.Now())
+	globals.NetworkMembershipKey = "another secret"
+	defer func() { globals.NetworkMembershipKey = "shared secret" }()
+	err := membership.Admit("team-network", "node1", proof)
+	if err == nil {
+		t.Errorf("A proof created with another key was accepted.")
+	}
+}
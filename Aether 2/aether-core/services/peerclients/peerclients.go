@@ -0,0 +1,105 @@
+// Services > PeerClients
+// This module keeps statistics of the clients that reach this node, from the X-Aether headers of their requests: which client and version each remote runs, which protocol extensions it supports, and how often it asks. A remote that gives its node id is counted under it, one that doesn't under its address.
+
+package peerclients
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"sort"
+	"sync"
+)
+
+// PeerClient is what is known of the client of one remote.
+type PeerClient struct {
+	Peer      string         `json:"peer"` // The node id, or the address if the remote didn't give one.
+	Address   string         `json:"address"`
+	Client    api.ClientInfo `json:"client"`
+	Requests  int64          `json:"requests"`
+	FirstSeen int64          `json:"first_seen"`
+	LastSeen  int64          `json:"last_seen"`
+}
+
+// Report is the statistics of all the remotes kept, and the count of them by client version.
+type Report struct {
+	Peers    []PeerClient   `json:"peers"`
+	Versions map[string]int `json:"versions"`
+}
+
+var lock sync.Mutex
+var peers = make(map[string]*PeerClient)
+
+// Note counts a request from the remote at the address, with what its headers say about its client.
+func Note(address string, c api.ClientInfo) {
+	key := c.NodeId
+	if len(key) == 0 {
+		key = address
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	now := clock.Unix()
+	p, ok := peers[key]
+	if !ok {
+		p = &PeerClient{Peer: key, FirstSeen: now}
+		peers[key] = p
+	}
+	p.Address = address
+	p.Client = c
+	p.Requests++
+	p.LastSeen = now
+	if !ok {
+		dropOldest(key)
+	}
+}
+
+// dropOldest drops the remotes seen least recently, other than the one just added, until there are no more than PeerClientsKept.
+func dropOldest(added string) {
+	for len(peers) > globals.PeerClientsKept {
+		var oldest *PeerClient
+		for _, p := range peers {
+			if p.Peer != added && (oldest == nil || p.LastSeen < oldest.LastSeen) {
+				oldest = p
+			}
+		}
+		delete(peers, oldest.Peer)
+	}
+}
+
+// Get gives what is known of the client of the remote, by its node id or its address.
+func Get(peer string) (api.ClientInfo, bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	p, ok := peers[peer]
+	if !ok {
+		return api.ClientInfo{}, false
+	}
+	return p.Client, true
+}
+
+// GetReport gives the statistics of the remotes, the ones seen most recently first.
+func GetReport() Report {
+	lock.Lock()
+	defer lock.Unlock()
+	r := Report{Peers: []PeerClient{}, Versions: make(map[string]int)}
+	for _, p := range peers {
+		r.Peers = append(r.Peers, *p)
+		if len(p.Client.ClientName) > 0 {
+			r.Versions[p.Client.Version()]++
+		}
+	}
+	sort.Slice(r.Peers, func(i, j int) bool {
+		if r.Peers[i].LastSeen != r.Peers[j].LastSeen {
+			return r.Peers[i].LastSeen > r.Peers[j].LastSeen
+		}
+		return r.Peers[i].Peer < r.Peers[j].Peer
+	})
+	return r
+}
+
+// Reset forgets all the remotes.
+func Reset() {
+	lock.Lock()
+	defer lock.Unlock()
+	peers = make(map[string]*PeerClient)
+}
@@ -0,0 +1,107 @@
+package peerclients_test
+
+import (
+	"aether-core/io/api"
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/peerclients"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Infrastructure, setup and teardown
+
+var mc *clock.MockClock
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+	mc = clock.NewMockClock(time.Unix(1500000000, 0))
+	clock.Set(mc)
+}
+
+func teardown() {
+	clock.Reset()
+}
+
+// Tests
+
+func TestClientHeaders_Success(t *testing.T) {
+	globals.NodeId = strings.Repeat("a", 64)
+	h := http.Header{}
+	api.SetClientHeaders(h)
+	c, found := api.ParseClientHeaders(h)
+	if !found || c.NodeId != globals.NodeId || c.ClientName != globals.ClientName || !c.HasExtension("aether") {
+		t.Errorf("The headers set on a request should read back as this node. Client: %#v", c)
+	}
+	if !c.AtLeast(2, 0, 0) || c.AtLeast(2, 0, 1) || c.AtLeast(3, 0, 0) || !c.AtLeast(1, 9, 9) {
+		t.Errorf("The version of the client should compare in order. Client: %#v", c)
+	}
+	globals.SendClientHeaders = false
+	defer func() { globals.SendClientHeaders = true }()
+	h2 := http.Header{}
+	api.SetClientHeaders(h2)
+	if len(h2) != 0 {
+		t.Errorf("The headers should not be set when they are turned off. Headers: %#v", h2)
+	}
+}
+
+func TestClientHeaders_Fail(t *testing.T) {
+	h := http.Header{}
+	h.Set(api.NodeIdHeader, "short")
+	h.Set(api.ClientHeader, "Aether/2.x.0")
+	if c, found := api.ParseClientHeaders(h); found {
+		t.Errorf("Headers that are not valid should be left out. Client: %#v", c)
+	}
+	h.Set(api.ClientHeader, strings.Repeat("n", 100)+"/2.0.0")
+	h.Set(api.ExtensionsHeader, strings.Repeat("x,", 100))
+	c, found := api.ParseClientHeaders(h)
+	if !found || len(c.ClientName) != 0 || len(c.Extensions) != 32 {
+		t.Errorf("A client name over the limit should be left out, and the extensions cut at the limit. Client: %#v", c)
+	}
+}
+
+func TestNote_Success(t *testing.T) {
+	peerclients.Reset()
+	id := strings.Repeat("b", 64)
+	peerclients.Note("10.0.0.1", api.ClientInfo{NodeId: id, ClientName: "Aether", VersionMajor: 2})
+	mc.Advance(time.Minute)
+	peerclients.Note("10.0.0.2", api.ClientInfo{NodeId: id, ClientName: "Aether", VersionMajor: 2, VersionMinor: 1})
+	peerclients.Note("10.0.0.3", api.ClientInfo{ClientName: "Other", VersionMajor: 1})
+	r := peerclients.GetReport()
+	if len(r.Peers) != 2 || r.Versions["Aether/2.1.0"] != 1 || r.Versions["Other/1.0.0"] != 1 {
+		t.Fatalf("The remotes should be counted by node id, or by address without one. Report: %#v", r)
+	}
+	if r.Peers[0].Peer != id && r.Peers[1].Peer != id {
+		t.Fatalf("The remote with a node id should be kept under it. Report: %#v", r)
+	}
+	c, ok := peerclients.Get(id)
+	if !ok || c.VersionMinor != 1 {
+		t.Errorf("The client of a remote should be the one it gave last. Client: %#v", c)
+	}
+	if _, ok := peerclients.Get("10.0.0.3"); !ok {
+		t.Errorf("A remote without a node id should be found by its address.")
+	}
+}
+
+func TestNote_DropsOldest(t *testing.T) {
+	peerclients.Reset()
+	defer func(v int) { globals.PeerClientsKept = v }(globals.PeerClientsKept)
+	globals.PeerClientsKept = 2
+	for _, address := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		peerclients.Note(address, api.ClientInfo{ClientName: "Aether"})
+		mc.Advance(time.Second)
+	}
+	if _, ok := peerclients.Get("10.0.0.1"); ok || len(peerclients.GetReport().Peers) != 2 {
+		t.Errorf("The remote seen least recently should be dropped over the limit. Report: %#v", peerclients.GetReport())
+	}
+}
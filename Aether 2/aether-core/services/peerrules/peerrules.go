@@ -0,0 +1,161 @@
+// Services > Peer Rules
+// This module decides which remotes this node talks to. The operator can block remotes by node id or IP range, or allow only the ones listed. The rules come from two places: the ones in the settings, and the ones the operator adds at runtime, which are saved in the user directory so that they survive restarts.
+
+package peerrules
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+)
+
+var rulesLock sync.Mutex
+
+// added are the rules added at runtime.
+var added []globals.PeerRule
+
+func rulesPath() string {
+	return fmt.Sprint(globals.UserDirectory, "/peerrules.json")
+}
+
+// Load reads the rules added at runtime from the user directory.
+func Load() error {
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+	data, err := ioutil.ReadFile(rulesPath())
+	if err != nil && os.IsNotExist(err) {
+		added = []globals.PeerRule{}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var rules []globals.PeerRule
+	err2 := json.Unmarshal(data, &rules)
+	if err2 != nil {
+		return errors.New(fmt.Sprintf("The peer rules could not be read. Error: %s", err2))
+	}
+	added = rules
+	return nil
+}
+
+// save writes the rules added at runtime into the user directory. Expired rules are dropped on the way. It has to be called with the lock held.
+func save() error {
+	var live []globals.PeerRule
+	for _, r := range added {
+		if !expired(r) {
+			live = append(live, r)
+		}
+	}
+	added = live
+	data, err := json.Marshal(added)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(globals.UserDirectory, 0755)
+	return ioutil.WriteFile(rulesPath(), data, 0600)
+}
+
+func expired(r globals.PeerRule) bool {
+	return r.Expiry > 0 && r.Expiry <= clock.Unix()
+}
+
+// validate checks that a rule matches something, and that its IP range is valid.
+func validate(r globals.PeerRule) error {
+	if len(r.NodeId) == 0 && len(r.CIDR) == 0 {
+		return errors.New("A peer rule needs a node id, an IP range, or both.")
+	}
+	if len(r.CIDR) > 0 {
+		_, _, err := net.ParseCIDR(r.CIDR)
+		if err != nil {
+			return errors.New(fmt.Sprintf("The IP range of the peer rule is invalid. Range: %s, Error: %s", r.CIDR, err))
+		}
+	}
+	return nil
+}
+
+// Add adds a rule at runtime and saves it.
+func Add(r globals.PeerRule) error {
+	err := validate(r)
+	if err != nil {
+		return err
+	}
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+	added = append(added, r)
+	return save()
+}
+
+// Remove removes the rules added at runtime that have the given node id and IP range. It returns how many were removed. The rules in the settings can't be removed this way.
+func Remove(nodeId string, cidr string) (int, error) {
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+	var kept []globals.PeerRule
+	for _, r := range added {
+		if r.NodeId == nodeId && r.CIDR == cidr {
+			continue
+		}
+		kept = append(kept, r)
+	}
+	removed := len(added) - len(kept)
+	added = kept
+	return removed, save()
+}
+
+// List returns all rules in effect, the ones in the settings first.
+func List() []globals.PeerRule {
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+	var rules []globals.PeerRule
+	for _, r := range append(append([]globals.PeerRule{}, globals.PeerRules...), added...) {
+		if !expired(r) {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// matches checks whether the rule applies to the remote. Either of the IP and the node id can be unknown; a rule that needs an unknown one does not match.
+func matches(r globals.PeerRule, ip net.IP, nodeId string) bool {
+	nodeMatch := len(r.NodeId) == 0 || (len(nodeId) > 0 && r.NodeId == nodeId)
+	ipMatch := len(r.CIDR) == 0
+	if len(r.CIDR) > 0 && ip != nil {
+		_, ipNet, err := net.ParseCIDR(r.CIDR)
+		ipMatch = err == nil && ipNet.Contains(ip)
+	}
+	if len(r.NodeId) == 0 && len(r.CIDR) == 0 {
+		return false
+	}
+	return nodeMatch && ipMatch
+}
+
+// Allowed checks whether this node should talk to the remote. The location is the IP address of the remote, and it can be a host name, in which case the IP rules don't apply to it. Either can be empty if it is not known yet; the check is then made again when it is known. In whitelist-only mode, a remote whose node id is not known yet is allowed if there are node ids in the whitelist, since it can still turn out to be one of them.
+func Allowed(location string, nodeId string) bool {
+	ip := net.ParseIP(location)
+	rules := List()
+	whitelisted := false
+	whitelistHasNodeIds := false
+	for _, r := range rules {
+		if r.Allow {
+			if len(r.NodeId) > 0 {
+				whitelistHasNodeIds = true
+			}
+			if matches(r, ip, nodeId) {
+				whitelisted = true
+			}
+			continue
+		}
+		if matches(r, ip, nodeId) {
+			return false
+		}
+	}
+	if !globals.PeerWhitelistOnly || whitelisted {
+		return true
+	}
+	return len(nodeId) == 0 && whitelistHasNodeIds
+}
@@ -0,0 +1,116 @@
+package peerrules_test
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/peerrules"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Infrastructure, setup and teardown
+
+var tempDir string
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	tempDir, _ = ioutil.TempDir("", "peerrules")
+	globals.UserDirectory = tempDir
+}
+
+func teardown() {
+	os.RemoveAll(tempDir)
+}
+
+func reset() {
+	globals.PeerRules = []globals.PeerRule{}
+	globals.PeerWhitelistOnly = false
+	os.Remove(tempDir + "/peerrules.json")
+	peerrules.Load()
+}
+
+// Tests
+
+func TestAllowed_Success_NoRules(t *testing.T) {
+	reset()
+	if !peerrules.Allowed("10.0.0.1", "node1") {
+		t.Errorf("A remote was blocked without any rules.")
+	}
+}
+
+func TestAllowed_Fail_BlockedRange(t *testing.T) {
+	reset()
+	globals.PeerRules = []globals.PeerRule{globals.PeerRule{CIDR: "10.0.0.0/8"}}
+	if peerrules.Allowed("10.1.2.3", "") {
+		t.Errorf("A remote in a blocked range was allowed.")
+	}
+	if !peerrules.Allowed("192.168.1.1", "") {
+		t.Errorf("A remote outside of the blocked range was blocked.")
+	}
+}
+
+func TestAllowed_Fail_BlockedNodeId(t *testing.T) {
+	reset()
+	err := peerrules.Add(globals.PeerRule{NodeId: "node1"})
+	if err != nil {
+		t.Errorf("Test failed, err: '%s'", err)
+	}
+	if peerrules.Allowed("10.0.0.1", "node1") {
+		t.Errorf("A blocked node was allowed.")
+	}
+	// The rule survives a reload.
+	peerrules.Load()
+	if peerrules.Allowed("10.0.0.1", "node1") {
+		t.Errorf("A blocked node was allowed after the rules were loaded again.")
+	}
+}
+
+func TestAllowed_Success_ExpiredRule(t *testing.T) {
+	reset()
+	globals.PeerRules = []globals.PeerRule{globals.PeerRule{NodeId: "node1", Expiry: clock.Unix() - 1}}
+	if !peerrules.Allowed("10.0.0.1", "node1") {
+		t.Errorf("An expired rule blocked a remote.")
+	}
+}
+
+func TestAllowed_WhitelistOnly(t *testing.T) {
+	reset()
+	globals.PeerWhitelistOnly = true
+	globals.PeerRules = []globals.PeerRule{globals.PeerRule{NodeId: "node1", Allow: true}}
+	if !peerrules.Allowed("10.0.0.1", "node1") {
+		t.Errorf("A whitelisted node was blocked.")
+	}
+	if peerrules.Allowed("10.0.0.1", "node2") {
+		t.Errorf("A node that is not whitelisted was allowed.")
+	}
+	if !peerrules.Allowed("10.0.0.1", "") {
+		t.Errorf("A remote whose node id is not known yet was blocked, although it could be whitelisted.")
+	}
+}
+
+func TestRemove_Success(t *testing.T) {
+	reset()
+	peerrules.Add(globals.PeerRule{CIDR: "10.0.0.0/8"})
+	removed, err := peerrules.Remove("", "10.0.0.0/8")
+	if err != nil || removed != 1 {
+		t.Errorf("Test failed, removed: %d, err: '%s'", removed, err)
+	}
+	if !peerrules.Allowed("10.1.2.3", "") {
+		t.Errorf("A remote was blocked by a removed rule.")
+	}
+}
+
+func TestAdd_Fail_InvalidRange(t *testing.T) {
+	reset()
+	err := peerrules.Add(globals.PeerRule{CIDR: "not a range"})
+	if err == nil {
+		t.Errorf("An invalid range was accepted.")
+	}
+}
@@ -281,3 +281,18 @@ func Verify(input string, pow string, pubKey string) (bool, int64, error) {
 			"This proof of work is in a format Mim does not support. PoW: ", pow))
 	}
 }
+
+// DeclaredDifficulty returns the difficulty a proof of work claims to have, without verifying it. This is cheap, and it's useful to skip the entities that would fail a policy check before spending time on a full verification. Whether the claim is true is determined by Verify.
+func DeclaredDifficulty(pow string) (int64, error) {
+	parsedStrings := strings.SplitN(pow, ":", 9)
+	if len(parsedStrings) != 8 {
+		return 0, errors.New(fmt.Sprint(
+			"PoW had more or less fields than expected. PoW: ", pow))
+	}
+	parsedDifficulty, err := strconv.ParseInt(parsedStrings[1], 10, 64)
+	if err != nil {
+		return 0, errors.New(fmt.Sprint(
+			"PoW parsing failed, this PoW is invalid. Error: ", err))
+	}
+	return parsedDifficulty, nil
+}
@@ -3,12 +3,14 @@ package proofofwork_test
 import (
 	"aether-core/io/api"
 	"aether-core/services/globals"
+	"aether-core/services/proofofwork"
 	"aether-core/services/signaturing"
 	// "fmt"
 	// "log"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"encoding/hex"
+	"encoding/json"
 	"os"
 	"strings"
 	"testing"
@@ -141,6 +143,18 @@ func TestVerifyPoW_Success_WithKey(t *testing.T) {
 	}
 }
 
+// The proofs of work made over the canonical JSON of the entity, with its keys sorted, are accepted along with the ones over the JSON of the struct.
+func TestVerifyPoW_Success_CanonicalForm(t *testing.T) {
+	canonicalBoard := newboard
+	canonicalBoard.ProofOfWork = "MIM1:20::::INOuqHroLuQYWiQM:399551:"
+	result, err := canonicalBoard.VerifyPoW("")
+	if err != nil {
+		t.Errorf("Test failed, err: '%s'", err)
+	} else if result != true {
+		t.Errorf("Test failed, this PoW should be valid but it is not.")
+	}
+}
+
 func TestVerifyPoW_Fail_SignatureKeyMismatch_WithKey(t *testing.T) {
 	_, err := signedNewboard.VerifyPoW("fake key")
 	errMessage := "The signature of this PoW is invalid. The PoW signature and the public key provided does not match."
@@ -411,6 +425,25 @@ func TestCreatePoW_Success_WithoutKey(t *testing.T) {
 	}
 }
 
+// The proofs of work are made over the JSON of the struct, in the order of its fields, which the nodes that know no other form check them against.
+func TestCreatePoW_Success_StructOrder(t *testing.T) {
+	var newboard2 api.Board
+	newboard2.Creation = 4564654
+	newboard2.Name = "my board name"
+	newboard2.Description = "my board description"
+	err := newboard2.CreatePoW(new(ecdsa.PrivateKey), 20)
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	cpI := newboard2
+	cpI.ProofOfWork = ""
+	res, _ := json.Marshal(cpI)
+	result, _, err2 := proofofwork.Verify(string(res), string(newboard2.ProofOfWork), "")
+	if err2 != nil || result != true {
+		t.Errorf("Test failed, the PoW should be over the JSON of the struct. Err: '%v'", err2)
+	}
+}
+
 func TestCreatePoW_RunTwice_Success_WithoutKey(t *testing.T) {
 	var newboard2 api.Board
 	newboard2.Fingerprint = "my random fingerprint2"
@@ -712,3 +745,24 @@ func TestCreateUpdatePoW_RunTwice_ForgotReUpdatePoW_Fail_WithKey(t *testing.T) {
 		}
 	}
 }
+
+// // DeclaredDifficulty tests
+
+func TestDeclaredDifficulty_Success(t *testing.T) {
+	difficulty, err := proofofwork.DeclaredDifficulty(string(weakPoWBoard.ProofOfWork))
+	if err != nil {
+		t.Errorf("Test failed, err: '%s'", err)
+	} else if difficulty != 18 {
+		t.Errorf("Test failed, expected: '%d', got: '%d'", 18, difficulty)
+	}
+}
+
+func TestDeclaredDifficulty_Fail_Malformed(t *testing.T) {
+	_, err := proofofwork.DeclaredDifficulty("MIM1:20::::")
+	errMessage := "PoW had more or less fields than expected."
+	if err == nil {
+		t.Errorf("Did not return error on malformed PoW.")
+	} else if !strings.Contains(err.Error(), errMessage) {
+		t.Errorf("Test returned an error that was different than the expected one. '%s'", err)
+	}
+}
@@ -0,0 +1,225 @@
+// Scheduling > Maintenance
+// This file schedules the heavy jobs, the ones that keep the disk and the CPU busy for minutes, such as generating the caches. They are deferred to the maintenance windows given in the config, so that they don't run during the hours the node is used the most, and they are staggered, so that two of them never load the machine at the same time.
+
+package scheduling
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maintenancePollInterval is how often a deferred job checks whether it can run.
+const maintenancePollInterval = time.Minute
+
+// Window is a parsed maintenance window. It is a cron expression of five fields, minute, hour, day of the month, month and day of the week, and the window is every minute the expression matches. As in cron, if both the day of the month and the day of the week are restricted, a day that matches either is in the window.
+type Window struct {
+	minutes    [60]bool
+	hours      [24]bool
+	days       [32]bool
+	months     [13]bool
+	weekdays   [7]bool
+	anyDay     bool
+	anyWeekday bool
+}
+
+// parseField parses a field of a cron expression into the values it allows. A field is a comma separated list of "*", a value, or a range "a-b", each optionally followed by a step, such as "*/15" or "0-30/10".
+func parseField(field string, min int, max int) ([]bool, error) {
+	allowed := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s < 1 {
+				return nil, errors.New(fmt.Sprintf("The step is not valid. Part: %s", part))
+			}
+			step = s
+			part = part[:i]
+		}
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			v, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("The value is not a number. Part: %s", part))
+			}
+			start, end = v, v
+			if len(bounds) == 2 {
+				v2, err2 := strconv.Atoi(bounds[1])
+				if err2 != nil {
+					return nil, errors.New(fmt.Sprintf("The end of the range is not a number. Part: %s", part))
+				}
+				end = v2
+			} else if step > 1 {
+				// "5/15" is from 5 to the end, as in cron.
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, errors.New(fmt.Sprintf("The value is out of range. Part: %s, Range: %d-%d", part, min, max))
+		}
+		for v := start; v <= end; v += step {
+			allowed[v] = true
+		}
+	}
+	return allowed, nil
+}
+
+// ParseWindow parses a maintenance window from its cron expression.
+func ParseWindow(expr string) (Window, error) {
+	var w Window
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return w, errors.New(fmt.Sprintf("A maintenance window has to have five fields: minute, hour, day of the month, month and day of the week. Window: %s", expr))
+	}
+	minutes, err := parseField(fields[0], 0, 59)
+	if err != nil {
+		return w, errors.New(fmt.Sprintf("The minute field of the maintenance window is not valid. Window: %s, Error: %s", expr, err))
+	}
+	hours, err2 := parseField(fields[1], 0, 23)
+	if err2 != nil {
+		return w, errors.New(fmt.Sprintf("The hour field of the maintenance window is not valid. Window: %s, Error: %s", expr, err2))
+	}
+	days, err3 := parseField(fields[2], 1, 31)
+	if err3 != nil {
+		return w, errors.New(fmt.Sprintf("The day of the month field of the maintenance window is not valid. Window: %s, Error: %s", expr, err3))
+	}
+	months, err4 := parseField(fields[3], 1, 12)
+	if err4 != nil {
+		return w, errors.New(fmt.Sprintf("The month field of the maintenance window is not valid. Window: %s, Error: %s", expr, err4))
+	}
+	// Sunday is both 0 and 7, as in cron.
+	weekdays, err5 := parseField(fields[4], 0, 7)
+	if err5 != nil {
+		return w, errors.New(fmt.Sprintf("The day of the week field of the maintenance window is not valid. Window: %s, Error: %s", expr, err5))
+	}
+	copy(w.minutes[:], minutes)
+	copy(w.hours[:], hours)
+	copy(w.days[:], days)
+	copy(w.months[:], months)
+	copy(w.weekdays[:], weekdays)
+	if weekdays[7] {
+		w.weekdays[0] = true
+	}
+	w.anyDay = fields[2] == "*"
+	w.anyWeekday = fields[4] == "*"
+	return w, nil
+}
+
+// Contains checks whether the given time, in the local time zone, is in the window.
+func (w Window) Contains(t time.Time) bool {
+	t = t.Local()
+	if !w.minutes[t.Minute()] || !w.hours[t.Hour()] || !w.months[int(t.Month())] {
+		return false
+	}
+	dayOk := w.days[t.Day()]
+	weekdayOk := w.weekdays[int(t.Weekday())]
+	if !w.anyDay && !w.anyWeekday {
+		return dayOk || weekdayOk
+	}
+	return dayOk && weekdayOk
+}
+
+// InMaintenanceWindow checks whether the heavy jobs can run at the given time. With no windows given, they can run at any time. The windows are checked when the config is read, so the invalid ones are not expected here; they are skipped.
+func InMaintenanceWindow(t time.Time) bool {
+	if len(globals.MaintenanceWindows) == 0 {
+		return true
+	}
+	for _, expr := range globals.MaintenanceWindows {
+		w, err := ParseWindow(expr)
+		if err != nil {
+			continue
+		}
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+var heavyLock sync.Mutex
+var heavyRunning string // The name of the heavy job that is running, if any.
+var heavyLastEnd time.Time
+
+// claimHeavy marks the job as the one running, if it can start now: it is in a maintenance window, no other heavy job is running, and the last one ended at least MaintenanceStagger ago. If it can't, it gives why.
+func claimHeavy(name string) (bool, string) {
+	heavyLock.Lock()
+	defer heavyLock.Unlock()
+	now := clock.Now()
+	if !InMaintenanceWindow(now) {
+		return false, "outside the maintenance windows"
+	}
+	if len(heavyRunning) > 0 {
+		return false, fmt.Sprintf("waiting for %s to finish", heavyRunning)
+	}
+	if !heavyLastEnd.IsZero() && now.Sub(heavyLastEnd) < globals.MaintenanceStagger {
+		return false, "staggered after the previous heavy job"
+	}
+	heavyRunning = name
+	return true, ""
+}
+
+func releaseHeavy() {
+	heavyLock.Lock()
+	defer heavyLock.Unlock()
+	heavyRunning = ""
+	heavyLastEnd = clock.Now()
+}
+
+// TryHeavy claims the heavy job slot for the job if it can start now, and gives why not if it can't. This is for the job queue, which picks the next job to run itself instead of waiting for one; the caller gives the slot back with ReleaseHeavy when the job ends.
+func TryHeavy(name string) (bool, string) {
+	return claimHeavy(name)
+}
+
+// ReleaseHeavy gives back the slot claimed with TryHeavy.
+func ReleaseHeavy() {
+	releaseHeavy()
+}
+
+// RunHeavy waits until the heavy job can start, and runs it. It returns false without running it if it is asked to stop while it waits.
+func RunHeavy(name string, inputFunction func(), stopChan chan bool) bool {
+	lastReason := ""
+	for {
+		ok, reason := claimHeavy(name)
+		if ok {
+			break
+		}
+		if reason != lastReason {
+			logging.Log(2, fmt.Sprintf("The heavy job %s is deferred: %s.", name, reason))
+			lastReason = reason
+		}
+		select {
+		case <-time.After(maintenancePollInterval):
+		case <-stopChan:
+			return false
+		}
+	}
+	defer releaseHeavy()
+	logging.Log(2, fmt.Sprintf("The heavy job %s is starting.", name))
+	inputFunction()
+	return true
+}
+
+// ScheduleHeavy is Schedule for the heavy jobs. Every run waits for a maintenance window, and for the other heavy jobs to be done, so it can happen later than the interval. The interval is counted from the end of the previous run, as in Schedule.
+func ScheduleHeavy(name string, inputFunction func(), interval time.Duration) chan bool {
+	stopChan := make(chan bool)
+	go func() {
+		for {
+			if !RunHeavy(name, inputFunction, stopChan) {
+				return
+			}
+			select {
+			case <-time.After(interval):
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+	return stopChan
+}
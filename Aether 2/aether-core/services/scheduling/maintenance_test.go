@@ -0,0 +1,129 @@
+package scheduling_test
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/globals"
+	"aether-core/services/scheduling"
+	"os"
+	"testing"
+	"time"
+)
+
+// Infrastructure, setup and teardown
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	globals.SetGlobals()
+}
+
+func teardown() {
+	clock.Reset()
+	globals.MaintenanceWindows = []string{}
+}
+
+// at is a local time on Monday, the 2nd of October 2017.
+func at(hour int, minute int) time.Time {
+	return time.Date(2017, time.October, 2, hour, minute, 0, 0, time.Local)
+}
+
+// Tests
+
+func TestParseWindow_Success(t *testing.T) {
+	w, err := scheduling.ParseWindow("*/15 2-5 * * *")
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	if !w.Contains(at(2, 0)) || !w.Contains(at(5, 45)) {
+		t.Errorf("The window should contain the quarter hours from 2:00 to 5:45.")
+	}
+	if w.Contains(at(2, 10)) || w.Contains(at(6, 0)) || w.Contains(at(1, 45)) {
+		t.Errorf("The window should contain nothing else.")
+	}
+}
+
+func TestParseWindow_Weekdays_Success(t *testing.T) {
+	w, err := scheduling.ParseWindow("* * * * 1-5")
+	if err != nil {
+		t.Fatalf("Test failed, err: '%s'", err)
+	}
+	if !w.Contains(at(12, 0)) {
+		t.Errorf("The window should contain Mondays.")
+	}
+	sunday := at(12, 0).AddDate(0, 0, -1)
+	if w.Contains(sunday) {
+		t.Errorf("The window should not contain Sundays.")
+	}
+	w2, _ := scheduling.ParseWindow("* * * * 7")
+	if !w2.Contains(sunday) {
+		t.Errorf("7 should be Sunday, as in cron.")
+	}
+	// Both days restricted: either of them is enough.
+	w3, _ := scheduling.ParseWindow("* * 15 * 1")
+	if !w3.Contains(at(12, 0)) {
+		t.Errorf("A Monday should be in the window even if it is not the 15th.")
+	}
+}
+
+func TestParseWindow_Fail(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := scheduling.ParseWindow(expr); err == nil {
+			t.Errorf("The window should have been refused. Window: %q", expr)
+		}
+	}
+}
+
+func TestInMaintenanceWindow_Success(t *testing.T) {
+	defer func() { globals.MaintenanceWindows = []string{} }()
+	globals.MaintenanceWindows = []string{}
+	if !scheduling.InMaintenanceWindow(at(12, 0)) {
+		t.Errorf("Without windows, the heavy jobs should be able to run at any time.")
+	}
+	globals.MaintenanceWindows = []string{"* 2-4 * * *", "* 22 * * *"}
+	if !scheduling.InMaintenanceWindow(at(3, 30)) || !scheduling.InMaintenanceWindow(at(22, 59)) {
+		t.Errorf("The times in either window should be in the maintenance windows.")
+	}
+	if scheduling.InMaintenanceWindow(at(12, 0)) {
+		t.Errorf("Noon should be outside the maintenance windows.")
+	}
+}
+
+func TestRunHeavy_Fail_OutsideWindow(t *testing.T) {
+	defer func() { globals.MaintenanceWindows = []string{} }()
+	clock.Set(clock.NewMockClock(at(12, 0)))
+	defer clock.Reset()
+	globals.MaintenanceWindows = []string{"* 2-4 * * *"}
+	stop := make(chan bool, 1)
+	stop <- true
+	ran := false
+	if scheduling.RunHeavy("test", func() { ran = true }, stop) || ran {
+		t.Errorf("The heavy job should not have run outside the maintenance windows.")
+	}
+}
+
+func TestRunHeavy_Staggered(t *testing.T) {
+	mc := clock.NewMockClock(at(3, 0))
+	clock.Set(mc)
+	defer clock.Reset()
+	globals.MaintenanceStagger = 10 * time.Minute
+	ran := false
+	if !scheduling.RunHeavy("first", func() { ran = true }, make(chan bool)) || !ran {
+		t.Fatalf("The first heavy job should have run.")
+	}
+	// The next one can't start until the stagger has passed since the first ended.
+	stop := make(chan bool, 1)
+	stop <- true
+	ran = false
+	if scheduling.RunHeavy("second", func() { ran = true }, stop) || ran {
+		t.Errorf("The second heavy job should have been staggered.")
+	}
+	mc.Advance(11 * time.Minute)
+	if !scheduling.RunHeavy("second", func() { ran = true }, make(chan bool)) || !ran {
+		t.Errorf("The second heavy job should have run after the stagger.")
+	}
+}
@@ -4,7 +4,11 @@
 package scheduling
 
 import (
-	// "fmt"
+	"aether-core/services/globals"
+	"aether-core/services/logging"
+	"fmt"
+	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -23,3 +27,95 @@ func Schedule(inputFunction func(), interval time.Duration) chan bool {
 	}()
 	return stopChan
 }
+
+// Jittered gives how long to wait for the given interval. In privacy mode, it is a random time between half the interval and one and a half times it, so that the node can't be told apart by the rhythm of its requests; otherwise it is the interval.
+func Jittered(interval time.Duration) time.Duration {
+	if !globals.PrivacyMode || interval <= 0 {
+		return interval
+	}
+	return interval/2 + time.Duration(rand.Int63n(int64(interval)+1))
+}
+
+// ScheduleJittered is Schedule, except that the waits are Jittered. Privacy mode is looked at before every wait, so turning it on or off takes effect without a restart.
+func ScheduleJittered(inputFunction func(), interval time.Duration) chan bool {
+	stopChan := make(chan bool)
+	go func() {
+		for {
+			inputFunction()
+			select {
+			case <-time.After(Jittered(interval)):
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+	return stopChan
+}
+
+// AdaptiveBounds are the bounds of an adaptive interval. Base is where it starts and where it returns to when the runs are neither busy nor empty. Busy is how much a run has to return for the interval to be halved.
+type AdaptiveBounds struct {
+	Base time.Duration
+	Min  time.Duration
+	Max  time.Duration
+	Busy int
+}
+
+// AdaptiveInterval is an interval that follows what the runs of a function return: it halves after a busy run, doubles after an empty one, and takes a step back towards the base after one in between.
+type AdaptiveInterval struct {
+	lock    sync.Mutex
+	current time.Duration
+}
+
+// Next gives the interval after a run that returned the given amount. A negative amount means the run could not tell, and leaves the interval as it is. The bounds are read every time, so that they can be changed without a restart.
+func (a *AdaptiveInterval) Next(amount int, b AdaptiveBounds) time.Duration {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	prior := a.current
+	if a.current == 0 {
+		a.current = b.Base
+	}
+	switch {
+	case amount < 0:
+	case amount >= b.Busy && b.Busy > 0:
+		a.current = a.current / 2
+	case amount == 0:
+		a.current = a.current * 2
+	case a.current < b.Base:
+		a.current = a.current * 2
+		if a.current > b.Base {
+			a.current = b.Base
+		}
+	case a.current > b.Base:
+		a.current = a.current / 2
+		if a.current < b.Base {
+			a.current = b.Base
+		}
+	}
+	if a.current > b.Max {
+		a.current = b.Max
+	}
+	if a.current < b.Min {
+		a.current = b.Min
+	}
+	if prior != 0 && prior != a.current {
+		logging.Log(2, fmt.Sprintf("The adaptive interval changed from %s to %s. The last run returned: %d", prior, a.current, amount))
+	}
+	return a.current
+}
+
+// ScheduleAdaptive is ScheduleJittered, except that the interval is an AdaptiveInterval that goes by what the function returns, within the bounds the given function gives.
+func ScheduleAdaptive(inputFunction func() int, bounds func() AdaptiveBounds) chan bool {
+	stopChan := make(chan bool)
+	var interval AdaptiveInterval
+	go func() {
+		for {
+			amount := inputFunction()
+			select {
+			case <-time.After(Jittered(interval.Next(amount, bounds()))):
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+	return stopChan
+}
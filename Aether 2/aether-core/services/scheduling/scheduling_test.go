@@ -0,0 +1,70 @@
+package scheduling_test
+
+import (
+	"aether-core/services/globals"
+	"aether-core/services/scheduling"
+	"testing"
+	"time"
+)
+
+func TestJittered_Success(t *testing.T) {
+	if d := scheduling.Jittered(time.Minute); d != time.Minute {
+		t.Errorf("Outside privacy mode, the wait should be the interval. Wait: %s", d)
+	}
+	globals.PrivacyMode = true
+	defer func() { globals.PrivacyMode = false }()
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		d := scheduling.Jittered(time.Minute)
+		if d < 30*time.Second || d > 90*time.Second {
+			t.Fatalf("In privacy mode, the wait should be between half and one and a half times the interval. Wait: %s", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("In privacy mode, the waits should be random.")
+	}
+}
+
+func TestAdaptiveInterval_Success(t *testing.T) {
+	b := scheduling.AdaptiveBounds{Base: time.Minute, Min: 15 * time.Second, Max: 8 * time.Minute, Busy: 100}
+	var a scheduling.AdaptiveInterval
+	if d := a.Next(150, b); d != 30*time.Second {
+		t.Errorf("A busy run should halve the interval. Interval: %s", d)
+	}
+	if d := a.Next(10, b); d != time.Minute {
+		t.Errorf("A run in between should take the interval back towards the base. Interval: %s", d)
+	}
+	for _, want := range []time.Duration{2 * time.Minute, 4 * time.Minute, 8 * time.Minute} {
+		if d := a.Next(0, b); d != want {
+			t.Errorf("An empty run should double the interval. Interval: %s, Expected: %s", d, want)
+		}
+	}
+	if d := a.Next(-1, b); d != 8*time.Minute {
+		t.Errorf("A run that could not tell should leave the interval as it is. Interval: %s", d)
+	}
+	if d := a.Next(10, b); d != 4*time.Minute {
+		t.Errorf("A run in between should take the interval back towards the base. Interval: %s", d)
+	}
+}
+
+func TestAdaptiveInterval_Fail_OutOfBounds(t *testing.T) {
+	b := scheduling.AdaptiveBounds{Base: time.Minute, Min: 15 * time.Second, Max: 4 * time.Minute, Busy: 100}
+	var a scheduling.AdaptiveInterval
+	for i := 0; i < 10; i++ {
+		a.Next(0, b)
+	}
+	if d := a.Next(0, b); d != b.Max {
+		t.Errorf("The interval should not go over the maximum. Interval: %s", d)
+	}
+	for i := 0; i < 10; i++ {
+		a.Next(1000, b)
+	}
+	if d := a.Next(1000, b); d != b.Min {
+		t.Errorf("The interval should not go under the minimum. Interval: %s", d)
+	}
+	b.Min, b.Max = b.Base, b.Base
+	if d := a.Next(1000, b); d != b.Base {
+		t.Errorf("With the bounds at the base, the interval should be fixed. Interval: %s", d)
+	}
+}
@@ -0,0 +1,176 @@
+// Services > SyncProgress
+// This module keeps track of how far the syncs with remotes are, so that the frontend can show a progress bar during the first sync, which can take hours. The unit of progress is a cache page: the dispatcher says which entity type of which remote it is syncing, the fetcher adds the pages of the caches it finds in the indexes as they become known, and counts them off as they arrive.
+
+package syncprogress
+
+import (
+	"aether-core/services/clock"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// finishedKept is how long the progress of a finished sync is still reported. This keeps the numbers of a bootstrap from going back to zero between the syncs with different remotes.
+const finishedKept = time.Hour
+
+// EntityProgress is the progress of the sync of one entity type from one remote.
+type EntityProgress struct {
+	Peer           string `json:"peer"`
+	EntityType     string `json:"entity_type"`
+	TotalPages     int    `json:"total_pages"` // Grows while the sync runs, since the page count of a cache is known only once its first page arrives.
+	CompletedPages int    `json:"completed_pages"`
+	Bytes          int64  `json:"bytes"`
+	Started        int64  `json:"started"`
+	Finished       int64  `json:"finished,omitempty"` // 0 while the sync is running.
+}
+
+// Progress is the progress of all syncs, running and recently finished.
+type Progress struct {
+	Syncing        bool             `json:"syncing"`
+	TotalPages     int              `json:"total_pages"`
+	CompletedPages int              `json:"completed_pages"`
+	Percent        float64          `json:"percent"`
+	Bytes          int64            `json:"bytes"`
+	BytesPerSecond float64          `json:"bytes_per_second"`
+	EtaSeconds     int64            `json:"eta_seconds"` // -1 if it can't be estimated yet.
+	Entities       []EntityProgress `json:"entities"`
+}
+
+var lock sync.Mutex
+var entries = make(map[string]*EntityProgress)
+
+// PeerKey is how a remote is named in the progress.
+func PeerKey(host string, port uint16) string {
+	return fmt.Sprint(host, ":", port)
+}
+
+func entryKey(peer string, entityType string) string {
+	return fmt.Sprint(peer, "|", entityType)
+}
+
+// Begin starts tracking the sync of an entity type from a remote. The progress of an earlier sync of the same is replaced.
+func Begin(peer string, entityType string) {
+	lock.Lock()
+	defer lock.Unlock()
+	entries[entryKey(peer, entityType)] = &EntityProgress{Peer: peer, EntityType: entityType, Started: clock.Unix()}
+}
+
+// End marks the sync of an entity type from a remote finished. The pages that were planned but didn't arrive are no longer waited for.
+func End(peer string, entityType string) {
+	lock.Lock()
+	defer lock.Unlock()
+	e, ok := entries[entryKey(peer, entityType)]
+	if !ok || e.Finished > 0 {
+		return
+	}
+	e.Finished = clock.Unix()
+	e.TotalPages = e.CompletedPages
+}
+
+// EndAll marks every running sync from a remote finished. This is for when a sync stops midway.
+func EndAll(peer string) {
+	lock.Lock()
+	defer lock.Unlock()
+	now := clock.Unix()
+	for _, e := range entries {
+		if e.Peer == peer && e.Finished == 0 {
+			e.Finished = now
+			e.TotalPages = e.CompletedPages
+		}
+	}
+}
+
+// running gives the entry of a sync that is running. Fetches that are not part of a tracked sync have none, and they are not counted.
+func running(peer string, entityType string) (*EntityProgress, bool) {
+	e, ok := entries[entryKey(peer, entityType)]
+	if !ok || e.Finished > 0 {
+		return nil, false
+	}
+	return e, true
+}
+
+// Plan adds pages that are expected to arrive.
+func Plan(peer string, entityType string, pages int) {
+	lock.Lock()
+	defer lock.Unlock()
+	if e, ok := running(peer, entityType); ok && pages > 0 {
+		e.TotalPages += pages
+	}
+}
+
+// PagesDone counts pages that arrived.
+func PagesDone(peer string, entityType string, pages int) {
+	lock.Lock()
+	defer lock.Unlock()
+	if e, ok := running(peer, entityType); ok {
+		e.CompletedPages += pages
+		// Pages beyond the plan, such as those of a cache that grew, are planned as they arrive.
+		if e.CompletedPages > e.TotalPages {
+			e.TotalPages = e.CompletedPages
+		}
+	}
+}
+
+// AddBytes counts the bytes that arrived.
+func AddBytes(peer string, entityType string, bytes int) {
+	lock.Lock()
+	defer lock.Unlock()
+	if e, ok := running(peer, entityType); ok {
+		e.Bytes += int64(bytes)
+	}
+}
+
+// Report gives the progress of the running syncs and of the ones that finished recently. The estimate of the time left is based on the pace of the running syncs so far.
+func Report() Progress {
+	lock.Lock()
+	defer lock.Unlock()
+	now := clock.Unix()
+	p := Progress{EtaSeconds: -1, Entities: []EntityProgress{}}
+	var earliest int64
+	runningTotal, runningCompleted := 0, 0
+	var runningBytes int64
+	for k, e := range entries {
+		if e.Finished > 0 && now-e.Finished > int64(finishedKept/time.Second) {
+			delete(entries, k)
+			continue
+		}
+		p.Entities = append(p.Entities, *e)
+		p.TotalPages += e.TotalPages
+		p.CompletedPages += e.CompletedPages
+		p.Bytes += e.Bytes
+		if e.Finished == 0 {
+			p.Syncing = true
+			runningTotal += e.TotalPages
+			runningCompleted += e.CompletedPages
+			runningBytes += e.Bytes
+			if earliest == 0 || e.Started < earliest {
+				earliest = e.Started
+			}
+		}
+	}
+	sort.Slice(p.Entities, func(i, j int) bool {
+		if p.Entities[i].Peer != p.Entities[j].Peer {
+			return p.Entities[i].Peer < p.Entities[j].Peer
+		}
+		return p.Entities[i].EntityType < p.Entities[j].EntityType
+	})
+	if p.TotalPages > 0 {
+		p.Percent = float64(p.CompletedPages) * 100 / float64(p.TotalPages)
+	} else if len(p.Entities) > 0 && !p.Syncing {
+		p.Percent = 100
+	}
+	if !p.Syncing {
+		p.EtaSeconds = 0
+		return p
+	}
+	elapsed := now - earliest
+	if elapsed > 0 {
+		p.BytesPerSecond = float64(runningBytes) / float64(elapsed)
+		if runningCompleted > 0 {
+			perPage := float64(elapsed) / float64(runningCompleted)
+			p.EtaSeconds = int64(perPage * float64(runningTotal-runningCompleted))
+		}
+	}
+	return p
+}
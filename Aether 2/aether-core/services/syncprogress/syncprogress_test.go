@@ -0,0 +1,82 @@
+package syncprogress_test
+
+import (
+	"aether-core/services/clock"
+	"aether-core/services/syncprogress"
+	"os"
+	"testing"
+	"time"
+)
+
+// Infrastructure, setup and teardown
+
+var mc *clock.MockClock
+
+func TestMain(m *testing.M) {
+	setup()
+	exitVal := m.Run()
+	teardown()
+	os.Exit(exitVal)
+}
+
+func setup() {
+	mc = clock.NewMockClock(time.Unix(1500000000, 0))
+	clock.Set(mc)
+}
+
+func teardown() {
+	clock.Reset()
+}
+
+// Tests
+
+func TestReport_Success(t *testing.T) {
+	peer := syncprogress.PeerKey("127.0.0.1", 8001)
+	syncprogress.Begin(peer, "boards")
+	// Two caches in the index, one page each until their first pages arrive.
+	syncprogress.Plan(peer, "boards", 2)
+	syncprogress.Plan(peer, "boards", 3) // The first cache has 4 pages.
+	syncprogress.PagesDone(peer, "boards", 1)
+	syncprogress.AddBytes(peer, "boards", 1000)
+	mc.Advance(10 * time.Second)
+	p := syncprogress.Report()
+	if !p.Syncing || p.TotalPages != 5 || p.CompletedPages != 1 || p.Percent != 20 {
+		t.Errorf("Unexpected progress. Progress: %#v", p)
+	}
+	if p.EtaSeconds != 40 || p.BytesPerSecond != 100 {
+		t.Errorf("Expected 4 more pages at 10 seconds each, and 100 bytes per second. ETA: %d, Bytes per second: %f", p.EtaSeconds, p.BytesPerSecond)
+	}
+	syncprogress.PagesDone(peer, "boards", 4)
+	syncprogress.End(peer, "boards")
+	p2 := syncprogress.Report()
+	if p2.Syncing || p2.Percent != 100 || p2.EtaSeconds != 0 || len(p2.Entities) != 1 {
+		t.Errorf("The finished sync should be reported as complete. Progress: %#v", p2)
+	}
+	mc.Advance(2 * time.Hour)
+	if p3 := syncprogress.Report(); len(p3.Entities) != 0 {
+		t.Errorf("The finished sync should be forgotten after an hour. Progress: %#v", p3)
+	}
+}
+
+func TestReport_Fail_Untracked(t *testing.T) {
+	peer := syncprogress.PeerKey("127.0.0.1", 8002)
+	// Fetches outside of a tracked sync are not counted.
+	syncprogress.Plan(peer, "posts", 10)
+	syncprogress.PagesDone(peer, "posts", 1)
+	syncprogress.AddBytes(peer, "posts", 1000)
+	if p := syncprogress.Report(); len(p.Entities) != 0 || p.EtaSeconds != 0 {
+		t.Errorf("Nothing should be tracked. Progress: %#v", p)
+	}
+	syncprogress.Begin(peer, "posts")
+	syncprogress.Plan(peer, "posts", 10)
+	if p := syncprogress.Report(); p.EtaSeconds != -1 {
+		t.Errorf("The time left can't be estimated before any page arrives. ETA: %d", p.EtaSeconds)
+	}
+	// A sync that stops midway is no longer waited for.
+	syncprogress.EndAll(peer)
+	if p := syncprogress.Report(); p.Syncing || p.TotalPages != 0 {
+		t.Errorf("The stopped sync should not be running, nor wait for its pages. Progress: %#v", p)
+	}
+	mc.Advance(2 * time.Hour)
+	syncprogress.Report()
+}
@@ -14,6 +14,10 @@ import (
 // var err error
 
 func MapPort() {
+	if globals.ServingMode == "light" {
+		// A light node isn't asking to be reached, so the router is left alone.
+		return
+	}
 	router, err := extUpnp.Discover()
 	if err != nil {
 		// Either could not be found, or connected to the internet directly.
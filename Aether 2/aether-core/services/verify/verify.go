@@ -6,7 +6,9 @@ package verify
 import (
 	"aether-core/io/api"
 	"aether-core/io/persistence"
+	"aether-core/services/globals"
 	"aether-core/services/logging"
+	"aether-core/services/proofofwork"
 	"errors"
 	"fmt"
 )
@@ -112,7 +114,7 @@ func VerifyResponse(resp api.Response) api.Response {
 		if isVerified {
 			cleanedResp.Boards = append(cleanedResp.Boards, entity)
 		} else {
-			logging.Log(1, fmt.Sprintf("Verification failed for this entity. Entity: %#v, Error: %s", entity, err))
+			logging.LogSampled("verify", "verification-failed", 1, fmt.Sprintf("Verification failed for this entity. Entity: %#v, Error: %s", entity, err))
 		}
 	}
 
@@ -121,7 +123,7 @@ func VerifyResponse(resp api.Response) api.Response {
 		if isVerified {
 			cleanedResp.Threads = append(cleanedResp.Threads, entity)
 		} else {
-			logging.Log(1, fmt.Sprintf("Verification failed for this entity. Entity: %#v, Error: %s", entity, err))
+			logging.LogSampled("verify", "verification-failed", 1, fmt.Sprintf("Verification failed for this entity. Entity: %#v, Error: %s", entity, err))
 		}
 	}
 
@@ -130,7 +132,7 @@ func VerifyResponse(resp api.Response) api.Response {
 		if isVerified {
 			cleanedResp.Posts = append(cleanedResp.Posts, entity)
 		} else {
-			logging.Log(1, fmt.Sprintf("Verification failed for this entity. Entity: %#v, Error: %s", entity, err))
+			logging.LogSampled("verify", "verification-failed", 1, fmt.Sprintf("Verification failed for this entity. Entity: %#v, Error: %s", entity, err))
 		}
 	}
 
@@ -139,7 +141,7 @@ func VerifyResponse(resp api.Response) api.Response {
 		if isVerified {
 			cleanedResp.Votes = append(cleanedResp.Votes, entity)
 		} else {
-			logging.Log(1, fmt.Sprintf("Verification failed for this entity. Entity: %#v, Error: %s", entity, err))
+			logging.LogSampled("verify", "verification-failed", 1, fmt.Sprintf("Verification failed for this entity. Entity: %#v, Error: %s", entity, err))
 		}
 	}
 
@@ -151,7 +153,7 @@ func VerifyResponse(resp api.Response) api.Response {
 		if isVerified {
 			cleanedResp.Keys = append(cleanedResp.Keys, entity)
 		} else {
-			logging.Log(1, fmt.Sprintf("Verification failed for this entity. Entity: %#v, Error: %s", entity, err))
+			logging.LogSampled("verify", "verification-failed", 1, fmt.Sprintf("Verification failed for this entity. Entity: %#v, Error: %s", entity, err))
 		}
 	}
 
@@ -160,7 +162,262 @@ func VerifyResponse(resp api.Response) api.Response {
 		if isVerified {
 			cleanedResp.Truststates = append(cleanedResp.Truststates, entity)
 		} else {
-			logging.Log(1, fmt.Sprintf("Verification failed for this entity. Entity: %#v, Error: %s", entity, err))
+			logging.LogSampled("verify", "verification-failed", 1, fmt.Sprintf("Verification failed for this entity. Entity: %#v, Error: %s", entity, err))
+		}
+	}
+
+	for _, entity := range resp.Tombstones {
+		isVerified, err := verifyProvable(resp, &entity)
+		if isVerified {
+			isVerified, err = verifyTombstoneOwnership(resp, entity)
+		}
+		if isVerified {
+			cleanedResp.Tombstones = append(cleanedResp.Tombstones, entity)
+		} else {
+			logging.LogSampled("verify", "verification-failed", 1, fmt.Sprintf("Verification failed for this entity. Entity: %#v, Error: %s", entity, err))
+		}
+	}
+	return cleanedResp
+}
+
+// verifyTombstoneOwnership checks that the tombstone is owned by the owner of the entity it targets. If the target is not available neither in the response nor in the database, the tombstone is accepted, since the target can arrive later. In that case, the persistence layer applies the same ownership check when the target arrives.
+func verifyTombstoneOwnership(resp api.Response, tomb api.Tombstone) (bool, error) {
+	if tomb.Owner == "" {
+		// Anonymous entities cannot be deleted, since there is no way to prove ownership.
+		return false, errors.New(fmt.Sprintf(
+			"Tombstones cannot be anonymous. Tombstone: %#v\n", tomb))
+	}
+	var targetOwner api.Fingerprint
+	var found bool
+	switch tomb.TargetType {
+	case "threads":
+		for _, thread := range resp.Threads {
+			if thread.Fingerprint == tomb.Target {
+				targetOwner = thread.Owner
+				found = true
+				break
+			}
+		}
+		if !found {
+			dbResp, err := persistence.ReadThreads([]api.Fingerprint{tomb.Target}, 0, 0)
+			if err != nil {
+				return false, err
+			}
+			if len(dbResp) > 0 {
+				targetOwner = dbResp[0].Owner
+				found = true
+			}
+		}
+	case "posts":
+		for _, post := range resp.Posts {
+			if post.Fingerprint == tomb.Target {
+				targetOwner = post.Owner
+				found = true
+				break
+			}
+		}
+		if !found {
+			dbResp, err := persistence.ReadPosts([]api.Fingerprint{tomb.Target}, 0, 0)
+			if err != nil {
+				return false, err
+			}
+			if len(dbResp) > 0 {
+				targetOwner = dbResp[0].Owner
+				found = true
+			}
+		}
+	default:
+		return false, errors.New(fmt.Sprintf(
+			"This tombstone has an invalid target type. Target type: %s, Tombstone: %#v\n", tomb.TargetType, tomb))
+	}
+	if !found {
+		return true, nil
+	}
+	if targetOwner != tomb.Owner {
+		return false, errors.New(fmt.Sprintf(
+			"This tombstone is not owned by the owner of its target. Tombstone: %#v, Target owner: %s\n", tomb, targetOwner))
+	}
+	return true, nil
+}
+
+// judgeSubmitted decides whether a submitted entity is accepted. The entities that are over the validation policy or fall short of the PoW policy are not verified at all, since verification is the expensive part.
+func judgeSubmitted(resp api.Response, entity api.Provable, powOk map[api.Fingerprint]bool) api.EntityStatus {
+	status := api.EntityStatus{Fingerprint: entity.GetFingerprint(), Status: api.EntityRejected}
+	if api.CheckPolicy(entity) != nil || (globals.TextInboundPolicy == "drop" && api.CheckNormalized(entity) != nil) {
+		status.Reason = api.RejectedOverLimits
+		return status
+	}
+	if !powOk[entity.GetFingerprint()] {
+		status.Reason = api.RejectedInsufficientPoW
+		return status
+	}
+	isVerified, err := verifyProvable(resp, entity)
+	if !isVerified {
+		logging.LogSampled("verify", "submission-failed", 2, fmt.Sprintf("Verification failed for this submitted entity. Fingerprint: %s, Error: %s", entity.GetFingerprint(), err))
+		status.Reason = api.RejectedUnverifiable
+		return status
+	}
+	status.Status = api.EntityAccepted
+	return status
+}
+
+// VerifySubmission verifies the entities a remote submitted to this node. It returns the ones that can be committed, and the status of every one of them, so that the submitter knows what not to send again.
+func VerifySubmission(resp api.Response) (api.Response, []api.EntityStatus) {
+	var accepted api.Response
+	var statuses []api.EntityStatus
+	passing := FilterByMinPoW(resp)
+	powOk := make(map[api.Fingerprint]bool)
+	for _, e := range passing.Boards {
+		powOk[e.Fingerprint] = true
+	}
+	for _, e := range passing.Threads {
+		powOk[e.Fingerprint] = true
+	}
+	for _, e := range passing.Posts {
+		powOk[e.Fingerprint] = true
+	}
+	for _, e := range passing.Votes {
+		powOk[e.Fingerprint] = true
+	}
+	for _, e := range passing.Keys {
+		powOk[e.Fingerprint] = true
+	}
+	for _, e := range passing.Truststates {
+		powOk[e.Fingerprint] = true
+	}
+	for _, e := range passing.Tombstones {
+		powOk[e.Fingerprint] = true
+	}
+	for _, entity := range resp.Boards {
+		status := judgeSubmitted(resp, &entity, powOk)
+		if status.Status == api.EntityAccepted {
+			accepted.Boards = append(accepted.Boards, entity)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, entity := range resp.Threads {
+		status := judgeSubmitted(resp, &entity, powOk)
+		if status.Status == api.EntityAccepted {
+			accepted.Threads = append(accepted.Threads, entity)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, entity := range resp.Posts {
+		status := judgeSubmitted(resp, &entity, powOk)
+		if status.Status == api.EntityAccepted {
+			accepted.Posts = append(accepted.Posts, entity)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, entity := range resp.Votes {
+		status := judgeSubmitted(resp, &entity, powOk)
+		if status.Status == api.EntityAccepted {
+			accepted.Votes = append(accepted.Votes, entity)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, entity := range resp.Keys {
+		status := judgeSubmitted(resp, &entity, powOk)
+		if status.Status == api.EntityAccepted {
+			accepted.Keys = append(accepted.Keys, entity)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, entity := range resp.Truststates {
+		status := judgeSubmitted(resp, &entity, powOk)
+		if status.Status == api.EntityAccepted {
+			accepted.Truststates = append(accepted.Truststates, entity)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, entity := range resp.Tombstones {
+		status := judgeSubmitted(resp, &entity, powOk)
+		if status.Status == api.EntityAccepted {
+			owned, err := verifyTombstoneOwnership(resp, entity)
+			if !owned {
+				logging.LogSampled("verify", "submission-failed", 2, fmt.Sprintf("This submitted tombstone is not owned by the owner of its target. Fingerprint: %s, Error: %s", entity.Fingerprint, err))
+				status.Status = api.EntityRejected
+				status.Reason = api.RejectedNotOwnerOfTarget
+			}
+		}
+		if status.Status == api.EntityAccepted {
+			accepted.Tombstones = append(accepted.Tombstones, entity)
+		}
+		statuses = append(statuses, status)
+	}
+	return accepted, statuses
+}
+
+// meetsMinPoW checks whether the declared strength of a proof of work satisfies the given minimum. This does not check whether the declared strength is real, that is the job of Verify.
+func meetsMinPoW(pow api.ProofOfWork, minStrength int64) bool {
+	difficulty, err := proofofwork.DeclaredDifficulty(string(pow))
+	if err != nil {
+		return false
+	}
+	return difficulty >= minStrength
+}
+
+// FilterByMinPoW removes the entities whose proof of work is weaker than the local node's policy. This is applied both on ingest, and when deciding what to serve to the remotes, so that the policy of this node holds in both directions.
+func FilterByMinPoW(resp api.Response) api.Response {
+	cleanedResp := resp
+	cleanedResp.Boards = nil
+	cleanedResp.Threads = nil
+	cleanedResp.Posts = nil
+	cleanedResp.Votes = nil
+	cleanedResp.Keys = nil
+	cleanedResp.Truststates = nil
+	cleanedResp.Tombstones = nil
+	for _, entity := range resp.Boards {
+		if meetsMinPoW(entity.ProofOfWork, globals.MinPoWStrengths.Board) &&
+			(len(entity.UpdateProofOfWork) == 0 || meetsMinPoW(entity.UpdateProofOfWork, globals.MinPoWStrengths.BoardUpdate)) {
+			cleanedResp.Boards = append(cleanedResp.Boards, entity)
+		} else {
+			logging.LogSampled("verify", "pow-policy", 2, fmt.Sprintf("This entity does not satisfy the minimum PoW policy of this node. Entity: %#v", entity))
+		}
+	}
+	for _, entity := range resp.Threads {
+		if meetsMinPoW(entity.ProofOfWork, globals.MinPoWStrengths.Thread) {
+			cleanedResp.Threads = append(cleanedResp.Threads, entity)
+		} else {
+			logging.LogSampled("verify", "pow-policy", 2, fmt.Sprintf("This entity does not satisfy the minimum PoW policy of this node. Entity: %#v", entity))
+		}
+	}
+	for _, entity := range resp.Posts {
+		if meetsMinPoW(entity.ProofOfWork, globals.MinPoWStrengths.Post) {
+			cleanedResp.Posts = append(cleanedResp.Posts, entity)
+		} else {
+			logging.LogSampled("verify", "pow-policy", 2, fmt.Sprintf("This entity does not satisfy the minimum PoW policy of this node. Entity: %#v", entity))
+		}
+	}
+	for _, entity := range resp.Votes {
+		if meetsMinPoW(entity.ProofOfWork, globals.MinPoWStrengths.Vote) &&
+			(len(entity.UpdateProofOfWork) == 0 || meetsMinPoW(entity.UpdateProofOfWork, globals.MinPoWStrengths.VoteUpdate)) {
+			cleanedResp.Votes = append(cleanedResp.Votes, entity)
+		} else {
+			logging.LogSampled("verify", "pow-policy", 2, fmt.Sprintf("This entity does not satisfy the minimum PoW policy of this node. Entity: %#v", entity))
+		}
+	}
+	for _, entity := range resp.Keys {
+		if meetsMinPoW(entity.ProofOfWork, globals.MinPoWStrengths.Key) &&
+			(len(entity.UpdateProofOfWork) == 0 || meetsMinPoW(entity.UpdateProofOfWork, globals.MinPoWStrengths.KeyUpdate)) {
+			cleanedResp.Keys = append(cleanedResp.Keys, entity)
+		} else {
+			logging.LogSampled("verify", "pow-policy", 2, fmt.Sprintf("This entity does not satisfy the minimum PoW policy of this node. Entity: %#v", entity))
+		}
+	}
+	for _, entity := range resp.Truststates {
+		if meetsMinPoW(entity.ProofOfWork, globals.MinPoWStrengths.Truststate) &&
+			(len(entity.UpdateProofOfWork) == 0 || meetsMinPoW(entity.UpdateProofOfWork, globals.MinPoWStrengths.TruststateUpdate)) {
+			cleanedResp.Truststates = append(cleanedResp.Truststates, entity)
+		} else {
+			logging.LogSampled("verify", "pow-policy", 2, fmt.Sprintf("This entity does not satisfy the minimum PoW policy of this node. Entity: %#v", entity))
+		}
+	}
+	for _, entity := range resp.Tombstones {
+		if meetsMinPoW(entity.ProofOfWork, globals.MinPoWStrengths.Tombstone) {
+			cleanedResp.Tombstones = append(cleanedResp.Tombstones, entity)
+		} else {
+			logging.LogSampled("verify", "pow-policy", 2, fmt.Sprintf("This entity does not satisfy the minimum PoW policy of this node. Entity: %#v", entity))
 		}
 	}
 	return cleanedResp
@@ -285,3 +285,107 @@ func TestVerify_UpdatedItemFailure_Signature(t *testing.T) {
 		t.Errorf("Test returned an error that did not include the expected one. Error: '%s', Expected error: '%s'", err2, errMessage)
 	}
 }
+
+func TestVerifySubmission_Fail_InsufficientPoW(t *testing.T) {
+	var resp api.Response
+	// A post without any proof of work is below any policy.
+	resp.Posts = []api.Post{api.Post{ProvableFieldSet: api.ProvableFieldSet{Fingerprint: "my post fingerprint"}, Body: "my post body"}}
+	accepted, statuses := verify.VerifySubmission(resp)
+	if len(accepted.Posts) != 0 {
+		t.Errorf("Expected the post to be rejected, but it was accepted.")
+	} else if len(statuses) != 1 {
+		t.Errorf("Expected one status, got %d.", len(statuses))
+	} else if statuses[0].Status != api.EntityRejected || statuses[0].Reason != api.RejectedInsufficientPoW {
+		t.Errorf("Unexpected status. Status: %#v", statuses[0])
+	}
+}
+
+func TestVerifySubmission_Fail_OverPolicy(t *testing.T) {
+	defer func(v map[string]int) { globals.ValidationFieldMaxima = v }(globals.ValidationFieldMaxima)
+	globals.ValidationFieldMaxima = map[string]int{"posts.body": 4}
+	var resp api.Response
+	resp.Posts = []api.Post{api.Post{ProvableFieldSet: api.ProvableFieldSet{Fingerprint: "my post fingerprint"}, Body: "my post body"}}
+	_, statuses := verify.VerifySubmission(resp)
+	if len(statuses) != 1 || statuses[0].Reason != api.RejectedOverLimits {
+		t.Errorf("Expected the post over the validation policy to be rejected as over the limits. Statuses: %#v", statuses)
+	}
+}
+
+func TestFilterByPolicy_Success(t *testing.T) {
+	defer func(v map[string]int) { globals.ValidationFieldMaxima = v }(globals.ValidationFieldMaxima)
+	globals.ValidationFieldMaxima = map[string]int{}
+	var resp api.Response
+	// 255 characters of two bytes each are still within the maximum of the name.
+	resp.Boards = []api.Board{api.Board{Name: strings.Repeat("ü", 255)}}
+	resp.Posts = []api.Post{api.Post{Body: "short body"}}
+	resp.Votes = []api.Vote{api.Vote{}}
+	cleaned := api.FilterByPolicy(resp)
+	if len(cleaned.Boards) != 1 || len(cleaned.Posts) != 1 || len(cleaned.Votes) != 1 {
+		t.Errorf("Entities within the validation policy were dropped. Response: %#v", cleaned)
+	}
+	globals.ValidationFieldMaxima = map[string]int{"boards.name": 0}
+	resp.Boards[0].Name = strings.Repeat("a", 1000)
+	if cleaned := api.FilterByPolicy(resp); len(cleaned.Boards) != 1 {
+		t.Errorf("A field whose maximum is turned off was checked.")
+	}
+}
+
+func TestFilterByPolicy_Fail(t *testing.T) {
+	defer func(v map[string]int) { globals.ValidationFieldMaxima = v }(globals.ValidationFieldMaxima)
+	globals.ValidationFieldMaxima = map[string]int{"posts.body": 10}
+	var resp api.Response
+	resp.Boards = []api.Board{api.Board{Name: strings.Repeat("a", 256)}}
+	resp.Posts = []api.Post{
+		api.Post{ProvableFieldSet: api.ProvableFieldSet{Fingerprint: "kept"}, Body: "0123456789"},
+		api.Post{ProvableFieldSet: api.ProvableFieldSet{Fingerprint: "dropped"}, Body: "01234567890"},
+	}
+	cleaned := api.FilterByPolicy(resp)
+	if len(cleaned.Boards) != 0 {
+		t.Errorf("A board name over the maximum of the protocol was kept.")
+	}
+	if len(cleaned.Posts) != 1 || cleaned.Posts[0].Fingerprint != "kept" {
+		t.Errorf("The override of the maximum of the post body was not applied. Posts: %#v", cleaned.Posts)
+	}
+}
+
+func TestFilterByTextPolicy_Fail_NotNormalized(t *testing.T) {
+	defer func(v string) { globals.TextInboundPolicy = v }(globals.TextInboundPolicy)
+	globals.TextInboundPolicy = "drop"
+	var resp api.Response
+	resp.Posts = []api.Post{
+		api.Post{ProvableFieldSet: api.ProvableFieldSet{Fingerprint: "kept"}, Body: "caf\u00e9\nline two"},
+		api.Post{ProvableFieldSet: api.ProvableFieldSet{Fingerprint: "marks"}, Body: "\u05e9\u05dc\u05d5\u05dd\u200f (1)\u200e"},
+		api.Post{ProvableFieldSet: api.ProvableFieldSet{Fingerprint: "decomposed"}, Body: "cafe\u0301"},
+		api.Post{ProvableFieldSet: api.ProvableFieldSet{Fingerprint: "override"}, Body: "\u202etxt.exe"},
+		api.Post{ProvableFieldSet: api.ProvableFieldSet{Fingerprint: "isolate"}, Body: "\u2067abc\u2069"},
+	}
+	resp.Threads = []api.Thread{api.Thread{Name: "bell\a"}}
+	cleaned := api.FilterByTextPolicy(resp)
+	if len(cleaned.Posts) != 2 || cleaned.Posts[0].Fingerprint != "kept" || cleaned.Posts[1].Fingerprint != "marks" || len(cleaned.Threads) != 0 {
+		t.Errorf("Only the normalized entities should have been kept. Response: %#v", cleaned)
+	}
+	for _, policy := range []string{"flag", "accept"} {
+		globals.TextInboundPolicy = policy
+		if cleaned := api.FilterByTextPolicy(resp); len(cleaned.Posts) != 5 || len(cleaned.Threads) != 1 {
+			t.Errorf("Every entity should have been kept with the inbound text policy at %s.", policy)
+		}
+	}
+}
+
+func TestFilterByPolicy_Success_TextPolicyNotApplied(t *testing.T) {
+	defer func(v string) { globals.TextInboundPolicy = v }(globals.TextInboundPolicy)
+	globals.TextInboundPolicy = "drop"
+	var resp api.Response
+	resp.Posts = []api.Post{api.Post{ProvableFieldSet: api.ProvableFieldSet{Fingerprint: "override"}, Body: "\u202etxt.exe"}}
+	// The entities served are filtered by the validation policy alone, since the text policy was applied when they were ingested.
+	if cleaned := api.FilterByPolicy(resp); len(cleaned.Posts) != 1 {
+		t.Errorf("The inbound text policy should not have been applied by FilterByPolicy. Response: %#v", cleaned)
+	}
+}
+
+func TestNormalizeText_Success_KeepsDirectionMarks(t *testing.T) {
+	in := "\u05e9\u200f\u061c\u200e\u202a\u202b\u202c\u202d\u202e\u2066\u2067\u2068\u2069x"
+	if out := api.NormalizeText(in); out != "\u05e9\u200f\u061c\u200ex" {
+		t.Errorf("Only the embeddings, overrides and isolates should have been removed. Output: %+q", out)
+	}
+}